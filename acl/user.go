@@ -0,0 +1,236 @@
+// Package acl holds the users of the server and enforces their limits
+// A connection is the default user until it authenticates as another one with AUTH username
+// password, the default user authenticates with requirepass. The other users are configured with
+// the users option, each with its password and its own limits
+package acl
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultUserName is the name of the user of connections which did not authenticate
+const DefaultUserName = "default"
+
+// Limits are the optional limits of a user, 0 means no limit
+type Limits struct {
+	// MaxOpsPerSecond is the max number of commands per second of all connections of the user
+	MaxOpsPerSecond int
+	// MaxConnections is the max number of concurrent connections of the user
+	MaxConnections int
+	// MaxWritesPerSecond is the max number of commands modifying the keyspace per second
+	MaxWritesPerSecond int
+}
+
+// LimitError is returned when a user exceeds one of its limits, it is sent to clients as -LIMIT
+type LimitError struct {
+	User  string
+	Limit string // name of the config option of the limit
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return "LIMIT user '" + e.User + "' exceeded " + e.Limit + " " + strconv.Itoa(e.Max)
+}
+
+// User is a user of the server with the state of its limits
+type User struct {
+	Name     string
+	password string
+	limits   Limits
+
+	mu          sync.Mutex
+	connections int
+	ops         bucket
+	writes      bucket
+}
+
+// NewUser creates a user with the limits
+func NewUser(name string, limits Limits) *User {
+	now := time.Now()
+	return &User{
+		Name:   name,
+		limits: limits,
+		ops:    makeBucket(limits.MaxOpsPerSecond, now),
+		writes: makeBucket(limits.MaxWritesPerSecond, now),
+	}
+}
+
+// ParseUser parses a user of the users option: its name and password separated by a space,
+// followed by its limits maxopspersecond=N, maxconnections=N and maxwritespersecond=N
+func ParseUser(spec string) (*User, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 {
+		return nil, errors.New("user " + strconv.Quote(spec) + ": expected a name and a password")
+	}
+	if fields[0] == DefaultUserName {
+		return nil, errors.New("user " + DefaultUserName + ": its password is requirepass")
+	}
+	var limits Limits
+	for _, field := range fields[2:] {
+		name, value, _ := strings.Cut(field, "=")
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, errors.New("user " + fields[0] + ": invalid limit " + strconv.Quote(field))
+		}
+		switch strings.ToLower(name) {
+		case "maxopspersecond":
+			limits.MaxOpsPerSecond = n
+		case "maxconnections":
+			limits.MaxConnections = n
+		case "maxwritespersecond":
+			limits.MaxWritesPerSecond = n
+		default:
+			return nil, errors.New("user " + fields[0] + ": unknown limit " + strconv.Quote(name))
+		}
+	}
+	user := NewUser(fields[0], limits)
+	user.password = fields[1]
+	return user, nil
+}
+
+// CheckPassword reports whether the password is the one of the user, the default user has none
+func (u *User) CheckPassword(password []byte) bool {
+	return u.password != "" && subtle.ConstantTimeCompare(password, []byte(u.password)) == 1
+}
+
+// Limits returns the limits of the user
+func (u *User) Limits() Limits {
+	return u.limits
+}
+
+// Connect counts a new connection of the user, it fails if the user has MaxConnections connections
+func (u *User) Connect() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.limits.MaxConnections > 0 && u.connections >= u.limits.MaxConnections {
+		return &LimitError{User: u.Name, Limit: "user-max-connections", Max: u.limits.MaxConnections}
+	}
+	u.connections++
+	return nil
+}
+
+// Disconnect counts a closed connection of the user
+func (u *User) Disconnect() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.connections > 0 {
+		u.connections--
+	}
+}
+
+// Connections returns the number of connections of the user
+func (u *User) Connections() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.connections
+}
+
+// Allow consumes a command of the user, write tells whether the command modifies the keyspace
+// A rejected command consumes nothing
+func (u *User) Allow(write bool) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now()
+	u.ops.refill(now)
+	if write {
+		u.writes.refill(now)
+	}
+	if !u.ops.available() {
+		return &LimitError{User: u.Name, Limit: "user-max-ops-per-second", Max: u.limits.MaxOpsPerSecond}
+	}
+	if write && !u.writes.available() {
+		return &LimitError{User: u.Name, Limit: "user-max-writes-per-second", Max: u.limits.MaxWritesPerSecond}
+	}
+	u.ops.take()
+	if write {
+		u.writes.take()
+	}
+	return nil
+}
+
+// bucket is a token bucket refilled with rate tokens per second and holding up to rate tokens,
+// so that a user may burst for one second, rate 0 means no limit
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func makeBucket(rate int, now time.Time) bucket {
+	return bucket{rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b.rate == 0 {
+		return
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+func (b *bucket) available() bool {
+	return b.rate == 0 || b.tokens >= 1
+}
+
+func (b *bucket) take() {
+	if b.rate != 0 {
+		b.tokens--
+	}
+}
+
+var (
+	usersMu sync.RWMutex
+	users   = map[string]*User{DefaultUserName: NewUser(DefaultUserName, Limits{})}
+)
+
+// LoadUsers replaces the users other than the default one with those of the users option
+func LoadUsers(specs []string) error {
+	loaded := make(map[string]*User)
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		user, err := ParseUser(spec)
+		if err != nil {
+			return err
+		}
+		if _, ok := loaded[user.Name]; ok {
+			return errors.New("user " + user.Name + " is defined twice")
+		}
+		loaded[user.Name] = user
+	}
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	loaded[DefaultUserName] = users[DefaultUserName]
+	users = loaded
+	return nil
+}
+
+// SetUser adds a user or replaces the user with the same name, resetting its limits
+func SetUser(user *User) {
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	users[user.Name] = user
+}
+
+// GetUser returns the user with the name
+func GetUser(name string) (*User, bool) {
+	usersMu.RLock()
+	defer usersMu.RUnlock()
+	user, ok := users[name]
+	return user, ok
+}
+
+// DefaultUser returns the default user
+func DefaultUser() *User {
+	user, _ := GetUser(DefaultUserName)
+	return user
+}
@@ -0,0 +1,101 @@
+package acl
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestParseUser tests the users of the users option and the invalid ones
+func TestParseUser(t *testing.T) {
+	user, err := ParseUser("alice secret maxopspersecond=100 maxconnections=2 MaxWritesPerSecond=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "alice" || user.Limits() != (Limits{MaxOpsPerSecond: 100, MaxConnections: 2, MaxWritesPerSecond: 10}) {
+		t.Errorf("Unexpected user %s with the limits %+v", user.Name, user.Limits())
+	}
+	if !user.CheckPassword([]byte("secret")) || user.CheckPassword([]byte("other")) {
+		t.Error("Expected only the password of the user to be accepted")
+	}
+	if DefaultUser().CheckPassword([]byte("")) {
+		t.Error("Expected the default user to have no password of its own")
+	}
+	for _, spec := range []string{"alice", "default secret", "alice secret maxops=1", "alice secret maxconnections=-1"} {
+		if _, err := ParseUser(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+// TestLoadUsers tests that the users are replaced by those loaded, keeping the default user
+func TestLoadUsers(t *testing.T) {
+	defer func() {
+		_ = LoadUsers(nil)
+	}()
+	defaultUser := DefaultUser()
+	if err := LoadUsers([]string{"alice a", " bob b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadUsers([]string{"carol c"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := GetUser("alice"); ok {
+		t.Error("Expected alice to be removed")
+	}
+	if _, ok := GetUser("carol"); !ok || DefaultUser() != defaultUser {
+		t.Error("Expected carol and the default user")
+	}
+	if err := LoadUsers([]string{"dave d", "dave e"}); err == nil {
+		t.Error("Expected an error for a user defined twice")
+	}
+	if _, ok := GetUser("carol"); !ok {
+		t.Error("Expected the users to be kept after an error")
+	}
+}
+
+// TestUserLimits tests that each user has its own limits, and that a rejected command consumes none
+func TestUserLimits(t *testing.T) {
+	alice := NewUser("alice", Limits{MaxOpsPerSecond: 2, MaxConnections: 1, MaxWritesPerSecond: 1})
+	bob := NewUser("bob", Limits{MaxConnections: 1})
+	var limitErr *LimitError
+
+	if err := alice.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Connect(); !errors.As(err, &limitErr) || limitErr.Limit != "user-max-connections" {
+		t.Errorf("Expected the connection limit of alice, got %v", err)
+	}
+	if err := bob.Connect(); err != nil {
+		t.Errorf("Expected bob to have its own connection limit, got %v", err)
+	}
+	alice.Disconnect()
+	if err := alice.Connect(); err != nil || alice.Connections() != 1 {
+		t.Errorf("Expected the closed connection to be released, got %v", err)
+	}
+
+	if err := alice.Allow(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Allow(true); !errors.As(err, &limitErr) || limitErr.Limit != "user-max-writes-per-second" {
+		t.Errorf("Expected the write limit of alice, got %v", err)
+	}
+	// the rejected write consumed no op
+	if err := alice.Allow(false); err != nil {
+		t.Errorf("Expected a read to be allowed, got %v", err)
+	}
+	if err := alice.Allow(false); !errors.As(err, &limitErr) || limitErr.Limit != "user-max-ops-per-second" {
+		t.Errorf("Expected the ops limit of alice, got %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := bob.Allow(true); err != nil {
+			t.Fatalf("Expected bob to have no ops limit, got %v", err)
+		}
+	}
+
+	// the buckets refill with the time
+	alice.ops.last = alice.ops.last.Add(-time.Second)
+	if err := alice.Allow(false); err != nil {
+		t.Errorf("Expected the ops of alice to be refilled, got %v", err)
+	}
+}
@@ -1,25 +1,63 @@
 package aof
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"os"
+	"path/filepath"
 	"redigo/config"
 	"redigo/interface/database"
+	"redigo/lib/failpoint"
 	"redigo/lib/logger"
 	"redigo/lib/utils"
 	"redigo/resp/connection"
 	"redigo/resp/parser"
 	"redigo/resp/reply"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const aofBufferSize = 1 << 16 // 65536 bytes
+// defaultBufferSize is the number of commands waiting for the writer when aof-buffer-size is
+// not set
+const defaultBufferSize = 1 << 16 // 65536 commands
+
+// Policies of aof-backpressure, applied to the write commands when the writer is behind and
+// aofChan is full
+const (
+	BackpressureBlock  = "block"  // the commands wait for room in aofChan, counted as blocked
+	BackpressureSpill  = "spill"  // the commands are kept in a temporary file until the writer caught up
+	BackpressureReject = "reject" // the write commands are rejected with an OOM error
+)
+
+// Policies of appendfsync, when the written commands are flushed to disk
+const (
+	FsyncAlways   = "always"   // after every command, a crash loses no acknowledged write
+	FsyncEverySec = "everysec" // once per second, a crash loses up to a second of writes
+	FsyncNo       = "no"       // when the operating system flushes its cache
+)
+
+// FsyncPolicy returns the appendfsync policy of the configuration, which may change at runtime
+func FsyncPolicy() string {
+	if policy := config.Read(func(p *config.ServerProperties) string { return p.AppendFsync }); policy != "" {
+		return strings.ToLower(policy)
+	}
+	return FsyncAlways
+}
+
+// defaultFilename is the AOF file used when appendfilename is not set
+const defaultFilename = "appendonly.aof"
 
 type CmdLine = [][]byte
 
 type payload struct {
 	cmdLine CmdLine
 	dbIndex int
+	// rewrite is set on the payloads starting or finishing a rewrite, which carry no command
+	rewrite *rewriteEvent
 }
 
 // AofHandler handles the Append-Only File (AOF) functionality for Redis.
@@ -29,68 +67,323 @@ type AofHandler struct {
 	aofFile     *os.File
 	aofFilename string
 	currentDB   int
+	// closed stops AddAof, finished is closed once handleAof wrote the pending commands
+	mu       sync.RWMutex
+	closed   bool
+	finished chan struct{}
+	// size is the size of the AOF file, baseSize its size after the last rewrite or at startup
+	size     atomic.Int64
+	baseSize atomic.Int64
+	// rewriting is set from StartRewrite to the end of the rewrite, rewriteBuf holds the
+	// commands written during the rewrite and rewriteDB the DB they were last selected in, they
+	// are only used by handleAof
+	rewriting  atomic.Bool
+	rewriteBuf *bytes.Buffer
+	rewriteDB  int
+	// policy is the aof-backpressure policy, spill buffers the commands of the spill policy,
+	// blocked and rejected count the commands which waited for aofChan or were rejected
+	policy   string
+	spill    *spillBuffer
+	blocked  atomic.Int64
+	rejected atomic.Int64
+	// unsynced is set when commands were written since the last flush to disk of everysec, only
+	// used by handleAof
+	unsynced bool
+}
+
+// Filename returns the path of the AOF file: a relative appendfilename is in appenddirname,
+// and a relative appenddirname is in the dir of the configuration
+func Filename() string {
+	filename := defaultFilename
+	if config.Properties.AppendFilename != "" {
+		filename = config.Properties.AppendFilename
+	}
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+	if config.Properties.AppendDirname != "" {
+		filename = filepath.Join(config.Properties.AppendDirname, filename)
+	}
+	if config.Properties.Dir != "" && !filepath.IsAbs(filename) {
+		filename = filepath.Join(config.Properties.Dir, filename)
+	}
+	return filename
 }
 
 // NewAofHandler creates a new AofHandler instance.
+// The directory of the AOF file is created if needed, an error is returned if the file cannot
+// be opened for writing, before anything is loaded from it
 func NewAofHandler(db database.Database) (*AofHandler, error) {
-	handler := &AofHandler{}
-	handler.aofFilename = config.Properties.AppendFilename
-	handler.db = db
-	// Load the AOF file if it exists
-	handler.LoadAof()
+	handler, err := makeAofHandler(db)
+	if err != nil {
+		return nil, err
+	}
 	// Open the AOF file for reading and writing
 	aofFile, err := os.OpenFile(handler.aofFilename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
 	if err != nil {
-		return nil, err
+		return nil, errors.New("cannot open the AOF file " + handler.aofFilename + ": " + err.Error())
 	}
 	handler.aofFile = aofFile
+	// Load the AOF file if it exists
+	handler.LoadAof()
+	handler.start()
+	return handler, nil
+}
+
+// NewSeededAofHandler creates an AofHandler for a dataset which is already in memory, when AOF is
+// turned on at runtime: the AOF file is replaced with the commands rebuilding the dataset instead
+// of being loaded. The handler starts with a rewrite and without file, the commands added are
+// only kept for the rewrite until FinishRewrite creates the file with the dump
+// It must be called while no write command runs, so that the AOF misses none of them
+func NewSeededAofHandler(db database.Database) (*AofHandler, error) {
+	handler, err := makeAofHandler(db)
+	if err != nil {
+		return nil, err
+	}
+	handler.start()
+	if err := handler.StartRewrite(); err != nil {
+		handler.Close()
+		return nil, err
+	}
+	return handler, nil
+}
+
+// makeAofHandler returns a handler of the AOF file of the configuration, creating its directory
+func makeAofHandler(db database.Database) (*AofHandler, error) {
+	handler := &AofHandler{}
+	handler.aofFilename = Filename()
+	handler.db = db
+	if err := os.MkdirAll(filepath.Dir(handler.aofFilename), 0755); err != nil {
+		return nil, errors.New("cannot create the directory of the AOF file " + handler.aofFilename + ": " + err.Error())
+	}
+	handler.policy = config.Properties.AofBackpressure
+	switch handler.policy {
+	case "":
+		handler.policy = BackpressureBlock
+	case BackpressureBlock, BackpressureReject:
+	case BackpressureSpill:
+		handler.spill = makeSpillBuffer(filepath.Dir(handler.aofFilename))
+	default:
+		return nil, errors.New("invalid aof-backpressure " + handler.policy + ", expected block, spill or reject")
+	}
+	switch policy := FsyncPolicy(); policy {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+	default:
+		return nil, errors.New("invalid appendfsync " + policy + ", expected always, everysec or no")
+	}
+	return handler, nil
+}
+
+// start writes the commands added from now on to the open AOF file
+func (h *AofHandler) start() {
+	if h.aofFile != nil {
+		if info, err := h.aofFile.Stat(); err == nil {
+			h.size.Store(info.Size())
+			h.baseSize.Store(info.Size())
+		}
+	}
 	// Make a chan for aof
-	handler.aofChan = make(chan *payload, aofBufferSize)
+	bufferSize := config.Properties.AofBufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	h.aofChan = make(chan *payload, bufferSize)
+	h.finished = make(chan struct{})
 	// Start a goroutine to handle the AOF file writing
 	go func() {
-		handler.handleAof()
+		h.handleAof()
 	}()
-	return handler, nil
 }
 
 // AddAof adds a command line to the AOF file. It will push the command line to the aofChan channel.
+// Commands added after Close are dropped
 func (h *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
-	if h.aofChan == nil || !config.Properties.AppendOnly {
-		h.aofChan = make(chan *payload, 100)
-	}
-	h.aofChan <- &payload{
+	h.send(&payload{
 		cmdLine: cmdLine,
 		dbIndex: dbIndex,
+	})
+}
+
+// send pushes a payload to handleAof, it returns false after Close
+func (h *AofHandler) send(p *payload) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		return false
+	}
+	if h.spill != nil {
+		h.spill.send(h.aofChan, p)
+		return true
+	}
+	select {
+	case h.aofChan <- p:
+	default:
+		// the writer is behind, the command waits for it
+		if p.rewrite == nil {
+			h.blocked.Add(1)
+		}
+		h.aofChan <- p
+	}
+	return true
+}
+
+// Reject reports whether a write command must be rejected because the writer is behind, with
+// aof-backpressure reject, and counts the rejection
+func (h *AofHandler) Reject() bool {
+	if h.policy != BackpressureReject || len(h.aofChan) < cap(h.aofChan) {
+		return false
+	}
+	h.rejected.Add(1)
+	return true
+}
+
+// Backpressure returns the aof-backpressure policy and the number of commands waiting for the
+// writer, in aofChan or spilled
+func (h *AofHandler) Backpressure() (policy string, pending int64) {
+	pending = int64(len(h.aofChan))
+	if h.spill != nil {
+		pending += h.spill.pending.Load()
 	}
+	return h.policy, pending
+}
+
+// BackpressureStats returns the number of commands which waited for the writer, were spilled,
+// or were rejected since the start
+func (h *AofHandler) BackpressureStats() (blocked, spilled, rejected int64) {
+	if h.spill != nil {
+		spilled = h.spill.total.Load()
+	}
+	return h.blocked.Load(), spilled, h.rejected.Load()
 }
 
 // handleAof handles the AOF file writing. It will write the command line to the AOF file.
 func (h *AofHandler) handleAof() {
-	h.currentDB = 0
-	for p := range h.aofChan {
-		var dataToWrite []byte
-
-		// 原子性地准备所有要写入的数据
-		if p.dbIndex != h.currentDB {
-			h.currentDB = p.dbIndex
-			// 准备 SELECT 命令数据
-			selectData := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
-			cmdData := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
-			// 合并为一次写入
-			dataToWrite = append(selectData, cmdData...)
-		} else {
-			dataToWrite = reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
+	defer close(h.finished)
+	// the DB selected at the end of an existing file is unknown, the first command selects its DB
+	h.currentDB = -1
+	// ready stays nil without spill, it never fires
+	var ready chan struct{}
+	if h.spill != nil {
+		defer h.spill.close()
+		ready = h.spill.ready
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case p, ok := <-h.aofChan:
+			if !ok {
+				if h.spill != nil {
+					h.drainSpill()
+				}
+				return
+			}
+			h.handlePayload(p)
+		case <-ready:
+			h.drainSpill()
+		case <-ticker.C:
+			if h.unsynced {
+				h.unsynced = false
+				if err := h.aofFile.Sync(); err != nil {
+					logger.Error("AOF sync error: " + err.Error())
+				}
+			}
 		}
+	}
+}
 
-		// 原子性写入
-		_, err := h.aofFile.Write(dataToWrite)
+// drainSpill writes the commands of aofChan, then the spilled commands, which were sent after them
+func (h *AofHandler) drainSpill() {
+	for empty := false; !empty; {
+		select {
+		case p, ok := <-h.aofChan:
+			if ok {
+				h.handlePayload(p)
+			} else {
+				empty = true
+			}
+		default:
+			empty = true
+		}
+	}
+	for h.spill.pending.Load() > 0 {
+		p, err := h.spill.pop()
 		if err != nil {
-			logger.Error("AOF write error: " + err.Error())
-			continue
+			logger.Error("AOF spill read error: " + err.Error())
+			h.spill.discard()
+			return
 		}
+		h.handlePayload(p)
+	}
+}
+
+// handlePayload writes a command to the AOF file, or runs a step of a rewrite
+func (h *AofHandler) handlePayload(p *payload) {
+	if p.rewrite != nil {
+		h.handleRewrite(p.rewrite)
+		return
+	}
+	if h.rewriteBuf != nil {
+		h.bufferRewrite(p)
+	}
+	if h.aofFile == nil {
+		return
+	}
+	var dataToWrite []byte
+
+	// 原子性地准备所有要写入的数据
+	if p.dbIndex != h.currentDB {
+		h.currentDB = p.dbIndex
+		// 准备 SELECT 命令数据
+		selectData := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
+		cmdData := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
+		// 合并为一次写入
+		dataToWrite = append(selectData, cmdData...)
+	} else {
+		dataToWrite = reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
+	}
 
-		// 确保数据立即刷新到磁盘
+	// 原子性写入
+	err := failpoint.Inject(failpoint.AofWrite)
+	if err == nil {
+		var n int
+		n, err = h.aofFile.Write(dataToWrite)
+		h.size.Add(int64(n))
+	}
+	if err != nil {
+		logger.Error("AOF write error: " + err.Error())
+		return
+	}
+
+	// 按 appendfsync 策略刷新到磁盘
+	switch FsyncPolicy() {
+	case FsyncAlways:
 		h.aofFile.Sync()
+	case FsyncEverySec:
+		h.unsynced = true
+	}
+}
+
+// Close writes the pending commands and closes the AOF file, so that every acknowledged write
+// is on disk when the server exits
+func (h *AofHandler) Close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	close(h.aofChan)
+	h.mu.Unlock()
+	<-h.finished
+	if h.aofFile == nil {
+		return
+	}
+	if err := h.aofFile.Sync(); err != nil {
+		logger.Error("AOF sync error: " + err.Error())
+	}
+	if err := h.aofFile.Close(); err != nil {
+		logger.Error("AOF close error: " + err.Error())
 	}
 }
 
@@ -105,6 +398,8 @@ func (h *AofHandler) LoadAof() {
 	defer aofFile.Close()
 
 	ch := parser.ParseStream(aofFile)
+	// the SELECT records are executed like the other commands, they switch the DB of fakeConn
+	// for the commands which follow
 	fakeConn := &connection.Connection{}
 	for p := range ch {
 		if p.Err != nil {
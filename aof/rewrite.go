@@ -0,0 +1,191 @@
+package aof
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"redigo/config"
+	"redigo/lib/logger"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strconv"
+)
+
+// A rewrite replaces the AOF with the commands rebuilding the current dataset:
+//   - StartRewrite is called while no write command runs, handleAof keeps a copy of the
+//     commands written from then on
+//   - FinishRewrite has the caller dump the dataset as it was at StartRewrite to a temporary file
+//   - handleAof appends the commands written during the rewrite to the temporary file, which
+//     then replaces the AOF
+//
+// The AOF keeps receiving every command until it is replaced, a failed rewrite leaves it intact
+
+// DefaultAutoRewriteMinSize is the min size of the AOF for an automatic rewrite when
+// auto-aof-rewrite-min-size is not set, that of Redis
+const DefaultAutoRewriteMinSize = 64 * 1024 * 1024
+
+// ErrRewriteInProgress is returned by StartRewrite while another rewrite is running
+var ErrRewriteInProgress = errors.New("a rewrite of the AOF is already in progress")
+
+var errClosed = errors.New("AOF is closed")
+
+// rewriteEvent starts a rewrite if start is set, otherwise finishes it by replacing the AOF
+// with tmp, or cancels it if tmp is nil
+type rewriteEvent struct {
+	start bool
+	tmp   *os.File
+	done  chan error
+}
+
+// StartRewrite starts a rewrite, the commands added from now on are appended to the rewritten file
+// It must be called while no write command runs, so that the dump of FinishRewrite holds exactly
+// the commands added before
+func (h *AofHandler) StartRewrite() error {
+	if !h.rewriting.CompareAndSwap(false, true) {
+		return ErrRewriteInProgress
+	}
+	if !h.send(&payload{rewrite: &rewriteEvent{start: true}}) {
+		h.rewriting.Store(false)
+		return errClosed
+	}
+	return nil
+}
+
+// FinishRewrite replaces the AOF with the commands rebuilding the dataset at StartRewrite, written
+// by dump, followed by the commands added since
+func (h *AofHandler) FinishRewrite(dump func(w io.Writer) error) error {
+	defer h.rewriting.Store(false)
+	tmp, err := h.writeRewriteBase(dump)
+	if err != nil {
+		h.CancelRewrite()
+		return err
+	}
+	done := make(chan error, 1)
+	if !h.send(&payload{rewrite: &rewriteEvent{tmp: tmp, done: done}}) {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return errClosed
+	}
+	return <-done
+}
+
+// CancelRewrite stops a rewrite started by StartRewrite, leaving the AOF as it is
+func (h *AofHandler) CancelRewrite() {
+	done := make(chan error, 1)
+	if h.send(&payload{rewrite: &rewriteEvent{done: done}}) {
+		<-done
+	}
+	h.rewriting.Store(false)
+}
+
+// IsRewriting reports whether a rewrite is running
+func (h *AofHandler) IsRewriting() bool {
+	return h.rewriting.Load()
+}
+
+// ShouldRewrite reports whether the AOF grew enough since the last rewrite for an automatic
+// one, according to auto-aof-rewrite-percentage and auto-aof-rewrite-min-size
+func (h *AofHandler) ShouldRewrite() bool {
+	percentage := config.Properties.AutoAofRewritePercentage
+	if percentage <= 0 || h.rewriting.Load() {
+		return false
+	}
+	minSize := int64(config.Properties.AutoAofRewriteMinSize)
+	if minSize <= 0 {
+		minSize = DefaultAutoRewriteMinSize
+	}
+	size := h.size.Load()
+	if size < minSize {
+		return false
+	}
+	base := h.baseSize.Load()
+	if base <= 0 {
+		base = 1
+	}
+	return (size-base)*100/base >= int64(percentage)
+}
+
+// Size returns the size of the AOF file and its size after the last rewrite or at startup
+func (h *AofHandler) Size() (size int64, baseSize int64) {
+	return h.size.Load(), h.baseSize.Load()
+}
+
+// writeRewriteBase writes the dump to a temporary file next to the AOF
+func (h *AofHandler) writeRewriteBase(dump func(w io.Writer) error) (*os.File, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(h.aofFilename), "temp-rewrite-*.aof")
+	if err != nil {
+		return nil, err
+	}
+	if err = dump(tmp); err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// bufferRewrite keeps a copy of a command written during a rewrite
+func (h *AofHandler) bufferRewrite(p *payload) {
+	if p.dbIndex != h.rewriteDB {
+		h.rewriteDB = p.dbIndex
+		h.rewriteBuf.Write(reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes())
+	}
+	h.rewriteBuf.Write(reply.MakeMultiBulkReply(p.cmdLine).ToBytes())
+}
+
+// handleRewrite runs the steps of a rewrite in handleAof, between the writes of the commands
+func (h *AofHandler) handleRewrite(ev *rewriteEvent) {
+	if ev.start {
+		h.rewriteBuf = &bytes.Buffer{}
+		h.rewriteDB = -1
+		return
+	}
+	buffered := h.rewriteBuf
+	h.rewriteBuf = nil
+	if ev.tmp == nil {
+		ev.done <- nil
+		return
+	}
+	err := h.replaceFile(ev.tmp, buffered.Bytes())
+	if err != nil {
+		_ = ev.tmp.Close()
+		_ = os.Remove(ev.tmp.Name())
+		logger.Error("AOF rewrite failed: " + err.Error())
+	}
+	ev.done <- err
+}
+
+// replaceFile appends the commands written during the rewrite to tmp and makes it the AOF
+func (h *AofHandler) replaceFile(tmp *os.File, buffered []byte) error {
+	if _, err := tmp.Write(buffered); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), h.aofFilename); err != nil {
+		return err
+	}
+	// a seeded handler has no file before its first rewrite
+	if h.aofFile != nil {
+		if err := h.aofFile.Close(); err != nil {
+			logger.Error("AOF close error: " + err.Error())
+		}
+	}
+	h.aofFile = tmp
+	h.size.Store(info.Size())
+	h.baseSize.Store(info.Size())
+	// the rewritten file ends in the DB of its last command, the next command selects its DB
+	h.currentDB = -1
+	logger.Info("AOF rewritten, " + strconv.FormatInt(info.Size(), 10) + " bytes")
+	return nil
+}
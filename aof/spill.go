@@ -0,0 +1,201 @@
+package aof
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"redigo/lib/logger"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// spillBuffer keeps the commands in a temporary file while aofChan is full, with
+// aof-backpressure spill, so that the write commands neither wait for the writer nor get lost
+// Once a command is spilled, the commands which follow are spilled too until the writer caught
+// up, so that the AOF receives them in order: the writer first writes the commands of aofChan,
+// which were sent before, then reads the file
+type spillBuffer struct {
+	dir string
+
+	mu       sync.Mutex
+	file     *os.File // created by the first spill
+	readOff  int64
+	writeOff int64
+	// pending is the number of commands in the file, it is only increased with mu held and
+	// decreased by the writer
+	pending atomic.Int64
+	// total is the number of commands spilled since the start
+	total atomic.Int64
+	// ready wakes up the writer when a command is spilled, drained is signaled once the writer
+	// read every spilled command
+	ready   chan struct{}
+	drained *sync.Cond
+}
+
+// makeSpillBuffer returns a buffer spilling to a file of dir, the directory of the AOF
+func makeSpillBuffer(dir string) *spillBuffer {
+	s := &spillBuffer{
+		dir:   dir,
+		ready: make(chan struct{}, 1),
+	}
+	s.drained = sync.NewCond(&s.mu)
+	return s
+}
+
+// send queues p after the spilled commands: it is sent to ch unless commands are spilled or ch
+// is full, then it is written to the file
+// The rewrite events, which cannot be written to the file, wait for the spilled commands to be
+// written first, and so do the commands failing to be written to the file
+func (s *spillBuffer) send(ch chan<- *payload, p *payload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.pending.Load() > 0 {
+		if p.rewrite == nil {
+			err := s.push(p)
+			if err == nil {
+				return
+			}
+			logger.Error("AOF spill error, waiting for the writer: " + err.Error())
+		}
+		s.drained.Wait()
+	}
+	if p.rewrite != nil {
+		// the writer does not take mu while nothing is spilled
+		ch <- p
+		return
+	}
+	select {
+	case ch <- p:
+		return
+	default:
+	}
+	if err := s.push(p); err != nil {
+		logger.Error("AOF spill error, waiting for the writer: " + err.Error())
+		ch <- p
+	}
+}
+
+// push appends p to the file, mu must be held
+func (s *spillBuffer) push(p *payload) error {
+	if s.file == nil {
+		file, err := os.CreateTemp(s.dir, "temp-spill-*.aof")
+		if err != nil {
+			return err
+		}
+		s.file = file
+	}
+	record := encodeSpilled(p)
+	if _, err := s.file.WriteAt(record, s.writeOff); err != nil {
+		return err
+	}
+	s.writeOff += int64(len(record))
+	if s.pending.Add(1) == 1 {
+		logger.Warn("AOF writer is behind, spilling the commands to " + s.file.Name())
+	}
+	s.total.Add(1)
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pop reads the oldest spilled command, the file is emptied once every command is read
+func (s *spillBuffer) pop() (*payload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var header [4]byte
+	if _, err := s.file.ReadAt(header[:], s.readOff); err != nil {
+		return nil, err
+	}
+	record := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := s.file.ReadAt(record, s.readOff+int64(len(header))); err != nil {
+		return nil, err
+	}
+	p, err := decodeSpilled(record)
+	if err != nil {
+		return nil, err
+	}
+	s.readOff += int64(len(header) + len(record))
+	if s.pending.Add(-1) == 0 {
+		s.reset()
+	}
+	return p, nil
+}
+
+// discard drops the spilled commands after a read error, so that the commands which follow
+// are written again
+func (s *spillBuffer) discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lost := s.pending.Swap(0)
+	logger.Error("AOF spill lost " + strconv.FormatInt(lost, 10) + " commands")
+	s.reset()
+}
+
+// reset empties the file and wakes up the senders waiting for the writer, mu must be held
+func (s *spillBuffer) reset() {
+	s.readOff, s.writeOff = 0, 0
+	s.drained.Broadcast()
+	if s.file == nil {
+		return
+	}
+	if err := s.file.Truncate(0); err != nil {
+		logger.Error("AOF spill truncate error: " + err.Error())
+	}
+}
+
+// close removes the file
+func (s *spillBuffer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return
+	}
+	_ = s.file.Close()
+	_ = os.Remove(s.file.Name())
+	s.file = nil
+}
+
+// encodeSpilled returns the record of a spilled command: its length on 4 bytes, followed by the
+// DB index, the number of arguments and each argument prefixed by its length, as uvarints
+func encodeSpilled(p *payload) []byte {
+	record := make([]byte, 4, 16)
+	record = binary.AppendUvarint(record, uint64(p.dbIndex))
+	record = binary.AppendUvarint(record, uint64(len(p.cmdLine)))
+	for _, arg := range p.cmdLine {
+		record = binary.AppendUvarint(record, uint64(len(arg)))
+		record = append(record, arg...)
+	}
+	binary.BigEndian.PutUint32(record, uint32(len(record)-4))
+	return record
+}
+
+var errBadSpill = errors.New("malformed spilled command")
+
+// decodeSpilled reads a spilled command from its record, without the length
+func decodeSpilled(record []byte) (*payload, error) {
+	next := func() (uint64, bool) {
+		n, size := binary.Uvarint(record)
+		if size <= 0 {
+			return 0, false
+		}
+		record = record[size:]
+		return n, true
+	}
+	dbIndex, ok1 := next()
+	count, ok2 := next()
+	if !ok1 || !ok2 || count > uint64(len(record)) {
+		return nil, errBadSpill
+	}
+	cmdLine := make(CmdLine, count)
+	for i := range cmdLine {
+		n, ok := next()
+		if !ok || n > uint64(len(record)) {
+			return nil, errBadSpill
+		}
+		cmdLine[i], record = record[:n:n], record[n:]
+	}
+	return &payload{cmdLine: cmdLine, dbIndex: int(dbIndex)}, nil
+}
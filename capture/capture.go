@@ -0,0 +1,353 @@
+// Package capture records the commands received from the clients to a binary file, which
+// cmd/replay sends again to another server for load tests with a realistic traffic
+//
+// Unlike the AOF, the capture holds every command of every client, reads included, with the
+// time it was received and the connection it came from, so that a replay reproduces the
+// concurrency and the pace of the traffic. AUTH and the password of HELLO are not recorded.
+//
+// The file starts with the magic "RDGCAP" and a version byte, followed by entries, each starting
+// with its type byte:
+//   - 'S' starts a session: the start time in unix nanoseconds as an uvarint; a server appending
+//     to an existing capture starts a new session
+//   - 'C' is a command: the uvarints of the nanoseconds since the start of the session, of the
+//     connection ID and of the number of arguments, then each argument as its uvarint length and
+//     its bytes
+//   - 'X' is a closed connection: the uvarints of the nanoseconds since the start of the session
+//     and of the connection ID
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"redigo/lib/logger"
+	"sync"
+	"time"
+)
+
+const (
+	magic   = "RDGCAP"
+	version = 1
+
+	entrySession = 'S'
+	entryCommand = 'C'
+	entryClose   = 'X'
+)
+
+// flushInterval is the max time a recorded command waits in the buffer before it is written
+const flushInterval = time.Second
+
+// bufferSize is the size of the buffer the entries are encoded in before they are written
+const bufferSize = 256 * 1024
+
+// Recorder appends the commands received from the clients to a capture file
+// Entries are encoded in a buffer written when it is full and every second, so recording a
+// command costs a copy of its arguments
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	start   time.Time
+	scratch []byte
+	failed  bool
+	closed  bool
+
+	commands int64
+	done     chan struct{}
+}
+
+// Open opens the capture file, or creates it, and starts a session at its end
+func Open(filename string) (*Recorder, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	size := info.Size()
+	if size > 0 {
+		if size, err = validLength(file); err != nil {
+			_ = file.Close()
+			return nil, errors.New(filename + ": " + err.Error())
+		}
+		// an entry cut by a crash is dropped, the new session starts after the last whole entry
+		if err := file.Truncate(size); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	r := &Recorder{
+		file:   file,
+		writer: bufio.NewWriterSize(file, bufferSize),
+		start:  time.Now(),
+		done:   make(chan struct{}),
+	}
+	if size == 0 {
+		_, _ = r.writer.WriteString(magic)
+		_ = r.writer.WriteByte(version)
+	}
+	r.scratch = append(r.scratch[:0], entrySession)
+	r.scratch = binary.AppendUvarint(r.scratch, uint64(r.start.UnixNano()))
+	_, _ = r.writer.Write(r.scratch)
+	go r.flushEvery(flushInterval)
+	return r, nil
+}
+
+// validLength returns the length of the whole entries of an existing capture
+func validLength(file *os.File) (int64, error) {
+	reader, err := NewReader(file)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err := reader.Next(); err == io.EOF {
+			return reader.valid, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Record records a command received from the connection id
+func (r *Recorder) Record(id uint64, args [][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.failed {
+		return
+	}
+	b := append(r.scratch[:0], entryCommand)
+	b = binary.AppendUvarint(b, uint64(time.Since(r.start)))
+	b = binary.AppendUvarint(b, id)
+	b = binary.AppendUvarint(b, uint64(len(args)))
+	for _, arg := range args {
+		b = binary.AppendUvarint(b, uint64(len(arg)))
+		b = append(b, arg...)
+	}
+	r.scratch = b
+	r.write(b)
+	r.commands++
+}
+
+// Disconnected records that the connection id was closed
+func (r *Recorder) Disconnected(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.failed {
+		return
+	}
+	b := append(r.scratch[:0], entryClose)
+	b = binary.AppendUvarint(b, uint64(time.Since(r.start)))
+	b = binary.AppendUvarint(b, id)
+	r.scratch = b
+	r.write(b)
+}
+
+// write writes an entry to the buffer, the recording stops on the first error
+func (r *Recorder) write(b []byte) {
+	if _, err := r.writer.Write(b); err != nil {
+		r.failed = true
+		logger.Error("traffic capture stopped: " + err.Error())
+	}
+}
+
+// Commands returns the number of commands recorded since the capture was opened
+func (r *Recorder) Commands() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.commands
+}
+
+// flushEvery writes the buffer to the file every interval until the recorder is closed
+func (r *Recorder) flushEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			if !r.failed {
+				if err := r.writer.Flush(); err != nil {
+					r.failed = true
+					logger.Error("traffic capture stopped: " + err.Error())
+				}
+			}
+			r.mu.Unlock()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Close writes the buffered entries and closes the file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	err := r.writer.Flush()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Entry is a command or a closed connection read from a capture
+type Entry struct {
+	// Offset is the time of the entry since the start of the capture; the sessions follow each
+	// other, a session starts at the offset of the last entry of the previous one
+	Offset time.Duration
+	// Session numbers the sessions from 1, the connection IDs of a session restart with the server
+	Session int
+	ConnID  uint64
+	Args    [][]byte // the command, nil for a closed connection
+}
+
+// Reader reads the entries of a capture file in order
+type Reader struct {
+	src    *countingReader
+	reader *bufio.Reader
+	// base is the offset the current session starts at, last the offset of the last entry
+	base    time.Duration
+	last    time.Duration
+	session int
+	valid   int64 // length of the capture up to the end of the last whole entry
+}
+
+// countingReader counts the bytes read from the capture
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewReader checks the header of the capture and returns its reader
+func NewReader(src io.Reader) (*Reader, error) {
+	counting := &countingReader{Reader: src}
+	reader := bufio.NewReader(counting)
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, errors.New("not a capture file: " + err.Error())
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, errors.New("not a capture file")
+	}
+	if header[len(magic)] != version {
+		return nil, errors.New("unsupported capture version")
+	}
+	return &Reader{src: counting, reader: reader, valid: int64(len(header))}, nil
+}
+
+// position returns the number of bytes consumed from the capture
+func (r *Reader) position() int64 {
+	return r.src.n - int64(r.reader.Buffered())
+}
+
+// Next returns the next entry, io.EOF at the end of the capture
+// An entry cut by a crash of the server ends the capture like io.EOF
+func (r *Reader) Next() (*Entry, error) {
+	for {
+		kind, err := r.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case entrySession:
+			if _, err := binary.ReadUvarint(r.reader); err != nil {
+				return nil, truncated(err)
+			}
+			r.base = r.last
+			r.session++
+			r.valid = r.position()
+		case entryCommand, entryClose:
+			if r.session == 0 {
+				return nil, errors.New("invalid capture entry")
+			}
+			entry, err := r.readEntry(kind)
+			if err != nil {
+				return nil, truncated(err)
+			}
+			r.last = entry.Offset
+			r.valid = r.position()
+			return entry, nil
+		default:
+			return nil, errors.New("invalid capture entry")
+		}
+	}
+}
+
+func (r *Reader) readEntry(kind byte) (*Entry, error) {
+	offset, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return nil, err
+	}
+	id, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{Offset: r.base + time.Duration(offset), Session: r.session, ConnID: id}
+	if kind == entryClose {
+		return entry, nil
+	}
+	count, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, errors.New("invalid capture entry")
+	}
+	entry.Args = make([][]byte, 0, min(count, 1024))
+	for i := uint64(0); i < count; i++ {
+		size, err := binary.ReadUvarint(r.reader)
+		if err != nil {
+			return nil, err
+		}
+		arg, err := readArg(r.reader, size)
+		if err != nil {
+			return nil, err
+		}
+		entry.Args = append(entry.Args, arg)
+	}
+	return entry, nil
+}
+
+// maxPreallocatedArg is the size above which an argument is read without allocating its length
+// first, so that a corrupted length does not allocate more than the capture holds
+const maxPreallocatedArg = 1 << 20
+
+func readArg(reader *bufio.Reader, size uint64) ([]byte, error) {
+	if size <= maxPreallocatedArg {
+		arg := make([]byte, size)
+		_, err := io.ReadFull(reader, arg)
+		return arg, err
+	}
+	arg, err := io.ReadAll(io.LimitReader(reader, int64(size)))
+	if err == nil && uint64(len(arg)) != size {
+		err = io.ErrUnexpectedEOF
+	}
+	return arg, err
+}
+
+// truncated returns io.EOF for an entry cut by the end of the file
+func truncated(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return io.EOF
+	}
+	return err
+}
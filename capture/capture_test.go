@@ -0,0 +1,124 @@
+package capture
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, filename string) []*Entry {
+	t.Helper()
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []*Entry
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func toArgs(line string) [][]byte {
+	var args [][]byte
+	for _, arg := range strings.Split(line, " ") {
+		args = append(args, []byte(arg))
+	}
+	return args
+}
+
+// TestRecordAndRead tests that the commands and the closed connections are read back in order
+// with increasing offsets, and that a reopened capture continues in a new session
+func TestRecordAndRead(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "traffic.cap")
+	recorder, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Record(1, toArgs("SET key value"))
+	time.Sleep(2 * time.Millisecond)
+	recorder.Record(2, [][]byte{[]byte("SET"), []byte("binary"), {0, '\r', '\n', 0xff}})
+	recorder.Disconnected(1)
+	if recorder.Commands() != 2 {
+		t.Errorf("expected 2 commands, got %d", recorder.Commands())
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Record(1, toArgs("GET key"))
+	_ = recorder.Close()
+
+	entries := readAll(t, filename)
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	if string(entries[0].Args[2]) != "value" || entries[0].ConnID != 1 || entries[0].Session != 1 {
+		t.Errorf("unexpected first entry %+v", entries[0])
+	}
+	if string(entries[1].Args[2]) != "\x00\r\n\xff" || entries[1].Offset < entries[0].Offset+2*time.Millisecond {
+		t.Errorf("unexpected second entry %+v", entries[1])
+	}
+	if entries[2].Args != nil || entries[2].ConnID != 1 {
+		t.Errorf("expected the close of the connection 1, got %+v", entries[2])
+	}
+	if string(entries[3].Args[0]) != "GET" || entries[3].Session != 2 || entries[3].Offset < entries[2].Offset {
+		t.Errorf("expected GET in a second session after the first, got %+v", entries[3])
+	}
+}
+
+// TestTruncatedCapture tests that an entry cut by a crash ends the capture and is dropped when
+// the capture is reopened
+func TestTruncatedCapture(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "traffic.cap")
+	recorder, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Record(1, toArgs("SET key value"))
+	recorder.Record(1, toArgs("SET other value"))
+	_ = recorder.Close()
+	info, _ := os.Stat(filename)
+	if err := os.Truncate(filename, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	if entries := readAll(t, filename); len(entries) != 1 {
+		t.Fatalf("expected the cut entry to be skipped, got %d entries", len(entries))
+	}
+
+	recorder, err = Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.Record(7, toArgs("GET key"))
+	_ = recorder.Close()
+	entries := readAll(t, filename)
+	if len(entries) != 2 || string(entries[1].Args[0]) != "GET" || entries[1].ConnID != 7 {
+		t.Fatalf("expected the new session after the whole entries, got %+v", entries)
+	}
+
+	if err := os.WriteFile(filename, []byte("*1\r\n$4\r\nPING\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(filename); err == nil {
+		t.Error("expected a file which is not a capture to be rejected")
+	}
+}
@@ -0,0 +1,164 @@
+// Package cdc publishes the changes of the dataset to external systems (change data capture)
+//
+// The database sends every write command to the Publisher through the same path as the AOF, so
+// the change feed holds exactly the commands which are written to the AOF, in the same order.
+// The publisher writes them to a Sink in background: a JSON lines file, a webhook or a channel
+package cdc
+
+import (
+	"encoding/base64"
+	"errors"
+	"redigo/lib/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// bufferSize is the number of records waiting for the sink before writes are slowed down
+const bufferSize = 1 << 16
+
+// Record is a change of the dataset, the write command with its DB and keys
+type Record struct {
+	Seq     uint64   `json:"seq"`  // position of the change in the feed, starting at 1 on each start
+	Time    int64    `json:"time"` // unix time of the change in milliseconds
+	DB      int      `json:"db"`
+	Command string   `json:"command"`
+	Keys    []string `json:"keys,omitempty"`
+	Args    []string `json:"args"` // arguments after the command name
+	// Encoding is base64 if the keys and the arguments are base64 encoded because one of them is
+	// not valid UTF-8, empty otherwise
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// MakeRecord creates the record of a write command, keys are the keys of the command
+func MakeRecord(db int, cmdLine [][]byte, keys []string) Record {
+	r := Record{
+		Time:    time.Now().UnixMilli(),
+		DB:      db,
+		Command: strings.ToUpper(string(cmdLine[0])),
+		Keys:    keys,
+		Args:    make([]string, len(cmdLine)-1),
+	}
+	binary := false
+	for _, key := range keys {
+		binary = binary || !utf8.ValidString(key)
+	}
+	for _, arg := range cmdLine[1:] {
+		binary = binary || !utf8.Valid(arg)
+	}
+	for i, arg := range cmdLine[1:] {
+		r.Args[i] = string(arg)
+	}
+	if binary {
+		r.Encoding = "base64"
+		r.Keys = make([]string, len(keys))
+		for i, key := range keys {
+			r.Keys[i] = base64.StdEncoding.EncodeToString([]byte(key))
+		}
+		for i, arg := range cmdLine[1:] {
+			r.Args[i] = base64.StdEncoding.EncodeToString(arg)
+		}
+	}
+	return r
+}
+
+// Sink receives the change records
+type Sink interface {
+	// Write writes records in the order of the changes
+	Write(records []Record) error
+	// Close flushes and releases the sink
+	Close() error
+}
+
+// Open opens the sink described by spec:
+//   - file:<path> appends JSON lines to the file
+//   - http://<url> or https://<url> posts the records as JSON lines to the URL
+func Open(spec string) (Sink, error) {
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		return OpenFileSink(strings.TrimPrefix(spec, "file:"))
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return NewWebhookSink(spec), nil
+	}
+	return nil, errors.New("unknown cdc sink " + spec + ", expected file:<path> or an http(s) URL")
+}
+
+// Publisher numbers the changes and writes them to a sink in background
+// Like the AOF, a full buffer slows the writes down instead of losing changes
+type Publisher struct {
+	sink     Sink
+	records  chan Record
+	finished chan struct{}
+
+	mu     sync.Mutex
+	seq    uint64
+	closed bool
+}
+
+// NewPublisher starts publishing to the sink
+func NewPublisher(sink Sink) *Publisher {
+	p := &Publisher{
+		sink:     sink,
+		records:  make(chan Record, bufferSize),
+		finished: make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Publish adds a change to the feed, changes published after Close are dropped
+func (p *Publisher) Publish(r Record) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.seq++
+	r.Seq = p.seq
+	// sent under the lock so that the records are in the order of their sequence numbers
+	p.records <- r
+	p.mu.Unlock()
+}
+
+// run writes the pending records to the sink in batches
+func (p *Publisher) run() {
+	defer close(p.finished)
+	const maxBatch = 256
+	batch := make([]Record, 0, maxBatch)
+	for r := range p.records {
+		batch = append(batch[:0], r)
+	pending:
+		for len(batch) < maxBatch {
+			select {
+			case r, ok := <-p.records:
+				if !ok {
+					break pending
+				}
+				batch = append(batch, r)
+			default:
+				break pending
+			}
+		}
+		if err := p.sink.Write(batch); err != nil {
+			logger.Error("cdc: dropped " + strconv.Itoa(len(batch)) + " changes: " + err.Error())
+		}
+	}
+}
+
+// Close publishes the pending changes and closes the sink
+func (p *Publisher) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.records)
+	p.mu.Unlock()
+	<-p.finished
+	if err := p.sink.Close(); err != nil {
+		logger.Error("cdc: close sink: " + err.Error())
+	}
+}
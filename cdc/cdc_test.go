@@ -0,0 +1,118 @@
+package cdc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"redigo/lib/utils"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestMakeRecord(t *testing.T) {
+	r := MakeRecord(2, utils.ToCmdLine("set", "key", "value"), []string{"key"})
+	if r.DB != 2 || r.Command != "SET" || !reflect.DeepEqual(r.Keys, []string{"key"}) ||
+		!reflect.DeepEqual(r.Args, []string{"key", "value"}) || r.Encoding != "" {
+		t.Errorf("unexpected record %+v", r)
+	}
+
+	// a binary argument makes the whole record base64 encoded
+	r = MakeRecord(0, [][]byte{[]byte("SET"), []byte("key"), {0xff, 0xfe}}, []string{"key"})
+	if r.Encoding != "base64" || !reflect.DeepEqual(r.Keys, []string{"a2V5"}) ||
+		!reflect.DeepEqual(r.Args, []string{"a2V5", "//4="}) {
+		t.Errorf("unexpected binary record %+v", r)
+	}
+}
+
+// readLines decodes the JSON lines of the file
+func readLines(t *testing.T, r io.Reader) []Record {
+	t.Helper()
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// TestPublisherFileSink tests that the publisher numbers the records and writes them all before Close returns
+func TestPublisherFileSink(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "changes.jsonl")
+	sink, err := Open("file:" + filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPublisher(sink)
+	const n = 1000
+	for i := 0; i < n; i++ {
+		p.Publish(MakeRecord(0, utils.ToCmdLine("INCR", "counter"), []string{"counter"}))
+	}
+	p.Close()
+	// published after Close, dropped
+	p.Publish(MakeRecord(0, utils.ToCmdLine("INCR", "counter"), []string{"counter"}))
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records := readLines(t, file)
+	if len(records) != n {
+		t.Fatalf("expected %d records, got %d", n, len(records))
+	}
+	for i, r := range records {
+		if r.Seq != uint64(i+1) || r.Command != "INCR" {
+			t.Fatalf("unexpected record %d: %+v", i, r)
+		}
+	}
+}
+
+// TestWebhookSinkRetries tests that a batch rejected by the webhook is posted again
+func TestWebhookSinkRetries(t *testing.T) {
+	var mu sync.Mutex
+	var received []Record
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received = append(received, readLines(t, r.Body)...)
+	}))
+	defer server.Close()
+
+	sink, err := Open(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []Record{
+		MakeRecord(0, utils.ToCmdLine("SET", "a", "1"), []string{"a"}),
+		MakeRecord(0, utils.ToCmdLine("DEL", "a"), []string{"a"}),
+	}
+	if err := sink.Write(records); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 || len(received) != 2 || received[1].Command != "DEL" {
+		t.Errorf("expected the batch after a retry, got %d attempts and %+v", attempts, received)
+	}
+}
+
+func TestOpenUnknownSink(t *testing.T) {
+	if _, err := Open("kafka://localhost"); err == nil {
+		t.Error("expected an error for an unknown sink")
+	}
+}
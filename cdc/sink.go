@@ -0,0 +1,132 @@
+package cdc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// FileSink appends the records to a file as JSON lines
+type FileSink struct {
+	file *os.File
+	w    *bufio.Writer
+}
+
+// OpenFileSink opens the file in append mode, creating it if it does not exist
+func OpenFileSink(filename string) (*FileSink, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file, w: bufio.NewWriter(file)}, nil
+}
+
+// Write appends the records and flushes them to the file
+func (s *FileSink) Write(records []Record) error {
+	if err := writeJSONLines(s.w, records); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	if err := s.w.Flush(); err != nil {
+		_ = s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// WebhookSink posts the records to a URL as JSON lines, a batch per request
+// A batch which is not accepted with a 2xx status after the retries is dropped
+type WebhookSink struct {
+	url     string
+	client  *http.Client
+	retries int
+}
+
+// NewWebhookSink creates a sink posting to the URL
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		url:     url,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		retries: 3,
+	}
+}
+
+// Write posts the records, retrying with a backoff on failure
+func (s *WebhookSink) Write(records []Record) error {
+	var body bytes.Buffer
+	if err := writeJSONLines(&body, records); err != nil {
+		return err
+	}
+	var err error
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+		if err = s.post(body.Bytes()); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *WebhookSink) post(body []byte) error {
+	resp, err := s.client.Post(s.url, "application/x-ndjson", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("webhook replied " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
+
+// Close does nothing, the requests are synchronous
+func (s *WebhookSink) Close() error {
+	return nil
+}
+
+// ChannelSink sends the records to a channel, to consume the feed in the same process
+// The publisher waits for the consumer, which must keep reading until the channel is closed
+type ChannelSink struct {
+	C chan Record
+}
+
+// NewChannelSink creates a sink with a channel of the given capacity
+func NewChannelSink(capacity int) *ChannelSink {
+	return &ChannelSink{C: make(chan Record, capacity)}
+}
+
+// Write sends the records to the channel
+func (s *ChannelSink) Write(records []Record) error {
+	for _, r := range records {
+		s.C <- r
+	}
+	return nil
+}
+
+// Close closes the channel
+func (s *ChannelSink) Close() error {
+	close(s.C)
+	return nil
+}
+
+// writeJSONLines writes a JSON object per record and line
+func writeJSONLines(w interface{ Write([]byte) (int, error) }, records []Record) error {
+	encoder := json.NewEncoder(w)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,47 +1,102 @@
 package cluster
 
 import (
-	"context"
+	"errors"
 	"redigo/config"
 	databaseinstance "redigo/database"
 	"redigo/interface/database"
 	"redigo/interface/resp"
 	consistenthash "redigo/lib/consistent_hash"
-	"redigo/lib/logger"
+	"redigo/resp/client"
 	"redigo/resp/reply"
 	"strings"
-
-	pool "github.com/jolestar/go-commons-pool/v2"
+	"sync"
+	"sync/atomic"
 )
 
+// peerPoolConfig is the connection pool config for each peer node
+var peerPoolConfig = client.PoolConfig{
+	MaxActive: 16,
+	MaxIdle:   16,
+	Wait:      true,
+}
+
 // ClusterDatabase is a cluster instance
 type ClusterDatabase struct {
-	self       string                      // self node id
-	nodes      []string                    // cluster nodes
-	peerPicker *consistenthash.NodeMap     // consistent hash ring
-	peerConn   map[string]*pool.ObjectPool // connection pool for each node
-	db         database.Database           // database instance
+	self      string                  // self node id
+	nodeID    string                  // random ID of the node, sent in the handshake with the peers
+	peerInfos peerRegistry            // identity of each peer, learnt from the handshake
+	nodes     []string                // cluster nodes
+	hashFunc  consistenthash.HashFunc // hash function of the ring
+	hash      string                  // name of the hash function of the ring
+	hashSeed  uint32                  // seed of the hash function
+	peerConn  map[string]*client.Pool // connection pool for each node
+	peerStats map[string]*peerStats   // relay stats for each node
+	slots     *slotTable              // owner of each hash slot with cluster-redirect, nil otherwise
+	health    *healthChecker          // probes of the peers, nil if cluster-probe-interval is not set
+	closed    chan struct{}           // closed by Close to stop the probes
+	closeOnce sync.Once
+	db        database.Database // database instance
+
+	// peerPicker is the consistent hash ring of the nodes, replaced without the failed peers
+	peerPicker atomic.Pointer[consistenthash.NodeMap]
 }
 
 // MakeClusterDatabase creates a new ClusterDatabase instance
 func MakeClusterDatabase() *ClusterDatabase {
+	hash := strings.ToLower(config.Properties.ClusterHash)
+	if hash == "" {
+		hash = consistenthash.HashCRC32
+	}
+	hashFunc, err := consistenthash.NewHashFunc(hash, uint32(config.Properties.ClusterHashSeed))
+	if err != nil {
+		panic(err)
+	}
+	standalone := databaseinstance.NewStandaloneDatabase()
 	cluster := &ClusterDatabase{
-		self:       config.Properties.Self,
-		db:         databaseinstance.NewStandaloneDatabase(),
-		peerPicker: consistenthash.NewNodeMap(nil),
-		peerConn:   make(map[string]*pool.ObjectPool),
+		self:      config.Properties.Self,
+		nodeID:    newNodeID(),
+		db:        standalone,
+		hashFunc:  hashFunc,
+		hash:      hash,
+		hashSeed:  uint32(config.Properties.ClusterHashSeed),
+		peerConn:  make(map[string]*client.Pool),
+		peerStats: make(map[string]*peerStats),
+		health:    makeHealthChecker(config.Properties.Peers),
+		closed:    make(chan struct{}),
 	}
 	nodes := make([]string, 0, len(config.Properties.Peers)+1)
 	nodes = append(nodes, config.Properties.Peers...)
 	nodes = append(nodes, config.Properties.Self)
 	// Add nodes to the consistent hash ring
-	cluster.peerPicker.AddNodes(nodes...)
-	ctx := context.Background()
+	ring := consistenthash.NewNodeMap(hashFunc)
+	ring.AddNodes(nodes...)
+	cluster.peerPicker.Store(ring)
+	if config.Properties.ClusterRedirect {
+		// the clients place the keys with the slots of Redis Cluster
+		if hash != consistenthash.HashCRC16 || config.Properties.ClusterHashSeed != 0 {
+			panic(errors.New("cluster-redirect requires cluster-hash crc16 and cluster-hash-seed 0"))
+		}
+		cluster.slots = makeSlotTable(ring)
+	}
 	// Create connection pools for each peer
+	poolConfig := peerPoolConfig
+	poolConfig.AutoPipeline.Threshold = config.Properties.ClusterAutoPipeline
+	// read when dialing, CONFIG SET requirepass changes it
+	poolConfig.PasswordFunc = func() string {
+		return config.Read(func(p *config.ServerProperties) string { return p.RequirePass })
+	}
 	for _, peer := range config.Properties.Peers {
-		cluster.peerConn[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, &connectionFactory{Peer: peer})
+		peer := peer
+		poolConfig.Handshake = func(c *client.Client) error {
+			return cluster.handshake(peer, c)
+		}
+		cluster.peerConn[peer] = client.MakePool(peer, poolConfig)
+		cluster.peerStats[peer] = makePeerStats()
 	}
 	cluster.nodes = nodes
+	cluster.registerMetrics(standalone)
+	cluster.startHealthChecks()
 	return cluster
 }
 
@@ -53,15 +108,38 @@ var routerMap = makeRouter()
 func (c *ClusterDatabase) Exec(client resp.Connection, args [][]byte) (result resp.Reply) {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Error("ClusterDatabase Exec panic:" + err.(error).Error())
-			result = reply.MakeUnknownReply()
+			result = databaseinstance.PanicReply(args, err)
 		}
 	}()
 
 	cmdName := strings.ToLower(string(args[0]))
+	restoreAsking := false
+	if cmdName == "restore-asking" {
+		// sent by MIGRATE to the node importing the slot of the keys
+		args = append([][]byte{[]byte("RESTORE")}, args[1:]...)
+		cmdName, restoreAsking = "restore", true
+	}
+	// checked before routing, so that the router functions can index the arguments of the command
+	if arity, ok := routeArity(cmdName); ok && !databaseinstance.ValidateArity(arity, args) {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+
+	if c.readsLocally(client, cmdName, args) {
+		return c.db.Exec(client, args)
+	}
+
+	if c.slots != nil {
+		asking := cmdName != "asking" && takeAsking(client) || restoreAsking
+		if result, ok := c.redirect(client, args, asking); ok {
+			return result
+		}
+	}
 
 	if cmdFunc, ok := routerMap[cmdName]; ok {
 		return cmdFunc(c, client, args)
+	} else if databaseinstance.IsModuleCommand(cmdName) {
+		// module commands take the key as first argument
+		return defaultFunc(c, client, args)
 	} else {
 		result = reply.MakeStandardErrorReply("ERR unknown command '" + cmdName + "'")
 	}
@@ -69,9 +147,17 @@ func (c *ClusterDatabase) Exec(client resp.Connection, args [][]byte) (result re
 	return
 }
 
-// Close closes the cluster database
+// Close closes the cluster database: the probes stop, the local database finishes its write
+// commands and drains its AOF, then the connections to the peers are closed, so that the
+// commands relayed until then are answered
 func (c *ClusterDatabase) Close() {
-	c.db.Close()
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.db.Close()
+		for _, pool := range c.peerConn {
+			pool.Close()
+		}
+	})
 }
 
 // AfterClientClose is called after a client closes
@@ -1,41 +1,33 @@
 package cluster
 
 import (
-	"context"
 	"errors"
+	"redigo/config"
+	databaseinstance "redigo/database"
 	"redigo/interface/resp"
+	"redigo/lib/failpoint"
 	"redigo/lib/utils"
 	"redigo/resp/client"
 	"redigo/resp/reply"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// getPeerClient retrieves a client for the specified peer node
-func (c *ClusterDatabase) getPeerClient(peer string) (*client.Client, error) {
+// getRelayClient retrieves a client to relay the command to the specified peer node and the
+// function which gives it back. With automatic pipelining, the commands which cannot block the
+// connection share a client so that the relays of concurrent connections are batched
+func (c *ClusterDatabase) getRelayClient(peer string, args [][]byte) (*client.Client, func(), error) {
 	pool, ok := c.peerConn[peer]
 	if !ok {
-		return nil, errors.New("peer not found")
+		return nil, nil, errors.New("peer not found")
 	}
-	conn, err := pool.BorrowObject(context.Background())
-	if err != nil {
-		return nil, err
-	}
-	// Turn the borrowed object into a client
-	client, ok := conn.(*client.Client)
-	if !ok {
-		return nil, errors.New("invalid connection type")
-	}
-	return client, nil
-}
-
-// returnPeerClient returns a client to the specified peer node
-func (c *ClusterDatabase) returnPeerClient(peer string, client *client.Client) error {
-	pool, ok := c.peerConn[peer]
-	if !ok {
-		return errors.New("peer not found")
+	if config.Properties.ClusterAutoPipeline > 0 && !databaseinstance.IsBlockingCommand(args) {
+		shared, err := pool.Shared()
+		return shared, func() {}, err
 	}
-	// Return the client to the pool
-	return pool.ReturnObject(context.Background(), client)
+	borrowed, err := pool.Get()
+	return borrowed, func() { pool.Put(borrowed) }, err
 }
 
 // relay exec executes a command on the specified peer node
@@ -43,15 +35,60 @@ func (c *ClusterDatabase) relayExec(peer string, conn resp.Connection, args [][]
 	if peer == c.self {
 		return c.db.Exec(conn, args)
 	}
-	client, err := c.getPeerClient(peer)
+	start := time.Now()
+	command := strings.ToLower(string(args[0]))
+	if err := failpoint.Inject(failpoint.ClusterRelay); err != nil {
+		c.recordRelay(peer, command, start, relayError, false)
+		return reply.MakeStandardErrorReply("ERR relay to " + peer + ": " + err.Error())
+	}
+	client, giveBack, err := c.getRelayClient(peer, args)
 	if err != nil {
+		c.recordRelay(peer, command, start, relayError, false)
 		return reply.MakeStandardErrorReply(err.Error())
 	}
-	defer func() {
-		c.returnPeerClient(peer, client)
-	}()
-	client.Send(utils.ToCmdLine("SELECT", strconv.Itoa(conn.GetDBIndex())))
-	return client.Send(args)
+	defer giveBack()
+	// Send SELECT and the command in one flush, only the reply of the command matters
+	replies := client.Pipeline().
+		Queue(utils.ToCmdLine("SELECT", strconv.Itoa(conn.GetDBIndex()))).
+		Queue(args).
+		Exec()
+	// SELECT of a valid DB only fails when the peer did not reply
+	c.recordRelay(peer, command, start, outcomeOf(replies[1]), !reply.IsErrReply(replies[0]))
+	return replies[1]
+}
+
+// relayWithCapability relays an internal command to the peer if the peer has the capability the
+// command depends on, false if it lacks it and the command is not sent. The capabilities are learnt
+// by the handshake of the first connection to the peer
+func (c *ClusterDatabase) relayWithCapability(peer string, conn resp.Connection, capability string, args [][]byte) (resp.Reply, bool) {
+	if c.peerInfos.get(peer) == nil {
+		if _, giveBack, err := c.getRelayClient(peer, args); err == nil {
+			giveBack()
+		}
+	}
+	// a peer not reached yet gets the command, which fails the same way
+	if info := c.peerInfos.get(peer); info != nil && !info.has(capability) {
+		return nil, false
+	}
+	return c.relayExec(peer, conn, args), true
+}
+
+// outcomeOf returns the outcome of a relay from the reply of the command
+func outcomeOf(result resp.Reply) relayOutcome {
+	switch {
+	case client.IsTimeout(result):
+		return relayTimeout
+	case reply.IsErrReply(result):
+		return relayError
+	}
+	return relaySuccess
+}
+
+// recordRelay records the latency and the outcome of a relay of the command to the peer
+func (c *ClusterDatabase) recordRelay(peer string, command string, start time.Time, outcome relayOutcome, replied bool) {
+	if stats, found := c.peerStats[peer]; found {
+		stats.record(command, time.Since(start), outcome, replied)
+	}
 }
 
 // broadcastExec executes a command on all peer nodes
@@ -0,0 +1,168 @@
+package cluster
+
+import (
+	"redigo/config"
+	consistenthash "redigo/lib/consistent_hash"
+	"redigo/lib/logger"
+	"redigo/resp/reply"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultProbeFailures is the number of failed probes in a row marking a peer failed when
+// cluster-probe-failures is not set
+const defaultProbeFailures = 3
+
+// Health of a peer reported by INFO cluster
+const (
+	healthUnknown = "unknown" // the peers are not probed
+	healthOK      = "ok"
+	healthFailed  = "failed"
+)
+
+// peerHealth is the result of the probes of a peer
+type peerHealth struct {
+	failures int  // failed probes in a row
+	failed   bool // removed from the ring
+}
+
+// healthChecker probes the peers with PING and removes the failed ones from the ring, so that
+// their keys are served by the other nodes until they recover
+// The keys written to another node while a peer is failed stay on that node: once the peer is
+// back, they are not moved to it, and the keys the peer held before failing are served again
+type healthChecker struct {
+	interval  time.Duration
+	threshold int
+
+	mu    sync.Mutex
+	peers map[string]*peerHealth
+}
+
+// makeHealthChecker returns the checker configured by cluster-probe-interval, nil if it is disabled
+func makeHealthChecker(peers []string) *healthChecker {
+	if config.Properties.ClusterProbeInterval <= 0 {
+		return nil
+	}
+	threshold := config.Properties.ClusterProbeFailures
+	if threshold <= 0 {
+		threshold = defaultProbeFailures
+	}
+	h := &healthChecker{
+		interval:  time.Duration(config.Properties.ClusterProbeInterval) * time.Millisecond,
+		threshold: threshold,
+		peers:     make(map[string]*peerHealth, len(peers)),
+	}
+	for _, peer := range peers {
+		h.peers[peer] = &peerHealth{}
+	}
+	return h
+}
+
+// record counts the result of a probe of the peer and returns whether the peer changed state
+func (h *healthChecker) record(peer string, ok bool) (changed bool, failed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	health := h.peers[peer]
+	if ok {
+		health.failures = 0
+		changed = health.failed
+		health.failed = false
+		return changed, false
+	}
+	health.failures++
+	if !health.failed && health.failures >= h.threshold {
+		health.failed = true
+		return true, true
+	}
+	return false, health.failed
+}
+
+// healthOf returns the health of the peer reported by INFO cluster
+func (c *ClusterDatabase) healthOf(peer string) string {
+	if c.health == nil {
+		return healthUnknown
+	}
+	c.health.mu.Lock()
+	defer c.health.mu.Unlock()
+	if c.health.peers[peer].failed {
+		return healthFailed
+	}
+	return healthOK
+}
+
+// startHealthChecks probes every peer each interval until the cluster is closed
+func (c *ClusterDatabase) startHealthChecks() {
+	if c.health == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(c.health.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.closed:
+				return
+			case <-ticker.C:
+				c.probePeers()
+			}
+		}
+	}()
+}
+
+// probePeers pings the peers concurrently, a slow peer does not delay the probes of the others,
+// and rebuilds the ring if a peer failed or recovered
+func (c *ClusterDatabase) probePeers() {
+	var mu sync.Mutex
+	changed := false
+	var wg sync.WaitGroup
+	for peer := range c.peerConn {
+		peer := peer
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok := c.probe(peer)
+			peerChanged, failed := c.health.record(peer, ok)
+			if !peerChanged {
+				return
+			}
+			if failed {
+				logger.Warn("peer " + peer + " failed " + strconv.Itoa(c.health.threshold) + " probes, removed from the ring")
+			} else {
+				logger.Info("peer " + peer + " recovered, added back to the ring")
+			}
+			mu.Lock()
+			changed = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if changed {
+		c.rebuildRing()
+	}
+}
+
+// probe sends PING to the peer on the shared client of its pool
+func (c *ClusterDatabase) probe(peer string) bool {
+	cli, err := c.peerConn[peer].Shared()
+	if err != nil {
+		return false
+	}
+	return !reply.IsErrReply(cli.Send([][]byte{[]byte("PING")}))
+}
+
+// rebuildRing places the keys on the nodes which are not failed
+// With cluster-redirect the clients route the keys with the slots, which are only moved by
+// CLUSTER SETSLOT, so the ring is kept
+func (c *ClusterDatabase) rebuildRing() {
+	if c.slots != nil {
+		return
+	}
+	ring := consistenthash.NewNodeMap(c.hashFunc)
+	for _, node := range c.nodes {
+		if node == c.self || c.healthOf(node) != healthFailed {
+			ring.AddNodes(node)
+		}
+	}
+	c.peerPicker.Store(ring)
+}
@@ -0,0 +1,199 @@
+package cluster
+
+import (
+	"errors"
+	"redigo/interface/resp"
+	"redigo/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keyStatsRow is a row of the reply of KEYSTATS on a node, by slot or by node
+type keyStatsRow struct {
+	slot  int64  // set for the rows of KEYSTATS SLOTS
+	node  string // set for the rows of KEYSTATS RING
+	keys  int64
+	bytes int64
+}
+
+// parseKeyStats reads the reply of KEYSTATS SLOTS or KEYSTATS RING of a node
+func parseKeyStats(result resp.Reply) ([]keyStatsRow, error) {
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		return nil, errReply
+	}
+	if _, ok := result.(*reply.EmptyMultiBulkReply); ok {
+		return nil, nil
+	}
+	rows, ok := result.(*reply.MultiRawReply)
+	if !ok {
+		return nil, errors.New("unexpected reply of KEYSTATS")
+	}
+	parsed := make([]keyStatsRow, len(rows.Replies))
+	for i, r := range rows.Replies {
+		fields, ok := r.(*reply.MultiRawReply)
+		if !ok || len(fields.Replies) != 3 {
+			return nil, errors.New("malformed KEYSTATS row")
+		}
+		switch first := fields.Replies[0].(type) {
+		case *reply.IntReply:
+			parsed[i].slot = first.Code
+		case *reply.BulkReply:
+			parsed[i].node = string(first.Arg)
+		default:
+			return nil, errors.New("malformed KEYSTATS row")
+		}
+		keys, ok1 := fields.Replies[1].(*reply.IntReply)
+		bytes, ok2 := fields.Replies[2].(*reply.IntReply)
+		if !ok1 || !ok2 {
+			return nil, errors.New("malformed KEYSTATS row")
+		}
+		parsed[i].keys, parsed[i].bytes = keys.Code, bytes.Code
+	}
+	return parsed, nil
+}
+
+// keyStatsFunc reports where the keys of the cluster are, for capacity planning
+// KEYSTATS SLOTS [SLOTSRANGE start end] sums the slots of every node
+// KEYSTATS NODES [node ...] replies, for each node of the ring made of the given nodes or of the
+// current nodes, [node, keys, bytes, keys-in, bytes-in, keys-out, bytes-out]: the keys it would
+// hold and the keys which would move to and from it, so that the data moved by adding or removing
+// a node is known before changing the peers
+// KEYSTATS RING is answered by the local node
+// The nodes are asked for their own keys with _keystats
+func keyStatsFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	switch strings.ToUpper(string(args[1])) {
+	case "SLOTS":
+		return cluster.keyStatsSlots(conn, args[2:])
+	case "NODES":
+		return cluster.keyStatsNodes(conn, args[2:])
+	case "RING":
+		return cluster.db.Exec(conn, args)
+	}
+	return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[1]) + "'. Try KEYSTATS SLOTS, KEYSTATS NODES or KEYSTATS RING.")
+}
+
+// localKeyStatsFunc answers the KEYSTATS of a peer with the keys of this node only
+func localKeyStatsFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	return cluster.db.Exec(conn, append([][]byte{[]byte("keystats")}, args[1:]...))
+}
+
+// gatherKeyStats runs KEYSTATS with the arguments on every node, relayed to the peers as _keystats
+func (c *ClusterDatabase) gatherKeyStats(conn resp.Connection, args [][]byte) map[string]resp.Reply {
+	results := make(map[string]resp.Reply, len(c.nodes))
+	for _, peer := range c.nodes {
+		if peer == c.self {
+			results[peer] = c.db.Exec(conn, append([][]byte{[]byte("keystats")}, args...))
+		} else if r, ok := c.relayWithCapability(peer, conn, capKeyStats, append([][]byte{[]byte("_keystats")}, args...)); ok {
+			results[peer] = r
+		} else {
+			results[peer] = reply.MakeStandardErrorReply("ERR the node predates KEYSTATS")
+		}
+	}
+	return results
+}
+
+// keyStatsSlots sums KEYSTATS SLOTS of every node by slot
+func (c *ClusterDatabase) keyStatsSlots(conn resp.Connection, args [][]byte) resp.Reply {
+	slots := make(map[int64]*keyStatsRow)
+	for peer, result := range c.gatherKeyStats(conn, append([][]byte{[]byte("SLOTS")}, args...)) {
+		rows, err := parseKeyStats(result)
+		if err != nil {
+			return reply.MakeStandardErrorReply("ERR KEYSTATS of " + peer + ": " + err.Error())
+		}
+		for _, row := range rows {
+			if sum, ok := slots[row.slot]; ok {
+				sum.keys += row.keys
+				sum.bytes += row.bytes
+			} else {
+				row := row
+				slots[row.slot] = &row
+			}
+		}
+	}
+	sorted := make([]int64, 0, len(slots))
+	for s := range slots {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	result := make([]resp.Reply, len(sorted))
+	for i, s := range sorted {
+		result[i] = reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeIntReply(s),
+			reply.MakeIntReply(slots[s].keys),
+			reply.MakeIntReply(slots[s].bytes),
+		})
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// nodeMovement is the keys a node would hold and exchange with the others on a new ring
+type nodeMovement struct {
+	keys, bytes       int64
+	keysIn, bytesIn   int64
+	keysOut, bytesOut int64
+}
+
+// keyStatsNodes asks every node where its keys would be placed on the ring of the nodes, with
+// KEYSTATS RING and the hash function of the cluster
+func (c *ClusterDatabase) keyStatsNodes(conn resp.Connection, args [][]byte) resp.Reply {
+	nodes := c.nodes
+	if len(args) > 0 {
+		nodes = make([]string, len(args))
+		for i, node := range args {
+			nodes[i] = string(node)
+		}
+	}
+	ring := make([][]byte, 0, len(nodes)+3)
+	ring = append(ring, []byte("RING"), []byte(c.hash), []byte(strconv.FormatUint(uint64(c.hashSeed), 10)))
+	for _, node := range nodes {
+		ring = append(ring, []byte(node))
+	}
+
+	// the nodes of the new ring first, then the current nodes leaving it
+	var order []string
+	movements := make(map[string]*nodeMovement)
+	for _, node := range append(append([]string{}, nodes...), c.nodes...) {
+		if _, ok := movements[node]; !ok {
+			movements[node] = &nodeMovement{}
+			order = append(order, node)
+		}
+	}
+	for holder, result := range c.gatherKeyStats(conn, ring) {
+		rows, err := parseKeyStats(result)
+		if err != nil {
+			return reply.MakeStandardErrorReply("ERR KEYSTATS of " + holder + ": " + err.Error())
+		}
+		for _, row := range rows {
+			target, ok := movements[row.node]
+			if !ok {
+				return reply.MakeStandardErrorReply("ERR KEYSTATS of " + holder + ": unknown node " + row.node)
+			}
+			target.keys += row.keys
+			target.bytes += row.bytes
+			if row.node != holder {
+				target.keysIn += row.keys
+				target.bytesIn += row.bytes
+				movements[holder].keysOut += row.keys
+				movements[holder].bytesOut += row.bytes
+			}
+		}
+	}
+
+	result := make([]resp.Reply, len(order))
+	for i, node := range order {
+		m := movements[node]
+		result[i] = reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(node)),
+			reply.MakeIntReply(m.keys),
+			reply.MakeIntReply(m.bytes),
+			reply.MakeIntReply(m.keysIn),
+			reply.MakeIntReply(m.bytesIn),
+			reply.MakeIntReply(m.keysOut),
+			reply.MakeIntReply(m.bytesOut),
+		})
+	}
+	return reply.MakeMultiRawReply(result)
+}
@@ -0,0 +1,245 @@
+package cluster
+
+import (
+	"math"
+	databaseinstance "redigo/database"
+	"redigo/lib/metrics"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// relayOutcome is the result of a relay
+type relayOutcome int
+
+const (
+	relaySuccess relayOutcome = iota // the peer replied
+	relayError                       // the peer replied with an error or could not be reached
+	relayTimeout                     // the peer did not reply in time
+	relayOutcomes
+)
+
+var relayOutcomeNames = [relayOutcomes]string{"success", "error", "timeout"}
+
+// commandStats records the relays of a command to a peer
+type commandStats struct {
+	outcomes [relayOutcomes]uint64
+	latency  *metrics.Histogram // latency in seconds
+}
+
+// peerStats records the relays to a peer, to find slow and broken peers
+type peerStats struct {
+	mu          sync.Mutex
+	relays      uint64        // relayed commands
+	failures    uint64        // relays which did not get a reply from the peer
+	latency     time.Duration // total latency of the relays
+	lastLatency time.Duration
+	linkUp      bool                     // the last relay got a reply
+	commands    map[string]*commandStats // command name -> stats
+}
+
+func makePeerStats() *peerStats {
+	return &peerStats{commands: make(map[string]*commandStats)}
+}
+
+// record records a relay of the command, replied tells whether the peer replied
+func (s *peerStats) record(command string, latency time.Duration, outcome relayOutcome, replied bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.relays++
+	s.latency += latency
+	s.lastLatency = latency
+	s.linkUp = replied
+	if !replied {
+		s.failures++
+	}
+	stats, ok := s.commands[command]
+	if !ok {
+		stats = &commandStats{latency: metrics.NewHistogram(metrics.LatencyBuckets)}
+		s.commands[command] = stats
+	}
+	stats.outcomes[outcome]++
+	stats.latency.Observe(latency.Seconds())
+}
+
+// peerSnapshot is a copy of the stats of a peer with the utilization of its pool
+type peerSnapshot struct {
+	peer        string
+	relays      uint64
+	failures    uint64
+	latency     time.Duration
+	lastLatency time.Duration
+	linkUp      bool
+	outcomes    [relayOutcomes]uint64
+	histogram   *metrics.Histogram       // latency of all commands
+	commands    map[string]*commandStats // copies of the stats of each command
+	active      int
+	idle        int
+	waiting     int
+	info        *peerInfo // identity from the handshake, nil before the first connection
+}
+
+// avgLatency returns the average latency of the relays
+func (s peerSnapshot) avgLatency() time.Duration {
+	if s.relays == 0 {
+		return 0
+	}
+	return s.latency / time.Duration(s.relays)
+}
+
+// peerSnapshots returns the stats of all peers, ordered by address
+func (c *ClusterDatabase) peerSnapshots() []peerSnapshot {
+	snapshots := make([]peerSnapshot, 0, len(c.peerConn))
+	for peer, pool := range c.peerConn {
+		stats := c.peerStats[peer]
+		stats.mu.Lock()
+		snapshot := peerSnapshot{
+			peer:        peer,
+			relays:      stats.relays,
+			failures:    stats.failures,
+			latency:     stats.latency,
+			lastLatency: stats.lastLatency,
+			linkUp:      stats.linkUp,
+			histogram:   metrics.NewHistogram(metrics.LatencyBuckets),
+			commands:    make(map[string]*commandStats, len(stats.commands)),
+		}
+		for command, cmdStats := range stats.commands {
+			for outcome, n := range cmdStats.outcomes {
+				snapshot.outcomes[outcome] += n
+			}
+			snapshot.histogram.Merge(cmdStats.latency)
+			snapshot.commands[command] = &commandStats{outcomes: cmdStats.outcomes, latency: cmdStats.latency.Clone()}
+		}
+		stats.mu.Unlock()
+		poolStats := pool.Stats()
+		snapshot.active, snapshot.idle, snapshot.waiting = poolStats.Active, poolStats.Idle, poolStats.Waiting
+		snapshot.info = c.peerInfos.get(peer)
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].peer < snapshots[j].peer })
+	return snapshots
+}
+
+// linkStatus returns up if the last relay to the peer got a reply, down if it did not, and
+// unknown before the first relay
+func (s peerSnapshot) linkStatus() string {
+	switch {
+	case s.relays == 0:
+		return "unknown"
+	case s.linkUp:
+		return "up"
+	}
+	return "down"
+}
+
+// quantileMicros returns the upper bound of the latency bucket of the q-quantile in microseconds,
+// -1 if it is above all buckets
+func (s peerSnapshot) quantileMicros(q float64) int64 {
+	seconds := s.histogram.Quantile(q)
+	if math.IsInf(seconds, 1) {
+		return -1
+	}
+	return int64(seconds * 1e6)
+}
+
+// infoCluster writes the cluster section of INFO, each peer is reported as
+// peer_<n>:addr=<addr>,link=<status>,relays=<n>,failures=<n>,errors=<n>,timeouts=<n>,
+// avg_latency_us=<n>,last_latency_us=<n>,p50_latency_us=<n>,p99_latency_us=<n>,
+// pool_active=<n>,pool_idle=<n>,pool_waiting=<n>,node_id=<id>,protocol=<n>,capabilities=<a|b>
+// The percentiles are the upper bounds of the buckets of the latency histogram
+func (c *ClusterDatabase) infoCluster(sb *strings.Builder) {
+	sb.WriteString("cluster_enabled:1\r\n")
+	sb.WriteString("cluster_known_nodes:" + strconv.Itoa(len(c.nodes)) + "\r\n")
+	sb.WriteString("cluster_self:" + c.self + "\r\n")
+	sb.WriteString("cluster_node_id:" + c.nodeID + "\r\n")
+	sb.WriteString("cluster_protocol_version:" + strconv.Itoa(peerProtocolVersion) + "\r\n")
+	sb.WriteString("cluster_hash:" + c.hash + "\r\n")
+	if c.slots != nil {
+		sb.WriteString("cluster_mode:redirect\r\n")
+	} else {
+		sb.WriteString("cluster_mode:proxy\r\n")
+	}
+	for i, s := range c.peerSnapshots() {
+		sb.WriteString("peer_" + strconv.Itoa(i) + ":addr=" + s.peer +
+			",link=" + s.linkStatus() +
+			",relays=" + strconv.FormatUint(s.relays, 10) +
+			",failures=" + strconv.FormatUint(s.failures, 10) +
+			",errors=" + strconv.FormatUint(s.outcomes[relayError], 10) +
+			",timeouts=" + strconv.FormatUint(s.outcomes[relayTimeout], 10) +
+			",avg_latency_us=" + strconv.FormatInt(s.avgLatency().Microseconds(), 10) +
+			",last_latency_us=" + strconv.FormatInt(s.lastLatency.Microseconds(), 10) +
+			",p50_latency_us=" + strconv.FormatInt(s.quantileMicros(0.5), 10) +
+			",p99_latency_us=" + strconv.FormatInt(s.quantileMicros(0.99), 10) +
+			",pool_active=" + strconv.Itoa(s.active) +
+			",pool_idle=" + strconv.Itoa(s.idle) +
+			",pool_waiting=" + strconv.Itoa(s.waiting) +
+			",health=" + c.healthOf(s.peer) +
+			s.identity() + "\r\n")
+	}
+}
+
+// identity describes the node of the peer for INFO, unknown before the handshake
+func (s peerSnapshot) identity() string {
+	if s.info == nil {
+		return ",node_id=unknown,protocol=unknown,capabilities="
+	}
+	return ",node_id=" + s.info.idOrUnknown() +
+		",protocol=" + strconv.Itoa(s.info.version) +
+		",capabilities=" + strings.Join(s.info.capabilities(), "|")
+}
+
+// collectMetrics reports the peers to the metrics endpoint
+func (c *ClusterDatabase) collectMetrics(w *metrics.Writer) {
+	snapshots := c.peerSnapshots()
+	var linkUp, relays, failures, latency, active, idle, waiting, commands []metrics.Sample
+	var durations []metrics.HistogramSample
+	for _, s := range snapshots {
+		names := make([]string, 0, len(s.commands))
+		for command := range s.commands {
+			names = append(names, command)
+		}
+		sort.Strings(names)
+		for _, command := range names {
+			stats := s.commands[command]
+			labels := []metrics.Label{{Name: "peer", Value: s.peer}, {Name: "command", Value: command}}
+			for outcome, n := range stats.outcomes {
+				commands = append(commands, metrics.Sample{
+					Labels: append(labels[:2:2], metrics.Label{Name: "outcome", Value: relayOutcomeNames[outcome]}),
+					Value:  float64(n),
+				})
+			}
+			durations = append(durations, metrics.HistogramSample{Labels: labels, Histogram: stats.latency})
+		}
+		labels := []metrics.Label{{Name: "peer", Value: s.peer}}
+		up := 0.0
+		if s.linkUp {
+			up = 1
+		}
+		linkUp = append(linkUp, metrics.Sample{Labels: labels, Value: up})
+		relays = append(relays, metrics.Sample{Labels: labels, Value: float64(s.relays)})
+		failures = append(failures, metrics.Sample{Labels: labels, Value: float64(s.failures)})
+		latency = append(latency, metrics.Sample{Labels: labels, Value: s.latency.Seconds()})
+		active = append(active, metrics.Sample{Labels: labels, Value: float64(s.active)})
+		idle = append(idle, metrics.Sample{Labels: labels, Value: float64(s.idle)})
+		waiting = append(waiting, metrics.Sample{Labels: labels, Value: float64(s.waiting)})
+	}
+	w.Gauge("redigo_cluster_known_nodes", "Number of nodes of the cluster.", metrics.Sample{Value: float64(len(c.nodes))})
+	w.Gauge("redigo_cluster_peer_link_up", "Whether the last relay to the peer got a reply.", linkUp...)
+	w.Counter("redigo_cluster_peer_relays_total", "Commands relayed to the peer.", relays...)
+	w.Counter("redigo_cluster_peer_relay_failures_total", "Relays which got no reply from the peer.", failures...)
+	w.Counter("redigo_cluster_peer_relay_latency_seconds_total", "Total latency of the relays to the peer.", latency...)
+	w.Counter("redigo_cluster_relay_commands_total", "Commands relayed to the peer by outcome: success, error or timeout.", commands...)
+	w.Histogram("redigo_cluster_relay_duration_seconds", "Latency of the commands relayed to the peer.", durations...)
+	w.Gauge("redigo_cluster_peer_pool_active", "Connections to the peer allocated by the pool.", active...)
+	w.Gauge("redigo_cluster_peer_pool_idle", "Idle connections to the peer.", idle...)
+	w.Gauge("redigo_cluster_peer_pool_waiting", "Callers waiting for a connection to the peer.", waiting...)
+}
+
+// registerMetrics adds the cluster section to INFO of the local database and the peers to the
+// metrics endpoint
+func (c *ClusterDatabase) registerMetrics(db *databaseinstance.StandaloneDatabase) {
+	db.RegisterInfoSection("cluster", c.infoCluster)
+	metrics.Register("cluster", c.collectMetrics)
+}
@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"net"
+	"redigo/lib/metrics"
+	"redigo/lib/utils"
+	"redigo/resp/connection"
+	"strings"
+	"testing"
+)
+
+// TestRelayStats tests that the relays to each peer are counted by outcome in INFO cluster and
+// in the metrics
+func TestRelayStats(t *testing.T) {
+	peer := startFakePeer(t, append([]string{"1", "peer-id", "addr"}, peerCapabilities...))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := &fakePeer{addr: listener.Addr().String()}
+	_ = listener.Close()
+	cluster := makeTestCluster(t, nil, peer, down)
+	conn := &connection.Connection{}
+
+	cluster.relayExec(peer.addr, conn, utils.ToCmdLine("GET", "a"))
+	cluster.relayExec(peer.addr, conn, utils.ToCmdLine("GET", "b"))
+	cluster.relayExec(down.addr, conn, utils.ToCmdLine("GET", "c"))
+
+	info := string(cluster.Exec(conn, utils.ToCmdLine("INFO", "cluster")).ToBytes())
+	for _, expected := range []string{
+		"cluster_known_nodes:3\r\n",
+		"cluster_mode:proxy\r\n",
+		"addr=" + peer.addr + ",link=up,relays=2,failures=0,errors=0,timeouts=0,",
+		"addr=" + down.addr + ",link=down,relays=1,failures=1,errors=1,timeouts=0,",
+		"node_id=peer-id,protocol=1,capabilities=keystats|publish|script\r\n",
+	} {
+		if !strings.Contains(info, expected) {
+			t.Errorf("Expected INFO cluster to contain %q, got %q", expected, info)
+		}
+	}
+
+	// the cluster registers its collector once made
+	collected := metrics.Collect()
+	for _, expected := range []string{
+		`redigo_cluster_peer_link_up{peer="` + peer.addr + `"} 1`,
+		`redigo_cluster_peer_relays_total{peer="` + peer.addr + `"} 2`,
+		`redigo_cluster_peer_relay_failures_total{peer="` + down.addr + `"} 1`,
+		`redigo_cluster_relay_commands_total{peer="` + peer.addr + `",command="get",outcome="success"} 2`,
+		`redigo_cluster_relay_commands_total{peer="` + down.addr + `",command="get",outcome="error"} 1`,
+	} {
+		if !strings.Contains(collected, expected+"\n") {
+			t.Errorf("Expected the metrics to contain %q", expected)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/resp/client"
+	"redigo/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// peerProtocolVersion is the version of the internal protocol spoken on the connections between
+// the nodes, increased when the nodes start to depend on a new internal command or reply
+const peerProtocolVersion = 1
+
+// minPeerProtocolVersion is the oldest version of a peer this node works with, version 0 is a node
+// which predates the handshake
+const minPeerProtocolVersion = 0
+
+// The capabilities are the internal features a node offers to its peers, a feature is used with a
+// peer only if both nodes have it
+const (
+	capPublish  = "publish"  // PUBLISH is relayed to the node as _publish
+	capKeyStats = "keystats" // the keys of the node are reported to KEYSTATS by _keystats
+	capScript   = "script"   // SCRIPT LOAD and SCRIPT FLUSH are relayed to the node as _script
+)
+
+// peerCapabilities are the capabilities of this node
+var peerCapabilities = []string{capPublish, capKeyStats, capScript}
+
+// peerInfo is the identity of a peer, learnt from the handshake
+type peerInfo struct {
+	id      string // node ID, empty for a peer which predates the handshake
+	version int    // protocol version negotiated with the peer
+	caps    map[string]struct{}
+}
+
+// peerRegistry holds the identity of each peer
+type peerRegistry struct {
+	mu    sync.Mutex
+	peers map[string]*peerInfo // addr -> identity
+}
+
+func (r *peerRegistry) set(addr string, info *peerInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.peers == nil {
+		r.peers = make(map[string]*peerInfo)
+	}
+	r.peers[addr] = info
+}
+
+// get returns the identity of the peer, nil before the handshake
+func (r *peerRegistry) get(addr string) *peerInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.peers[addr]
+}
+
+// newNodeID returns a random node ID of 40 hexadecimal characters
+func newNodeID() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// helloArgs returns the handshake of this node
+// _peerhello <version> <node-id> <addr> [capability ...]
+func (c *ClusterDatabase) helloArgs() [][]byte {
+	args := [][]byte{
+		[]byte("_peerhello"),
+		[]byte(strconv.Itoa(peerProtocolVersion)),
+		[]byte(c.nodeID),
+		[]byte(c.self),
+	}
+	for _, capability := range peerCapabilities {
+		args = append(args, []byte(capability))
+	}
+	return args
+}
+
+// parseHello parses the version, the node ID, the address and the capabilities of a handshake
+func parseHello(args [][]byte) (*peerInfo, string, error) {
+	if len(args) < 3 {
+		return nil, "", errors.New("handshake has too few fields")
+	}
+	version, err := strconv.Atoi(string(args[0]))
+	if err != nil || version < 0 {
+		return nil, "", errors.New("invalid peer protocol version " + string(args[0]))
+	}
+	info := &peerInfo{
+		id:      string(args[1]),
+		version: version,
+		caps:    make(map[string]struct{}),
+	}
+	for _, capability := range args[3:] {
+		info.caps[strings.ToLower(string(capability))] = struct{}{}
+	}
+	return info, string(args[2]), nil
+}
+
+// negotiate returns the identity of the peer as seen by this node: the lowest of both versions
+// and the capabilities of both nodes
+func negotiate(info *peerInfo) *peerInfo {
+	if info.version > peerProtocolVersion {
+		info.version = peerProtocolVersion
+	}
+	own := make(map[string]struct{}, len(peerCapabilities))
+	for _, capability := range peerCapabilities {
+		own[capability] = struct{}{}
+	}
+	for capability := range info.caps {
+		if _, ok := own[capability]; !ok {
+			delete(info.caps, capability)
+		}
+	}
+	return info
+}
+
+// handshake sends the handshake of this node on a new connection to the peer and records the
+// identity of the peer. A peer which does not know the handshake predates it, it is recorded with
+// version 0 and no capability. A peer older than minPeerProtocolVersion fails the connection
+func (c *ClusterDatabase) handshake(peer string, cli *client.Client) error {
+	r := cli.Send(c.helloArgs())
+	if errReply, ok := r.(reply.ErrorReply); ok {
+		if !strings.HasPrefix(errReply.Error(), "ERR unknown command") {
+			return errors.New("handshake with " + peer + " failed: " + errReply.Error())
+		}
+		return c.acceptPeer(peer, &peerInfo{caps: map[string]struct{}{}})
+	}
+	fields, err := client.GetStringSlice(r)
+	if err != nil {
+		return errors.New("handshake with " + peer + " failed: " + err.Error())
+	}
+	args := make([][]byte, len(fields))
+	for i, field := range fields {
+		args[i] = []byte(field)
+	}
+	info, _, err := parseHello(args)
+	if err != nil {
+		return errors.New("handshake with " + peer + " failed: " + err.Error())
+	}
+	return c.acceptPeer(peer, negotiate(info))
+}
+
+// acceptPeer records the identity of the peer if its version is supported
+func (c *ClusterDatabase) acceptPeer(peer string, info *peerInfo) error {
+	if info.version < minPeerProtocolVersion {
+		return errors.New("peer " + peer + " speaks protocol version " + strconv.Itoa(info.version) +
+			", the minimum is " + strconv.Itoa(minPeerProtocolVersion))
+	}
+	if old := c.peerInfos.get(peer); old == nil || old.id != info.id || old.version != info.version {
+		logger.Info("peer " + peer + " is node " + info.idOrUnknown() + " with protocol version " + strconv.Itoa(info.version))
+	}
+	c.peerInfos.set(peer, info)
+	return nil
+}
+
+func (info *peerInfo) idOrUnknown() string {
+	if info.id == "" {
+		return "unknown"
+	}
+	return info.id
+}
+
+// has reports whether the capability was negotiated with the peer
+func (info *peerInfo) has(capability string) bool {
+	_, ok := info.caps[capability]
+	return ok
+}
+
+// capabilities returns the capabilities in alphabetical order
+func (info *peerInfo) capabilities() []string {
+	caps := make([]string, 0, len(info.caps))
+	for capability := range info.caps {
+		caps = append(caps, capability)
+	}
+	sort.Strings(caps)
+	return caps
+}
+
+// peerHelloFunc answers the handshake of a peer with the handshake of this node, the identity of
+// a known peer is recorded as well
+func peerHelloFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	info, addr, err := parseHello(args[1:])
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR " + err.Error())
+	}
+	if info.version < minPeerProtocolVersion {
+		return reply.MakeStandardErrorReply("ERR peer protocol version " + strconv.Itoa(info.version) +
+			" is not supported, the minimum is " + strconv.Itoa(minPeerProtocolVersion))
+	}
+	if _, known := cluster.peerConn[addr]; known {
+		_ = cluster.acceptPeer(addr, negotiate(info))
+	}
+	return reply.MakeMultiBulkReply(cluster.helloArgs()[1:])
+}
@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"bytes"
+	"net"
+	"redigo/config"
+	"redigo/lib/utils"
+	"redigo/resp/connection"
+	"redigo/resp/parser"
+	"redigo/resp/reply"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakePeer is a node answering the handshake with its capabilities, or as a node predating the
+// handshake if hello is nil. It records the commands other than the handshake and SELECT, replies
+// +OK to MSET, the value "peer" of each key to MGET and :1 to the others
+type fakePeer struct {
+	addr     string
+	mu       sync.Mutex
+	commands []string
+	lines    []string // the commands with their arguments
+}
+
+func startFakePeer(t *testing.T, hello []string) *fakePeer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &fakePeer{addr: listener.Addr().String()}
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		_ = listener.Close()
+		wg.Wait()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				for payload := range parser.ParseStream(conn) {
+					if payload.Err != nil {
+						return
+					}
+					args := payload.Data.(*reply.MultiBulkReply).Args
+					var r []byte
+					switch name := strings.ToLower(string(args[0])); name {
+					case "_peerhello":
+						if hello == nil {
+							r = reply.MakeStandardErrorReply("ERR unknown command '_peerhello'").ToBytes()
+						} else {
+							r = reply.MakeMultiBulkReply(utils.ToCmdLine(hello...)).ToBytes()
+						}
+					case "select", "ping", "auth":
+						r = reply.MakeOKReply().ToBytes()
+					default:
+						peer.mu.Lock()
+						peer.commands = append(peer.commands, name)
+						peer.lines = append(peer.lines, string(bytes.Join(args, []byte(" "))))
+						peer.mu.Unlock()
+						switch name {
+						case "mset":
+							r = reply.MakeOKReply().ToBytes()
+						case "mget":
+							values := make([][]byte, len(args)-1)
+							for i := range values {
+								values[i] = []byte("peer")
+							}
+							r = reply.MakeMultiBulkReply(values).ToBytes()
+						default:
+							r = reply.MakeIntReply(1).ToBytes()
+						}
+					}
+					if _, err := conn.Write(r); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return peer
+}
+
+// received returns the names of the commands received
+func (p *fakePeer) received() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.commands...)
+}
+
+// receivedLines returns the commands received with their arguments
+func (p *fakePeer) receivedLines() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.lines...)
+}
+
+// makeTestCluster creates a node whose peers are the fake peers, configure changes the
+// configuration of the node, which is restored at the end of the test
+func makeTestCluster(t *testing.T, configure func(p *config.ServerProperties), peers ...*fakePeer) *ClusterDatabase {
+	saved := *config.Properties
+	t.Cleanup(func() {
+		*config.Properties = saved
+	})
+	config.Properties.Self = "127.0.0.1:1"
+	config.Properties.Peers = nil
+	for _, peer := range peers {
+		config.Properties.Peers = append(config.Properties.Peers, peer.addr)
+	}
+	if configure != nil {
+		configure(config.Properties)
+	}
+	cluster := MakeClusterDatabase()
+	t.Cleanup(cluster.Close)
+	return cluster
+}
+
+// TestNegotiate tests that the capabilities of a peer are those of both nodes, with the lowest
+// version
+func TestNegotiate(t *testing.T) {
+	info, addr, err := parseHello(utils.ToCmdLine("7", "id", "127.0.0.1:7000", "PUBLISH", "script", "future"))
+	if err != nil || addr != "127.0.0.1:7000" || info.id != "id" {
+		t.Fatalf("Unexpected handshake %+v %s (%v)", info, addr, err)
+	}
+	info = negotiate(info)
+	if info.version != peerProtocolVersion || strings.Join(info.capabilities(), ",") != "publish,script" {
+		t.Errorf("Expected version %d with publish and script, got %d %v", peerProtocolVersion, info.version, info.capabilities())
+	}
+	if !info.has(capPublish) || info.has(capKeyStats) {
+		t.Error("Expected only the negotiated capabilities")
+	}
+	for _, args := range [][]string{{"1", "id"}, {"-1", "id", "addr"}, {"x", "id", "addr"}} {
+		if _, _, err := parseHello(utils.ToCmdLine(args...)); err == nil {
+			t.Errorf("Expected an error for the handshake %q", args)
+		}
+	}
+}
+
+// TestRelayCapabilities tests that the internal commands are only relayed to the peers which
+// negotiated their capability
+func TestRelayCapabilities(t *testing.T) {
+	old := startFakePeer(t, nil)
+	keyStatsOnly := startFakePeer(t, []string{"1", "node", "addr", capKeyStats})
+	full := startFakePeer(t, append([]string{"1", "node", "addr"}, peerCapabilities...))
+	cluster := makeTestCluster(t, nil, old, keyStatsOnly, full)
+	conn := &connection.Connection{}
+
+	if r := cluster.Exec(conn, utils.ToCmdLine("PUBLISH", "channel", "message")); string(r.ToBytes()) != ":1\r\n" {
+		t.Errorf("Expected the message to reach the subscriber of the peer with publish, got %q", r.ToBytes())
+	}
+	cluster.Exec(conn, utils.ToCmdLine("SCRIPT", "FLUSH"))
+	results := cluster.gatherKeyStats(conn, utils.ToCmdLine("SLOTS"))
+
+	if got := old.received(); len(got) != 0 {
+		t.Errorf("Expected no internal command relayed to the peer predating the handshake, got %q", got)
+	}
+	if got := keyStatsOnly.received(); strings.Join(got, ",") != "_keystats" {
+		t.Errorf("Expected only _keystats to be relayed, got %q", got)
+	}
+	if got := full.received(); strings.Join(got, ",") != "_publish,_script,_keystats" {
+		t.Errorf("Expected every internal command to be relayed, got %q", got)
+	}
+	if r, ok := results[old.addr].(reply.ErrorReply); !ok || !strings.Contains(r.Error(), "predates KEYSTATS") {
+		t.Errorf("Expected KEYSTATS to fail for the peer predating it, got %v", results[old.addr])
+	}
+}
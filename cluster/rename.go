@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"errors"
+	"redigo/interface/resp"
+	"redigo/lib/failpoint"
+	"redigo/lib/logger"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// dumpedKey is a value read with DUMP and PTTL to be written on another node
+type dumpedKey struct {
+	payload []byte
+	ttl     int64 // milliseconds, 0 without expiration time
+}
+
+// dumpKey reads the value and the TTL of key on the node, nil if the key does not exist
+func (c *ClusterDatabase) dumpKey(peer string, conn resp.Connection, key string) (*dumpedKey, error) {
+	result := c.relayExec(peer, conn, utils.ToCmdLine("DUMP", key))
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		return nil, errReply
+	}
+	if _, ok := result.(*reply.NullBulkReply); ok {
+		return nil, nil
+	}
+	payload, ok := result.(*reply.BulkReply)
+	if !ok {
+		return nil, errors.New("ERR unexpected reply of DUMP from " + peer)
+	}
+	result = c.relayExec(peer, conn, utils.ToCmdLine("PTTL", key))
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		return nil, errReply
+	}
+	ttl, ok := result.(*reply.IntReply)
+	if !ok {
+		return nil, errors.New("ERR unexpected reply of PTTL from " + peer)
+	}
+	switch {
+	case ttl.Code == -2:
+		// expired between DUMP and PTTL
+		return nil, nil
+	case ttl.Code < 0:
+		return &dumpedKey{payload: payload.Arg}, nil
+	}
+	// 0 would restore the key without expiration time
+	return &dumpedKey{payload: payload.Arg, ttl: max(ttl.Code, 1)}, nil
+}
+
+// restoreKey writes key on the node with RESTORE, replacing its value if replace is set
+func (c *ClusterDatabase) restoreKey(peer string, conn resp.Connection, key string, value *dumpedKey, replace bool) resp.Reply {
+	cmdLine := utils.ToCmdLine("RESTORE", key, strconv.FormatInt(value.ttl, 10))
+	cmdLine = append(cmdLine, value.payload)
+	if replace {
+		cmdLine = append(cmdLine, []byte("REPLACE"))
+	}
+	return c.relayExec(peer, conn, cmdLine)
+}
+
+// renameFunc implements RENAME and RENAMENX in the cluster
+// The keys of the same node are renamed by that node. Otherwise the value is moved: it is read
+// from the node of the source key with DUMP, written to the node of the new key with RESTORE,
+// then deleted from the source node. If the source key cannot be deleted, the previous value of
+// the new key is put back, so that a failure leaves the keys as they were.
+// Unlike a rename on a node, the move is not isolated from the writes of the other clients:
+// a write of the source key between DUMP and the deletion is lost
+func renameFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	src, dest := string(args[1]), string(args[2])
+	srcPeer := cluster.pickNode(src)
+	destPeer := cluster.pickNode(dest)
+	if srcPeer == destPeer {
+		return cluster.relayExec(srcPeer, conn, args)
+	}
+	nx := cmdName == "renamenx"
+
+	value, err := cluster.dumpKey(srcPeer, conn, src)
+	if err != nil {
+		return reply.MakeStandardErrorReply(err.Error())
+	}
+	if value == nil {
+		return reply.MakeStandardErrorReply("ERR no such key")
+	}
+	// RENAMENX never replaces the new key, so there is nothing to put back
+	var previous *dumpedKey
+	if !nx {
+		if previous, err = cluster.dumpKey(destPeer, conn, dest); err != nil {
+			return reply.MakeStandardErrorReply(err.Error())
+		}
+	}
+
+	result := cluster.restoreKey(destPeer, conn, dest, value, !nx)
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		if nx && strings.HasPrefix(errReply.Error(), "BUSYKEY") {
+			return reply.MakeIntReply(0)
+		}
+		return errReply
+	}
+	if err := failpoint.Inject(failpoint.ClusterRename); err != nil {
+		result = reply.MakeStandardErrorReply(err.Error())
+	} else {
+		result = cluster.relayExec(srcPeer, conn, utils.ToCmdLine("DEL", src))
+	}
+	if _, ok := result.(*reply.IntReply); !ok {
+		cluster.rollbackRename(destPeer, conn, dest, previous)
+		return reply.MakeStandardErrorReply("ERR " + cmdName + " of " + src + " failed to delete it from " + srcPeer + ", " + dest + " was rolled back: " + errorText(result))
+	}
+	if nx {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeOKReply()
+}
+
+// rollbackRename restores the value the new key had before a failed move, or deletes the key
+// if it did not exist
+func (c *ClusterDatabase) rollbackRename(peer string, conn resp.Connection, key string, previous *dumpedKey) {
+	var result resp.Reply
+	if previous != nil {
+		result = c.restoreKey(peer, conn, key, previous, true)
+	} else {
+		result = c.relayExec(peer, conn, utils.ToCmdLine("DEL", key))
+	}
+	if reply.IsErrReply(result) {
+		logger.Error("rename rollback of " + key + " on " + peer + " failed: " + errorText(result))
+	}
+}
+
+// errorText returns the message of an error reply, or the encoded reply otherwise
+func errorText(result resp.Reply) string {
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		return errReply.Error()
+	}
+	return strings.TrimSpace(string(result.ToBytes()))
+}
@@ -1,27 +1,92 @@
 package cluster
 
 import (
-	"fmt"
+	databaseinstance "redigo/database"
 	"redigo/datastruct/set"
 	"redigo/interface/resp"
 	"redigo/resp/reply"
+	"strings"
 )
 
 func makeRouter() map[string]CmdFunc {
 	routerMap := make(map[string]CmdFunc)
-	routerMap["exists"] = defaultFunc // exists key
-	routerMap["type"] = defaultFunc   // type key
-	routerMap["set"] = defaultFunc    // set key
-	routerMap["get"] = defaultFunc    // get key
-	routerMap["setnx"] = defaultFunc  // setnx key
-	routerMap["getset"] = defaultFunc // getset key
-
-	routerMap["ping"] = pingFunc     // ping command
-	routerMap["rename"] = renameFunc // rename key
-	routerMap["renamex"] = renameFunc
-	routerMap["flushdb"] = flushDBFunc // flushdb command
-	routerMap["del"] = delFunc         // del key
-	routerMap["select"] = selectFunc   // select database
+	routerMap["exists"] = defaultFunc   // exists key
+	routerMap["type"] = defaultFunc     // type key
+	routerMap["set"] = defaultFunc      // set key
+	routerMap["get"] = defaultFunc      // get key
+	routerMap["setnx"] = defaultFunc    // setnx key
+	routerMap["getset"] = defaultFunc   // getset key
+	routerMap["setex"] = defaultFunc    // setex key seconds value
+	routerMap["psetex"] = defaultFunc   // psetex key milliseconds value
+	routerMap["setrange"] = defaultFunc // setrange key offset value
+	routerMap["getrange"] = defaultFunc // getrange key start end
+	routerMap["lcs"] = defaultFunc      // lcs key1 key2, both keys must be on the same node
+	routerMap["mset"] = msetFunc        // mset key value [key value ...], fanned out to the nodes of the keys
+	routerMap["msetnx"] = msetNXFunc    // msetnx key value [key value ...]
+	routerMap["mget"] = mgetFunc        // mget key [key ...]
+
+	routerMap["append"] = defaultFunc      // append key value
+	routerMap["incr"] = defaultFunc        // incr key
+	routerMap["decr"] = defaultFunc        // decr key
+	routerMap["incrby"] = defaultFunc      // incrby key increment
+	routerMap["decrby"] = defaultFunc      // decrby key decrement
+	routerMap["incrbyfloat"] = defaultFunc // incrbyfloat key increment
+
+	routerMap["expire"] = defaultFunc      // expire key seconds [nx|xx|gt|lt]
+	routerMap["pexpire"] = defaultFunc     // pexpire key milliseconds [nx|xx|gt|lt]
+	routerMap["expireat"] = defaultFunc    // expireat key unix-time-seconds [nx|xx|gt|lt]
+	routerMap["pexpireat"] = defaultFunc   // pexpireat key unix-time-milliseconds [nx|xx|gt|lt]
+	routerMap["ttl"] = defaultFunc         // ttl key
+	routerMap["pttl"] = defaultFunc        // pttl key
+	routerMap["expiretime"] = defaultFunc  // expiretime key
+	routerMap["pexpiretime"] = defaultFunc // pexpiretime key
+	routerMap["persist"] = defaultFunc     // persist key
+	routerMap["touch"] = defaultFunc       // touch key [key ...], all keys must be on the same node
+	routerMap["object"] = defaultFunc      // object idletime key
+
+	routerMap["rename"] = renameFunc   // rename key newkey, moved with DUMP and RESTORE across nodes
+	routerMap["renamenx"] = renameFunc // renamenx key newkey
+
+	routerMap["dump"] = defaultFunc    // dump key
+	routerMap["restore"] = defaultFunc // restore key ttl serialized-value
+	routerMap["migrate"] = defaultFunc // migrate host port key|"" db timeout [KEYS key ...], by the node of the keys
+
+	routerMap["eval"] = defaultFunc    // eval script numkeys [key ...] [arg ...], all keys must be on the same node
+	routerMap["evalsha"] = defaultFunc // evalsha sha1 numkeys [key ...] [arg ...]
+	routerMap["script"] = scriptFunc   // script load|exists|flush
+
+	routerMap["ping"] = pingFunc         // ping command
+	routerMap["echo"] = pingFunc         // echo message
+	routerMap["time"] = pingFunc         // time
+	routerMap["lolwut"] = pingFunc       // lolwut [version v]
+	routerMap["flushdb"] = flushDBFunc   // flushdb command
+	routerMap["del"] = delFunc           // del key
+	routerMap["select"] = selectFunc     // select database
+	routerMap["module"] = pingFunc       // module list, answered by the local node
+	routerMap["command"] = pingFunc      // command [count|list|info|getkeys], the command table of the local node
+	routerMap["memory"] = pingFunc       // memory bigkeys, scans the local node only
+	routerMap["scan"] = pingFunc         // scan cursor [match pattern] [count count] [type type], the keys of the local node
+	routerMap["hotkeys"] = pingFunc      // hotkeys of the local node
+	routerMap["info"] = pingFunc         // info of the local node
+	routerMap["keystats"] = keyStatsFunc // keystats slots|nodes, the keys of every node
+	routerMap["debug"] = debugFunc       // debug reload, debug change-repl-id on the local node
+	routerMap["hello"] = pingFunc        // hello [protover], negotiated with the local node
+	routerMap["backup"] = pingFunc       // backup, snapshot of the local node
+	routerMap["maintenance"] = pingFunc  // maintenance on|off|status of the local node
+	routerMap["config"] = pingFunc       // config get parameter, the configuration of the local node
+	routerMap["save"] = pingFunc         // save, snapshot of the local node to its RDB file
+	routerMap["bgsave"] = pingFunc       // bgsave, snapshot of the local node in background
+	routerMap["lastsave"] = pingFunc     // lastsave of the local node
+	routerMap["bgrewriteaof"] = pingFunc // bgrewriteaof, rewrites the AOF of the local node
+	routerMap["wait"] = pingFunc         // wait numreplicas timeout, for the replicas of the local node
+	routerMap["replicaof"] = pingFunc    // replicaof host port|no one, the local node replicates the master
+	routerMap["slaveof"] = pingFunc      // slaveof host port|no one, alias of replicaof
+	routerMap["psync"] = pingFunc        // psync replicationid offset, sent by a replica of the local node
+	routerMap["replconf"] = pingFunc     // replconf option value, sent by a replica of the local node
+	routerMap["cluster"] = clusterFunc   // cluster keyslot|myid|countkeysinslot|slots|shards|setslot
+	routerMap["asking"] = askingFunc     // asking, before a command on a slot being imported
+	routerMap["readonly"] = readModeFunc // readonly, the reads of the connection are served by a replica
+	routerMap["readwrite"] = readModeFunc
 
 	routerMap["lpush"] = defaultFunc
 	routerMap["rpush"] = defaultFunc
@@ -31,6 +96,16 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["llen"] = defaultFunc
 	routerMap["lindex"] = defaultFunc
 	routerMap["lset"] = defaultFunc
+	routerMap["lpushx"] = defaultFunc    // lpushx key value [value ...]
+	routerMap["rpushx"] = defaultFunc    // rpushx key value [value ...]
+	routerMap["linsert"] = defaultFunc   // linsert key before|after pivot value
+	routerMap["lrem"] = defaultFunc      // lrem key count value
+	routerMap["ltrim"] = defaultFunc     // ltrim key start stop
+	routerMap["lpos"] = defaultFunc      // lpos key value [rank rank] [count num] [maxlen len]
+	routerMap["rpoplpush"] = defaultFunc // rpoplpush source destination, both keys must be on the same node
+	routerMap["lmove"] = defaultFunc     // lmove source destination left|right left|right
+	routerMap["blpop"] = defaultFunc     // blpop key [key ...] timeout
+	routerMap["brpop"] = defaultFunc     // brpop key [key ...] timeout
 
 	// Hash operations
 	routerMap["hset"] = defaultFunc      // hset key field value
@@ -45,6 +120,7 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["hmget"] = defaultFunc     // hmget key field [field ...]
 	routerMap["hmset"] = defaultFunc     // hmset key field value [field value ...]
 	routerMap["hencoding"] = defaultFunc // hencoding key (custom command)
+	routerMap["hscan"] = defaultFunc     // hscan key cursor [match pattern] [count count] [novalues]
 
 	// Set operations
 	routerMap["sadd"] = defaultFunc        // sadd key member [member ...]
@@ -54,6 +130,7 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["srem"] = defaultFunc        // srem key member [member ...]
 	routerMap["spop"] = defaultFunc        // spop key [count]
 	routerMap["srandmember"] = defaultFunc // srandmember key [count]
+	routerMap["sscan"] = defaultFunc       // sscan key cursor [match pattern] [count count]
 
 	// Set operations - multi-key commands (need special handling)
 	routerMap["sunion"] = setUnionFunc               // sunion key [key ...]
@@ -64,46 +141,246 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["sdiffstore"] = setDiffStoreFunc       // sdiffstore destination key [key ...]
 
 	// ZSet operations
-	routerMap["zadd"] = defaultFunc   // zadd key score member [score member ...]
-	routerMap["zscore"] = defaultFunc // zscore key member
-	routerMap["zcard"] = defaultFunc  // zcard key
-	routerMap["zrange"] = defaultFunc // zrange key start stop [WITHSCORES]
-	routerMap["zrem"] = defaultFunc   // zrem key member [member ...]
-	routerMap["zcount"] = defaultFunc // zcount key min max
-	routerMap["zrank"] = defaultFunc  // zrank key member
-
+	routerMap["zadd"] = defaultFunc             // zadd key score member [score member ...]
+	routerMap["zscore"] = defaultFunc           // zscore key member
+	routerMap["zcard"] = defaultFunc            // zcard key
+	routerMap["zrange"] = defaultFunc           // zrange key start stop [WITHSCORES]
+	routerMap["zrem"] = defaultFunc             // zrem key member [member ...]
+	routerMap["zcount"] = defaultFunc           // zcount key min max
+	routerMap["zrank"] = defaultFunc            // zrank key member
+	routerMap["zscan"] = defaultFunc            // zscan key cursor [match pattern] [count count]
+	routerMap["zrevrange"] = defaultFunc        // zrevrange key start stop [WITHSCORES]
+	routerMap["zrevrank"] = defaultFunc         // zrevrank key member
+	routerMap["zrangebyscore"] = defaultFunc    // zrangebyscore key min max [WITHSCORES] [LIMIT offset count]
+	routerMap["zrevrangebyscore"] = defaultFunc // zrevrangebyscore key max min [WITHSCORES] [LIMIT offset count]
+	routerMap["zincrby"] = defaultFunc          // zincrby key increment member
+	routerMap["zremrangebyrank"] = defaultFunc  // zremrangebyrank key start stop
+	routerMap["zremrangebyscore"] = defaultFunc // zremrangebyscore key min max
+	routerMap["zpopmin"] = defaultFunc          // zpopmin key [count]
+	routerMap["zpopmax"] = defaultFunc          // zpopmax key [count]
+
+	// Geo operations
+	routerMap["geoadd"] = defaultFunc    // geoadd key [NX|XX] [CH] longitude latitude member [...]
+	routerMap["geopos"] = defaultFunc    // geopos key member [member ...]
+	routerMap["geodist"] = defaultFunc   // geodist key member1 member2 [unit]
+	routerMap["geosearch"] = defaultFunc // geosearch key FROMMEMBER|FROMLONLAT BYRADIUS|BYBOX [options]
+
+	// Stream operations
+	routerMap["xadd"] = defaultFunc      // xadd key [NOMKSTREAM] [MAXLEN [=|~] n] *|id field value [...]
+	routerMap["xlen"] = defaultFunc      // xlen key
+	routerMap["xtrim"] = defaultFunc     // xtrim key MAXLEN [=|~] threshold
+	routerMap["xrange"] = defaultFunc    // xrange key start end [COUNT count]
+	routerMap["xrevrange"] = defaultFunc // xrevrange key end start [COUNT count]
+	routerMap["xread"] = defaultFunc     // xread [COUNT count] [BLOCK ms] STREAMS key [key ...] id [id ...]
+
+	// Pub/Sub, the subscriptions are held by the node of the connection
+	routerMap["subscribe"] = pingFunc    // subscribe channel [channel ...]
+	routerMap["unsubscribe"] = pingFunc  // unsubscribe [channel ...]
+	routerMap["psubscribe"] = pingFunc   // psubscribe pattern [pattern ...]
+	routerMap["punsubscribe"] = pingFunc // punsubscribe [pattern ...]
+	routerMap["pubsub"] = pingFunc       // pubsub channels|numsub|numpat of the local node
+	routerMap["publish"] = publishFunc   // publish channel message
+	routerMap["_publish"] = localPublishFunc
+	routerMap["_keystats"] = localKeyStatsFunc
+	routerMap["_script"] = localScriptFunc
+
+	// Internal protocol between the nodes
+	routerMap["_peerhello"] = peerHelloFunc // _peerhello version node-id addr [capability ...]
+
+	for name := range routerMap {
+		if _, ok := routeArity(name); !ok {
+			panic("cluster route " + name + " has no arity")
+		}
+	}
 	return routerMap
 }
 
-// defaultFunc is a default function that executes a command on the cluster database
+// serverCommandArity is the arity of the routed commands which the database executes before
+// its command table, in the convention of database.ValidateArity
+var serverCommandArity = map[string]int{
+	"select":       2,  // select index
+	"info":         -1, // info [section ...]
+	"debug":        -2, // debug subcommand [args ...]
+	"hello":        -1, // hello [protover]
+	"backup":       1,  // backup
+	"maintenance":  2,  // maintenance on|off|status
+	"config":       -2, // config subcommand [args ...]
+	"keystats":     -2, // keystats subcommand [args ...]
+	"save":         1,  // save
+	"bgsave":       1,  // bgsave
+	"lastsave":     1,  // lastsave
+	"bgrewriteaof": 1,  // bgrewriteaof
+	"replicaof":    3,  // replicaof host port
+	"slaveof":      3,  // slaveof host port
+	"psync":        3,  // psync replicationid offset
+	"replconf":     -2, // replconf option value [option value ...]
+	"cluster":      -2, // cluster subcommand [args ...]
+	"asking":       1,  // asking
+	"readonly":     1,  // readonly
+	"readwrite":    1,  // readwrite
+	"subscribe":    -2, // subscribe channel [channel ...]
+	"unsubscribe":  -1, // unsubscribe [channel ...]
+	"psubscribe":   -2, // psubscribe pattern [pattern ...]
+	"punsubscribe": -1, // punsubscribe [pattern ...]
+	"pubsub":       -2, // pubsub subcommand [args ...]
+	"publish":      3,  // publish channel message
+	"_publish":     3,  // _publish channel message
+	"_keystats":    -2, // _keystats slots|ring [args ...]
+	"_script":      -2, // _script load|flush [args ...]
+	"_peerhello":   -4, // _peerhello version node-id addr [capability ...]
+}
+
+// routeArity returns the arity of a routed command, from the command table of the database for
+// the commands it holds
+func routeArity(name string) (int, bool) {
+	if arity, ok := serverCommandArity[name]; ok {
+		return arity, true
+	}
+	return databaseinstance.CommandArity(name)
+}
+
+// defaultFunc relays the command to the node of its keys
+// The keys are found with the key specs of the command table, a command whose keys are on
+// different nodes is rejected with CROSSSLOT
+// Note that a blocking BLPOP, BRPOP or XREAD longer than the relay timeout fails when the keys are on another node
 func defaultFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
-	fmt.Println("args:", args)
-	key := string(args[1])
-	peer := cluster.peerPicker.PickNode(key)
+	peer, errReply := cluster.pickNodeOfKeys(args)
+	if errReply != nil {
+		return errReply
+	}
 	return cluster.relayExec(peer, conn, args)
 }
 
+// pickNodeOfKeys returns the node holding all the keys of the command line
+func (c *ClusterDatabase) pickNodeOfKeys(args [][]byte) (string, reply.ErrorReply) {
+	keys, ok := databaseinstance.CommandKeys(args)
+	if !ok || len(keys) == 0 {
+		if len(args) < 2 {
+			return "", reply.MakeArgNumErrReply(strings.ToLower(string(args[0])))
+		}
+		// unknown commands are answered by the node of their first argument
+		keys = []string{string(args[1])}
+	}
+	peer := c.pickNode(keys[0])
+	for _, key := range keys[1:] {
+		if c.pickNode(key) != peer {
+			return "", reply.MakeCrossSlotErrReply()
+		}
+	}
+	return peer, nil
+}
+
+// readOnlyConn is a connection remembering READONLY until READWRITE
+type readOnlyConn interface {
+	SetReadOnly(bool)
+	IsReadOnly() bool
+}
+
+// replicaDB is the local database when it may replicate another node
+type replicaDB interface {
+	IsReplica() bool
+}
+
+// readModeFunc implements READONLY and READWRITE
+// Once a connection sent READONLY, a replica serves its read-only key commands from its own
+// dataset, which may lag behind the master, instead of redirecting or relaying them to the node
+// of the keys. READWRITE restores the routing of the reads. On a master the flag has no effect
+func readModeFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	if c, ok := conn.(readOnlyConn); ok {
+		c.SetReadOnly(strings.ToLower(string(args[0])) == "readonly")
+	}
+	return reply.MakeOKReply()
+}
+
+// readsLocally reports whether the command is a read on keys which the local node serves from its
+// dataset, because it is a replica and the connection sent READONLY
+func (c *ClusterDatabase) readsLocally(conn resp.Connection, cmdName string, args [][]byte) bool {
+	if rc, ok := conn.(readOnlyConn); !ok || !rc.IsReadOnly() {
+		return false
+	}
+	if db, ok := c.db.(replicaDB); !ok || !db.IsReplica() {
+		return false
+	}
+	if !databaseinstance.IsReadOnlyCommand(cmdName) {
+		return false
+	}
+	keys, ok := databaseinstance.CommandKeys(args)
+	return ok && len(keys) > 0
+}
+
+// debugFunc runs DEBUG on the local node, except DEBUG QUICKCHECK key which checks the key on
+// the node holding it
+func debugFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 3 && strings.ToUpper(string(args[1])) == "QUICKCHECK" {
+		return cluster.relayExec(cluster.pickNode(string(args[2])), conn, args)
+	}
+	return cluster.db.Exec(conn, args)
+}
+
 // pingFunc is a function that executes a command on the cluster database
 func pingFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
 	return cluster.db.Exec(conn, args)
 }
 
-// renameFunc is a function that executes a command on the cluster database
-func renameFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+// publishFunc sends the message to the subscribers of every node, it replies the total number
+// of messages sent
+// The message is relayed to the peers as _publish, which they deliver to their own subscribers only
+func publishFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
 	if len(args) != 3 {
-		return reply.MakeStandardErrorReply("ERR wrong number of arguments for 'rename' command")
+		return reply.MakeArgNumErrReply("publish")
 	}
-	src := string(args[1])
-	dest := string(args[2])
+	relayed := append([][]byte{[]byte("_publish")}, args[1:]...)
+	var receivers int64
+	for _, peer := range cluster.nodes {
+		var result resp.Reply
+		if peer == cluster.self {
+			result = cluster.db.Exec(conn, args)
+		} else if r, ok := cluster.relayWithCapability(peer, conn, capPublish, relayed); ok {
+			result = r
+		} else {
+			// a peer predating _publish can not deliver to its subscribers only
+			continue
+		}
+		if n, ok := result.(*reply.IntReply); ok {
+			receivers += n.Code
+		}
+	}
+	return reply.MakeIntReply(receivers)
+}
 
-	srcPeer := cluster.peerPicker.PickNode(src)
-	destPeer := cluster.peerPicker.PickNode(dest)
+// localPublishFunc delivers a message relayed by publishFunc to the subscribers of this node
+func localPublishFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	return cluster.db.Exec(conn, append([][]byte{[]byte("publish")}, args[1:]...))
+}
 
-	if srcPeer != destPeer {
-		return reply.MakeStandardErrorReply("ERR source and destination keys are on different nodes")
+// scriptFunc loads and flushes the scripts on every node, so that EVALSHA finds the script on the
+// node of its keys; SCRIPT EXISTS is answered by the local node
+// The command is relayed to the peers as _script, which they run on their own node only
+func scriptFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	if strings.ToUpper(string(args[1])) == "EXISTS" {
+		return cluster.db.Exec(conn, args)
+	}
+	result := cluster.db.Exec(conn, args)
+	if reply.IsErrReply(result) {
+		return result
+	}
+	relayed := append([][]byte{[]byte("_script")}, args[1:]...)
+	for _, peer := range cluster.nodes {
+		if peer == cluster.self {
+			continue
+		}
+		// a peer predating _script does not get the script, EVALSHA replies NOSCRIPT there and the
+		// clients fall back to EVAL
+		if r, ok := cluster.relayWithCapability(peer, conn, capScript, relayed); ok && reply.IsErrReply(r) {
+			return reply.MakeStandardErrorReply("error: " + r.(reply.ErrorReply).Error())
+		}
 	}
+	return result
+}
 
-	return cluster.relayExec(srcPeer, conn, args)
+// localScriptFunc runs SCRIPT relayed by scriptFunc on this node
+func localScriptFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	return cluster.db.Exec(conn, append([][]byte{[]byte("script")}, args[1:]...))
 }
 
 // flushDBFunc is a function that executes a command on the cluster database
@@ -132,7 +409,7 @@ func delFunc(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Re
 	// If there is only one key, route directly to the corresponding node
 	if len(args) == 2 {
 		key := string(args[1])
-		peer := cluster.peerPicker.PickNode(key)
+		peer := cluster.pickNode(key)
 		// Note: The full command, including "DEL", needs to be passed
 		fullArgs := make([][]byte, 2)
 		fullArgs[0] = []byte("DEL")
@@ -144,7 +421,7 @@ func delFunc(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Re
 	groupedKeys := make(map[string][][]byte) // key: peer address, value: list of keys handled by the peer
 	for i := 1; i < len(args); i++ {         // Iterate over all keys to delete, starting from index 1
 		key := string(args[i])
-		peer := cluster.peerPicker.PickNode(key)
+		peer := cluster.pickNode(key)
 		if _, ok := groupedKeys[peer]; !ok {
 			groupedKeys[peer] = make([][]byte, 0)
 		}
@@ -202,6 +479,117 @@ func delFunc(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Re
 	return reply.MakeIntReply(deleted)
 }
 
+// groupByNode returns the positions in args of the keys held by each node, the keys being the
+// arguments from args[1] every step arguments
+func (c *ClusterDatabase) groupByNode(args [][]byte, step int) map[string][]int {
+	groups := make(map[string][]int)
+	for i := 1; i < len(args); i += step {
+		peer := c.pickNode(string(args[i]))
+		groups[peer] = append(groups[peer], i)
+	}
+	return groups
+}
+
+// nodeCmdLine returns the command line of name with the arguments of a node, each position
+// followed by step-1 arguments
+func nodeCmdLine(name string, args [][]byte, positions []int, step int) [][]byte {
+	cmdLine := make([][]byte, 0, len(positions)*step+1)
+	cmdLine = append(cmdLine, []byte(name))
+	for _, i := range positions {
+		cmdLine = append(cmdLine, args[i:i+step]...)
+	}
+	return cmdLine
+}
+
+// mgetFunc reads the keys of MGET from their nodes with one MGET per node, and replies the
+// values in the order of the keys
+func mgetFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("mget")
+	}
+	groups := cluster.groupByNode(args, 1)
+	if len(groups) == 1 {
+		for peer := range groups {
+			return cluster.relayExec(peer, conn, args)
+		}
+	}
+	values := make([][]byte, len(args)-1)
+	for peer, positions := range groups {
+		nodeReply := cluster.relayExec(peer, conn, nodeCmdLine("MGET", args, positions, 1))
+		if reply.IsErrReply(nodeReply) {
+			return nodeReply
+		}
+		multiBulk, ok := nodeReply.(*reply.MultiBulkReply)
+		if !ok || len(multiBulk.Args) != len(positions) {
+			return reply.MakeStandardErrorReply("ERR unexpected reply of MGET from " + peer)
+		}
+		for j, i := range positions {
+			values[i-1] = multiBulk.Args[j]
+		}
+	}
+	return reply.MakeMultiBulkReply(values)
+}
+
+// msetFunc writes the pairs of MSET to the nodes of their keys with one MSET per node
+// Each node sets its keys at once, but the nodes are written one after the other: the clients may
+// see the new values of a node before those of the others, and a failed node leaves the keys of
+// the nodes written before it set
+func msetFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return reply.MakeArgNumErrReply("mset")
+	}
+	groups := cluster.groupByNode(args, 2)
+	if len(groups) == 1 {
+		for peer := range groups {
+			return cluster.relayExec(peer, conn, args)
+		}
+	}
+	return cluster.msetOnNodes(conn, args, groups)
+}
+
+// msetOnNodes sends MSET with its pairs to each node, it replies the first error
+func (c *ClusterDatabase) msetOnNodes(conn resp.Connection, args [][]byte, groups map[string][]int) resp.Reply {
+	for peer, positions := range groups {
+		if nodeReply := c.relayExec(peer, conn, nodeCmdLine("MSET", args, positions, 2)); reply.IsErrReply(nodeReply) {
+			return reply.MakeStandardErrorReply("ERR MSET failed on " + peer + ", the keys of the other nodes may be set: " + errorText(nodeReply))
+		}
+	}
+	return reply.MakeOKReply()
+}
+
+// msetNXFunc implements MSETNX in the cluster
+// The keys of the same node are set by that node. Otherwise the nodes are asked with EXISTS
+// whether one of the keys exists, and the pairs are written with MSET if none does. Unlike
+// MSETNX on a node, a key created by another client between EXISTS and MSET is overwritten
+func msetNXFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return reply.MakeArgNumErrReply("msetnx")
+	}
+	groups := cluster.groupByNode(args, 2)
+	if len(groups) == 1 {
+		for peer := range groups {
+			return cluster.relayExec(peer, conn, args)
+		}
+	}
+	for peer, positions := range groups {
+		nodeReply := cluster.relayExec(peer, conn, nodeCmdLine("EXISTS", args, positions, 1))
+		if reply.IsErrReply(nodeReply) {
+			return nodeReply
+		}
+		existing, ok := nodeReply.(*reply.IntReply)
+		if !ok {
+			return reply.MakeStandardErrorReply("ERR unexpected reply of EXISTS from " + peer)
+		}
+		if existing.Code > 0 {
+			return reply.MakeIntReply(0)
+		}
+	}
+	if result := cluster.msetOnNodes(conn, args, groups); reply.IsErrReply(result) {
+		return result
+	}
+	return reply.MakeIntReply(1)
+}
+
 // selectFunc is a function that executes a command on the cluster database
 func selectFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
 	return cluster.db.Exec(conn, args)
@@ -219,7 +607,7 @@ func setUnionFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte)
 	// Process each key individually
 	for i := 1; i < len(args); i++ {
 		key := string(args[i])
-		peer := cluster.peerPicker.PickNode(key)
+		peer := cluster.pickNode(key)
 
 		// Create SMEMBERS command for this key
 		smembersArgs := make([][]byte, 2)
@@ -261,7 +649,7 @@ func setUnionStoreFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]
 
 	// Get the destination key and its node
 	destKey := string(args[1])
-	destPeer := cluster.peerPicker.PickNode(destKey)
+	destPeer := cluster.pickNode(destKey)
 
 	// Get the union of source sets
 	sourceArgs := make([][]byte, len(args)-1)
@@ -309,7 +697,7 @@ func setIntersectFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]b
 	// If there's only one key, just return its members
 	if len(args) == 2 {
 		key := string(args[1])
-		peer := cluster.peerPicker.PickNode(key)
+		peer := cluster.pickNode(key)
 
 		// Create SMEMBERS command for this key
 		smembersArgs := make([][]byte, 2)
@@ -325,7 +713,7 @@ func setIntersectFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]b
 	// Process each key separately
 	for i := 1; i < len(args); i++ {
 		key := string(args[i])
-		peer := cluster.peerPicker.PickNode(key)
+		peer := cluster.pickNode(key)
 
 		// Create SMEMBERS command for this key
 		smembersArgs := make([][]byte, 2)
@@ -400,7 +788,7 @@ func setDiffFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte)
 
 	// Get the first set (base set)
 	firstKey := string(args[1])
-	firstPeer := cluster.peerPicker.PickNode(firstKey)
+	firstPeer := cluster.pickNode(firstKey)
 
 	// Create SMEMBERS command for the first key
 	smembersArgs := make([][]byte, 2)
@@ -431,7 +819,7 @@ func setDiffFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte)
 	// Remove members of other sets from the result set
 	for i := 2; i < len(args); i++ {
 		key := string(args[i])
-		peer := cluster.peerPicker.PickNode(key)
+		peer := cluster.pickNode(key)
 
 		// Create SMEMBERS command for this key
 		smembersArgs := make([][]byte, 2)
@@ -475,7 +863,7 @@ func setDiffStoreFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]b
 
 	// Get the destination key and its node
 	destKey := string(args[1])
-	destPeer := cluster.peerPicker.PickNode(destKey)
+	destPeer := cluster.pickNode(destKey)
 
 	// Get the difference of source sets
 	sourceArgs := make([][]byte, len(args)-1)
@@ -527,7 +915,7 @@ func setIntersectStoreFunc(cluster *ClusterDatabase, conn resp.Connection, args
 
 	// Get the destination key and its node
 	destKey := string(args[1])
-	destPeer := cluster.peerPicker.PickNode(destKey)
+	destPeer := cluster.pickNode(destKey)
 
 	// Get the intersection of source sets
 	sourceArgs := make([][]byte, len(args)-1)
@@ -0,0 +1,158 @@
+package cluster
+
+import (
+	"net"
+	"redigo/lib/slot"
+	"redigo/lib/utils"
+	"redigo/resp/connection"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// keysOf returns a key held by each of the nodes, found by trying key0, key1...
+func keysOf(t *testing.T, cluster *ClusterDatabase, nodes ...string) []string {
+	keys := make([]string, len(nodes))
+	for i, node := range nodes {
+		for n := 0; keys[i] == ""; n++ {
+			if n == 10000 {
+				t.Fatalf("No key is held by %s", node)
+			}
+			if key := "key" + strconv.Itoa(n); cluster.pickNode(key) == node {
+				keys[i] = key
+			}
+		}
+	}
+	return keys
+}
+
+// TestRouteKeys tests that the commands are run by the nodes of their keys, the multi-key
+// commands being split by node
+func TestRouteKeys(t *testing.T) {
+	peer := startFakePeer(t, append([]string{"1", "peer-id", "addr"}, peerCapabilities...))
+	cluster := makeTestCluster(t, nil, peer)
+	conn := &connection.Connection{}
+	assert := func(expected string, args ...string) {
+		t.Helper()
+		if got := string(cluster.Exec(conn, utils.ToCmdLine(args...)).ToBytes()); got != expected {
+			t.Errorf("Expected %s to reply %q, got %q", strings.Join(args, " "), expected, got)
+		}
+	}
+	keys := keysOf(t, cluster, cluster.self, peer.addr)
+	local, remote := keys[0], keys[1]
+
+	assert("+OK\r\n", "SET", local, "1")
+	assert("$1\r\n1\r\n", "GET", local)
+	assert(":1\r\n", "GET", remote)
+	assert("*2\r\n$1\r\n1\r\n$4\r\npeer\r\n", "MGET", local, remote)
+	assert("+OK\r\n", "MSET", local, "2", remote, "3")
+	assert("$1\r\n2\r\n", "GET", local)
+	assert(":2\r\n", "DEL", local, remote)
+	assert(":0\r\n", "EXISTS", local)
+	assert("-CROSSSLOT Keys in request don't hash to the same slot\r\n", "LCS", local, remote)
+	assert("-ERR unknown command 'nosuchcommand'\r\n", "NOSUCHCOMMAND", local)
+	assert("-ERR wrong number of arguments for 'get' command\r\n", "GET")
+
+	expected := []string{"GET " + remote, "MGET " + remote, "MSET " + remote + " 3", "DEL " + remote}
+	if got := peer.receivedLines(); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected the peer to receive %q, got %q", expected, got)
+	}
+}
+
+// TestCrossSlot tests that the commands whose keys are on different nodes are rejected with
+// CROSSSLOT before reaching a node, and that those whose keys are on one node are relayed to it
+func TestCrossSlot(t *testing.T) {
+	peer := startFakePeer(t, append([]string{"1", "peer-id", "addr"}, peerCapabilities...))
+	cluster := makeTestCluster(t, nil, peer)
+	conn := &connection.Connection{}
+	keys := keysOf(t, cluster, cluster.self, peer.addr)
+	local, remote := keys[0], keys[1]
+	crossSlot := "-CROSSSLOT Keys in request don't hash to the same slot\r\n"
+	for _, args := range [][]string{
+		{"RPOPLPUSH", local, remote},
+		{"LMOVE", remote, local, "LEFT", "RIGHT"},
+		{"TOUCH", remote, local},
+		{"BLPOP", local, remote, "1"},
+		{"EVAL", "return 1", "2", local, remote},
+		{"XREAD", "STREAMS", local, remote, "0", "0"},
+		{"LCS", local, remote},
+	} {
+		if got := string(cluster.Exec(conn, utils.ToCmdLine(args...)).ToBytes()); got != crossSlot {
+			t.Errorf("Expected %s to reply CROSSSLOT, got %q", args[0], got)
+		}
+	}
+	if got := peer.received(); len(got) != 0 {
+		t.Errorf("Expected no command relayed, got %q", got)
+	}
+
+	if got := string(cluster.Exec(conn, utils.ToCmdLine("TOUCH", remote, remote)).ToBytes()); got != ":1\r\n" {
+		t.Errorf("Expected TOUCH to be relayed to the node of its keys, got %q", got)
+	}
+	if got := peer.receivedLines(); strings.Join(got, ",") != "TOUCH "+remote+" "+remote {
+		t.Errorf("Expected the peer to receive TOUCH, got %q", got)
+	}
+}
+
+// closedAddr returns the host and port of an address nothing listens on
+func closedAddr(t *testing.T) (string, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = listener.Close()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port
+}
+
+// TestReadOnly tests that a replica serves the reads of a READONLY connection on the keys of other
+// nodes from its dataset, in both modes, while the writes and the reads after READWRITE keep
+// being redirected or relayed, and that READONLY has no effect on a master
+func TestReadOnly(t *testing.T) {
+	for _, redirect := range []bool{false, true} {
+		peer := startFakePeer(t, append([]string{"1", "peer-id", "addr"}, peerCapabilities...))
+		var cluster *ClusterDatabase
+		if redirect {
+			cluster = makeRedirectCluster(t, peer)
+		} else {
+			cluster = makeTestCluster(t, nil, peer)
+		}
+		conn := &connection.Connection{}
+		remote := keysOf(t, cluster, peer.addr)[0]
+		routed := ":1\r\n"
+		if redirect {
+			routed = "-MOVED " + strconv.Itoa(slot.KeySlot(remote)) + " " + peer.addr + "\r\n"
+		}
+		assert := func(expected string, args ...string) {
+			t.Helper()
+			if got := string(cluster.Exec(conn, utils.ToCmdLine(args...)).ToBytes()); got != expected {
+				t.Errorf("Expected %s to reply %q with cluster-redirect %v, got %q", strings.Join(args, " "), expected, redirect, got)
+			}
+		}
+		// the key replicated from the peer
+		cluster.db.Exec(conn, utils.ToCmdLine("SET", remote, "replicated"))
+
+		assert("+OK\r\n", "READONLY")
+		assert(routed, "GET", remote)
+		host, port := closedAddr(t)
+		assert("+OK\r\n", "REPLICAOF", host, port)
+		assert("$10\r\nreplicated\r\n", "GET", remote)
+		assert(":1\r\n", "EXISTS", remote)
+		assert(routed, "SET", remote, "1")
+		assert("+OK\r\n", "READWRITE")
+		assert(routed, "GET", remote)
+		// READONLY is kept by the connection only
+		assert("+OK\r\n", "READONLY")
+		if got := string(cluster.Exec(&connection.Connection{}, utils.ToCmdLine("GET", remote)).ToBytes()); got != routed {
+			t.Errorf("Expected the reads of another connection to be routed, got %q", got)
+		}
+		assert("-ERR wrong number of arguments for 'readonly' command\r\n", "READONLY", "x")
+
+		var expected []string
+		if !redirect {
+			expected = []string{"GET " + remote, "SET " + remote + " 1", "GET " + remote, "GET " + remote}
+		}
+		if got := peer.receivedLines(); strings.Join(got, ",") != strings.Join(expected, ",") {
+			t.Errorf("Expected the peer to receive %q, got %q", expected, got)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package cluster
+
+import (
+	"errors"
+	"net"
+	databaseinstance "redigo/database"
+	"redigo/interface/resp"
+	consistenthash "redigo/lib/consistent_hash"
+	"redigo/lib/slot"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// With cluster-redirect, the nodes speak the protocol of Redis Cluster instead of relaying the
+// commands: every node owns ranges of the 16384 hash slots, a command whose keys are owned by
+// another node is answered with -MOVED so that the client sends it there, and the clients learn
+// the slots with CLUSTER SLOTS or CLUSTER SHARDS. The slots start as placed by the crc16 ring of
+// the configured nodes and are moved with CLUSTER SETSLOT: while the keys of a slot are moved with
+// MIGRATE, the source node answers -ASK for the keys it no longer holds, which the client sends to
+// the target node after ASKING.
+
+// errRedirectDisabled is the reply to the commands which need cluster-redirect
+var errRedirectDisabled = reply.MakeStandardErrorReply("ERR This node relays the commands of the cluster, set cluster-redirect yes to use the slots")
+
+// slotTable holds the owner of each hash slot and the slots being moved
+type slotTable struct {
+	mu        sync.RWMutex
+	owners    [slot.SlotCount]string
+	migrating map[int]string // slot -> node receiving its keys, on the owner
+	importing map[int]string // slot -> node sending its keys, on the target
+}
+
+// makeSlotTable places the slots as the ring hashing the keys with crc16 places their keys
+func makeSlotTable(ring *consistenthash.NodeMap) *slotTable {
+	t := &slotTable{
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+	}
+	for i := range t.owners {
+		t.owners[i] = ring.PickNodeOfHash(uint32(i))
+	}
+	return t
+}
+
+func (t *slotTable) owner(s int) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.owners[s]
+}
+
+// state returns the owner of the slot and the nodes it is migrating to or importing from
+func (t *slotTable) state(s int) (owner, migrating, importing string) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.owners[s], t.migrating[s], t.importing[s]
+}
+
+// slotRange is a range of slots owned by the same node
+type slotRange struct {
+	start, end int
+	node       string
+}
+
+// ranges returns the ranges of consecutive slots owned by the same node
+func (t *slotTable) ranges() []slotRange {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var ranges []slotRange
+	for i, node := range t.owners {
+		if n := len(ranges); n > 0 && ranges[n-1].node == node {
+			ranges[n-1].end = i
+			continue
+		}
+		ranges = append(ranges, slotRange{start: i, end: i, node: node})
+	}
+	return ranges
+}
+
+// pickNode returns the node holding the key: the owner of its slot with cluster-redirect, the node
+// of the ring otherwise
+func (c *ClusterDatabase) pickNode(key string) string {
+	if c.slots != nil {
+		return c.slots.owner(slot.KeySlot(key))
+	}
+	return c.peerPicker.Load().PickNode(key)
+}
+
+// askingConn is a connection remembering ASKING for its next command
+type askingConn interface {
+	SetAsking(bool)
+	TakeAsking() bool
+}
+
+// takeAsking returns whether the client sent ASKING before this command, the flag only applies
+// to one command
+func takeAsking(conn resp.Connection) bool {
+	if c, ok := conn.(askingConn); ok {
+		return c.TakeAsking()
+	}
+	return false
+}
+
+// askingFunc implements ASKING, the next command of the connection may access a slot this node
+// is importing
+func askingFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	if c, ok := conn.(askingConn); ok {
+		c.SetAsking(true)
+	}
+	return reply.MakeOKReply()
+}
+
+// redirect executes a command with keys on this node if it serves their slot, or replies with
+// the redirection of the client. It returns false for the commands without keys, which are routed
+// as usual
+func (c *ClusterDatabase) redirect(conn resp.Connection, args [][]byte, asking bool) (resp.Reply, bool) {
+	keys, ok := databaseinstance.CommandKeys(args)
+	if !ok || len(keys) == 0 {
+		return nil, false
+	}
+	s := slot.KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if slot.KeySlot(key) != s {
+			return reply.MakeCrossSlotErrReply(), true
+		}
+	}
+	owner, migrating, importing := c.slots.state(s)
+	if owner != c.self {
+		if importing != "" && asking {
+			return c.db.Exec(conn, args), true
+		}
+		return reply.MakeMovedErrReply(s, owner), true
+	}
+	if migrating != "" {
+		// the keys already moved are asked to the target, a command needs all its keys on one node
+		unique := utils.ToCmdLine(uniqueKeys(keys)...)
+		existing := c.db.Exec(conn, append([][]byte{[]byte("EXISTS")}, unique...))
+		if n, ok := existing.(*reply.IntReply); ok {
+			switch {
+			case n.Code == 0:
+				return reply.MakeAskErrReply(s, migrating), true
+			case n.Code < int64(len(unique)):
+				return reply.MakeStandardErrorReply("TRYAGAIN Multiple keys request during rehashing of slot"), true
+			}
+		}
+	}
+	return c.db.Exec(conn, args), true
+}
+
+func uniqueKeys(keys []string) []string {
+	seen := make(map[string]struct{}, len(keys))
+	unique := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			unique = append(unique, key)
+		}
+	}
+	return unique
+}
+
+// clusterFunc implements CLUSTER
+// CLUSTER KEYSLOT key, CLUSTER MYID and CLUSTER COUNTKEYSINSLOT slot are answered in both modes,
+// CLUSTER SLOTS, CLUSTER SHARDS and CLUSTER SETSLOT need cluster-redirect
+// COUNTKEYSINSLOT walks the keys of the node, it is meant for the tools moving the slots
+func clusterFunc(cluster *ClusterDatabase, conn resp.Connection, args [][]byte) resp.Reply {
+	subCmd := strings.ToUpper(string(args[1]))
+	switch {
+	case subCmd == "KEYSLOT" && len(args) == 3:
+		return reply.MakeIntReply(int64(slot.KeySlot(string(args[2]))))
+	case subCmd == "MYID" && len(args) == 2:
+		return reply.MakeBulkReply([]byte(cluster.nodeID))
+	case subCmd == "COUNTKEYSINSLOT" && len(args) == 3:
+		s, errReply := parseSlot(args[2])
+		if errReply != nil {
+			return errReply
+		}
+		n, err := cluster.countKeysInSlot(conn, s)
+		if err != nil {
+			return reply.MakeStandardErrorReply("ERR " + err.Error())
+		}
+		return reply.MakeIntReply(n)
+	case subCmd == "SLOTS" && len(args) == 2:
+		if cluster.slots == nil {
+			return errRedirectDisabled
+		}
+		return cluster.clusterSlots()
+	case subCmd == "SHARDS" && len(args) == 2:
+		if cluster.slots == nil {
+			return errRedirectDisabled
+		}
+		return cluster.clusterShards()
+	case subCmd == "SETSLOT" && (len(args) == 4 || len(args) == 5):
+		if cluster.slots == nil {
+			return errRedirectDisabled
+		}
+		return cluster.setSlot(conn, args[2:])
+	case subCmd == "KEYSLOT" || subCmd == "MYID" || subCmd == "COUNTKEYSINSLOT" || subCmd == "SLOTS" ||
+		subCmd == "SHARDS" || subCmd == "SETSLOT":
+		return reply.MakeArgNumErrReply("cluster|" + strings.ToLower(subCmd))
+	}
+	return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[1]) + "'. Try CLUSTER KEYSLOT, CLUSTER MYID, " +
+		"CLUSTER COUNTKEYSINSLOT, CLUSTER SLOTS, CLUSTER SHARDS or CLUSTER SETSLOT.")
+}
+
+func parseSlot(arg []byte) (int, reply.ErrorReply) {
+	s, err := strconv.Atoi(string(arg))
+	if err != nil || s < 0 || s >= slot.SlotCount {
+		return 0, reply.MakeStandardErrorReply("ERR Invalid or out of range slot")
+	}
+	return s, nil
+}
+
+// countKeysInSlot returns the number of keys of the slot on this node, in all the DBs
+func (c *ClusterDatabase) countKeysInSlot(conn resp.Connection, s int) (int64, error) {
+	rows, err := parseKeyStats(c.db.Exec(conn, utils.ToCmdLine("KEYSTATS", "SLOTS", "SLOTSRANGE", strconv.Itoa(s), strconv.Itoa(s))))
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) != 1 {
+		return 0, errors.New("unexpected reply of KEYSTATS")
+	}
+	return rows[0].keys, nil
+}
+
+// nodeIdentity returns the ID of the node, the peers are connected to learn it if their
+// handshake did not happen yet. The ID is empty for a node which predates the handshake or is down
+func (c *ClusterDatabase) nodeIdentity(node string) string {
+	if node == c.self {
+		return c.nodeID
+	}
+	if info := c.peerInfos.get(node); info != nil {
+		return info.id
+	}
+	if pool, ok := c.peerConn[node]; ok {
+		if cli, err := pool.Get(); err == nil {
+			pool.Put(cli)
+		}
+	}
+	if info := c.peerInfos.get(node); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// resolveNode returns the address of a node given by its address or its ID
+func (c *ClusterDatabase) resolveNode(name string) (string, bool) {
+	for _, node := range c.nodes {
+		if node == name || (name != "" && c.nodeIdentity(node) == name) {
+			return node, true
+		}
+	}
+	return "", false
+}
+
+// nodeEndpoint splits the address of a node into the IP and the port replied to the clients
+func nodeEndpoint(node string) (string, int64) {
+	host, portStr, err := net.SplitHostPort(node)
+	if err != nil {
+		return node, 0
+	}
+	port, _ := strconv.ParseInt(portStr, 10, 64)
+	return host, port
+}
+
+// clusterSlots replies [start, end, [ip, port, id]] for each range of slots
+func (c *ClusterDatabase) clusterSlots() resp.Reply {
+	ranges := c.slots.ranges()
+	result := make([]resp.Reply, len(ranges))
+	for i, r := range ranges {
+		ip, port := nodeEndpoint(r.node)
+		node := []resp.Reply{reply.MakeBulkReply([]byte(ip)), reply.MakeIntReply(port)}
+		if id := c.nodeIdentity(r.node); id != "" {
+			node = append(node, reply.MakeBulkReply([]byte(id)))
+		}
+		result[i] = reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeIntReply(int64(r.start)),
+			reply.MakeIntReply(int64(r.end)),
+			reply.MakeMultiRawReply(node),
+		})
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// clusterShards replies a shard for each node, made of its slot ranges and of the node itself
+// since the nodes have no replica
+func (c *ClusterDatabase) clusterShards() resp.Reply {
+	slotsOf := make(map[string][]resp.Reply)
+	for _, r := range c.slots.ranges() {
+		slotsOf[r.node] = append(slotsOf[r.node], reply.MakeIntReply(int64(r.start)), reply.MakeIntReply(int64(r.end)))
+	}
+	shards := make([]resp.Reply, len(c.nodes))
+	for i, node := range c.nodes {
+		ip, port := nodeEndpoint(node)
+		description := reply.MakeMapReply(
+			[]resp.Reply{
+				reply.MakeBulkReply([]byte("id")), reply.MakeBulkReply([]byte("port")), reply.MakeBulkReply([]byte("ip")),
+				reply.MakeBulkReply([]byte("endpoint")), reply.MakeBulkReply([]byte("role")),
+				reply.MakeBulkReply([]byte("replication-offset")), reply.MakeBulkReply([]byte("health")),
+			},
+			[]resp.Reply{
+				reply.MakeBulkReply([]byte(c.nodeIdentity(node))), reply.MakeIntReply(port), reply.MakeBulkReply([]byte(ip)),
+				reply.MakeBulkReply([]byte(ip)), reply.MakeBulkReply([]byte("master")),
+				reply.MakeIntReply(0), reply.MakeBulkReply([]byte("online")),
+			})
+		shards[i] = reply.MakeMapReply(
+			[]resp.Reply{reply.MakeBulkReply([]byte("slots")), reply.MakeBulkReply([]byte("nodes"))},
+			[]resp.Reply{reply.MakeMultiRawReply(slotsOf[node]), reply.MakeMultiRawReply([]resp.Reply{description})})
+	}
+	return reply.MakeMultiRawReply(shards)
+}
+
+// setSlot implements CLUSTER SETSLOT slot MIGRATING|IMPORTING|NODE node and CLUSTER SETSLOT slot
+// STABLE, the nodes are given by ID or address
+// A slot is moved by setting it IMPORTING on the target and MIGRATING on the owner, moving its keys
+// with MIGRATE, then assigning it with NODE on every node. The table of each node is changed by
+// the command sent to it only, the nodes do not propagate the changes to each other
+func (c *ClusterDatabase) setSlot(conn resp.Connection, args [][]byte) resp.Reply {
+	s, errReply := parseSlot(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	action := strings.ToUpper(string(args[1]))
+	if action == "STABLE" {
+		if len(args) != 2 {
+			return reply.MakeSyntaxErrReply()
+		}
+		c.slots.mu.Lock()
+		delete(c.slots.migrating, s)
+		delete(c.slots.importing, s)
+		c.slots.mu.Unlock()
+		return reply.MakeOKReply()
+	}
+	if len(args) != 3 || (action != "MIGRATING" && action != "IMPORTING" && action != "NODE") {
+		return reply.MakeSyntaxErrReply()
+	}
+	node, ok := c.resolveNode(string(args[2]))
+	if !ok {
+		return reply.MakeStandardErrorReply("ERR I don't know about node " + string(args[2]))
+	}
+	if action == "NODE" && node != c.self && c.slots.owner(s) == c.self {
+		// checked before locking the table, a key written in the meantime is moved by the next MIGRATE
+		n, err := c.countKeysInSlot(conn, s)
+		if err != nil {
+			return reply.MakeStandardErrorReply("ERR " + err.Error())
+		}
+		if n > 0 {
+			return reply.MakeStandardErrorReply("ERR Can't assign hashslot " + strconv.Itoa(s) +
+				" to a different node while I still hold keys for this hash slot.")
+		}
+	}
+
+	c.slots.mu.Lock()
+	defer c.slots.mu.Unlock()
+	owner := c.slots.owners[s]
+	switch action {
+	case "MIGRATING":
+		if owner != c.self {
+			return reply.MakeStandardErrorReply("ERR I'm not the owner of hash slot " + strconv.Itoa(s))
+		}
+		if node == c.self {
+			return reply.MakeStandardErrorReply("ERR I'm the owner of hash slot " + strconv.Itoa(s))
+		}
+		c.slots.migrating[s] = node
+	case "IMPORTING":
+		if owner == c.self {
+			return reply.MakeStandardErrorReply("ERR I'm already the owner of hash slot " + strconv.Itoa(s))
+		}
+		if node == c.self {
+			return reply.MakeStandardErrorReply("ERR I can't import hash slot " + strconv.Itoa(s) + " from myself")
+		}
+		c.slots.importing[s] = node
+	case "NODE":
+		c.slots.owners[s] = node
+		delete(c.slots.migrating, s)
+		delete(c.slots.importing, s)
+	}
+	return reply.MakeOKReply()
+}
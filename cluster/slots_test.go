@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"redigo/config"
+	consistenthash "redigo/lib/consistent_hash"
+	"redigo/lib/slot"
+	"redigo/lib/utils"
+	"redigo/resp/connection"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestSlotRanges tests that the consecutive slots of a node are grouped in ranges
+func TestSlotRanges(t *testing.T) {
+	table := &slotTable{}
+	for i := range table.owners {
+		table.owners[i] = "a"
+	}
+	table.owners[0] = "b"
+	table.owners[100] = "b"
+	table.owners[101] = "b"
+	ranges := table.ranges()
+	expected := []slotRange{{0, 0, "b"}, {1, 99, "a"}, {100, 101, "b"}, {102, slot.SlotCount - 1, "a"}}
+	if len(ranges) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, ranges)
+	}
+	for i := range expected {
+		if ranges[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], ranges[i])
+		}
+	}
+}
+
+// makeRedirectCluster creates a node with cluster-redirect whose peer is the fake peer
+func makeRedirectCluster(t *testing.T, peer *fakePeer) *ClusterDatabase {
+	return makeTestCluster(t, func(p *config.ServerProperties) {
+		p.ClusterRedirect = true
+		p.ClusterHash = consistenthash.HashCRC16
+		p.ClusterHashSeed = 0
+	}, peer)
+}
+
+// TestSlotRedirect tests the replies of the keys of the slots served by the node, moved to the
+// peer, migrating to it and imported from it
+func TestSlotRedirect(t *testing.T) {
+	peer := startFakePeer(t, append([]string{"1", "peer-id", "addr"}, peerCapabilities...))
+	cluster := makeRedirectCluster(t, peer)
+	conn := &connection.Connection{}
+	exec := func(args ...string) string {
+		return string(cluster.Exec(conn, utils.ToCmdLine(args...)).ToBytes())
+	}
+	assert := func(expected string, args ...string) {
+		t.Helper()
+		if got := exec(args...); got != expected {
+			t.Errorf("Expected %s to reply %q, got %q", strings.Join(args, " "), expected, got)
+		}
+	}
+	s := strconv.Itoa(slot.KeySlot("foo"))
+	moved := "-MOVED " + s + " " + peer.addr + "\r\n"
+
+	assert(":"+s+"\r\n", "CLUSTER", "KEYSLOT", "foo")
+	assert("+OK\r\n", "CLUSTER", "SETSLOT", s, "NODE", cluster.self)
+	assert("+OK\r\n", "SET", "foo", "1")
+	assert("-CROSSSLOT Keys in request don't hash to the same slot\r\n", "MSET", "foo", "1", "bar", "2")
+
+	assert("+OK\r\n", "CLUSTER", "SETSLOT", s, "MIGRATING", peer.addr)
+	assert("$1\r\n1\r\n", "GET", "foo")
+	assert("-ASK "+s+" "+peer.addr+"\r\n", "GET", "{foo}x")
+	assert("-TRYAGAIN Multiple keys request during rehashing of slot\r\n", "MGET", "foo", "{foo}x")
+	if r := exec("CLUSTER", "SETSLOT", s, "NODE", "peer-id"); !strings.HasPrefix(r, "-ERR Can't assign hashslot "+s) {
+		t.Errorf("Expected the slot holding keys not to be assigned, got %q", r)
+	}
+	exec("DEL", "foo")
+	assert("+OK\r\n", "CLUSTER", "SETSLOT", s, "NODE", "peer-id")
+	assert(moved, "GET", "foo")
+
+	assert("+OK\r\n", "CLUSTER", "SETSLOT", s, "IMPORTING", peer.addr)
+	assert(moved, "GET", "foo")
+	assert("+OK\r\n", "ASKING")
+	assert("$-1\r\n", "GET", "foo")
+	// ASKING only applies to the next command
+	assert(moved, "GET", "foo")
+	assert("+OK\r\n", "CLUSTER", "SETSLOT", s, "STABLE")
+	assert("+OK\r\n", "ASKING")
+	assert(moved, "GET", "foo")
+
+	assert("-ERR I don't know about node other\r\n", "CLUSTER", "SETSLOT", s, "NODE", "other")
+	assert("-ERR Invalid or out of range slot\r\n", "CLUSTER", "SETSLOT", strconv.Itoa(slot.SlotCount), "STABLE")
+	if got := peer.received(); len(got) != 0 {
+		t.Errorf("Expected the keys not to be relayed with cluster-redirect, got %q", got)
+	}
+}
+
+// TestClusterSlots tests that CLUSTER SLOTS replies the ranges of the nodes with their ID
+func TestClusterSlots(t *testing.T) {
+	peer := startFakePeer(t, append([]string{"1", "peer-id", "addr"}, peerCapabilities...))
+	cluster := makeRedirectCluster(t, peer)
+	conn := &connection.Connection{}
+	for i := 0; i < slot.SlotCount; i++ {
+		cluster.slots.owners[i] = cluster.self
+	}
+	cluster.Exec(conn, utils.ToCmdLine("CLUSTER", "SETSLOT", "100", "NODE", peer.addr))
+
+	host, port := nodeEndpoint(peer.addr)
+	expected := "*3\r\n" +
+		"*3\r\n:0\r\n:99\r\n*3\r\n$9\r\n127.0.0.1\r\n:1\r\n$40\r\n" + cluster.nodeID + "\r\n" +
+		"*3\r\n:100\r\n:100\r\n*3\r\n$9\r\n" + host + "\r\n:" + strconv.FormatInt(port, 10) + "\r\n$7\r\npeer-id\r\n" +
+		"*3\r\n:101\r\n:16383\r\n*3\r\n$9\r\n127.0.0.1\r\n:1\r\n$40\r\n" + cluster.nodeID + "\r\n"
+	if r := cluster.Exec(conn, utils.ToCmdLine("CLUSTER", "SLOTS")); string(r.ToBytes()) != expected {
+		t.Errorf("Expected %q, got %q", expected, r.ToBytes())
+	}
+
+	relay := makeTestCluster(t, func(p *config.ServerProperties) {
+		p.ClusterRedirect = false
+	}, peer)
+	if r := relay.Exec(conn, utils.ToCmdLine("CLUSTER", "SLOTS")); r != errRedirectDisabled {
+		t.Errorf("Expected CLUSTER SLOTS to need cluster-redirect, got %q", r.ToBytes())
+	}
+}
@@ -0,0 +1,82 @@
+// Command backup copies the dataset of a running redigo server to a local RDB file
+//
+//	go run ./cmd/backup -addr 127.0.0.1:6379 -o backup.rdb
+//
+// It sends BACKUP, streams the snapshot in the reply to a temporary file, checks it with the
+// RDB decoder and renames it, so that a failed backup never replaces a good one
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"redigo/cmd/internal/remote"
+	"redigo/rdb"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "address of the server")
+	output := flag.String("o", "dump.rdb", "file the snapshot is written to")
+	timeout := flag.Duration("timeout", 10*time.Minute, "time limit of the backup")
+	flag.Parse()
+
+	size, err := backup(*addr, *output, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backup failed: "+err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("saved %d bytes to %s\n", size, *output)
+}
+
+// backup writes the snapshot of the server at addr to filename and returns its size
+func backup(addr string, filename string, timeout time.Duration) (int64, error) {
+	snapshot, err := remote.OpenBackup(addr, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = snapshot.Close()
+	}()
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "temp-*.rdb")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if err := copySnapshot(tmp, snapshot); err != nil {
+		_ = tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return snapshot.Size, os.Rename(tmp.Name(), filename)
+}
+
+// copySnapshot copies the snapshot to file and checks that it is valid
+func copySnapshot(file *os.File, snapshot *remote.Backup) error {
+	if _, err := io.CopyN(file, snapshot, snapshot.Size); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	keys := 0
+	err := rdb.NewDecoder(bufio.NewReader(file)).Decode(func(obj *rdb.Object) error {
+		keys++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	fmt.Printf("received %d keys\n", keys)
+	return nil
+}
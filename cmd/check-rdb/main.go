@@ -0,0 +1,121 @@
+// Command check-rdb validates a snapshot before it is restored, like redis-check-rdb
+//
+//	go run ./cmd/check-rdb dump.rdb
+//
+// It decodes every key, verifies the checksum, rejects duplicate keys and trailing data, and
+// prints the number of keys of each type. It exits with status 1 if the snapshot is invalid
+package main
+
+import (
+	"fmt"
+	"os"
+	"redigo/rdb"
+	"sort"
+)
+
+// report is the content of a snapshot
+type report struct {
+	version int
+	aux     map[string]string
+	keys    int
+	expires int
+	types   map[string]int // type name -> keys
+	dbs     map[int]int    // db index -> keys
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: check-rdb <file>")
+		os.Exit(2)
+	}
+	filename := os.Args[1]
+	fmt.Println("checking RDB file " + filename)
+	r, offset, err := check(filename)
+	if err != nil {
+		fmt.Printf("[offset %d] %s\n", offset, err.Error())
+		fmt.Println("RDB ERROR DETECTED")
+		os.Exit(1)
+	}
+	r.print()
+	fmt.Println("RDB looks OK")
+}
+
+// check decodes the file and returns its content, or the error with the offset it was found at
+func check(filename string) (*report, int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r := &report{types: make(map[string]int), dbs: make(map[int]int)}
+	seen := make(map[int]map[string]struct{})
+	dec := rdb.NewDecoder(file)
+	err = dec.Decode(func(obj *rdb.Object) error {
+		keys, ok := seen[obj.DB]
+		if !ok {
+			keys = make(map[string]struct{})
+			seen[obj.DB] = keys
+		}
+		if _, ok := keys[obj.Key]; ok {
+			return fmt.Errorf("%w: duplicate key %q in db %d", rdb.ErrBadFormat, obj.Key, obj.DB)
+		}
+		keys[obj.Key] = struct{}{}
+		r.version = obj.Version
+		r.aux = obj.Aux
+		r.keys++
+		if obj.ExpireAt > 0 {
+			r.expires++
+		}
+		r.types[obj.TypeName()]++
+		r.dbs[obj.DB]++
+		return nil
+	})
+	if err != nil {
+		return nil, dec.Offset(), err
+	}
+	if dec.Offset() != info.Size() {
+		return nil, dec.Offset(), fmt.Errorf("%w: %d bytes after the end of the snapshot",
+			rdb.ErrBadFormat, info.Size()-dec.Offset())
+	}
+	return r, dec.Offset(), nil
+}
+
+func (r *report) print() {
+	if r.version > 0 {
+		fmt.Printf("version: %d\n", r.version)
+	}
+	auxKeys := make([]string, 0, len(r.aux))
+	for key := range r.aux {
+		auxKeys = append(auxKeys, key)
+	}
+	sort.Strings(auxKeys)
+	for _, key := range auxKeys {
+		fmt.Printf("aux %s: %s\n", key, r.aux[key])
+	}
+	fmt.Printf("keys: %d\n", r.keys)
+	fmt.Printf("expires: %d\n", r.expires)
+
+	dbs := make([]int, 0, len(r.dbs))
+	for db := range r.dbs {
+		dbs = append(dbs, db)
+	}
+	sort.Ints(dbs)
+	for _, db := range dbs {
+		fmt.Printf("db%d: %d keys\n", db, r.dbs[db])
+	}
+	types := make([]string, 0, len(r.types))
+	for name := range r.types {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	for _, name := range types {
+		fmt.Printf("%s: %d keys\n", name, r.types[name])
+	}
+}
@@ -0,0 +1,64 @@
+// Package remote reads the snapshot of a running server for the command line tools
+package remote
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backup is the snapshot in the reply of BACKUP, read from the connection as it arrives
+type Backup struct {
+	io.Reader
+	Size int64 // size of the snapshot in bytes
+	conn net.Conn
+}
+
+// OpenBackup sends BACKUP to the server at addr, the whole transfer must end within timeout
+func OpenBackup(addr string, timeout time.Duration) (*Backup, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte("*1\r\n$6\r\nBACKUP\r\n")); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	size, err := readBulkHeader(reader)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &Backup{Reader: io.LimitReader(reader, size), Size: size, conn: conn}, nil
+}
+
+// Close closes the connection to the server
+func (b *Backup) Close() error {
+	return b.conn.Close()
+}
+
+// readBulkHeader reads the $<length> line of the reply, an error reply is returned as an error
+func readBulkHeader(reader *bufio.Reader) (int64, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if strings.HasPrefix(line, "-") {
+		return 0, errors.New(line[1:])
+	}
+	if !strings.HasPrefix(line, "$") {
+		return 0, errors.New("unexpected reply: " + line)
+	}
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New("unexpected reply: " + line)
+	}
+	return size, nil
+}
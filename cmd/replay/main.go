@@ -0,0 +1,260 @@
+// Command replay sends the commands of a traffic capture to a server, at the pace they were
+// received or faster, for load tests with the traffic of a real server
+//
+//	go run ./cmd/replay -addr 127.0.0.1:6380 traffic.cap
+//	go run ./cmd/replay -addr 127.0.0.1:6380 -speed 4 traffic.cap
+//
+// The capture is recorded by a server started with traffic-capture. Each connection of the
+// capture is replayed on its own connection, so the commands of a client keep their order and
+// the clients run concurrently as they did. -speed 2 replays twice as fast, -speed 0 sends the
+// commands as fast as the server answers them
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"redigo/capture"
+	"redigo/resp/client"
+	"redigo/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// queueSize is the number of commands of a connection waiting for the replies of the previous
+// ones, the replay slows down when a connection of the capture falls behind
+const queueSize = 1024
+
+// maxErrorReports is the number of distinct error replies printed
+const maxErrorReports = 10
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "address of the server the capture is replayed to")
+	speed := flag.Float64("speed", 1, "speed of the replay, 1 keeps the pace of the capture, 0 does not wait")
+	password := flag.String("password", "", "password sent with AUTH on each connection")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: replay [flags] <capture>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *speed < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	reader, err := capture.NewReader(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, flag.Arg(0)+": "+err.Error())
+		os.Exit(1)
+	}
+	r := &replayer{addr: *addr, password: *password, speed: *speed, errors: make(map[string]int64)}
+	span, err := r.replay(reader)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay failed: "+err.Error())
+		os.Exit(1)
+	}
+	r.report(span)
+}
+
+// connKey identifies a connection of the capture, the IDs restart with each session
+type connKey struct {
+	session int
+	id      uint64
+}
+
+// replayer sends the commands of each connection of the capture on a connection of its own
+type replayer struct {
+	addr     string
+	password string
+	speed    float64
+
+	conns   map[connKey]chan [][]byte
+	wg      sync.WaitGroup
+	opened  int
+	elapsed time.Duration
+
+	sent   atomic.Int64
+	failed atomic.Int64 // commands not sent, the connection to the server failed
+
+	mu     sync.Mutex
+	errors map[string]int64 // error replies by message
+}
+
+// replay replays the capture and returns the time it spans
+func (r *replayer) replay(reader *capture.Reader) (time.Duration, error) {
+	r.conns = make(map[connKey]chan [][]byte)
+	start := time.Now()
+	session := 0
+	var span time.Duration
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			r.closeAll()
+			return span, err
+		}
+		span = entry.Offset
+		if entry.Session != session {
+			// the server restarted, its clients were disconnected
+			r.closeAll()
+			session = entry.Session
+		}
+		if r.speed > 0 {
+			if wait := time.Duration(float64(entry.Offset)/r.speed) - time.Since(start); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		key := connKey{session: entry.Session, id: entry.ConnID}
+		commands, ok := r.conns[key]
+		if entry.Args == nil {
+			if ok {
+				close(commands)
+				delete(r.conns, key)
+			}
+			continue
+		}
+		if !ok {
+			commands = r.open()
+			r.conns[key] = commands
+		}
+		commands <- entry.Args
+	}
+	r.closeAll()
+	r.elapsed = time.Since(start)
+	return span, nil
+}
+
+// open starts a connection replaying the commands sent to the returned channel
+func (r *replayer) open() chan [][]byte {
+	commands := make(chan [][]byte, queueSize)
+	r.opened++
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		cli, err := r.dial()
+		if err != nil {
+			r.recordError("connection failed: " + err.Error())
+			for range commands {
+				r.failed.Add(1)
+			}
+			return
+		}
+		defer cli.Close()
+		draining := false
+		for args := range commands {
+			err := r.send(cli, args, &draining)
+			r.sent.Add(1)
+			if err != nil {
+				r.recordError(err.Error())
+			}
+		}
+	}()
+	return commands
+}
+
+// send sends a command and returns its error reply. The subscriptions go through the pub/sub API
+// of the client, which discards the messages
+func (r *replayer) send(cli *client.Client, args [][]byte, draining *bool) error {
+	targets := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		targets[i] = string(arg)
+	}
+	var messages <-chan *client.Message
+	var err error
+	switch strings.ToLower(string(args[0])) {
+	case "subscribe":
+		messages, err = cli.Subscribe(targets...)
+	case "psubscribe":
+		messages, err = cli.PSubscribe(targets...)
+	case "unsubscribe":
+		return cli.Unsubscribe(targets...)
+	case "punsubscribe":
+		return cli.PUnsubscribe(targets...)
+	default:
+		if errReply, ok := cli.Send(args).(reply.ErrorReply); ok {
+			return errReply
+		}
+		return nil
+	}
+	if err == nil && !*draining {
+		*draining = true
+		// the channel is closed with the client
+		go func() {
+			for range messages {
+			}
+		}()
+	}
+	return err
+}
+
+// dial connects to the server and authenticates with the password
+func (r *replayer) dial() (*client.Client, error) {
+	cli, err := client.MakeClient(r.addr)
+	if err != nil {
+		return nil, err
+	}
+	cli.Start()
+	if r.password != "" {
+		if errReply, ok := cli.Send([][]byte{[]byte("AUTH"), []byte(r.password)}).(reply.ErrorReply); ok {
+			cli.Close()
+			return nil, errReply
+		}
+	}
+	return cli, nil
+}
+
+// closeAll ends the connections once they sent their commands
+func (r *replayer) closeAll() {
+	for key, commands := range r.conns {
+		close(commands)
+		delete(r.conns, key)
+	}
+	r.wg.Wait()
+}
+
+func (r *replayer) recordError(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors[msg]++
+}
+
+// report prints the number of commands replayed, their rate and the most frequent error replies
+func (r *replayer) report(span time.Duration) {
+	sent := r.sent.Load()
+	rate := float64(sent) / r.elapsed.Seconds()
+	fmt.Printf("replayed %d commands on %d connections in %s (%s ops/sec), the capture spans %s\n",
+		sent, r.opened, r.elapsed.Round(time.Millisecond), strconv.FormatFloat(rate, 'f', 0, 64),
+		span.Round(time.Millisecond))
+	if failed := r.failed.Load(); failed > 0 {
+		fmt.Printf("%d commands not sent\n", failed)
+	}
+	messages := make([]string, 0, len(r.errors))
+	for msg := range r.errors {
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return r.errors[messages[i]] > r.errors[messages[j]]
+	})
+	for i, msg := range messages {
+		if i == maxErrorReports {
+			fmt.Printf("... %d other errors\n", len(messages)-maxErrorReports)
+			break
+		}
+		fmt.Printf("%d x %s\n", r.errors[msg], msg)
+	}
+}
@@ -0,0 +1,194 @@
+// Command verify compares the datasets of two nodes and reports the keys which diverge, to check
+// a replica against its master or a node before and after a migration
+//
+//	go run ./cmd/verify 127.0.0.1:6379 127.0.0.1:6380
+//	go run ./cmd/verify 127.0.0.1:6379 backup.rdb
+//
+// Each side is a server address, whose dataset is read with SCAN, or a snapshot file. Keys are
+// compared by type, value and expire time. Expire times are absolute, they may differ by
+// -ttl-tolerance since the snapshots are not taken at the same instant.
+// It exits with status 1 if the datasets diverge
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"redigo/rdb"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// keyspace maps the keys of each DB to their objects
+type keyspace map[int]map[string]*rdb.Object
+
+func main() {
+	timeout := flag.Duration("timeout", 10*time.Minute, "time limit of reading each dataset")
+	tolerance := flag.Duration("ttl-tolerance", time.Second, "max difference of the expire times of a key")
+	maxReports := flag.Int("max-reports", 100, "max number of divergences printed, 0 prints all")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: verify [flags] <addr|file> <addr|file>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	sources := flag.Args()
+	spaces := make([]keyspace, 2)
+	for i, source := range sources {
+		space, err := load(source, *timeout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "read "+source+": "+err.Error())
+			os.Exit(2)
+		}
+		spaces[i] = space
+	}
+
+	divergences := compare(spaces[0], spaces[1], *tolerance)
+	for i, d := range divergences {
+		if *maxReports > 0 && i == *maxReports {
+			fmt.Printf("... %d more\n", len(divergences)-i)
+			break
+		}
+		fmt.Println(d)
+	}
+	fmt.Printf("%s: %d keys, %s: %d keys, %d divergences\n",
+		sources[0], spaces[0].len(), sources[1], spaces[1].len(), len(divergences))
+	if len(divergences) > 0 {
+		os.Exit(1)
+	}
+}
+
+// load reads the dataset of a server or a snapshot file
+func load(source string, timeout time.Duration) (keyspace, error) {
+	info, err := os.Stat(source)
+	if err != nil || info.IsDir() {
+		return scanServer(source, timeout)
+	}
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	space := make(keyspace)
+	err = rdb.NewDecoder(bufio.NewReader(file)).Decode(func(obj *rdb.Object) error {
+		keys, ok := space[obj.DB]
+		if !ok {
+			keys = make(map[string]*rdb.Object)
+			space[obj.DB] = keys
+		}
+		keys[obj.Key] = normalize(obj)
+		return nil
+	})
+	return space, err
+}
+
+func (s keyspace) len() int {
+	n := 0
+	for _, keys := range s {
+		n += len(keys)
+	}
+	return n
+}
+
+// normalize clears the fields which don't belong to the key and sorts the unordered values
+func normalize(obj *rdb.Object) *rdb.Object {
+	obj.Aux = nil
+	obj.Version = 0
+	sort.Strings(obj.Set)
+	sort.Slice(obj.ZSet, func(i, j int) bool { return obj.ZSet[i].Member < obj.ZSet[j].Member })
+	return obj
+}
+
+// compare returns the divergences of the keyspaces, ordered by DB and key
+func compare(a, b keyspace, tolerance time.Duration) []string {
+	dbs := make(map[int]struct{})
+	for db := range a {
+		dbs[db] = struct{}{}
+	}
+	for db := range b {
+		dbs[db] = struct{}{}
+	}
+	sortedDBs := make([]int, 0, len(dbs))
+	for db := range dbs {
+		sortedDBs = append(sortedDBs, db)
+	}
+	sort.Ints(sortedDBs)
+
+	var divergences []string
+	for _, db := range sortedDBs {
+		keys := make(map[string]struct{})
+		for key := range a[db] {
+			keys[key] = struct{}{}
+		}
+		for key := range b[db] {
+			keys[key] = struct{}{}
+		}
+		sortedKeys := make([]string, 0, len(keys))
+		for key := range keys {
+			sortedKeys = append(sortedKeys, key)
+		}
+		sort.Strings(sortedKeys)
+		for _, key := range sortedKeys {
+			if d := compareKey(a[db][key], b[db][key], tolerance); d != "" {
+				divergences = append(divergences, "db"+strconv.Itoa(db)+" "+strconv.Quote(key)+": "+d)
+			}
+		}
+	}
+	return divergences
+}
+
+// compareKey describes how the key differs on both sides, or returns an empty string
+func compareKey(a, b *rdb.Object, tolerance time.Duration) string {
+	switch {
+	case b == nil:
+		return "only on the first side"
+	case a == nil:
+		return "only on the second side"
+	case a.Type != b.Type:
+		return "type " + a.TypeName() + " vs " + b.TypeName()
+	}
+	if d := compareExpire(a.ExpireAt, b.ExpireAt, tolerance); d != "" {
+		return d
+	}
+	expireA, expireB := a.ExpireAt, b.ExpireAt
+	a.ExpireAt, b.ExpireAt = 0, 0
+	equal := reflect.DeepEqual(a, b)
+	a.ExpireAt, b.ExpireAt = expireA, expireB
+	if !equal {
+		return a.TypeName() + " values differ"
+	}
+	return ""
+}
+
+func compareExpire(a, b int64, tolerance time.Duration) string {
+	switch {
+	case a == 0 && b == 0:
+		return ""
+	case a == 0:
+		return "no expire vs expire at " + formatMs(b)
+	case b == 0:
+		return "expire at " + formatMs(a) + " vs no expire"
+	}
+	diff := time.Duration(a-b) * time.Millisecond
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > tolerance {
+		return "expire at " + formatMs(a) + " vs " + formatMs(b)
+	}
+	return ""
+}
+
+func formatMs(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"redigo/lib/utils"
+	"redigo/rdb"
+	"redigo/resp/client"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scanCount is the COUNT of each SCAN, the keys of a batch are read with one pipeline
+const scanCount = 100
+
+// scanServer reads the dataset of the server at addr with SCAN, then DUMP and PTTL for each key
+// Unlike KEYS, each SCAN only walks a few buckets so that the server keeps serving its clients.
+// A key changed during the scan is read as it is when its batch is reached, a key added or
+// removed meanwhile may be missed
+func scanServer(addr string, timeout time.Duration) (keyspace, error) {
+	deadline := time.Now().Add(timeout)
+	cli, err := client.MakeClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	cli.Start()
+	defer cli.Close()
+
+	dbs, err := keyspaceDBs(cli)
+	if err != nil {
+		return nil, err
+	}
+	space := make(keyspace)
+	for _, db := range dbs {
+		if errReply, ok := cli.Send(utils.ToCmdLine("SELECT", strconv.Itoa(db))).(reply.ErrorReply); ok {
+			return nil, errReply
+		}
+		keys := make(map[string]*rdb.Object)
+		space[db] = keys
+		cursor := "0"
+		for {
+			if time.Now().After(deadline) {
+				return nil, errors.New("timeout scanning db" + strconv.Itoa(db))
+			}
+			next, batch, err := scanBatch(cli, cursor)
+			if err != nil {
+				return nil, err
+			}
+			if err := readKeys(cli, db, batch, keys); err != nil {
+				return nil, err
+			}
+			if next == "0" {
+				break
+			}
+			cursor = next
+		}
+	}
+	return space, nil
+}
+
+// keyspaceDBs returns the DBs holding keys, read from the dbN lines of INFO keyspace
+func keyspaceDBs(cli *client.Client) ([]int, error) {
+	info, err := client.GetString(cli.Send(utils.ToCmdLine("INFO", "keyspace")))
+	if err != nil {
+		return nil, err
+	}
+	var dbs []int
+	for _, line := range strings.Split(info, "\r\n") {
+		name, _, ok := strings.Cut(line, ":")
+		if !ok || !strings.HasPrefix(name, "db") {
+			continue
+		}
+		if db, err := strconv.Atoi(name[2:]); err == nil {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs, nil
+}
+
+// scanBatch sends SCAN and returns the next cursor with the keys of the batch
+func scanBatch(cli *client.Client, cursor string) (string, []string, error) {
+	result := cli.Send(utils.ToCmdLine("SCAN", cursor, "COUNT", strconv.Itoa(scanCount)))
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		return "", nil, errReply
+	}
+	multi, ok := result.(*reply.MultiRawReply)
+	if !ok || len(multi.Replies) != 2 {
+		return "", nil, errors.New("unexpected reply of SCAN")
+	}
+	next, err := client.GetString(multi.Replies[0])
+	if err != nil {
+		return "", nil, err
+	}
+	keys, err := client.GetStringSlice(multi.Replies[1])
+	if err != nil {
+		return "", nil, err
+	}
+	return next, keys, nil
+}
+
+// readKeys reads the value and the TTL of the keys with one pipeline, the keys removed since
+// they were scanned are skipped
+func readKeys(cli *client.Client, db int, batch []string, keys map[string]*rdb.Object) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	pipeline := cli.Pipeline()
+	for _, key := range batch {
+		pipeline.Queue(utils.ToCmdLine("DUMP", key)).Queue(utils.ToCmdLine("PTTL", key))
+	}
+	now := time.Now()
+	replies := pipeline.Exec()
+	for i, key := range batch {
+		dump, ttl := replies[2*i], replies[2*i+1]
+		if _, ok := dump.(*reply.NullBulkReply); ok {
+			continue
+		}
+		payload, ok := dump.(*reply.BulkReply)
+		if !ok {
+			return errors.New("DUMP " + strconv.Quote(key) + ": unexpected reply " + strings.TrimSpace(string(dump.ToBytes())))
+		}
+		pttl, err := client.GetInt(ttl)
+		if err != nil {
+			return errors.New("PTTL " + strconv.Quote(key) + ": " + err.Error())
+		}
+		if pttl == -2 {
+			continue
+		}
+		obj, err := rdb.DecodeDump(payload.Arg)
+		if err != nil {
+			return errors.New("DUMP " + strconv.Quote(key) + ": " + err.Error())
+		}
+		obj.DB, obj.Key = db, key
+		if pttl >= 0 {
+			obj.ExpireAt = now.UnixMilli() + pttl
+		}
+		keys[key] = normalize(obj)
+	}
+	return nil
+}
@@ -21,8 +21,125 @@ type ServerProperties struct {
 	Databases      int      `cfg:"databases"`
 	Peers          []string `cfg:"peers"`
 	Self           string   `cfg:"self"`
+	// CommandTimeout is the execution time limit in milliseconds of KEYS and the SCAN commands, which
+	// stop with an error past it; the watchdog logs the other commands running longer. 0 means no limit
+	CommandTimeout int `cfg:"command-timeout"`
+	// LuaTimeLimit is the execution time limit of a script in milliseconds, 5000 by default
+	LuaTimeLimit int `cfg:"lua-time-limit"`
+	// TrackHotKeys enables counting key accesses for HOTKEYS and INFO hotkeys
+	TrackHotKeys bool `cfg:"track-hot-keys"`
+	// RDBFilename is the snapshot file written by SAVE, BGSAVE and DEBUG RELOAD, dump.rdb by default
+	RDBFilename string `cfg:"dbfilename"`
+	// MetricsPort serves the Prometheus metrics at /metrics on the bind address, 0 disables it
+	MetricsPort int `cfg:"metrics-port"`
+	// ProtoMaxBulkLen is the max length of a bulk string sent by clients, 512MB by default
+	ProtoMaxBulkLen int `cfg:"proto-max-bulk-len"`
+	// MaxRequestSize is the max size of a request sent by clients in bytes, 1GB by default
+	MaxRequestSize int `cfg:"max-request-size"`
+	// MaxWriteElements is the max number of elements of a write command, 0 means no limit
+	MaxWriteElements int `cfg:"max-write-elements"`
+	// UserMaxOpsPerSecond, UserMaxConnections and UserMaxWritesPerSecond are the limits of the
+	// default user, which all connections use, 0 means no limit
+	UserMaxOpsPerSecond    int `cfg:"user-max-ops-per-second"`
+	UserMaxConnections     int `cfg:"user-max-connections"`
+	UserMaxWritesPerSecond int `cfg:"user-max-writes-per-second"`
+	// Users are the users other than the default one, separated by commas, each with its name,
+	// its password and its limits: alice secret maxopspersecond=100 maxconnections=10
+	Users []string `cfg:"users"`
+	// ReusePort listens with SO_REUSEPORT, so that a new process can start on the same port before
+	// the old one shuts down. The kernel spreads the new connections among the processes and each
+	// has its own dataset, so it is only meant for the overlap of an upgrade: the processes must
+	// not keep serving the same port, their clients would see different data
+	ReusePort bool `cfg:"reuse-port"`
+	// ShutdownDrainTimeout is how long a shutting down server waits for its clients to disconnect
+	// in milliseconds, 0 closes them immediately
+	ShutdownDrainTimeout int `cfg:"shutdown-drain-timeout"`
+	// CDCSink receives the change feed of the write commands: file:<path> appends JSON lines to the
+	// file, an http(s) URL receives them in POST requests, empty disables the feed
+	CDCSink string `cfg:"cdc-sink"`
+	// ReadOnly starts the server in maintenance mode, rejecting the write commands until MAINTENANCE OFF
+	ReadOnly bool `cfg:"read-only"`
+	// IntegrityCheck verifies the dataset after it is loaded: no (default), report or abort
+	IntegrityCheck string `cfg:"integrity-check"`
+	// WriteTimeout is the max time to write a reply to a client in milliseconds, 10s by default
+	// A client which does not read its replies in time is disconnected
+	WriteTimeout int `cfg:"write-timeout"`
+	// Dir is the directory of the snapshot file when dbfilename is relative, and of the AOF when
+	// appenddirname is relative, the working directory by default
+	Dir string `cfg:"dir"`
+	// AppendDirname is the directory of the AOF file when appendfilename is relative, it is
+	// created at startup if it does not exist
+	AppendDirname string `cfg:"appenddirname"`
+	// AutoAofRewritePercentage rewrites the AOF when it grew by this percentage since the last
+	// rewrite, 0 disables the automatic rewrites
+	AutoAofRewritePercentage int `cfg:"auto-aof-rewrite-percentage"`
+	// AutoAofRewriteMinSize is the min size of the AOF in bytes for an automatic rewrite, 64MB by default
+	AutoAofRewriteMinSize int `cfg:"auto-aof-rewrite-min-size"`
+	// AofBufferSize is the number of commands waiting for the AOF writer, 65536 by default
+	AofBufferSize int `cfg:"aof-buffer-size"`
+	// AofBackpressure is what happens to the write commands once aof-buffer-size commands wait for
+	// the AOF writer: block (the default) waits, spill keeps them in a temporary file next to the
+	// AOF, reject fails the write commands with an OOM error
+	AofBackpressure string `cfg:"aof-backpressure"`
+	// AppendFsync is when the AOF file is flushed to disk: always (the default) after every
+	// command, everysec once per second, no leaves it to the operating system
+	AppendFsync string `cfg:"appendfsync"`
+	// ReplicaOf makes the server a replica of the master "<host> <port>" at startup
+	ReplicaOf string `cfg:"replicaof"`
+	// ReplBacklogSize is the size in bytes of the backlog of the stream sent to the replicas,
+	// from which a replica resumes after a lost link, 1MB by default
+	ReplBacklogSize int `cfg:"repl-backlog-size"`
+	// ClusterHash is the hash function placing the keys and the nodes on the ring of the cluster:
+	// crc32 (default), crc16 (the slots and hash tags of Redis Cluster) or xxhash
+	// All the nodes of a cluster must hash with the same function and seed
+	ClusterHash string `cfg:"cluster-hash"`
+	// ClusterHashSeed seeds the hash function, 0 keeps the standard checksum
+	ClusterHashSeed int `cfg:"cluster-hash-seed"`
+	// ClusterAutoPipeline is the rate of relays to a peer in requests per second above which the
+	// connections to the peer batch their queued requests in one write, 0 disables the batching
+	ClusterAutoPipeline int `cfg:"cluster-auto-pipeline"`
+	// ClusterRedirect makes the nodes answer -MOVED and -ASK for the keys of the other nodes like
+	// Redis Cluster instead of relaying the commands, it requires cluster-hash crc16
+	ClusterRedirect bool `cfg:"cluster-redirect"`
+	// ClusterProbeInterval pings the peers every this many milliseconds and removes those failing
+	// cluster-probe-failures probes in a row from the ring until they reply again, 0 disables the probes
+	ClusterProbeInterval int `cfg:"cluster-probe-interval"`
+	// ClusterProbeFailures is the number of failed probes in a row marking a peer failed, 3 by default
+	ClusterProbeFailures int `cfg:"cluster-probe-failures"`
+	// MasterAuth is the password sent with AUTH to the master of replicaof when it sets requirepass
+	MasterAuth string `cfg:"masterauth"`
+	// MaxMemory is the limit in bytes of the estimated memory of the dataset, 0 means no limit
+	MaxMemory int `cfg:"maxmemory"`
+	// MaxMemoryPolicy chooses the keys evicted over maxmemory: noeviction (default) rejects the
+	// write commands with -OOM, allkeys-lru, allkeys-random, volatile-lru or volatile-ttl
+	MaxMemoryPolicy string `cfg:"maxmemory-policy"`
+	// MaxMemorySamples is the number of keys of each DB sampled to find the key to evict, 5 by
+	// default, more samples approximate the policy better at a higher cost
+	MaxMemorySamples int `cfg:"maxmemory-samples"`
+	// TrafficCapture records the commands of the clients with their time and connection to this
+	// file, replayed by cmd/replay; empty disables the capture
+	TrafficCapture string `cfg:"traffic-capture"`
+	// WatchdogPeriod logs the stack traces of all the goroutines when a command runs for more than
+	// this many milliseconds, to find deadlocks; 0 disables the watchdog
+	WatchdogPeriod int `cfg:"watchdog-period"`
+	// KeysMaxScan is the max number of keys iterated by KEYS, 0 means no limit
+	KeysMaxScan int `cfg:"keys-max-scan"`
+	// KeysOverBudget is the reply of KEYS over keys-max-scan: error (default) or truncate, which
+	// replies like SCAN with the cursor where KEYS stopped and the keys found so far
+	KeysOverBudget string `cfg:"keys-over-budget"`
+	// NotifyKeyspaceEvents are the classes of the keyspace events published to the pub/sub
+	// channels __keyspace@<db>__:<key> and __keyevent@<db>__:<event>, in the flags of Redis such
+	// as KEA; empty (the default) disables the notifications
+	NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"`
+	// ClientOutputBufferLimit bounds the messages waiting to be written to a pub/sub subscriber,
+	// in the groups "<class> <hard> <soft> <soft seconds>" of Redis; only the pubsub class is
+	// enforced, 32mb 8mb 60 by default
+	ClientOutputBufferLimit string `cfg:"client-output-buffer-limit"`
 }
 
+// DefaultProtoMaxBulkLen is the max length of a bulk string when ProtoMaxBulkLen is not set, that of Redis
+const DefaultProtoMaxBulkLen = 512 * 1024 * 1024
+
 // Properties 存储全局配置
 var Properties *ServerProperties
 
@@ -107,5 +224,10 @@ func SetupConfig(configFilename string) {
 
 		}
 	}(file)
-	Properties = parse(file)
+	properties := parse(file)
+	mu.Lock()
+	defer mu.Unlock()
+	Properties = properties
+	configFile = configFilename
+	changed = make(map[string]string)
 }
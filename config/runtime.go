@@ -0,0 +1,201 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mu guards the parameters changed by CONFIG SET while the server runs, they are read with Read
+var mu sync.RWMutex
+
+// configFile is the file the configuration was read from, empty when the server started without one
+var configFile string
+
+// changed holds the parameters set at runtime with their value, CONFIG REWRITE writes them to
+// the configuration file
+var changed = make(map[string]string)
+
+// ErrNoConfigFile is returned by Rewrite when the server started without a configuration file
+var ErrNoConfigFile = errors.New("The server is running without a config file")
+
+// Read returns a value of the configuration under the lock of the runtime changes, the zero
+// value before the configuration is set up
+func Read[T any](read func(p *ServerProperties) T) T {
+	mu.RLock()
+	defer mu.RUnlock()
+	if Properties == nil {
+		var zero T
+		return zero
+	}
+	return read(Properties)
+}
+
+// parameterName returns the name of the parameter of a field, its cfg tag in lower case
+func parameterName(field reflect.StructField) string {
+	key, ok := field.Tag.Lookup("cfg")
+	if !ok {
+		key = field.Name
+	}
+	return strings.ToLower(key)
+}
+
+// field returns the field of the parameter
+func field(name string) (reflect.Value, bool) {
+	t := reflect.TypeOf(Properties).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if parameterName(t.Field(i)) == name {
+			return reflect.ValueOf(Properties).Elem().Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// Parameters returns the names of the parameters of the configuration in order
+func Parameters() []string {
+	t := reflect.TypeOf(ServerProperties{})
+	names := make([]string, t.NumField())
+	for i := range names {
+		names[i] = parameterName(t.Field(i))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the value of a parameter in the format of the configuration file, false if there
+// is no such parameter
+func Get(name string) (string, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	value, ok := field(strings.ToLower(name))
+	if !ok {
+		return "", false
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return value.String(), true
+	case reflect.Int:
+		return strconv.FormatInt(value.Int(), 10), true
+	case reflect.Bool:
+		if value.Bool() {
+			return "yes", true
+		}
+		return "no", true
+	case reflect.Slice:
+		return strings.Join(value.Interface().([]string), ","), true
+	}
+	return "", true
+}
+
+// Set changes a parameter while the server runs, the value is parsed like in the configuration
+// file but an invalid one is an error instead of being ignored
+// The caller checks that the parameter may change at runtime and that the value is valid
+func Set(name, value string) error {
+	name = strings.ToLower(name)
+	mu.Lock()
+	defer mu.Unlock()
+	field, ok := field(name)
+	if !ok {
+		return errors.New("unknown parameter " + name)
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.New("argument couldn't be parsed into an integer")
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		switch strings.ToLower(value) {
+		case "yes":
+			field.SetBool(true)
+		case "no":
+			field.SetBool(false)
+		default:
+			return errors.New("argument must be 'yes' or 'no'")
+		}
+		value = strings.ToLower(value)
+	case reflect.Slice:
+		field.Set(reflect.ValueOf(strings.Split(value, ",")))
+	}
+	changed[name] = value
+	return nil
+}
+
+// Rewrite writes the parameters set at runtime to the configuration file: the line of a
+// parameter is replaced, in place of the first one if it appears several times, the comments and
+// the other lines are kept and the parameters missing from the file are appended
+// The file is replaced atomically, an empty value removes the parameter since it is the default
+func Rewrite() error {
+	mu.RLock()
+	defer mu.RUnlock()
+	if configFile == "" {
+		return ErrNoConfigFile
+	}
+	src, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	written := make(map[string]bool)
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(string(src)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		key := line
+		if pivot := strings.IndexByte(line, ' '); pivot > 0 {
+			key = line[:pivot]
+		}
+		name := strings.ToLower(key)
+		value, ok := changed[name]
+		if len(line) == 0 || line[0] == '#' || !ok {
+			lines = append(lines, line)
+			continue
+		}
+		if !written[name] && value != "" {
+			lines = append(lines, key+" "+value)
+		}
+		written[name] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		if !written[name] && changed[name] != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, name+" "+changed[name])
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(configFile), filepath.Base(configFile)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(configFile); err == nil {
+		_ = os.Chmod(tmp.Name(), info.Mode())
+	}
+	return os.Rename(tmp.Name(), configFile)
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"os"
+	"redigo/aof"
+	"redigo/config"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// StartupSummary describes the server about to start from the resolved configuration, one line
+// per topic, so that the logs show which mode, files and limits a process ran with
+// cluster tells whether the server runs as a node of a cluster
+func StartupSummary(cluster bool) []string {
+	p := config.Properties
+	lines := []string{
+		"redigo " + redigoVersion + " (" + runtime.Version() + " " + runtime.GOOS + "/" + runtime.GOARCH + "), pid " + strconv.Itoa(os.Getpid()),
+	}
+
+	switch {
+	case cluster:
+		mode := "proxy"
+		if p.ClusterRedirect {
+			mode = "redirect"
+		}
+		hash := p.ClusterHash
+		if hash == "" {
+			hash = "crc32"
+		}
+		lines = append(lines, "mode: cluster ("+mode+"), node "+p.Self+", peers "+strings.Join(p.Peers, " ")+", hash "+hash)
+	case p.ReplicaOf != "":
+		lines = append(lines, "mode: replica of "+p.ReplicaOf)
+	default:
+		lines = append(lines, "mode: standalone")
+	}
+
+	persistence := "aof off"
+	if p.AppendOnly {
+		persistence = "aof " + aof.Filename()
+	}
+	lines = append(lines, "persistence: "+persistence+", rdb "+rdbFilename())
+
+	endpoint := "listening: " + p.Bind + ":" + strconv.Itoa(p.Port)
+	if p.MetricsPort > 0 {
+		endpoint += ", metrics " + p.Bind + ":" + strconv.Itoa(p.MetricsPort)
+	}
+	lines = append(lines, endpoint)
+
+	limits := "limits: databases " + strconv.Itoa(p.Databases) + ", maxclients " + limitOf(p.MaxClients) +
+		", maxmemory " + limitOf(p.MaxMemory)
+	if p.MaxMemory > 0 {
+		policy := p.MaxMemoryPolicy
+		if policy == "" {
+			policy = "noeviction"
+		}
+		limits += " (" + policy + ")"
+	}
+	lines = append(lines, limits)
+
+	security := "security: requirepass "
+	if p.RequirePass != "" {
+		security += "set"
+	} else {
+		security += "not set"
+	}
+	if p.ReadOnly {
+		security += ", maintenance mode"
+	}
+	return append(lines, security)
+}
+
+// limitOf formats a limit of the configuration where 0 means no limit
+func limitOf(n int) string {
+	if n <= 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,32 @@
+package database
+
+import (
+	"redigo/config"
+	"strings"
+	"testing"
+)
+
+// TestStartupSummary tests that the startup summary reports the mode and the persistence of the
+// configuration
+func TestStartupSummary(t *testing.T) {
+	saved := *config.Properties
+	defer func() {
+		*config.Properties = saved
+	}()
+	config.Properties.ReplicaOf = "127.0.0.1 6380"
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = "appendonly.aof"
+	config.Properties.MaxMemory = 1024
+
+	summary := strings.Join(StartupSummary(false), "\n")
+	for _, want := range []string{"mode: replica of 127.0.0.1 6380", "persistence: aof appendonly.aof", "maxmemory 1024 (noeviction)"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("expected %q in the summary:\n%s", want, summary)
+		}
+	}
+
+	config.Properties.Self, config.Properties.Peers = "127.0.0.1:6391", []string{"127.0.0.1:6392"}
+	if summary := strings.Join(StartupSummary(true), "\n"); !strings.Contains(summary, "mode: cluster (proxy), node 127.0.0.1:6391, peers 127.0.0.1:6392, hash crc32") {
+		t.Errorf("expected the cluster mode in the summary:\n%s", summary)
+	}
+}
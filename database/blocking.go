@@ -0,0 +1,373 @@
+package database
+
+import (
+	"math"
+	"redigo/interface/resp"
+	"redigo/resp/reply"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Blocking commands (BLPOP, BRPOP, WAIT, XREAD BLOCK) wait with DB.block, which registers the
+// client in the blockingRegistry of the DB:
+//   - the clients blocked on a key are queued in the order they blocked, a write command wakes
+//     the first client of the queue of each of its keys, which checks its keys again
+//   - a client only takes the value of a key while it is the first of its queue, so that the
+//     pushed elements are served to the clients in FIFO order. Leaving the queue wakes the next
+//     client, which takes the elements left
+//   - the timeoutWheel releases the clients whose timeout expired
+//   - AfterClientClose releases the command of a client which disconnected while blocked
+
+// wheelTick is the resolution of the timeouts of the blocking commands
+const wheelTick = 10 * time.Millisecond
+
+// wheelSlots is the number of slots of the timing wheel, a turn of the wheel lasts 5s
+const wheelSlots = 512
+
+// blockedClient is a client waiting in a blocking command
+type blockedClient struct {
+	conn resp.Connection // nil if not called by a client
+	keys []string
+	// wake receives a value when one of the keys is written, it is buffered so that a write
+	// while the client is checking its keys is not missed
+	wake chan struct{}
+	// done is closed when the client stops waiting on timeout or disconnection
+	done     chan struct{}
+	doneOnce sync.Once
+	// slot and rounds locate the client in the timing wheel, slot is -1 without timeout
+	slot   int
+	rounds int
+}
+
+// notify wakes the client to check its keys again
+func (b *blockedClient) notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// release stops the wait of the client
+func (b *blockedClient) release() {
+	b.doneOnce.Do(func() {
+		close(b.done)
+	})
+}
+
+// blockingRegistry holds the clients blocked on the keys of a DB
+type blockingRegistry struct {
+	mu    sync.Mutex
+	keys  map[string][]*blockedClient        // clients in the order they blocked
+	conns map[resp.Connection]*blockedClient // a client blocks in a single command at a time
+	// count is the number of blocked clients, read without the lock before each write command
+	count atomic.Int32
+	wheel *timeoutWheel
+}
+
+func makeBlockingRegistry() *blockingRegistry {
+	return &blockingRegistry{
+		keys:  make(map[string][]*blockedClient),
+		conns: make(map[resp.Connection]*blockedClient),
+		wheel: makeTimeoutWheel(wheelTick, wheelSlots),
+	}
+}
+
+// add registers a client blocked on the keys, a timeout of 0 blocks forever
+func (r *blockingRegistry) add(conn resp.Connection, keys []string, timeout time.Duration) *blockedClient {
+	b := &blockedClient{
+		conn: conn,
+		keys: keys,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+		slot: -1,
+	}
+	r.mu.Lock()
+	for _, key := range keys {
+		r.keys[key] = append(r.keys[key], b)
+	}
+	if conn != nil {
+		r.conns[conn] = b
+	}
+	r.count.Add(1)
+	r.mu.Unlock()
+	if timeout > 0 {
+		r.wheel.add(b, timeout)
+	}
+	return b
+}
+
+// remove unregisters a client returned by add
+func (r *blockingRegistry) remove(b *blockedClient) {
+	r.wheel.remove(b)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range b.keys {
+		queue := r.keys[key]
+		wasFirst := len(queue) > 0 && queue[0] == b
+		queue = slices.DeleteFunc(queue, func(other *blockedClient) bool {
+			return other == b
+		})
+		if len(queue) == 0 {
+			delete(r.keys, key)
+			continue
+		}
+		r.keys[key] = queue
+		if wasFirst {
+			// the value of the key may be left for the next client
+			queue[0].notify()
+		}
+	}
+	if b.conn != nil && r.conns[b.conn] == b {
+		delete(r.conns, b.conn)
+	}
+	r.count.Add(-1)
+}
+
+// signal wakes the first client blocked on each of the keys
+func (r *blockingRegistry) signal(keys []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		if queue := r.keys[key]; len(queue) > 0 {
+			queue[0].notify()
+		}
+	}
+}
+
+// first reports whether the client is the first blocked on the key
+func (r *blockingRegistry) first(b *blockedClient, key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queue := r.keys[key]
+	return len(queue) > 0 && queue[0] == b
+}
+
+// disconnect releases the command the client is blocked in
+func (r *blockingRegistry) disconnect(conn resp.Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.conns[conn]; ok {
+		b.release()
+	}
+}
+
+// blocked returns the number of blocked clients
+func (r *blockingRegistry) blocked() int {
+	return int(r.count.Load())
+}
+
+// block calls try until it returns a reply, calling it again whenever one of the keys is
+// written. It returns false if the timeout expires first or the client disconnects, a timeout of
+// 0 blocks forever.
+// try must take the locks of the keys itself, the caller must not hold them. first tells try
+// whether the client is the first blocked on a key, the only one which may consume its value
+func (db *DB) block(conn resp.Connection, keys []string, timeout time.Duration, try func(first func(key string) bool) resp.Reply) (resp.Reply, bool) {
+	// register before the first try so that a write between the try and the wait is not missed
+	b := db.blocking.add(conn, keys, timeout)
+	defer db.blocking.remove(b)
+	first := func(key string) bool {
+		return db.blocking.first(b, key)
+	}
+	for {
+		if result := try(first); result != nil {
+			return result, true
+		}
+		select {
+		case <-b.wake:
+		case <-b.done:
+			return nil, false
+		}
+	}
+}
+
+// signalWrite wakes the clients blocked on the keys of a write command
+func (db *DB) signalWrite(cmdLine CmdLine) {
+	if db.blocking.blocked() == 0 {
+		return
+	}
+	if keys, ok := CommandKeys(cmdLine); ok && len(keys) > 0 {
+		db.blocking.signal(keys)
+	}
+}
+
+// blockingCommands lists the commands which may block, with the function telling whether a
+// command line blocks, nil if it always does
+var blockingCommands = map[string]func(args [][]byte) bool{
+	"blpop": nil,
+	"brpop": nil,
+	"wait":  nil,
+	"xread": func(args [][]byte) bool {
+		for _, arg := range args {
+			if strings.ToUpper(string(arg)) == "BLOCK" {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+// IsBlockingCommand reports whether the command line may block the client
+func IsBlockingCommand(cmdLine [][]byte) bool {
+	blocks, ok := blockingCommands[strings.ToLower(string(cmdLine[0]))]
+	return ok && (blocks == nil || blocks(cmdLine[1:]))
+}
+
+// parseBlockTimeout parses the timeout in seconds of BLPOP and BRPOP
+func parseBlockTimeout(arg []byte) (time.Duration, reply.ErrorReply) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, reply.MakeStandardErrorReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, reply.MakeStandardErrorReply("ERR timeout is negative")
+	}
+	// float64(math.MaxInt64) is 2^63, a product reaching it would overflow the Duration
+	if seconds*float64(time.Second) >= float64(math.MaxInt64) {
+		return 0, reply.MakeStandardErrorReply("ERR timeout is out of range")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// timeoutWheel is a hashed timing wheel releasing the blocked clients whose timeout expired.
+// A client is put in the slot its deadline falls in, with the number of turns of the wheel left
+// before that deadline. A goroutine, running only while the wheel holds clients, moves to the
+// next slot every tick and releases its due clients
+type timeoutWheel struct {
+	mu      sync.Mutex
+	tick    time.Duration
+	slots   []map[*blockedClient]struct{}
+	current int
+	size    int
+	stop    chan struct{} // stops the goroutine moving the wheel, nil if it is not running
+}
+
+func makeTimeoutWheel(tick time.Duration, slots int) *timeoutWheel {
+	w := &timeoutWheel{
+		tick:  tick,
+		slots: make([]map[*blockedClient]struct{}, slots),
+	}
+	for i := range w.slots {
+		w.slots[i] = make(map[*blockedClient]struct{})
+	}
+	return w
+}
+
+// add schedules the release of the client after timeout
+// The current tick has partly elapsed, a tick is added so that the client never times out early
+func (w *timeoutWheel) add(b *blockedClient, timeout time.Duration) {
+	// rounded up without adding to the timeout, which may be close to the max Duration
+	ticks := int(timeout/w.tick) + 1
+	if timeout%w.tick != 0 {
+		ticks++
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b.slot = (w.current + ticks) % len(w.slots)
+	b.rounds = (ticks - 1) / len(w.slots)
+	w.slots[b.slot][b] = struct{}{}
+	w.size++
+	if w.stop == nil {
+		w.stop = make(chan struct{})
+		go w.run(w.stop)
+	}
+}
+
+// remove cancels the timeout of the client
+func (w *timeoutWheel) remove(b *blockedClient) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if b.slot < 0 {
+		return
+	}
+	if _, ok := w.slots[b.slot][b]; ok {
+		delete(w.slots[b.slot], b)
+		w.size--
+	}
+	b.slot = -1
+	w.stopIfEmpty()
+}
+
+func (w *timeoutWheel) run(stop chan struct{}) {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.advance(stop)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// advance moves the wheel to the next slot and releases its due clients
+func (w *timeoutWheel) advance(stop chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stop != stop {
+		// the goroutine was stopped while waiting for the lock, another one may move the wheel
+		return
+	}
+	w.current = (w.current + 1) % len(w.slots)
+	for b := range w.slots[w.current] {
+		if b.rounds > 0 {
+			b.rounds--
+			continue
+		}
+		delete(w.slots[w.current], b)
+		w.size--
+		b.release()
+	}
+	w.stopIfEmpty()
+}
+
+// stopIfEmpty stops the goroutine moving the wheel when no client is left, w.mu must be held
+func (w *timeoutWheel) stopIfEmpty() {
+	if w.size == 0 && w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+}
+
+// execWait implements the WAIT command of a DB without replication, it replies 0 at once if no
+// replica is required, or after the timeout in milliseconds otherwise
+// WAIT numreplicas timeout
+func execWait(db *DB, client resp.Connection, args [][]byte) resp.Reply {
+	replicas, timeout, errReply := parseWaitArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	if replicas > 0 {
+		db.block(client, nil, timeout, func(func(string) bool) resp.Reply {
+			return nil
+		})
+	}
+	return reply.MakeIntReply(0)
+}
+
+// parseWaitArgs parses the number of replicas and the timeout of WAIT, a timeout of 0 waits forever
+func parseWaitArgs(args [][]byte) (int64, time.Duration, resp.Reply) {
+	replicas, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return 0, 0, reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	ms, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return 0, 0, reply.MakeStandardErrorReply("ERR timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return 0, 0, reply.MakeStandardErrorReply("ERR timeout is negative")
+	}
+	if ms > math.MaxInt64/int64(time.Millisecond) {
+		return 0, 0, reply.MakeStandardErrorReply("ERR timeout is out of range")
+	}
+	return replicas, time.Duration(ms) * time.Millisecond, nil
+}
+
+func init() {
+	registerBlockingCommand("WAIT", execWait, 3) // WAIT numreplicas timeout
+}
@@ -0,0 +1,204 @@
+package database
+
+import (
+	"math"
+	"redigo/interface/resp"
+	"redigo/lib/utils"
+	"redigo/resp/connection"
+	"redigo/resp/reply"
+	"testing"
+	"time"
+)
+
+// execAsync runs a command of the client in background
+func execAsync(db *DB, client resp.Connection, args ...string) <-chan resp.Reply {
+	done := make(chan resp.Reply, 1)
+	go func() {
+		done <- db.Exec(client, utils.ToCmdLine(args...))
+	}()
+	return done
+}
+
+// waitBlocked waits until n clients are blocked on the DB
+func waitBlocked(t *testing.T, db *DB, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for db.blocking.blocked() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d blocked clients, got %d", n, db.blocking.blocked())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, done <-chan resp.Reply) resp.Reply {
+	t.Helper()
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(time.Second):
+		t.Fatal("Expected the blocking command to return")
+		return nil
+	}
+}
+
+// TestBLPopWakesOnPush tests that BLPOP blocked on several keys pops the element pushed to any of them
+func TestBLPopWakesOnPush(t *testing.T) {
+	db := MakeDB()
+	done := execAsync(db, nil, "BLPOP", "empty", "list", "0")
+	waitBlocked(t, db, 1)
+
+	exec(db, "SET", "other", "value")
+	exec(db, "RPUSH", "list", "a", "b")
+	assertReply(t, receive(t, done), "*2\r\n$4\r\nlist\r\n$1\r\na\r\n")
+	assertReply(t, exec(db, "LRANGE", "list", "0", "-1"), "*1\r\n$1\r\nb\r\n")
+	waitBlocked(t, db, 0)
+}
+
+// TestBlockingPopWithoutWaiting tests that BLPOP and BRPOP pop from the first non-empty list at once
+func TestBlockingPopWithoutWaiting(t *testing.T) {
+	db := MakeDB()
+	exec(db, "RPUSH", "second", "a", "b", "c")
+	exec(db, "RPUSH", "third", "x")
+
+	assertReply(t, exec(db, "BLPOP", "first", "second", "third", "1"), "*2\r\n$6\r\nsecond\r\n$1\r\na\r\n")
+	assertReply(t, exec(db, "BRPOP", "first", "second", "third", "1"), "*2\r\n$6\r\nsecond\r\n$1\r\nc\r\n")
+
+	exec(db, "SET", "string", "value")
+	assertReply(t, exec(db, "BLPOP", "string", "1"), string(reply.MakeWrongTypeErrReply().ToBytes()))
+	assertReply(t, exec(db, "BLPOP", "list", "-1"), "-ERR timeout is negative\r\n")
+	assertReply(t, exec(db, "BLPOP", "list", "soon"), "-ERR timeout is not a float or out of range\r\n")
+	// the timeouts beyond the range of a Duration would wrap around
+	assertReply(t, exec(db, "BLPOP", "list", "9223372037"), "-ERR timeout is out of range\r\n")
+	assertReply(t, exec(db, "BRPOP", "list", "1e300"), "-ERR timeout is out of range\r\n")
+}
+
+// TestBlockingPopTimeout tests that BLPOP replies null after its timeout and not before
+func TestBlockingPopTimeout(t *testing.T) {
+	db := MakeDB()
+	start := time.Now()
+	assertReply(t, exec(db, "BLPOP", "list", "0.05"), "*-1\r\n")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected BLPOP to wait 50ms, returned after %v", elapsed)
+	}
+	waitBlocked(t, db, 0)
+}
+
+// TestBlockedClientDisconnect tests that a client blocked forever is released when it disconnects
+func TestBlockedClientDisconnect(t *testing.T) {
+	db := MakeDB()
+	client := &connection.Connection{}
+	done := execAsync(db, client, "BRPOP", "list", "0")
+	waitBlocked(t, db, 1)
+
+	db.blocking.disconnect(&connection.Connection{})
+	select {
+	case <-done:
+		t.Fatal("Expected another client to be kept blocked")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	db.blocking.disconnect(client)
+	assertReply(t, receive(t, done), "*-1\r\n")
+	waitBlocked(t, db, 0)
+	// the element pushed later is not lost
+	exec(db, "RPUSH", "list", "a")
+	assertReply(t, exec(db, "LLEN", "list"), ":1\r\n")
+}
+
+// TestBlockingPopFIFO tests that the clients blocked on a list are served in the order they
+// blocked, including when the first one leaves without popping
+func TestBlockingPopFIFO(t *testing.T) {
+	db := MakeDB()
+	first := execAsync(db, nil, "BLPOP", "list", "0")
+	waitBlocked(t, db, 1)
+	leaving := &connection.Connection{}
+	second := execAsync(db, leaving, "BLPOP", "list", "0")
+	waitBlocked(t, db, 2)
+	third := execAsync(db, nil, "BRPOP", "other", "list", "0")
+	waitBlocked(t, db, 3)
+	fourth := execAsync(db, nil, "BLPOP", "list", "0")
+	waitBlocked(t, db, 4)
+
+	exec(db, "RPUSH", "list", "a")
+	assertReply(t, receive(t, first), "*2\r\n$4\r\nlist\r\n$1\r\na\r\n")
+	db.blocking.disconnect(leaving)
+	assertReply(t, receive(t, second), "*-1\r\n")
+	waitBlocked(t, db, 2)
+
+	exec(db, "RPUSH", "list", "b", "c")
+	assertReply(t, receive(t, third), "*2\r\n$4\r\nlist\r\n$1\r\nc\r\n")
+	assertReply(t, receive(t, fourth), "*2\r\n$4\r\nlist\r\n$1\r\nb\r\n")
+	waitBlocked(t, db, 0)
+	assertReply(t, exec(db, "EXISTS", "list"), ":0\r\n")
+}
+
+// TestXReadBlockWakesOnWrite tests that XREAD BLOCK is woken by XADD and by RENAME of a stream
+func TestXReadBlockWakesOnWrite(t *testing.T) {
+	db := MakeDB()
+	done := execAsync(db, nil, "XREAD", "BLOCK", "0", "STREAMS", "stream", "$")
+	waitBlocked(t, db, 1)
+	exec(db, "XADD", "stream", "1-1", "field", "value")
+	assertReply(t, receive(t, done), "*1\r\n*2\r\n$6\r\nstream\r\n*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$5\r\nfield\r\n$5\r\nvalue\r\n")
+
+	done = execAsync(db, nil, "XREAD", "BLOCK", "0", "STREAMS", "renamed", "0")
+	waitBlocked(t, db, 1)
+	exec(db, "RENAME", "stream", "renamed")
+	assertReply(t, receive(t, done), "*1\r\n*2\r\n$7\r\nrenamed\r\n*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$5\r\nfield\r\n$5\r\nvalue\r\n")
+
+	assertReply(t, exec(db, "XREAD", "BLOCK", "20", "STREAMS", "renamed", "$"), "*-1\r\n")
+}
+
+// TestWait tests WAIT without replicas
+func TestWait(t *testing.T) {
+	db := MakeDB()
+	assertReply(t, exec(db, "WAIT", "0", "0"), ":0\r\n")
+	start := time.Now()
+	assertReply(t, exec(db, "WAIT", "1", "20"), ":0\r\n")
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected WAIT to wait 20ms, returned after %v", elapsed)
+	}
+	assertReply(t, exec(db, "WAIT", "1", "9223372036855"), "-ERR timeout is out of range\r\n")
+}
+
+// TestTimeoutWheel tests timeouts shorter and longer than a turn of the wheel
+func TestTimeoutWheel(t *testing.T) {
+	wheel := makeTimeoutWheel(time.Millisecond, 8)
+	timeouts := []time.Duration{3 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond}
+	clients := make([]*blockedClient, len(timeouts))
+	start := time.Now()
+	for i, timeout := range timeouts {
+		clients[i] = &blockedClient{done: make(chan struct{}), slot: -1}
+		wheel.add(clients[i], timeout)
+	}
+	cancelled := &blockedClient{done: make(chan struct{}), slot: -1}
+	wheel.add(cancelled, 10*time.Millisecond)
+	wheel.remove(cancelled)
+	longest := &blockedClient{done: make(chan struct{}), slot: -1}
+	wheel.add(longest, time.Duration(math.MaxInt64))
+	if longest.rounds <= 0 {
+		t.Errorf("Expected the longest timeout to take many rounds, got %d", longest.rounds)
+	}
+	wheel.remove(longest)
+
+	for i, b := range clients {
+		select {
+		case <-b.done:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the client with timeout %v to be released", timeouts[i])
+		}
+		if elapsed := time.Since(start); elapsed < timeouts[i] {
+			t.Errorf("Expected the client with timeout %v to be released after it, got %v", timeouts[i], elapsed)
+		}
+	}
+	select {
+	case <-cancelled.done:
+		t.Error("Expected the removed client not to be released")
+	default:
+	}
+	wheel.mu.Lock()
+	defer wheel.mu.Unlock()
+	if wheel.size != 0 || wheel.stop != nil {
+		t.Errorf("Expected the empty wheel to stop, size %d", wheel.size)
+	}
+}
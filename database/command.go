@@ -1,20 +1,317 @@
 package database
 
-import "strings"
+import (
+	"redigo/interface/resp"
+	"redigo/resp/reply"
+	"sort"
+	"strings"
+)
 
 // cmdTable is a map that associates command names (as strings) with their corresponding command structures
 var cmdTable = make(map[string]*command)
 
 type command struct {
-	exec  ExecFunc // function to execute the command
-	arity int      // number of arguments required for the command
+	exec     ExecFunc // function to execute the command
+	arity    int      // number of arguments required for the command
+	readOnly bool     // the command does not modify the database
+	keys     keySpec  // positions of the keys, the commands of keysFuncs find theirs at run time
+	// blockingExec executes the commands which may block instead of exec, nil for the others
+	blockingExec BlockingExecFunc
+	// cancellableExec executes the commands limited by the command timeout instead of exec, nil
+	// for the others
+	cancellableExec cancellableExecFunc
+}
+
+// readOnlyCommands lists the builtin commands which never modify the database
+var readOnlyCommands = map[string]bool{
+	"ping": true, "echo": true, "time": true, "lolwut": true, "exists": true, "type": true, "keys": true, "scan": true,
+	"ttl": true, "pttl": true, "expiretime": true, "pexpiretime": true,
+	"get": true, "mget": true, "strlen": true, "getrange": true, "lcs": true,
+	"lrange": true, "llen": true, "lindex": true, "lpos": true,
+	"hget": true, "hexists": true, "hlen": true, "hgetall": true, "hkeys": true, "hvals": true, "hmget": true, "hencoding": true, "hscan": true,
+	"scard": true, "sismember": true, "smembers": true, "srandmember": true, "sunion": true, "sinter": true, "sdiff": true, "settype": true, "sscan": true,
+	"zscore": true, "zcard": true, "zrange": true, "zcount": true, "zrank": true, "ztype": true, "zscan": true,
+	"zrevrange": true, "zrevrank": true, "zrangebyscore": true, "zrevrangebyscore": true,
+	"geopos": true, "geodist": true, "geosearch": true,
+	"xlen": true, "xrange": true, "xrevrange": true, "xread": true,
+	"module": true, "memory": true, "wait": true, "touch": true, "object": true, "dump": true,
+	"script": true, "command": true,
+}
+
+// keySpec tells where the keys are in a command line like the key specs of the Redis command
+// table, positions start with the command name at 0 and a negative lastKey counts from the end
+type keySpec struct {
+	firstKey int // position of the first key, 0 if the command has no key
+	lastKey  int // position of the last key
+	step     int // distance between two keys
+}
+
+// defaultKeySpec is the key spec of the commands whose only key is the first argument
+var defaultKeySpec = keySpec{firstKey: 1, lastKey: 1, step: 1}
+
+// keySpecs lists the builtin commands whose keys are not just the first argument
+var keySpecs = map[string]keySpec{
+	"ping": {}, "echo": {}, "time": {}, "lolwut": {}, "keys": {}, "scan": {}, "flushdb": {}, "module": {}, "memory": {}, "wait": {},
+	"del": {1, -1, 1}, "exists": {1, -1, 1}, "rename": {1, 2, 1}, "renamenx": {1, 2, 1}, "lcs": {1, 2, 1},
+	"mset": {1, -1, 2}, "msetnx": {1, -1, 2}, "mget": {1, -1, 1},
+	"sunion": {1, -1, 1}, "sinter": {1, -1, 1}, "sdiff": {1, -1, 1},
+	"sunionstore": {1, -1, 1}, "sinterstore": {1, -1, 1}, "sdiffstore": {1, -1, 1},
+	"blpop": {1, -2, 1}, "brpop": {1, -2, 1}, "rpoplpush": {1, 2, 1}, "lmove": {1, 2, 1},
+	"touch": {1, -1, 1}, "object": {2, 2, 1}, "script": {}, "command": {}, "hotkeys": {},
+	// the commands of keysFuncs, their spec is the one reported by COMMAND
+	"xread": {}, "eval": {}, "evalsha": {}, "migrate": {3, 3, 1},
+}
+
+// keySpecOf returns the key spec of a command
+func keySpecOf(name string) keySpec {
+	if spec, ok := keySpecs[name]; ok {
+		return spec
+	}
+	return defaultKeySpec
+}
+
+// complete reports whether a command line of argc arguments holds whole groups of keys and
+// values, the spec of MSET key value [key value ...] takes an odd number of arguments
+// Like the arity, it is checked before the command runs
+func (spec keySpec) complete(argc int) bool {
+	if spec.firstKey == 0 || spec.lastKey >= 0 || spec.step <= 1 {
+		return true
+	}
+	return (argc-spec.firstKey)%spec.step == 0
+}
+
+// keysFuncs extracts the keys of the commands which cannot be described by a key spec
+var keysFuncs = map[string]func(args [][]byte) [][]byte{
+	"xread":   xreadKeys,
+	"migrate": migrateKeys,
+	"eval":    evalKeys,
+	"evalsha": evalKeys,
+}
+
+// xreadKeys returns the stream keys of XREAD, the first half of the arguments after STREAMS
+func xreadKeys(args [][]byte) [][]byte {
+	for i := 1; i < len(args); i++ {
+		if strings.ToUpper(string(args[i])) == "STREAMS" {
+			streams := args[i+1:]
+			return streams[:len(streams)/2]
+		}
+	}
+	return nil
+}
+
+// CommandKeys returns the keys of the command line, false if the command is unknown
+// Module commands take their key as first argument
+func CommandKeys(args [][]byte) ([]string, bool) {
+	name := commandName(args[0])
+	if _, ok := cmdTable[name]; !ok && !IsModuleCommand(name) {
+		return nil, false
+	}
+	if keysFunc, ok := keysFuncs[name]; ok {
+		keys := keysFunc(args)
+		result := make([]string, len(keys))
+		for i, key := range keys {
+			result[i] = string(key)
+		}
+		return result, true
+	}
+
+	spec := keySpecOf(name)
+	if spec.firstKey == 0 {
+		return nil, true
+	}
+	last := spec.lastKey
+	if last < 0 {
+		last = len(args) + last
+	}
+	if last >= len(args) {
+		last = len(args) - 1
+	}
+	var keys []string
+	for i := spec.firstKey; i <= last; i += spec.step {
+		keys = append(keys, string(args[i]))
+	}
+	return keys, true
+}
+
+// IsReadOnlyCommand reports whether the builtin command never modifies the database
+func IsReadOnlyCommand(name string) bool {
+	cmd, ok := cmdTable[strings.ToLower(name)]
+	return ok && cmd.readOnly
+}
+
+// CommandArity returns the arity of a builtin or module command, in the convention of ValidateArity
+func CommandArity(name string) (int, bool) {
+	cmd, ok := cmdTable[strings.ToLower(name)]
+	if !ok {
+		return 0, false
+	}
+	return cmd.arity, true
+}
+
+// IsWriteCommand reports whether the command is a builtin or module command which may modify the keyspace
+func IsWriteCommand(name string) bool {
+	cmd, ok := cmdTable[strings.ToLower(name)]
+	return ok && !cmd.readOnly
+}
+
+// commandNames maps the registered command names, in lower and upper case, to their lower case
+// name so that the command name of a request is found without allocating
+var commandNames = make(map[string]string)
+
+// commandName returns the command name of a request in lower case
+func commandName(arg []byte) string {
+	if name, ok := commandNames[string(arg)]; ok {
+		return name
+	}
+	return strings.ToLower(string(arg))
 }
 
 // RegisterCommand registers a command with the command table
 func RegisterCommand(name string, exec ExecFunc, arity int) {
 	name = strings.ToLower(name)
+	commandNames[name] = name
+	commandNames[strings.ToUpper(name)] = name
 	cmdTable[name] = &command{
-		exec:  exec,
-		arity: arity,
+		exec:     exec,
+		arity:    arity,
+		readOnly: readOnlyCommands[name],
+		keys:     keySpecOf(name),
+	}
+}
+
+// validArgs checks the number of arguments of the command line against the arity and the key
+// spec of the command
+func (cmd *command) validArgs(cmdLine CmdLine) bool {
+	return ValidateArity(cmd.arity, cmdLine) && cmd.keys.complete(len(cmdLine))
+}
+
+// registerBlockingCommand registers a command which may block the client
+func registerBlockingCommand(name string, exec BlockingExecFunc, arity int) {
+	RegisterCommand(name, func(db *DB, args [][]byte) resp.Reply {
+		return exec(db, nil, args)
+	}, arity)
+	cmdTable[strings.ToLower(name)].blockingExec = exec
+}
+
+// registerCancellableCommand registers a command which stops once the command timeout passed
+func registerCancellableCommand(name string, exec cancellableExecFunc, arity int) {
+	RegisterCommand(name, func(db *DB, args [][]byte) resp.Reply {
+		return exec(db, nil, args)
+	}, arity)
+	cmdTable[strings.ToLower(name)].cancellableExec = exec
+}
+
+// registerReadOnlyCommand registers a command which never modifies the database
+func registerReadOnlyCommand(name string, exec ExecFunc, arity int) {
+	RegisterCommand(name, exec, arity)
+	cmdTable[strings.ToLower(name)].readOnly = true
+}
+
+// execCommand implements the COMMAND command, which describes the command table like Redis so
+// that the clients find the keys of the commands, the commands of the server are not listed
+// COMMAND [COUNT | LIST | INFO [command ...] | GETKEYS command [arg ...]]
+func execCommand(db *DB, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		names := commandList()
+		items := make([]resp.Reply, len(names))
+		for i, name := range names {
+			items[i] = commandInfo(name, cmdTable[name])
+		}
+		return reply.MakeMultiRawReply(items)
+	}
+	subcommand := strings.ToUpper(string(args[0]))
+	switch {
+	case subcommand == "COUNT" && len(args) == 1:
+		return reply.MakeIntReply(int64(len(cmdTable)))
+	case subcommand == "LIST" && len(args) == 1:
+		names := commandList()
+		result := make([][]byte, len(names))
+		for i, name := range names {
+			result[i] = []byte(name)
+		}
+		return reply.MakeMultiBulkReply(result)
+	case subcommand == "INFO":
+		if len(args) == 1 {
+			return execCommand(db, nil)
+		}
+		items := make([]resp.Reply, len(args)-1)
+		for i, arg := range args[1:] {
+			name := strings.ToLower(string(arg))
+			if cmd, ok := cmdTable[name]; ok {
+				items[i] = commandInfo(name, cmd)
+			} else {
+				items[i] = reply.MakeNullMultiBulkReply()
+			}
+		}
+		return reply.MakeMultiRawReply(items)
+	case subcommand == "GETKEYS" && len(args) > 1:
+		return commandGetKeys(args[1:])
 	}
+	return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[0]) +
+		"'. Try COMMAND COUNT, COMMAND LIST, COMMAND INFO or COMMAND GETKEYS.")
+}
+
+// commandList returns the names of the command table in order
+func commandList() []string {
+	names := make([]string, 0, len(cmdTable))
+	for name := range cmdTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandInfo describes a command in the format of Redis 7: name, arity, flags, first key, last
+// key, step, then the ACL categories, the tips, the key specs and the subcommands which are empty
+func commandInfo(name string, cmd *command) resp.Reply {
+	var flags [][]byte
+	if cmd.readOnly {
+		flags = append(flags, []byte("readonly"))
+	} else {
+		flags = append(flags, []byte("write"))
+	}
+	if cmd.blockingExec != nil {
+		flags = append(flags, []byte("blocking"))
+	}
+	if _, ok := keysFuncs[name]; ok {
+		flags = append(flags, []byte("movablekeys"))
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(name)),
+		reply.MakeIntReply(int64(cmd.arity)),
+		reply.MakeMultiBulkReply(flags),
+		reply.MakeIntReply(int64(cmd.keys.firstKey)),
+		reply.MakeIntReply(int64(cmd.keys.lastKey)),
+		reply.MakeIntReply(int64(cmd.keys.step)),
+		reply.MakeEmptyMultiBulkReply(),
+		reply.MakeEmptyMultiBulkReply(),
+		reply.MakeEmptyMultiBulkReply(),
+		reply.MakeEmptyMultiBulkReply(),
+	})
+}
+
+// commandGetKeys replies the keys of a command line, with the errors of Redis
+func commandGetKeys(cmdLine [][]byte) resp.Reply {
+	name := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[name]
+	if !ok {
+		return reply.MakeStandardErrorReply("ERR Invalid command specified")
+	}
+	_, movable := keysFuncs[name]
+	if cmd.keys.firstKey == 0 && !movable {
+		return reply.MakeStandardErrorReply("ERR The command has no key arguments")
+	}
+	if !cmd.validArgs(cmdLine) {
+		return reply.MakeStandardErrorReply("ERR Invalid number of arguments specified for command")
+	}
+	keys, _ := CommandKeys(cmdLine)
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = []byte(key)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+func init() {
+	RegisterCommand("COMMAND", execCommand, -1) // COMMAND [COUNT | LIST | INFO [command ...] | GETKEYS command [arg ...]]
 }
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+)
+
+// redisCommand is the arity and the key positions of a command in the output of COMMAND INFO
+type redisCommand struct {
+	arity, firstKey, lastKey, step int
+}
+
+// redisCommands is the output of COMMAND INFO of Redis 7.0 for the commands of redigo
+// OBJECT is a container command in Redis 7.0, its key positions are those of its subcommands
+var redisCommands = map[string]redisCommand{
+	"append": {3, 1, 1, 1}, "blpop": {-3, 1, -2, 1}, "brpop": {-3, 1, -2, 1}, "command": {-1, 0, 0, 0},
+	"decr": {2, 1, 1, 1}, "decrby": {3, 1, 1, 1}, "del": {-2, 1, -1, 1}, "dump": {2, 1, 1, 1}, "echo": {2, 0, 0, 0},
+	"eval": {-3, 0, 0, 0}, "evalsha": {-3, 0, 0, 0}, "exists": {-2, 1, -1, 1}, "expire": {-3, 1, 1, 1},
+	"expireat": {-3, 1, 1, 1}, "expiretime": {2, 1, 1, 1}, "flushdb": {-1, 0, 0, 0},
+	"geoadd": {-5, 1, 1, 1}, "geodist": {-4, 1, 1, 1}, "geopos": {-2, 1, 1, 1}, "geosearch": {-7, 1, 1, 1},
+	"get": {2, 1, 1, 1}, "getrange": {4, 1, 1, 1}, "getset": {3, 1, 1, 1},
+	"hdel": {-3, 1, 1, 1}, "hexists": {3, 1, 1, 1}, "hget": {3, 1, 1, 1}, "hgetall": {2, 1, 1, 1}, "hkeys": {2, 1, 1, 1},
+	"hlen": {2, 1, 1, 1}, "hmget": {-3, 1, 1, 1}, "hmset": {-4, 1, 1, 1}, "hscan": {-3, 1, 1, 1}, "hset": {-4, 1, 1, 1},
+	"hsetnx": {4, 1, 1, 1}, "hvals": {2, 1, 1, 1},
+	"incr": {2, 1, 1, 1}, "incrby": {3, 1, 1, 1}, "incrbyfloat": {3, 1, 1, 1}, "keys": {2, 0, 0, 0}, "lcs": {-3, 1, 2, 1},
+	"lindex": {3, 1, 1, 1}, "linsert": {5, 1, 1, 1}, "llen": {2, 1, 1, 1}, "lmove": {5, 1, 2, 1}, "lolwut": {-1, 0, 0, 0},
+	"lpop": {-2, 1, 1, 1}, "lpos": {-3, 1, 1, 1}, "lpush": {-3, 1, 1, 1}, "lpushx": {-3, 1, 1, 1}, "lrange": {4, 1, 1, 1},
+	"lrem": {4, 1, 1, 1}, "lset": {4, 1, 1, 1}, "ltrim": {4, 1, 1, 1},
+	"memory": {-2, 0, 0, 0}, "mget": {-2, 1, -1, 1}, "migrate": {-6, 3, 3, 1}, "module": {-2, 0, 0, 0},
+	"mset": {-3, 1, -1, 2}, "msetnx": {-3, 1, -1, 2}, "object": {-2, 2, 2, 1},
+	"persist": {2, 1, 1, 1}, "pexpire": {-3, 1, 1, 1}, "pexpireat": {-3, 1, 1, 1}, "pexpiretime": {2, 1, 1, 1},
+	"ping": {-1, 0, 0, 0}, "psetex": {4, 1, 1, 1}, "pttl": {2, 1, 1, 1},
+	"rename": {3, 1, 2, 1}, "renamenx": {3, 1, 2, 1}, "restore": {-4, 1, 1, 1}, "rpop": {-2, 1, 1, 1},
+	"rpoplpush": {3, 1, 2, 1}, "rpush": {-3, 1, 1, 1}, "rpushx": {-3, 1, 1, 1},
+	"sadd": {-3, 1, 1, 1}, "scan": {-2, 0, 0, 0}, "scard": {2, 1, 1, 1}, "script": {-2, 0, 0, 0},
+	"sdiff": {-2, 1, -1, 1}, "sdiffstore": {-3, 1, -1, 1}, "set": {-3, 1, 1, 1}, "setex": {4, 1, 1, 1},
+	"setnx": {3, 1, 1, 1}, "setrange": {4, 1, 1, 1}, "sinter": {-2, 1, -1, 1}, "sinterstore": {-3, 1, -1, 1},
+	"sismember": {3, 1, 1, 1}, "smembers": {2, 1, 1, 1}, "spop": {-2, 1, 1, 1}, "srandmember": {-2, 1, 1, 1},
+	"srem": {-3, 1, 1, 1}, "sscan": {-3, 1, 1, 1}, "strlen": {2, 1, 1, 1}, "sunion": {-2, 1, -1, 1},
+	"sunionstore": {-3, 1, -1, 1}, "time": {1, 0, 0, 0}, "touch": {-2, 1, -1, 1}, "ttl": {2, 1, 1, 1}, "type": {2, 1, 1, 1}, "wait": {3, 0, 0, 0},
+	"xadd": {-5, 1, 1, 1}, "xlen": {2, 1, 1, 1}, "xrange": {-4, 1, 1, 1}, "xread": {-4, 0, 0, 0},
+	"xrevrange": {-4, 1, 1, 1}, "xtrim": {-4, 1, 1, 1},
+	"zadd": {-4, 1, 1, 1}, "zcard": {2, 1, 1, 1}, "zcount": {4, 1, 1, 1}, "zincrby": {4, 1, 1, 1},
+	"zpopmax": {-2, 1, 1, 1}, "zpopmin": {-2, 1, 1, 1}, "zrange": {-4, 1, 1, 1}, "zrangebyscore": {-4, 1, 1, 1},
+	"zrank": {3, 1, 1, 1}, "zrem": {-3, 1, 1, 1}, "zremrangebyrank": {4, 1, 1, 1}, "zremrangebyscore": {4, 1, 1, 1},
+	"zrevrange": {-4, 1, 1, 1}, "zrevrangebyscore": {-4, 1, 1, 1}, "zrevrank": {3, 1, 1, 1}, "zscan": {-3, 1, 1, 1},
+	"zscore": {3, 1, 1, 1},
+}
+
+// redigoCommands are the commands which Redis does not have
+var redigoCommands = map[string]redisCommand{
+	"hencoding": {2, 1, 1, 1}, "settype": {2, 1, 1, 1}, "ztype": {2, 1, 1, 1}, "hotkeys": {-1, 0, 0, 0},
+}
+
+// TestCommandTable compares the arity and the key spec of every builtin command with Redis
+func TestCommandTable(t *testing.T) {
+	for name, cmd := range cmdTable {
+		if IsModuleCommand(name) {
+			continue
+		}
+		expected, ok := redisCommands[name]
+		if !ok {
+			expected, ok = redigoCommands[name]
+		}
+		if !ok {
+			t.Errorf("%s is not in the reference table", name)
+			continue
+		}
+		got := redisCommand{cmd.arity, cmd.keys.firstKey, cmd.keys.lastKey, cmd.keys.step}
+		if got != expected {
+			t.Errorf("%s: expected %v like Redis, got %v", name, expected, got)
+		}
+	}
+	for name := range redisCommands {
+		if _, ok := cmdTable[name]; !ok {
+			t.Errorf("%s is not registered", name)
+		}
+	}
+}
+
+// TestCommandKeys tests the keys of the commands whose keys are not only the first argument
+func TestCommandKeys(t *testing.T) {
+	tests := []struct {
+		cmdLine []string
+		keys    []string
+	}{
+		{[]string{"GET", "k"}, []string{"k"}},
+		{[]string{"MSET", "a", "1", "b", "2"}, []string{"a", "b"}},
+		{[]string{"BLPOP", "a", "b", "0"}, []string{"a", "b"}},
+		{[]string{"LMOVE", "a", "b", "LEFT", "RIGHT"}, []string{"a", "b"}},
+		{[]string{"OBJECT", "ENCODING", "k"}, []string{"k"}},
+		{[]string{"EVAL", "return 1", "2", "a", "b", "arg"}, []string{"a", "b"}},
+		{[]string{"XREAD", "COUNT", "1", "STREAMS", "a", "b", "0", "0"}, []string{"a", "b"}},
+		{[]string{"PING"}, nil},
+	}
+	for _, tt := range tests {
+		cmdLine := make([][]byte, len(tt.cmdLine))
+		for i, arg := range tt.cmdLine {
+			cmdLine[i] = []byte(arg)
+		}
+		keys, ok := CommandKeys(cmdLine)
+		if !ok || len(keys) != len(tt.keys) {
+			t.Errorf("%v: expected the keys %v, got %v", tt.cmdLine, tt.keys, keys)
+			continue
+		}
+		for i := range keys {
+			if keys[i] != tt.keys[i] {
+				t.Errorf("%v: expected the keys %v, got %v", tt.cmdLine, tt.keys, keys)
+			}
+		}
+	}
+}
+
+// TestIncompleteKeyGroups tests that a key without its value is rejected before the command runs
+func TestIncompleteKeyGroups(t *testing.T) {
+	db := MakeDB()
+	assertReply(t, exec(db, "MSET", "a", "1", "b"), "-ERR wrong number of arguments for 'mset' command\r\n")
+	assertReply(t, exec(db, "MSETNX", "a", "1", "b"), "-ERR wrong number of arguments for 'msetnx' command\r\n")
+	assertReply(t, exec(db, "EXISTS", "a"), ":0\r\n")
+	assertReply(t, exec(db, "HSET", "h", "a", "1", "b"), "-ERR wrong number of arguments for 'hset' command\r\n")
+	assertReply(t, exec(db, "HSET", "h", "a", "1", "b", "2"), ":2\r\n")
+	assertReply(t, exec(db, "HSET", "h", "a", "3", "c", "4"), ":1\r\n")
+}
+
+func TestCommandCommand(t *testing.T) {
+	db := MakeDB()
+	assertReply(t, exec(db, "COMMAND", "INFO", "mset", "nosuch"),
+		"*2\r\n*10\r\n$4\r\nmset\r\n:-3\r\n*1\r\n$5\r\nwrite\r\n:1\r\n:-1\r\n:2\r\n*0\r\n*0\r\n*0\r\n*0\r\n*-1\r\n")
+	assertReply(t, exec(db, "COMMAND", "GETKEYS", "MSET", "a", "1", "b", "2"), "*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+	assertReply(t, exec(db, "COMMAND", "GETKEYS", "EVAL", "return 1", "0"), "*0\r\n")
+	assertReply(t, exec(db, "COMMAND", "GETKEYS", "MSET", "a"), "-ERR Invalid number of arguments specified for command\r\n")
+	assertReply(t, exec(db, "COMMAND", "GETKEYS", "PING"), "-ERR The command has no key arguments\r\n")
+	assertReply(t, exec(db, "COMMAND", "GETKEYS", "NOSUCH"), "-ERR Invalid command specified\r\n")
+	assertReply(t, exec(db, "COMMAND", "COUNT"), ":"+strconv.Itoa(len(cmdTable))+"\r\n")
+}
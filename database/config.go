@@ -0,0 +1,163 @@
+package database
+
+import (
+	"redigo/aof"
+	"redigo/config"
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/lib/wildcard"
+	"redigo/pubsub"
+	"redigo/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configParameters are the parameters whose value replied by CONFIG GET is resolved from the
+// configuration, the others are replied as configured
+var configParameters = map[string]func() string{
+	"databases":         func() string { return strconv.Itoa(config.Properties.Databases) },
+	"maxmemory-policy":  maxMemoryPolicy,
+	"maxmemory-samples": func() string { return strconv.Itoa(maxMemorySamples()) },
+	"appendfsync":       aof.FsyncPolicy,
+}
+
+// configSetters are the parameters CONFIG SET changes at runtime, a setter returns the error
+// message of an invalid or failed change
+var configSetters = map[string]func(d *StandaloneDatabase, name, value string) string{
+	"appendonly":                 setAppendOnly,
+	"appendfsync":                setOneOf(aof.FsyncAlways, aof.FsyncEverySec, aof.FsyncNo),
+	"requirepass":                setParameter,
+	"maxclients":                 setNonNegative,
+	"max-write-elements":         setNonNegative,
+	"command-timeout":            setWatchdogPeriod,
+	"lua-time-limit":             setNonNegative,
+	"watchdog-period":            setWatchdogPeriod,
+	"keys-max-scan":              setNonNegative,
+	"keys-over-budget":           setOneOf("error", "truncate"),
+	"notify-keyspace-events":     setNotifyKeyspaceEvents,
+	"client-output-buffer-limit": setClientOutputBufferLimit,
+}
+
+// execConfig implements the CONFIG command
+// CONFIG GET parameter [parameter ...]
+// CONFIG SET parameter value [parameter value ...]
+// CONFIG REWRITE
+func execConfig(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("config")
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "GET":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply("config|get")
+		}
+		return execConfigGet(args[1:])
+	case "SET":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return reply.MakeArgNumErrReply("config|set")
+		}
+		return execConfigSet(d, args[1:])
+	case "REWRITE":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("config|rewrite")
+		}
+		if err := config.Rewrite(); err != nil {
+			logger.Error("CONFIG REWRITE failed: " + err.Error())
+			return reply.MakeStandardErrorReply("ERR " + err.Error())
+		}
+		logger.Info("CONFIG REWRITE executed with success")
+		return reply.MakeOKReply()
+	}
+	return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[0]) + "'. Try CONFIG GET, CONFIG SET, CONFIG REWRITE.")
+}
+
+// execConfigGet replies the parameters matching any of the glob-style patterns, in the order of
+// their names
+func execConfigGet(patterns [][]byte) resp.Reply {
+	var names []string
+	for _, name := range config.Parameters() {
+		for _, pattern := range patterns {
+			if wildcard.CompilePattern(strings.ToLower(string(pattern))).IsMatch(name) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	keys := make([]resp.Reply, len(names))
+	values := make([]resp.Reply, len(names))
+	for i, name := range names {
+		keys[i] = reply.MakeBulkReply([]byte(name))
+		values[i] = reply.MakeBulkReply([]byte(configValue(name)))
+	}
+	return reply.MakeMapReply(keys, values)
+}
+
+// execConfigSet applies the parameter value pairs in order, it stops at the first one which is
+// unknown or fails, the pairs before it stay applied
+func execConfigSet(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(string(args[i]))
+		setter, ok := configSetters[name]
+		if !ok {
+			return reply.MakeStandardErrorReply("ERR Unknown option or number of arguments for CONFIG SET - '" + name + "'")
+		}
+		if msg := setter(d, name, string(args[i+1])); msg != "" {
+			return reply.MakeStandardErrorReply("ERR CONFIG SET failed (possibly related to argument '" + name + "') - " + msg)
+		}
+	}
+	return reply.MakeOKReply()
+}
+
+// configValue returns the value of a parameter replied by CONFIG GET
+func configValue(name string) string {
+	if value, ok := configParameters[name]; ok {
+		return value()
+	}
+	value, _ := config.Get(name)
+	return value
+}
+
+// setParameter sets a parameter which takes any value
+func setParameter(d *StandaloneDatabase, name, value string) string {
+	if err := config.Set(name, value); err != nil {
+		return err.Error()
+	}
+	logger.Info("CONFIG SET " + name)
+	return ""
+}
+
+// setNonNegative sets a parameter taking an integer, 0 disables the limits
+func setNonNegative(d *StandaloneDatabase, name, value string) string {
+	if n, err := strconv.Atoi(value); err != nil || n < 0 {
+		return "argument must be a non-negative integer"
+	}
+	return setParameter(d, name, value)
+}
+
+// setClientOutputBufferLimit sets the limit of the messages queued for the subscribers
+func setClientOutputBufferLimit(d *StandaloneDatabase, name, value string) string {
+	limit, err := pubsub.ParseOutputLimit(value)
+	if err != nil {
+		return err.Error()
+	}
+	if msg := setParameter(d, name, value); msg != "" {
+		return msg
+	}
+	d.hub.SetOutputLimit(limit)
+	return ""
+}
+
+// setOneOf returns the setter of a parameter taking one of the values
+func setOneOf(values ...string) func(d *StandaloneDatabase, name, value string) string {
+	return func(d *StandaloneDatabase, name, value string) string {
+		value = strings.ToLower(value)
+		for _, v := range values {
+			if v == value {
+				return setParameter(d, name, value)
+			}
+		}
+		return "argument must be one of " + strings.Join(values, ", ")
+	}
+}
@@ -0,0 +1,317 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"redigo/interface/database"
+	"redigo/lib/utils"
+	"redigo/rdb"
+	"redigo/resp/reply"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A snapshot is a point in time view of the dataset written out while the write commands go on,
+// copy on write like the fork of Redis:
+//   - it starts while the writes are paused, which only waits for the running write commands
+//   - before a write changes keys, it saves them to the snapshots being written: a key the
+//     snapshot does not have yet is encoded to memory as it was at the start
+//   - the snapshot goes through the keys of each DB, encodes those it does not have yet and
+//     writes them out with the keys saved by the writes
+//
+// A snapshot only holds in memory the keys changed while it is written. The keys which expire in
+// the meantime are left out, like the expired keys at the start
+
+// keyEncoder returns the function appending a key with its value to buf in the format of a
+// snapshot, expireAt is zero if the key does not expire
+type keyEncoder func(buf *bytes.Buffer) encodeKey
+
+type encodeKey func(key string, entity *database.DataEntity, expireAt time.Time) error
+
+// snapshot is a snapshot being written, started by startSnapshot
+type snapshot struct {
+	set *snapshots
+	mu  sync.Mutex
+	dbs []snapshotDB
+	// err is the first error encoding a key saved by a write
+	err   error
+	ended atomic.Bool
+}
+
+// snapshotDB is a DB in a snapshot, db is nil if the DB was empty at the start
+type snapshotDB struct {
+	db *DB
+	// keys and expires are the numbers of keys and of volatile keys at the start
+	keys    int
+	expires int
+	// saved holds the keys the snapshot has, or knows were absent at the start
+	saved map[string]struct{}
+	// pending holds the keys encoded by encode which are not written out yet
+	pending *bytes.Buffer
+	encode  encodeKey
+	// written is set once the snapshot went through the keys, the writes need not save them
+	written bool
+	// complete is set once all keys are saved, before a flush, the keys not saved were absent
+	complete bool
+}
+
+// snapshots are the snapshots being written, the DBs save their keys to them before a write
+type snapshots struct {
+	mu     sync.Mutex
+	active atomic.Pointer[[]*snapshot]
+}
+
+// startSnapshot starts a snapshot of the dataset in the format of encode, it must be called while
+// the writes are paused. The snapshot must be ended once written
+func (d *StandaloneDatabase) startSnapshot(encode keyEncoder) *snapshot {
+	s := &snapshot{set: d.snapshots, dbs: make([]snapshotDB, len(d.dbSet))}
+	for i := range d.dbSet {
+		if db := d.dbSet[i].Load(); db != nil && db.data.Len() > 0 {
+			pending := &bytes.Buffer{}
+			s.dbs[i] = snapshotDB{
+				db:      db,
+				keys:    db.data.Len(),
+				expires: db.expires.len(),
+				saved:   make(map[string]struct{}),
+				pending: pending,
+				encode:  encode(pending),
+			}
+		}
+	}
+	d.snapshots.add(s)
+	return s
+}
+
+// end stops the writes saving their keys to the snapshot
+func (s *snapshot) end() {
+	if s.ended.CompareAndSwap(false, true) {
+		s.set.remove(s)
+	}
+}
+
+// save saves the keys of the DB of index before a write changes them
+func (s *snapshot) save(index int, keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sdb := &s.dbs[index]
+	if sdb.db == nil || sdb.written {
+		return
+	}
+	for _, key := range keys {
+		s.saveKey(sdb, key)
+	}
+}
+
+// saveAll saves all keys of the DB of index, before it is flushed
+func (s *snapshot) saveAll(index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sdb := &s.dbs[index]
+	if sdb.db == nil || sdb.written {
+		return
+	}
+	sdb.db.data.ForEach(func(key string, _ interface{}) bool {
+		s.saveKey(sdb, key)
+		return true
+	})
+	sdb.complete = true
+}
+
+// saveKey encodes the key unless the snapshot has it already, s.mu must be held
+func (s *snapshot) saveKey(sdb *snapshotDB, key string) {
+	if _, ok := sdb.saved[key]; ok || sdb.complete {
+		return
+	}
+	sdb.saved[key] = struct{}{}
+	raw, ok := sdb.db.data.Get(key)
+	if !ok || sdb.db.isExpired(key) {
+		return
+	}
+	expireAt, _ := sdb.db.ExpireTime(key)
+	if err := sdb.encode(key, raw.(*database.DataEntity), expireAt); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// write goes through the keys of each DB, startDB is called before the keys of a DB and
+// writeKeys with the encoded keys
+func (s *snapshot) write(startDB func(sdb *snapshotDB) error, writeKeys func(data []byte) error) error {
+	for i := range s.dbs {
+		sdb := &s.dbs[i]
+		if sdb.db == nil {
+			continue
+		}
+		if err := startDB(sdb); err != nil {
+			return err
+		}
+		var err error
+		sdb.db.data.ScanBuckets(0, func(keys []string, _ uint64) bool {
+			err = s.writeOut(sdb, keys, false, writeKeys)
+			return err == nil
+		})
+		if err == nil {
+			err = s.writeOut(sdb, nil, true, writeKeys)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// writeOut saves the keys and writes out those pending, last marks the DB as written
+func (s *snapshot) writeOut(sdb *snapshotDB, keys []string, last bool, writeKeys func(data []byte) error) error {
+	s.mu.Lock()
+	for _, key := range keys {
+		s.saveKey(sdb, key)
+	}
+	var pending []byte
+	if sdb.pending.Len() > 0 {
+		pending = bytes.Clone(sdb.pending.Bytes())
+		sdb.pending.Reset()
+	}
+	if last {
+		sdb.written = true
+		sdb.saved, sdb.pending, sdb.encode = nil, nil, nil
+	}
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	return writeKeys(pending)
+}
+
+// writeRDB writes the snapshot to w in the RDB format, it must be started with encodeRDBKeys
+func (s *snapshot) writeRDB(w io.Writer) error {
+	enc := rdb.NewEncoder(w)
+	err := enc.WriteHeader(map[string]string{
+		"redigo-ver": redigoVersion,
+		"ctime":      strconv.FormatInt(time.Now().Unix(), 10),
+	})
+	if err != nil {
+		return err
+	}
+	err = s.write(func(sdb *snapshotDB) error {
+		return enc.WriteDBHeader(sdb.db.index, sdb.keys, sdb.expires)
+	}, enc.WriteEncoded)
+	if err != nil {
+		return err
+	}
+	return enc.WriteEnd()
+}
+
+// writeCommands writes the snapshot to w as the commands rebuilding it, it must be started with
+// encodeCommands
+func (s *snapshot) writeCommands(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	writeKeys := func(data []byte) error {
+		_, err := bw.Write(data)
+		return err
+	}
+	err := s.write(func(sdb *snapshotDB) error {
+		return writeKeys(reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(sdb.db.index))).ToBytes())
+	}, writeKeys)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// encodeRDBKeys encodes the keys in the RDB format
+func encodeRDBKeys(buf *bytes.Buffer) encodeKey {
+	enc := rdb.NewEncoder(buf)
+	return func(key string, entity *database.DataEntity, expireAt time.Time) error {
+		if !expireAt.IsZero() {
+			if err := enc.WriteExpire(expireAt.UnixMilli()); err != nil {
+				return err
+			}
+		}
+		if err := writeEntity(enc, key, entity); err != nil {
+			return err
+		}
+		return enc.Flush()
+	}
+}
+
+// encodeCommands encodes the keys as the commands rebuilding them, with a PEXPIREAT for those
+// with a TTL
+func encodeCommands(buf *bytes.Buffer) encodeKey {
+	return func(key string, entity *database.DataEntity, expireAt time.Time) error {
+		cmdLines, err := rewriteEntity(key, entity)
+		if err != nil {
+			return err
+		}
+		if !expireAt.IsZero() {
+			cmdLines = append(cmdLines, makeExpireCmd(key, expireAt))
+		}
+		for _, cmdLine := range cmdLines {
+			buf.Write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+		}
+		return nil
+	}
+}
+
+func (ss *snapshots) add(s *snapshot) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	var list []*snapshot
+	if active := ss.active.Load(); active != nil {
+		list = append(list, *active...)
+	}
+	list = append(list, s)
+	ss.active.Store(&list)
+}
+
+func (ss *snapshots) remove(s *snapshot) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	active := ss.active.Load()
+	if active == nil {
+		return
+	}
+	var list []*snapshot
+	for _, other := range *active {
+		if other != s {
+			list = append(list, other)
+		}
+	}
+	if len(list) == 0 {
+		ss.active.Store(nil)
+		return
+	}
+	ss.active.Store(&list)
+}
+
+// save saves the keys of db to the snapshots being written, before a write changes them
+func (ss *snapshots) save(db *DB, keys ...string) {
+	if ss == nil {
+		return
+	}
+	if active := ss.active.Load(); active != nil {
+		for _, s := range *active {
+			s.save(db.index, keys)
+		}
+	}
+}
+
+// saveAll saves all keys of db to the snapshots being written, before it is flushed
+func (ss *snapshots) saveAll(db *DB) {
+	if ss == nil {
+		return
+	}
+	if active := ss.active.Load(); active != nil {
+		for _, s := range *active {
+			s.saveAll(db.index)
+		}
+	}
+}
+
+// writing reports whether snapshots are being written
+func (ss *snapshots) writing() bool {
+	return ss != nil && ss.active.Load() != nil
+}
@@ -8,83 +8,167 @@ import (
 	"redigo/interface/database"
 	"redigo/interface/resp"
 	"redigo/resp/reply"
-	"strings"
+	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 // KeyLockManager manages locks for individual keys
+// Locks are reference counted and released when no goroutine holds or waits for them, so that
+// removing a key while its lock is held never strands the goroutines waiting for it
 type KeyLockManager struct {
-	locks sync.Map // map[string]*sync.RWMutex
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is the lock of a key with the number of goroutines holding or waiting for it
+type keyLock struct {
+	sync.RWMutex
+	refs int
+}
+
+// keyLocks recycles the locks of the keys, a command locks its keys once or more
+// A lock is put back only when its last reference is dropped, once it is unlocked and removed
+// from the manager, so no goroutine can still hold or wait for it
+var keyLocks = sync.Pool{
+	New: func() any { return &keyLock{} },
 }
 
 // NewKeyLockManager creates a new KeyLockManager instance
 func NewKeyLockManager() *KeyLockManager {
-	return &KeyLockManager{}
+	return &KeyLockManager{locks: make(map[string]*keyLock)}
+}
+
+// acquire returns the lock of the key, creating it if needed, and takes a reference on it
+func (klm *KeyLockManager) acquire(key string) *keyLock {
+	klm.mu.Lock()
+	defer klm.mu.Unlock()
+	lock, ok := klm.locks[key]
+	if !ok {
+		lock = keyLocks.Get().(*keyLock)
+		klm.locks[key] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+// release unlocks the lock of the key with unlock and drops a reference on it, removing the
+// lock when it is no longer used
+// Unlocking never blocks, so it is done under the mutex of the manager: the lock cannot be
+// recycled between the unlock and the drop of the reference
+func (klm *KeyLockManager) release(key string, unlock func(lock *keyLock)) {
+	klm.mu.Lock()
+	defer klm.mu.Unlock()
+	lock, ok := klm.locks[key]
+	if !ok {
+		return
+	}
+	unlock(lock)
+	lock.refs--
+	if lock.refs == 0 {
+		delete(klm.locks, key)
+		keyLocks.Put(lock)
+	}
 }
 
 // Lock acquires a write lock for the given key
 func (klm *KeyLockManager) Lock(key string) {
-	lockInterface, _ := klm.locks.LoadOrStore(key, &sync.RWMutex{})
-	lock := lockInterface.(*sync.RWMutex)
 	// If the lock is locked, it will block until it can acquire the lock
-	lock.Lock()
+	klm.acquire(key).Lock()
 }
 
 // Unlock releases a write lock for the given key
 func (klm *KeyLockManager) Unlock(key string) {
-	if lockInterface, ok := klm.locks.Load(key); ok {
-		lock := lockInterface.(*sync.RWMutex)
-		lock.Unlock()
-	}
+	klm.release(key, (*keyLock).Unlock)
 }
 
 // RLock acquires a read lock for the given key
 func (klm *KeyLockManager) RLock(key string) {
-	lockInterface, _ := klm.locks.LoadOrStore(key, &sync.RWMutex{})
-	lock := lockInterface.(*sync.RWMutex)
-	lock.RLock()
+	klm.acquire(key).RLock()
 }
 
 // RUnlock releases a read lock for the given key
 func (klm *KeyLockManager) RUnlock(key string) {
-	if lockInterface, ok := klm.locks.Load(key); ok {
-		lock := lockInterface.(*sync.RWMutex)
-		lock.RUnlock()
+	klm.release(key, (*keyLock).RUnlock)
+}
+
+// lockOrder returns the distinct keys in the order they must be locked, so that goroutines
+// locking overlapping sets of keys never deadlock
+func lockOrder(keys []string) []string {
+	ordered := make([]string, 0, len(keys))
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			ordered = append(ordered, key)
+		}
 	}
+	sort.Strings(ordered)
+	return ordered
 }
 
-// CleanupLock removes the lock for a deleted key to prevent memory leaks
-// This should be called when a key is permanently deleted from the database
-func (klm *KeyLockManager) CleanupLock(key string) {
-	// Only delete the lock if no one is using it
-	// In practice, this should be called after ensuring no operations are pending on this key
-	klm.locks.Delete(key)
+// keyLocker locks the keys of a DB, the KeyLockManager or the locks already held by a script
+type keyLocker interface {
+	Lock(key string)
+	Unlock(key string)
+	RLock(key string)
+	RUnlock(key string)
 }
 
 type DB struct {
 	index   int
 	data    dict.Dict
 	addAof  func(CmdLine)
-	lockMgr *KeyLockManager
+	lockMgr keyLocker
+	// blocking holds the clients blocked on the keys by BLPOP, BRPOP and XREAD BLOCK
+	blocking *blockingRegistry
+	// hotKeys counts the accesses to the keys
+	hotKeys *hotKeys
+	// expires holds the expiration time of the volatile keys
+	expires *expireTable
+	// used is the estimated memory of the values, compared to maxmemory
+	used *atomic.Int64
+	// events delivers the changes of the keys to the subsystems observing them
+	events *eventBus
+	// writeGate runs the writes of the blocking commands, which wait outside of the gate of the
+	// write commands; it returns an error instead of running them once the database is closing
+	writeGate func(fn func()) resp.Reply
+	// snapshots are the snapshots being written, the keys are saved to them before they change
+	snapshots *snapshots
 }
 
 // MakeDB creates a new DB instance
 func MakeDB() *DB {
-	return &DB{
-		index: 0,
-		data:  dict.MakeSyncDict(),
-		addAof: func(line CmdLine) {
-			// No-op by default,
-			// can be overridden by the database instance
+	db := &DB{
+		index:    0,
+		data:     dict.MakeSyncDict(),
+		lockMgr:  NewKeyLockManager(),
+		blocking: makeBlockingRegistry(),
+		hotKeys:  makeHotKeys(),
+		expires:  makeExpireTable(),
+		used:     new(atomic.Int64),
+		events:   makeEventBus(),
+		writeGate: func(fn func()) resp.Reply {
+			fn()
+			return nil
 		},
-		lockMgr: NewKeyLockManager(),
 	}
+	// the propagated write commands are published on the event bus, the database instance
+	// subscribes to them to write the AOF
+	db.addAof = func(line CmdLine) {
+		db.events.publish(keyEvent{typ: eventWritten, db: db, cmdLine: line})
+	}
+	return db
 }
 
 // ExecFunc is a function type that takes a DB instance and a slice of byte slices as arguments and returns a resp.Reply
 // All redis commands like PING, SET, GET, etc. are implemented as functions of this type
 type ExecFunc func(db *DB, args [][]byte) resp.Reply
 
+// BlockingExecFunc is the function of the commands which may block, it gets the client so that the
+// command is released when the client disconnects. The client is nil if the command is not sent by a client
+type BlockingExecFunc func(db *DB, client resp.Connection, args [][]byte) resp.Reply
+
 // CmdLine is a type alias for a slice of byte slices
 // It is used to represent the command line arguments passed to the ExecFunc
 type CmdLine = [][]byte
@@ -95,7 +179,7 @@ type CmdLine = [][]byte
 func (db *DB) Exec(c resp.Connection, cmdLine CmdLine) resp.Reply {
 	// The first element of cmdLine is the command name, like "PING", "SET", etc.
 	// Convert it to lowercase to ensure case-insensitivity
-	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmdName := commandName(cmdLine[0])
 	// Get the command from the command table using the command name
 	// If the command is not found, return an error reply
 	cmd, ok := cmdTable[cmdName]
@@ -103,11 +187,26 @@ func (db *DB) Exec(c resp.Connection, cmdLine CmdLine) resp.Reply {
 		return reply.MakeStandardErrorReply("ERR unknown command '" + cmdName + "'")
 	}
 	// Validate the number of arguments passed to the command
-	if !ValidateArity(cmd.arity, cmdLine) {
+	if !cmd.validArgs(cmdLine) {
 		return reply.MakeArgNumErrReply(cmdName)
 	}
+	if !cmd.readOnly && db.snapshots.writing() {
+		keys, _ := CommandKeys(cmdLine)
+		db.snapshots.save(db, keys...)
+	}
 	// Execute the command and return the response
-	return cmd.exec(db, cmdLine[1:])
+	var result resp.Reply
+	if cmd.blockingExec != nil {
+		result = cmd.blockingExec(db, c, cmdLine[1:])
+	} else if timeout := commandTimeout(cmdLine); timeout > 0 {
+		result = db.execWithTimeout(cmdName, cmd, cmdLine[1:], timeout)
+	} else {
+		result = cmd.exec(db, cmdLine[1:])
+	}
+	if !cmd.readOnly {
+		db.resizeKeys(cmdLine)
+	}
+	return result
 }
 
 // ValidateArity checks if the number of arguments passed to a command is valid
@@ -125,38 +224,128 @@ func ValidateArity(arity int, args [][]byte) bool {
 // GetEntity returns DataEntity bind to the given key
 func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
 	raw, ok := db.data.Get(key)
-	if !ok {
+	if !ok || db.expireIfNeeded(key) {
 		return nil, false
 	}
 	entity, _ := raw.(*database.DataEntity)
+	if entity != nil {
+		entity.Touch()
+	}
+	db.hotKeys.touch(key)
 	return entity, true
 }
 
+// peekEntity returns the DataEntity bind to the given key without counting an access, for the
+// commands inspecting the keys like OBJECT
+func (db *DB) peekEntity(key string) (*database.DataEntity, bool) {
+	raw, ok := db.data.Get(key)
+	if !ok || db.expireIfNeeded(key) {
+		return nil, false
+	}
+	entity, _ := raw.(*database.DataEntity)
+	return entity, entity != nil
+}
+
 // PutEntity stores the given DataEntity in the database
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
-	return db.data.Put(key, entity)
+	entity.Touch()
+	db.release(key, entity)
+	result := db.data.Put(key, entity)
+	db.account(key, entity)
+	return result
 }
 
 // PutIfExists edit the given DataEntity in the database
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
-	return db.data.PutIfExists(key, entity)
+	entity.Touch()
+	old, _ := db.data.Get(key)
+	result := db.data.PutIfExists(key, entity)
+	if result > 0 {
+		if old, _ := old.(*database.DataEntity); old != nil && old != entity {
+			db.used.Add(-old.Release())
+		}
+		db.account(key, entity)
+	}
+	return result
 }
 
-// PutIfAbsent stores the given DataEntity in the database if it doesn't already exist
+// PutIfAbsent stores the given DataEntity in the database if it doesn't already exist, an
+// expired key which is not reclaimed yet does not exist
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
-	return db.data.PutIfAbsent(key, entity)
+	entity.Touch()
+	db.expireIfNeeded(key)
+	result := db.data.PutIfAbsent(key, entity)
+	if result > 0 {
+		db.account(key, entity)
+	}
+	return result
 }
 
 // Remove deletes the DataEntity associated with the given key from the database
 func (db *DB) Remove(key string) int {
-	result := db.data.Remove(key)
-	// Clean up the lock for the deleted key to prevent memory leaks
+	return db.removeKey(key, eventDeleted)
+}
+
+// removeEmptied deletes a collection the command emptied, like Remove
+func (db *DB) removeEmptied(key string) int {
+	return db.removeEvent(keyEvent{typ: eventDeleted, db: db, key: key, emptied: true})
+}
+
+// removeKey deletes the key and publishes its removal as an event of the type
+func (db *DB) removeKey(key string, typ eventType) int {
+	return db.removeEvent(keyEvent{typ: typ, db: db, key: key})
+}
+
+func (db *DB) removeEvent(event keyEvent) int {
+	db.release(event.key, nil)
+	result := db.data.Remove(event.key)
 	if result > 0 {
-		db.lockMgr.CleanupLock(key)
+		db.events.publish(event)
 	}
 	return result
 }
 
+// account adds the estimated memory of the value stored at key to the used memory
+func (db *DB) account(key string, entity *database.DataEntity) {
+	_, size := estimateSize(key, entity, sizeSamples)
+	db.used.Add(entity.Account(int64(size)))
+}
+
+// release removes the memory of the value stored at key from the used memory, unless it is
+// replaced by the same value
+func (db *DB) release(key string, replacement *database.DataEntity) {
+	raw, ok := db.data.Get(key)
+	if !ok {
+		return
+	}
+	if old, _ := raw.(*database.DataEntity); old != nil && old != replacement {
+		db.used.Add(-old.Release())
+	}
+}
+
+// resizeKeys updates the used memory with the values of the keys of a write command, which may
+// have been modified in place
+// The keys are locked while their values are sampled, the commands of other clients may be
+// modifying them
+func (db *DB) resizeKeys(cmdLine CmdLine) {
+	keys, ok := CommandKeys(cmdLine)
+	if !ok || len(keys) == 0 {
+		return
+	}
+	db.WithKeysLock(keys, func() {
+		for _, key := range keys {
+			raw, ok := db.data.Get(key)
+			if !ok {
+				continue
+			}
+			if entity, _ := raw.(*database.DataEntity); entity != nil {
+				_, size := estimateSize(key, entity, sizeSamples)
+				db.used.Add(entity.Resize(int64(size)))
+			}
+		}
+	})
+}
+
 // GetAsHash retrieves the DataEntity associated with the given key and checks if it is a hash
 func (db *DB) getAsHash(key string) (*hash.Hash, bool) {
 	entity, ok := db.GetEntity(key)
@@ -184,6 +373,20 @@ func (db *DB) getOrCreateHash(key string) (*hash.Hash, bool) {
 	return hashObj, false
 }
 
+// getAsString returns the value of a string key, nil if the key does not exist
+func getAsString(db *DB, key string) ([]byte, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+
+	value, ok := entity.Data.([]byte)
+	if !ok {
+		return nil, reply.MakeWrongTypeErrReply()
+	}
+	return value, nil
+}
+
 // getAsSet returns a set.Set from database
 func getAsSet(db *DB, key string) (set.Set, reply.ErrorReply) {
 	entity, exists := db.GetEntity(key)
@@ -238,10 +441,13 @@ func (db *DB) Removes(keys ...string) int {
 	for _, key := range keys {
 		_, ok := db.data.Get(key)
 		if ok {
-			db.data.Remove(key)
-			// Clean up the lock for the deleted key to prevent memory leaks
-			db.lockMgr.CleanupLock(key)
-			deleted++
+			// an expired key is not counted, its removal is an expiration
+			if db.isExpired(key) {
+				db.removeKey(key, eventExpired)
+			} else {
+				db.removeKey(key, eventDeleted)
+				deleted++
+			}
 		}
 	}
 	return deleted
@@ -249,9 +455,11 @@ func (db *DB) Removes(keys ...string) int {
 
 // Flush clears the database by removing all DataEntity objects
 func (db *DB) Flush() {
+	db.snapshots.saveAll(db)
 	db.data.Clear()
-	// Clear all locks when flushing the database
-	db.lockMgr.locks = sync.Map{}
+	db.used.Store(0)
+	db.expires.clear()
+	db.hotKeys.reset()
 }
 
 // WithKeyLock executes the given function with a write lock on the specified key
@@ -261,6 +469,34 @@ func (db *DB) WithKeyLock(key string, fn func()) {
 	fn()
 }
 
+// WithKeysLock executes the given function with write locks on all the keys
+func (db *DB) WithKeysLock(keys []string, fn func()) {
+	ordered := lockOrder(keys)
+	for _, key := range ordered {
+		db.lockMgr.Lock(key)
+	}
+	defer func() {
+		for i := len(ordered) - 1; i >= 0; i-- {
+			db.lockMgr.Unlock(ordered[i])
+		}
+	}()
+	fn()
+}
+
+// WithKeysRLock executes the given function with read locks on all the keys
+func (db *DB) WithKeysRLock(keys []string, fn func()) {
+	ordered := lockOrder(keys)
+	for _, key := range ordered {
+		db.lockMgr.RLock(key)
+	}
+	defer func() {
+		for i := len(ordered) - 1; i >= 0; i-- {
+			db.lockMgr.RUnlock(ordered[i])
+		}
+	}()
+	fn()
+}
+
 // WithKeyRLock executes the given function with a read lock on the specified key
 func (db *DB) WithKeyRLock(key string, fn func()) {
 	db.lockMgr.RLock(key)
@@ -268,6 +504,28 @@ func (db *DB) WithKeyRLock(key string, fn func()) {
 	fn()
 }
 
+// streamMinElements is the number of elements from which a reply is written out from the value
+// itself, the smaller replies are copied so that the key is not locked while they are sent
+const streamMinElements = 1024
+
+// elementsReply returns a reply whose elements are written out by produce from the value of key,
+// while the connection holds the read lock of the key. The value is looked up again then, it may
+// have changed since the command ran: produce replies an error if it is not of the expected type
+// anymore, and a missing key gives an empty reply
+func (db *DB) elementsReply(prefix byte, key string, produce func(entity *database.DataEntity, header func(n int), element func(elem []byte) bool) reply.ErrorReply) resp.Reply {
+	hold := func(fn func()) {
+		db.WithKeyRLock(key, fn)
+	}
+	return reply.MakeElementsReply(prefix, hold, func(header func(n int), element func(elem []byte) bool) reply.ErrorReply {
+		entity, ok := db.peekEntity(key)
+		if !ok {
+			header(0)
+			return nil
+		}
+		return produce(entity, header, element)
+	})
+}
+
 // WithKeyLockReturn executes the given function with a write lock on the specified key and returns the result
 func (db *DB) WithKeyLockReturn(key string, fn func() interface{}) interface{} {
 	db.lockMgr.Lock(key)
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"redigo/interface/database"
+	"redigo/interface/resp"
+	"redigo/lib/failpoint"
+	"redigo/lib/logger"
+	"redigo/resp/reply"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// newReplID generates a random replication ID of 40 hex characters
+func newReplID() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// ReplID returns the replication ID of the server, reported by INFO replication
+func (d *StandaloneDatabase) ReplID() string {
+	return d.replID.Load().(string)
+}
+
+// execDebug implements the DEBUG command
+// DEBUG RELOAD
+// DEBUG CHANGE-REPL-ID
+// DEBUG GO-STATS
+// DEBUG FAILPOINT [name action|OFF]
+// DEBUG EVICTION-POOL
+// DEBUG QUICKCHECK key
+func execDebug(d *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("debug")
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "RELOAD":
+		if len(args) != 1 {
+			return reply.MakeSyntaxErrReply()
+		}
+		return execDebugReload(d)
+	case "CHANGE-REPL-ID":
+		if len(args) != 1 {
+			return reply.MakeSyntaxErrReply()
+		}
+		d.replID.Store(newReplID())
+		logger.Info("replication ID changed to " + d.ReplID())
+		return reply.MakeOKReply()
+	case "GO-STATS":
+		if len(args) != 1 {
+			return reply.MakeSyntaxErrReply()
+		}
+		return execDebugGoStats()
+	case "FAILPOINT":
+		return execDebugFailpoint(args[1:])
+	case "EVICTION-POOL":
+		if len(args) != 1 {
+			return reply.MakeSyntaxErrReply()
+		}
+		var sb strings.Builder
+		d.evictions.describe(&sb)
+		return reply.MakeBulkReply([]byte(sb.String()))
+	case "QUICKCHECK":
+		if len(args) != 2 {
+			return reply.MakeSyntaxErrReply()
+		}
+		return execDebugQuickCheck(d.getDB(c.GetDBIndex()), string(args[1]))
+	}
+	return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[0]) + "'. Try DEBUG RELOAD, DEBUG CHANGE-REPL-ID, DEBUG GO-STATS, DEBUG FAILPOINT, DEBUG EVICTION-POOL or DEBUG QUICKCHECK.")
+}
+
+// execDebugQuickCheck checks the value of a key against the invariants of its type, like the
+// integrity check of the startup, and reports the result in the format of INFO:
+//
+//	type:zset
+//	status:corrupted
+//	violation:skiplist: wrong span 3 at level 2 before member "m17"
+//
+// The output is meant to be attached to bug reports, the value itself is not included
+func execDebugQuickCheck(db *DB, key string) resp.Reply {
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		// read the dict directly so that the check is not counted as an access
+		raw, ok := db.data.Get(key)
+		if !ok || db.isExpired(key) {
+			result = reply.MakeStandardErrorReply("ERR no such key")
+			return
+		}
+		entity := raw.(*database.DataEntity)
+		var sb strings.Builder
+		sb.WriteString("type:" + typeOf(entity) + "\r\n")
+		if err := verifyEntity(entity); err != nil {
+			sb.WriteString("status:corrupted\r\n")
+			sb.WriteString("violation:" + err.Error() + "\r\n")
+		} else {
+			// counting the elements walks the structure, which is only safe once it is verified
+			elements, _ := estimateSize(key, entity, 1)
+			sb.WriteString("elements:" + strconv.Itoa(elements) + "\r\n")
+			sb.WriteString("status:ok\r\n")
+		}
+		result = reply.MakeBulkReply([]byte(sb.String()))
+	})
+	return result
+}
+
+// execDebugReload saves the dataset to the RDB file and loads it back, to check that every
+// value survives a round trip through the snapshot format
+// Writes of other clients between the save and the load are lost, the command is meant for tests
+func execDebugReload(d *StandaloneDatabase) resp.Reply {
+	filename := rdbFilename()
+	if err := d.SaveRDB(filename); err != nil {
+		logger.Error("DEBUG RELOAD save failed: " + err.Error())
+		return reply.MakeStandardErrorReply("ERR Error trying to save the DB: " + err.Error())
+	}
+	if err := d.LoadRDB(filename); err != nil {
+		logger.Error("DEBUG RELOAD load failed: " + err.Error())
+		return reply.MakeStandardErrorReply("ERR Error trying to load the RDB dump: " + err.Error())
+	}
+	return reply.MakeOKReply()
+}
+
+// execDebugFailpoint enables or disables a failpoint, or lists the enabled ones without argument
+// DEBUG FAILPOINT aof-write delay(100ms)
+// DEBUG FAILPOINT cluster-relay 2*error(connection refused)
+// DEBUG FAILPOINT aof-write OFF
+func execDebugFailpoint(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		names := failpoint.List()
+		lines := make([][]byte, len(names))
+		for i, name := range names {
+			lines[i] = []byte(name)
+		}
+		return reply.MakeMultiBulkReply(lines)
+	}
+	if len(args) != 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	name := strings.ToLower(string(args[0]))
+	if !failpoint.Exists(name) {
+		return reply.MakeStandardErrorReply("ERR unknown failpoint '" + name + "'")
+	}
+	if strings.ToUpper(string(args[1])) == "OFF" {
+		failpoint.Disable(name)
+		return reply.MakeOKReply()
+	}
+	if err := failpoint.Enable(name, string(args[1])); err != nil {
+		return reply.MakeStandardErrorReply("ERR " + err.Error())
+	}
+	logger.Info("failpoint " + name + " enabled: " + string(args[1]))
+	return reply.MakeOKReply()
+}
+
+// execDebugGoStats reports the state of the Go runtime in the format of INFO: goroutines, heap
+// and garbage collector
+func execDebugGoStats() resp.Reply {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	// the latest pause is at (NumGC+255)%256 of the circular buffer
+	var lastPause uint64
+	if stats.NumGC > 0 {
+		lastPause = stats.PauseNs[(stats.NumGC+255)%256]
+	}
+	var sb strings.Builder
+	sb.WriteString("go_version:" + runtime.Version() + "\r\n")
+	sb.WriteString("goroutines:" + strconv.Itoa(runtime.NumGoroutine()) + "\r\n")
+	sb.WriteString("gomaxprocs:" + strconv.Itoa(runtime.GOMAXPROCS(0)) + "\r\n")
+	sb.WriteString("heap_alloc:" + strconv.FormatUint(stats.HeapAlloc, 10) + "\r\n")
+	sb.WriteString("heap_inuse:" + strconv.FormatUint(stats.HeapInuse, 10) + "\r\n")
+	sb.WriteString("heap_idle:" + strconv.FormatUint(stats.HeapIdle, 10) + "\r\n")
+	sb.WriteString("heap_released:" + strconv.FormatUint(stats.HeapReleased, 10) + "\r\n")
+	sb.WriteString("heap_objects:" + strconv.FormatUint(stats.HeapObjects, 10) + "\r\n")
+	sb.WriteString("sys:" + strconv.FormatUint(stats.Sys, 10) + "\r\n")
+	sb.WriteString("next_gc:" + strconv.FormatUint(stats.NextGC, 10) + "\r\n")
+	sb.WriteString("gc_cycles:" + strconv.FormatUint(uint64(stats.NumGC), 10) + "\r\n")
+	sb.WriteString("gc_forced_cycles:" + strconv.FormatUint(uint64(stats.NumForcedGC), 10) + "\r\n")
+	sb.WriteString("gc_pause_total_ns:" + strconv.FormatUint(stats.PauseTotalNs, 10) + "\r\n")
+	sb.WriteString("gc_last_pause_ns:" + strconv.FormatUint(lastPause, 10) + "\r\n")
+	sb.WriteString("gc_cpu_fraction:" + strconv.FormatFloat(stats.GCCPUFraction, 'f', 6, 64) + "\r\n")
+	return reply.MakeBulkReply([]byte(sb.String()))
+}
@@ -0,0 +1,345 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"redigo/config"
+	"redigo/interface/database"
+	"redigo/interface/resp"
+	"redigo/lib/utils"
+	"redigo/rdb"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dumpEntity serializes a value in the format of DUMP, which is the encoding of the value in a
+// snapshot followed by the RDB version and a checksum
+func dumpEntity(key string, entity *database.DataEntity) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := rdb.NewDumpEncoder(buf)
+	if err := writeEntity(enc, key, entity); err != nil {
+		return nil, err
+	}
+	if err := enc.WriteDumpEnd(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// execDump implements DUMP key, it replies with the serialized value or nil if the key does not
+// exist; the payload is read back by RESTORE, on this node or another one
+func execDump(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		entity, ok := db.GetEntity(key)
+		if !ok {
+			result = reply.MakeNullBulkReply()
+			return
+		}
+		payload, err := dumpEntity(key, entity)
+		if err != nil {
+			result = reply.MakeStandardErrorReply("ERR " + err.Error())
+			return
+		}
+		result = reply.MakeBulkReply(payload)
+	})
+	return result
+}
+
+// execRestore implements RESTORE key ttl serialized-value [REPLACE] [ABSTTL]
+// ttl is in milliseconds, 0 for no expiration time, or the unix time in milliseconds with ABSTTL
+// The AOF receives RESTORE with ABSTTL, so that replaying the file does not extend the TTL
+func execRestore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return reply.MakeStandardErrorReply("ERR Invalid TTL value, must be >= 0")
+	}
+	replace, absTTL := false, false
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(string(arg)) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	var expireAt time.Time
+	if ttl > 0 {
+		if absTTL {
+			expireAt = time.UnixMilli(ttl)
+		} else {
+			expireAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+	}
+
+	obj, err := rdb.DecodeDump(args[2])
+	if errors.Is(err, rdb.ErrBadDump) {
+		return reply.MakeStandardErrorReply("ERR DUMP payload version or checksum are wrong")
+	}
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR Bad data format")
+	}
+	obj.Key = key
+	entity, err := readEntity(obj)
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR Bad data format")
+	}
+
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		if _, exists := db.GetEntity(key); exists && !replace {
+			result = reply.MakeStandardErrorReply("BUSYKEY Target key name already exists.")
+			return
+		}
+		result = reply.MakeOKReply()
+		if !expireAt.IsZero() && !expireAt.After(time.Now()) {
+			// restored already expired, like Redis only the replaced key is removed
+			if db.Remove(key) > 0 {
+				db.addAof(utils.ToCmdLine("DEL", key))
+			}
+			return
+		}
+		db.PutEntity(key, entity)
+		absolute := "0"
+		if expireAt.IsZero() {
+			db.Persist(key)
+		} else {
+			db.Expire(key, expireAt)
+			absolute = strconv.FormatInt(expireAt.UnixMilli(), 10)
+		}
+		db.addAof(utils.ToCmdLine("RESTORE", key, absolute, string(args[2]), "REPLACE", "ABSTTL"))
+	})
+	return result
+}
+
+// migrateOptions are the arguments of MIGRATE
+type migrateOptions struct {
+	addr    string
+	dbIndex int
+	timeout time.Duration
+	copy    bool
+	replace bool
+	auth    []string // the AUTH command to send, nil without authentication
+	keys    []string
+}
+
+// parseMigrate reads MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE]
+// [AUTH password | AUTH2 username password] [KEYS key [key ...]]
+func parseMigrate(args [][]byte) (*migrateOptions, reply.ErrorReply) {
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, reply.MakeStandardErrorReply("ERR Invalid port")
+	}
+	dbIndex, err1 := strconv.Atoi(string(args[3]))
+	timeout, err2 := strconv.ParseInt(string(args[4]), 10, 64)
+	if err1 != nil || err2 != nil || dbIndex < 0 {
+		return nil, reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	if timeout <= 0 {
+		// Redis waits a second when the timeout is not positive
+		timeout = 1000
+	}
+	opts := &migrateOptions{
+		addr:    net.JoinHostPort(string(args[0]), strconv.Itoa(port)),
+		dbIndex: dbIndex,
+		timeout: time.Duration(timeout) * time.Millisecond,
+	}
+	withKeys := false
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "COPY":
+			opts.copy = true
+		case "REPLACE":
+			opts.replace = true
+		case "AUTH":
+			if i+1 >= len(args) {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			opts.auth = []string{"AUTH", string(args[i+1])}
+			i++
+		case "AUTH2":
+			if i+2 >= len(args) {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			opts.auth = []string{"AUTH", string(args[i+1]), string(args[i+2])}
+			i += 2
+		case "KEYS":
+			if len(args[2]) != 0 {
+				return nil, reply.MakeStandardErrorReply("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			withKeys = true
+			for _, key := range args[i+1:] {
+				opts.keys = append(opts.keys, string(key))
+			}
+			i = len(args)
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+	}
+	// the key argument is empty with KEYS, an empty KEYS list migrates no key
+	if !withKeys {
+		opts.keys = []string{string(args[2])}
+	}
+	return opts, nil
+}
+
+// migrateKeys returns the keys of MIGRATE, the key argument or those after KEYS
+func migrateKeys(args [][]byte) [][]byte {
+	for i := 6; i < len(args); i++ {
+		if strings.ToUpper(string(args[i])) == "KEYS" {
+			return args[i+1:]
+		}
+	}
+	if len(args) > 3 {
+		return args[3:4]
+	}
+	return nil
+}
+
+// migratedKey is a key dumped to be restored on the target of MIGRATE
+type migratedKey struct {
+	key     string
+	ttl     int64 // milliseconds, 0 without expiration time
+	payload []byte
+}
+
+// execMigrate implements MIGRATE, which moves keys to another instance: the keys are dumped,
+// restored on the target with RESTORE and removed once the target accepted them, unless COPY
+// The keys stay locked until then, so that the clients of this instance never miss them and no
+// write between the dump and the removal is lost
+func execMigrate(db *DB, args [][]byte) resp.Reply {
+	opts, errReply := parseMigrate(args)
+	if errReply != nil {
+		return errReply
+	}
+	if len(opts.keys) == 0 {
+		return reply.MakeStatusReply("NOKEY")
+	}
+	var result resp.Reply
+	db.WithKeysLock(opts.keys, func() {
+		var dumped []migratedKey
+		for _, key := range opts.keys {
+			entity, ok := db.GetEntity(key)
+			if !ok {
+				continue
+			}
+			payload, err := dumpEntity(key, entity)
+			if err != nil {
+				result = reply.MakeStandardErrorReply("ERR " + err.Error())
+				return
+			}
+			var ttl int64
+			if expireAt, ok := db.ExpireTime(key); ok {
+				ttl = max(time.Until(expireAt).Milliseconds(), 1)
+			}
+			dumped = append(dumped, migratedKey{key: key, ttl: ttl, payload: payload})
+		}
+		if len(dumped) == 0 {
+			result = reply.MakeStatusReply("NOKEY")
+			return
+		}
+		restored, errReply := restoreOnTarget(opts, dumped)
+		if !opts.copy {
+			var removed [][]byte
+			for _, key := range restored {
+				if db.Remove(key) > 0 {
+					removed = append(removed, []byte(key))
+				}
+			}
+			if len(removed) > 0 {
+				db.addAof(utils.ToCmdLineWithName("DEL", removed...))
+			}
+		}
+		if errReply != nil {
+			result = errReply
+		} else {
+			result = reply.MakeOKReply()
+		}
+	})
+	return result
+}
+
+// restoreOnTarget sends the keys to the target of MIGRATE with RESTORE, after AUTH and SELECT,
+// and returns the keys the target accepted
+func restoreOnTarget(opts *migrateOptions, dumped []migratedKey) ([]string, reply.ErrorReply) {
+	conn, err := net.DialTimeout("tcp", opts.addr, opts.timeout)
+	if err != nil {
+		return nil, reply.MakeStandardErrorReply("IOERR error or timeout connecting to the client")
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	var setup [][]string
+	if opts.auth != nil {
+		setup = append(setup, opts.auth)
+	}
+	setup = append(setup, []string{"SELECT", strconv.Itoa(opts.dbIndex)})
+
+	// the commands are pipelined, the replies are read in the same order
+	buf := &bytes.Buffer{}
+	for _, cmd := range setup {
+		buf.Write(reply.MakeMultiBulkReply(utils.ToCmdLine(cmd...)).ToBytes())
+	}
+	restore := "RESTORE"
+	if config.Properties.ClusterRedirect {
+		// the target may be importing the slot of the keys, which it accepts with ASKING only
+		restore = "RESTORE-ASKING"
+	}
+	for _, k := range dumped {
+		cmd := utils.ToCmdLine(restore, k.key, strconv.FormatInt(k.ttl, 10))
+		cmd = append(cmd, k.payload)
+		if opts.replace {
+			cmd = append(cmd, []byte("REPLACE"))
+		}
+		buf.Write(reply.MakeMultiBulkReply(cmd).ToBytes())
+	}
+	_ = conn.SetDeadline(time.Now().Add(opts.timeout))
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return nil, reply.MakeStandardErrorReply("IOERR error or timeout writing to target instance")
+	}
+
+	reader := bufio.NewReader(conn)
+	for range setup {
+		line, err := readReplyLine(reader)
+		if err != nil {
+			return nil, reply.MakeStandardErrorReply("IOERR error or timeout reading to target instance")
+		}
+		if strings.HasPrefix(line, "-") {
+			return nil, reply.MakeStandardErrorReply("ERR Target instance replied with error: " + line[1:])
+		}
+	}
+	var restored []string
+	var errReply reply.ErrorReply
+	for _, k := range dumped {
+		line, err := readReplyLine(reader)
+		if err != nil {
+			return restored, reply.MakeStandardErrorReply("IOERR error or timeout reading to target instance")
+		}
+		if strings.HasPrefix(line, "-") {
+			// the other keys are still moved, the first error is replied like Redis
+			if errReply == nil {
+				errReply = reply.MakeStandardErrorReply("ERR Target instance replied with error: " + line[1:])
+			}
+			continue
+		}
+		restored = append(restored, k.key)
+	}
+	return restored, errReply
+}
+
+func init() {
+	RegisterCommand("DUMP", execDump, 2)
+	RegisterCommand("RESTORE", execRestore, -4)
+	RegisterCommand("MIGRATE", execMigrate, -6)
+}
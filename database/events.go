@@ -0,0 +1,80 @@
+package database
+
+// eventType is the kind of change of the keyspace published on the event bus of a DB
+type eventType int
+
+const (
+	// eventWritten is a write command which modified its keys, published when the command is
+	// propagated, so a command which failed or changed nothing publishes nothing
+	eventWritten eventType = iota
+	// eventDeleted is a key removed by a command
+	eventDeleted
+	// eventExpired is a key removed because its expiration time passed
+	eventExpired
+	// eventEvicted is a key removed to free memory over maxmemory
+	eventEvicted
+	// eventRenamed is a key removed by RENAME: the source, whose value moves to the destination,
+	// and the destination, whose value is replaced
+	eventRenamed
+	numEventTypes
+)
+
+// keyEvent is a change of the keyspace of a DB
+type keyEvent struct {
+	typ eventType
+	db  *DB
+	// key is the key removed by the events other than eventWritten
+	key string
+	// emptied is set on the eventDeleted of a collection removed by the command which emptied it
+	emptied bool
+	// cmdLine is the command of eventWritten as it is propagated, PEXPIREAT instead of EXPIRE
+	cmdLine CmdLine
+}
+
+// keys returns the keys changed by the event
+func (e keyEvent) keys() []string {
+	if e.typ != eventWritten {
+		return []string{e.key}
+	}
+	keys, _ := CommandKeys(e.cmdLine)
+	return keys
+}
+
+// eventBus delivers the changes of the keyspace of a DB to the subsystems observing them:
+// the expiration table, the wakeup of the blocked clients, and the propagation to the AOF, the
+// replicas and the change feed, instead of the commands calling each of them
+// The subscribers are called in the order they subscribed, by the goroutine which made the change
+// while it holds the locks of the keys, so that they see the changes in order; they must neither
+// block nor lock keys
+type eventBus struct {
+	subscribers [numEventTypes][]func(event keyEvent)
+}
+
+// subscribe calls fn for the events of the type, it must be called before the DB serves commands
+func (b *eventBus) subscribe(typ eventType, fn func(event keyEvent)) {
+	b.subscribers[typ] = append(b.subscribers[typ], fn)
+}
+
+// publish delivers the event to the subscribers of its type
+func (b *eventBus) publish(event keyEvent) {
+	for _, fn := range b.subscribers[event.typ] {
+		fn(event)
+	}
+}
+
+// makeEventBus returns the event bus of a new DB with the subscribers of the DB itself
+func makeEventBus() *eventBus {
+	b := &eventBus{}
+	// the expiration time goes with the key
+	forgetExpiration := func(event keyEvent) {
+		event.db.expires.remove(event.key)
+	}
+	b.subscribe(eventDeleted, forgetExpiration)
+	b.subscribe(eventExpired, forgetExpiration)
+	b.subscribe(eventEvicted, forgetExpiration)
+	b.subscribe(eventRenamed, forgetExpiration)
+	b.subscribe(eventWritten, func(event keyEvent) {
+		event.db.signalWrite(event.cmdLine)
+	})
+	return b
+}
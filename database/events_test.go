@@ -0,0 +1,43 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEventBus tests the events published by the commands, the removals and the expirations
+func TestEventBus(t *testing.T) {
+	db := MakeDB()
+	var events []string
+	record := func(event keyEvent) {
+		switch event.typ {
+		case eventWritten:
+			events = append(events, "written "+strings.Join(event.keys(), ","))
+		case eventDeleted:
+			events = append(events, "deleted "+event.key)
+		case eventExpired:
+			events = append(events, "expired "+event.key)
+		}
+	}
+	db.events.subscribe(eventWritten, record)
+	db.events.subscribe(eventDeleted, record)
+	db.events.subscribe(eventExpired, record)
+
+	exec(db, "MSET", "a", "1", "b", "2")
+	exec(db, "GET", "a")
+	exec(db, "DEL", "a", "missing")
+	exec(db, "LPOP", "b") // a failed command publishes nothing
+	exec(db, "PEXPIRE", "b", "1")
+	time.Sleep(5 * time.Millisecond)
+	exec(db, "GET", "b")
+
+	expected := []string{"written a,b", "deleted a", "written a,missing", "written b", "expired b"}
+	if strings.Join(events, "; ") != strings.Join(expected, "; ") {
+		t.Errorf("expected the events %q, got %q", expected, events)
+	}
+	// the expiration table forgets the removed keys
+	if _, ok := db.ExpireTime("b"); ok {
+		t.Error("expected the expiration time of b to be removed")
+	}
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"math/rand"
+	"redigo/config"
+	"redigo/interface/database"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strings"
+	"time"
+)
+
+// When maxmemory is set, the write commands which may add data first evict keys until the
+// estimated memory of the dataset is under the limit, the keys are chosen by maxmemory-policy
+// The memory is the sum of the sizes estimated for the values, see estimateSize, rather than
+// the heap of the process which only shrinks after a garbage collection
+const (
+	noEviction    = "noeviction"
+	allKeysLRU    = "allkeys-lru"
+	allKeysRandom = "allkeys-random"
+	volatileLRU   = "volatile-lru"
+	volatileTTL   = "volatile-ttl"
+)
+
+// evictionPolicies are the supported values of maxmemory-policy
+var evictionPolicies = map[string]bool{
+	noEviction:    true,
+	allKeysLRU:    true,
+	allKeysRandom: true,
+	volatileLRU:   true,
+	volatileTTL:   true,
+}
+
+const (
+	// defaultEvictionSamples is the number of keys of each DB sampled to find the key to evict
+	// when maxmemory-samples is not set
+	defaultEvictionSamples = 5
+	// sizeSamples is the number of elements of a collection sampled to estimate its size
+	sizeSamples = 5
+	// maxEvictionMisses is the number of victims removed by other clients before the eviction
+	// gives up
+	maxEvictionMisses = 8
+)
+
+// oomErrReply is the reply of the write commands when the memory cannot be freed
+var oomErrReply = reply.MakeStandardErrorReply("OOM command not allowed when used memory > 'maxmemory'.")
+
+// oomAllowedCommands are the write commands accepted over maxmemory since they do not add data
+var oomAllowedCommands = map[string]bool{
+	"del":              true,
+	"flushdb":          true,
+	"expire":           true,
+	"expireat":         true,
+	"pexpire":          true,
+	"pexpireat":        true,
+	"persist":          true,
+	"lpop":             true,
+	"rpop":             true,
+	"blpop":            true,
+	"brpop":            true,
+	"lrem":             true,
+	"ltrim":            true,
+	"spop":             true,
+	"srem":             true,
+	"hdel":             true,
+	"zrem":             true,
+	"zremrangebyrank":  true,
+	"zremrangebyscore": true,
+	"zpopmin":          true,
+	"zpopmax":          true,
+	"xtrim":            true,
+	"migrate":          true,
+}
+
+// maxMemoryPolicy returns the eviction policy of the configuration
+func maxMemoryPolicy() string {
+	if policy := strings.ToLower(config.Properties.MaxMemoryPolicy); policy != "" {
+		return policy
+	}
+	return noEviction
+}
+
+// maxMemorySamples returns the number of keys of each DB sampled by an eviction
+func maxMemorySamples() int {
+	if samples := config.Properties.MaxMemorySamples; samples > 0 {
+		return samples
+	}
+	return defaultEvictionSamples
+}
+
+// usedMemory returns the estimated memory of the values of all DBs
+func (d *StandaloneDatabase) usedMemory() int64 {
+	var used int64
+	d.forEachDB(func(db *DB) {
+		used += db.used.Load()
+	})
+	return used
+}
+
+// checkMemory evicts keys before a write command while the used memory is over maxmemory, it
+// rejects the command if no key can be evicted
+func (d *StandaloneDatabase) checkMemory(cmdName string) reply.ErrorReply {
+	maxMemory := int64(config.Properties.MaxMemory)
+	if maxMemory <= 0 || !IsWriteCommand(cmdName) || oomAllowedCommands[cmdName] {
+		return nil
+	}
+	if d.usedMemory() <= maxMemory {
+		return nil
+	}
+	policy := maxMemoryPolicy()
+	d.evictions.cycle(policy)
+	misses := 0
+	for d.usedMemory() > maxMemory {
+		if policy == noEviction {
+			d.evictions.reject(policy)
+			return oomErrReply
+		}
+		candidate, ok := d.evictions.next(d, policy)
+		if !ok {
+			d.evictions.reject(policy)
+			return oomErrReply
+		}
+		if !d.evict(policy, candidate) {
+			d.evictions.miss(policy)
+			if misses++; misses == maxEvictionMisses {
+				d.evictions.reject(policy)
+				return oomErrReply
+			}
+		}
+	}
+	return nil
+}
+
+// evictionCandidates returns the keys of the DB which may be evicted by the policy
+func (db *DB) evictionCandidates(policy string, samples int) []string {
+	switch policy {
+	case allKeysLRU:
+		return db.data.RandomKeys(samples)
+	case allKeysRandom:
+		return db.data.RandomKeys(1)
+	case volatileLRU:
+		return db.expires.randomKeys(samples)
+	case volatileTTL:
+		// the expire table is ordered by time, the key which expires first is known
+		if key, _, ok := db.expires.earliest(); ok {
+			return []string{key}
+		}
+	}
+	return nil
+}
+
+// evictionScore rates a candidate, the key with the highest score is evicted
+// The LRU policies prefer the least recently used keys and volatile-ttl the earliest expiration
+func (db *DB) evictionScore(policy string, key string) (int64, bool) {
+	raw, ok := db.data.Get(key)
+	if !ok {
+		return 0, false
+	}
+	switch policy {
+	case allKeysLRU, volatileLRU:
+		return int64(raw.(*database.DataEntity).IdleTime()), true
+	case volatileTTL:
+		at, ok := db.expires.get(key)
+		return -at.UnixMilli(), ok
+	}
+	return rand.Int63(), true
+}
+
+// evict removes the key of the candidate and propagates its deletion, it returns false if the
+// key was removed in the meantime
+func (d *StandaloneDatabase) evict(policy string, c evictionCandidate) bool {
+	var idle time.Duration
+	evicted := false
+	c.db.WithKeyLock(c.key, func() {
+		raw, ok := c.db.data.Get(c.key)
+		if !ok {
+			return
+		}
+		idle = raw.(*database.DataEntity).IdleTime()
+		c.db.snapshots.save(c.db, c.key)
+		c.db.removeKey(c.key, eventEvicted)
+		c.db.addAof(utils.ToCmdLine("DEL", c.key))
+		evicted = true
+	})
+	if evicted {
+		d.evictedKeys.Add(1)
+		d.evictions.evicted(policy, idle)
+	}
+	return evicted
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// evictionPoolSize is the number of best candidates kept between the evictions, like the
+// eviction pool of Redis: a key sampled by an eviction may still be chosen by the next ones, so
+// that few samples approximate LRU well
+const evictionPoolSize = 16
+
+// evictionCandidate is a key which may be evicted, the key with the highest score is evicted
+type evictionCandidate struct {
+	db    *DB
+	key   string
+	score int64
+}
+
+// policyStats are the counters of an eviction policy, reported by INFO stats to tune
+// maxmemory-samples
+type policyStats struct {
+	cycles   int64         // write commands which found the memory over maxmemory
+	sampled  int64         // keys sampled
+	evicted  int64         // keys evicted
+	misses   int64         // candidates whose key was removed before their eviction
+	rejected int64         // write commands rejected with -OOM
+	idle     time.Duration // total idle time of the evicted keys
+}
+
+// evictionPool holds the best candidates found by the samplings of a policy
+type evictionPool struct {
+	mu     sync.Mutex
+	policy string
+	// candidates are sorted by score, the best last
+	candidates []evictionCandidate
+	stats      map[string]*policyStats
+}
+
+func makeEvictionPool() *evictionPool {
+	return &evictionPool{stats: make(map[string]*policyStats)}
+}
+
+// statsOf returns the counters of the policy, the pool must be locked
+func (p *evictionPool) statsOf(policy string) *policyStats {
+	s, ok := p.stats[policy]
+	if !ok {
+		s = &policyStats{}
+		p.stats[policy] = s
+	}
+	return s
+}
+
+func (p *evictionPool) cycle(policy string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statsOf(policy).cycles++
+}
+
+func (p *evictionPool) reject(policy string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statsOf(policy).rejected++
+}
+
+func (p *evictionPool) miss(policy string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statsOf(policy).misses++
+}
+
+func (p *evictionPool) evicted(policy string, idle time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.statsOf(policy)
+	s.evicted++
+	s.idle += idle
+}
+
+// next samples the keys of each DB into the pool and removes the best candidate from it
+func (p *evictionPool) next(d *StandaloneDatabase, policy string) (evictionCandidate, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.policy != policy {
+		// the scores of another policy are not comparable
+		p.policy, p.candidates = policy, nil
+	}
+	samples := maxMemorySamples()
+	stats := p.statsOf(policy)
+	d.forEachDB(func(db *DB) {
+		for _, key := range db.evictionCandidates(policy, samples) {
+			stats.sampled++
+			if score, ok := db.evictionScore(policy, key); ok {
+				p.insert(evictionCandidate{db: db, key: key, score: score})
+			}
+		}
+	})
+	for len(p.candidates) > 0 {
+		best := p.candidates[len(p.candidates)-1]
+		p.candidates = p.candidates[:len(p.candidates)-1]
+		if policy == allKeysRandom {
+			// the random scores are drawn again by the next eviction
+			p.candidates = nil
+		}
+		// the key of a candidate kept from an earlier sampling may have been removed since
+		if _, ok := best.db.data.Get(best.key); ok {
+			return best, true
+		}
+		stats.misses++
+	}
+	return evictionCandidate{}, false
+}
+
+// insert adds the candidate to the pool, replacing its previous score, and drops the worst
+// candidate when the pool is full
+func (p *evictionPool) insert(c evictionCandidate) {
+	for i, old := range p.candidates {
+		if old.db == c.db && old.key == c.key {
+			p.candidates = append(p.candidates[:i], p.candidates[i+1:]...)
+			break
+		}
+	}
+	if len(p.candidates) == evictionPoolSize {
+		if c.score <= p.candidates[0].score {
+			return
+		}
+		p.candidates = p.candidates[1:]
+	}
+	i := sort.Search(len(p.candidates), func(i int) bool {
+		return p.candidates[i].score > c.score
+	})
+	p.candidates = append(p.candidates, evictionCandidate{})
+	copy(p.candidates[i+1:], p.candidates[i:])
+	p.candidates[i] = c
+}
+
+// writeStats writes the counters of each policy used since the start to INFO stats
+// eviction_<policy>:cycles=<n>,sampled=<n>,evicted=<n>,misses=<n>,rejected=<n>,avg_victim_idle_sec=<n>
+func (p *evictionPool) writeStats(sb *strings.Builder) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	policies := make([]string, 0, len(p.stats))
+	for policy := range p.stats {
+		policies = append(policies, policy)
+	}
+	sort.Strings(policies)
+	for _, policy := range policies {
+		s := p.stats[policy]
+		var avgIdle int64
+		if s.evicted > 0 {
+			avgIdle = int64(s.idle.Seconds()) / s.evicted
+		}
+		sb.WriteString("eviction_" + strings.ReplaceAll(policy, "-", "_") +
+			":cycles=" + strconv.FormatInt(s.cycles, 10) +
+			",sampled=" + strconv.FormatInt(s.sampled, 10) +
+			",evicted=" + strconv.FormatInt(s.evicted, 10) +
+			",misses=" + strconv.FormatInt(s.misses, 10) +
+			",rejected=" + strconv.FormatInt(s.rejected, 10) +
+			",avg_victim_idle_sec=" + strconv.FormatInt(avgIdle, 10) + "\r\n")
+	}
+}
+
+// describe writes the candidates of the pool for DEBUG EVICTION-POOL, the best first
+// The LRU policies report the idle time of the keys and volatile-ttl their time to live
+func (p *evictionPool) describe(sb *strings.Builder) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	policy := p.policy
+	if policy == "" {
+		policy = maxMemoryPolicy()
+	}
+	sb.WriteString("policy:" + policy + "\r\n")
+	sb.WriteString("samples:" + strconv.Itoa(maxMemorySamples()) + "\r\n")
+	sb.WriteString("pool_size:" + strconv.Itoa(evictionPoolSize) + "\r\n")
+	sb.WriteString("candidates:" + strconv.Itoa(len(p.candidates)) + "\r\n")
+	now := time.Now().UnixMilli()
+	for i := len(p.candidates) - 1; i >= 0; i-- {
+		c := p.candidates[i]
+		line := "candidate_" + strconv.Itoa(len(p.candidates)-1-i) + ":db=" + strconv.Itoa(c.db.index) + ",key=" + c.key
+		switch policy {
+		case allKeysLRU, volatileLRU:
+			line += ",idle_sec=" + strconv.FormatInt(int64(time.Duration(c.score).Seconds()), 10)
+		case volatileTTL:
+			line += ",ttl_ms=" + strconv.FormatInt(-c.score-now, 10)
+		}
+		sb.WriteString(line + "\r\n")
+	}
+}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"redigo/config"
+	"redigo/interface/resp"
+	"redigo/lib/utils"
+	"redigo/resp/connection"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// withMaxMemory sets maxmemory and maxmemory-policy for the test
+func withMaxMemory(t *testing.T, maxMemory int, policy string) {
+	old, oldPolicy := config.Properties.MaxMemory, config.Properties.MaxMemoryPolicy
+	t.Cleanup(func() {
+		config.Properties.MaxMemory, config.Properties.MaxMemoryPolicy = old, oldPolicy
+	})
+	config.Properties.MaxMemory, config.Properties.MaxMemoryPolicy = maxMemory, policy
+}
+
+func isError(r resp.Reply) bool {
+	_, ok := r.(reply.ErrorReply)
+	return ok
+}
+
+// TestUsedMemory tests that the estimated memory follows the values, including those modified
+// in place
+func TestUsedMemory(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+
+	d.Exec(client, utils.ToCmdLine("SET", "key", "value"))
+	afterSet := d.usedMemory()
+	if afterSet <= 0 {
+		t.Fatalf("expected the string to be accounted, got %d", afterSet)
+	}
+	d.Exec(client, utils.ToCmdLine("SET", "key", strings.Repeat("x", 1000)))
+	if used := d.usedMemory(); used < afterSet+990 {
+		t.Errorf("expected the replaced value to be accounted, got %d", used)
+	}
+	beforePush := d.usedMemory()
+	for i := 0; i < 100; i++ {
+		d.Exec(client, utils.ToCmdLine("RPUSH", "list", strings.Repeat("y", 100)))
+	}
+	if used := d.usedMemory(); used < beforePush+100*100 {
+		t.Errorf("expected the pushed elements to be accounted, got %d", used-beforePush)
+	}
+	d.Exec(client, utils.ToCmdLine("DEL", "key", "list"))
+	if used := d.usedMemory(); used != 0 {
+		t.Errorf("expected no memory after DEL, got %d", used)
+	}
+	d.Exec(client, utils.ToCmdLine("SET", "key", "value"))
+	d.Exec(client, utils.ToCmdLine("FLUSHDB"))
+	if used := d.usedMemory(); used != 0 {
+		t.Errorf("expected no memory after FLUSHDB, got %d", used)
+	}
+}
+
+// TestConcurrentResize tests the accounting of the values modified in place by concurrent
+// writers of the same keys, it is meant to run with -race
+func TestConcurrentResize(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := &connection.Connection{}
+			for j := 0; j < 200; j++ {
+				member := strconv.Itoa(i*1000 + j)
+				d.Exec(client, utils.ToCmdLine("SADD", "set", member))
+				d.Exec(client, utils.ToCmdLine("HSET", "hash", member, member))
+				d.Exec(client, utils.ToCmdLine("RPUSH", "list", member))
+			}
+		}()
+	}
+	wg.Wait()
+
+	client := &connection.Connection{}
+	assertReply(t, d.Exec(client, utils.ToCmdLine("SCARD", "set")), ":1600\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("HLEN", "hash")), ":1600\r\n")
+	d.Exec(client, utils.ToCmdLine("DEL", "set", "hash", "list"))
+	if used := d.usedMemory(); used != 0 {
+		t.Errorf("expected no memory after DEL, got %d", used)
+	}
+}
+
+// TestNoEviction tests that the write commands adding data are rejected over maxmemory and the
+// others are served
+func TestNoEviction(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	withMaxMemory(t, 2000, "noeviction")
+
+	var r resp.Reply
+	i := 0
+	for ; i < 100; i++ {
+		r = d.Exec(client, utils.ToCmdLine("SET", "key"+strconv.Itoa(i), strings.Repeat("x", 100)))
+		if isError(r) {
+			break
+		}
+	}
+	if !strings.HasPrefix(string(r.ToBytes()), "-OOM") {
+		t.Fatalf("expected -OOM over maxmemory, got %q", r.ToBytes())
+	}
+	if r := d.Exec(client, utils.ToCmdLine("GET", "key0")); isError(r) {
+		t.Errorf("expected GET to be served over maxmemory, got %q", r.ToBytes())
+	}
+	if r := d.Exec(client, utils.ToCmdLine("DEL", "key0", "key1")); string(r.ToBytes()) != ":2\r\n" {
+		t.Errorf("expected DEL to be served over maxmemory, got %q", r.ToBytes())
+	}
+	if r := d.Exec(client, utils.ToCmdLine("SET", "key0", "x")); isError(r) {
+		t.Errorf("expected SET to be accepted under maxmemory, got %q", r.ToBytes())
+	}
+	if d.evictedKeys.Load() != 0 {
+		t.Errorf("expected no eviction with noeviction, got %d", d.evictedKeys.Load())
+	}
+}
+
+// TestAllKeysLRU tests that the least recently used keys are evicted to stay under maxmemory
+func TestAllKeysLRU(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	withMaxMemory(t, 5000, "allkeys-lru")
+
+	for i := 0; i < 200; i++ {
+		r := d.Exec(client, utils.ToCmdLine("SET", "key"+strconv.Itoa(i), strings.Repeat("x", 100)))
+		if isError(r) {
+			t.Fatalf("expected SET to evict keys, got %q", r.ToBytes())
+		}
+		// one value may be written over the limit before the next write evicts
+		if used := d.usedMemory(); used > 5000+200 {
+			t.Fatalf("expected the memory to stay under maxmemory, got %d", used)
+		}
+	}
+	if d.evictedKeys.Load() == 0 {
+		t.Error("expected keys to be evicted")
+	}
+	if !strings.Contains(string(execInfo(d, utils.ToCmdLine("stats")).ToBytes()), "evicted_keys:") {
+		t.Error("expected evicted_keys in INFO stats")
+	}
+}
+
+// TestVolatileTTL tests that the keys expiring first are evicted and the persistent keys are kept
+func TestVolatileTTL(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+
+	d.Exec(client, utils.ToCmdLine("SET", "persistent", strings.Repeat("x", 500)))
+	for i := 0; i < 10; i++ {
+		d.Exec(client, utils.ToCmdLine("SET", "volatile"+strconv.Itoa(i), strings.Repeat("x", 100),
+			"EX", strconv.Itoa(1000+i)))
+	}
+	withMaxMemory(t, int(d.usedMemory())-1, "volatile-ttl")
+	d.Exec(client, utils.ToCmdLine("SET", "new", "value"))
+
+	if r := d.Exec(client, utils.ToCmdLine("EXISTS", "volatile0")); string(r.ToBytes()) != ":0\r\n" {
+		t.Error("expected the key expiring first to be evicted")
+	}
+	if r := d.Exec(client, utils.ToCmdLine("EXISTS", "persistent", "volatile9")); string(r.ToBytes()) != ":2\r\n" {
+		t.Error("expected the persistent key and the key expiring last to be kept")
+	}
+
+	// only the persistent key and the new key are left once the volatile keys are evicted
+	config.Properties.MaxMemory = 1
+	if r := d.Exec(client, utils.ToCmdLine("SET", "other", "value")); !strings.HasPrefix(string(r.ToBytes()), "-OOM") {
+		t.Errorf("expected -OOM without volatile key to evict, got %q", r.ToBytes())
+	}
+	if r := d.Exec(client, utils.ToCmdLine("EXISTS", "persistent")); string(r.ToBytes()) != ":1\r\n" {
+		t.Error("expected the persistent key to be kept")
+	}
+}
+
+// TestEvictionPoolInsert tests that the pool keeps the best candidates sorted by score
+func TestEvictionPoolInsert(t *testing.T) {
+	p := makeEvictionPool()
+	db := MakeDB()
+	for i := 0; i < 40; i++ {
+		p.insert(evictionCandidate{db: db, key: "key" + strconv.Itoa(i), score: int64(i % 20)})
+	}
+	if len(p.candidates) != evictionPoolSize {
+		t.Fatalf("expected %d candidates, got %d", evictionPoolSize, len(p.candidates))
+	}
+	for i := 1; i < len(p.candidates); i++ {
+		if p.candidates[i-1].score > p.candidates[i].score {
+			t.Fatalf("expected the candidates to be sorted by score, got %+v", p.candidates)
+		}
+	}
+	if best := p.candidates[len(p.candidates)-1]; best.score != 19 {
+		t.Errorf("expected the best score to be 19, got %d", best.score)
+	}
+	p.insert(evictionCandidate{db: db, key: "key39", score: 100})
+	for _, c := range p.candidates[:len(p.candidates)-1] {
+		if c.key == "key39" {
+			t.Error("expected the score of a candidate to be replaced")
+		}
+	}
+}
+
+// TestEvictionStats tests the counters of INFO stats and the view of DEBUG EVICTION-POOL
+func TestEvictionStats(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	withMaxMemory(t, 5000, "allkeys-lru")
+	samples := config.Properties.MaxMemorySamples
+	defer func() { config.Properties.MaxMemorySamples = samples }()
+	config.Properties.MaxMemorySamples = 3
+
+	for i := 0; i < 100; i++ {
+		d.Exec(client, utils.ToCmdLine("SET", "key"+strconv.Itoa(i), strings.Repeat("x", 100)))
+	}
+	info := string(execInfo(d, utils.ToCmdLine("stats")).ToBytes())
+	for _, field := range []string{"evicted_keys:", "evicted_clients:0", "eviction_allkeys_lru:cycles="} {
+		if !strings.Contains(info, field) {
+			t.Errorf("expected %s in INFO stats, got %s", field, info)
+		}
+	}
+	pool := string(d.Exec(client, utils.ToCmdLine("DEBUG", "EVICTION-POOL")).ToBytes())
+	for _, field := range []string{"policy:allkeys-lru", "samples:3", "pool_size:16", "candidate_0:db=0,key="} {
+		if !strings.Contains(pool, field) {
+			t.Errorf("expected %s in DEBUG EVICTION-POOL, got %s", field, pool)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package database
+
+import (
+	"redigo/datastruct/zset"
+	"redigo/interface/database"
+	"redigo/interface/resp"
+	"redigo/lib/geohash"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GEO members are stored in a sorted set, the score of a member is the 52-bit geohash
+// of its coordinates, so nearby points have close scores
+
+// geoUnits maps the distance units to meters
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+	"mi": 1609.34,
+}
+
+// parseGeoUnit returns the number of meters in the unit
+func parseGeoUnit(arg []byte) (float64, resp.Reply) {
+	unit, ok := geoUnits[strings.ToLower(string(arg))]
+	if !ok {
+		return 0, reply.MakeStandardErrorReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+	}
+	return unit, nil
+}
+
+// parseCoordinates parses a longitude latitude pair and checks it can be encoded
+func parseCoordinates(lngArg, latArg []byte) (float64, float64, resp.Reply) {
+	lng, err1 := strconv.ParseFloat(string(lngArg), 64)
+	lat, err2 := strconv.ParseFloat(string(latArg), 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, reply.MakeStandardErrorReply("ERR value is not a valid float")
+	}
+	if !geohash.Valid(lng, lat) {
+		return 0, 0, reply.MakeStandardErrorReply("ERR invalid longitude,latitude pair " +
+			strconv.FormatFloat(lng, 'f', 6, 64) + "," + strconv.FormatFloat(lat, 'f', 6, 64))
+	}
+	return lng, lat, nil
+}
+
+// formatCoordinates makes the [longitude, latitude] reply of a geohash
+func formatCoordinates(hash uint64) resp.Reply {
+	lng, lat := geohash.Decode(hash)
+	return reply.MakeMultiBulkReply([][]byte{
+		[]byte(strconv.FormatFloat(lng, 'f', -1, 64)),
+		[]byte(strconv.FormatFloat(lat, 'f', -1, 64)),
+	})
+}
+
+// formatDistance formats a distance in meters in the given unit
+func formatDistance(meters float64, unit float64) []byte {
+	return []byte(strconv.FormatFloat(meters/unit, 'f', 4, 64))
+}
+
+// execGeoAdd implements the GEOADD command
+// GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func execGeoAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	nx, xx, ch := false, false, false
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+			ch = true
+		default:
+			break options
+		}
+	}
+	if nx && xx {
+		return reply.MakeStandardErrorReply("ERR XX and NX options at the same time are not compatible")
+	}
+	items := args[i:]
+	if len(items) == 0 || len(items)%3 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+
+	// validate all the points before modifying anything
+	members := make([]string, 0, len(items)/3)
+	scores := make([]float64, 0, len(items)/3)
+	for j := 0; j < len(items); j += 3 {
+		lng, lat, errReply := parseCoordinates(items[j], items[j+1])
+		if errReply != nil {
+			return errReply
+		}
+		members = append(members, string(items[j+2]))
+		scores = append(scores, float64(geohash.Encode(lng, lat)))
+	}
+
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		zsetObj, exists := getAsZSet(db, key)
+		if exists && zsetObj == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+
+		added, changed := 0, 0
+		for j, member := range members {
+			oldScore, memberExists := zsetObj.Score(member)
+			if (nx && memberExists) || (xx && !memberExists) {
+				continue
+			}
+			zsetObj.Add(member, scores[j])
+			if !memberExists {
+				added++
+			} else if oldScore != scores[j] {
+				changed++
+			}
+		}
+
+		if added+changed > 0 {
+			db.PutEntity(key, &database.DataEntity{Data: zsetObj})
+			db.addAof(utils.ToCmdLineWithName("GEOADD", args...))
+		}
+
+		if ch {
+			result = reply.MakeIntReply(int64(added + changed))
+		} else {
+			result = reply.MakeIntReply(int64(added))
+		}
+	})
+
+	return result
+}
+
+// execGeoPos implements the GEOPOS command
+// GEOPOS key member [member ...]
+func execGeoPos(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		zsetObj, exists := getAsZSet(db, key)
+		if exists && zsetObj == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+
+		positions := make([]resp.Reply, 0, len(args)-1)
+		for _, member := range args[1:] {
+			score, ok := zsetObj.Score(string(member))
+			if !ok {
+				positions = append(positions, reply.MakeNullMultiBulkReply())
+				continue
+			}
+			positions = append(positions, formatCoordinates(uint64(score)))
+		}
+		result = reply.MakeMultiRawReply(positions)
+	})
+
+	return result
+}
+
+// execGeoDist implements the GEODIST command
+// GEODIST key member1 member2 [M|KM|FT|MI]
+func execGeoDist(db *DB, args [][]byte) resp.Reply {
+	if len(args) > 4 {
+		return reply.MakeSyntaxErrReply()
+	}
+	key := string(args[0])
+	unit := 1.0
+	if len(args) == 4 {
+		var errReply resp.Reply
+		unit, errReply = parseGeoUnit(args[3])
+		if errReply != nil {
+			return errReply
+		}
+	}
+
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		zsetObj, exists := getAsZSet(db, key)
+		if exists && zsetObj == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+
+		score1, ok1 := zsetObj.Score(string(args[1]))
+		score2, ok2 := zsetObj.Score(string(args[2]))
+		if !ok1 || !ok2 {
+			result = reply.MakeNullBulkReply()
+			return
+		}
+		lng1, lat1 := geohash.Decode(uint64(score1))
+		lng2, lat2 := geohash.Decode(uint64(score2))
+		result = reply.MakeBulkReply(formatDistance(geohash.Distance(lng1, lat1, lng2, lat2), unit))
+	})
+
+	return result
+}
+
+// geoSearchOptions holds the parsed arguments of GEOSEARCH
+type geoSearchOptions struct {
+	fromMember []byte // FROMMEMBER member
+	fromLonLat bool   // FROMLONLAT longitude latitude
+	lng, lat   float64
+
+	byRadius      bool // BYRADIUS radius unit
+	byBox         bool // BYBOX width height unit
+	radius        float64
+	width, height float64
+	unit          float64
+
+	sort      int // 0: unsorted, 1: ASC, -1: DESC
+	count     int // 0 means no limit
+	any       bool
+	withCoord bool
+	withDist  bool
+	withHash  bool
+}
+
+// parseGeoSearchOptions parses the arguments of GEOSEARCH after the key
+func parseGeoSearchOptions(args [][]byte) (*geoSearchOptions, resp.Reply) {
+	opts := &geoSearchOptions{}
+	parseLength := func(arg []byte) (float64, resp.Reply) {
+		val, err := strconv.ParseFloat(string(arg), 64)
+		if err != nil {
+			return 0, reply.MakeStandardErrorReply("ERR need numeric radius")
+		}
+		if val < 0 {
+			return 0, reply.MakeStandardErrorReply("ERR radius cannot be negative")
+		}
+		return val, nil
+	}
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		var errReply resp.Reply
+		switch strings.ToUpper(string(args[i])) {
+		case "FROMMEMBER":
+			if remaining < 1 || opts.fromMember != nil || opts.fromLonLat {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			opts.fromMember = args[i+1]
+			i++
+		case "FROMLONLAT":
+			if remaining < 2 || opts.fromMember != nil || opts.fromLonLat {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			opts.lng, opts.lat, errReply = parseCoordinates(args[i+1], args[i+2])
+			opts.fromLonLat = true
+			i += 2
+		case "BYRADIUS":
+			if remaining < 2 || opts.byRadius || opts.byBox {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			if opts.radius, errReply = parseLength(args[i+1]); errReply == nil {
+				opts.unit, errReply = parseGeoUnit(args[i+2])
+			}
+			opts.byRadius = true
+			i += 2
+		case "BYBOX":
+			if remaining < 3 || opts.byRadius || opts.byBox {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			if opts.width, errReply = parseLength(args[i+1]); errReply == nil {
+				if opts.height, errReply = parseLength(args[i+2]); errReply == nil {
+					opts.unit, errReply = parseGeoUnit(args[i+3])
+				}
+			}
+			opts.byBox = true
+			i += 3
+		case "ASC":
+			opts.sort = 1
+		case "DESC":
+			opts.sort = -1
+		case "COUNT":
+			if remaining < 1 {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+			}
+			if count <= 0 {
+				return nil, reply.MakeStandardErrorReply("ERR COUNT must be > 0")
+			}
+			opts.count = count
+			i++
+			if i+1 < len(args) && strings.ToUpper(string(args[i+1])) == "ANY" {
+				opts.any = true
+				i++
+			}
+		case "WITHCOORD":
+			opts.withCoord = true
+		case "WITHDIST":
+			opts.withDist = true
+		case "WITHHASH":
+			opts.withHash = true
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+		if errReply != nil {
+			return nil, errReply
+		}
+	}
+	if opts.fromMember == nil && !opts.fromLonLat {
+		return nil, reply.MakeStandardErrorReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	}
+	if !opts.byRadius && !opts.byBox {
+		return nil, reply.MakeStandardErrorReply("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	}
+	if opts.count > 0 && opts.sort == 0 && !opts.any {
+		// without ANY the nearest points are returned
+		opts.sort = 1
+	}
+	return opts, nil
+}
+
+// geoPoint is a member found by GEOSEARCH
+type geoPoint struct {
+	member string
+	hash   uint64
+	dist   float64 // in meters
+}
+
+// geoSearch returns the members of the zset in the area described by opts
+func geoSearch(zsetObj zset.ZSet, opts *geoSearchOptions) []*geoPoint {
+	var halfWidth, halfHeight float64
+	if opts.byRadius {
+		halfWidth = opts.radius * opts.unit
+		halfHeight = halfWidth
+	} else {
+		halfWidth = opts.width * opts.unit / 2
+		halfHeight = opts.height * opts.unit / 2
+	}
+
+	var points []*geoPoint
+	for _, r := range geohash.SearchRanges(opts.lng, opts.lat, halfWidth, halfHeight) {
+		for _, member := range zsetObj.RangeByScore(float64(r.Min), float64(r.Max-1), 0, 0) {
+			score, _ := zsetObj.Score(member)
+			lng, lat := geohash.Decode(uint64(score))
+			var dist float64
+			if opts.byRadius {
+				dist = geohash.Distance(opts.lng, opts.lat, lng, lat)
+				if dist > halfWidth {
+					continue
+				}
+			} else {
+				var inBox bool
+				dist, inBox = geohash.DistanceInBox(opts.lng, opts.lat, halfWidth*2, halfHeight*2, lng, lat)
+				if !inBox {
+					continue
+				}
+			}
+			points = append(points, &geoPoint{member: member, hash: uint64(score), dist: dist})
+			if opts.any && len(points) == opts.count {
+				break
+			}
+		}
+		if opts.any && len(points) == opts.count {
+			break
+		}
+	}
+
+	if opts.sort != 0 {
+		sort.SliceStable(points, func(i, j int) bool {
+			if opts.sort > 0 {
+				return points[i].dist < points[j].dist
+			}
+			return points[i].dist > points[j].dist
+		})
+	}
+	if opts.count > 0 && len(points) > opts.count {
+		points = points[:opts.count]
+	}
+	return points
+}
+
+// execGeoSearch implements the GEOSEARCH command
+// GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func execGeoSearch(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	opts, errReply := parseGeoSearchOptions(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		zsetObj, exists := getAsZSet(db, key)
+		if exists && zsetObj == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+		if !exists {
+			result = reply.MakeEmptyMultiBulkReply()
+			return
+		}
+		if opts.fromMember != nil {
+			score, ok := zsetObj.Score(string(opts.fromMember))
+			if !ok {
+				result = reply.MakeStandardErrorReply("ERR could not decode requested zset member")
+				return
+			}
+			opts.lng, opts.lat = geohash.Decode(uint64(score))
+		}
+
+		points := geoSearch(zsetObj, opts)
+		if !opts.withCoord && !opts.withDist && !opts.withHash {
+			members := make([][]byte, len(points))
+			for i, point := range points {
+				members[i] = []byte(point.member)
+			}
+			result = reply.MakeMultiBulkReply(members)
+			return
+		}
+		// each point is [member, dist?, hash?, [longitude, latitude]?]
+		items := make([]resp.Reply, len(points))
+		for i, point := range points {
+			item := []resp.Reply{reply.MakeBulkReply([]byte(point.member))}
+			if opts.withDist {
+				item = append(item, reply.MakeBulkReply(formatDistance(point.dist, opts.unit)))
+			}
+			if opts.withHash {
+				item = append(item, reply.MakeIntReply(int64(point.hash)))
+			}
+			if opts.withCoord {
+				item = append(item, formatCoordinates(point.hash))
+			}
+			items[i] = reply.MakeMultiRawReply(item)
+		}
+		result = reply.MakeMultiRawReply(items)
+	})
+
+	return result
+}
+
+// Register GEO commands
+func init() {
+	RegisterCommand("GEOADD", execGeoAdd, -5)       // key [NX|XX] [CH] longitude latitude member [...]
+	RegisterCommand("GEOPOS", execGeoPos, -2)       // key member [member ...]
+	RegisterCommand("GEODIST", execGeoDist, -4)     // key member1 member2 [unit]
+	RegisterCommand("GEOSEARCH", execGeoSearch, -7) // key FROMMEMBER|FROMLONLAT BYRADIUS|BYBOX [options]
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+// addSicily adds the members of the example of the Redis documentation
+func addSicily(t *testing.T, db *DB) {
+	t.Helper()
+	assertReply(t, exec(db, "GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"), ":2\r\n")
+}
+
+// TestGeoDistPos tests GEODIST and GEOPOS against the values of Redis
+func TestGeoDistPos(t *testing.T) {
+	db := MakeDB()
+	addSicily(t, db)
+	assertReply(t, exec(db, "GEODIST", "Sicily", "Palermo", "Catania"), "$11\r\n166274.1516\r\n")
+	assertReply(t, exec(db, "GEODIST", "Sicily", "Palermo", "Catania", "km"), "$8\r\n166.2742\r\n")
+	assertReply(t, exec(db, "GEODIST", "Sicily", "Palermo", "missing"), "$-1\r\n")
+	assertReply(t, exec(db, "GEOPOS", "Sicily", "Palermo", "missing"),
+		"*2\r\n*2\r\n$18\r\n13.361389338970184\r\n$16\r\n38.1155563954963\r\n*-1\r\n")
+	assertReply(t, exec(db, "GEODIST", "Sicily", "Palermo", "Catania", "parsec"), "-ERR unsupported unit provided. please use M, KM, FT, MI\r\n")
+}
+
+// TestGeoAdd tests the options of GEOADD and the coordinates it refuses
+func TestGeoAdd(t *testing.T) {
+	db := MakeDB()
+	addSicily(t, db)
+	assertReply(t, exec(db, "GEOADD", "Sicily", "XX", "CH", "13.5", "38.1", "Palermo", "1", "1", "New"), ":1\r\n")
+	assertReply(t, exec(db, "ZCARD", "Sicily"), ":2\r\n")
+	assertReply(t, exec(db, "GEOADD", "Sicily", "NX", "1", "1", "Palermo", "1", "1", "New"), ":1\r\n")
+	if r := string(exec(db, "GEOPOS", "Sicily", "Palermo").ToBytes()); !strings.Contains(r, "13.5") {
+		t.Errorf("Expected NX to keep the position changed by XX, got %q", r)
+	}
+	assertReply(t, exec(db, "GEOADD", "Sicily", "NX", "XX", "1", "1", "a"), "-ERR XX and NX options at the same time are not compatible\r\n")
+	assertReply(t, exec(db, "GEOADD", "Sicily", "200", "38", "a"), "-ERR invalid longitude,latitude pair 200.000000,38.000000\r\n")
+	assertReply(t, exec(db, "GEOADD", "Sicily", "1", "1", "a", "2"), "-ERR syntax error\r\n")
+}
+
+// TestGeoSearch tests GEOSEARCH by radius and by box, with the order, the count and the options
+// of the reply
+func TestGeoSearch(t *testing.T) {
+	db := MakeDB()
+	addSicily(t, db)
+	assertReply(t, exec(db, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"),
+		"*2\r\n$7\r\nCatania\r\n$7\r\nPalermo\r\n")
+	assertReply(t, exec(db, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "DESC"),
+		"*2\r\n$7\r\nPalermo\r\n$7\r\nCatania\r\n")
+	assertReply(t, exec(db, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km", "ASC", "WITHDIST"),
+		"*1\r\n*2\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n")
+	assertReply(t, exec(db, "GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYBOX", "400", "400", "km", "DESC", "COUNT", "1", "WITHCOORD"),
+		"*1\r\n*2\r\n$7\r\nCatania\r\n*2\r\n$18\r\n15.087267458438873\r\n$17\r\n37.50266842333162\r\n")
+	assertReply(t, exec(db, "GEOSEARCH", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "10", "km"), "*0\r\n")
+	assertReply(t, exec(db, "GEOSEARCH", "missing", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km"), "*0\r\n")
+}
@@ -1,27 +1,38 @@
 package database
 
 import (
+	"redigo/datastruct/hash"
+	"redigo/interface/database"
 	"redigo/interface/resp"
 	"redigo/lib/utils"
 	"redigo/resp/reply"
 )
 
-// HSet sets field in the hash stored at key to value
+// HSet sets the fields in the hash stored at key to their values, it replies the number of fields added
 func execHSet(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	field := string(args[1])
-	value := string(args[2])
+
+	if len(args)%2 == 0 {
+		return reply.MakeArgNumErrReply("hset")
+	}
 
 	var result resp.Reply
 
 	// Use key-level locking to prevent concurrent modification of the same hash
 	db.WithKeyLock(key, func() {
 		hashObj, _ := db.getOrCreateHash(key)
-		res := hashObj.Set(field, value)
+		if hashObj == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+		added := 0
+		for i := 1; i < len(args); i += 2 {
+			added += hashObj.Set(string(args[i]), string(args[i+1]))
+		}
 
 		db.addAof(utils.ToCmdLineWithName("HSET", args...))
 
-		result = reply.MakeIntReply(int64(res))
+		result = reply.MakeIntReply(int64(added))
 	})
 
 	return result
@@ -36,6 +47,9 @@ func execHGet(db *DB, args [][]byte) resp.Reply {
 	if !exists {
 		return reply.MakeNullBulkReply()
 	}
+	if hash == nil {
+		return reply.MakeWrongTypeErrReply()
+	}
 
 	value, exists := hash.Get(field)
 	if !exists {
@@ -54,6 +68,9 @@ func execHExists(db *DB, args [][]byte) resp.Reply {
 	if !exists {
 		return reply.MakeIntReply(0)
 	}
+	if hash == nil {
+		return reply.MakeWrongTypeErrReply()
+	}
 
 	exists = hash.Exists(field)
 	if exists {
@@ -75,6 +92,10 @@ func execHDel(db *DB, args [][]byte) resp.Reply {
 			result = reply.MakeIntReply(0)
 			return
 		}
+		if hash == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
 
 		deleted := 0
 		for _, field := range args[1:] {
@@ -82,7 +103,7 @@ func execHDel(db *DB, args [][]byte) resp.Reply {
 		}
 
 		if hash.Len() == 0 {
-			db.Remove(key)
+			db.removeEmptied(key)
 		}
 
 		if deleted > 0 {
@@ -103,6 +124,9 @@ func execHLen(db *DB, args [][]byte) resp.Reply {
 	if !exists {
 		return reply.MakeIntReply(0)
 	}
+	if hash == nil {
+		return reply.MakeWrongTypeErrReply()
+	}
 
 	return reply.MakeIntReply(int64(hash.Len()))
 }
@@ -117,23 +141,46 @@ func execHGetAll(db *DB, args [][]byte) resp.Reply {
 	db.WithKeyRLock(key, func() {
 		hash, exists := db.getAsHash(key)
 		if !exists {
-			result = reply.MakeEmptyMultiBulkReply()
+			result = reply.MakeMapReply(nil, nil)
+			return
+		}
+		if hash == nil {
+			result = reply.MakeWrongTypeErrReply()
 			return
 		}
 
+		// A map under RESP3, flattened to field value pairs for RESP2 connections
+		if hash.Len() >= streamMinElements {
+			result = db.elementsReply('%', key, writeHashPairs)
+			return
+		}
 		allMap := hash.GetAll()
-		resultBytes := make([][]byte, 0, len(allMap)*2)
+		fields := make([]resp.Reply, 0, len(allMap))
+		values := make([]resp.Reply, 0, len(allMap))
 		for field, value := range allMap {
-			resultBytes = append(resultBytes, []byte(field))
-			resultBytes = append(resultBytes, []byte(value))
+			fields = append(fields, reply.MakeBulkReply([]byte(field)))
+			values = append(values, reply.MakeBulkReply([]byte(value)))
 		}
 
-		result = reply.MakeMultiBulkReply(resultBytes)
+		result = reply.MakeMapReply(fields, values)
 	})
 
 	return result
 }
 
+// writeHashPairs writes out the fields and values of the hash of entity
+func writeHashPairs(entity *database.DataEntity, header func(n int), element func(elem []byte) bool) reply.ErrorReply {
+	h, ok := entity.Data.(*hash.Hash)
+	if !ok {
+		return reply.MakeWrongTypeErrReply()
+	}
+	header(2 * h.Len())
+	h.ForEach(func(field, value string) bool {
+		return element([]byte(field)) && element([]byte(value))
+	})
+	return nil
+}
+
 // HKeys returns all fields in hash
 func execHKeys(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
@@ -147,6 +194,10 @@ func execHKeys(db *DB, args [][]byte) resp.Reply {
 			result = reply.MakeEmptyMultiBulkReply()
 			return
 		}
+		if hash == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
 
 		fields := hash.Fields()
 		resultBytes := make([][]byte, len(fields))
@@ -173,6 +224,10 @@ func execHVals(db *DB, args [][]byte) resp.Reply {
 			result = reply.MakeEmptyMultiBulkReply()
 			return
 		}
+		if hash == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
 
 		values := hash.Values()
 		resultBytes := make([][]byte, len(values))
@@ -203,6 +258,10 @@ func execHMGet(db *DB, args [][]byte) resp.Reply {
 			result = reply.MakeMultiBulkReply(results)
 			return
 		}
+		if hash == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
 
 		results := make([][]byte, len(args)-1)
 		for i, field := range args[1:] {
@@ -234,6 +293,10 @@ func execHMSet(db *DB, args [][]byte) resp.Reply {
 	// Use key-level locking to prevent concurrent modification of the same hash
 	db.WithKeyLock(key, func() {
 		hash, _ := db.getOrCreateHash(key)
+		if hash == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
 
 		for i := 1; i < len(args); i += 2 {
 			field := string(args[i])
@@ -259,6 +322,9 @@ func execHEncoding(db *DB, args [][]byte) resp.Reply {
 	if !exists {
 		return reply.MakeNullBulkReply()
 	}
+	if hash == nil {
+		return reply.MakeWrongTypeErrReply()
+	}
 
 	return reply.MakeIntReply(int64(hash.Encoding()))
 }
@@ -275,6 +341,10 @@ func execHSetNX(db *DB, args [][]byte) resp.Reply {
 	// Use key-level locking to prevent concurrent modification of the same hash
 	db.WithKeyLock(key, func() {
 		hash, _ := db.getOrCreateHash(key)
+		if hash == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
 
 		_, exists := hash.Get(field)
 		if exists {
@@ -294,7 +364,7 @@ func execHSetNX(db *DB, args [][]byte) resp.Reply {
 
 func init() {
 	// Register hash commands
-	RegisterCommand("HSET", execHSet, 4)           // HSET key field value
+	RegisterCommand("HSET", execHSet, -4)          // HSET key field value [field value ...]
 	RegisterCommand("HGET", execHGet, 3)           // HGET key field
 	RegisterCommand("HEXISTS", execHExists, 3)     // HEXISTS key field
 	RegisterCommand("HDEL", execHDel, -3)          // HDEL key field [field ...] (at least 2 args plus command name)
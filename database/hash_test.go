@@ -0,0 +1,31 @@
+package database
+
+import (
+	"redigo/resp/reply"
+	"testing"
+)
+
+// TestHashWrongType tests that the hash commands neither panic nor change a key of another type
+func TestHashWrongType(t *testing.T) {
+	db := MakeDB()
+	exec(db, "SET", "string", "value")
+	wrongType := string(reply.MakeWrongTypeErrReply().ToBytes())
+
+	for _, cmd := range [][]string{
+		{"HSET", "string", "f", "v"},
+		{"HMSET", "string", "f", "v"},
+		{"HSETNX", "string", "f", "v"},
+		{"HGET", "string", "f"},
+		{"HEXISTS", "string", "f"},
+		{"HDEL", "string", "f"},
+		{"HLEN", "string"},
+		{"HGETALL", "string"},
+		{"HKEYS", "string"},
+		{"HVALS", "string"},
+		{"HMGET", "string", "f"},
+		{"HENCODING", "string"},
+	} {
+		assertReply(t, exec(db, cmd...), wrongType)
+	}
+	assertReply(t, exec(db, "GET", "string"), "$5\r\nvalue\r\n")
+}
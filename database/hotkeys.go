@@ -0,0 +1,95 @@
+package database
+
+import (
+	"redigo/config"
+	"redigo/datastruct/topk"
+	"redigo/interface/resp"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// hotKeysTracked is the number of keys kept by the sketch of each DB
+const hotKeysTracked = 32
+
+// hotKeys counts the accesses to the keys of a DB with a HeavyKeeper sketch, whose decay
+// makes keys that are no longer accessed fall out like the LFU counters of Redis do
+// Only accesses to existing keys are counted, see DB.GetEntity
+type hotKeys struct {
+	mu     sync.Mutex
+	sketch *topk.TopK
+}
+
+func makeHotKeys() *hotKeys {
+	return &hotKeys{
+		sketch: topk.Make(hotKeysTracked, 256, 4, topk.DefaultDecay),
+	}
+}
+
+// touch records an access to the key if tracking is enabled
+func (h *hotKeys) touch(key string) {
+	if config.Properties == nil || !config.Properties.TrackHotKeys {
+		return
+	}
+	h.mu.Lock()
+	h.sketch.IncrBy(key, 1)
+	h.mu.Unlock()
+}
+
+// top returns at most count of the most accessed keys, the hottest first
+// Keys which have been removed are skipped
+func (h *hotKeys) top(db *DB, count int) []topk.Item {
+	h.mu.Lock()
+	items := h.sketch.List()
+	h.mu.Unlock()
+	result := make([]topk.Item, 0, count)
+	for _, item := range items {
+		if len(result) >= count {
+			break
+		}
+		if _, ok := db.data.Get(item.Item); ok {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// reset forgets all counters, used by FLUSHDB
+func (h *hotKeys) reset() {
+	h.mu.Lock()
+	h.sketch = topk.Make(hotKeysTracked, 256, 4, topk.DefaultDecay)
+	h.mu.Unlock()
+}
+
+// execHotKeys implements the HOTKEYS command
+// HOTKEYS [COUNT n]
+// It replies with [key, count] pairs of the most accessed keys of the current DB
+func execHotKeys(db *DB, args [][]byte) resp.Reply {
+	count := 10
+	if len(args) == 2 && strings.ToUpper(string(args[0])) == "COUNT" {
+		n, err := strconv.Atoi(string(args[1]))
+		if err != nil || n <= 0 {
+			return reply.MakeStandardErrorReply("ERR COUNT must be a positive integer")
+		}
+		count = n
+	} else if len(args) != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if !config.Properties.TrackHotKeys {
+		return reply.MakeStandardErrorReply("ERR hot keys tracking is disabled, set track-hot-keys yes in the config file")
+	}
+	items := db.hotKeys.top(db, count)
+	result := make([]resp.Reply, len(items))
+	for i, item := range items {
+		result[i] = reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(item.Item)),
+			reply.MakeIntReply(int64(item.Count)),
+		})
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+func init() {
+	registerReadOnlyCommand("HOTKEYS", execHotKeys, -1) // HOTKEYS [COUNT n]
+}
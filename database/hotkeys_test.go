@@ -0,0 +1,55 @@
+package database
+
+import (
+	"redigo/config"
+	"redigo/lib/utils"
+	"redigo/resp/connection"
+	"strings"
+	"testing"
+)
+
+// TestHotKeys tests that HOTKEYS and INFO report the most read keys of the DB, without the
+// removed keys, and only when the tracking is enabled
+func TestHotKeys(t *testing.T) {
+	defer func(enabled bool) {
+		config.Properties.TrackHotKeys = enabled
+	}(config.Properties.TrackHotKeys)
+	config.Properties.TrackHotKeys = false
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	exec := func(args ...string) string {
+		return string(d.Exec(client, utils.ToCmdLine(args...)).ToBytes())
+	}
+	if r := exec("HOTKEYS"); !strings.HasPrefix(r, "-ERR hot keys tracking is disabled") {
+		t.Errorf("Expected HOTKEYS to need track-hot-keys, got %q", r)
+	}
+	if r := exec("INFO", "hotkeys"); !strings.Contains(r, "hotkeys_tracking:disabled\r\n") {
+		t.Errorf("Expected the tracking to be reported disabled, got %q", r)
+	}
+
+	config.Properties.TrackHotKeys = true
+	for _, key := range []string{"hot", "warm", "gone"} {
+		exec("SET", key, "v")
+	}
+	for i := 0; i < 5; i++ {
+		exec("GET", "hot")
+	}
+	for i := 0; i < 3; i++ {
+		exec("GET", "gone")
+	}
+	exec("GET", "warm")
+	exec("GET", "missing")
+	exec("DEL", "gone")
+
+	assertReply(t, d.Exec(client, utils.ToCmdLine("HOTKEYS", "COUNT", "1")), "*1\r\n*2\r\n$3\r\nhot\r\n:5\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("HOTKEYS")), "*2\r\n*2\r\n$3\r\nhot\r\n:5\r\n*2\r\n$4\r\nwarm\r\n:1\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("HOTKEYS", "COUNT", "0")), "-ERR COUNT must be a positive integer\r\n")
+	if r := exec("INFO", "hotkeys"); !strings.Contains(r, "hotkey_0_0:key=hot,count=5\r\nhotkey_0_1:key=warm,count=1\r\n") {
+		t.Errorf("Expected the hot keys in INFO, got %q", r)
+	}
+
+	exec("FLUSHDB")
+	exec("SET", "hot", "v")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("HOTKEYS")), "*0\r\n")
+}
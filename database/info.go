@@ -0,0 +1,243 @@
+package database
+
+import (
+	"os"
+	"redigo/config"
+	"redigo/interface/resp"
+	"redigo/lib/metrics"
+	"redigo/lib/stats"
+	"redigo/resp/reply"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// infoSection writes a section of the INFO reply
+type infoSection func(d *StandaloneDatabase, sb *strings.Builder)
+
+// namedInfoSection is a section of the INFO reply with its name
+type namedInfoSection struct {
+	name  string
+	write infoSection
+}
+
+// defaultInfoSections lists the sections in the order they are reported
+var defaultInfoSections = []namedInfoSection{
+	{"server", infoServer},
+	{"clients", infoClients},
+	{"memory", infoMemory},
+	{"persistence", infoPersistence},
+	{"stats", infoStats},
+	{"replication", infoReplication},
+	{"keyspace", infoKeyspace},
+	{"hotkeys", infoHotKeys},
+	{"cluster", infoCluster},
+}
+
+// RegisterInfoSection adds a section to the INFO reply of the database, replacing the section of
+// the same name. It lets the cluster report its own state, it must be called before serving clients
+func (d *StandaloneDatabase) RegisterInfoSection(name string, write func(sb *strings.Builder)) {
+	section := func(d *StandaloneDatabase, sb *strings.Builder) {
+		write(sb)
+	}
+	for i := range d.infoSections {
+		if d.infoSections[i].name == name {
+			d.infoSections[i].write = section
+			return
+		}
+	}
+	d.infoSections = append(d.infoSections, namedInfoSection{name, section})
+}
+
+// hotKeysReported is the number of hot keys of each DB reported by INFO
+const hotKeysReported = 10
+
+// execInfo implements the INFO command
+// INFO [section ...]
+// Sections are server, clients, memory, persistence, stats, replication, keyspace, hotkeys and cluster, "all" and "default" report every section
+func execInfo(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	wanted := make(map[string]bool)
+	for _, arg := range args {
+		wanted[strings.ToLower(string(arg))] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+	var sb strings.Builder
+	for _, section := range d.infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		section.write(d, &sb)
+	}
+	return reply.MakeBulkReply([]byte(sb.String()))
+}
+
+func infoServer(d *StandaloneDatabase, sb *strings.Builder) {
+	sb.WriteString("redigo_version:" + redigoVersion + "\r\n")
+	sb.WriteString("go_version:" + runtime.Version() + "\r\n")
+	sb.WriteString("os:" + runtime.GOOS + " " + runtime.GOARCH + "\r\n")
+	sb.WriteString("process_id:" + strconv.Itoa(os.Getpid()) + "\r\n")
+	sb.WriteString("tcp_port:" + strconv.Itoa(config.Properties.Port) + "\r\n")
+	uptime := int64(stats.Server.Uptime() / time.Second)
+	sb.WriteString("uptime_in_seconds:" + strconv.FormatInt(uptime, 10) + "\r\n")
+	sb.WriteString("uptime_in_days:" + strconv.FormatInt(uptime/(24*3600), 10) + "\r\n")
+	if d.IsReadOnly() {
+		sb.WriteString("maintenance_mode:1\r\n")
+	} else {
+		sb.WriteString("maintenance_mode:0\r\n")
+	}
+}
+
+func infoClients(d *StandaloneDatabase, sb *strings.Builder) {
+	sb.WriteString("connected_clients:" + strconv.FormatInt(stats.Server.ConnectedClients(), 10) + "\r\n")
+	sb.WriteString("blocked_clients:" + strconv.Itoa(d.blockedClients()) + "\r\n")
+}
+
+// infoMemory reports the memory of the Go heap: used_memory is the bytes of the allocated objects,
+// used_memory_sys those obtained from the OS
+func infoMemory(d *StandaloneDatabase, sb *strings.Builder) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	sb.WriteString("used_memory:" + strconv.FormatUint(mem.HeapAlloc, 10) + "\r\n")
+	sb.WriteString("used_memory_human:" + bytesToHuman(mem.HeapAlloc) + "\r\n")
+	sb.WriteString("used_memory_heap_inuse:" + strconv.FormatUint(mem.HeapInuse, 10) + "\r\n")
+	sb.WriteString("used_memory_sys:" + strconv.FormatUint(mem.Sys, 10) + "\r\n")
+	sb.WriteString("used_memory_sys_human:" + bytesToHuman(mem.Sys) + "\r\n")
+	sb.WriteString("heap_objects:" + strconv.FormatUint(mem.HeapObjects, 10) + "\r\n")
+	sb.WriteString("gc_cycles:" + strconv.FormatUint(uint64(mem.NumGC), 10) + "\r\n")
+	sb.WriteString("mem_allocator:go\r\n")
+	// the estimated memory of the values, which maxmemory limits
+	used := uint64(d.usedMemory())
+	sb.WriteString("used_memory_dataset:" + strconv.FormatUint(used, 10) + "\r\n")
+	sb.WriteString("used_memory_dataset_human:" + bytesToHuman(used) + "\r\n")
+	sb.WriteString("maxmemory:" + strconv.Itoa(config.Properties.MaxMemory) + "\r\n")
+	sb.WriteString("maxmemory_human:" + bytesToHuman(uint64(config.Properties.MaxMemory)) + "\r\n")
+	sb.WriteString("maxmemory_policy:" + maxMemoryPolicy() + "\r\n")
+	sb.WriteString("maxmemory_samples:" + strconv.Itoa(maxMemorySamples()) + "\r\n")
+}
+
+// bytesToHuman formats bytes like Redis does, e.g. 1.50M
+func bytesToHuman(n uint64) string {
+	const unit = 1024
+	switch {
+	case n < unit:
+		return strconv.FormatUint(n, 10) + "B"
+	case n < unit*unit:
+		return strconv.FormatFloat(float64(n)/unit, 'f', 2, 64) + "K"
+	case n < unit*unit*unit:
+		return strconv.FormatFloat(float64(n)/(unit*unit), 'f', 2, 64) + "M"
+	}
+	return strconv.FormatFloat(float64(n)/(unit*unit*unit), 'f', 2, 64) + "G"
+}
+
+func infoStats(d *StandaloneDatabase, sb *strings.Builder) {
+	sb.WriteString("total_connections_received:" + strconv.FormatInt(stats.Server.TotalConnections(), 10) + "\r\n")
+	sb.WriteString("total_commands_processed:" + strconv.FormatInt(stats.Server.TotalCommands(), 10) + "\r\n")
+	sb.WriteString("instantaneous_ops_per_sec:" + strconv.FormatInt(stats.Server.OpsPerSecond(), 10) + "\r\n")
+	sb.WriteString("rejected_connections:" + strconv.FormatInt(stats.Server.RejectedConnections(), 10) + "\r\n")
+	sb.WriteString("keys_truncated:" + strconv.FormatInt(stats.Server.TruncatedKeys(), 10) + "\r\n")
+	sb.WriteString("command_panics:" + strconv.FormatInt(stats.Server.CommandPanics(), 10) + "\r\n")
+	sb.WriteString("evicted_keys:" + strconv.FormatInt(d.evictedKeys.Load(), 10) + "\r\n")
+	// maxmemory only limits the dataset, the clients are never evicted like with maxmemory-clients
+	sb.WriteString("evicted_clients:0\r\n")
+	d.evictions.writeStats(sb)
+}
+
+// startStatsSampling samples the rate of the commands reported as instantaneous_ops_per_sec
+func (d *StandaloneDatabase) startStatsSampling() {
+	go func() {
+		ticker := time.NewTicker(stats.SampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.closed:
+				return
+			case now := <-ticker.C:
+				stats.Server.Sample(now)
+			}
+		}
+	}()
+}
+
+func infoReplication(d *StandaloneDatabase, sb *strings.Builder) {
+	if link := d.master.Load(); link != nil {
+		sb.WriteString("role:slave\r\n")
+		infoReplicaReplication(d, link, sb)
+	} else {
+		sb.WriteString("role:master\r\n")
+	}
+	infoMasterReplication(d, sb)
+}
+
+// infoCluster is replaced by the cluster when the server runs in cluster mode
+func infoCluster(d *StandaloneDatabase, sb *strings.Builder) {
+	sb.WriteString("cluster_enabled:0\r\n")
+}
+
+// collectMetrics reports the replication state and the keyspace to the metrics endpoint
+func (d *StandaloneDatabase) collectMetrics(w *metrics.Writer) {
+	d.repl.mu.Lock()
+	replicas, histlen := len(d.repl.replicas), d.repl.histlen
+	d.repl.mu.Unlock()
+	w.Gauge("redigo_connected_replicas", "Number of connected replicas.", metrics.Sample{Value: float64(replicas)})
+	w.Gauge("redigo_master_repl_offset", "Replication offset of the master.",
+		metrics.Sample{Value: float64(d.repl.offset.Load())})
+	w.Gauge("redigo_repl_backlog_histlen_bytes", "Bytes of data in the replication backlog.",
+		metrics.Sample{Value: float64(histlen)})
+	w.Gauge("redigo_connected_clients", "Number of client connections.",
+		metrics.Sample{Value: float64(stats.Server.ConnectedClients())})
+	w.Counter("redigo_commands_processed_total", "Number of commands processed.",
+		metrics.Sample{Value: float64(stats.Server.TotalCommands())})
+	w.Counter("redigo_command_panics_total", "Number of commands whose execution panicked.",
+		metrics.Sample{Value: float64(stats.Server.CommandPanics())})
+	w.Gauge("redigo_blocked_clients", "Number of clients blocked by a blocking command.",
+		metrics.Sample{Value: float64(d.blockedClients())})
+	w.Gauge("redigo_used_memory_dataset_bytes", "Estimated memory of the values, limited by maxmemory.",
+		metrics.Sample{Value: float64(d.usedMemory())})
+	w.Counter("redigo_evicted_keys_total", "Number of keys evicted over maxmemory.",
+		metrics.Sample{Value: float64(d.evictedKeys.Load())})
+	var aofPending int64
+	if aofHandler := d.aofHandler.Load(); aofHandler != nil {
+		_, aofPending = aofHandler.Backpressure()
+	}
+	w.Gauge("redigo_aof_pending_commands", "Number of commands waiting for the AOF writer.",
+		metrics.Sample{Value: float64(aofPending)})
+	var keys, expires []metrics.Sample
+	d.forEachDB(func(db *DB) {
+		labels := []metrics.Label{{Name: "db", Value: strconv.Itoa(db.index)}}
+		keys = append(keys, metrics.Sample{Labels: labels, Value: float64(db.data.Len())})
+		expires = append(expires, metrics.Sample{Labels: labels, Value: float64(db.expires.len())})
+	})
+	w.Gauge("redigo_db_keys", "Number of keys in the DB.", keys...)
+	w.Gauge("redigo_db_expiring_keys", "Number of keys with an expiration in the DB.", expires...)
+}
+
+func infoKeyspace(d *StandaloneDatabase, sb *strings.Builder) {
+	d.forEachDB(func(db *DB) {
+		if keys := db.data.Len(); keys > 0 {
+			sb.WriteString("db" + strconv.Itoa(db.index) + ":keys=" + strconv.Itoa(keys) +
+				",expires=" + strconv.Itoa(db.expires.len()) + "\r\n")
+		}
+	})
+}
+
+// infoHotKeys reports the most accessed keys of each DB as hotkey_<db>_<rank>:key=<key>,count=<count>
+func infoHotKeys(d *StandaloneDatabase, sb *strings.Builder) {
+	enabled := config.Properties.TrackHotKeys
+	if enabled {
+		sb.WriteString("hotkeys_tracking:enabled\r\n")
+	} else {
+		sb.WriteString("hotkeys_tracking:disabled\r\n")
+		return
+	}
+	d.forEachDB(func(db *DB) {
+		for rank, item := range db.hotKeys.top(db, hotKeysReported) {
+			sb.WriteString("hotkey_" + strconv.Itoa(db.index) + "_" + strconv.Itoa(rank) +
+				":key=" + item.Item + ",count=" + strconv.FormatUint(uint64(item.Count), 10) + "\r\n")
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"redigo/config"
+	"redigo/interface/database"
+	"redigo/lib/logger"
+	"strconv"
+	"strings"
+)
+
+// The integrity check runs after the dataset is loaded from the AOF or an RDB file, before the
+// server serves clients, to catch a corrupted persistence file. Every value is checked against
+// the invariants of its type, and the keys whose expiration time passed meanwhile are removed.
+// The integrity-check option selects what happens to the corrupted keys:
+//   - no, the default, skips the check
+//   - report logs the corrupted keys and keeps serving them
+//   - abort refuses to start, or DEBUG RELOAD refuses to load the file
+
+// Integrity check modes of the configuration
+const (
+	integrityCheckOff    = "no"
+	integrityCheckReport = "report"
+	integrityCheckAbort  = "abort"
+)
+
+var integrityModes = map[string]bool{
+	integrityCheckOff:    true,
+	integrityCheckReport: true,
+	integrityCheckAbort:  true,
+}
+
+// maxReportedKeys limits the corrupted keys logged by the integrity check
+const maxReportedKeys = 20
+
+// Verifier may be implemented by the data types, including those of modules, to be checked by
+// the integrity check
+type Verifier interface {
+	Verify() error
+}
+
+// integrityMode returns the integrity check mode of the configuration
+func integrityMode() string {
+	mode := strings.ToLower(config.Properties.IntegrityCheck)
+	if mode == "" {
+		return integrityCheckOff
+	}
+	return mode
+}
+
+// integrityReport is the result of the integrity check
+type integrityReport struct {
+	keys    int
+	expired int
+	corrupt []string // key and error of the corrupted values
+}
+
+// add checks a value
+func (r *integrityReport) add(dbIndex int, key string, entity *database.DataEntity) {
+	r.keys++
+	if err := verifyEntity(entity); err != nil {
+		r.corrupt = append(r.corrupt, "db "+strconv.Itoa(dbIndex)+" key "+strconv.Quote(key)+": "+err.Error())
+	}
+}
+
+// err returns an error describing the corrupted values, nil if there are none
+func (r *integrityReport) err() error {
+	if len(r.corrupt) == 0 {
+		return nil
+	}
+	return fmt.Errorf("integrity check: %d of %d keys are corrupted", len(r.corrupt), r.keys)
+}
+
+// log reports the result of the check
+func (r *integrityReport) log(source string) {
+	for i, corrupt := range r.corrupt {
+		if i == maxReportedKeys {
+			logger.Error("integrity check: " + strconv.Itoa(len(r.corrupt)-i) + " more corrupted keys")
+			break
+		}
+		logger.Error("integrity check: " + corrupt)
+	}
+	logger.Info(fmt.Sprintf("integrity check of %s: %d keys, %d corrupted, %d expired removed",
+		source, r.keys, len(r.corrupt), r.expired))
+}
+
+// verifyEntity checks a value against the invariants of its type
+func verifyEntity(entity *database.DataEntity) (err error) {
+	// a corrupted structure may break the code walking it
+	defer func() {
+		if e := recover(); e != nil {
+			err = errors.New("invalid structure: " + toString(e))
+		}
+	}()
+	if entity == nil || entity.Data == nil {
+		return errors.New("no value")
+	}
+	if typeOf(entity) == "unknown" {
+		return fmt.Errorf("unknown type %T", entity.Data)
+	}
+	if v, ok := entity.Data.(Verifier); ok {
+		return v.Verify()
+	}
+	return nil
+}
+
+// checkIntegrity checks the dataset loaded at startup according to the configuration, it panics
+// on corrupted values in abort mode
+func (d *StandaloneDatabase) checkIntegrity() {
+	mode := integrityMode()
+	if mode == integrityCheckOff {
+		return
+	}
+	report := &integrityReport{}
+	d.forEachDB(func(db *DB) {
+		var expired []string
+		db.data.ForEach(func(key string, val interface{}) bool {
+			if db.isExpired(key) {
+				expired = append(expired, key)
+				return true
+			}
+			entity, _ := val.(*database.DataEntity)
+			report.add(db.index, key, entity)
+			return true
+		})
+		for _, key := range expired {
+			db.Remove(key)
+		}
+		report.expired += len(expired)
+	})
+	report.log("the AOF")
+	if err := report.err(); err != nil && mode == integrityCheckAbort {
+		panic(err)
+	}
+}
+
+// checkStagedIntegrity checks the keys decoded from an RDB file before they replace the dataset,
+// it returns an error on corrupted values in abort mode
+func checkStagedIntegrity(staged []stagedDB, filename string) error {
+	mode := integrityMode()
+	if mode == integrityCheckOff {
+		return nil
+	}
+	report := &integrityReport{}
+	for i, sdb := range staged {
+		for key, entity := range sdb.entities {
+			report.add(i, key, entity)
+		}
+	}
+	report.log(filename)
+	if mode == integrityCheckAbort {
+		return report.err()
+	}
+	return nil
+}
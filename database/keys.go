@@ -1,10 +1,16 @@
 package database
 
 import (
+	"redigo/config"
 	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/lib/stats"
 	"redigo/lib/utils"
 	"redigo/lib/wildcard"
 	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Handle the DEL command.
@@ -16,9 +22,8 @@ func execDel(db *DB, args [][]byte) resp.Reply {
 	}
 	deleted := db.Removes(keys...)
 	if deleted > 0 {
-		go func(cmdLine [][]byte) {
-			db.addAof(utils.ToCmdLineWithName("DEL", cmdLine...))
-		}(args)
+		// written before replying so that a later write of the keys is not ordered before the DEL
+		db.addAof(utils.ToCmdLineWithName("DEL", args...))
 	}
 	return reply.MakeIntReply(int64(deleted))
 }
@@ -48,66 +53,375 @@ func execFlushDB(db *DB, args [][]byte) resp.Reply {
 // It returns the type of the specified key
 func execType(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	if entity, ok := db.GetEntity(key); ok {
-		switch entity.Data.(type) {
-		// If the entity is []byte, return the type as "string"
-		case []byte:
-			return reply.MakeBulkReply([]byte("string"))
-		}
-		// TODO: Add more types as needed
-	} else {
+	entity, ok := db.GetEntity(key)
+	if !ok {
 		return reply.MakeStatusReply("none")
 	}
-	return reply.MakeUnknownReply()
+	typeName := typeOf(entity)
+	if typeName == "unknown" {
+		return reply.MakeUnknownReply()
+	}
+	return reply.MakeBulkReply([]byte(typeName))
 }
 
 // Handle the RENAME command.
-// It renames a key in the database.
+// It renames a key in the database, the expiration time moves with the value and the previous
+// value of newkey is discarded
 // RENAME key newkey
 func execRename(db *DB, args [][]byte) resp.Reply {
-	src := string(args[0])
-	dst := string(args[1])
-	entity, ok := db.GetEntity(src)
-	if !ok {
-		return reply.MakeStandardErrorReply("ERR no such key")
-	}
-	db.PutEntity(dst, entity)
-	db.Remove(src)
-	db.addAof(utils.ToCmdLineWithName("RENAME", args...))
-	return reply.MakeOKReply()
+	var result resp.Reply
+	src, dst := string(args[0]), string(args[1])
+	db.WithKeysLock([]string{src, dst}, func() {
+		if _, ok := db.GetEntity(src); !ok {
+			result = reply.MakeStandardErrorReply("ERR no such key")
+			return
+		}
+		db.renameKey(src, dst)
+		db.addAof(utils.ToCmdLineWithName("RENAME", args...))
+		result = reply.MakeOKReply()
+	})
+	return result
 }
 
 // Handle the RENAMENX command.
 // It renames a key in the database only if the new key does not exist.
-// RENAME key newkey
+// RENAMENX key newkey
 func execRenameNX(db *DB, args [][]byte) resp.Reply {
-	src := string(args[0])
-	dst := string(args[1])
-	entity, ok := db.GetEntity(src)
-	if !ok {
-		return reply.MakeStandardErrorReply("ERR no such key")
-	}
-	if _, ok := db.GetEntity(dst); ok {
-		return reply.MakeIntReply(0)
+	var result resp.Reply
+	src, dst := string(args[0]), string(args[1])
+	db.WithKeysLock([]string{src, dst}, func() {
+		if _, ok := db.GetEntity(src); !ok {
+			result = reply.MakeStandardErrorReply("ERR no such key")
+			return
+		}
+		if _, ok := db.GetEntity(dst); ok {
+			result = reply.MakeIntReply(0)
+			return
+		}
+		db.renameKey(src, dst)
+		db.addAof(utils.ToCmdLineWithName("RENAMENX", args...))
+		result = reply.MakeIntReply(1)
+	})
+	return result
+}
+
+// renameKey moves the value of src with its expiration time to dst, src must exist
+// The caller must hold the locks of both keys
+func (db *DB) renameKey(src, dst string) {
+	if src == dst {
+		return
 	}
+	entity, _ := db.GetEntity(src)
+	expireAt, volatile := db.ExpireTime(src)
+	db.removeKey(src, eventRenamed)
+	db.removeKey(dst, eventRenamed)
 	db.PutEntity(dst, entity)
-	db.Remove(src)
-	db.addAof(utils.ToCmdLineWithName("RENAMENX", args...))
-	return reply.MakeIntReply(1)
+	if volatile {
+		db.Expire(dst, expireAt)
+	}
 }
 
 // Handle the KEYS command.
 // It returns all keys in the database that match the specified pattern.
-func execKeys(db *DB, args [][]byte) resp.Reply {
+// With keys-max-scan, KEYS iterates at most that many keys, those SCAN would return first, so
+// that a KEYS * by mistake does not walk a large dataset. Over the budget it replies with an
+// error, or if keys-over-budget is truncate, like SCAN: the cursor where it stopped and the keys
+// found so far, so that the client can tell the result is incomplete and go on with SCAN.
+// It stops with an error once the command timeout passed.
+func execKeys(db *DB, dl *deadline, args [][]byte) resp.Reply {
 	pattern := wildcard.CompilePattern(string(args[0]))
-	result := make([][]byte, 0) // Store all matching keys
-	db.data.ForEach(func(key string, val interface{}) bool {
-		if pattern.IsMatch(key) {
-			result = append(result, []byte(key))
+	budget := keysBudget()
+	if budget == 0 {
+		result := make([][]byte, 0) // Store all matching keys
+		db.data.ForEach(func(key string, val interface{}) bool {
+			if pattern.IsMatch(key) && !db.isExpired(key) {
+				result = append(result, []byte(key))
+			}
+			return !dl.visit()
+		})
+		if dl.exceeded() {
+			return errDeadlineReply
+		}
+		return reply.MakeMultiBulkReply(result)
+	}
+
+	// the keys iterated are those of a SCAN from 0 with a COUNT of the budget, matching or not
+	var scanned [][]byte
+	var next uint64
+	var rest bool
+	for count := budget; ; count *= 2 {
+		batch := &scanBatch{count: count}
+		db.data.ScanBuckets(0, func(keys []string, end uint64) bool {
+			for _, key := range keys {
+				batch.add(key)
+				if dl.visit() {
+					return false
+				}
+			}
+			if len(batch.elements) >= batch.count {
+				batch.end = end
+				return false
+			}
+			return true
+		})
+		if dl.exceeded() {
+			return errDeadlineReply
+		}
+		var ok bool
+		if scanned, next, ok = batch.result(); ok {
+			rest = batch.rest
+			break
+		}
+	}
+	truncated := next != 0
+	if truncated && !rest {
+		// the batch may hold all the keys, the buckets from its end on are then empty
+		truncated = false
+		db.data.ScanBuckets(next, func(keys []string, _ uint64) bool {
+			truncated = len(keys) > 0
+			return !truncated
+		})
+	}
+	result := make([][]byte, 0)
+	for _, key := range scanned {
+		if pattern.IsMatch(string(key)) && !db.isExpired(string(key)) {
+			result = append(result, key)
+		}
+	}
+	if !truncated {
+		return reply.MakeMultiBulkReply(result)
+	}
+	stats.Server.KeysTruncated()
+	logger.Warn("KEYS " + string(args[0]) + " on db " + strconv.Itoa(db.index) + " stopped after " +
+		strconv.Itoa(len(scanned)) + " keys of " + strconv.Itoa(db.data.Len()) + ", use SCAN instead")
+	if config.Read(func(p *config.ServerProperties) string { return p.KeysOverBudget }) == "truncate" {
+		return makeScanReply(next, result)
+	}
+	return reply.MakeStandardErrorReply("ERR KEYS would iterate more than " + strconv.Itoa(budget) +
+		" keys (keys-max-scan), use SCAN instead")
+}
+
+// keysBudget returns the max number of keys iterated by KEYS, 0 means no limit
+func keysBudget() int {
+	return max(config.Read(func(p *config.ServerProperties) int { return p.KeysMaxScan }), 0)
+}
+
+// Conditions of EXPIRE and its variants
+const (
+	expireNX = 1 << iota // only if the key has no expiration time
+	expireXX             // only if the key has an expiration time
+	expireGT             // only if the new expiration time is greater than the current one
+	expireLT             // only if the new expiration time is less than the current one
+)
+
+// parseExpireConditions parses the NX, XX, GT and LT options
+func parseExpireConditions(args [][]byte) (int, resp.Reply) {
+	conditions := 0
+	for _, arg := range args {
+		switch strings.ToUpper(string(arg)) {
+		case "NX":
+			conditions |= expireNX
+		case "XX":
+			conditions |= expireXX
+		case "GT":
+			conditions |= expireGT
+		case "LT":
+			conditions |= expireLT
+		default:
+			return 0, reply.MakeStandardErrorReply("ERR Unsupported option " + string(arg))
+		}
+	}
+	if conditions&expireNX != 0 && conditions != expireNX {
+		return 0, reply.MakeStandardErrorReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if conditions&expireGT != 0 && conditions&expireLT != 0 {
+		return 0, reply.MakeStandardErrorReply("ERR GT and LT options at the same time are not compatible")
+	}
+	return conditions, nil
+}
+
+// expireAllowed checks the conditions against the current expiration time of the key
+// A key without expiration time is considered to have an infinite TTL by GT and LT
+func expireAllowed(conditions int, current time.Time, volatile bool, expireAt time.Time) bool {
+	switch {
+	case conditions&expireNX != 0 && volatile:
+		return false
+	case conditions&expireXX != 0 && !volatile:
+		return false
+	case conditions&expireGT != 0 && (!volatile || !expireAt.After(current)):
+		return false
+	case conditions&expireLT != 0 && volatile && !expireAt.Before(current):
+		return false
+	}
+	return true
+}
+
+// execExpireGeneric implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT
+// unit is the unit of the time argument, absolute tells whether it is a unix time or a TTL
+// The AOF always receives PEXPIREAT, or DEL when the expiration time is already past
+func execExpireGeneric(db *DB, cmdName string, args [][]byte, unit time.Duration, absolute bool) resp.Reply {
+	key := string(args[0])
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	conditions, errReply := parseExpireConditions(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	now := time.Now()
+	expireAt, ok := toExpireAt(n, unit, absolute, now)
+	if !ok {
+		return reply.MakeStandardErrorReply("ERR invalid expire time in '" + cmdName + "' command")
+	}
+
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		if _, ok := db.GetEntity(key); !ok {
+			result = reply.MakeIntReply(0)
+			return
+		}
+		current, volatile := db.ExpireTime(key)
+		if !expireAllowed(conditions, current, volatile, expireAt) {
+			result = reply.MakeIntReply(0)
+			return
 		}
-		return true
+		if !expireAt.After(now) {
+			db.Remove(key)
+			db.addAof(utils.ToCmdLine("DEL", key))
+		} else {
+			db.Expire(key, expireAt)
+			db.addAof(makeExpireCmd(key, expireAt))
+		}
+		result = reply.MakeIntReply(1)
 	})
-	return reply.MakeMultiBulkReply(result)
+	return result
+}
+
+// Handle the EXPIRE command.
+// EXPIRE key seconds [NX | XX | GT | LT]
+func execExpire(db *DB, args [][]byte) resp.Reply {
+	return execExpireGeneric(db, "expire", args, time.Second, false)
+}
+
+// Handle the PEXPIRE command.
+// PEXPIRE key milliseconds [NX | XX | GT | LT]
+func execPExpire(db *DB, args [][]byte) resp.Reply {
+	return execExpireGeneric(db, "pexpire", args, time.Millisecond, false)
+}
+
+// Handle the EXPIREAT command.
+// EXPIREAT key unix-time-seconds [NX | XX | GT | LT]
+func execExpireAt(db *DB, args [][]byte) resp.Reply {
+	return execExpireGeneric(db, "expireat", args, time.Second, true)
+}
+
+// Handle the PEXPIREAT command.
+// PEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT]
+func execPExpireAt(db *DB, args [][]byte) resp.Reply {
+	return execExpireGeneric(db, "pexpireat", args, time.Millisecond, true)
+}
+
+// execTTLGeneric implements TTL, PTTL, EXPIRETIME and PEXPIRETIME
+// It returns -2 if the key does not exist and -1 if it has no expiration time
+func execTTLGeneric(db *DB, args [][]byte, unit time.Duration, absolute bool) resp.Reply {
+	key := string(args[0])
+	if _, ok := db.GetEntity(key); !ok {
+		return reply.MakeIntReply(-2)
+	}
+	expireAt, ok := db.ExpireTime(key)
+	if !ok {
+		return reply.MakeIntReply(-1)
+	}
+	if absolute {
+		return reply.MakeIntReply(expireAt.UnixMilli() / int64(unit/time.Millisecond))
+	}
+	ttl := time.Until(expireAt)
+	if ttl < 0 {
+		ttl = 0
+	}
+	// round to the nearest unit, as Redis does
+	return reply.MakeIntReply(int64((ttl + unit/2) / unit))
+}
+
+// Handle the TTL command.
+// TTL key
+func execTTL(db *DB, args [][]byte) resp.Reply {
+	return execTTLGeneric(db, args, time.Second, false)
+}
+
+// Handle the PTTL command.
+// PTTL key
+func execPTTL(db *DB, args [][]byte) resp.Reply {
+	return execTTLGeneric(db, args, time.Millisecond, false)
+}
+
+// Handle the EXPIRETIME command.
+// EXPIRETIME key
+func execExpireTime(db *DB, args [][]byte) resp.Reply {
+	return execTTLGeneric(db, args, time.Second, true)
+}
+
+// Handle the PEXPIRETIME command.
+// PEXPIRETIME key
+func execPExpireTime(db *DB, args [][]byte) resp.Reply {
+	return execTTLGeneric(db, args, time.Millisecond, true)
+}
+
+// Handle the PERSIST command.
+// It removes the expiration time of the key.
+// PERSIST key
+func execPersist(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	result := 0
+	db.WithKeyLock(key, func() {
+		if _, ok := db.GetEntity(key); ok {
+			result = db.Persist(key)
+		}
+	})
+	if result > 0 {
+		db.addAof(utils.ToCmdLineWithName("PERSIST", args...))
+	}
+	return reply.MakeIntReply(int64(result))
+}
+
+// execTouch implements the TOUCH command, it records an access to the keys without reading them
+// and replies the number of existing keys
+// TOUCH key [key ...]
+func execTouch(db *DB, args [][]byte) resp.Reply {
+	result := int64(0)
+	for _, arg := range args {
+		if _, ok := db.GetEntity(string(arg)); ok {
+			result++
+		}
+	}
+	return reply.MakeIntReply(result)
+}
+
+// execObject implements the OBJECT command, inspecting a key does not count as an access
+// OBJECT IDLETIME key
+func execObject(db *DB, args [][]byte) resp.Reply {
+	switch strings.ToUpper(string(args[0])) {
+	case "IDLETIME":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("object|idletime")
+		}
+		entity, ok := db.peekEntity(string(args[1]))
+		if !ok {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeIntReply(int64(entity.IdleTime() / time.Second))
+	case "ENCODING":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("object|encoding")
+		}
+		entity, ok := db.peekEntity(string(args[1]))
+		if !ok {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeBulkReply([]byte(objectEncoding(entity)))
+	}
+	return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[0]) + "'. Try OBJECT IDLETIME or OBJECT ENCODING.")
 }
 
 func init() {
@@ -117,5 +431,16 @@ func init() {
 	RegisterCommand("TYPE", execType, 2)
 	RegisterCommand("RENAME", execRename, 3)
 	RegisterCommand("RENAMENX", execRenameNX, 3)
-	RegisterCommand("KEYS", execKeys, 2)
+	registerCancellableCommand("KEYS", execKeys, 2)
+	RegisterCommand("EXPIRE", execExpire, -3)
+	RegisterCommand("PEXPIRE", execPExpire, -3)
+	RegisterCommand("EXPIREAT", execExpireAt, -3)
+	RegisterCommand("PEXPIREAT", execPExpireAt, -3)
+	RegisterCommand("TTL", execTTL, 2)
+	RegisterCommand("PTTL", execPTTL, 2)
+	RegisterCommand("EXPIRETIME", execExpireTime, 2)
+	RegisterCommand("PEXPIRETIME", execPExpireTime, 2)
+	RegisterCommand("PERSIST", execPersist, 2)
+	RegisterCommand("TOUCH", execTouch, -2)
+	RegisterCommand("OBJECT", execObject, -2)
 }
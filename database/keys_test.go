@@ -0,0 +1,310 @@
+package database
+
+import (
+	"bytes"
+	"redigo/config"
+	"redigo/interface/resp"
+	"redigo/lib/stats"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRenameMovesTTL tests that RENAME moves the expiration time with the value and discards
+// the expiration time of the previous value of the new key
+func TestRenameMovesTTL(t *testing.T) {
+	db := MakeDB()
+	exec(db, "SET", "src", "value", "EX", "100")
+	exec(db, "SET", "dst", "old", "EX", "5")
+
+	assertReply(t, exec(db, "RENAME", "src", "dst"), "+OK\r\n")
+	assertReply(t, exec(db, "EXISTS", "src"), ":0\r\n")
+	assertReply(t, exec(db, "GET", "dst"), "$5\r\nvalue\r\n")
+	assertReply(t, exec(db, "TTL", "dst"), ":100\r\n")
+
+	exec(db, "SET", "persistent", "value")
+	exec(db, "SET", "volatile", "old", "EX", "100")
+	assertReply(t, exec(db, "RENAME", "persistent", "volatile"), "+OK\r\n")
+	assertReply(t, exec(db, "TTL", "volatile"), ":-1\r\n")
+}
+
+// TestRenameSameKey tests renaming a key to itself
+func TestRenameSameKey(t *testing.T) {
+	db := MakeDB()
+	exec(db, "SET", "key", "value", "EX", "100")
+
+	assertReply(t, exec(db, "RENAME", "key", "key"), "+OK\r\n")
+	assertReply(t, exec(db, "GET", "key"), "$5\r\nvalue\r\n")
+	assertReply(t, exec(db, "TTL", "key"), ":100\r\n")
+	assertReply(t, exec(db, "RENAMENX", "key", "key"), ":0\r\n")
+}
+
+// TestRenameNX tests that RENAMENX keeps an existing new key and moves the TTL otherwise
+func TestRenameNX(t *testing.T) {
+	db := MakeDB()
+	exec(db, "HSET", "src", "field", "value")
+	exec(db, "EXPIRE", "src", "100")
+	exec(db, "SET", "taken", "value")
+
+	assertReply(t, exec(db, "RENAMENX", "src", "taken"), ":0\r\n")
+	assertReply(t, exec(db, "GET", "taken"), "$5\r\nvalue\r\n")
+
+	assertReply(t, exec(db, "RENAMENX", "src", "free"), ":1\r\n")
+	assertReply(t, exec(db, "HGET", "free", "field"), "$5\r\nvalue\r\n")
+	assertReply(t, exec(db, "TTL", "free"), ":100\r\n")
+	assertReply(t, exec(db, "RENAMENX", "missing", "other"), "-ERR no such key\r\n")
+}
+
+// TestTouchAndIdleTime tests that TOUCH counts the existing keys and resets their idle time,
+// and that OBJECT IDLETIME does not count as an access
+func TestTouchAndIdleTime(t *testing.T) {
+	db := MakeDB()
+	exec(db, "SET", "touched", "value")
+	exec(db, "SET", "idle", "value")
+	assertReply(t, exec(db, "OBJECT", "IDLETIME", "idle"), ":0\r\n")
+	assertReply(t, exec(db, "OBJECT", "IDLETIME", "missing"), "$-1\r\n")
+
+	time.Sleep(1100 * time.Millisecond)
+	assertReply(t, exec(db, "TOUCH", "touched", "missing", "touched"), ":2\r\n")
+	assertReply(t, exec(db, "OBJECT", "IDLETIME", "touched"), ":0\r\n")
+	// the idle time has a resolution of a second, 1.1s may span 2 seconds of the clock
+	for i := 0; i < 2; i++ {
+		if idle := string(exec(db, "OBJECT", "IDLETIME", "idle").ToBytes()); idle != ":1\r\n" && idle != ":2\r\n" {
+			t.Errorf("Expected an idle time of 1 or 2 seconds, got %q", idle)
+		}
+	}
+	assertReply(t, exec(db, "OBJECT", "FREQ", "idle"), "-ERR unknown subcommand 'FREQ'. Try OBJECT IDLETIME or OBJECT ENCODING.\r\n")
+}
+
+// TestObjectEncoding tests the encodings of each type, and the transitions of a set from intset
+// to listpack to hashtable
+func TestObjectEncoding(t *testing.T) {
+	db := MakeDB()
+	exec(db, "SET", "int", "12345")
+	exec(db, "SET", "embstr", strings.Repeat("a", 44))
+	exec(db, "SET", "raw", strings.Repeat("a", 45))
+	exec(db, "RPUSH", "list", "a")
+	exec(db, "HSET", "hash", "f", "v")
+	exec(db, "ZADD", "zset", "1", "m")
+	exec(db, "XADD", "stream", "*", "f", "v")
+	for key, encoding := range map[string]string{
+		"int": "int", "embstr": "embstr", "raw": "raw", "list": "listpack",
+		"hash": "listpack", "zset": "listpack", "stream": "stream",
+	} {
+		assertReply(t, exec(db, "OBJECT", "ENCODING", key), "$"+strconv.Itoa(len(encoding))+"\r\n"+encoding+"\r\n")
+	}
+	assertReply(t, exec(db, "OBJECT", "ENCODING", "missing"), "$-1\r\n")
+	assertReply(t, exec(db, "OBJECT", "ENCODING"), "-ERR wrong number of arguments for 'object|encoding' command\r\n")
+
+	exec(db, "SADD", "set", "1", "2")
+	assertReply(t, exec(db, "OBJECT", "ENCODING", "set"), "$6\r\nintset\r\n")
+	exec(db, "SADD", "set", "a")
+	assertReply(t, exec(db, "OBJECT", "ENCODING", "set"), "$8\r\nlistpack\r\n")
+	assertReply(t, exec(db, "SETTYPE", "set"), "+listpack\r\n")
+	for i := 0; i < 125; i++ {
+		exec(db, "SADD", "set", "m"+strconv.Itoa(i))
+	}
+	assertReply(t, exec(db, "OBJECT", "ENCODING", "set"), "$8\r\nlistpack\r\n")
+	exec(db, "SADD", "set", "b")
+	assertReply(t, exec(db, "OBJECT", "ENCODING", "set"), "$9\r\nhashtable\r\n")
+	assertReply(t, exec(db, "SCARD", "set"), ":129\r\n")
+
+	exec(db, "SADD", "long", "a", strings.Repeat("a", 65))
+	assertReply(t, exec(db, "OBJECT", "ENCODING", "long"), "$9\r\nhashtable\r\n")
+}
+
+// TestDumpRestore tests that every type survives DUMP and RESTORE, with the TTL and the options
+// of RESTORE, and that the AOF receives an absolute TTL
+func TestDumpRestore(t *testing.T) {
+	db := MakeDB()
+	exec(db, "SET", "str", "value")
+	exec(db, "RPUSH", "list", "a", "b", "c")
+	exec(db, "HSET", "hash", "f", "v")
+	exec(db, "SADD", "set", "1", "2", "3")
+	exec(db, "ZADD", "zset", "1.5", "a", "-2", "b")
+	exec(db, "XADD", "stream", "1-1", "f", "v")
+	for _, key := range []string{"str", "list", "hash", "set", "zset", "stream"} {
+		payload := exec(db, "DUMP", key).(*reply.BulkReply).Arg
+		assertReply(t, exec(db, "RESTORE", key+"-copy", "0", string(payload)), "+OK\r\n")
+		if copied := exec(db, "DUMP", key+"-copy").(*reply.BulkReply).Arg; !bytes.Equal(copied, payload) {
+			t.Errorf("Expected the copy of %s to dump the same payload", key)
+		}
+	}
+	assertReply(t, exec(db, "ZRANGE", "zset-copy", "0", "-1", "WITHSCORES"), "*4\r\n$1\r\nb\r\n$2\r\n-2\r\n$1\r\na\r\n$3\r\n1.5\r\n")
+	assertReply(t, exec(db, "DUMP", "missing"), "$-1\r\n")
+
+	payload := string(exec(db, "DUMP", "str").(*reply.BulkReply).Arg)
+	assertReply(t, exec(db, "RESTORE", "str", "0", payload), "-BUSYKEY Target key name already exists.\r\n")
+	var aofLines []CmdLine
+	db.addAof = func(line CmdLine) {
+		aofLines = append(aofLines, line)
+	}
+	assertReply(t, exec(db, "RESTORE", "str", "100000", payload, "REPLACE"), "+OK\r\n")
+	if ttl := exec(db, "PTTL", "str").(*reply.IntReply).Code; ttl <= 99000 || ttl > 100000 {
+		t.Errorf("Expected the TTL of RESTORE, got %d", ttl)
+	}
+	if len(aofLines) != 1 || string(aofLines[0][0]) != "RESTORE" || string(aofLines[0][5]) != "ABSTTL" {
+		t.Fatalf("Expected RESTORE with ABSTTL in the AOF, got %q", aofLines)
+	}
+	if at, _ := strconv.ParseInt(string(aofLines[0][2]), 10, 64); at < time.Now().UnixMilli()+99000 {
+		t.Errorf("Expected the absolute expiration time in the AOF, got %s", aofLines[0][2])
+	}
+	assertReply(t, exec(db, "RESTORE", "str", "1", payload, "REPLACE", "ABSTTL"), "+OK\r\n")
+	assertReply(t, exec(db, "EXISTS", "str"), ":0\r\n")
+
+	corrupted := []byte(payload)
+	corrupted[2] ^= 1
+	assertReply(t, exec(db, "RESTORE", "bad", "0", string(corrupted)), "-ERR DUMP payload version or checksum are wrong\r\n")
+	assertReply(t, exec(db, "RESTORE", "bad", "-1", payload), "-ERR Invalid TTL value, must be >= 0\r\n")
+	assertReply(t, exec(db, "RESTORE", "bad", "0", payload, "IDLETIME"), "-ERR syntax error\r\n")
+}
+
+// TestKeysBudget tests that KEYS stops after keys-max-scan keys with an error, or when
+// keys-over-budget is truncate, with the keys found so far and the cursor of SCAN to go on
+func TestKeysBudget(t *testing.T) {
+	defer func(maxScan int, overBudget string) {
+		config.Properties.KeysMaxScan, config.Properties.KeysOverBudget = maxScan, overBudget
+	}(config.Properties.KeysMaxScan, config.Properties.KeysOverBudget)
+	db := MakeDB()
+	for i := 0; i < 10; i++ {
+		exec(db, "SET", "key"+strconv.Itoa(i), "value")
+	}
+
+	config.Properties.KeysMaxScan = 10
+	if result, ok := exec(db, "KEYS", "*").(*reply.MultiBulkReply); !ok || len(result.Args) != 10 {
+		t.Fatalf("expected the 10 keys within the budget, got %q", exec(db, "KEYS", "*").ToBytes())
+	}
+
+	config.Properties.KeysMaxScan = 4
+	truncated := stats.Server.TruncatedKeys()
+	assertReply(t, exec(db, "KEYS", "*"), "-ERR KEYS would iterate more than 4 keys (keys-max-scan), use SCAN instead\r\n")
+	if got := stats.Server.TruncatedKeys(); got != truncated+1 {
+		t.Errorf("expected keys_truncated to count the KEYS, got %d", got-truncated)
+	}
+
+	config.Properties.KeysOverBudget = "truncate"
+	result, ok := exec(db, "KEYS", "*").(*reply.MultiRawReply)
+	if !ok || len(result.Replies) != 2 {
+		t.Fatalf("expected the reply of SCAN, got %q", exec(db, "KEYS", "*").ToBytes())
+	}
+	found := make(map[string]bool)
+	for _, key := range result.Replies[1].(*reply.MultiBulkReply).Args {
+		found[string(key)] = true
+	}
+	if len(found) != 4 {
+		t.Errorf("expected the 4 keys iterated, got %d keys", len(found))
+	}
+	// SCAN goes on from the cursor with the keys not iterated
+	cursor := string(result.Replies[0].(*reply.BulkReply).Arg)
+	for cursor != "0" {
+		page := exec(db, "SCAN", cursor, "COUNT", "100").(*reply.MultiRawReply)
+		for _, key := range page.Replies[1].(*reply.MultiBulkReply).Args {
+			found[string(key)] = true
+		}
+		cursor = string(page.Replies[0].(*reply.BulkReply).Arg)
+	}
+	if len(found) != 10 {
+		t.Errorf("expected KEYS and SCAN from its cursor to return the 10 keys, got %d", len(found))
+	}
+	// the keys not matching the pattern are iterated too
+	r := exec(db, "KEYS", "none*")
+	if result, ok := r.(*reply.MultiRawReply); !ok || len(result.Replies[1].(*reply.MultiBulkReply).Args) != 0 {
+		t.Errorf("expected KEYS to stop with no key, got %q", r.ToBytes())
+	}
+}
+
+// TestElementsReplies tests that the large collections are written out from the value when the
+// reply is written, and that the value is read again then
+func TestElementsReplies(t *testing.T) {
+	db := MakeDB()
+	for i := 0; i < streamMinElements; i++ {
+		value := strconv.Itoa(i)
+		exec(db, "SADD", "set", value)
+		exec(db, "RPUSH", "list", value)
+		exec(db, "HSET", "hash", "f"+value, value)
+	}
+	exec(db, "SADD", "small", "a")
+	if _, ok := exec(db, "SMEMBERS", "small").(*reply.ElementsReply); ok {
+		t.Error("Expected the small set to be copied to the reply")
+	}
+
+	members := exec(db, "SMEMBERS", "set")
+	values := exec(db, "LRANGE", "list", "0", "-1")
+	pairs := exec(db, "HGETALL", "hash")
+	tail := exec(db, "LRANGE", "list", "-1024", "-1")
+	for _, r := range []resp.Reply{members, values, pairs, tail} {
+		if _, ok := r.(*reply.ElementsReply); !ok {
+			t.Fatalf("Expected an ElementsReply, got %T", r)
+		}
+	}
+	exec(db, "SADD", "set", "added")
+	exec(db, "RPUSH", "list", "pushed")
+	exec(db, "DEL", "hash")
+
+	if got := string(members.ToBytes()); !strings.HasPrefix(got, "~1025\r\n") || !strings.Contains(got, "$5\r\nadded\r\n") {
+		t.Errorf("Expected the members at the time of the write, got %q", got[:10])
+	}
+	if got := string(values.ToBytes()); !strings.HasPrefix(got, "*1025\r\n$1\r\n0\r\n") || !strings.HasSuffix(got, "$6\r\npushed\r\n") {
+		t.Errorf("Expected the elements at the time of the write, got %q", got[:10])
+	}
+	if got := string(tail.ToBytes()); !strings.HasPrefix(got, "*1024\r\n$1\r\n1\r\n") {
+		t.Errorf("Expected the range to be taken at the time of the write, got %q", got[:10])
+	}
+	assertReply(t, pairs, "%0\r\n")
+
+	exec(db, "DEL", "list")
+	exec(db, "SET", "list", "value")
+	assertReply(t, values, string(reply.MakeWrongTypeErrReply().ToBytes()))
+}
+
+// TestCommandTimeout tests that KEYS and the SCAN commands stop once their deadline passed
+// instead of walking the whole keyspace or value, and that the other commands run to completion
+func TestCommandTimeout(t *testing.T) {
+	defer func(timeout, maxScan int) {
+		config.Properties.CommandTimeout, config.Properties.KeysMaxScan = timeout, maxScan
+	}(config.Properties.CommandTimeout, config.Properties.KeysMaxScan)
+	db := MakeDB()
+	for i := 0; i < 2000; i++ {
+		exec(db, "SET", "key"+strconv.Itoa(i), "value")
+		exec(db, "HSET", "hash", "field"+strconv.Itoa(i), "value")
+		exec(db, "SADD", "set", "member"+strconv.Itoa(i))
+		exec(db, "ZADD", "zset", strconv.Itoa(i), "member"+strconv.Itoa(i))
+	}
+	cases := []struct {
+		maxScan int
+		args    []string
+	}{
+		{0, []string{"KEYS", "*"}},
+		{5000, []string{"KEYS", "*"}},
+		{0, []string{"SCAN", "0", "COUNT", "5000"}},
+		{0, []string{"HSCAN", "hash", "0", "MATCH", "none*"}},
+		{0, []string{"SSCAN", "set", "0"}},
+		{0, []string{"ZSCAN", "zset", "0"}},
+	}
+	for _, c := range cases {
+		config.Properties.KeysMaxScan = c.maxScan
+		args := utils.ToCmdLine(c.args...)
+		dl := &deadline{at: time.Now().Add(-time.Second)}
+		if r := cmdTable[commandName(args[0])].cancellableExec(db, dl, args[1:]); r != errDeadlineReply {
+			t.Errorf("Expected %s to stop, got %q", strings.Join(c.args, " "), r.ToBytes())
+		}
+		if dl.visits != deadlineCheckInterval {
+			t.Errorf("Expected %s to stop at the first check, visited %d", strings.Join(c.args, " "), dl.visits)
+		}
+	}
+
+	config.Properties.KeysMaxScan = 0
+	keys := cmdTable["keys"]
+	assertReply(t, db.execWithTimeout("keys", keys, utils.ToCmdLine("*"), time.Nanosecond), "-ERR command keys timed out after 1ns\r\n")
+	if r, ok := db.execWithTimeout("keys", keys, utils.ToCmdLine("*"), time.Minute).(*reply.MultiBulkReply); !ok || len(r.Args) != 2003 {
+		t.Errorf("Expected the keys within the timeout, got %v", r)
+	}
+	config.Properties.CommandTimeout = 60000
+	assertReply(t, exec(db, "HSET", "hash", "field", "value"), ":1\r\n")
+	if r, ok := exec(db, "KEYS", "key1*").(*reply.MultiBulkReply); !ok || len(r.Args) != 1111 {
+		t.Errorf("Expected KEYS within the timeout to reply, got %v", r)
+	}
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"redigo/interface/database"
+	"redigo/interface/resp"
+	consistenthash "redigo/lib/consistent_hash"
+	"redigo/lib/slot"
+	"redigo/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keyStatsSamples is the number of elements of a collection sampled to estimate its size, it
+// keeps KEYSTATS proportional to the number of keys rather than to the number of elements
+const keyStatsSamples = 64
+
+// keyCounter accumulates the keys of a slot or of a node
+type keyCounter struct {
+	keys  int64
+	bytes int64
+}
+
+// forEachKey calls fn with the estimated size of every key of every DB
+// Keys are locked one at a time, so other clients keep being served during the scan
+func (d *StandaloneDatabase) forEachKey(fn func(key string, bytes int)) {
+	d.forEachDB(func(db *DB) {
+		db.data.ForEach(func(key string, _ interface{}) bool {
+			db.WithKeyRLock(key, func() {
+				// read the dict directly so that the scan is not counted as accesses by HOTKEYS
+				raw, ok := db.data.Get(key)
+				if !ok {
+					return
+				}
+				_, bytes := estimateSize(key, raw.(*database.DataEntity), keyStatsSamples)
+				fn(key, bytes)
+			})
+			return true
+		})
+	})
+}
+
+// execKeyStats implements the KEYSTATS command, which reports where the keys of all the DBs are
+// to plan the capacity of a cluster
+// KEYSTATS SLOTS [SLOTSRANGE start end] replies [slot, keys, bytes] for each hash slot holding
+// keys, or for every slot of the range
+// KEYSTATS RING hash seed node [node ...] replies [node, keys, bytes] for each node of a
+// consistent hash ring with the hash function and the seed of the cluster-hash options, the nodes
+// the keys would be placed on
+func execKeyStats(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("keystats")
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "SLOTS":
+		return execKeyStatsSlots(d, args[1:])
+	case "RING":
+		return execKeyStatsRing(d, args[1:])
+	}
+	return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[0]) + "'. Try KEYSTATS SLOTS or KEYSTATS RING.")
+}
+
+// execKeyStatsSlots counts the keys and their bytes by hash slot
+func execKeyStatsSlots(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	first, last, all := 0, slot.SlotCount-1, false
+	if len(args) == 3 && strings.ToUpper(string(args[0])) == "SLOTSRANGE" {
+		var err1, err2 error
+		first, err1 = strconv.Atoi(string(args[1]))
+		last, err2 = strconv.Atoi(string(args[2]))
+		if err1 != nil || err2 != nil || first < 0 || last >= slot.SlotCount || first > last {
+			return reply.MakeStandardErrorReply("ERR invalid slot range, expected 0 <= start <= end < " + strconv.Itoa(slot.SlotCount))
+		}
+		all = true
+	} else if len(args) != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+
+	slots := make([]keyCounter, slot.SlotCount)
+	d.forEachKey(func(key string, bytes int) {
+		s := &slots[slot.KeySlot(key)]
+		s.keys++
+		s.bytes += int64(bytes)
+	})
+
+	result := make([]resp.Reply, 0)
+	for i := first; i <= last; i++ {
+		if slots[i].keys == 0 && !all {
+			continue
+		}
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeIntReply(int64(i)),
+			reply.MakeIntReply(slots[i].keys),
+			reply.MakeIntReply(slots[i].bytes),
+		}))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// execKeyStatsRing counts the keys and their bytes by the node of the ring they are placed on
+func execKeyStatsRing(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return reply.MakeArgNumErrReply("keystats")
+	}
+	seed, err := strconv.ParseUint(string(args[1]), 10, 32)
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	hashFunc, err := consistenthash.NewHashFunc(string(args[0]), uint32(seed))
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR " + err.Error())
+	}
+	ring := consistenthash.NewNodeMap(hashFunc)
+	nodes := make([]string, len(args)-2)
+	for i, node := range args[2:] {
+		nodes[i] = string(node)
+	}
+	ring.AddNodes(nodes...)
+
+	counters := make(map[string]*keyCounter, len(nodes))
+	for _, node := range nodes {
+		counters[node] = &keyCounter{}
+	}
+	d.forEachKey(func(key string, bytes int) {
+		c := counters[ring.PickNode(key)]
+		c.keys++
+		c.bytes += int64(bytes)
+	})
+
+	sort.Strings(nodes)
+	result := make([]resp.Reply, 0, len(nodes))
+	for i, node := range nodes {
+		if i > 0 && node == nodes[i-1] {
+			continue
+		}
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(node)),
+			reply.MakeIntReply(counters[node].keys),
+			reply.MakeIntReply(counters[node].bytes),
+		}))
+	}
+	return reply.MakeMultiRawReply(result)
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"redigo/interface/resp"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// lcsMaxTableBytes bounds the memory of the dynamic programming table of LCS, like the
+// proto-max-bulk-len limit of Redis
+const lcsMaxTableBytes = 512 * 1024 * 1024
+
+// lcsOptions are the options of LCS
+type lcsOptions struct {
+	length       bool // LEN: only return the length
+	idx          bool // IDX: return the match ranges
+	minMatchLen  int  // MINMATCHLEN: ignore the ranges shorter than this
+	withMatchLen bool // WITHMATCHLEN: add the length to each range
+}
+
+func parseLCSOptions(args [][]byte) (lcsOptions, resp.Reply) {
+	var opts lcsOptions
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "LEN":
+			opts.length = true
+		case "IDX":
+			opts.idx = true
+		case "WITHMATCHLEN":
+			opts.withMatchLen = true
+		case "MINMATCHLEN":
+			if i+1 >= len(args) {
+				return opts, reply.MakeSyntaxErrReply()
+			}
+			i++
+			n, err := strconv.Atoi(string(args[i]))
+			if err != nil {
+				return opts, reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+			}
+			if n > 0 {
+				opts.minMatchLen = n
+			}
+		default:
+			return opts, reply.MakeSyntaxErrReply()
+		}
+	}
+	if opts.length && opts.idx {
+		return opts, reply.MakeStandardErrorReply("ERR If you want both the length and indexes, please just use IDX.")
+	}
+	return opts, nil
+}
+
+// lcsTable computes the lengths of the longest common subsequences of the prefixes of a and b,
+// the length for a[:i] and b[:j] is at table[i*(len(b)+1)+j]
+func lcsTable(a, b []byte) []uint32 {
+	width := len(b) + 1
+	table := make([]uint32, (len(a)+1)*width)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				table[i*width+j] = table[(i-1)*width+j-1] + 1
+			} else if up, left := table[(i-1)*width+j], table[i*width+j-1]; up > left {
+				table[i*width+j] = up
+			} else {
+				table[i*width+j] = left
+			}
+		}
+	}
+	return table
+}
+
+// lcsRange is a range of contiguous matching bytes, the bounds are inclusive
+type lcsRange struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+// lcsBacktrack walks the table back from the end of both strings, returning the subsequence
+// and the ranges of contiguous matches, the last ones first as reported by Redis
+func lcsBacktrack(a, b []byte, table []uint32) ([]byte, []lcsRange) {
+	width := len(b) + 1
+	length := int(table[len(a)*width+len(b)])
+	result := make([]byte, length)
+	var ranges []lcsRange
+	var current lcsRange
+	inRange := false
+	i, j := len(a), len(b)
+	for i > 0 && j > 0 {
+		emit := false
+		if a[i-1] == b[j-1] {
+			result[length-1] = a[i-1]
+			length--
+			if !inRange {
+				current = lcsRange{aStart: i - 1, aEnd: i - 1, bStart: j - 1, bEnd: j - 1}
+				inRange = true
+			} else if current.aStart == i && current.bStart == j {
+				// the match is contiguous with the current range, extend it backward
+				current.aStart--
+				current.bStart--
+			} else {
+				emit = true
+			}
+			// the range cannot be extended past the first byte of either string
+			if current.aStart == 0 || current.bStart == 0 {
+				emit = true
+			}
+			i--
+			j--
+		} else {
+			if table[(i-1)*width+j] > table[i*width+j-1] {
+				i--
+			} else {
+				j--
+			}
+			emit = inRange
+		}
+		if emit {
+			ranges = append(ranges, current)
+			inRange = false
+		}
+	}
+	return result, ranges
+}
+
+// execLCS implements the LCS command
+// LCS key1 key2 [LEN] [IDX] [MINMATCHLEN len] [WITHMATCHLEN]
+// Missing keys are considered empty strings
+func execLCS(db *DB, args [][]byte) resp.Reply {
+	opts, errReply := parseLCSOptions(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	a, errReply := getAsString(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	b, errReply := getAsString(db, string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	if uint64(len(a)+1)*uint64(len(b)+1)*4 > lcsMaxTableBytes {
+		return reply.MakeStandardErrorReply("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+	}
+
+	table := lcsTable(a, b)
+	length := table[len(table)-1]
+	if opts.length {
+		return reply.MakeIntReply(int64(length))
+	}
+	result, ranges := lcsBacktrack(a, b, table)
+	if !opts.idx {
+		return reply.MakeBulkReply(result)
+	}
+
+	matches := make([]resp.Reply, 0, len(ranges))
+	for _, r := range ranges {
+		matchLen := r.aEnd - r.aStart + 1
+		if matchLen < opts.minMatchLen {
+			continue
+		}
+		match := []resp.Reply{
+			makeIntsReply(r.aStart, r.aEnd),
+			makeIntsReply(r.bStart, r.bEnd),
+		}
+		if opts.withMatchLen {
+			match = append(match, reply.MakeIntReply(int64(matchLen)))
+		}
+		matches = append(matches, reply.MakeMultiRawReply(match))
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("matches")),
+		reply.MakeMultiRawReply(matches),
+		reply.MakeBulkReply([]byte("len")),
+		reply.MakeIntReply(int64(length)),
+	})
+}
+
+// makeIntsReply returns an array of integers
+func makeIntsReply(values ...int) resp.Reply {
+	replies := make([]resp.Reply, len(values))
+	for i, v := range values {
+		replies[i] = reply.MakeIntReply(int64(v))
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+func init() {
+	RegisterCommand("LCS", execLCS, -3) // LCS key1 key2 [LEN] [IDX] [MINMATCHLEN len] [WITHMATCHLEN]
+}
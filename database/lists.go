@@ -1,13 +1,14 @@
 package database
 
 import (
-	// Use the go standard library's list package
-	"container/list"
+	"math"
+	"redigo/datastruct/list"
 	"redigo/interface/database"
 	"redigo/interface/resp"
 	"redigo/lib/utils"
 	"redigo/resp/reply"
 	"strconv"
+	"strings"
 )
 
 // getAsList retrieves the list stored at the given key, or creates a new one if it doesn't exist.
@@ -93,56 +94,34 @@ func execRPush(db *DB, args [][]byte) resp.Reply {
 	return result
 }
 
-// execLPop implements the LPOP command: Removes and returns the first element of the list stored at key
-// LPOP key
+// execLPop implements the LPOP command: Removes and returns the first elements of the list stored at key
+// LPOP key [count]
 func execLPop(db *DB, args [][]byte) resp.Reply {
-	key := string(args[0])
-
-	var result resp.Reply
-
-	// Use key-level locking to prevent concurrent modification of the same list
-	db.WithKeyLock(key, func() {
-		// Get list
-		lst, exists := getAsList(db, key)
-		if !exists {
-			result = reply.MakeNullBulkReply()
-			return
-		}
-		if lst == nil { // Key exists but is not a list
-			result = reply.MakeWrongTypeErrReply()
-			return
-		}
-
-		// Check if list is empty
-		if lst.Len() == 0 {
-			result = reply.MakeNullBulkReply()
-			return
-		}
-
-		// Remove and get the first element
-		element := lst.Front()
-		lst.Remove(element)
-		value := element.Value.([]byte)
-
-		// If list becomes empty after pop, remove the key
-		if lst.Len() == 0 {
-			db.Remove(key)
-		} else {
-			// Otherwise update the list in database
-			db.PutEntity(key, &database.DataEntity{Data: lst})
-		}
-
-		db.addAof(utils.ToCmdLineWithName("LPOP", args...))
-		result = reply.MakeBulkReply(value)
-	})
-
-	return result
+	return popElements(db, args, "LPOP", true)
 }
 
-// execRPop implements the RPOP command: Removes and returns the last element of the list stored at key
-// RPOP key
+// execRPop implements the RPOP command: Removes and returns the last elements of the list stored at key
+// RPOP key [count]
 func execRPop(db *DB, args [][]byte) resp.Reply {
+	return popElements(db, args, "RPOP", false)
+}
+
+// popElements removes elements from one end of the list, it replies the element without a count
+// and an array of up to count elements with one, a missing key replies a null array then
+func popElements(db *DB, args [][]byte, name string, fromLeft bool) resp.Reply {
 	key := string(args[0])
+	if len(args) > 2 {
+		return reply.MakeArgNumErrReply(strings.ToLower(name))
+	}
+	withCount := len(args) == 2
+	count := 1
+	if withCount {
+		var err error
+		count, err = strToInt(string(args[1]))
+		if err != nil || count < 0 {
+			return reply.MakeStandardErrorReply("ERR value is out of range, must be positive")
+		}
+	}
 
 	var result resp.Reply
 
@@ -150,36 +129,48 @@ func execRPop(db *DB, args [][]byte) resp.Reply {
 	db.WithKeyLock(key, func() {
 		// Get list
 		lst, exists := getAsList(db, key)
-		if !exists {
-			result = reply.MakeNullBulkReply()
+		if exists && lst == nil { // Key exists but is not a list
+			result = reply.MakeWrongTypeErrReply()
 			return
 		}
-		if lst == nil { // Key exists but is not a list
-			result = reply.MakeWrongTypeErrReply()
+		if !exists || lst.Len() == 0 {
+			if withCount {
+				result = reply.MakeNullMultiBulkReply()
+			} else {
+				result = reply.MakeNullBulkReply()
+			}
 			return
 		}
-
-		// Check if list is empty
-		if lst.Len() == 0 {
-			result = reply.MakeNullBulkReply()
+		if count == 0 {
+			result = reply.MakeEmptyMultiBulkReply()
 			return
 		}
 
-		// Remove and get the last element
-		element := lst.Back()
-		lst.Remove(element)
-		value := element.Value.([]byte)
+		values := make([][]byte, 0, min(count, lst.Len()))
+		for len(values) < count && lst.Len() > 0 {
+			var value []byte
+			if fromLeft {
+				value, _ = lst.PopFront()
+			} else {
+				value, _ = lst.PopBack()
+			}
+			values = append(values, value)
+		}
 
 		// If list becomes empty after pop, remove the key
 		if lst.Len() == 0 {
-			db.Remove(key)
+			db.removeEmptied(key)
 		} else {
 			// Otherwise update the list in database
 			db.PutEntity(key, &database.DataEntity{Data: lst})
 		}
 
-		db.addAof(utils.ToCmdLineWithName("RPOP", args...))
-		result = reply.MakeBulkReply(value)
+		db.addAof(utils.ToCmdLineWithName(name, args...))
+		if withCount {
+			result = reply.MakeMultiBulkReply(values)
+		} else {
+			result = reply.MakeBulkReply(values[0])
+		}
 	})
 
 	return result
@@ -214,43 +205,57 @@ func execLRange(db *DB, args [][]byte) resp.Reply {
 			return
 		}
 
-		// Convert negative indices
-		size := int64(lst.Len())
-		if start < 0 {
-			start = size + start
-		}
-		if stop < 0 {
-			stop = size + stop
-		}
-		if start < 0 {
-			start = 0
-		}
-		if stop >= size {
-			stop = size - 1
-		}
-		if start > stop {
+		from, to, ok := rangeIndexes(int64(lst.Len()), start, stop)
+		if !ok {
 			result = reply.MakeEmptyMultiBulkReply()
 			return
 		}
-
-		// Collect elements
-		elements := make([][]byte, 0, stop-start+1)
-		index := int64(0)
-		for e := lst.Front(); e != nil; e = e.Next() {
-			if index >= start && index <= stop {
-				elements = append(elements, e.Value.([]byte))
-			} else if index > stop {
-				break
-			}
-			index++
+		if to-from+1 >= streamMinElements {
+			result = db.elementsReply('*', key, func(entity *database.DataEntity, header func(n int), element func(elem []byte) bool) reply.ErrorReply {
+				lst, ok := entity.Data.(*list.List)
+				if !ok {
+					return reply.MakeWrongTypeErrReply()
+				}
+				from, to, ok := rangeIndexes(int64(lst.Len()), start, stop)
+				if !ok {
+					header(0)
+					return nil
+				}
+				header(to - from + 1)
+				lst.ForEachInRange(from, to, element)
+				return nil
+			})
+			return
 		}
 
-		result = reply.MakeMultiBulkReply(elements)
+		// Collect elements
+		result = reply.MakeMultiBulkReply(lst.Range(from, to))
 	})
 
 	return result
 }
 
+// rangeIndexes converts the indexes of LRANGE, negative from the tail, to those of the elements of
+// a list of size, false if the range is empty
+func rangeIndexes(size, start, stop int64) (int, int, bool) {
+	if start < 0 {
+		start = size + start
+	}
+	if stop < 0 {
+		stop = size + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop {
+		return 0, 0, false
+	}
+	return int(start), int(stop), true
+}
+
 // execLLen implements the LLEN command: Returns the length of the list stored at key
 // LLEN key
 func execLLen(db *DB, args [][]byte) resp.Reply {
@@ -301,22 +306,8 @@ func execLIndex(db *DB, args [][]byte) resp.Reply {
 		}
 
 		// Find the element at the specified index
-		var element *list.Element
-		if index < size/2 {
-			// If index is in the first half, iterate from front
-			element = lst.Front()
-			for i := int64(0); i < index; i++ {
-				element = element.Next()
-			}
-		} else {
-			// If index is in the second half, iterate from back
-			element = lst.Back()
-			for i := size - 1; i > index; i-- {
-				element = element.Prev()
-			}
-		}
-
-		result = reply.MakeBulkReply(element.Value.([]byte))
+		value, _ := lst.Get(int(index))
+		result = reply.MakeBulkReply(value)
 	})
 
 	return result
@@ -356,38 +347,487 @@ func execLSet(db *DB, args [][]byte) resp.Reply {
 			return
 		}
 
-		// Find and update the element at the specified index
-		var element *list.Element
-		if index < size/2 {
-			element = lst.Front()
-			for i := int64(0); i < index; i++ {
-				element = element.Next()
+		// Update the element at the specified index
+		lst.Set(int(index), value)
+
+		db.PutEntity(key, &database.DataEntity{Data: lst})
+		db.addAof(utils.ToCmdLineWithName("LSET", args...))
+		result = reply.MakeOKReply()
+	})
+
+	return result
+}
+
+// execBLPop implements the BLPOP command: Pops the first element of the first non-empty list,
+// blocking until an element is pushed if all lists are empty
+// BLPOP key [key ...] timeout
+func execBLPop(db *DB, client resp.Connection, args [][]byte) resp.Reply {
+	return execBlockingPop(db, client, args, execLPop)
+}
+
+// execBRPop implements the BRPOP command: Pops the last element of the first non-empty list,
+// blocking until an element is pushed if all lists are empty
+// BRPOP key [key ...] timeout
+func execBRPop(db *DB, client resp.Connection, args [][]byte) resp.Reply {
+	return execBlockingPop(db, client, args, execRPop)
+}
+
+// execBlockingPop pops an element with pop from the first non-empty list, it replies with the key
+// and the element, or a null reply on timeout. The pop is written to the AOF by pop
+func execBlockingPop(db *DB, client resp.Connection, args [][]byte, pop ExecFunc) resp.Reply {
+	timeout, errReply := parseBlockTimeout(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	keys := make([]string, len(args)-1)
+	for i := range keys {
+		keys[i] = string(args[i])
+	}
+	result, ok := db.block(client, keys, timeout, func(first func(key string) bool) resp.Reply {
+		for _, key := range keys {
+			if !first(key) {
+				// an element pushed to the key goes to the clients blocked before
+				continue
 			}
-		} else {
-			element = lst.Back()
-			for i := size - 1; i > index; i-- {
-				element = element.Prev()
+			var popped resp.Reply
+			if errReply := db.writeGate(func() {
+				// the snapshot may have started while the client was blocked
+				db.snapshots.save(db, key)
+				popped = pop(db, [][]byte{[]byte(key)})
+			}); errReply != nil {
+				return errReply
+			}
+			switch popped := popped.(type) {
+			case *reply.BulkReply:
+				return reply.MakeMultiBulkReply([][]byte{[]byte(key), popped.Arg})
+			case reply.ErrorReply:
+				return popped
 			}
 		}
-		element.Value = value
+		return nil
+	})
+	if !ok {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return result
+}
+
+// execLPushX implements the LPUSHX command: Prepends values to a list only if the list exists
+// LPUSHX key value [value ...]
+func execLPushX(db *DB, args [][]byte) resp.Reply {
+	return execPushX(db, args, "LPUSHX", (*list.List).PushFront)
+}
+
+// execRPushX implements the RPUSHX command: Appends values to a list only if the list exists
+// RPUSHX key value [value ...]
+func execRPushX(db *DB, args [][]byte) resp.Reply {
+	return execPushX(db, args, "RPUSHX", (*list.List).PushBack)
+}
+
+// execPushX pushes the values with push if the list exists, it replies with the new length, 0 if
+// there is no list
+func execPushX(db *DB, args [][]byte, name string, push func(l *list.List, val []byte)) resp.Reply {
+	key := string(args[0])
+
+	var result resp.Reply
+
+	db.WithKeyLock(key, func() {
+		lst, exists := getAsList(db, key)
+		if !exists {
+			result = reply.MakeIntReply(0)
+			return
+		}
+		if lst == nil { // Key exists but is not a list
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+
+		for _, value := range args[1:] {
+			push(lst, value)
+		}
 
 		db.PutEntity(key, &database.DataEntity{Data: lst})
-		db.addAof(utils.ToCmdLineWithName("LSET", args...))
+		db.addAof(utils.ToCmdLineWithName(name, args...))
+		result = reply.MakeIntReply(int64(lst.Len()))
+	})
+
+	return result
+}
+
+// execLInsert implements the LINSERT command: Inserts the value before or after the first
+// element equal to pivot
+// LINSERT key BEFORE|AFTER pivot value
+func execLInsert(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var after bool
+	switch strings.ToUpper(string(args[1])) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	pivot, value := args[2], args[3]
+
+	var result resp.Reply
+
+	db.WithKeyLock(key, func() {
+		lst, exists := getAsList(db, key)
+		if !exists {
+			result = reply.MakeIntReply(0)
+			return
+		}
+		if lst == nil { // Key exists but is not a list
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+
+		// Find the first element equal to the pivot
+		index := -1
+		lst.ForEach(func(i int, val []byte) bool {
+			if string(val) == string(pivot) {
+				index = i
+				return false
+			}
+			return true
+		})
+		if index < 0 {
+			result = reply.MakeIntReply(-1)
+			return
+		}
+		if after {
+			index++
+		}
+		lst.Insert(index, value)
+
+		db.PutEntity(key, &database.DataEntity{Data: lst})
+		db.addAof(utils.ToCmdLineWithName("LINSERT", args...))
+		result = reply.MakeIntReply(int64(lst.Len()))
+	})
+
+	return result
+}
+
+// execLRem implements the LREM command: Removes the elements equal to value, count > 0 removes
+// the first count of them, count < 0 the last -count and 0 all of them
+// LREM key count value
+func execLRem(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	count, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	value := args[2]
+
+	var result resp.Reply
+
+	db.WithKeyLock(key, func() {
+		lst, exists := getAsList(db, key)
+		if !exists {
+			result = reply.MakeIntReply(0)
+			return
+		}
+		if lst == nil { // Key exists but is not a list
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+
+		removed := lst.Remove(value, int(count))
+		if removed == 0 {
+			result = reply.MakeIntReply(0)
+			return
+		}
+		if lst.Len() == 0 {
+			db.removeEmptied(key)
+		} else {
+			db.PutEntity(key, &database.DataEntity{Data: lst})
+		}
+
+		db.addAof(utils.ToCmdLineWithName("LREM", args...))
+		result = reply.MakeIntReply(int64(removed))
+	})
+
+	return result
+}
+
+// execLTrim implements the LTRIM command: Keeps only the elements from start to stop, the
+// list is removed when the range is empty
+// LTRIM key start stop
+func execLTrim(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+
+	var result resp.Reply
+
+	db.WithKeyLock(key, func() {
+		lst, exists := getAsList(db, key)
+		if !exists {
+			result = reply.MakeOKReply()
+			return
+		}
+		if lst == nil { // Key exists but is not a list
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+
+		// Convert negative indices like LRANGE
+		size := int64(lst.Len())
+		if start < 0 {
+			start = size + start
+		}
+		if stop < 0 {
+			stop = size + stop
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= size {
+			stop = size - 1
+		}
+		if start == 0 && stop == size-1 {
+			// nothing to trim
+			result = reply.MakeOKReply()
+			return
+		}
+		lst.Trim(int(start), int(stop))
+
+		if lst.Len() == 0 {
+			db.removeEmptied(key)
+		} else {
+			db.PutEntity(key, &database.DataEntity{Data: lst})
+		}
+
+		db.addAof(utils.ToCmdLineWithName("LTRIM", args...))
 		result = reply.MakeOKReply()
 	})
 
 	return result
 }
 
+// execRPopLPush implements the RPOPLPUSH command: Pops the last element of a list and pushes it
+// at the head of another, or the same, list
+// RPOPLPUSH source destination
+func execRPopLPush(db *DB, args [][]byte) resp.Reply {
+	return moveElement(db, args, "RPOPLPUSH", false, true)
+}
+
+// execLMove implements the LMOVE command: Pops an element from one end of a list and pushes it
+// at one end of another, or the same, list
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func execLMove(db *DB, args [][]byte) resp.Reply {
+	fromLeft, ok := parseListEnd(args[2])
+	if !ok {
+		return reply.MakeSyntaxErrReply()
+	}
+	toLeft, ok := parseListEnd(args[3])
+	if !ok {
+		return reply.MakeSyntaxErrReply()
+	}
+	return moveElement(db, args, "LMOVE", fromLeft, toLeft)
+}
+
+// parseListEnd parses LEFT or RIGHT, it returns true for LEFT
+func parseListEnd(arg []byte) (bool, bool) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// moveElement pops an element of the source and pushes it to the destination with the locks of
+// both lists, it replies with the element or a null reply if the source does not exist
+func moveElement(db *DB, args [][]byte, name string, fromLeft bool, toLeft bool) resp.Reply {
+	src, dst := string(args[0]), string(args[1])
+
+	var result resp.Reply
+
+	db.WithKeysLock([]string{src, dst}, func() {
+		srcList, exists := getAsList(db, src)
+		if !exists {
+			result = reply.MakeNullBulkReply()
+			return
+		}
+		if srcList == nil { // Key exists but is not a list
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+		dstList := srcList
+		if dst != src {
+			// the destination is checked before popping, so that a wrong type loses no element
+			dstList, exists = getAsList(db, dst)
+			if dstList == nil && exists {
+				result = reply.MakeWrongTypeErrReply()
+				return
+			}
+		}
+
+		var value []byte
+		if fromLeft {
+			value, _ = srcList.PopFront()
+		} else {
+			value, _ = srcList.PopBack()
+		}
+		if toLeft {
+			dstList.PushFront(value)
+		} else {
+			dstList.PushBack(value)
+		}
+
+		if srcList.Len() == 0 {
+			db.removeEmptied(src)
+		} else if dst != src {
+			db.PutEntity(src, &database.DataEntity{Data: srcList})
+		}
+		db.PutEntity(dst, &database.DataEntity{Data: dstList})
+
+		db.addAof(utils.ToCmdLineWithName(name, args...))
+		result = reply.MakeBulkReply(value)
+	})
+
+	return result
+}
+
+// execLPos implements the LPOS command: Returns the index of the elements equal to value
+// LPOS key value [RANK rank] [COUNT num-matches] [MAXLEN len]
+// RANK picks the rank-th match, counting from the tail if negative, COUNT replies the indexes of
+// up to num-matches matches, all of them for 0, and MAXLEN compares only the first len elements
+// from the end the search starts at
+func execLPos(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	value := args[1]
+	rank, count, maxLen := int64(1), int64(-1), int64(0)
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return reply.MakeSyntaxErrReply()
+		}
+		n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "RANK":
+			if n == 0 || n == math.MinInt64 {
+				return reply.MakeStandardErrorReply("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return reply.MakeStandardErrorReply("ERR COUNT can't be negative")
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return reply.MakeStandardErrorReply("ERR MAXLEN can't be negative")
+			}
+			maxLen = n
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	var result resp.Reply
+
+	db.WithKeyRLock(key, func() {
+		lst, exists := getAsList(db, key)
+		if lst == nil && exists { // Key exists but is not a list
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+
+		// The scanned part of the list, from the end the search starts at
+		size := int64(lst.Len())
+		first, last := int64(0), size-1
+		if maxLen > 0 && maxLen < size {
+			if rank > 0 {
+				last = maxLen - 1
+			} else {
+				first = size - maxLen
+			}
+		}
+		var matches []int64
+		lst.ForEach(func(i int, val []byte) bool {
+			index := int64(i)
+			if index > last {
+				return false
+			}
+			if index >= first && string(val) == string(value) {
+				matches = append(matches, index)
+			}
+			return true
+		})
+
+		// Skip the matches before the rank-th one in the order of the search
+		wanted := count
+		if wanted <= 0 {
+			wanted = int64(len(matches))
+		}
+		var found []int64
+		for n, skip := 0, abs64(rank)-1; n < len(matches) && int64(len(found)) < wanted; n++ {
+			index := matches[n]
+			if rank < 0 {
+				index = matches[len(matches)-1-n]
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			found = append(found, index)
+		}
+
+		if count < 0 {
+			if len(found) == 0 {
+				result = reply.MakeNullBulkReply()
+			} else {
+				result = reply.MakeIntReply(found[0])
+			}
+			return
+		}
+		replies := make([]resp.Reply, len(found))
+		for i, index := range found {
+			replies[i] = reply.MakeIntReply(index)
+		}
+		result = reply.MakeMultiRawReply(replies)
+	})
+
+	return result
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
 func init() {
 	// Register list commands
 	// Arity is negative because the command takes a variable number of arguments (key + at least one value)
-	RegisterCommand("LPUSH", execLPush, -3)  // key value [value ...] -> at least 3 args
-	RegisterCommand("RPUSH", execRPush, -3)  // key value [value ...] -> at least 3 args
-	RegisterCommand("LPOP", execLPop, 2)     // key
-	RegisterCommand("RPOP", execRPop, 2)     // key
-	RegisterCommand("LRANGE", execLRange, 4) // key start stop
-	RegisterCommand("LLEN", execLLen, 2)     // LLEN key -> exactly 2 args
-	RegisterCommand("LINDEX", execLIndex, 3) // LINDEX key index -> exactly 3 args
-	RegisterCommand("LSET", execLSet, 4)     // LSET key index value -> exactly 4 args
+	RegisterCommand("LPUSH", execLPush, -3)         // key value [value ...] -> at least 3 args
+	RegisterCommand("RPUSH", execRPush, -3)         // key value [value ...] -> at least 3 args
+	RegisterCommand("LPOP", execLPop, -2)           // key [count]
+	RegisterCommand("RPOP", execRPop, -2)           // key [count]
+	RegisterCommand("LRANGE", execLRange, 4)        // key start stop
+	RegisterCommand("LLEN", execLLen, 2)            // LLEN key -> exactly 2 args
+	RegisterCommand("LINDEX", execLIndex, 3)        // LINDEX key index -> exactly 3 args
+	RegisterCommand("LSET", execLSet, 4)            // LSET key index value -> exactly 4 args
+	RegisterCommand("LPUSHX", execLPushX, -3)       // key value [value ...]
+	RegisterCommand("RPUSHX", execRPushX, -3)       // key value [value ...]
+	RegisterCommand("LINSERT", execLInsert, 5)      // key BEFORE|AFTER pivot value
+	RegisterCommand("LREM", execLRem, 4)            // key count value
+	RegisterCommand("LTRIM", execLTrim, 4)          // key start stop
+	RegisterCommand("RPOPLPUSH", execRPopLPush, 3)  // source destination
+	RegisterCommand("LMOVE", execLMove, 5)          // source destination LEFT|RIGHT LEFT|RIGHT
+	RegisterCommand("LPOS", execLPos, -3)           // key value [RANK rank] [COUNT num] [MAXLEN len]
+	registerBlockingCommand("BLPOP", execBLPop, -3) // BLPOP key [key ...] timeout
+	registerBlockingCommand("BRPOP", execBRPop, -3) // BRPOP key [key ...] timeout
 }
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"redigo/datastruct/list"
+	"redigo/resp/reply"
+	"strconv"
+	"testing"
+)
+
+// TestListAcrossNodes tests the list commands on a list spanning several quicklist nodes
+func TestListAcrossNodes(t *testing.T) {
+	db := MakeDB()
+	for i := 0; i < 500; i++ {
+		exec(db, "RPUSH", "list", strconv.Itoa(i))
+	}
+	exec(db, "LPUSH", "list", "head")
+	assertReply(t, exec(db, "LLEN", "list"), ":501\r\n")
+	assertReply(t, exec(db, "LINDEX", "list", "0"), "$4\r\nhead\r\n")
+	assertReply(t, exec(db, "LINDEX", "list", "300"), "$3\r\n299\r\n")
+	assertReply(t, exec(db, "LINDEX", "list", "-1"), "$3\r\n499\r\n")
+	assertReply(t, exec(db, "LSET", "list", "200", "changed"), "+OK\r\n")
+	assertReply(t, exec(db, "LRANGE", "list", "199", "201"), "*3\r\n$3\r\n198\r\n$7\r\nchanged\r\n$3\r\n200\r\n")
+	assertReply(t, exec(db, "LRANGE", "list", "499", "1000"), "*2\r\n$3\r\n498\r\n$3\r\n499\r\n")
+
+	entity, _ := db.GetEntity("list")
+	if err := entity.Data.(*list.List).Verify(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		exec(db, "RPOP", "list")
+	}
+	assertReply(t, exec(db, "LPOP", "list"), "$4\r\nhead\r\n")
+	assertReply(t, exec(db, "EXISTS", "list"), ":0\r\n")
+}
+
+// TestListEditing tests LINSERT, LREM, LTRIM and the X variants of the pushes
+func TestListEditing(t *testing.T) {
+	db := MakeDB()
+	assertReply(t, exec(db, "LPUSHX", "list", "a"), ":0\r\n")
+	assertReply(t, exec(db, "EXISTS", "list"), ":0\r\n")
+	exec(db, "RPUSH", "list", "a", "b", "a", "c", "a")
+	assertReply(t, exec(db, "RPUSHX", "list", "d"), ":6\r\n")
+	assertReply(t, exec(db, "LINSERT", "list", "before", "c", "x"), ":7\r\n")
+	assertReply(t, exec(db, "LINSERT", "list", "AFTER", "d", "y"), ":8\r\n")
+	assertReply(t, exec(db, "LINSERT", "list", "AFTER", "missing", "y"), ":-1\r\n")
+	assertReply(t, exec(db, "LINSERT", "list", "AROUND", "c", "y"), "-ERR syntax error\r\n")
+	assertReply(t, exec(db, "LINSERT", "nolist", "BEFORE", "c", "y"), ":0\r\n")
+
+	assertReply(t, exec(db, "LREM", "list", "-2", "a"), ":2\r\n")
+	assertReply(t, exec(db, "LRANGE", "list", "0", "-1"), "*6\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nx\r\n$1\r\nc\r\n$1\r\nd\r\n$1\r\ny\r\n")
+	assertReply(t, exec(db, "LTRIM", "list", "1", "-2"), "+OK\r\n")
+	assertReply(t, exec(db, "LRANGE", "list", "0", "-1"), "*4\r\n$1\r\nb\r\n$1\r\nx\r\n$1\r\nc\r\n$1\r\nd\r\n")
+	assertReply(t, exec(db, "LREM", "list", "0", "x"), ":1\r\n")
+	assertReply(t, exec(db, "LTRIM", "list", "5", "10"), "+OK\r\n")
+	assertReply(t, exec(db, "EXISTS", "list"), ":0\r\n")
+
+	exec(db, "SET", "str", "value")
+	assertReply(t, exec(db, "LREM", "str", "0", "v"), string(reply.MakeWrongTypeErrReply().ToBytes()))
+}
+
+// TestListMove tests RPOPLPUSH and LMOVE between lists and on a single list
+func TestListMove(t *testing.T) {
+	db := MakeDB()
+	exec(db, "RPUSH", "src", "a", "b", "c")
+	assertReply(t, exec(db, "RPOPLPUSH", "src", "dst"), "$1\r\nc\r\n")
+	assertReply(t, exec(db, "LMOVE", "src", "dst", "LEFT", "RIGHT"), "$1\r\na\r\n")
+	assertReply(t, exec(db, "LRANGE", "dst", "0", "-1"), "*2\r\n$1\r\nc\r\n$1\r\na\r\n")
+	assertReply(t, exec(db, "LMOVE", "dst", "dst", "LEFT", "RIGHT"), "$1\r\nc\r\n")
+	assertReply(t, exec(db, "LRANGE", "dst", "0", "-1"), "*2\r\n$1\r\na\r\n$1\r\nc\r\n")
+	assertReply(t, exec(db, "LMOVE", "src", "dst", "UP", "RIGHT"), "-ERR syntax error\r\n")
+
+	exec(db, "SET", "str", "value")
+	assertReply(t, exec(db, "RPOPLPUSH", "src", "str"), string(reply.MakeWrongTypeErrReply().ToBytes()))
+	assertReply(t, exec(db, "LLEN", "src"), ":1\r\n")
+	assertReply(t, exec(db, "RPOPLPUSH", "src", "dst"), "$1\r\nb\r\n")
+	assertReply(t, exec(db, "EXISTS", "src"), ":0\r\n")
+	assertReply(t, exec(db, "RPOPLPUSH", "src", "dst"), "$-1\r\n")
+}
+
+// TestLPos tests the RANK, COUNT and MAXLEN options of LPOS
+func TestLPos(t *testing.T) {
+	db := MakeDB()
+	exec(db, "RPUSH", "list", "a", "b", "c", "1", "2", "3", "c", "c")
+	assertReply(t, exec(db, "LPOS", "list", "c"), ":2\r\n")
+	assertReply(t, exec(db, "LPOS", "list", "c", "RANK", "2"), ":6\r\n")
+	assertReply(t, exec(db, "LPOS", "list", "c", "RANK", "-1"), ":7\r\n")
+	assertReply(t, exec(db, "LPOS", "list", "c", "COUNT", "0"), "*3\r\n:2\r\n:6\r\n:7\r\n")
+	assertReply(t, exec(db, "LPOS", "list", "c", "RANK", "-2", "COUNT", "2"), "*2\r\n:6\r\n:2\r\n")
+	assertReply(t, exec(db, "LPOS", "list", "c", "COUNT", "0", "MAXLEN", "7"), "*2\r\n:2\r\n:6\r\n")
+	assertReply(t, exec(db, "LPOS", "list", "a", "RANK", "-1", "MAXLEN", "3"), "$-1\r\n")
+	assertReply(t, exec(db, "LPOS", "list", "x", "COUNT", "1"), "*0\r\n")
+	assertReply(t, exec(db, "LPOS", "nolist", "x"), "$-1\r\n")
+	assertReply(t, exec(db, "LPOS", "list", "c", "RANK", "0"), "-ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list\r\n")
+	assertReply(t, exec(db, "LPOS", "list", "c", "COUNT"), "-ERR syntax error\r\n")
+}
+
+// TestPopCount tests LPOP and RPOP with a count
+func TestPopCount(t *testing.T) {
+	db := MakeDB()
+	exec(db, "RPUSH", "list", "a", "b", "c", "d")
+	assertReply(t, exec(db, "LPOP", "list", "2"), "*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+	assertReply(t, exec(db, "RPOP", "list", "1"), "*1\r\n$1\r\nd\r\n")
+	assertReply(t, exec(db, "LPOP", "list", "0"), "*0\r\n")
+	assertReply(t, exec(db, "LPOP", "list", "-1"), "-ERR value is out of range, must be positive\r\n")
+	assertReply(t, exec(db, "RPOP", "list", "10"), "*1\r\n$1\r\nc\r\n")
+	assertReply(t, exec(db, "EXISTS", "list"), ":0\r\n")
+	// a missing key replies a null array with a count and a null bulk without
+	assertReply(t, exec(db, "LPOP", "list", "2"), "*-1\r\n")
+	assertReply(t, exec(db, "LPOP", "list"), "$-1\r\n")
+	assertReply(t, exec(db, "LPOP", "list", "1", "2"), "-ERR wrong number of arguments for 'lpop' command\r\n")
+}
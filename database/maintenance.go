@@ -0,0 +1,69 @@
+package database
+
+import (
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/resp/reply"
+	"strings"
+)
+
+// In maintenance mode the node rejects the write commands and keeps serving the reads, so that
+// the dataset does not change during a backup or a migration. Writes relayed by the other nodes
+// of a cluster are rejected as well since they are executed by StandaloneDatabase.Exec
+
+// readOnlyErrReply is the reply of the write commands in maintenance mode
+var readOnlyErrReply = reply.MakeStandardErrorReply("READONLY the server is in maintenance mode, write commands are rejected")
+
+// SetReadOnly enables or disables the maintenance mode
+func (d *StandaloneDatabase) SetReadOnly(readOnly bool) {
+	if d.readOnly.Swap(readOnly) != readOnly {
+		if readOnly {
+			logger.Info("maintenance mode enabled, write commands are rejected")
+		} else {
+			logger.Info("maintenance mode disabled")
+		}
+	}
+}
+
+// IsReadOnly reports whether the node is in maintenance mode
+func (d *StandaloneDatabase) IsReadOnly() bool {
+	return d.readOnly.Load()
+}
+
+// checkReadOnly rejects a write command in maintenance mode or on a replica
+func (d *StandaloneDatabase) checkReadOnly(cmdName string) reply.ErrorReply {
+	if !IsWriteCommand(cmdName) {
+		return nil
+	}
+	if d.readOnly.Load() {
+		return readOnlyErrReply
+	}
+	if d.IsReplica() {
+		return replicaReadOnlyErrReply
+	}
+	return nil
+}
+
+// execMaintenance implements the MAINTENANCE command
+// MAINTENANCE ON enables the maintenance mode
+// MAINTENANCE OFF disables it
+// MAINTENANCE STATUS replies on or off
+func execMaintenance(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("maintenance")
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "ON":
+		d.SetReadOnly(true)
+		return reply.MakeOKReply()
+	case "OFF":
+		d.SetReadOnly(false)
+		return reply.MakeOKReply()
+	case "STATUS":
+		if d.IsReadOnly() {
+			return reply.MakeStatusReply("on")
+		}
+		return reply.MakeStatusReply("off")
+	}
+	return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[0]) + "'. Try MAINTENANCE ON, MAINTENANCE OFF or MAINTENANCE STATUS.")
+}
@@ -0,0 +1,274 @@
+package database
+
+import (
+	"redigo/datastruct/hash"
+	"redigo/datastruct/list"
+	"redigo/datastruct/set"
+	"redigo/datastruct/stream"
+	"redigo/datastruct/zset"
+	"redigo/interface/database"
+	"redigo/interface/resp"
+	"redigo/resp/reply"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rough per-element overheads used to estimate the memory of a value, they do not aim at
+// being exact but at ranking keys of the same type
+const (
+	entryOverhead    = 48 // key in the dict and DataEntity
+	elementOverhead  = 16 // list element, hash table bucket
+	zsetNodeOverhead = 40 // skiplist node and score
+	streamEntryBytes = 32 // ID and listpack header
+)
+
+// ModuleSizer may be implemented by the data types of modules to be reported by MEMORY BIGKEYS
+type ModuleSizer interface {
+	ModuleSize() (elements int, bytes int)
+}
+
+// typeOf returns the type name of the value, as reported by TYPE
+func typeOf(entity *database.DataEntity) string {
+	switch data := entity.Data.(type) {
+	case []byte:
+		return "string"
+	case *list.List:
+		return "list"
+	case *hash.Hash:
+		return "hash"
+	case set.Set:
+		return "set"
+	case zset.ZSet:
+		return "zset"
+	case *stream.Stream:
+		return "stream"
+	case ModuleType:
+		return data.ModuleTypeName()
+	}
+	return "unknown"
+}
+
+// embstrMaxSize is the longest string Redis embeds in its object
+const embstrMaxSize = 44
+
+// objectEncoding returns the encoding of the value of entity as OBJECT ENCODING replies it: the
+// strings are an integer, embedded up to 44 bytes as in Redis, or raw
+func objectEncoding(entity *database.DataEntity) string {
+	switch data := entity.Data.(type) {
+	case []byte:
+		if len(data) <= 20 {
+			if _, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+				return "int"
+			}
+		}
+		if len(data) <= embstrMaxSize {
+			return "embstr"
+		}
+		return "raw"
+	case *list.List:
+		return data.ObjectEncoding()
+	case *hash.Hash:
+		return data.ObjectEncoding()
+	case set.Set:
+		return data.ObjectEncoding()
+	case zset.ZSet:
+		return data.ObjectEncoding()
+	case *stream.Stream:
+		return "stream"
+	}
+	return "raw"
+}
+
+// sizeSampler accumulates the sizes of the elements of a collection seen by estimateSize
+type sizeSampler struct {
+	samples int // elements to sample, 0 for all
+	sampled int // total size of the elements seen
+	seen    int
+}
+
+// add counts an element, it returns false once enough elements are sampled
+func (s *sizeSampler) add(size int) bool {
+	s.sampled += size
+	s.seen++
+	return s.samples == 0 || s.seen < s.samples
+}
+
+// extrapolate returns the size of n elements from the average size of those seen
+func (s *sizeSampler) extrapolate(n int) int {
+	if s.seen == 0 {
+		return 0
+	}
+	return int(int64(s.sampled) * int64(n) / int64(s.seen))
+}
+
+// estimateSize returns the number of elements of the value and an estimation of its size in bytes
+// The size of a collection is extrapolated from its first samples elements, 0 counts them all
+func estimateSize(key string, entity *database.DataEntity, samples int) (elements int, bytes int) {
+	bytes = entryOverhead + len(key)
+	// the sampler is declared by each case, the closures make it escape to the heap
+	switch data := entity.Data.(type) {
+	case []byte:
+		return len(data), bytes + len(data)
+	case *list.List:
+		sampler := sizeSampler{samples: samples}
+		data.ForEach(func(_ int, val []byte) bool {
+			return sampler.add(elementOverhead + len(val))
+		})
+		return data.Len(), bytes + sampler.extrapolate(data.Len())
+	case *hash.Hash:
+		sampler := sizeSampler{samples: samples}
+		data.ForEach(func(field, value string) bool {
+			return sampler.add(elementOverhead + len(field) + len(value))
+		})
+		return data.Len(), bytes + sampler.extrapolate(data.Len())
+	case set.Set:
+		sampler := sizeSampler{samples: samples}
+		data.ForEach(func(member string) bool {
+			return sampler.add(elementOverhead + len(member))
+		})
+		return data.Len(), bytes + sampler.extrapolate(data.Len())
+	case zset.ZSet:
+		sampler := sizeSampler{samples: samples}
+		data.ForEachByRank(0, -1, false, func(member string, score float64) bool {
+			return sampler.add(zsetNodeOverhead + len(member))
+		})
+		return data.Len(), bytes + sampler.extrapolate(data.Len())
+	case *stream.Stream:
+		sampler := sizeSampler{samples: samples}
+		for _, entry := range data.Range(stream.MinID, stream.MaxID, samples) {
+			size := streamEntryBytes
+			for _, field := range entry.Fields() {
+				size += len(field)
+			}
+			sampler.add(size)
+		}
+		return data.Len(), bytes + sampler.extrapolate(data.Len())
+	case ModuleSizer:
+		n, size := data.ModuleSize()
+		return n, bytes + size
+	}
+	return 0, bytes
+}
+
+// bigKey is a key reported by MEMORY BIGKEYS
+type bigKey struct {
+	key      string
+	elements int
+	bytes    int
+}
+
+// typeStats accumulates the keys of a type during the scan
+type typeStats struct {
+	keys     int
+	elements int
+	bytes    int
+	biggest  []bigKey // sorted by bytes, the biggest first
+}
+
+// add records the key, keeping the top biggest keys
+func (s *typeStats) add(k bigKey, top int) {
+	s.keys++
+	s.elements += k.elements
+	s.bytes += k.bytes
+	i := sort.Search(len(s.biggest), func(i int) bool {
+		return s.biggest[i].bytes < k.bytes
+	})
+	if i >= top {
+		return
+	}
+	s.biggest = append(s.biggest, bigKey{})
+	copy(s.biggest[i+1:], s.biggest[i:])
+	s.biggest[i] = k
+	if len(s.biggest) > top {
+		s.biggest = s.biggest[:top]
+	}
+}
+
+// execMemory implements the MEMORY command
+// MEMORY BIGKEYS [TOP n]
+// MEMORY PURGE
+func execMemory(db *DB, args [][]byte) resp.Reply {
+	switch strings.ToUpper(string(args[0])) {
+	case "BIGKEYS":
+		return execMemoryBigKeys(db, args[1:])
+	case "PURGE":
+		if len(args) != 1 {
+			return reply.MakeSyntaxErrReply()
+		}
+		// return the memory freed by the garbage collector to the operating system
+		debug.FreeOSMemory()
+		return reply.MakeOKReply()
+	}
+	return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[0]) + "'. Try MEMORY BIGKEYS or MEMORY PURGE.")
+}
+
+// execMemoryBigKeys scans the keyspace and reports, for each type, the number of keys, elements
+// and estimated bytes, with the n biggest keys (1 by default)
+// Keys are locked one at a time, so other clients keep being served during the scan
+func execMemoryBigKeys(db *DB, args [][]byte) resp.Reply {
+	top := 1
+	if len(args) == 2 && strings.ToUpper(string(args[0])) == "TOP" {
+		n, err := strconv.Atoi(string(args[1]))
+		if err != nil || n <= 0 {
+			return reply.MakeStandardErrorReply("ERR TOP must be a positive integer")
+		}
+		top = n
+	} else if len(args) != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+
+	stats := make(map[string]*typeStats)
+	db.data.ForEach(func(key string, _ interface{}) bool {
+		db.WithKeyRLock(key, func() {
+			// the key may have been changed or removed since the iteration reached it
+			// read the dict directly so that the scan is not counted as accesses by HOTKEYS
+			raw, ok := db.data.Get(key)
+			if !ok {
+				return
+			}
+			entity := raw.(*database.DataEntity)
+			typeName := typeOf(entity)
+			elements, bytes := estimateSize(key, entity, 0)
+			s, ok := stats[typeName]
+			if !ok {
+				s = &typeStats{}
+				stats[typeName] = s
+			}
+			s.add(bigKey{key: key, elements: elements, bytes: bytes}, top)
+		})
+		return true
+	})
+
+	typeNames := make([]string, 0, len(stats))
+	for typeName := range stats {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+	// [type, keys, elements, bytes, [[key, elements, bytes], ...]] for each type
+	result := make([]resp.Reply, 0, len(typeNames))
+	for _, typeName := range typeNames {
+		s := stats[typeName]
+		biggest := make([]resp.Reply, len(s.biggest))
+		for i, k := range s.biggest {
+			biggest[i] = reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeBulkReply([]byte(k.key)),
+				reply.MakeIntReply(int64(k.elements)),
+				reply.MakeIntReply(int64(k.bytes)),
+			})
+		}
+		result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(typeName)),
+			reply.MakeIntReply(int64(s.keys)),
+			reply.MakeIntReply(int64(s.elements)),
+			reply.MakeIntReply(int64(s.bytes)),
+			reply.MakeMultiRawReply(biggest),
+		}))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+func init() {
+	RegisterCommand("MEMORY", execMemory, -2) // MEMORY BIGKEYS [TOP n]
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"errors"
+	"redigo/interface/resp"
+	"redigo/resp/reply"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A module is a group of commands provided by an optional package, like the modules of Redis.
+// The package registers itself in its init function, and is enabled by importing it:
+//
+//	import _ "redigo/module/probabilistic"
+//
+// Module commands are executed like builtin commands and may use the exported DB methods
+// (GetEntity, PutEntity, WithKeyLock, AddAof...) to store their own data types.
+
+// Module describes a group of commands
+type Module struct {
+	Name     string
+	Version  int
+	Commands []ModuleCommand
+	// Types maps the ModuleTypeName of the data types of the module to the function restoring
+	// them from snapshots, the types must implement ModuleDumper
+	Types map[string]ModuleTypeLoader
+}
+
+// ModuleCommand is a command provided by a module
+// The first argument after the command name must be the key, so that the cluster can route it
+type ModuleCommand struct {
+	Name     string
+	Exec     ExecFunc
+	Arity    int
+	ReadOnly bool // the command does not modify the database
+}
+
+// ModuleType is implemented by the data types of modules, TYPE replies with the returned name
+type ModuleType interface {
+	ModuleTypeName() string
+}
+
+// ModuleDumper is implemented by the data types of modules which can be saved in snapshots
+type ModuleDumper interface {
+	ModuleType
+	ModuleDump() ([]byte, error)
+}
+
+// ModuleTypeLoader restores a value saved by ModuleDump
+type ModuleTypeLoader func(payload []byte) (interface{}, error)
+
+var (
+	modulesMu         sync.RWMutex
+	modules           = make(map[string]*Module)
+	moduleCommands    = make(map[string]string) // command name -> module name
+	moduleTypeLoaders = make(map[string]ModuleTypeLoader)
+)
+
+// RegisterModule registers the commands of the module
+// It must be called before the server starts, usually in an init function
+func RegisterModule(module *Module) error {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	name := strings.ToLower(module.Name)
+	if name == "" {
+		return errors.New("module name is empty")
+	}
+	if _, ok := modules[name]; ok {
+		return errors.New("module " + name + " is already registered")
+	}
+	for _, cmd := range module.Commands {
+		if _, ok := cmdTable[strings.ToLower(cmd.Name)]; ok {
+			return errors.New("command " + cmd.Name + " of module " + name + " conflicts with an existing command")
+		}
+	}
+	for typeName := range module.Types {
+		if _, ok := moduleTypeLoaders[typeName]; ok {
+			return errors.New("type " + typeName + " of module " + name + " is already registered")
+		}
+	}
+	for typeName, loader := range module.Types {
+		moduleTypeLoaders[typeName] = loader
+	}
+	for _, cmd := range module.Commands {
+		if cmd.ReadOnly {
+			registerReadOnlyCommand(cmd.Name, cmd.Exec, cmd.Arity)
+		} else {
+			RegisterCommand(cmd.Name, cmd.Exec, cmd.Arity)
+		}
+		moduleCommands[strings.ToLower(cmd.Name)] = name
+	}
+	modules[name] = module
+	return nil
+}
+
+// IsModuleCommand reports whether the command is provided by a module
+func IsModuleCommand(name string) bool {
+	modulesMu.RLock()
+	defer modulesMu.RUnlock()
+	_, ok := moduleCommands[strings.ToLower(name)]
+	return ok
+}
+
+// getModuleTypeLoader returns the loader of a module data type
+func getModuleTypeLoader(typeName string) (ModuleTypeLoader, bool) {
+	modulesMu.RLock()
+	defer modulesMu.RUnlock()
+	loader, ok := moduleTypeLoaders[typeName]
+	return loader, ok
+}
+
+// AddAof appends the command line to the AOF of the DB, for commands defined outside this package
+func (db *DB) AddAof(line CmdLine) {
+	db.addAof(line)
+}
+
+// execModule implements the MODULE command
+// MODULE LIST
+func execModule(db *DB, args [][]byte) resp.Reply {
+	if strings.ToUpper(string(args[0])) != "LIST" || len(args) != 1 {
+		return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[0]) + "'. Try MODULE LIST.")
+	}
+	modulesMu.RLock()
+	defer modulesMu.RUnlock()
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// each module is reported as [name, <name>, ver, <version>]
+	items := make([]resp.Reply, len(names))
+	for i, name := range names {
+		items[i] = reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("name")),
+			reply.MakeBulkReply([]byte(name)),
+			reply.MakeBulkReply([]byte("ver")),
+			reply.MakeIntReply(int64(modules[name].Version)),
+		})
+	}
+	return reply.MakeMultiRawReply(items)
+}
+
+func init() {
+	RegisterCommand("MODULE", execModule, -2) // MODULE LIST
+}
@@ -0,0 +1,291 @@
+package database
+
+import (
+	"redigo/config"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the flags of notify-keyspace-events, those of Redis: K and E choose the channels, the others
+// the classes of the events published
+const (
+	notifyKeyspace = 1 << iota // K, __keyspace@<db>__:<key> receives the events of the key
+	notifyKeyevent             // E, __keyevent@<db>__:<event> receives the keys of the event
+	notifyGeneric              // g, the commands which are not specific to a type like EXPIRE
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x, the keys removed because their expiration time passed
+	notifyEvicted              // e, the keys evicted over maxmemory
+	notifyStream               // t
+)
+
+// notifyAll is A, the classes of all the events
+const notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet |
+	notifyExpired | notifyEvicted | notifyStream
+
+var notifyFlags = map[rune]int{
+	'K': notifyKeyspace,
+	'E': notifyKeyevent,
+	'g': notifyGeneric,
+	'$': notifyString,
+	'l': notifyList,
+	's': notifySet,
+	'h': notifyHash,
+	'z': notifyZSet,
+	'x': notifyExpired,
+	'e': notifyEvicted,
+	't': notifyStream,
+	'A': notifyAll,
+}
+
+// parseNotifyFlags returns the flags of a notify-keyspace-events value, false if it holds an
+// unknown flag
+func parseNotifyFlags(value string) (int, bool) {
+	flags := 0
+	for _, c := range value {
+		flag, ok := notifyFlags[c]
+		if !ok {
+			return 0, false
+		}
+		flags |= flag
+	}
+	return flags, true
+}
+
+// keyspaceEvents returns the flags of notify-keyspace-events, 0 when nothing is published
+func keyspaceEvents() int {
+	flags, _ := parseNotifyFlags(config.Read(func(p *config.ServerProperties) string {
+		return p.NotifyKeyspaceEvents
+	}))
+	if flags&(notifyKeyspace|notifyKeyevent) == 0 {
+		return 0
+	}
+	return flags
+}
+
+// setNotifyKeyspaceEvents sets the classes of the keyspace events published
+func setNotifyKeyspaceEvents(d *StandaloneDatabase, name, value string) string {
+	if _, ok := parseNotifyFlags(value); !ok {
+		return "argument must be made of the flags KEg$lshzxetA"
+	}
+	return setParameter(d, name, value)
+}
+
+// notification is the event published for the keys of a write command
+type notification struct {
+	class int
+	event string
+	// firstKey publishes the event for the first key only, the destination of the commands
+	// storing the result of their sources
+	firstKey bool
+}
+
+// notifications are the events of the write commands as they are propagated, the events of the
+// commands missing are published otherwise: DEL by the removals of the keys, EXPIRE propagated
+// as PEXPIREAT, or with several keys of different events like RENAME and LMOVE
+var notifications = map[string]notification{
+	"set":              {class: notifyString, event: "set"},
+	"setnx":            {class: notifyString, event: "set"},
+	"mset":             {class: notifyString, event: "set"},
+	"setrange":         {class: notifyString, event: "setrange"},
+	"append":           {class: notifyString, event: "append"},
+	"incr":             {class: notifyString, event: "incrby"},
+	"incrby":           {class: notifyString, event: "incrby"},
+	"decr":             {class: notifyString, event: "decrby"},
+	"decrby":           {class: notifyString, event: "decrby"},
+	"lpush":            {class: notifyList, event: "lpush"},
+	"lpushx":           {class: notifyList, event: "lpush"},
+	"rpush":            {class: notifyList, event: "rpush"},
+	"rpushx":           {class: notifyList, event: "rpush"},
+	"lpop":             {class: notifyList, event: "lpop"},
+	"rpop":             {class: notifyList, event: "rpop"},
+	"lset":             {class: notifyList, event: "lset"},
+	"linsert":          {class: notifyList, event: "linsert"},
+	"lrem":             {class: notifyList, event: "lrem"},
+	"ltrim":            {class: notifyList, event: "ltrim"},
+	"hset":             {class: notifyHash, event: "hset"},
+	"hmset":            {class: notifyHash, event: "hset"},
+	"hsetnx":           {class: notifyHash, event: "hset"},
+	"hdel":             {class: notifyHash, event: "hdel"},
+	"sadd":             {class: notifySet, event: "sadd"},
+	"srem":             {class: notifySet, event: "srem"},
+	"spop":             {class: notifySet, event: "spop"},
+	"sunionstore":      {class: notifySet, event: "sunionstore", firstKey: true},
+	"sinterstore":      {class: notifySet, event: "sinterstore", firstKey: true},
+	"sdiffstore":       {class: notifySet, event: "sdiffstore", firstKey: true},
+	"zadd":             {class: notifyZSet, event: "zadd"},
+	"geoadd":           {class: notifyZSet, event: "zadd"},
+	"zincrby":          {class: notifyZSet, event: "zincr"},
+	"zrem":             {class: notifyZSet, event: "zrem"},
+	"zremrangebyrank":  {class: notifyZSet, event: "zremrangebyrank"},
+	"zremrangebyscore": {class: notifyZSet, event: "zremrangebyscore"},
+	"zpopmin":          {class: notifyZSet, event: "zpopmin"},
+	"zpopmax":          {class: notifyZSet, event: "zpopmax"},
+	"xadd":             {class: notifyStream, event: "xadd"},
+	"xtrim":            {class: notifyStream, event: "xtrim"},
+	"pexpireat":        {class: notifyGeneric, event: "expire"},
+	"persist":          {class: notifyGeneric, event: "persist"},
+	"restore":          {class: notifyGeneric, event: "restore"},
+}
+
+// hookNotifications makes db publish its keyspace events to the pub/sub channels
+func (d *StandaloneDatabase) hookNotifications(db *DB) {
+	db.events.subscribe(eventWritten, func(event keyEvent) {
+		d.notifyWrite(event.db, event.cmdLine)
+	})
+	db.events.subscribe(eventDeleted, func(event keyEvent) {
+		// the del of an emptied collection follows the event of the command, see notifyWrite
+		if !event.emptied {
+			d.notify(event.db, notifyGeneric, "del", event.key)
+		}
+	})
+	db.events.subscribe(eventExpired, func(event keyEvent) {
+		d.notify(event.db, notifyExpired, "expired", event.key)
+	})
+	db.events.subscribe(eventEvicted, func(event keyEvent) {
+		d.notify(event.db, notifyEvicted, "evicted", event.key)
+	})
+}
+
+// notifyWrite publishes the events of a write command
+func (d *StandaloneDatabase) notifyWrite(db *DB, cmdLine CmdLine) {
+	if keyspaceEvents() == 0 {
+		return
+	}
+	name := strings.ToLower(string(cmdLine[0]))
+	switch name {
+	case "rename", "renamenx":
+		d.notify(db, notifyGeneric, "rename_from", string(cmdLine[1]))
+		d.notify(db, notifyGeneric, "rename_to", string(cmdLine[2]))
+		return
+	case "rpoplpush":
+		d.notify(db, notifyList, "rpop", string(cmdLine[1]))
+		d.notifyIfEmptied(db, string(cmdLine[1]))
+		d.notify(db, notifyList, "lpush", string(cmdLine[2]))
+		return
+	case "lmove":
+		d.notify(db, notifyList, popEvent(cmdLine[3]), string(cmdLine[1]))
+		d.notifyIfEmptied(db, string(cmdLine[1]))
+		d.notify(db, notifyList, pushEvent(cmdLine[4]), string(cmdLine[2]))
+		return
+	}
+	n, ok := notifications[name]
+	if !ok {
+		return
+	}
+	keys, _ := CommandKeys(cmdLine)
+	if n.firstKey {
+		// an empty result removed the destination, which is notified as deleted
+		if _, exists := db.data.Get(keys[0]); !exists {
+			return
+		}
+		keys = keys[:1]
+	}
+	for _, key := range keys {
+		d.notify(db, n.class, n.event, key)
+		if !n.firstKey {
+			d.notifyIfEmptied(db, key)
+		}
+	}
+	// SET with an expiration time also sets it
+	if name == "set" && len(cmdLine) > 4 && strings.EqualFold(string(cmdLine[3]), "PXAT") {
+		d.notify(db, notifyGeneric, "expire", string(cmdLine[1]))
+	}
+}
+
+// notifyIfEmptied publishes the del of a collection removed because the command emptied it,
+// after the event of the command like Redis
+func (d *StandaloneDatabase) notifyIfEmptied(db *DB, key string) {
+	if _, exists := db.data.Get(key); !exists {
+		d.notify(db, notifyGeneric, "del", key)
+	}
+}
+
+// popEvent returns the event of the element popped by LMOVE from the side of the source
+func popEvent(side []byte) string {
+	if strings.EqualFold(string(side), "LEFT") {
+		return "lpop"
+	}
+	return "rpop"
+}
+
+// pushEvent returns the event of the element pushed by LMOVE to the side of the destination
+func pushEvent(side []byte) string {
+	if strings.EqualFold(string(side), "LEFT") {
+		return "lpush"
+	}
+	return "rpush"
+}
+
+// notify publishes an event of the key if its class is enabled by notify-keyspace-events
+func (d *StandaloneDatabase) notify(db *DB, class int, event, key string) {
+	flags := keyspaceEvents()
+	if flags&class == 0 {
+		return
+	}
+	index := strconv.Itoa(db.index)
+	if flags&notifyKeyspace != 0 {
+		d.notices.add("__keyspace@"+index+"__:"+key, []byte(event))
+	}
+	if flags&notifyKeyevent != 0 {
+		d.notices.add("__keyevent@"+index+"__:"+event, []byte(key))
+	}
+}
+
+// notice is the message of a keyspace event waiting to be published
+type notice struct {
+	channel string
+	message []byte
+}
+
+// noticeQueue holds the keyspace events queued by the commands under the locks of their keys, in
+// the order of the changes; they are published by deliverNotices after the commands, which never
+// wait for the hub or the subscribers
+type noticeQueue struct {
+	mu      sync.Mutex
+	pending []notice
+	wake    chan struct{}
+}
+
+func makeNoticeQueue() *noticeQueue {
+	return &noticeQueue{wake: make(chan struct{}, 1)}
+}
+
+func (q *noticeQueue) add(channel string, message []byte) {
+	q.mu.Lock()
+	q.pending = append(q.pending, notice{channel: channel, message: message})
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the events queued since the last call
+func (q *noticeQueue) take() []notice {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := q.pending
+	q.pending = nil
+	return pending
+}
+
+// startNotifications publishes the queued keyspace events until the database is closed
+func (d *StandaloneDatabase) startNotifications() {
+	go func() {
+		for {
+			select {
+			case <-d.closed:
+				return
+			case <-d.notices.wake:
+				for _, n := range d.notices.take() {
+					d.hub.Publish(n.channel, n.message)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"bytes"
+	"redigo/config"
+	"redigo/lib/utils"
+	"redigo/resp/connection"
+	"redigo/resp/parser"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// subscriber is a connection recording the messages pushed by the pub/sub hub
+type subscriber struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	subs int
+	// stuck blocks the writes until it is closed, like a client which never reads
+	stuck chan struct{}
+}
+
+func (s *subscriber) Write(b []byte) error {
+	s.mu.Lock()
+	stuck := s.stuck
+	s.mu.Unlock()
+	if stuck != nil {
+		<-stuck
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Write(b)
+	return nil
+}
+
+func (s *subscriber) GetDBIndex() int  { return 0 }
+func (s *subscriber) SelectDB(int)     {}
+func (s *subscriber) GetProtocol() int { return reply.RESP2 }
+func (s *subscriber) SetProtocol(int)  {}
+func (s *subscriber) GetSubscriptions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subs
+}
+func (s *subscriber) SetSubscriptions(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs = n
+}
+
+// syncChannel is queued after the events expected, as the events are published in order and the
+// messages of a subscriber are written in order it is received after them
+const syncChannel = "__key__:sync"
+
+// messages returns the channel and the message of each pmessage received since the last call
+func (s *subscriber) messages(d *StandaloneDatabase) []string {
+	d.notices.add(syncChannel, nil)
+	deadline := time.Now().Add(5 * time.Second)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for !bytes.Contains(s.buf.Bytes(), []byte(syncChannel)) && time.Now().Before(deadline) {
+		s.mu.Unlock()
+		time.Sleep(time.Millisecond)
+		s.mu.Lock()
+	}
+	var messages []string
+	for payload := range parser.ParseStream(bytes.NewReader(s.buf.Bytes())) {
+		if push, ok := payload.Data.(*reply.MultiBulkReply); ok && string(push.Args[0]) == "pmessage" &&
+			string(push.Args[2]) != syncChannel {
+			messages = append(messages, string(push.Args[2])+" "+string(push.Args[3]))
+		}
+	}
+	s.buf.Reset()
+	return messages
+}
+
+// TestKeyspaceNotifications tests the events published by the write commands, the removals and
+// the expirations for the classes of notify-keyspace-events
+func TestKeyspaceNotifications(t *testing.T) {
+	defer func(properties *config.ServerProperties) {
+		config.Properties = properties
+	}(config.Properties)
+	properties := *config.Properties
+	config.Properties = &properties
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	sub := &subscriber{}
+	d.hub.PSubscribe(sub, utils.ToCmdLine("__key*__:*"))
+	sub.messages(d)
+	assertMessages := func(expected ...string) {
+		t.Helper()
+		if got := sub.messages(d); strings.Join(got, "; ") != strings.Join(expected, "; ") {
+			t.Errorf("expected the messages %q, got %q", expected, got)
+		}
+	}
+
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "notify-keyspace-events", "KEq")), "-ERR CONFIG SET failed (possibly related to argument 'notify-keyspace-events') - argument must be made of the flags KEg$lshzxetA\r\n")
+	d.Exec(client, utils.ToCmdLine("SET", "a", "1"))
+	assertMessages()
+
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "notify-keyspace-events", "KA")), "+OK\r\n")
+	d.Exec(client, utils.ToCmdLine("SET", "a", "1"))
+	d.Exec(client, utils.ToCmdLine("GET", "a"))
+	d.Exec(client, utils.ToCmdLine("RENAME", "a", "b"))
+	d.Exec(client, utils.ToCmdLine("RPUSH", "list", "x", "y"))
+	d.Exec(client, utils.ToCmdLine("LMOVE", "list", "other", "LEFT", "RIGHT"))
+	d.Exec(client, utils.ToCmdLine("LPOP", "list"))
+	d.Exec(client, utils.ToCmdLine("DEL", "b", "missing"))
+	d.Exec(client, utils.ToCmdLine("SET", "volatile", "1", "PX", "1"))
+	time.Sleep(5 * time.Millisecond)
+	d.Exec(client, utils.ToCmdLine("GET", "volatile"))
+	assertMessages(
+		"__keyspace@0__:a set",
+		"__keyspace@0__:a rename_from", "__keyspace@0__:b rename_to",
+		"__keyspace@0__:list rpush",
+		"__keyspace@0__:list lpop", "__keyspace@0__:other rpush",
+		"__keyspace@0__:list lpop", "__keyspace@0__:list del",
+		"__keyspace@0__:b del",
+		"__keyspace@0__:volatile set", "__keyspace@0__:volatile expire",
+		"__keyspace@0__:volatile expired",
+	)
+
+	// the key event channels, the classes which are not enabled publish nothing
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "notify-keyspace-events", "E$")), "+OK\r\n")
+	d.Exec(client, utils.ToCmdLine("SET", "a", "2"))
+	d.Exec(client, utils.ToCmdLine("LPUSH", "list", "z"))
+	assertMessages("__keyevent@0__:set a")
+
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "notify-keyspace-events", "")), "+OK\r\n")
+	d.Exec(client, utils.ToCmdLine("SET", "a", "3"))
+	assertMessages()
+}
+
+// TestNotificationsStuckSubscriber tests that a subscriber which never reads delays neither the
+// commands nor the other subscribers, and is disconnected over client-output-buffer-limit
+func TestNotificationsStuckSubscriber(t *testing.T) {
+	defer func(properties *config.ServerProperties) {
+		config.Properties = properties
+	}(config.Properties)
+	properties := *config.Properties
+	config.Properties = &properties
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	stuck, other := &subscriber{}, &subscriber{}
+	d.hub.PSubscribe(stuck, utils.ToCmdLine("__key*__:*"))
+	d.hub.PSubscribe(other, utils.ToCmdLine("__key*__:*"))
+	other.messages(d)
+	unblock := make(chan struct{})
+	defer close(unblock)
+	stuck.mu.Lock()
+	stuck.stuck = unblock
+	stuck.mu.Unlock()
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "client-output-buffer-limit", "pubsub 256kb 0 0")), "+OK\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "client-output-buffer-limit", "pubsub 256kb")), "-ERR CONFIG SET failed (possibly related to argument 'client-output-buffer-limit') - wrong number of arguments\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "notify-keyspace-events", "K$")), "+OK\r\n")
+
+	received := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20000; i++ {
+			d.Exec(client, utils.ToCmdLine("SET", "key", strconv.Itoa(i)))
+			if i%1000 == 999 {
+				// the other subscriber keeps up
+				received += len(other.messages(d))
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the commands not to wait for the stuck subscriber")
+	}
+	if received != 20000 {
+		t.Errorf("expected the other subscriber to receive the 20000 events, got %d", received)
+	}
+	if n := d.hub.NumPat(); n != 1 {
+		t.Errorf("expected the stuck subscriber to be disconnected, got %d subscriptions", n)
+	}
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"redigo/lib/logger"
+	"redigo/lib/stats"
+	"redigo/resp/reply"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// PanicReply reports a command which panicked, it must be called by the deferred function which
+// recovered the panic, so that the stack of the panic is logged
+// The log holds the command with its arguments redacted and a correlation ID, which the client
+// receives in the error reply so that the failure can be found in the log
+func PanicReply(cmdLine [][]byte, recovered any) reply.ErrorReply {
+	stats.Server.CommandPanicked()
+	id := correlationID()
+	name := commandName(cmdLine[0])
+	logger.Error("command " + name + " panic (id " + id + "): " + toString(recovered) +
+		"\ncommand: " + redactedCommand(cmdLine) + "\n" + string(debug.Stack()))
+	return reply.MakeStandardErrorReply("ERR internal error executing '" + name + "' (id " + id + "), see the server log")
+}
+
+// correlationID returns a random ID linking an error reply to its log
+func correlationID() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// redactedCommand returns the command name followed by the length of each argument, the values
+// may be secrets or personal data which must not be logged
+func redactedCommand(cmdLine [][]byte) string {
+	var sb strings.Builder
+	sb.WriteString(commandName(cmdLine[0]))
+	for _, arg := range cmdLine[1:] {
+		sb.WriteString(" <" + strconv.Itoa(len(arg)) + " bytes>")
+	}
+	return sb.String()
+}
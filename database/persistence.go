@@ -0,0 +1,152 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SAVE and BGSAVE write a point in time snapshot of the dataset to the RDB file. The write
+// commands hold d.writes for reading while they run, a save holds it for writing only to start
+// the snapshot, the writes then go on while it is written (see cow.go):
+//   - SAVE replies once the file is written
+//   - BGSAVE replies once the snapshot started, the file is written in background
+//
+// The blocking commands do not hold d.writes while they wait, only while they pop
+
+// errBGSaveInProgress is replied to SAVE and BGSAVE while a background save is running
+var errBGSaveInProgress = reply.MakeStandardErrorReply("ERR Background save already in progress")
+
+// aofBehindErrReply is replied to the write commands while the AOF writer is behind, with
+// aof-backpressure reject
+var aofBehindErrReply = reply.MakeStandardErrorReply("OOM command not allowed when the AOF writer is behind (aof-backpressure reject)")
+
+// checkAofBackpressure rejects a write command while aof-buffer-size commands wait for the AOF
+// writer with aof-backpressure reject, the commands which free memory are still accepted
+func (d *StandaloneDatabase) checkAofBackpressure(cmdName string) reply.ErrorReply {
+	if !IsWriteCommand(cmdName) || oomAllowedCommands[cmdName] {
+		return nil
+	}
+	if aofHandler := d.aofHandler.Load(); aofHandler != nil && aofHandler.Reject() {
+		return aofBehindErrReply
+	}
+	return nil
+}
+
+// pauseWrites waits for the running write commands and holds the new ones until resume is called
+func (d *StandaloneDatabase) pauseWrites() (resume func()) {
+	d.writes.Lock()
+	return d.writes.Unlock
+}
+
+// execSave implements the SAVE command, it writes the snapshot before replying
+// SAVE
+func execSave(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("save")
+	}
+	if !d.saving.CompareAndSwap(false, true) {
+		return errBGSaveInProgress
+	}
+	defer d.saving.Store(false)
+	if err := d.SaveRDB(rdbFilename()); err != nil {
+		logger.Error("SAVE failed: " + err.Error())
+		return reply.MakeStandardErrorReply("ERR Error trying to save the DB: " + err.Error())
+	}
+	d.lastSave.Store(time.Now().Unix())
+	return reply.MakeOKReply()
+}
+
+// execBGSave implements the BGSAVE command, it starts a snapshot and writes it to the file in
+// background
+// BGSAVE
+func execBGSave(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("bgsave")
+	}
+	if !d.saving.CompareAndSwap(false, true) {
+		return errBGSaveInProgress
+	}
+	resume := d.pauseWrites()
+	snap := d.startSnapshot(encodeRDBKeys)
+	resume()
+	filename := rdbFilename()
+	go func() {
+		defer d.saving.Store(false)
+		defer snap.end()
+		err := writeFileAtomic(filename, snap.writeRDB)
+		d.lastBGSaveFailed.Store(err != nil)
+		if err != nil {
+			logger.Error("BGSAVE failed: " + err.Error())
+			return
+		}
+		d.lastSave.Store(time.Now().Unix())
+		logger.Info("BGSAVE wrote " + filename)
+	}()
+	return reply.MakeStatusReply("Background saving started")
+}
+
+// execLastSave implements the LASTSAVE command, it replies the unix time of the last successful
+// save, or of the start of the server
+// LASTSAVE
+func execLastSave(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("lastsave")
+	}
+	return reply.MakeIntReply(d.lastSave.Load())
+}
+
+// loadSnapshot loads the RDB file at startup, a missing file leaves the dataset empty
+func (d *StandaloneDatabase) loadSnapshot() error {
+	filename := rdbFilename()
+	err := d.LoadRDB(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.New("load " + filename + ": " + err.Error())
+	}
+	logger.Info("loaded the snapshot " + filename)
+	return nil
+}
+
+func infoPersistence(d *StandaloneDatabase, sb *strings.Builder) {
+	saving, aofEnabled := 0, 0
+	if d.saving.Load() {
+		saving = 1
+	}
+	aofHandler := d.aofHandler.Load()
+	if aofHandler != nil {
+		aofEnabled = 1
+	}
+	status := "ok"
+	if d.lastBGSaveFailed.Load() {
+		status = "err"
+	}
+	sb.WriteString("rdb_bgsave_in_progress:" + strconv.Itoa(saving) + "\r\n")
+	sb.WriteString("rdb_last_save_time:" + strconv.FormatInt(d.lastSave.Load(), 10) + "\r\n")
+	sb.WriteString("rdb_last_bgsave_status:" + status + "\r\n")
+	sb.WriteString("aof_enabled:" + strconv.Itoa(aofEnabled) + "\r\n")
+	if aofHandler != nil {
+		rewriting := 0
+		if aofHandler.IsRewriting() {
+			rewriting = 1
+		}
+		size, base := aofHandler.Size()
+		sb.WriteString("aof_rewrite_in_progress:" + strconv.Itoa(rewriting) + "\r\n")
+		sb.WriteString("aof_current_size:" + strconv.FormatInt(size, 10) + "\r\n")
+		sb.WriteString("aof_base_size:" + strconv.FormatInt(base, 10) + "\r\n")
+		policy, pending := aofHandler.Backpressure()
+		blocked, spilled, rejected := aofHandler.BackpressureStats()
+		sb.WriteString("aof_backpressure:" + policy + "\r\n")
+		sb.WriteString("aof_pending_commands:" + strconv.FormatInt(pending, 10) + "\r\n")
+		sb.WriteString("aof_blocked_writes:" + strconv.FormatInt(blocked, 10) + "\r\n")
+		sb.WriteString("aof_spilled_commands:" + strconv.FormatInt(spilled, 10) + "\r\n")
+		sb.WriteString("aof_rejected_writes:" + strconv.FormatInt(rejected, 10) + "\r\n")
+	}
+}
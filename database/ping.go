@@ -1,17 +1,80 @@
 package database
 
 import (
+	"math/rand"
 	"redigo/interface/resp"
 	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// redigoVersion is reported by LOLWUT
+const redigoVersion = "1.0.0"
+
 // Ping responds to the PING command
+// PING [message]
 func Ping(db *DB, args [][]byte) resp.Reply {
+	if len(args) == 1 {
+		return reply.MakeBulkReply(args[0])
+	}
+	if len(args) > 1 {
+		return reply.MakeArgNumErrReply("ping")
+	}
 	return reply.MakePongReply()
 }
 
-// Register the PING command to the command table
+// execEcho responds to the ECHO command
+// ECHO message
+func execEcho(db *DB, args [][]byte) resp.Reply {
+	return reply.MakeBulkReply(args[0])
+}
+
+// execTime responds to the TIME command with the unix time in seconds and the microseconds
+// TIME
+func execTime(db *DB, args [][]byte) resp.Reply {
+	now := time.Now()
+	return reply.MakeMultiBulkReply([][]byte{
+		[]byte(strconv.FormatInt(now.Unix(), 10)),
+		[]byte(strconv.Itoa(now.Nanosecond() / 1000)),
+	})
+}
+
+// execLolwut draws a few rows of squares getting more and more disordered, in the spirit of
+// Georg Nees' "Schotter", followed by the server version
+// LOLWUT [VERSION version]
+func execLolwut(db *DB, args [][]byte) resp.Reply {
+	if len(args) != 0 && (len(args) != 2 || strings.ToUpper(string(args[0])) != "VERSION") {
+		return reply.MakeSyntaxErrReply()
+	}
+	const cols, rows = 12, 10
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	shapes := []string{"[]", "<>", "{}", "()", "/\\", "\\/"}
+	var sb strings.Builder
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			// the probability of a tilted square grows with the row
+			if rng.Intn(rows) < r {
+				sb.WriteString(shapes[rng.Intn(len(shapes))])
+			} else {
+				sb.WriteString(shapes[0])
+			}
+			// the squares drift apart as well
+			if rng.Intn(rows*2) < r {
+				sb.WriteString("  ")
+			} else {
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\nGeorg Nees - schotter, plotter on paper, 1968. Redigo ver. " + redigoVersion + "\n")
+	return reply.MakeBulkReply([]byte(sb.String()))
+}
+
 func init() {
-	// Register the PING command with the command table
-	RegisterCommand("ping", Ping, 1)
+	RegisterCommand("ping", Ping, -1)    // PING [message]
+	RegisterCommand("echo", execEcho, 2) // ECHO message
+	RegisterCommand("time", execTime, 1)
+	RegisterCommand("lolwut", execLolwut, -1) // LOLWUT [VERSION version]
 }
@@ -0,0 +1,423 @@
+package database
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"redigo/config"
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/lib/utils"
+	"redigo/resp/connection"
+	"redigo/resp/parser"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A replica keeps a link to its master: it connects, sends PSYNC, loads the snapshot of a full
+// resync and applies the stream of write commands, acknowledging its offset every second. A lost
+// link is connected again, resuming from the offset of the replica when the master still has it
+// in its backlog. The clients of a replica may only read
+
+// replTimeout is how long the link waits for the master before it is considered dead, the
+// master pings its replicas more often
+const replTimeout = 60 * time.Second
+
+// replRetryInterval is the delay before connecting again to the master after the link was lost
+const replRetryInterval = time.Second
+
+// replAckInterval is how often a replica acknowledges its offset
+const replAckInterval = time.Second
+
+// replicaReadOnlyErrReply is the reply of the write commands sent by clients to a replica
+var replicaReadOnlyErrReply = reply.MakeStandardErrorReply("READONLY You can't write against a read only replica.")
+
+// replicaLink is the link of a replica to its master
+type replicaLink struct {
+	host string
+	port int
+	stop chan struct{}
+	once sync.Once
+
+	mu   sync.Mutex
+	conn net.Conn // nil while disconnected
+	// replID and offset are those of the stream of the master, replID is empty before the first sync
+	replID string
+	offset atomic.Int64
+	// connected is set while the stream is applied, syncing while the snapshot is loaded
+	connected atomic.Bool
+	syncing   atomic.Bool
+	// lastIO is the unix time of the last data received from the master
+	lastIO atomic.Int64
+	// client holds the DB selected by the stream, kept across the partial resyncs
+	client *connection.Connection
+}
+
+func (l *replicaLink) addr() string {
+	return net.JoinHostPort(l.host, strconv.Itoa(l.port))
+}
+
+// close stops the link for good
+func (l *replicaLink) close() {
+	l.once.Do(func() {
+		close(l.stop)
+		l.mu.Lock()
+		if l.conn != nil {
+			_ = l.conn.Close()
+		}
+		l.mu.Unlock()
+	})
+}
+
+func (l *replicaLink) stopped() bool {
+	select {
+	case <-l.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// send writes a command to the master
+func (l *replicaLink) send(conn net.Conn, args ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_ = conn.SetWriteDeadline(time.Now().Add(replTimeout))
+	_, err := conn.Write(reply.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes())
+	return err
+}
+
+// IsReplica reports whether the server replicates a master
+func (d *StandaloneDatabase) IsReplica() bool {
+	return d.master.Load() != nil
+}
+
+// execReplicaOf implements the REPLICAOF command, and SLAVEOF its alias
+// REPLICAOF host port replicates the master at host:port, discarding the dataset at the first sync
+// REPLICAOF NO ONE stops replicating and makes the server a master keeping its dataset
+func execReplicaOf(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("replicaof")
+	}
+	if strings.EqualFold(string(args[0]), "no") && strings.EqualFold(string(args[1]), "one") {
+		if old := d.master.Swap(nil); old != nil {
+			old.close()
+			// the replicas of the former master, if any, must not resume its stream from this node
+			d.replID.Store(newReplID())
+			logger.Info("replication stopped, the server is a master with the replication ID " + d.ReplID())
+		}
+		return reply.MakeOKReply()
+	}
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return reply.MakeStandardErrorReply("ERR Invalid master port")
+	}
+	if old := d.master.Load(); old != nil && old.host == string(args[0]) && old.port == port {
+		return reply.MakeStatusReply("OK Already connected to specified master")
+	}
+	d.startReplication(string(args[0]), port)
+	return reply.MakeOKReply()
+}
+
+// startReplication replaces the link to the master by a link to host:port
+func (d *StandaloneDatabase) startReplication(host string, port int) {
+	link := &replicaLink{
+		host:   host,
+		port:   port,
+		stop:   make(chan struct{}),
+		client: &connection.Connection{},
+	}
+	if old := d.master.Swap(link); old != nil {
+		old.close()
+	}
+	// the replicas of this node would not follow the dataset of the new master
+	d.repl.dropAll()
+	logger.Info("replicating the master " + link.addr())
+	go d.runReplicaLink(link)
+}
+
+// parseReplicaOf parses the replicaof option, host and port separated by a space
+func parseReplicaOf(value string) (string, int, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return "", 0, errors.New("replicaof expects <host> <port>, got " + value)
+	}
+	port, err := strconv.Atoi(fields[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, errors.New("replicaof: invalid port " + fields[1])
+	}
+	return fields[0], port, nil
+}
+
+// runReplicaLink keeps the link to the master until it is stopped
+func (d *StandaloneDatabase) runReplicaLink(l *replicaLink) {
+	for {
+		err := d.syncWithMaster(l)
+		if l.stopped() {
+			return
+		}
+		logger.Warn("replication: link to the master " + l.addr() + " lost: " + err.Error())
+		select {
+		case <-l.stop:
+			return
+		case <-d.closed:
+			return
+		case <-time.After(replRetryInterval):
+		}
+	}
+}
+
+// syncWithMaster connects to the master, resyncs and applies the stream until the link is lost
+func (d *StandaloneDatabase) syncWithMaster(l *replicaLink) error {
+	conn, err := net.DialTimeout("tcp", l.addr(), replTimeout)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.conn = conn
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.conn = nil
+		l.mu.Unlock()
+		_ = conn.Close()
+		l.connected.Store(false)
+		l.syncing.Store(false)
+	}()
+	if l.stopped() {
+		return errors.New("replication stopped")
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(replTimeout))
+	reader := bufio.NewReader(conn)
+	handshake := [][]string{{"PING"}}
+	if config.Properties.MasterAuth != "" {
+		handshake = append(handshake, []string{"AUTH", config.Properties.MasterAuth})
+	}
+	handshake = append(handshake,
+		[]string{"REPLCONF", "listening-port", strconv.Itoa(config.Properties.Port)},
+		[]string{"REPLCONF", "capa", "psync2"},
+	)
+	for _, cmd := range handshake {
+		if err := l.send(conn, cmd...); err != nil {
+			return err
+		}
+		line, err := readReplyLine(reader)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "-") {
+			return errors.New(cmd[0] + ": " + line[1:])
+		}
+	}
+	l.mu.Lock()
+	replID, offset := l.replID, l.offset.Load()+1
+	l.mu.Unlock()
+	if replID == "" {
+		replID, offset = "?", -1
+	}
+	if err := l.send(conn, "PSYNC", replID, strconv.FormatInt(offset, 10)); err != nil {
+		return err
+	}
+	line, err := readReplyLine(reader)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+	switch {
+	case fields[0] == "+FULLRESYNC" && len(fields) == 3:
+		masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return errors.New("invalid offset in " + line)
+		}
+		if err := d.loadMasterSnapshot(l, conn, reader); err != nil {
+			return err
+		}
+		l.mu.Lock()
+		l.replID = fields[1]
+		l.offset.Store(masterOffset)
+		l.mu.Unlock()
+	case fields[0] == "+CONTINUE":
+		if len(fields) == 2 {
+			l.mu.Lock()
+			l.replID = fields[1]
+			l.mu.Unlock()
+		}
+		logger.Info("replication: partial resync with the master " + l.addr())
+	default:
+		return errors.New("PSYNC: " + line)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return d.applyStream(l, conn, reader)
+}
+
+// readReplyLine reads a single line reply of the master
+func readReplyLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", errors.New("empty reply from the master")
+	}
+	return line, nil
+}
+
+// loadMasterSnapshot reads the snapshot of a full resync and replaces the dataset with it
+func (d *StandaloneDatabase) loadMasterSnapshot(l *replicaLink, conn net.Conn, reader *bufio.Reader) error {
+	l.syncing.Store(true)
+	defer l.syncing.Store(false)
+	// the master sends newlines while it writes the snapshot
+	var header string
+	for header == "" {
+		_ = conn.SetReadDeadline(time.Now().Add(replTimeout))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		l.lastIO.Store(time.Now().Unix())
+		header = strings.TrimRight(line, "\r\n")
+	}
+	size, err := strconv.ParseInt(strings.TrimPrefix(header, "$"), 10, 64)
+	if !strings.HasPrefix(header, "$") || err != nil || size < 0 {
+		return errors.New("invalid snapshot header " + header)
+	}
+	// the snapshot may take longer than replTimeout for a large dataset, the deadline is moved
+	// as long as data is received
+	body := io.LimitReader(&deadlineReader{conn: conn, reader: reader, lastIO: &l.lastIO}, size)
+	staged, err := d.readRDB(body)
+	if err != nil {
+		return errors.New("load the snapshot of the master: " + err.Error())
+	}
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return err
+	}
+	if err := checkStagedIntegrity(staged, "snapshot of the master "+l.addr()); err != nil {
+		return err
+	}
+	resume := d.pauseWrites()
+	d.replaceDataset(staged)
+	resume()
+	logger.Info("replication: full resync with the master " + l.addr() + ", " + strconv.FormatInt(size, 10) + " bytes of snapshot")
+	if d.aofHandler.Load() != nil {
+		// the AOF holds the former dataset, it is replaced by the new one
+		_ = d.rewriteAof()
+	}
+	return nil
+}
+
+// deadlineReader reads from the master, failing if nothing is received for replTimeout
+type deadlineReader struct {
+	conn   net.Conn
+	reader io.Reader
+	lastIO *atomic.Int64
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	_ = r.conn.SetReadDeadline(time.Now().Add(replTimeout))
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.lastIO.Store(time.Now().Unix())
+	}
+	return n, err
+}
+
+// applyStream applies the write commands of the master until the link is lost
+func (d *StandaloneDatabase) applyStream(l *replicaLink, conn net.Conn, reader *bufio.Reader) error {
+	l.connected.Store(true)
+	l.lastIO.Store(time.Now().Unix())
+	logger.Info("replication: connected to the master " + l.addr())
+	ch := parser.ParseStream(&deadlineReader{conn: conn, reader: reader, lastIO: &l.lastIO})
+	defer func() {
+		// the parser stops once the connection is closed by syncWithMaster
+		go func() {
+			for range ch {
+			}
+		}()
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(replAckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = l.send(conn, "REPLCONF", "ACK", strconv.FormatInt(l.offset.Load(), 10))
+			}
+		}
+	}()
+
+	client := l.client
+	for payload := range ch {
+		if payload.Err != nil {
+			return payload.Err
+		}
+		cmd, ok := payload.Data.(*reply.MultiBulkReply)
+		if !ok || len(cmd.Args) == 0 {
+			return errors.New("unexpected data in the stream of the master")
+		}
+		// the master writes the commands in the encoding of MultiBulkReply, the offset counts
+		// the bytes of the stream
+		size := int64(len(cmd.ToBytes()))
+		cmdName := strings.ToLower(string(cmd.Args[0]))
+		switch {
+		case cmdName == "ping":
+		case cmdName == "select" && len(cmd.Args) == 2:
+			if errReply, ok := execSelect(client, d, cmd.Args[1:]).(reply.ErrorReply); ok {
+				return errors.New("SELECT " + string(cmd.Args[1]) + ": " + errReply.Error())
+			}
+		case cmdName == "replconf":
+			// REPLCONF GETACK *, the acknowledgement counts the command itself
+			l.offset.Add(size)
+			if err := l.send(conn, "REPLCONF", "ACK", strconv.FormatInt(l.offset.Load(), 10)); err != nil {
+				return err
+			}
+			continue
+		default:
+			d.execReplicated(client, cmd.Args)
+		}
+		l.offset.Add(size)
+	}
+	return io.EOF
+}
+
+// execReplicated applies a write command received from the master, skipping the checks of the
+// commands of clients
+func (d *StandaloneDatabase) execReplicated(client *connection.Connection, args [][]byte) {
+	d.writes.RLock()
+	defer d.writes.RUnlock()
+	if errReply, ok := d.getDB(client.GetDBIndex()).Exec(client, args).(reply.ErrorReply); ok {
+		logger.Warn("replication: " + string(args[0]) + " of the master failed: " + errReply.Error())
+	}
+}
+
+func infoReplicaReplication(d *StandaloneDatabase, l *replicaLink, sb *strings.Builder) {
+	status, syncing := "down", 0
+	if l.connected.Load() {
+		status = "up"
+	}
+	if l.syncing.Load() {
+		syncing = 1
+	}
+	lastIO := int64(-1)
+	if t := l.lastIO.Load(); t > 0 {
+		lastIO = time.Now().Unix() - t
+	}
+	sb.WriteString("master_host:" + l.host + "\r\n")
+	sb.WriteString("master_port:" + strconv.Itoa(l.port) + "\r\n")
+	sb.WriteString("master_link_status:" + status + "\r\n")
+	sb.WriteString("master_last_io_seconds_ago:" + strconv.FormatInt(lastIO, 10) + "\r\n")
+	sb.WriteString("master_sync_in_progress:" + strconv.Itoa(syncing) + "\r\n")
+	sb.WriteString("slave_repl_offset:" + strconv.FormatInt(l.offset.Load(), 10) + "\r\n")
+	sb.WriteString("slave_read_only:1\r\n")
+}
@@ -0,0 +1,555 @@
+package database
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"redigo/config"
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A replica connects to its master like a client and sends PSYNC:
+//   - if the replication ID of the master is the one the replica followed and the backlog still
+//     holds the commands after the offset of the replica, the master replies +CONTINUE and sends
+//     these commands (partial resync)
+//   - otherwise the master replies +FULLRESYNC with its offset followed by a snapshot of the
+//     dataset in the RDB format at that offset, written while the writes go on (full resync)
+//
+// The snapshot is written to a temporary file first since its size comes before it, the master
+// sends a newline every second meanwhile so that the replica does not time out
+//
+// The master then sends on the connection every write command, in the RESP encoding of the AOF,
+// with a SELECT whenever the DB changes. The offset of the master counts the bytes of this
+// stream, a replica acknowledges the offset it applied with REPLCONF ACK
+//
+// The stream starts, and the offset moves, once the first replica connected
+
+// defaultReplBacklogSize is the size of the backlog when repl-backlog-size is not set, that of Redis
+const defaultReplBacklogSize = 1024 * 1024
+
+// replicaBufferSize is the number of chunks of the stream waiting for a replica, a replica
+// falling further behind is disconnected and has to resync
+const replicaBufferSize = 1 << 16
+
+// snapshotKeepaliveInterval is how often the master sends a newline to a replica while the
+// snapshot of its full resync is being written
+const snapshotKeepaliveInterval = time.Second
+
+// replPingInterval is how often the master pings its replicas, so that they detect a dead link
+const replPingInterval = 10 * time.Second
+
+// replica is a replica connected to the master
+type replica struct {
+	conn resp.Connection
+	// ip and port locate the replica, port is the one it listens on once it announced it
+	ip   string
+	port int
+	// out holds the chunks of the stream until the writer sends them
+	out       chan []byte
+	ackOffset atomic.Int64
+	// ackTime is the unix time of the last acknowledgement
+	ackTime atomic.Int64
+	// first sends what comes before the stream, the snapshot of a full resync
+	first     func() error
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// writeLoop sends the stream to the replica until it is dropped
+func (r *replica) writeLoop() {
+	if r.first != nil {
+		if err := r.first(); err != nil {
+			logger.Error("full resync of replica " + r.ip + ":" + strconv.Itoa(r.port) + " failed: " + err.Error())
+			r.drop()
+			return
+		}
+	}
+	for {
+		select {
+		case chunk := <-r.out:
+			if err := r.conn.Write(chunk); err != nil {
+				r.drop()
+				return
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// drop stops sending the stream and closes the connection of the replica
+func (r *replica) drop() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		if closer, ok := r.conn.(interface{ Close() error }); ok {
+			go func() {
+				_ = closer.Close()
+			}()
+		}
+	})
+}
+
+// replication is the master side of the replication
+type replication struct {
+	mu sync.Mutex
+	// active is set by the first replica, the stream is not written before
+	active atomic.Bool
+	// offset is the number of bytes of the stream since the start
+	offset atomic.Int64
+	// backlog is a ring buffer holding the last histlen bytes of the stream
+	backlog []byte
+	histlen int
+	// currentDB is the DB of the last command of the stream, -1 if the next one must select its DB
+	currentDB int
+	replicas  map[resp.Connection]*replica
+	// acked is closed and replaced whenever a replica acknowledges an offset
+	acked chan struct{}
+	// waiters are the clients waiting in WAIT, their channel is closed if they disconnect
+	waiters map[resp.Connection]chan struct{}
+	// ports are the ports announced with REPLCONF listening-port by the replicas before PSYNC
+	ports map[resp.Connection]int
+}
+
+func makeReplication() *replication {
+	return &replication{
+		currentDB: -1,
+		replicas:  make(map[resp.Connection]*replica),
+		acked:     make(chan struct{}),
+		waiters:   make(map[resp.Connection]chan struct{}),
+		ports:     make(map[resp.Connection]int),
+	}
+}
+
+// backlogSize returns the size of the backlog from repl-backlog-size
+func backlogSize() int {
+	if config.Properties.ReplBacklogSize > 0 {
+		return config.Properties.ReplBacklogSize
+	}
+	return defaultReplBacklogSize
+}
+
+// feed appends a write command to the stream, r.mu must be held
+func (r *replication) feed(dbIndex int, cmdLine CmdLine) {
+	if dbIndex >= 0 && dbIndex != r.currentDB {
+		r.currentDB = dbIndex
+		r.write(reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(dbIndex))).ToBytes())
+	}
+	r.write(reply.MakeMultiBulkReply(cmdLine).ToBytes())
+}
+
+// write adds bytes to the backlog and sends them to the replicas, r.mu must be held
+func (r *replication) write(chunk []byte) {
+	size := len(r.backlog)
+	pos := int(r.offset.Load() % int64(size))
+	for n := 0; n < len(chunk); {
+		copied := copy(r.backlog[pos:], chunk[n:])
+		n += copied
+		pos = (pos + copied) % size
+	}
+	r.histlen = min(r.histlen+len(chunk), size)
+	r.offset.Add(int64(len(chunk)))
+	for _, rep := range r.replicas {
+		select {
+		case rep.out <- chunk:
+		default:
+			logger.Warn("replica " + rep.ip + ":" + strconv.Itoa(rep.port) + " is too far behind, dropping it")
+			r.remove(rep)
+		}
+	}
+}
+
+// since returns the bytes of the stream from offset on, false if the backlog does not hold them
+// any more, r.mu must be held
+func (r *replication) since(offset int64) ([]byte, bool) {
+	current := r.offset.Load()
+	if !r.active.Load() || offset > current || offset < current-int64(r.histlen) {
+		return nil, false
+	}
+	size := int64(len(r.backlog))
+	n := int(current - offset)
+	data := make([]byte, n)
+	pos := int(offset % size)
+	for copied := 0; copied < n; {
+		c := copy(data[copied:], r.backlog[pos:min(len(r.backlog), pos+n-copied)])
+		copied += c
+		pos = (pos + c) % int(size)
+	}
+	return data, true
+}
+
+// add starts streaming to a replica, r.mu must be held
+func (r *replication) add(rep *replica) {
+	if !r.active.Load() {
+		r.backlog = make([]byte, backlogSize())
+		r.active.Store(true)
+	}
+	r.replicas[rep.conn] = rep
+	go rep.writeLoop()
+}
+
+// remove drops a replica, r.mu must be held
+func (r *replication) remove(rep *replica) {
+	if r.replicas[rep.conn] == rep {
+		delete(r.replicas, rep.conn)
+	}
+	rep.drop()
+}
+
+// disconnect drops the replica on the connection and cancels its WAIT
+func (r *replication) disconnect(conn resp.Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rep, ok := r.replicas[conn]; ok {
+		r.remove(rep)
+	}
+	if cancel, ok := r.waiters[conn]; ok {
+		close(cancel)
+		delete(r.waiters, conn)
+	}
+	delete(r.ports, conn)
+}
+
+// dropAll disconnects all replicas, after the dataset was replaced they have to resync
+func (r *replication) dropAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rep := range r.replicas {
+		r.remove(rep)
+	}
+}
+
+// ack records the offset acknowledged by a replica
+func (r *replication) ack(conn resp.Connection, offset int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep, ok := r.replicas[conn]
+	if !ok {
+		return
+	}
+	rep.ackTime.Store(time.Now().Unix())
+	if offset > rep.ackOffset.Load() {
+		rep.ackOffset.Store(offset)
+		close(r.acked)
+		r.acked = make(chan struct{})
+	}
+}
+
+// ackedReplicas returns the number of replicas which acknowledged the offset, r.mu must be held
+func (r *replication) ackedReplicas(offset int64) int {
+	n := 0
+	for _, rep := range r.replicas {
+		if rep.ackOffset.Load() >= offset {
+			n++
+		}
+	}
+	return n
+}
+
+// startHeartbeat pings the replicas until closed is closed
+func (d *StandaloneDatabase) startHeartbeat() {
+	go func() {
+		ticker := time.NewTicker(replPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.closed:
+				return
+			case <-ticker.C:
+				d.repl.mu.Lock()
+				if len(d.repl.replicas) > 0 {
+					d.repl.feed(-1, utils.ToCmdLine("PING"))
+				}
+				d.repl.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// replicate appends a write command to the replication stream once a replica connected
+func (d *StandaloneDatabase) replicate(dbIndex int, cmdLine CmdLine) {
+	if !d.repl.active.Load() {
+		return
+	}
+	d.repl.mu.Lock()
+	d.repl.feed(dbIndex, cmdLine)
+	d.repl.mu.Unlock()
+}
+
+// execPSync implements the PSYNC command sent by a replica, the replies and the stream are
+// written to the connection directly
+// PSYNC replicationid offset
+func execPSync(d *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("psync")
+	}
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	if d.master.Load() != nil {
+		return reply.MakeStandardErrorReply("ERR chained replication is not supported, connect to the master")
+	}
+	rep := &replica{
+		conn: c,
+		out:  make(chan []byte, replicaBufferSize),
+		done: make(chan struct{}),
+	}
+	rep.ip, rep.port = remoteAddr(c)
+	rep.ackTime.Store(time.Now().Unix())
+	d.repl.mu.Lock()
+	if port, ok := d.repl.ports[c]; ok {
+		rep.port = port
+	}
+	// the offset sent by a replica is the next byte it expects, like Redis
+	if string(args[0]) == d.ReplID() {
+		if backlog, ok := d.repl.since(offset - 1); ok {
+			rep.ackOffset.Store(offset - 1)
+			rep.out <- []byte("+CONTINUE " + d.ReplID() + "\r\n")
+			if len(backlog) > 0 {
+				rep.out <- backlog
+			}
+			d.repl.add(rep)
+			d.repl.mu.Unlock()
+			logger.Info("partial resync of replica " + rep.ip + ":" + strconv.Itoa(rep.port) +
+				", " + strconv.Itoa(len(backlog)) + " bytes from the backlog")
+			return reply.MakeNoReply()
+		}
+	}
+	d.repl.mu.Unlock()
+	return d.fullSync(rep)
+}
+
+// fullSync sends a snapshot of the dataset to the replica, followed by the stream from the
+// offset of the snapshot
+func (d *StandaloneDatabase) fullSync(rep *replica) resp.Reply {
+	resume := d.pauseWrites()
+	snap := d.startSnapshot(encodeRDBKeys)
+	d.repl.mu.Lock()
+	// the replica selects its DB with the first command it receives
+	d.repl.currentDB = -1
+	offset := d.repl.offset.Load()
+	rep.first = func() error {
+		defer snap.end()
+		return d.sendSnapshot(rep, snap, offset)
+	}
+	// the stream is queued from now on, it is sent after the snapshot
+	d.repl.add(rep)
+	d.repl.mu.Unlock()
+	resume()
+	return reply.MakeNoReply()
+}
+
+// sendSnapshot writes the snapshot to a temporary file, sending a newline to the replica every
+// snapshotKeepaliveInterval meanwhile, then sends the file
+func (d *StandaloneDatabase) sendSnapshot(rep *replica, snap *snapshot, offset int64) error {
+	if err := rep.conn.Write([]byte("+FULLRESYNC " + d.ReplID() + " " + strconv.FormatInt(offset, 10) + "\r\n")); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(rdbFilename()), "temp-sync-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	if err := writeWithKeepalive(rep, snap, tmp); err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := rep.conn.Write([]byte("$" + strconv.FormatInt(size, 10) + "\r\n")); err != nil {
+		return err
+	}
+	chunk := make([]byte, 64*1024)
+	for {
+		select {
+		case <-rep.done:
+			return errors.New("replica dropped")
+		default:
+		}
+		n, err := tmp.Read(chunk)
+		if n > 0 {
+			if err := rep.conn.Write(chunk[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	logger.Info("full resync of replica " + rep.ip + ":" + strconv.Itoa(rep.port) +
+		", " + strconv.FormatInt(size, 10) + " bytes of snapshot")
+	return nil
+}
+
+// writeWithKeepalive writes the snapshot to w, sending a newline to the replica every
+// snapshotKeepaliveInterval until it is written
+func writeWithKeepalive(rep *replica, snap *snapshot, w io.Writer) error {
+	written := make(chan error, 1)
+	go func() {
+		written <- snap.writeRDB(w)
+	}()
+	ticker := time.NewTicker(snapshotKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-written:
+			return err
+		case <-ticker.C:
+			if err := rep.conn.Write([]byte("\n")); err != nil {
+				<-written
+				return err
+			}
+		}
+	}
+}
+
+// execReplConf implements the REPLCONF command sent by a replica
+// REPLCONF listening-port port
+// REPLCONF capa capability [capa capability ...]
+// REPLCONF ACK offset
+func execReplConf(d *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 0 || port > 65535 {
+				return reply.MakeStandardErrorReply("ERR invalid listening port")
+			}
+			d.repl.mu.Lock()
+			d.repl.ports[c] = port
+			d.repl.mu.Unlock()
+		case "capa":
+		case "ack":
+			// acknowledgements are not replied, they would be mixed with the stream
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if err == nil {
+				d.repl.ack(c, offset)
+			}
+			return reply.MakeNoReply()
+		default:
+			return reply.MakeStandardErrorReply("ERR Unrecognized REPLCONF option: " + string(args[i]))
+		}
+	}
+	return reply.MakeOKReply()
+}
+
+// execWaitReplicas implements WAIT on a master, it replies the number of replicas which
+// acknowledged the writes sent before the command, once numreplicas did or the timeout expired
+// WAIT numreplicas timeout
+func execWaitReplicas(d *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("wait")
+	}
+	numReplicas, timeout, errReply := parseWaitArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	r := d.repl
+	r.mu.Lock()
+	target := r.offset.Load()
+	acked := r.ackedReplicas(target)
+	if int64(acked) >= numReplicas || len(r.replicas) == 0 {
+		r.mu.Unlock()
+		return reply.MakeIntReply(int64(acked))
+	}
+	r.feed(-1, utils.ToCmdLine("REPLCONF", "GETACK", "*"))
+	cancel := make(chan struct{})
+	r.waiters[c] = cancel
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		if r.waiters[c] == cancel {
+			delete(r.waiters, c)
+		}
+		r.mu.Unlock()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		r.mu.Lock()
+		acked = r.ackedReplicas(target)
+		next := r.acked
+		r.mu.Unlock()
+		if int64(acked) >= numReplicas {
+			return reply.MakeIntReply(int64(acked))
+		}
+		select {
+		case <-next:
+		case <-expired:
+			return reply.MakeIntReply(int64(acked))
+		case <-cancel:
+			return reply.MakeIntReply(int64(acked))
+		case <-d.closed:
+			return reply.MakeIntReply(int64(acked))
+		}
+	}
+}
+
+// remoteAddr returns the IP and the port of the peer of a network connection
+func remoteAddr(c resp.Connection) (string, int) {
+	conn, ok := c.(interface{ RemoteAddr() net.Addr })
+	if !ok {
+		return "", 0
+	}
+	host, port, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return "", 0
+	}
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+func infoMasterReplication(d *StandaloneDatabase, sb *strings.Builder) {
+	r := d.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	offset := r.offset.Load()
+	now := time.Now().Unix()
+	sb.WriteString("connected_slaves:" + strconv.Itoa(len(r.replicas)) + "\r\n")
+	i := 0
+	for _, rep := range r.replicas {
+		sb.WriteString("slave" + strconv.Itoa(i) + ":ip=" + rep.ip + ",port=" + strconv.Itoa(rep.port) +
+			",state=online,offset=" + strconv.FormatInt(rep.ackOffset.Load(), 10) +
+			",lag=" + strconv.FormatInt(now-rep.ackTime.Load(), 10) + "\r\n")
+		i++
+	}
+	sb.WriteString("master_replid:" + d.ReplID() + "\r\n")
+	sb.WriteString("master_repl_offset:" + strconv.FormatInt(offset, 10) + "\r\n")
+	active := 0
+	if r.active.Load() {
+		active = 1
+	}
+	sb.WriteString("repl_backlog_active:" + strconv.Itoa(active) + "\r\n")
+	sb.WriteString("repl_backlog_size:" + strconv.Itoa(backlogSize()) + "\r\n")
+	sb.WriteString("repl_backlog_first_byte_offset:" + strconv.FormatInt(offset-int64(r.histlen)+1, 10) + "\r\n")
+	sb.WriteString("repl_backlog_histlen:" + strconv.Itoa(r.histlen) + "\r\n")
+}
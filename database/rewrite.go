@@ -0,0 +1,220 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"redigo/aof"
+	"redigo/config"
+	"redigo/datastruct/hash"
+	"redigo/datastruct/list"
+	"redigo/datastruct/set"
+	"redigo/datastruct/stream"
+	"redigo/datastruct/zset"
+	"redigo/interface/database"
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rewriteItemsPerCmd is the max number of elements of a command of the rewritten AOF, like Redis
+const rewriteItemsPerCmd = 64
+
+// autoRewriteInterval is how often the size of the AOF is checked for an automatic rewrite
+const autoRewriteInterval = time.Second
+
+// errAofOff is returned by rewriteAof while AOF is off
+var errAofOff = errors.New("AOF is off")
+
+// ModuleRewriter is implemented by the data types of modules which can be written to the
+// rewritten AOF, it returns the commands rebuilding the value of key
+type ModuleRewriter interface {
+	ModuleType
+	ModuleRewrite(key string) []CmdLine
+}
+
+// execBGRewriteAof implements the BGREWRITEAOF command
+// BGREWRITEAOF
+func execBGRewriteAof(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("bgrewriteaof")
+	}
+	if err := d.rewriteAof(); err != nil {
+		if errors.Is(err, errAofOff) {
+			return reply.MakeStandardErrorReply("ERR Append only file is disabled, set appendonly yes to rewrite it")
+		}
+		if errors.Is(err, aof.ErrRewriteInProgress) {
+			return reply.MakeStandardErrorReply("ERR Background append only file rewriting already in progress")
+		}
+		return reply.MakeStandardErrorReply("ERR Error trying to rewrite the AOF: " + err.Error())
+	}
+	return reply.MakeStatusReply("Background append only file rewriting started")
+}
+
+// rewriteAof starts a snapshot of the dataset while the writes are paused, then replaces the AOF
+// with it in background
+func (d *StandaloneDatabase) rewriteAof() error {
+	resume := d.pauseWrites()
+	// AOF is turned on and off while the writes are paused
+	aofHandler := d.aofHandler.Load()
+	if aofHandler == nil {
+		resume()
+		return errAofOff
+	}
+	if err := aofHandler.StartRewrite(); err != nil {
+		resume()
+		return err
+	}
+	snap := d.startSnapshot(encodeCommands)
+	resume()
+	go func() {
+		defer snap.end()
+		if err := aofHandler.FinishRewrite(snap.writeCommands); err != nil {
+			logger.Error("AOF rewrite failed: " + err.Error())
+		}
+	}()
+	return nil
+}
+
+// startAutoRewrite rewrites the AOF whenever it grows past auto-aof-rewrite-percentage, until
+// closed is closed
+func (d *StandaloneDatabase) startAutoRewrite() {
+	go func() {
+		ticker := time.NewTicker(autoRewriteInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.closed:
+				return
+			case <-ticker.C:
+				if aofHandler := d.aofHandler.Load(); aofHandler != nil && aofHandler.ShouldRewrite() {
+					size, base := aofHandler.Size()
+					logger.Info("AOF grew from " + strconv.FormatInt(base, 10) + " to " + strconv.FormatInt(size, 10) + " bytes, rewriting it")
+					_ = d.rewriteAof()
+				}
+			}
+		}
+	}()
+}
+
+// setAppendOnly implements CONFIG SET appendonly yes|no
+func setAppendOnly(d *StandaloneDatabase, name, value string) string {
+	var on bool
+	switch strings.ToLower(value) {
+	case "yes":
+		on = true
+	case "no":
+	default:
+		return "argument must be 'yes' or 'no'"
+	}
+	if err := d.setAppendOnly(on); err != nil {
+		logger.Error("turning AOF on failed: " + err.Error())
+		return err.Error()
+	}
+	return ""
+}
+
+// setAppendOnly turns AOF on or off at runtime, both while the writes are paused, so that the AOF
+// gets every write command from the moment it is on and none once it is off
+// Turning it on replaces the AOF file with a snapshot of the dataset, like a rewrite, written
+// while the writes go on, turning it off writes the pending commands and closes the file
+func (d *StandaloneDatabase) setAppendOnly(on bool) error {
+	resume := d.pauseWrites()
+	select {
+	case <-d.closed:
+		resume()
+		return errors.New("the server is shutting down")
+	default:
+	}
+	if on == (d.aofHandler.Load() != nil) {
+		resume()
+		return nil
+	}
+	if !on {
+		d.aofHandler.Swap(nil).Close()
+		_ = config.Set("appendonly", "no")
+		resume()
+		logger.Info("AOF turned off")
+		return nil
+	}
+	aofHandler, err := aof.NewSeededAofHandler(d)
+	if err != nil {
+		resume()
+		return err
+	}
+	snap := d.startSnapshot(encodeCommands)
+	d.aofHandler.Store(aofHandler)
+	resume()
+	defer snap.end()
+	if err := aofHandler.FinishRewrite(snap.writeCommands); err != nil {
+		// turned off meanwhile, or the server is shutting down
+		if d.aofHandler.CompareAndSwap(aofHandler, nil) {
+			aofHandler.Close()
+		}
+		return errors.New("cannot write the AOF file " + aof.Filename() + ": " + err.Error())
+	}
+	_ = config.Set("appendonly", "yes")
+	logger.Info("AOF turned on, " + aof.Filename() + " seeded with the dataset")
+	return nil
+}
+
+// rewriteEntity returns the commands rebuilding the value of key
+func rewriteEntity(key string, entity *database.DataEntity) ([]CmdLine, error) {
+	switch data := entity.Data.(type) {
+	case []byte:
+		return []CmdLine{utils.ToCmdLineWithName("SET", []byte(key), data)}, nil
+	case *list.List:
+		return batchCommands("RPUSH", key, data.Values(), 1), nil
+	case *hash.Hash:
+		args := make([][]byte, 0, 2*data.Len())
+		for field, value := range data.GetAll() {
+			args = append(args, []byte(field), []byte(value))
+		}
+		return batchCommands("HSET", key, args, 2), nil
+	case set.Set:
+		members := data.Members()
+		args := make([][]byte, len(members))
+		for i, member := range members {
+			args[i] = []byte(member)
+		}
+		return batchCommands("SADD", key, args, 1), nil
+	case zset.ZSet:
+		args := make([][]byte, 0, 2*data.Len())
+		data.ForEachByRank(0, -1, false, func(member string, score float64) bool {
+			args = append(args, []byte(strconv.FormatFloat(score, 'g', -1, 64)), []byte(member))
+			return true
+		})
+		return batchCommands("ZADD", key, args, 2), nil
+	case *stream.Stream:
+		all := data.Range(stream.MinID, stream.MaxID, 0)
+		if len(all) == 0 {
+			// an empty stream keeps its last ID, it is created by an entry trimmed at once
+			return []CmdLine{utils.ToCmdLine("XADD", key, "MAXLEN", "0", data.LastID().String(), "x", "y")}, nil
+		}
+		cmdLines := make([]CmdLine, len(all))
+		for i, entry := range all {
+			cmdLines[i] = append(utils.ToCmdLine("XADD", key, entry.ID.String()), entry.Fields()...)
+		}
+		return cmdLines, nil
+	case ModuleRewriter:
+		return data.ModuleRewrite(key), nil
+	}
+	return nil, fmt.Errorf("key %s: type %s cannot be rewritten", key, typeOf(entity))
+}
+
+// batchCommands splits the arguments of a variadic command in commands of at most
+// rewriteItemsPerCmd elements, an element being group arguments
+func batchCommands(name string, key string, args [][]byte, group int) []CmdLine {
+	var cmdLines []CmdLine
+	for len(args) > 0 {
+		n := min(len(args), rewriteItemsPerCmd*group)
+		cmdLine := make(CmdLine, 0, n+2)
+		cmdLine = append(cmdLine, []byte(name), []byte(key))
+		cmdLines = append(cmdLines, append(cmdLine, args[:n]...))
+		args = args[n:]
+	}
+	return cmdLines
+}
@@ -0,0 +1,359 @@
+package database
+
+import (
+	"container/heap"
+	"redigo/datastruct/dict"
+	"redigo/interface/database"
+	"redigo/interface/resp"
+	"redigo/lib/wildcard"
+	"redigo/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SCAN, HSCAN, SSCAN and ZSCAN iterate the keys of a DB or the elements of a value a few at a
+// time. The dict has no stable order, so a cursor is a position in the space of the 64 bits
+// FNV-1a hash of the keys or elements: a call replies the COUNT elements with the smallest
+// hashes from the cursor on and the cursor following the greatest of them, 0 once the end is
+// reached. The hash of an element never changes, so whatever is added or removed between calls:
+//   - an element present during the whole iteration is replied exactly once
+//   - an element added or removed during the iteration may be replied or not
+//
+// The keyspace keeps its keys in buckets ordered by hash, like the high bits of the reverse
+// binary cursor of Redis: SCAN visits the buckets from the one of the cursor on, until it found
+// COUNT keys or visited scanWork times COUNT keys and buckets, so a whole iteration costs O(N)
+// and a call may reply fewer keys than COUNT before the end. The elements not matching MATCH or
+// TYPE are skipped before they are counted. HSCAN, SSCAN and ZSCAN visit the whole value, a call
+// costs O(N log COUNT); no call holds a lock on the keyspace, where KEYS builds its whole reply
+// at once
+
+// defaultScanCount is the number of elements replied by a call without COUNT, that of Redis
+const defaultScanCount = 10
+
+// scanWork bounds the keys and the buckets a call of SCAN visits to this many times COUNT, like
+// the empty buckets of Redis
+const scanWork = 10
+
+// scanOptions are the arguments of the scan commands
+type scanOptions struct {
+	cursor   uint64
+	count    int
+	pattern  *wildcard.Pattern // nil matches everything
+	typeName string            // TYPE of SCAN, empty for any type
+	noValues bool              // NOVALUES of HSCAN
+}
+
+// matches reports whether the key or element s is selected by MATCH
+func (opts *scanOptions) matches(s string) bool {
+	return opts.pattern == nil || opts.pattern.IsMatch(s)
+}
+
+// parseScanArgs parses cursor [MATCH pattern] [COUNT count], followed by TYPE type if withType or
+// NOVALUES if withNoValues
+func parseScanArgs(args [][]byte, withType bool, withNoValues bool) (*scanOptions, resp.Reply) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, reply.MakeStandardErrorReply("ERR invalid cursor")
+	}
+	opts := &scanOptions{cursor: cursor, count: defaultScanCount}
+	for i := 1; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch {
+		case option == "NOVALUES" && withNoValues:
+			opts.noValues = true
+			continue
+		case i+1 >= len(args):
+			return nil, reply.MakeSyntaxErrReply()
+		}
+		value := string(args[i+1])
+		switch {
+		case option == "MATCH":
+			opts.pattern = nil
+			if value != "*" {
+				opts.pattern = wildcard.CompilePattern(value)
+			}
+		case option == "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, reply.MakeSyntaxErrReply()
+			}
+			opts.count = count
+		case option == "TYPE" && withType:
+			opts.typeName = strings.ToLower(value)
+		default:
+			return nil, reply.MakeSyntaxErrReply()
+		}
+		i++
+	}
+	return opts, nil
+}
+
+// scanPosition returns the position of a key or element in the cursor space, its FNV-1a hash,
+// that of the buckets of the keyspace
+func scanPosition(s string) uint64 {
+	return dict.Position(s)
+}
+
+// scanElement is a key, a set member, or a field or member followed by its value or score
+type scanElement struct {
+	pos      uint64
+	name     string
+	value    string
+	hasValue bool
+}
+
+// scanHeap is a max heap of positions, its root is the element which leaves first for a smaller one
+type scanHeap []scanElement
+
+func (h scanHeap) Len() int           { return len(h) }
+func (h scanHeap) Less(i, j int) bool { return h[i].pos > h[j].pos }
+func (h scanHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *scanHeap) Push(x any)        { *h = append(*h, x.(scanElement)) }
+func (h *scanHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// scanBatch keeps the count elements with the smallest positions from the cursor on
+type scanBatch struct {
+	cursor   uint64
+	count    int
+	elements scanHeap
+	rest     bool   // an element from the cursor on was left out
+	minRest  uint64 // smallest position of the elements left out
+	end      uint64 // the positions from end on were not visited, 0 if all were
+}
+
+// add offers a key or a member to the batch
+func (b *scanBatch) add(name string) {
+	b.offer(scanElement{pos: scanPosition(name), name: name})
+}
+
+// addPair offers a field or a member with its value or score to the batch
+func (b *scanBatch) addPair(name string, value string) {
+	b.offer(scanElement{pos: scanPosition(name), name: name, value: value, hasValue: true})
+}
+
+func (b *scanBatch) offer(element scanElement) {
+	if element.pos < b.cursor {
+		return
+	}
+	if len(b.elements) < b.count {
+		heap.Push(&b.elements, element)
+		return
+	}
+	if element.pos >= b.elements[0].pos {
+		b.leaveOut(element.pos)
+		return
+	}
+	b.leaveOut(b.elements[0].pos)
+	b.elements[0] = element
+	heap.Fix(&b.elements, 0)
+}
+
+func (b *scanBatch) leaveOut(pos uint64) {
+	if !b.rest || pos < b.minRest {
+		b.minRest = pos
+	}
+	b.rest = true
+}
+
+// result returns the arguments of the elements of the batch and the next cursor
+// The elements sharing the greatest position with an element left out are kept for the next
+// call, so that the next cursor does not skip it. ok is false if that leaves no element, the
+// batch must then be collected again with a greater count
+func (b *scanBatch) result() (args [][]byte, next uint64, ok bool) {
+	sort.Slice(b.elements, func(i, j int) bool { return b.elements[i].pos < b.elements[j].pos })
+	n := len(b.elements)
+	if !b.rest {
+		next = b.end
+	} else {
+		last := b.elements[n-1].pos
+		next = last + 1
+		if b.minRest == last {
+			for n > 0 && b.elements[n-1].pos == last {
+				n--
+			}
+			if n == 0 {
+				return nil, 0, false
+			}
+			next = last
+		}
+	}
+	args = make([][]byte, 0, 2*n)
+	for _, element := range b.elements[:n] {
+		args = append(args, []byte(element.name))
+		if element.hasValue {
+			args = append(args, []byte(element.value))
+		}
+	}
+	return args, next, true
+}
+
+// scan replies a batch of the elements which forEach offers to it, from the cursor of opts on
+// forEach stops once the deadline passed, the scan then replies errDeadlineReply
+func scan(opts *scanOptions, dl *deadline, forEach func(batch *scanBatch)) resp.Reply {
+	count := opts.count
+	for {
+		batch := &scanBatch{cursor: opts.cursor, count: count}
+		forEach(batch)
+		if dl.exceeded() {
+			return errDeadlineReply
+		}
+		if args, next, ok := batch.result(); ok {
+			return makeScanReply(next, args)
+		}
+		count *= 2
+	}
+}
+
+func makeScanReply(cursor uint64, args [][]byte) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.FormatUint(cursor, 10))),
+		reply.MakeMultiBulkReply(args),
+	})
+}
+
+// execScan implements the SCAN command
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func execScan(db *DB, dl *deadline, args [][]byte) resp.Reply {
+	opts, errReply := parseScanArgs(args, true, false)
+	if errReply != nil {
+		return errReply
+	}
+	return scan(opts, dl, func(batch *scanBatch) {
+		work := 0
+		db.data.ScanBuckets(batch.cursor, func(keys []string, end uint64) bool {
+			for _, key := range keys {
+				if dl.visit() {
+					return false
+				}
+				if !opts.matches(key) || db.isExpired(key) {
+					continue
+				}
+				val, ok := db.data.Get(key)
+				if !ok || opts.typeName != "" && typeOf(val.(*database.DataEntity)) != opts.typeName {
+					continue
+				}
+				batch.add(key)
+			}
+			work += 1 + len(keys)
+			if len(batch.elements) >= batch.count || work >= scanWork*batch.count {
+				batch.end = end
+				return false
+			}
+			return true
+		})
+	})
+}
+
+// execHScan implements the HSCAN command, it replies the fields followed by their values
+// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func execHScan(db *DB, dl *deadline, args [][]byte) resp.Reply {
+	key := string(args[0])
+	opts, errReply := parseScanArgs(args[1:], false, true)
+	if errReply != nil {
+		return errReply
+	}
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		hashObj, exists := db.getAsHash(key)
+		if !exists {
+			result = makeScanReply(0, nil)
+			return
+		}
+		if hashObj == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+		result = scan(opts, dl, func(batch *scanBatch) {
+			hashObj.ForEach(func(field, value string) bool {
+				if !opts.matches(field) {
+					return !dl.visit()
+				}
+				if opts.noValues {
+					batch.add(field)
+				} else {
+					batch.addPair(field, value)
+				}
+				return !dl.visit()
+			})
+		})
+	})
+	return result
+}
+
+// execSScan implements the SSCAN command
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func execSScan(db *DB, dl *deadline, args [][]byte) resp.Reply {
+	key := string(args[0])
+	opts, errReply := parseScanArgs(args[1:], false, false)
+	if errReply != nil {
+		return errReply
+	}
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		setObj, errReply := getAsSet(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		if setObj == nil {
+			result = makeScanReply(0, nil)
+			return
+		}
+		result = scan(opts, dl, func(batch *scanBatch) {
+			setObj.ForEach(func(member string) bool {
+				if opts.matches(member) {
+					batch.add(member)
+				}
+				return !dl.visit()
+			})
+		})
+	})
+	return result
+}
+
+// execZScan implements the ZSCAN command, it replies the members followed by their scores
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func execZScan(db *DB, dl *deadline, args [][]byte) resp.Reply {
+	key := string(args[0])
+	opts, errReply := parseScanArgs(args[1:], false, false)
+	if errReply != nil {
+		return errReply
+	}
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		zsetObj, exists := getAsZSet(db, key)
+		if !exists {
+			result = makeScanReply(0, nil)
+			return
+		}
+		if zsetObj == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+		result = scan(opts, dl, func(batch *scanBatch) {
+			zsetObj.ForEachByRank(0, -1, false, func(member string, score float64) bool {
+				if opts.matches(member) {
+					batch.addPair(member, strconv.FormatFloat(score, 'f', -1, 64))
+				}
+				return !dl.visit()
+			})
+		})
+	})
+	return result
+}
+
+func init() {
+	registerCancellableCommand("SCAN", execScan, -2)   // SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+	registerCancellableCommand("HSCAN", execHScan, -3) // HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+	registerCancellableCommand("SSCAN", execSScan, -3) // SSCAN key cursor [MATCH pattern] [COUNT count]
+	registerCancellableCommand("ZSCAN", execZScan, -3) // ZSCAN key cursor [MATCH pattern] [COUNT count]
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"redigo/resp/reply"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// scanStep runs a scan command and returns the next cursor and the elements of the reply
+func scanStep(t *testing.T, db *DB, args ...string) (string, []string) {
+	t.Helper()
+	result := exec(db, args...)
+	r, ok := result.(*reply.MultiRawReply)
+	if !ok || len(r.Replies) != 2 {
+		t.Fatalf("%v: unexpected reply %q", args, result.ToBytes())
+	}
+	var elements []string
+	for _, arg := range r.Replies[1].(*reply.MultiBulkReply).Args {
+		elements = append(elements, string(arg))
+	}
+	return string(r.Replies[0].(*reply.BulkReply).Arg), elements
+}
+
+// scanAll iterates a scan command from cursor 0 to the end, the cursor replaces the "0" of args,
+// between is called after each call
+func scanAll(t *testing.T, db *DB, between func(), args ...string) []string {
+	t.Helper()
+	var all []string
+	cursorAt := -1
+	for i, arg := range args {
+		if arg == "0" {
+			cursorAt = i
+			break
+		}
+	}
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		if calls > 10000 {
+			t.Fatalf("%v does not end", args)
+		}
+		args[cursorAt] = cursor
+		next, elements := scanStep(t, db, args...)
+		all = append(all, elements...)
+		if next == "0" {
+			return all
+		}
+		cursor = next
+		if between != nil {
+			between()
+		}
+	}
+}
+
+// TestScanConcurrentMutation tests that SCAN replies exactly once every key present during the
+// whole iteration, while keys are added and removed between the calls
+func TestScanConcurrentMutation(t *testing.T) {
+	db := MakeDB()
+	for i := 0; i < 500; i++ {
+		exec(db, "SET", "stable:"+strconv.Itoa(i), "v")
+		exec(db, "SET", "removed:"+strconv.Itoa(i), "v")
+	}
+	step := 0
+	keys := scanAll(t, db, func() {
+		for i := 0; i < 10; i++ {
+			exec(db, "DEL", "removed:"+strconv.Itoa(step*10+i))
+			exec(db, "SET", "added:"+strconv.Itoa(step*10+i), "v")
+		}
+		step++
+	}, "SCAN", "0", "COUNT", "7")
+
+	seen := make(map[string]int)
+	for _, key := range keys {
+		seen[key]++
+	}
+	for i := 0; i < 500; i++ {
+		if n := seen["stable:"+strconv.Itoa(i)]; n != 1 {
+			t.Errorf("stable:%d replied %d times", i, n)
+		}
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("%s replied %d times", key, n)
+		}
+	}
+}
+
+// TestScanOptions tests MATCH, COUNT and TYPE of SCAN and the skipping of the expired keys
+func TestScanOptions(t *testing.T) {
+	db := MakeDB()
+	for i := 0; i < 30; i++ {
+		exec(db, "SET", "user:"+strconv.Itoa(i), "v")
+	}
+	exec(db, "HSET", "user:hash", "f", "v")
+	exec(db, "SADD", "other", "m")
+	exec(db, "SET", "user:expired", "v", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	if keys := scanAll(t, db, nil, "SCAN", "0", "MATCH", "user:*"); len(keys) != 31 {
+		t.Errorf("MATCH user:* replied %d keys, expected 31", len(keys))
+	}
+	if keys := scanAll(t, db, nil, "SCAN", "0", "TYPE", "hash"); len(keys) != 1 || keys[0] != "user:hash" {
+		t.Errorf("TYPE hash replied %v", keys)
+	}
+	if keys := scanAll(t, db, nil, "SCAN", "0", "MATCH", "user:*", "TYPE", "set"); len(keys) != 0 {
+		t.Errorf("MATCH user:* TYPE set replied %v", keys)
+	}
+	if cursor, keys := scanStep(t, db, "SCAN", "0", "COUNT", "5"); cursor == "0" || len(keys) != 5 {
+		t.Errorf("COUNT 5 replied cursor %s and %d keys", cursor, len(keys))
+	}
+	if cursor, keys := scanStep(t, db, "SCAN", "0", "COUNT", "100"); cursor != "0" || len(keys) != 32 {
+		t.Errorf("COUNT 100 replied cursor %s and %d keys", cursor, len(keys))
+	}
+
+	assertReply(t, exec(db, "SCAN", "x"), "-ERR invalid cursor\r\n")
+	assertReply(t, exec(db, "SCAN", "0", "COUNT", "0"), "-ERR syntax error\r\n")
+	assertReply(t, exec(db, "SCAN", "0", "COUNT", "x"), "-ERR value is not an integer or out of range\r\n")
+	assertReply(t, exec(db, "SCAN", "0", "MATCH"), "-ERR syntax error\r\n")
+	assertReply(t, exec(db, "SCAN", "0", "NOVALUES"), "-ERR syntax error\r\n")
+}
+
+// TestScanBoundedWork tests that a call of SCAN visits a part of the keyspace, so that a MATCH
+// selecting no key replies a cursor to resume from instead of iterating every key
+func TestScanBoundedWork(t *testing.T) {
+	db := MakeDB()
+	for i := 0; i < 10000; i++ {
+		exec(db, "SET", "key:"+strconv.Itoa(i), "v")
+	}
+	cursor, keys := scanStep(t, db, "SCAN", "0", "MATCH", "none:*")
+	if cursor == "0" || len(keys) != 0 {
+		t.Errorf("Expected a cursor to resume from and no key, got %s and %v", cursor, keys)
+	}
+	calls := 0
+	all := scanAll(t, db, func() { calls++ }, "SCAN", "0", "COUNT", "100")
+	if len(all) != 10000 || calls < 50 {
+		t.Errorf("Expected the 10000 keys in about 100 calls, got %d keys in %d calls", len(all), calls+1)
+	}
+}
+
+// TestContainerScans tests HSCAN, SSCAN and ZSCAN on small and large values
+func TestContainerScans(t *testing.T) {
+	db := MakeDB()
+	for _, n := range []int{5, 1000} {
+		hashKey, setKey, zsetKey := "hash"+strconv.Itoa(n), "set"+strconv.Itoa(n), "zset"+strconv.Itoa(n)
+		for i := 0; i < n; i++ {
+			exec(db, "HSET", hashKey, "f"+strconv.Itoa(i), "v"+strconv.Itoa(i))
+			exec(db, "SADD", setKey, "m"+strconv.Itoa(i))
+			exec(db, "ZADD", zsetKey, strconv.Itoa(i), "m"+strconv.Itoa(i))
+		}
+
+		pairs := scanAll(t, db, nil, "HSCAN", hashKey, "0", "COUNT", "3")
+		if len(pairs) != 2*n {
+			t.Fatalf("HSCAN %s replied %d elements", hashKey, len(pairs))
+		}
+		for i := 0; i < len(pairs); i += 2 {
+			if "v"+pairs[i][1:] != pairs[i+1] {
+				t.Errorf("HSCAN %s replied %s %s", hashKey, pairs[i], pairs[i+1])
+			}
+		}
+		if fields := scanAll(t, db, nil, "HSCAN", hashKey, "0", "NOVALUES"); len(fields) != n {
+			t.Errorf("HSCAN %s NOVALUES replied %d elements", hashKey, len(fields))
+		}
+
+		seen := make(map[string]bool)
+		for _, member := range scanAll(t, db, nil, "SSCAN", setKey, "0", "COUNT", "3") {
+			seen[member] = true
+		}
+		if len(seen) != n {
+			t.Errorf("SSCAN %s replied %d members", setKey, len(seen))
+		}
+
+		pairs = scanAll(t, db, nil, "ZSCAN", zsetKey, "0", "COUNT", "3")
+		if len(pairs) != 2*n {
+			t.Fatalf("ZSCAN %s replied %d elements", zsetKey, len(pairs))
+		}
+		for i := 0; i < len(pairs); i += 2 {
+			if pairs[i][1:] != pairs[i+1] {
+				t.Errorf("ZSCAN %s replied %s %s", zsetKey, pairs[i], pairs[i+1])
+			}
+		}
+	}
+
+	if members := scanAll(t, db, nil, "SSCAN", "set1000", "0", "MATCH", "m99*"); len(members) != 11 {
+		t.Errorf("SSCAN MATCH m99* replied %v", members)
+	}
+	assertReply(t, exec(db, "HSCAN", "missing", "0"), "*2\r\n$1\r\n0\r\n*0\r\n")
+	wrongType := string(reply.MakeWrongTypeErrReply().ToBytes())
+	assertReply(t, exec(db, "ZSCAN", "hash5", "0"), wrongType)
+	assertReply(t, exec(db, "SSCAN", "hash5", "0"), wrongType)
+}
+
+// TestScanBatchCollisions tests that the elements sharing a position are replied by the same call
+func TestScanBatchCollisions(t *testing.T) {
+	positions := []uint64{1, 2, 3, 3, 3, 4}
+	var cursor uint64
+	var replied []string
+	for calls := 0; calls < 10; calls++ {
+		r := scan(&scanOptions{cursor: cursor, count: 2}, nil, func(batch *scanBatch) {
+			for i, pos := range positions {
+				batch.offer(scanElement{pos: pos, name: strconv.Itoa(i)})
+			}
+		})
+		raw := r.(*reply.MultiRawReply).Replies
+		for _, arg := range raw[1].(*reply.MultiBulkReply).Args {
+			replied = append(replied, string(arg))
+		}
+		next, _ := strconv.ParseUint(string(raw[0].(*reply.BulkReply).Arg), 10, 64)
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(replied) != len(positions) {
+		t.Fatalf("replied %v, expected the %d elements", replied, len(positions))
+	}
+	seen := make(map[string]bool)
+	for _, element := range replied {
+		if seen[element] {
+			t.Errorf("%s replied twice", element)
+		}
+		seen[element] = true
+	}
+}
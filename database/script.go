@@ -0,0 +1,443 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"redigo/config"
+	"redigo/interface/resp"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// defaultLuaTimeLimit is the execution time limit of a script in milliseconds when
+// lua-time-limit is not set
+const defaultLuaTimeLimit = 5000
+
+var (
+	noScriptErrReply      = reply.MakeStandardErrorReply("NOSCRIPT No matching script. Please use EVAL.")
+	scriptCommandErrReply = reply.MakeStandardErrorReply("ERR This Redis command is not allowed from script")
+)
+
+// scriptCache holds the scripts run by EVAL or loaded by SCRIPT LOAD, compiled once and found by
+// the SHA1 of their source; like Redis, the scripts are shared by the databases
+type scriptCache struct {
+	mu      sync.RWMutex
+	scripts map[string]*lua.FunctionProto
+}
+
+var scripts = &scriptCache{scripts: make(map[string]*lua.FunctionProto)}
+
+// load compiles the script unless it is cached and returns its SHA1
+func (c *scriptCache) load(source []byte) (string, *lua.FunctionProto, reply.ErrorReply) {
+	sum := sha1.Sum(source)
+	sha := hex.EncodeToString(sum[:])
+	if proto, ok := c.get(sha); ok {
+		return sha, proto, nil
+	}
+	chunk, err := parse.Parse(bytes.NewReader(source), "user_script")
+	if err != nil {
+		return "", nil, reply.MakeStandardErrorReply("ERR Error compiling script (new function): " + firstLine(err.Error()))
+	}
+	proto, err := lua.Compile(chunk, "user_script")
+	if err != nil {
+		return "", nil, reply.MakeStandardErrorReply("ERR Error compiling script (new function): " + firstLine(err.Error()))
+	}
+	c.mu.Lock()
+	c.scripts[sha] = proto
+	c.mu.Unlock()
+	return sha, proto, nil
+}
+
+// get returns the compiled script of the SHA1
+func (c *scriptCache) get(sha string) (*lua.FunctionProto, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	proto, ok := c.scripts[strings.ToLower(sha)]
+	return proto, ok
+}
+
+// flush removes every script
+func (c *scriptCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts = make(map[string]*lua.FunctionProto)
+}
+
+// firstLine returns the first line of an error message of the Lua parser
+func firstLine(message string) string {
+	line, _, _ := strings.Cut(message, "\n")
+	return line
+}
+
+// execEval implements EVAL script numkeys [key ...] [arg ...]
+func execEval(db *DB, args [][]byte) resp.Reply {
+	_, proto, errReply := scripts.load(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	return runScript(db, proto, args[1:])
+}
+
+// execEvalSha implements EVALSHA sha1 numkeys [key ...] [arg ...], which runs a cached script
+func execEvalSha(db *DB, args [][]byte) resp.Reply {
+	proto, ok := scripts.get(string(args[0]))
+	if !ok {
+		return noScriptErrReply
+	}
+	return runScript(db, proto, args[1:])
+}
+
+// execScript implements SCRIPT LOAD script, SCRIPT EXISTS sha1 [sha1 ...] and SCRIPT FLUSH [ASYNC|SYNC]
+func execScript(db *DB, args [][]byte) resp.Reply {
+	switch strings.ToUpper(string(args[0])) {
+	case "LOAD":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("script|load")
+		}
+		sha, _, errReply := scripts.load(args[1])
+		if errReply != nil {
+			return errReply
+		}
+		return reply.MakeBulkReply([]byte(sha))
+	case "EXISTS":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply("script|exists")
+		}
+		result := make([]resp.Reply, len(args)-1)
+		for i, sha := range args[1:] {
+			if _, ok := scripts.get(string(sha)); ok {
+				result[i] = reply.MakeIntReply(1)
+			} else {
+				result[i] = reply.MakeIntReply(0)
+			}
+		}
+		return reply.MakeMultiRawReply(result)
+	case "FLUSH":
+		if len(args) > 2 {
+			return reply.MakeArgNumErrReply("script|flush")
+		}
+		if len(args) == 2 {
+			if mode := strings.ToUpper(string(args[1])); mode != "ASYNC" && mode != "SYNC" {
+				return reply.MakeSyntaxErrReply()
+			}
+		}
+		scripts.flush()
+		return reply.MakeOKReply()
+	}
+	return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[0]) + "'. Try SCRIPT LOAD, SCRIPT EXISTS or SCRIPT FLUSH.")
+}
+
+// evalKeys returns the keys of EVAL and EVALSHA, the numkeys arguments after numkeys
+func evalKeys(args [][]byte) [][]byte {
+	if len(args) < 3 {
+		return nil
+	}
+	numKeys, err := strconv.Atoi(string(args[2]))
+	if err != nil || numKeys < 0 || numKeys > len(args)-3 {
+		return nil
+	}
+	return args[3 : 3+numKeys]
+}
+
+// runScript runs the script with numkeys [key ...] [arg ...]
+// The keys are locked during the whole script, so that no other client sees the keys between two
+// of its commands; the commands may only access these keys, so that a script never waits for a
+// key locked by another one
+// A script running for more than lua-time-limit is stopped, the commands it executed are kept
+func runScript(db *DB, proto *lua.FunctionProto, args [][]byte) resp.Reply {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	if numKeys < 0 {
+		return reply.MakeStandardErrorReply("ERR Number of keys can't be negative")
+	}
+	if numKeys > len(args)-1 {
+		return reply.MakeStandardErrorReply("ERR Number of keys can't be greater than number of args")
+	}
+	keys := make([]string, numKeys)
+	for i, key := range args[1 : 1+numKeys] {
+		keys[i] = string(key)
+	}
+
+	var result resp.Reply
+	db.WithKeysLock(keys, func() {
+		run := &scriptRun{db: db.scriptView(), declared: make(map[string]struct{}, numKeys)}
+		for _, key := range keys {
+			run.declared[key] = struct{}{}
+		}
+		result = run.call(proto, args[1:1+numKeys], args[1+numKeys:])
+	})
+	return result
+}
+
+// heldLocks is the keyLocker of the commands of a script, whose keys are locked by the script
+type heldLocks struct{}
+
+func (heldLocks) Lock(string)    {}
+func (heldLocks) Unlock(string)  {}
+func (heldLocks) RLock(string)   {}
+func (heldLocks) RUnlock(string) {}
+
+// scriptView returns the DB on which the commands of a script are executed, it shares the data of
+// db without locking the keys
+func (db *DB) scriptView() *DB {
+	view := *db
+	view.lockMgr = heldLocks{}
+	return &view
+}
+
+// scriptRun is a script being run with its declared keys
+type scriptRun struct {
+	db       *DB
+	declared map[string]struct{}
+}
+
+// call runs the script with the KEYS and ARGV tables and converts its result to a reply
+func (run *scriptRun) call(proto *lua.FunctionProto, keys, argv [][]byte) resp.Reply {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+	run.openLibs(L)
+	L.SetGlobal("KEYS", stringsTable(L, keys))
+	L.SetGlobal("ARGV", stringsTable(L, argv))
+
+	limit := config.Read(func(p *config.ServerProperties) int { return p.LuaTimeLimit })
+	if limit <= 0 {
+		limit = defaultLuaTimeLimit
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(limit)*time.Millisecond)
+	defer cancel()
+	L.SetContext(ctx)
+
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		if ctx.Err() != nil {
+			return reply.MakeStandardErrorReply("ERR Script killed after running for more than " + strconv.Itoa(limit) + " milliseconds (lua-time-limit)")
+		}
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			if table, ok := apiErr.Object.(*lua.LTable); ok {
+				if message, ok := table.RawGetString("err").(lua.LString); ok {
+					return reply.MakeStandardErrorReply(string(message))
+				}
+			}
+			return reply.MakeStandardErrorReply("ERR Error running script: " + firstLine(apiErr.Object.String()))
+		}
+		return reply.MakeStandardErrorReply("ERR Error running script: " + err.Error())
+	}
+	return fromLua(L.Get(-1))
+}
+
+// openLibs opens the base, table, string and math libraries without the functions loading files,
+// and the redis library
+func (run *scriptRun) openLibs(L *lua.LState) {
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "module", "require"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call": func(L *lua.LState) int {
+			return run.redisCall(L, true)
+		},
+		"pcall": func(L *lua.LState) int {
+			return run.redisCall(L, false)
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"error_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			sum := sha1.Sum([]byte(L.CheckString(1)))
+			L.Push(lua.LString(hex.EncodeToString(sum[:])))
+			return 1
+		},
+	})
+	L.SetGlobal("redis", redis)
+}
+
+// redisCall implements redis.call and redis.pcall, which execute a command and return its reply
+// converted to Lua; redis.call raises the error replies, redis.pcall returns them as {err=...}
+func (run *scriptRun) redisCall(L *lua.LState, raise bool) int {
+	n := L.GetTop()
+	if n == 0 {
+		L.RaiseError("Please specify at least one argument for this redis lib call")
+	}
+	cmdLine := make(CmdLine, n)
+	for i := 1; i <= n; i++ {
+		switch arg := L.Get(i).(type) {
+		case lua.LString:
+			cmdLine[i-1] = []byte(arg)
+		case lua.LNumber:
+			cmdLine[i-1] = []byte(strconv.FormatFloat(float64(arg), 'g', 17, 64))
+		default:
+			L.RaiseError("Lua redis lib command arguments must be strings or integers")
+		}
+	}
+	result := toLua(L, run.exec(cmdLine))
+	if table, ok := result.(*lua.LTable); ok && raise && table.RawGetString("err") != lua.LNil {
+		L.Error(table, 1)
+	}
+	L.Push(result)
+	return 1
+}
+
+// exec executes a command of the script, the blocking commands and those of the server are not
+// allowed, nor the keys which are not declared
+func (run *scriptRun) exec(cmdLine CmdLine) resp.Reply {
+	name := commandName(cmdLine[0])
+	cmd, ok := cmdTable[name]
+	if !ok {
+		return reply.MakeStandardErrorReply("ERR Unknown Redis command called from script")
+	}
+	if cmd.blockingExec != nil || name == "eval" || name == "evalsha" || name == "script" {
+		return scriptCommandErrReply
+	}
+	if !cmd.validArgs(cmdLine) {
+		return reply.MakeStandardErrorReply("ERR Wrong number of args calling Redis command from script")
+	}
+	keys, _ := CommandKeys(cmdLine)
+	for _, key := range keys {
+		if _, ok := run.declared[key]; !ok {
+			return reply.MakeStandardErrorReply("ERR Script attempted to access a key not declared in KEYS: " + key)
+		}
+	}
+	return run.db.Exec(nil, cmdLine)
+}
+
+// stringsTable returns the Lua array of the arguments
+func stringsTable(L *lua.LState, args [][]byte) *lua.LTable {
+	table := L.CreateTable(len(args), 0)
+	for _, arg := range args {
+		table.Append(lua.LString(arg))
+	}
+	return table
+}
+
+// replyTable returns the table {ok=message} of a status reply or {err=message} of an error reply
+func replyTable(L *lua.LState, field string, message string) *lua.LTable {
+	table := L.CreateTable(0, 1)
+	table.RawSetString(field, lua.LString(message))
+	return table
+}
+
+// toLua converts a reply to Lua like Redis: integers to numbers, strings to strings, nil to false,
+// arrays to tables, status and error replies to {ok=...} and {err=...}
+// The RESP3 replies are converted to their RESP2 representation first
+func toLua(L *lua.LState, r resp.Reply) lua.LValue {
+	// the elements are read while the script holds the locks of its keys
+	r = reply.ForProtocol(reply.Resolve(r), reply.RESP2)
+	switch r := r.(type) {
+	case *reply.IntReply:
+		return lua.LNumber(r.Code)
+	case *reply.BulkReply:
+		if r.Arg == nil {
+			return lua.LFalse
+		}
+		return lua.LString(r.Arg)
+	case *reply.MultiBulkReply:
+		table := L.CreateTable(len(r.Args), 0)
+		for _, arg := range r.Args {
+			if arg == nil {
+				table.Append(lua.LFalse)
+			} else {
+				table.Append(lua.LString(arg))
+			}
+		}
+		return table
+	case *reply.MultiRawReply:
+		table := L.CreateTable(len(r.Replies), 0)
+		for _, element := range r.Replies {
+			table.Append(toLua(L, element))
+		}
+		return table
+	}
+	raw := r.ToBytes()
+	if len(raw) == 0 {
+		return lua.LFalse
+	}
+	line := strings.TrimSuffix(string(raw[1:]), reply.CRLF)
+	switch raw[0] {
+	case '+':
+		return replyTable(L, "ok", line)
+	case '-':
+		return replyTable(L, "err", line)
+	case ':':
+		n, _ := strconv.ParseInt(line, 10, 64)
+		return lua.LNumber(n)
+	case '$':
+		if strings.HasPrefix(line, "-1") {
+			return lua.LFalse
+		}
+		return lua.LString("")
+	case '*':
+		if strings.HasPrefix(line, "-1") {
+			return lua.LFalse
+		}
+		return L.NewTable()
+	}
+	return lua.LFalse
+}
+
+// fromLua converts the result of a script to a reply like Redis: numbers to integers, strings to
+// strings, true to 1, false and nil to nil, {ok=...} and {err=...} to status and error replies,
+// and the other tables to arrays up to their first nil
+func fromLua(value lua.LValue) resp.Reply {
+	switch value := value.(type) {
+	case lua.LString:
+		return reply.MakeBulkReply([]byte(value))
+	case lua.LNumber:
+		return reply.MakeIntReply(int64(value))
+	case lua.LBool:
+		if value {
+			return reply.MakeIntReply(1)
+		}
+	case *lua.LTable:
+		if message, ok := value.RawGetString("err").(lua.LString); ok {
+			return reply.MakeStandardErrorReply(string(message))
+		}
+		if status, ok := value.RawGetString("ok").(lua.LString); ok {
+			return reply.MakeStatusReply(string(status))
+		}
+		replies := make([]resp.Reply, 0, value.Len())
+		for i := 1; ; i++ {
+			element := value.RawGetInt(i)
+			if element == lua.LNil {
+				break
+			}
+			replies = append(replies, fromLua(element))
+		}
+		return reply.MakeMultiRawReply(replies)
+	}
+	return reply.MakeNullBulkReply()
+}
+
+func init() {
+	RegisterCommand("EVAL", execEval, -3)
+	RegisterCommand("EVALSHA", execEvalSha, -3)
+	RegisterCommand("SCRIPT", execScript, -2)
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"redigo/config"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	db := MakeDB()
+	assertReply(t, exec(db, "EVAL", "return redis.call('SET', KEYS[1], ARGV[1])", "1", "k", "v"), "+OK\r\n")
+	assertReply(t, exec(db, "EVAL", "return redis.call('GET', KEYS[1])", "1", "k"), "$1\r\nv\r\n")
+	assertReply(t, exec(db, "EVAL", "return {KEYS[1], ARGV[1], ARGV[2]}", "1", "k", "a", "b"), "*3\r\n$1\r\nk\r\n$1\r\na\r\n$1\r\nb\r\n")
+
+	// the conversions of the replies to Lua and of the results back
+	assertReply(t, exec(db, "EVAL", "return 3.7", "0"), ":3\r\n")
+	assertReply(t, exec(db, "EVAL", "return true", "0"), ":1\r\n")
+	assertReply(t, exec(db, "EVAL", "return false", "0"), "$-1\r\n")
+	assertReply(t, exec(db, "EVAL", "return {1, 2, nil, 4}", "0"), "*2\r\n:1\r\n:2\r\n")
+	assertReply(t, exec(db, "EVAL", "return redis.status_reply('FINE')", "0"), "+FINE\r\n")
+	assertReply(t, exec(db, "EVAL", "return redis.error_reply('MY error')", "0"), "-MY error\r\n")
+	assertReply(t, exec(db, "EVAL", "return redis.call('GET', KEYS[1]) == false", "1", "missing"), ":1\r\n")
+	assertReply(t, exec(db, "EVAL", "return redis.call('SET', KEYS[1], 'v')['ok']", "1", "k"), "$2\r\nOK\r\n")
+	assertReply(t, exec(db, "EVAL", "return redis.call('INCRBY', KEYS[1], 5) + 1", "1", "n"), ":6\r\n")
+	assertReply(t, exec(db, "EVAL", "redis.call('RPUSH', KEYS[1], 'a', 'b'); return redis.call('LRANGE', KEYS[1], 0, -1)", "1", "list"),
+		"*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+	assertReply(t, exec(db, "EVAL", "return redis.sha1hex('')", "0"), "$40\r\nda39a3ee5e6b4b0d3255bfef95601890afd80709\r\n")
+}
+
+func TestEvalErrors(t *testing.T) {
+	db := MakeDB()
+	exec(db, "RPUSH", "list", "a")
+	assertReply(t, exec(db, "EVAL", "return 1", "-1"), "-ERR Number of keys can't be negative\r\n")
+	assertReply(t, exec(db, "EVAL", "return 1", "2", "k"), "-ERR Number of keys can't be greater than number of args\r\n")
+	assertReply(t, exec(db, "EVAL", "return 1", "x"), "-ERR value is not an integer or out of range\r\n")
+	if r := string(exec(db, "EVAL", "return (", "0").ToBytes()); !strings.HasPrefix(r, "-ERR Error compiling script") {
+		t.Errorf("Expected a compilation error, got %q", r)
+	}
+	if r := string(exec(db, "EVAL", "error('boom')", "0").ToBytes()); !strings.HasPrefix(r, "-ERR Error running script") || !strings.Contains(r, "boom") {
+		t.Errorf("Expected a runtime error, got %q", r)
+	}
+
+	// redis.call raises the error replies, redis.pcall returns them
+	assertReply(t, exec(db, "EVAL", "return redis.call('GET', KEYS[1])", "1", "list"), string(wrongTypeReply(t)))
+	assertReply(t, exec(db, "EVAL", "local r = redis.pcall('GET', KEYS[1]); return r['err'] ~= nil", "1", "list"), ":1\r\n")
+
+	assertReply(t, exec(db, "EVAL", "return redis.call('GET', 'other')", "0"), "-ERR Script attempted to access a key not declared in KEYS: other\r\n")
+	assertReply(t, exec(db, "EVAL", "return redis.call('BLPOP', KEYS[1], 0)", "1", "list"), "-ERR This Redis command is not allowed from script\r\n")
+	assertReply(t, exec(db, "EVAL", "return redis.call('EVAL', 'return 1', 0)", "0"), "-ERR This Redis command is not allowed from script\r\n")
+	assertReply(t, exec(db, "EVAL", "return redis.call('NOSUCH')", "0"), "-ERR Unknown Redis command called from script\r\n")
+	assertReply(t, exec(db, "EVAL", "return redis.call('GET')", "0"), "-ERR Wrong number of args calling Redis command from script\r\n")
+	if r := string(exec(db, "EVAL", "return dofile('/etc/passwd')", "0").ToBytes()); !strings.HasPrefix(r, "-ERR Error running script") {
+		t.Errorf("Expected dofile to be unavailable, got %q", r)
+	}
+}
+
+// wrongTypeReply returns the error of a command on a key of the wrong type
+func wrongTypeReply(t *testing.T) []byte {
+	t.Helper()
+	db := MakeDB()
+	exec(db, "RPUSH", "list", "a")
+	return exec(db, "GET", "list").ToBytes()
+}
+
+func TestEvalSha(t *testing.T) {
+	db := MakeDB()
+	script := "return redis.call('INCR', KEYS[1])"
+	r := exec(db, "SCRIPT", "LOAD", script)
+	sha := strings.Split(string(r.ToBytes()), "\r\n")[1]
+	if len(sha) != 40 {
+		t.Fatalf("Expected a SHA1, got %q", r.ToBytes())
+	}
+	assertReply(t, exec(db, "EVALSHA", sha, "1", "n"), ":1\r\n")
+	assertReply(t, exec(db, "EVALSHA", strings.ToUpper(sha), "1", "n"), ":2\r\n")
+	assertReply(t, exec(db, "SCRIPT", "EXISTS", sha, "0000000000000000000000000000000000000000"), "*2\r\n:1\r\n:0\r\n")
+
+	// EVAL caches the script too
+	exec(db, "EVAL", "return 'cached'", "0")
+	assertReply(t, exec(db, "EVALSHA", "952f49ffc8f7b098d8ab5da45d3164ca36ed18b1", "0"), "$6\r\ncached\r\n")
+
+	assertReply(t, exec(db, "SCRIPT", "FLUSH"), "+OK\r\n")
+	assertReply(t, exec(db, "EVALSHA", sha, "1", "n"), "-NOSCRIPT No matching script. Please use EVAL.\r\n")
+	assertReply(t, exec(db, "SCRIPT", "KILL"), "-ERR unknown subcommand 'KILL'. Try SCRIPT LOAD, SCRIPT EXISTS or SCRIPT FLUSH.\r\n")
+}
+
+func TestEvalAtomic(t *testing.T) {
+	db := MakeDB()
+	var lines []string
+	var mu sync.Mutex
+	db.addAof = func(line CmdLine) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, string(line[0]))
+	}
+	// GET then SET is not atomic without the script, the increments would be lost
+	script := "local v = redis.call('GET', KEYS[1]) or 0; return redis.call('SET', KEYS[1], v + 1)"
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			exec(db, "EVAL", script, "1", "counter")
+		}()
+	}
+	wg.Wait()
+	assertReply(t, exec(db, "GET", "counter"), "$2\r\n50\r\n")
+	// the AOF receives the commands of the scripts
+	if len(lines) != 50 || lines[0] != "SET" {
+		t.Errorf("Expected 50 SET in the AOF, got %v", lines)
+	}
+}
+
+func TestEvalTimeLimit(t *testing.T) {
+	limit := config.Properties.LuaTimeLimit
+	defer func() {
+		config.Properties.LuaTimeLimit = limit
+	}()
+	config.Properties.LuaTimeLimit = 50
+
+	db := MakeDB()
+	assertReply(t, exec(db, "EVAL", "redis.call('SET', KEYS[1], 'v'); while true do end", "1", "k"),
+		"-ERR Script killed after running for more than 50 milliseconds (lua-time-limit)\r\n")
+	// the commands executed before are kept and the key is unlocked
+	assertReply(t, exec(db, "GET", "k"), "$1\r\nv\r\n")
+}
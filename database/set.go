@@ -41,10 +41,7 @@ func execSAdd(db *DB, args [][]byte) resp.Reply {
 		}
 
 		// Add all members
-		count := 0
-		for _, member := range members {
-			count += setObj.Add(string(member))
-		}
+		count := addMembers(setObj, members)
 
 		// Store back to database if it's a new set or any members were added
 		if isNew || count > 0 {
@@ -62,6 +59,16 @@ func execSAdd(db *DB, args [][]byte) resp.Reply {
 	return result
 }
 
+// addMembers adds the members to the set and returns the number of members added, the set
+// starts as an intset and changes its encoding once a member is not an integer or it grows
+func addMembers(setObj set.Set, members [][]byte) int {
+	count := 0
+	for _, member := range members {
+		count += setObj.Add(string(member))
+	}
+	return count
+}
+
 // execSCard implements SCARD key
 // Get the number of members in a set
 func execSCard(db *DB, args [][]byte) resp.Reply {
@@ -118,6 +125,10 @@ func execSMembers(db *DB, args [][]byte) resp.Reply {
 			result = reply.MakeMultiBulkReply([][]byte{})
 			return
 		}
+		if setObj.Len() >= streamMinElements {
+			result = db.elementsReply('*', key, writeSetMembers)
+			return
+		}
 
 		// Convert members to [][]byte
 		members := setObj.Members()
@@ -163,7 +174,7 @@ func execSRem(db *DB, args [][]byte) resp.Reply {
 		if count > 0 {
 			// Check if set is now empty
 			if setObj.Len() == 0 {
-				db.Remove(key)
+				db.removeEmptied(key)
 			} else {
 				// Store updated set
 				db.PutEntity(key, &database.DataEntity{
@@ -232,7 +243,7 @@ func execSPop(db *DB, args [][]byte) resp.Reply {
 
 		// Store updated set or remove if empty
 		if setObj.Len() == 0 {
-			db.Remove(key)
+			db.removeEmptied(key)
 		} else {
 			db.PutEntity(key, &database.DataEntity{
 				Data: setObj,
@@ -325,19 +336,71 @@ func execSRandMember(db *DB, args [][]byte) resp.Reply {
 	return result
 }
 
+// getSets returns the sets of the keys, nil for the keys which do not exist
+// The types of all keys are checked before any computation, as Redis does
+func getSets(db *DB, args [][]byte) ([]set.Set, reply.ErrorReply) {
+	sets := make([]set.Set, len(args))
+	for i, arg := range args {
+		setObj, errReply := getAsSet(db, string(arg))
+		if errReply != nil {
+			return nil, errReply
+		}
+		sets[i] = setObj
+	}
+	return sets, nil
+}
+
+// asIntSets returns the intsets of the sets, a missing set being an empty intset
+// It returns false if one of the sets is a hash table, the operations then go through strings
+func asIntSets(sets []set.Set) ([]*set.IntSet, bool) {
+	intSets := make([]*set.IntSet, len(sets))
+	for i, setObj := range sets {
+		if setObj == nil {
+			intSets[i] = set.NewIntSet()
+			continue
+		}
+		hashSet, ok := setObj.(*set.HashSet)
+		if !ok || !hashSet.IsIntSet() {
+			return nil, false
+		}
+		intSets[i] = hashSet.IntSet()
+	}
+	return intSets, true
+}
+
+// makeIntSetReply returns the values of the intset, sorted
+func makeIntSetReply(is *set.IntSet) resp.Reply {
+	if is.Len() == 0 {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	resultBytes := make([][]byte, 0, is.Len())
+	is.ForEach(func(value int64) bool {
+		resultBytes = append(resultBytes, []byte(strconv.FormatInt(value, 10)))
+		return true
+	})
+	return reply.MakeMultiBulkReply(resultBytes)
+}
+
 // execSUnion implements SUNION key [key...]
 // Return the union of multiple sets
 func execSUnion(db *DB, args [][]byte) resp.Reply {
+	sets, errReply := getSets(db, args)
+	if errReply != nil {
+		return errReply
+	}
+	if intSets, ok := asIntSets(sets); ok {
+		result := set.NewIntSet()
+		for _, is := range intSets {
+			result = result.Union(is)
+		}
+		return makeIntSetReply(result)
+	}
+
 	// Create empty result set
 	result := set.NewHashSet()
 
 	// Process each set
-	for _, arg := range args {
-		key := string(arg)
-		setObj, errReply := getAsSet(db, key)
-		if errReply != nil {
-			return errReply
-		}
+	for _, setObj := range sets {
 		if setObj == nil {
 			continue
 		}
@@ -362,31 +425,7 @@ func execSUnion(db *DB, args [][]byte) resp.Reply {
 // execSUnionStore implements SUNIONSTORE destination key [key...]
 // Store the union of multiple sets in a new set
 func execSUnionStore(db *DB, args [][]byte) resp.Reply {
-	destKey := string(args[0])
-	keys := args[1:]
-
-	// Execute union
-	unionReply := execSUnion(db, keys)
-	if _, ok := unionReply.(reply.ErrorReply); ok {
-		return unionReply
-	}
-
-	// Create new set with union result
-	unionResult := unionReply.(*reply.MultiBulkReply)
-	newSet := set.NewHashSet()
-	for _, member := range unionResult.Args {
-		newSet.Add(string(member))
-	}
-
-	// Store set in database
-	db.PutEntity(destKey, &database.DataEntity{
-		Data: newSet,
-	})
-
-	// Add to AOF
-	db.addAof(utils.ToCmdLineWithName("SUNIONSTORE", args...))
-
-	return reply.MakeIntReply(int64(newSet.Len()))
+	return storeSet(db, "SUNIONSTORE", args, execSUnion(db, args[1:]))
 }
 
 // execSInter implements SINTER key [key...]
@@ -396,12 +435,23 @@ func execSInter(db *DB, args [][]byte) resp.Reply {
 		return reply.MakeEmptyMultiBulkReply()
 	}
 
-	// Get first set as base
-	key := string(args[0])
-	firstSet, errReply := getAsSet(db, key)
+	sets, errReply := getSets(db, args)
 	if errReply != nil {
 		return errReply
 	}
+	if intSets, ok := asIntSets(sets); ok {
+		result := intSets[0]
+		for _, is := range intSets[1:] {
+			if result.Len() == 0 {
+				break
+			}
+			result = result.Intersect(is)
+		}
+		return makeIntSetReply(result)
+	}
+
+	// Get first set as base
+	firstSet := sets[0]
 	if firstSet == nil {
 		return reply.MakeEmptyMultiBulkReply()
 	}
@@ -414,12 +464,7 @@ func execSInter(db *DB, args [][]byte) resp.Reply {
 	})
 
 	// Intersect with each other set
-	for i := 1; i < len(args); i++ {
-		key := string(args[i])
-		currentSet, errReply := getAsSet(db, key)
-		if errReply != nil {
-			return errReply
-		}
+	for _, currentSet := range sets[1:] {
 
 		// Empty set or key doesn't exist means empty intersection
 		if currentSet == nil {
@@ -459,46 +504,29 @@ func execSInter(db *DB, args [][]byte) resp.Reply {
 // execSInterStore implements SINTERSTORE destination key [key...]
 // Store the intersection of multiple sets in a new set
 func execSInterStore(db *DB, args [][]byte) resp.Reply {
-	destKey := string(args[0])
-	keys := args[1:]
-
-	// Execute intersection
-	interReply := execSInter(db, keys)
-	if _, ok := interReply.(reply.ErrorReply); ok {
-		return interReply
-	}
-
-	// Create new set with intersection result
-	interResult, ok := interReply.(*reply.MultiBulkReply)
-	if !ok {
-		return reply.MakeEmptyMultiBulkReply()
-	}
-
-	newSet := set.NewHashSet()
-	for _, member := range interResult.Args {
-		newSet.Add(string(member))
-	}
-
-	// Store set in database
-	db.PutEntity(destKey, &database.DataEntity{
-		Data: newSet,
-	})
-
-	// Add to AOF
-	db.addAof(utils.ToCmdLineWithName("SINTERSTORE", args...))
-
-	return reply.MakeIntReply(int64(newSet.Len()))
+	return storeSet(db, "SINTERSTORE", args, execSInter(db, args[1:]))
 }
 
 // execSDiff implements SDIFF key [key...]
 // Return the difference between sets
 func execSDiff(db *DB, args [][]byte) resp.Reply {
-	// Get first set as base
-	key := string(args[0])
-	firstSet, errReply := getAsSet(db, key)
+	sets, errReply := getSets(db, args)
 	if errReply != nil {
 		return errReply
 	}
+	if intSets, ok := asIntSets(sets); ok {
+		result := intSets[0]
+		for _, is := range intSets[1:] {
+			if result.Len() == 0 {
+				break
+			}
+			result = result.Diff(is)
+		}
+		return makeIntSetReply(result)
+	}
+
+	// Get first set as base
+	firstSet := sets[0]
 	if firstSet == nil {
 		return reply.MakeEmptyMultiBulkReply()
 	}
@@ -511,12 +539,7 @@ func execSDiff(db *DB, args [][]byte) resp.Reply {
 	})
 
 	// Remove members that appear in subsequent sets
-	for i := 1; i < len(args); i++ {
-		key := string(args[i])
-		currentSet, errReply := getAsSet(db, key)
-		if errReply != nil {
-			return errReply
-		}
+	for _, currentSet := range sets[1:] {
 		if currentSet == nil {
 			continue
 		}
@@ -546,38 +569,42 @@ func execSDiff(db *DB, args [][]byte) resp.Reply {
 // execSDiffStore implements SDIFFSTORE destination key [key...]
 // Store the difference between sets in a new set
 func execSDiffStore(db *DB, args [][]byte) resp.Reply {
-	destKey := string(args[0])
-	keys := args[1:]
+	return storeSet(db, "SDIFFSTORE", args, execSDiff(db, args[1:]))
+}
 
-	// Execute difference
-	diffReply := execSDiff(db, keys)
-	if _, ok := diffReply.(reply.ErrorReply); ok {
-		return diffReply
+// storeSet stores the members replied by SUNION, SINTER or SDIFF at the destination of the
+// STORE command, an empty result deletes the destination like in Redis
+func storeSet(db *DB, name string, args [][]byte, result resp.Reply) resp.Reply {
+	var members [][]byte
+	switch r := result.(type) {
+	case reply.ErrorReply:
+		return r
+	case *reply.MultiBulkReply:
+		members = r.Args
 	}
 
-	// Create new set with difference result
-	diffResult, ok := diffReply.(*reply.MultiBulkReply)
-	if !ok {
+	destKey := string(args[0])
+	if len(members) == 0 {
+		if db.Remove(destKey) > 0 {
+			db.addAof(utils.ToCmdLineWithName(name, args...))
+		}
 		return reply.MakeIntReply(0)
 	}
 
+	// the members are added like those of SADD so that the encoding is the one SADD would pick,
+	// whatever the encodings of the sets the result is computed from
 	newSet := set.NewHashSet()
-	for _, member := range diffResult.Args {
-		newSet.Add(string(member))
-	}
-
-	// Store set in database
+	addMembers(newSet, members)
 	db.PutEntity(destKey, &database.DataEntity{
 		Data: newSet,
 	})
-
-	// Add to AOF
-	db.addAof(utils.ToCmdLineWithName("SDIFFSTORE", args...))
+	db.Persist(destKey)
+	db.addAof(utils.ToCmdLineWithName(name, args...))
 
 	return reply.MakeIntReply(int64(newSet.Len()))
 }
 
-// SetType represents the type of the set (intset or hashset)
+// SetType represents the type of the set (intset, listpack or hashset)
 func execSetType(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
 
@@ -591,25 +618,56 @@ func execSetType(db *DB, args [][]byte) resp.Reply {
 	}
 
 	// Determine set type
-	if setObj.IsIntSet() {
-		return reply.MakeStatusReply("intset")
+	encoding := setObj.ObjectEncoding()
+	if encoding == "hashtable" {
+		return reply.MakeStatusReply("hashset")
+	}
+	return reply.MakeStatusReply(encoding)
+}
+
+// writeSetMembers writes out the members of the set of entity
+func writeSetMembers(entity *database.DataEntity, header func(n int), element func(elem []byte) bool) reply.ErrorReply {
+	setObj, ok := entity.Data.(set.Set)
+	if !ok {
+		return reply.MakeWrongTypeErrReply()
+	}
+	header(setObj.Len())
+	setObj.ForEach(func(member string) bool {
+		return element([]byte(member))
+	})
+	return nil
+}
+
+// asSetReply wraps a command whose members are returned as a set to RESP3 connections. The STORE
+// commands call the command itself and read the members of its array
+func asSetReply(exec ExecFunc) ExecFunc {
+	return func(db *DB, args [][]byte) resp.Reply {
+		switch r := exec(db, args).(type) {
+		case *reply.MultiBulkReply:
+			return reply.MakeBulkSetReply(r.Args)
+		case *reply.ElementsReply:
+			return r.WithPrefix('~')
+		case *reply.EmptyMultiBulkReply:
+			return reply.MakeSetReply(nil)
+		default:
+			return r
+		}
 	}
-	return reply.MakeStatusReply("hashset")
 }
 
 func init() {
 	RegisterCommand("SADD", execSAdd, -3)
 	RegisterCommand("SCARD", execSCard, 2)
 	RegisterCommand("SISMEMBER", execSIsMember, 3)
-	RegisterCommand("SMEMBERS", execSMembers, 2)
+	RegisterCommand("SMEMBERS", asSetReply(execSMembers), 2)
 	RegisterCommand("SREM", execSRem, -3)
 	RegisterCommand("SPOP", execSPop, -2)
 	RegisterCommand("SRANDMEMBER", execSRandMember, -2)
-	RegisterCommand("SUNION", execSUnion, -2)
+	RegisterCommand("SUNION", asSetReply(execSUnion), -2)
 	RegisterCommand("SUNIONSTORE", execSUnionStore, -3)
-	RegisterCommand("SINTER", execSInter, -2)
+	RegisterCommand("SINTER", asSetReply(execSInter), -2)
 	RegisterCommand("SINTERSTORE", execSInterStore, -3)
-	RegisterCommand("SDIFF", execSDiff, -2)
+	RegisterCommand("SDIFF", asSetReply(execSDiff), -2)
 	RegisterCommand("SDIFFSTORE", execSDiffStore, -3)
 	RegisterCommand("SETTYPE", execSetType, 2)
 }
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"redigo/datastruct/set"
+	"strconv"
+	"testing"
+)
+
+// TestSetStoreEmpty tests that the STORE commands delete the destination when the result is empty
+func TestSetStoreEmpty(t *testing.T) {
+	db := MakeDB()
+	exec(db, "SADD", "a", "1", "2")
+	exec(db, "SADD", "b", "3")
+
+	for _, cmd := range [][]string{
+		{"SUNIONSTORE", "dest", "missing1", "missing2"},
+		{"SINTERSTORE", "dest", "a", "b"},
+		{"SDIFFSTORE", "dest", "a", "a"},
+	} {
+		exec(db, "SET", "dest", "value")
+		assertReply(t, exec(db, cmd...), ":0\r\n")
+		assertReply(t, exec(db, "EXISTS", "dest"), ":0\r\n")
+	}
+
+	assertReply(t, exec(db, "SUNIONSTORE", "dest", "a", "b"), ":3\r\n")
+	assertReply(t, exec(db, "SCARD", "dest"), ":3\r\n")
+}
+
+// TestSetStoreEncoding tests that the results of the STORE commands are encoded as if their
+// members were added by SADD, whatever the encodings of the sets they are computed from
+func TestSetStoreEncoding(t *testing.T) {
+	db := MakeDB()
+	exec(db, "SADD", "a", "1", "2", "3")
+	exec(db, "SADD", "mixed", "1", "2", "x")
+	ints := []string{"SADD", "ints"}
+	for i := 0; i < set.SET_MAX_INTSET_ENTRIES+1; i++ {
+		ints = append(ints, strconv.Itoa(i))
+	}
+	exec(db, ints...)
+	assertReply(t, exec(db, "OBJECT", "ENCODING", "ints"), "$9\r\nhashtable\r\n")
+
+	for _, c := range []struct {
+		cmd      []string
+		encoding string
+	}{
+		{[]string{"SINTERSTORE", "dest", "a", "mixed"}, "intset"},
+		{[]string{"SDIFFSTORE", "dest", "mixed", "x"}, "listpack"},
+		{[]string{"SINTERSTORE", "dest", "ints", "a"}, "intset"},
+		{[]string{"SUNIONSTORE", "dest", "a", "mixed"}, "listpack"},
+		{[]string{"SUNIONSTORE", "dest", "ints", "a"}, "hashtable"},
+	} {
+		exec(db, c.cmd...)
+		assertReply(t, exec(db, "OBJECT", "ENCODING", "dest"), "$"+strconv.Itoa(len(c.encoding))+"\r\n"+c.encoding+"\r\n")
+	}
+	// the integers left once x is removed
+	exec(db, "SADD", "x", "x")
+	exec(db, "SDIFFSTORE", "dest", "mixed", "x")
+	assertReply(t, exec(db, "OBJECT", "ENCODING", "dest"), "$6\r\nintset\r\n")
+	assertReply(t, exec(db, "SCARD", "dest"), ":2\r\n")
+}
@@ -0,0 +1,314 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"redigo/config"
+	"redigo/datastruct/hash"
+	"redigo/datastruct/list"
+	"redigo/datastruct/set"
+	"redigo/datastruct/stream"
+	"redigo/datastruct/zset"
+	"redigo/interface/database"
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/rdb"
+	"redigo/resp/reply"
+	"strconv"
+	"time"
+)
+
+// defaultRDBFilename is the snapshot file used when dbfilename is not set
+const defaultRDBFilename = "dump.rdb"
+
+// rdbFilename returns the path of the snapshot file, in the dir of the configuration if the
+// file name is relative
+func rdbFilename() string {
+	filename := defaultRDBFilename
+	if config.Properties.RDBFilename != "" {
+		filename = config.Properties.RDBFilename
+	}
+	if config.Properties.Dir != "" && !filepath.IsAbs(filename) {
+		filename = filepath.Join(config.Properties.Dir, filename)
+	}
+	return filename
+}
+
+// SaveRDB writes a point in time snapshot of all DBs to filename, the writes go on meanwhile
+func (d *StandaloneDatabase) SaveRDB(filename string) error {
+	return writeFileAtomic(filename, d.writeRDB)
+}
+
+// writeFileAtomic writes filename with write
+// The file is written to a temporary file first, then renamed, so that a failed save
+// never leaves a truncated file behind
+func writeFileAtomic(filename string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if err := write(tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// writeRDB writes a point in time snapshot of all DBs to w
+func (d *StandaloneDatabase) writeRDB(w io.Writer) error {
+	resume := d.pauseWrites()
+	snap := d.startSnapshot(encodeRDBKeys)
+	resume()
+	defer snap.end()
+	return snap.writeRDB(w)
+}
+
+// execBackup replies with a snapshot of all DBs in the RDB format as a bulk string, so that
+// cmd/backup can copy the dataset of a running server to a local file
+// The snapshot is written to a temporary file while the writes go on, since its size comes
+// before it, then sent from the file
+// backup
+func execBackup(d *StandaloneDatabase, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("backup")
+	}
+	file, size, err := d.writeRDBFile()
+	if err != nil {
+		logger.Error("BACKUP failed: " + err.Error())
+		return reply.MakeStandardErrorReply("ERR Error trying to save the DB: " + err.Error())
+	}
+	return &fileBulkReply{file: file, size: size}
+}
+
+// writeRDBFile writes a snapshot of all DBs to a temporary file and returns it with its size
+// The file is removed at once, it is read through the returned descriptor
+func (d *StandaloneDatabase) writeRDBFile() (*os.File, int64, error) {
+	file, err := os.CreateTemp(filepath.Dir(rdbFilename()), "temp-backup-*.rdb")
+	if err != nil {
+		return nil, 0, err
+	}
+	_ = os.Remove(file.Name())
+	if err := d.writeRDB(file); err != nil {
+		_ = file.Close()
+		return nil, 0, err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, err
+	}
+	return file, size, nil
+}
+
+// fileBulkReply is a bulk string read from a file as it is sent, the file is closed once the
+// reply is written
+type fileBulkReply struct {
+	file *os.File
+	size int64
+}
+
+func (r *fileBulkReply) WriteTo(w io.Writer) (int64, error) {
+	defer r.file.Close()
+	n, err := io.WriteString(w, "$"+strconv.FormatInt(r.size, 10)+reply.CRLF)
+	if err != nil {
+		return int64(n), err
+	}
+	copied, err := io.Copy(w, io.NewSectionReader(r.file, 0, r.size))
+	total := int64(n) + copied
+	if err != nil {
+		return total, err
+	}
+	n, err = io.WriteString(w, reply.CRLF)
+	return total + int64(n), err
+}
+
+func (r *fileBulkReply) ToBytes() []byte {
+	buf := &bytes.Buffer{}
+	_, _ = r.WriteTo(buf)
+	return buf.Bytes()
+}
+
+// writeEntity encodes a key with its value
+func writeEntity(enc *rdb.Encoder, key string, entity *database.DataEntity) error {
+	switch data := entity.Data.(type) {
+	case []byte:
+		return enc.WriteString(key, data)
+	case *list.List:
+		return enc.WriteList(key, data.Values())
+	case *hash.Hash:
+		return enc.WriteHash(key, data.GetAll())
+	case set.Set:
+		return enc.WriteSet(key, data.Members())
+	case zset.ZSet:
+		pairs := make([]rdb.ZSetMember, 0, data.Len())
+		data.ForEachByRank(0, -1, false, func(member string, score float64) bool {
+			pairs = append(pairs, rdb.ZSetMember{Member: member, Score: score})
+			return true
+		})
+		return enc.WriteZSet(key, pairs)
+	case *stream.Stream:
+		all := data.Range(stream.MinID, stream.MaxID, 0)
+		entries := make([]rdb.StreamEntry, len(all))
+		for i, entry := range all {
+			entries[i] = rdb.StreamEntry{Ms: entry.ID.Ms, Seq: entry.ID.Seq, Fields: entry.Fields()}
+		}
+		last := data.LastID()
+		return enc.WriteStream(key, last.Ms, last.Seq, entries)
+	case ModuleDumper:
+		payload, err := data.ModuleDump()
+		if err != nil {
+			return fmt.Errorf("dump key %s: %w", key, err)
+		}
+		return enc.WriteModule(key, data.ModuleTypeName(), payload)
+	}
+	return fmt.Errorf("key %s: type %s cannot be saved", key, typeOf(entity))
+}
+
+// stagedDB holds the keys of a DB decoded from a snapshot
+type stagedDB struct {
+	entities map[string]*database.DataEntity
+	expires  map[string]time.Time
+}
+
+// readRDB decodes a snapshot, returning the keys of each DB
+// Nothing is changed in the DBs, so that a corrupted file leaves the dataset untouched
+// Keys which expired before the load are skipped
+func (d *StandaloneDatabase) readRDB(r io.Reader) ([]stagedDB, error) {
+	staged := make([]stagedDB, len(d.dbSet))
+	for i := range staged {
+		staged[i] = stagedDB{
+			entities: make(map[string]*database.DataEntity),
+			expires:  make(map[string]time.Time),
+		}
+	}
+	now := time.Now()
+	err := rdb.NewDecoder(r).Decode(func(obj *rdb.Object) error {
+		if obj.DB < 0 || obj.DB >= len(staged) {
+			return fmt.Errorf("key %s: DB index %d out of range", obj.Key, obj.DB)
+		}
+		entity, err := readEntity(obj)
+		if err != nil {
+			return err
+		}
+		if obj.ExpireAt > 0 {
+			expireAt := time.UnixMilli(obj.ExpireAt)
+			if !expireAt.After(now) {
+				return nil
+			}
+			staged[obj.DB].expires[obj.Key] = expireAt
+		}
+		staged[obj.DB].entities[obj.Key] = entity
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return staged, nil
+}
+
+// readEntity rebuilds the value of a decoded key
+func readEntity(obj *rdb.Object) (*database.DataEntity, error) {
+	switch obj.Type {
+	case rdb.TypeString:
+		return &database.DataEntity{Data: obj.String}, nil
+	case rdb.TypeList:
+		l := list.New()
+		for _, v := range obj.List {
+			l.PushBack(v)
+		}
+		return &database.DataEntity{Data: l}, nil
+	case rdb.TypeSet:
+		s := set.NewHashSet()
+		for _, member := range obj.Set {
+			s.Add(member)
+		}
+		return &database.DataEntity{Data: s}, nil
+	case rdb.TypeHash:
+		h := hash.MakeHash()
+		for field, value := range obj.Hash {
+			h.Set(field, value)
+		}
+		return &database.DataEntity{Data: h}, nil
+	case rdb.TypeZSet2:
+		z := zset.NewZSet()
+		for _, m := range obj.ZSet {
+			z.Add(m.Member, m.Score)
+		}
+		return &database.DataEntity{Data: z}, nil
+	case rdb.TypeStream:
+		s := stream.MakeStream()
+		for _, entry := range obj.Stream.Entries {
+			if err := s.Add(stream.ID{Ms: entry.Ms, Seq: entry.Seq}, entry.Fields); err != nil {
+				return nil, fmt.Errorf("key %s: %w", obj.Key, err)
+			}
+		}
+		s.SetLastID(stream.ID{Ms: obj.Stream.LastMs, Seq: obj.Stream.LastSeq})
+		return &database.DataEntity{Data: s}, nil
+	case rdb.TypeModule:
+		load, ok := getModuleTypeLoader(obj.Module.TypeName)
+		if !ok {
+			return nil, fmt.Errorf("key %s: unknown module type %s", obj.Key, obj.Module.TypeName)
+		}
+		data, err := load(obj.Module.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", obj.Key, err)
+		}
+		return &database.DataEntity{Data: data}, nil
+	}
+	return nil, errors.New("key " + obj.Key + ": unsupported type " + strconv.Itoa(int(obj.Type)))
+}
+
+// LoadRDB replaces the dataset with the snapshot in filename
+// The file is fully decoded before any DB is flushed
+func (d *StandaloneDatabase) LoadRDB(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	staged, err := d.readRDB(file)
+	if err != nil {
+		return err
+	}
+	if err := checkStagedIntegrity(staged, filename); err != nil {
+		return err
+	}
+	d.replaceDataset(staged)
+	return nil
+}
+
+// replaceDataset flushes the DBs and puts the keys decoded from a snapshot
+func (d *StandaloneDatabase) replaceDataset(staged []stagedDB) {
+	for i, sdb := range staged {
+		db := d.dbSet[i].Load()
+		if db == nil {
+			if len(sdb.entities) == 0 {
+				continue
+			}
+			db = d.getDB(i)
+		}
+		db.Flush()
+		for key, entity := range sdb.entities {
+			db.PutEntity(key, entity)
+		}
+		for key, expireAt := range sdb.expires {
+			db.Expire(key, expireAt)
+		}
+	}
+}
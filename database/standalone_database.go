@@ -2,75 +2,364 @@ package database
 
 import (
 	"redigo/aof"
+	"redigo/cdc"
 	"redigo/config"
 	"redigo/interface/resp"
 	"redigo/lib/logger"
+	"redigo/lib/metrics"
+	"redigo/pubsub"
 	"redigo/resp/reply"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type StandaloneDatabase struct {
-	dbSet      []*DB
-	aofHandler *aof.AofHandler
+	// dbSet holds the DBs of the databases option, a DB is allocated by its first use
+	dbSet []atomic.Pointer[DB]
+	// aofHandler writes the AOF, nil while AOF is off, it is replaced by CONFIG SET appendonly
+	aofHandler atomic.Pointer[aof.AofHandler]
+	// replID is the replication ID reported by INFO, changed by DEBUG CHANGE-REPL-ID
+	replID atomic.Value
+	// closed stops the background jobs
+	closed    chan struct{}
+	closeOnce sync.Once
+	// infoSections are the sections of the INFO reply in the order they are reported
+	infoSections []namedInfoSection
+	// changes publishes the write commands to the CDC sink, nil if there is no sink
+	changes atomic.Pointer[cdc.Publisher]
+	// readOnly is the maintenance mode, the write commands are rejected
+	readOnly atomic.Bool
+	// hub holds the subscriptions of the clients to the pub/sub channels
+	hub *pubsub.Hub
+	// notices are the keyspace events waiting to be published to the hub
+	notices *noticeQueue
+	// snapshots are the snapshots being written, that the DBs save their keys to before a write
+	snapshots *snapshots
+	// writes is held for reading by the write commands and for writing by SAVE and BGSAVE while
+	// they iterate the dataset
+	writes sync.RWMutex
+	// saving is set while SAVE or BGSAVE is running
+	saving atomic.Bool
+	// lastSave is the unix time of the last successful save, or of the start
+	lastSave atomic.Int64
+	// lastBGSaveFailed is set if the last BGSAVE could not write the snapshot
+	lastBGSaveFailed atomic.Bool
+	// repl streams the write commands to the replicas
+	repl *replication
+	// master is the link to the master of a replica, nil on a master
+	master atomic.Pointer[replicaLink]
+	// propagating is set once the dataset is loaded, the DBs allocated from then on send their
+	// write commands to the AOF, the replicas and the change feed
+	propagating atomic.Bool
+	// evictedKeys counts the keys evicted over maxmemory
+	evictedKeys atomic.Int64
+	// evictions holds the candidates to the eviction and the counters of the policies
+	evictions *evictionPool
+	// watchdog reports the commands running longer than the watchdog period, while it is positive
+	watchdog *watchdog
 }
 
 // NewStandaloneDatabase creates a new StandaloneDatabase instance
 func NewStandaloneDatabase() *StandaloneDatabase {
-	database := &StandaloneDatabase{}
+	database := &StandaloneDatabase{
+		closed:       make(chan struct{}),
+		infoSections: append([]namedInfoSection{}, defaultInfoSections...),
+		hub:          pubsub.MakeHub(),
+		notices:      makeNoticeQueue(),
+		snapshots:    &snapshots{},
+		repl:         makeReplication(),
+		evictions:    makeEvictionPool(),
+		// enabled once the AOF is loaded
+		watchdog: makeWatchdog(0),
+	}
+	database.replID.Store(newReplID())
+	database.lastSave.Store(time.Now().Unix())
 	if config.Properties.Databases == 0 {
 		config.Properties.Databases = 16
 	}
-	database.dbSet = make([]*DB, config.Properties.Databases)
-	for i := range database.dbSet {
-		db := MakeDB()
-		db.index = i
-		database.dbSet[i] = db
+	if policy := maxMemoryPolicy(); !evictionPolicies[policy] {
+		panic("invalid maxmemory-policy " + policy)
+	}
+	if mode := integrityMode(); !integrityModes[mode] {
+		panic("invalid integrity-check " + mode)
+	}
+	if _, ok := parseNotifyFlags(config.Properties.NotifyKeyspaceEvents); !ok {
+		panic("invalid notify-keyspace-events " + config.Properties.NotifyKeyspaceEvents)
+	}
+	outputLimit, err := pubsub.ParseOutputLimit(config.Properties.ClientOutputBufferLimit)
+	if err != nil {
+		panic("invalid client-output-buffer-limit: " + err.Error())
 	}
+	database.hub.SetOutputLimit(outputLimit)
+	database.dbSet = make([]atomic.Pointer[DB], config.Properties.Databases)
 
 	if config.Properties.AppendOnly {
 		aofHandler, err := aof.NewAofHandler(database)
 		if err != nil {
 			panic(err)
 		}
-		database.aofHandler = aofHandler
-		for _, db := range database.dbSet {
-			// create new variable to avoid closure capturing the loop variable
-			sdb := db
-			sdb.addAof = func(line CmdLine) {
-				database.aofHandler.AddAof(sdb.index, line)
-			}
+		database.aofHandler.Store(aofHandler)
+		database.checkIntegrity()
+	} else if err := database.loadSnapshot(); err != nil {
+		// the snapshot is only loaded without AOF, the AOF holds the most recent dataset
+		panic(err)
+	}
+	if config.Properties.CDCSink != "" {
+		sink, err := cdc.Open(config.Properties.CDCSink)
+		if err != nil {
+			panic(err)
+		}
+		database.SetChangeSink(sink)
+	}
+	// the commands replayed from the AOF above are not propagated again
+	database.propagating.Store(true)
+	database.forEachDB(database.hookPropagation)
+	// enabled after the AOF is loaded, which replays write commands
+	database.SetReadOnly(config.Properties.ReadOnly)
+	database.startActiveExpire()
+	database.startAutoRewrite()
+	database.startHeartbeat()
+	database.startStatsSampling()
+	database.startNotifications()
+	database.watchdog.setPeriod(watchdogPeriod())
+	database.startWatchdog()
+	if config.Properties.ReplicaOf != "" {
+		host, port, err := parseReplicaOf(config.Properties.ReplicaOf)
+		if err != nil {
+			panic(err)
 		}
+		database.startReplication(host, port)
 	}
+	metrics.Register("database", database.collectMetrics)
 
 	return database
 }
 
+// SetChangeSink publishes the write commands to the sink, replacing the sink of the configuration
+// It must be called before serving clients
+func (d *StandaloneDatabase) SetChangeSink(sink cdc.Sink) {
+	if old := d.changes.Swap(cdc.NewPublisher(sink)); old != nil {
+		old.Close()
+	}
+}
+
+// propagate sends a write command to the AOF, the replicas and the change feed
+func (d *StandaloneDatabase) propagate(event keyEvent) {
+	dbIndex, line := event.db.index, event.cmdLine
+	if aofHandler := d.aofHandler.Load(); aofHandler != nil {
+		aofHandler.AddAof(dbIndex, line)
+	} else if d.isClosed() && config.Read(func(p *config.ServerProperties) bool { return p.AppendOnly }) {
+		// the writes are rejected once Close began, one getting here skipped the gate
+		logger.Error("write command " + string(line[0]) + " of db " + strconv.Itoa(dbIndex) + " after close, it is not persisted")
+	}
+	d.replicate(dbIndex, line)
+	if changes := d.changes.Load(); changes != nil {
+		changes.Publish(cdc.MakeRecord(dbIndex, line, event.keys()))
+	}
+}
+
 // Exec executes a command on the database
-func (d *StandaloneDatabase) Exec(client resp.Connection, args [][]byte) resp.Reply {
+func (d *StandaloneDatabase) Exec(client resp.Connection, args [][]byte) (result resp.Reply) {
 	defer func() {
 		if err := recover(); err != nil {
-			logger.Error("Database Exec panic:" + err.(error).Error())
+			result = PanicReply(args, err)
 		}
 	}()
-	cmdName := strings.ToLower(string(args[0]))
+	cmdName := commandName(args[0])
+	// the blocking commands and WAIT wait on purpose
+	if d.watchdog.enabled() && cmdName != "wait" && !IsBlockingCommand(args) {
+		id := d.watchdog.begin(client, cmdName)
+		defer d.watchdog.end(id)
+	}
 	if cmdName == "select" {
 		if len(args) != 2 {
 			return reply.MakeArgNumErrReply("select")
 		}
 		return execSelect(client, d, args[1:])
 	}
+	if cmdName == "info" {
+		return execInfo(d, args[1:])
+	}
+	if cmdName == "debug" {
+		return execDebug(d, client, args[1:])
+	}
+	if cmdName == "backup" {
+		return execBackup(d, args[1:])
+	}
+	if cmdName == "hello" {
+		return execHello(d, client, args[1:])
+	}
+	if cmdName == "maintenance" {
+		return execMaintenance(d, args[1:])
+	}
+	if cmdName == "config" {
+		return execConfig(d, args[1:])
+	}
+	if cmdName == "keystats" {
+		return execKeyStats(d, args[1:])
+	}
+	switch cmdName {
+	case "save":
+		return execSave(d, args[1:])
+	case "bgsave":
+		return execBGSave(d, args[1:])
+	case "lastsave":
+		return execLastSave(d, args[1:])
+	case "bgrewriteaof":
+		return execBGRewriteAof(d, args[1:])
+	}
+	switch cmdName {
+	case "replicaof", "slaveof":
+		return execReplicaOf(d, args[1:])
+	case "psync":
+		return execPSync(d, client, args[1:])
+	case "replconf":
+		return execReplConf(d, client, args[1:])
+	case "wait":
+		return execWaitReplicas(d, client, args[1:])
+	}
+	switch cmdName {
+	case "subscribe":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return d.hub.Subscribe(client, args[1:])
+	case "psubscribe":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		return d.hub.PSubscribe(client, args[1:])
+	case "unsubscribe":
+		return d.hub.Unsubscribe(client, args[1:])
+	case "punsubscribe":
+		return d.hub.PUnsubscribe(client, args[1:])
+	case "publish":
+		return d.hub.ExecPublish(args[1:])
+	case "pubsub":
+		return d.hub.ExecPubSub(args[1:])
+	}
+	if errReply := d.checkReadOnly(cmdName); errReply != nil {
+		return errReply
+	}
+	if d.propagating.Load() {
+		// the dataset is not evicted while it is loaded
+		if errReply := d.checkMemory(cmdName); errReply != nil {
+			return errReply
+		}
+	}
+	if errReply := d.checkAofBackpressure(cmdName); errReply != nil {
+		return errReply
+	}
+	if IsWriteCommand(cmdName) && !IsBlockingCommand(args) {
+		// the blocking commands go through writeGate when they write, not while they wait
+		d.writes.RLock()
+		defer d.writes.RUnlock()
+		if d.isClosed() {
+			return shutdownErrReply
+		}
+	}
 	// Get the current database index from the client connection
-	db := d.dbSet[client.GetDBIndex()]
+	db := d.getDB(client.GetDBIndex())
 	return db.Exec(client, args)
 }
 
+// getDB returns the DB of index, allocating it on its first use
+func (d *StandaloneDatabase) getDB(index int) *DB {
+	if db := d.dbSet[index].Load(); db != nil {
+		return db
+	}
+	db := MakeDB()
+	db.index = index
+	db.writeGate = d.writeGate
+	db.snapshots = d.snapshots
+	if d.propagating.Load() {
+		d.hookPropagation(db)
+	}
+	if !d.dbSet[index].CompareAndSwap(nil, db) {
+		return d.dbSet[index].Load()
+	}
+	return db
+}
+
+// forEachDB calls fn for each allocated DB in the order of the indexes, the others are empty
+func (d *StandaloneDatabase) forEachDB(fn func(db *DB)) {
+	for i := range d.dbSet {
+		if db := d.dbSet[i].Load(); db != nil {
+			fn(db)
+		}
+	}
+}
+
+// hookPropagation makes db propagate its write commands and publish its keyspace events
+func (d *StandaloneDatabase) hookPropagation(db *DB) {
+	db.events.subscribe(eventWritten, func(event keyEvent) {
+		d.propagate(event)
+	})
+	d.hookNotifications(db)
+}
+
+// AfterClientClose releases the blocking command the client is waiting in and removes its
+// subscriptions
 func (d *StandaloneDatabase) AfterClientClose(c resp.Connection) {
+	d.forEachDB(func(db *DB) {
+		db.blocking.disconnect(c)
+	})
+	d.hub.Disconnect(c)
+	d.repl.disconnect(c)
+}
 
+// blockedClients returns the number of clients blocked by a blocking command
+func (d *StandaloneDatabase) blockedClients() int {
+	blocked := 0
+	d.forEachDB(func(db *DB) {
+		blocked += db.blocking.blocked()
+	})
+	return blocked
 }
 
-func (d *StandaloneDatabase) Close() {
+// shutdownErrReply is the reply of the write commands received once Close began
+var shutdownErrReply = reply.MakeStandardErrorReply("ERR the server is shutting down, write commands are rejected")
+
+func (d *StandaloneDatabase) isClosed() bool {
+	select {
+	case <-d.closed:
+		return true
+	default:
+		return false
+	}
+}
 
+// writeGate runs a write of a blocking command like Exec runs the write commands: while SAVE does
+// not pause the writes, and not once Close began
+func (d *StandaloneDatabase) writeGate(fn func()) resp.Reply {
+	d.writes.RLock()
+	defer d.writes.RUnlock()
+	if d.isClosed() {
+		return shutdownErrReply
+	}
+	fn()
+	return nil
+}
+
+// Close shuts the database down in order: the new write commands are rejected and the running
+// ones finish, then the AOF writer drains its queue and syncs the file, so that every write
+// command which was answered is on disk, and finally the change sink is closed
+func (d *StandaloneDatabase) Close() {
+	d.closeOnce.Do(func() {
+		close(d.closed)
+		d.pauseWrites()()
+		if link := d.master.Swap(nil); link != nil {
+			link.close()
+		}
+		if aofHandler := d.aofHandler.Swap(nil); aofHandler != nil {
+			aofHandler.Close()
+		}
+		if changes := d.changes.Load(); changes != nil {
+			changes.Close()
+		}
+	})
 }
 
 // execSelect sets the current database for the client connection.
@@ -86,3 +375,53 @@ func execSelect(c resp.Connection, database *StandaloneDatabase, args [][]byte)
 	c.SelectDB(dbIndex)
 	return reply.MakeOKReply()
 }
+
+// execHello negotiates the protocol version of the connection and returns the server properties
+// The AUTH and SETNAME options are handled by the handler, which passes the protocol version only
+// hello [protover]
+func execHello(d *StandaloneDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) > 1 {
+		return reply.MakeSyntaxErrReply()
+	}
+	if len(args) == 1 {
+		protocol, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			return reply.MakeStandardErrorReply("ERR Protocol version is not an integer or out of range")
+		}
+		if protocol != reply.RESP2 && protocol != reply.RESP3 {
+			return reply.MakeStandardErrorReply("NOPROTO unsupported protocol version")
+		}
+		c.SetProtocol(protocol)
+	}
+
+	mode := "standalone"
+	if config.Properties.Self != "" && len(config.Properties.Peers) > 0 {
+		mode = "cluster"
+	}
+	role := "master"
+	if d.IsReplica() {
+		role = "replica"
+	}
+	// the connections of the tests and of the replication stream have no ID
+	var id int64
+	if conn, ok := c.(interface{ GetID() uint64 }); ok {
+		id = int64(conn.GetID())
+	}
+	return reply.MakeMapReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("server")),
+		reply.MakeBulkReply([]byte("version")),
+		reply.MakeBulkReply([]byte("proto")),
+		reply.MakeBulkReply([]byte("id")),
+		reply.MakeBulkReply([]byte("mode")),
+		reply.MakeBulkReply([]byte("role")),
+		reply.MakeBulkReply([]byte("modules")),
+	}, []resp.Reply{
+		reply.MakeBulkReply([]byte("redigo")),
+		reply.MakeBulkReply([]byte(redigoVersion)),
+		reply.MakeIntReply(int64(c.GetProtocol())),
+		reply.MakeIntReply(id),
+		reply.MakeBulkReply([]byte(mode)),
+		reply.MakeBulkReply([]byte(role)),
+		reply.MakeEmptyMultiBulkReply(),
+	})
+}
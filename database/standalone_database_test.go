@@ -0,0 +1,1255 @@
+package database
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"redigo/cdc"
+	"redigo/config"
+	"redigo/datastruct/list"
+	"redigo/datastruct/set"
+	"redigo/interface/database"
+	"redigo/interface/resp"
+	consistenthash "redigo/lib/consistent_hash"
+	"redigo/lib/slot"
+	"redigo/lib/stats"
+	"redigo/lib/utils"
+	"redigo/resp/connection"
+	"redigo/resp/parser"
+	"redigo/resp/reply"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestChangeFeed tests that the write commands are published to the change sink as they are
+// written to the AOF, and the reads are not
+func TestChangeFeed(t *testing.T) {
+	d := NewStandaloneDatabase()
+	sink := cdc.NewChannelSink(16)
+	d.SetChangeSink(sink)
+	client := &connection.Connection{}
+
+	d.Exec(client, utils.ToCmdLine("SET", "key", "value", "EX", "100"))
+	d.Exec(client, utils.ToCmdLine("GET", "key"))
+	d.Exec(client, utils.ToCmdLine("SET", "other", "value", "NX"))
+	d.Exec(client, utils.ToCmdLine("SET", "other", "value", "NX"))
+	d.Exec(client, utils.ToCmdLine("SELECT", "3"))
+	d.Exec(client, utils.ToCmdLine("RPUSH", "list", "a"))
+	d.Exec(client, utils.ToCmdLine("DEL", "missing", "list"))
+	d.Close()
+
+	var records []cdc.Record
+	for r := range sink.C {
+		records = append(records, r)
+	}
+	expected := []struct {
+		db      int
+		command string
+		key     string
+	}{
+		{0, "SET", "key"},
+		{0, "SET", "other"},
+		{3, "RPUSH", "list"},
+		{3, "DEL", "missing"},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d changes, got %+v", len(expected), records)
+	}
+	for i, e := range expected {
+		r := records[i]
+		if r.Seq != uint64(i+1) || r.DB != e.db || r.Command != e.command || len(r.Keys) == 0 || r.Keys[0] != e.key {
+			t.Errorf("expected change %d to be %s %s in db %d, got %+v", i, e.command, e.key, e.db, r)
+		}
+	}
+	// SET is propagated with an absolute expiration time, like in the AOF
+	if args := records[0].Args; len(args) != 4 || args[2] != "PXAT" {
+		t.Errorf("expected SET with PXAT, got %v", args)
+	}
+}
+
+// TestMaintenanceMode tests that the write commands are rejected in maintenance mode and the
+// reads are still served
+func TestMaintenanceMode(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	d.Exec(client, utils.ToCmdLine("SET", "key", "value"))
+
+	assertReply(t, d.Exec(client, utils.ToCmdLine("MAINTENANCE", "ON")), "+OK\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("MAINTENANCE", "STATUS")), "+on\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("SET", "key", "other")), string(readOnlyErrReply.ToBytes()))
+	assertReply(t, d.Exec(client, utils.ToCmdLine("DEL", "key")), string(readOnlyErrReply.ToBytes()))
+	assertReply(t, d.Exec(client, utils.ToCmdLine("GET", "key")), "$5\r\nvalue\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("EXISTS", "key")), ":1\r\n")
+
+	assertReply(t, d.Exec(client, utils.ToCmdLine("MAINTENANCE", "OFF")), "+OK\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("MAINTENANCE", "STATUS")), "+off\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("SET", "key", "other")), "+OK\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("MAINTENANCE", "SOON")), "-ERR unknown subcommand 'SOON'. Try MAINTENANCE ON, MAINTENANCE OFF or MAINTENANCE STATUS.\r\n")
+}
+
+// TestIntegrityCheck tests that the integrity check removes the expired keys, reports the
+// corrupted values and refuses them in abort mode
+func TestIntegrityCheck(t *testing.T) {
+	defer func(mode string) {
+		config.Properties.IntegrityCheck = mode
+	}(config.Properties.IntegrityCheck)
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	d.Exec(client, utils.ToCmdLine("SET", "key", "value"))
+	d.Exec(client, utils.ToCmdLine("SET", "expired", "value"))
+	d.Exec(client, utils.ToCmdLine("RPUSH", "list", "a", "b"))
+	d.getDB(0).Expire("expired", time.Now().Add(-time.Second))
+
+	config.Properties.IntegrityCheck = "report"
+	d.checkIntegrity()
+	if _, ok := d.getDB(0).data.Get("expired"); ok {
+		t.Error("Expected the expired key to be removed")
+	}
+	assertReply(t, d.Exec(client, utils.ToCmdLine("GET", "key")), "$5\r\nvalue\r\n")
+
+	var corrupted *list.List // walking a nil list panics
+	staged := []stagedDB{{entities: map[string]*database.DataEntity{
+		"key":     {Data: []byte("value")},
+		"list":    {Data: corrupted},
+		"unknown": {Data: 42},
+	}}}
+	if err := checkStagedIntegrity(staged, "dump.rdb"); err != nil {
+		t.Errorf("Expected the report mode to accept the file, got %v", err)
+	}
+	config.Properties.IntegrityCheck = "abort"
+	if err := checkStagedIntegrity(staged, "dump.rdb"); err == nil || err.Error() != "integrity check: 2 of 3 keys are corrupted" {
+		t.Errorf("Expected the abort mode to refuse the 2 corrupted keys, got %v", err)
+	}
+	delete(staged[0].entities, "list")
+	delete(staged[0].entities, "unknown")
+	if err := checkStagedIntegrity(staged, "dump.rdb"); err != nil {
+		t.Errorf("Expected the valid keys to be accepted, got %v", err)
+	}
+
+	config.Properties.IntegrityCheck = "Report"
+	NewStandaloneDatabase().Close()
+	config.Properties.IntegrityCheck = "reprot"
+	defer func() {
+		if err := recover(); err != "invalid integrity-check reprot" {
+			t.Errorf("Expected the startup to fail with the unknown mode, got %v", err)
+		}
+	}()
+	NewStandaloneDatabase()
+	t.Error("Expected the startup to fail")
+}
+
+// TestDebugQuickCheck tests that DEBUG QUICKCHECK reports the invariants of a key of the DB of
+// the client
+func TestDebugQuickCheck(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	client.SelectDB(1)
+	d.Exec(client, utils.ToCmdLine("SADD", "set", "3", "1", "2"))
+	for i := 0; i < 200; i++ {
+		d.Exec(client, utils.ToCmdLine("ZADD", "zset", strconv.Itoa(i), "m"+strconv.Itoa(i)))
+	}
+	assertReply(t, d.Exec(client, utils.ToCmdLine("DEBUG", "QUICKCHECK", "set")),
+		"$33\r\ntype:set\r\nelements:3\r\nstatus:ok\r\n\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("DEBUG", "quickcheck", "zset")),
+		"$36\r\ntype:zset\r\nelements:200\r\nstatus:ok\r\n\r\n")
+	assertReply(t, d.Exec(&connection.Connection{}, utils.ToCmdLine("DEBUG", "QUICKCHECK", "set")), "-ERR no such key\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("DEBUG", "QUICKCHECK")), "-ERR syntax error\r\n")
+
+	var corrupted *list.List // walking a nil list panics
+	d.getDB(1).data.Put("list", &database.DataEntity{Data: corrupted})
+	r := d.Exec(client, utils.ToCmdLine("DEBUG", "QUICKCHECK", "list"))
+	if result := string(r.ToBytes()); !strings.Contains(result, "type:list\r\nstatus:corrupted\r\nviolation:invalid structure") {
+		t.Errorf("expected the corrupted list to be reported, got %q", result)
+	}
+}
+
+// goStat returns the field of DEBUG GO-STATS
+func goStat(t *testing.T, d *StandaloneDatabase, field string) uint64 {
+	r, ok := d.Exec(&connection.Connection{}, utils.ToCmdLine("DEBUG", "GO-STATS")).(*reply.BulkReply)
+	if !ok {
+		t.Fatal("Expected a bulk reply from DEBUG GO-STATS")
+	}
+	for _, line := range strings.Split(string(r.Arg), "\r\n") {
+		if value, found := strings.CutPrefix(line, field+":"); found {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				t.Fatalf("Expected %s to be a number, got %q", field, value)
+			}
+			return n
+		}
+	}
+	t.Fatalf("Expected %s in DEBUG GO-STATS, got %q", field, r.Arg)
+	return 0
+}
+
+// TestDebugGoStats tests the fields of DEBUG GO-STATS, and that MEMORY PURGE runs a garbage
+// collection reported by them
+func TestDebugGoStats(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	r := d.Exec(client, utils.ToCmdLine("DEBUG", "go-stats"))
+	stats := string(r.ToBytes())
+	for _, field := range []string{"go_version:" + runtime.Version() + "\r\n", "goroutines:", "heap_alloc:", "heap_released:",
+		"gc_cycles:", "gc_forced_cycles:", "gc_last_pause_ns:", "gc_cpu_fraction:"} {
+		if !strings.Contains(stats, field) {
+			t.Errorf("Expected %s in DEBUG GO-STATS, got %q", field, stats)
+		}
+	}
+	assertReply(t, d.Exec(client, utils.ToCmdLine("DEBUG", "GO-STATS", "extra")), "-ERR syntax error\r\n")
+
+	forced := goStat(t, d, "gc_forced_cycles")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("MEMORY", "PURGE")), "+OK\r\n")
+	if after := goStat(t, d, "gc_forced_cycles"); after <= forced {
+		t.Errorf("Expected MEMORY PURGE to force a garbage collection, %d forced cycles before and %d after", forced, after)
+	}
+	if goStat(t, d, "heap_released") == 0 {
+		t.Error("Expected MEMORY PURGE to release memory to the operating system")
+	}
+	assertReply(t, d.Exec(client, utils.ToCmdLine("MEMORY", "PURGE", "extra")), "-ERR syntax error\r\n")
+}
+
+// TestSaveAndLoadOnStartup tests that SAVE and BGSAVE write the snapshot to the dir of the
+// configuration, and that a new database loads it
+func TestSaveAndLoadOnStartup(t *testing.T) {
+	defer func(dir string) {
+		config.Properties.Dir = dir
+	}(config.Properties.Dir)
+	config.Properties.Dir = t.TempDir()
+	d := NewStandaloneDatabase()
+	client := &connection.Connection{}
+	d.Exec(client, utils.ToCmdLine("SET", "key", "value"))
+	d.Exec(client, utils.ToCmdLine("SELECT", "2"))
+	d.Exec(client, utils.ToCmdLine("RPUSH", "list", "a", "b"))
+	assertReply(t, d.Exec(client, utils.ToCmdLine("SAVE")), "+OK\r\n")
+
+	d.Exec(client, utils.ToCmdLine("RPUSH", "list", "c"))
+	assertReply(t, d.Exec(client, utils.ToCmdLine("BGSAVE")), "+Background saving started\r\n")
+	deadline := time.Now().Add(time.Second)
+	for d.saving.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected BGSAVE to finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// written after the copy of BGSAVE, not in the snapshot
+	d.Exec(client, utils.ToCmdLine("RPUSH", "list", "d"))
+	d.Close()
+
+	loaded := NewStandaloneDatabase()
+	defer loaded.Close()
+	client = &connection.Connection{}
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("GET", "key")), "$5\r\nvalue\r\n")
+	loaded.Exec(client, utils.ToCmdLine("SELECT", "2"))
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("LRANGE", "list", "0", "-1")), "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n")
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("SAVE", "now")), "-ERR wrong number of arguments for 'save' command\r\n")
+}
+
+// TestSnapshotPointInTime tests that a snapshot holds the dataset as it was at its start while
+// the writes go on during it
+func TestSnapshotPointInTime(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	for i := 0; i < 1000; i++ {
+		d.Exec(client, utils.ToCmdLine("SET", "key"+strconv.Itoa(i), "old"))
+	}
+	d.Exec(client, utils.ToCmdLine("RPUSH", "list", "a"))
+	d.Exec(client, utils.ToCmdLine("SELECT", "1"))
+	d.Exec(client, utils.ToCmdLine("SET", "flushed", "value"))
+
+	resume := d.pauseWrites()
+	snap := d.startSnapshot(encodeRDBKeys)
+	resume()
+	d.Exec(client, utils.ToCmdLine("FLUSHDB"))
+	d.Exec(client, utils.ToCmdLine("SELECT", "0"))
+	d.Exec(client, utils.ToCmdLine("DEL", "key0"))
+	d.Exec(client, utils.ToCmdLine("RPUSH", "list", "b"))
+	d.Exec(client, utils.ToCmdLine("SET", "added", "new"))
+
+	// the writes go on while the snapshot is written
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		writer := &connection.Connection{}
+		for i := 1; ; i = i%999 + 1 {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			d.Exec(writer, utils.ToCmdLine("SET", "key"+strconv.Itoa(i), "new"))
+		}
+	}()
+	buf := &bytes.Buffer{}
+	err := snap.writeRDB(buf)
+	snap.end()
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.snapshots.writing() {
+		t.Error("Expected the ended snapshot to be removed")
+	}
+
+	staged, err := d.readRDB(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(staged[0].entities); n != 1001 {
+		t.Errorf("Expected the 1001 keys of DB 0, got %d", n)
+	}
+	for i := 1; i < 1000; i++ {
+		entity, ok := staged[0].entities["key"+strconv.Itoa(i)]
+		if !ok || string(entity.Data.([]byte)) != "old" {
+			t.Fatalf("Expected key%d to hold its value at the start", i)
+		}
+	}
+	if _, ok := staged[0].entities["key0"]; !ok {
+		t.Error("Expected the key deleted during the snapshot")
+	}
+	if _, ok := staged[0].entities["added"]; ok {
+		t.Error("Expected no key added during the snapshot")
+	}
+	if values := staged[0].entities["list"].Data.(*list.List).Values(); len(values) != 1 {
+		t.Errorf("Expected the list at the start, got %q", values)
+	}
+	if _, ok := staged[1].entities["flushed"]; !ok {
+		t.Error("Expected the key of the DB flushed during the snapshot")
+	}
+}
+
+// TestBackup tests that BACKUP sends the snapshot from a file, streamed, which leaves nothing behind
+func TestBackup(t *testing.T) {
+	defer func(dir string) {
+		config.Properties.Dir = dir
+	}(config.Properties.Dir)
+	config.Properties.Dir = t.TempDir()
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	d.Exec(client, utils.ToCmdLine("SET", "key", "value"))
+
+	sr, ok := d.Exec(client, utils.ToCmdLine("BACKUP")).(resp.StreamReply)
+	if !ok {
+		t.Fatal("Expected BACKUP to stream its reply")
+	}
+	if entries, _ := os.ReadDir(config.Properties.Dir); len(entries) != 0 {
+		t.Errorf("Expected no file left in the dir, got %v", entries)
+	}
+	buf := &bytes.Buffer{}
+	if _, err := sr.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	header, err := buf.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+	if size != buf.Len()-2 || !strings.HasSuffix(buf.String(), "\r\n") {
+		t.Fatalf("Expected a bulk string of %d bytes, got %d", size, buf.Len()-2)
+	}
+	staged, err := d.readRDB(bytes.NewReader(buf.Bytes()[:size]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entity, ok := staged[0].entities["key"]; !ok || string(entity.Data.([]byte)) != "value" {
+		t.Error("Expected the key in the backup")
+	}
+}
+
+// TestRewriteAof tests that BGREWRITEAOF replaces the AOF with a shorter file rebuilding the same
+// dataset, keeping the commands written during the rewrite
+func TestRewriteAof(t *testing.T) {
+	defer func(appendOnly bool, filename string) {
+		config.Properties.AppendOnly = appendOnly
+		config.Properties.AppendFilename = filename
+	}(config.Properties.AppendOnly, config.Properties.AppendFilename)
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+	d := NewStandaloneDatabase()
+	client := &connection.Connection{}
+	for i := 0; i < 100; i++ {
+		d.Exec(client, utils.ToCmdLine("SET", "counter", strconv.Itoa(i+1)))
+		d.Exec(client, utils.ToCmdLine("RPUSH", "list", strconv.Itoa(i)))
+	}
+	d.Exec(client, utils.ToCmdLine("SELECT", "1"))
+	d.Exec(client, utils.ToCmdLine("SET", "ttl", "value", "EX", "100"))
+	d.Exec(client, utils.ToCmdLine("ZADD", "zset", "1.5", "a", "-inf", "b"))
+	d.Exec(client, utils.ToCmdLine("HSET", "hash", "field", "value"))
+	d.Exec(client, utils.ToCmdLine("SADD", "set", "x", "y"))
+	d.Exec(client, utils.ToCmdLine("XADD", "stream", "1-1", "field", "value"))
+	d.Exec(client, utils.ToCmdLine("XADD", "trimmed", "MAXLEN", "0", "5-5", "field", "value"))
+
+	assertReply(t, d.Exec(client, utils.ToCmdLine("BGREWRITEAOF")), "+Background append only file rewriting started\r\n")
+	d.Exec(client, utils.ToCmdLine("SELECT", "0"))
+	d.Exec(client, utils.ToCmdLine("RPUSH", "list", "after"))
+	deadline := time.Now().Add(time.Second)
+	for d.aofHandler.Load().IsRewriting() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the rewrite to finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	d.Exec(client, utils.ToCmdLine("SELECT", "1"))
+	d.Exec(client, utils.ToCmdLine("SADD", "set", "z"))
+	size, base := d.aofHandler.Load().Size()
+	d.Close()
+	if size >= 4000 || base > size {
+		t.Errorf("Expected a compact AOF, got %d bytes, %d after the rewrite", size, base)
+	}
+
+	loaded := NewStandaloneDatabase()
+	defer loaded.Close()
+	client = &connection.Connection{}
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("GET", "counter")), "$3\r\n100\r\n")
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("LLEN", "list")), ":101\r\n")
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("LINDEX", "list", "-1")), "$5\r\nafter\r\n")
+	loaded.Exec(client, utils.ToCmdLine("SELECT", "1"))
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("GET", "ttl")), "$5\r\nvalue\r\n")
+	if ttl := loaded.Exec(client, utils.ToCmdLine("TTL", "ttl")).ToBytes(); string(ttl) != ":100\r\n" && string(ttl) != ":99\r\n" {
+		t.Errorf("Expected the TTL to be kept, got %q", ttl)
+	}
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("ZSCORE", "zset", "b")), ",-inf\r\n")
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("HGET", "hash", "field")), "$5\r\nvalue\r\n")
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("SCARD", "set")), ":3\r\n")
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("XLEN", "stream")), ":1\r\n")
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("XLEN", "trimmed")), ":0\r\n")
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("XADD", "trimmed", "5-5", "field", "value")), "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n")
+}
+
+// TestAofReplaySelect tests that the commands of the AOF are replayed in the DB selected before them
+func TestAofReplaySelect(t *testing.T) {
+	defer func(appendOnly bool, filename string) {
+		config.Properties.AppendOnly = appendOnly
+		config.Properties.AppendFilename = filename
+	}(config.Properties.AppendOnly, config.Properties.AppendFilename)
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+	d := NewStandaloneDatabase()
+	client := &connection.Connection{}
+	other := &connection.Connection{}
+	other.SelectDB(5)
+	for i, dbIndex := range []int{0, 1, 5, 1, 0} {
+		client.SelectDB(dbIndex)
+		d.Exec(client, utils.ToCmdLine("RPUSH", "list", strconv.Itoa(i)))
+		// the commands of another client interleave with their own DB
+		d.Exec(other, utils.ToCmdLine("RPUSH", "other", strconv.Itoa(i)))
+	}
+	client.SelectDB(1)
+	d.Exec(client, utils.ToCmdLine("SET", "key", "db1"))
+	d.Close()
+
+	loaded := NewStandaloneDatabase()
+	defer loaded.Close()
+	client = &connection.Connection{}
+	expected := map[int]string{
+		0: "*2\r\n$1\r\n0\r\n$1\r\n4\r\n",
+		1: "*2\r\n$1\r\n1\r\n$1\r\n3\r\n",
+		5: "*1\r\n$1\r\n2\r\n",
+	}
+	for dbIndex, list := range expected {
+		client.SelectDB(dbIndex)
+		assertReply(t, loaded.Exec(client, utils.ToCmdLine("LRANGE", "list", "0", "-1")), list)
+	}
+	client.SelectDB(5)
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("LLEN", "other")), ":5\r\n")
+	client.SelectDB(1)
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("GET", "key")), "$3\r\ndb1\r\n")
+	client.SelectDB(0)
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("EXISTS", "key", "other")), ":0\r\n")
+}
+
+// TestAofDirname tests that the AOF is written in appenddirname, created under dir if needed, and
+// that a path which cannot be written stops the startup with the path in the error
+func TestAofDirname(t *testing.T) {
+	defer func(appendOnly bool, filename, dirname, dir string) {
+		config.Properties.AppendOnly = appendOnly
+		config.Properties.AppendFilename = filename
+		config.Properties.AppendDirname = dirname
+		config.Properties.Dir = dir
+	}(config.Properties.AppendOnly, config.Properties.AppendFilename, config.Properties.AppendDirname, config.Properties.Dir)
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = ""
+	config.Properties.AppendDirname = filepath.Join("aof", "node")
+	config.Properties.Dir = t.TempDir()
+	d := NewStandaloneDatabase()
+	client := &connection.Connection{}
+	d.Exec(client, utils.ToCmdLine("SET", "key", "value"))
+	d.Close()
+	if _, err := os.Stat(filepath.Join(config.Properties.Dir, "aof", "node", "appendonly.aof")); err != nil {
+		t.Fatalf("expected the AOF in appenddirname: %v", err)
+	}
+
+	loaded := NewStandaloneDatabase()
+	client = &connection.Connection{}
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("GET", "key")), "$5\r\nvalue\r\n")
+	loaded.Close()
+
+	// a regular file where a directory is expected
+	blocker := filepath.Join(config.Properties.Dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.Properties.AppendDirname = filepath.Join(blocker, "aof")
+	defer func() {
+		err, _ := recover().(error)
+		if err == nil || !strings.Contains(err.Error(), filepath.Join(blocker, "aof", "appendonly.aof")) {
+			t.Errorf("expected the startup to fail with the AOF path, got %v", err)
+		}
+	}()
+	NewStandaloneDatabase()
+	t.Error("expected the startup to fail")
+}
+
+// TestAppendOnlyToggle tests that CONFIG SET appendonly yes writes the AOF from the dataset and
+// that the writes after CONFIG SET appendonly no are not written to it
+func TestAppendOnlyToggle(t *testing.T) {
+	defer func(appendOnly bool, filename string) {
+		config.Properties.AppendOnly = appendOnly
+		config.Properties.AppendFilename = filename
+	}(config.Properties.AppendOnly, config.Properties.AppendFilename)
+	config.Properties.AppendOnly = false
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+	// a stale file of a former run is replaced, not appended to
+	if err := os.WriteFile(config.Properties.AppendFilename, reply.MakeMultiBulkReply(utils.ToCmdLine("SET", "stale", "value")).ToBytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d := NewStandaloneDatabase()
+	client := &connection.Connection{}
+	d.Exec(client, utils.ToCmdLine("SET", "before", "value"))
+	assertReply(t, d.Exec(client, utils.ToCmdLine("BGREWRITEAOF")), "-ERR Append only file is disabled, set appendonly yes to rewrite it\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "appendonly", "maybe")), "-ERR CONFIG SET failed (possibly related to argument 'appendonly') - argument must be 'yes' or 'no'\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "missing", "value")), "-ERR Unknown option or number of arguments for CONFIG SET - 'missing'\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "appendonly")), "-ERR wrong number of arguments for 'config|set' command\r\n")
+
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "appendonly", "yes")), "+OK\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "GET", "appendonly")), "%1\r\n$10\r\nappendonly\r\n$3\r\nyes\r\n")
+	if info := string(d.Exec(client, utils.ToCmdLine("INFO", "persistence")).ToBytes()); !strings.Contains(info, "aof_enabled:1") {
+		t.Errorf("expected AOF to be on, got %q", info)
+	}
+	d.Exec(client, utils.ToCmdLine("SET", "during", "value"))
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "appendonly", "no")), "+OK\r\n")
+	d.Exec(client, utils.ToCmdLine("SET", "after", "value"))
+	d.Close()
+
+	config.Properties.AppendOnly = true
+	loaded := NewStandaloneDatabase()
+	defer loaded.Close()
+	client = &connection.Connection{}
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("EXISTS", "before", "during")), ":2\r\n")
+	assertReply(t, loaded.Exec(client, utils.ToCmdLine("EXISTS", "stale", "after")), ":0\r\n")
+}
+
+// TestConfigSetRewrite tests the parameters changed by CONFIG SET and written by CONFIG REWRITE
+func TestConfigSetRewrite(t *testing.T) {
+	defer func(properties *config.ServerProperties) {
+		config.Properties = properties
+	}(config.Properties)
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "REWRITE")), "-ERR The server is running without a config file\r\n")
+
+	filename := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(filename, []byte("# limits\nmaxClients 10\nbind 127.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.SetupConfig(filename)
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "GET", "maxclients")), "%1\r\n$10\r\nmaxclients\r\n$2\r\n10\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "GET", "appendf*")), "%2\r\n$14\r\nappendfilename\r\n$0\r\n\r\n$11\r\nappendfsync\r\n$6\r\nalways\r\n")
+
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "maxclients", "20", "appendfsync", "EVERYSEC", "requirepass", "secret")), "+OK\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "GET", "maxclients", "appendfsync")), "%2\r\n$11\r\nappendfsync\r\n$8\r\neverysec\r\n$10\r\nmaxclients\r\n$2\r\n20\r\n")
+	if config.Properties.RequirePass != "secret" {
+		t.Errorf("expected requirepass to be set, got %q", config.Properties.RequirePass)
+	}
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "maxclients", "-1")), "-ERR CONFIG SET failed (possibly related to argument 'maxclients') - argument must be a non-negative integer\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "appendfsync", "sometimes")), "-ERR CONFIG SET failed (possibly related to argument 'appendfsync') - argument must be one of always, everysec, no\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "SET", "databases", "4")), "-ERR Unknown option or number of arguments for CONFIG SET - 'databases'\r\n")
+
+	// the line of maxclients is replaced, the comments and the other lines are kept
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "REWRITE")), "+OK\r\n")
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# limits\nmaxClients 20\nbind 127.0.0.1\nappendfsync everysec\nrequirepass secret\n"
+	if string(content) != expected {
+		t.Errorf("expected the rewritten file %q, got %q", expected, content)
+	}
+	config.SetupConfig(filename)
+	if config.Properties.MaxClients != 20 || config.Properties.AppendFsync != "everysec" || config.Properties.RequirePass != "secret" {
+		t.Errorf("expected the rewritten parameters to be loaded, got %+v", config.Properties)
+	}
+}
+
+// TestCloseDrainsAof tests that Close waits for the running write commands and drains the AOF
+// writer, so that the file holds exactly the write commands which were answered
+func TestCloseDrainsAof(t *testing.T) {
+	defer func(appendOnly bool, filename string) {
+		config.Properties.AppendOnly = appendOnly
+		config.Properties.AppendFilename = filename
+	}(config.Properties.AppendOnly, config.Properties.AppendFilename)
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+
+	d := NewStandaloneDatabase()
+	const writers = 8
+	acked := make([]int, writers)
+	var ready, done sync.WaitGroup
+	ready.Add(writers)
+	done.Add(writers)
+	for i := range writers {
+		go func() {
+			defer done.Done()
+			client := &connection.Connection{}
+			key := "counter:" + strconv.Itoa(i)
+			for {
+				r := d.Exec(client, utils.ToCmdLine("INCR", key))
+				if reply.IsErrReply(r) {
+					if r != shutdownErrReply {
+						t.Errorf("expected the writes to be rejected after Close, got %q", r.ToBytes())
+					}
+					if acked[i] < 100 {
+						ready.Done()
+					}
+					return
+				}
+				acked[i]++
+				if acked[i] == 100 {
+					ready.Done()
+				}
+			}
+		}()
+	}
+	ready.Wait()
+	d.Close()
+	done.Wait()
+
+	loaded := NewStandaloneDatabase()
+	defer loaded.Close()
+	client := &connection.Connection{}
+	for i, n := range acked {
+		assertReply(t, loaded.Exec(client, utils.ToCmdLine("GET", "counter:"+strconv.Itoa(i))), string(reply.MakeBulkReply([]byte(strconv.Itoa(n))).ToBytes()))
+	}
+}
+
+// TestCloseBlockingPop tests that the elements popped by BLPOP while Close runs are popped from
+// the AOF too: after a restart every pushed element which was acknowledged is either popped by
+// an acknowledged BLPOP or still in the list, once
+func TestCloseBlockingPop(t *testing.T) {
+	defer func(appendOnly bool, filename string) {
+		config.Properties.AppendOnly = appendOnly
+		config.Properties.AppendFilename = filename
+	}(config.Properties.AppendOnly, config.Properties.AppendFilename)
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = filepath.Join(t.TempDir(), "appendonly.aof")
+
+	d := NewStandaloneDatabase()
+	const poppers = 4
+	var mu sync.Mutex
+	var pushed, popped []string
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for range poppers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := &connection.Connection{}
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				switch r := d.Exec(client, utils.ToCmdLine("BLPOP", "queue", "0.01")).(type) {
+				case *reply.MultiBulkReply:
+					mu.Lock()
+					popped = append(popped, string(r.Args[1]))
+					mu.Unlock()
+				case reply.ErrorReply:
+					if r != shutdownErrReply {
+						t.Errorf("expected the pops to be rejected after Close, got %q", r.ToBytes())
+					}
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		client := &connection.Connection{}
+		for i := 0; ; i++ {
+			value := strconv.Itoa(i)
+			if reply.IsErrReply(d.Exec(client, utils.ToCmdLine("RPUSH", "queue", value))) {
+				return
+			}
+			mu.Lock()
+			pushed = append(pushed, value)
+			mu.Unlock()
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	d.Close()
+	close(stop)
+	wg.Wait()
+
+	loaded := NewStandaloneDatabase()
+	defer loaded.Close()
+	remaining := exec(loaded.getDB(0), "LRANGE", "queue", "0", "-1")
+	seen := make(map[string]int)
+	for _, value := range popped {
+		seen[value]++
+	}
+	if list, ok := reply.Resolve(remaining).(*reply.MultiBulkReply); ok {
+		for _, value := range list.Args {
+			seen[string(value)]++
+		}
+	}
+	if len(popped) == 0 {
+		t.Fatal("expected BLPOP to pop elements before Close")
+	}
+	for _, value := range pushed {
+		if n := seen[value]; n != 1 {
+			t.Fatalf("expected %s to be popped or in the AOF once, got %d times", value, n)
+		}
+	}
+	if len(seen) != len(pushed) {
+		t.Errorf("expected only the acknowledged pushes, got %d elements for %d pushes", len(seen), len(pushed))
+	}
+}
+
+// TestCommandPanic tests that a command which panics gets an error reply with a correlation ID,
+// is counted, and leaves the database usable
+func TestCommandPanic(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	var corrupted *set.HashSet // reading a nil set panics
+	d.getDB(0).data.Put("broken", &database.DataEntity{Data: corrupted})
+	panics := stats.Server.CommandPanics()
+
+	r := string(d.Exec(client, utils.ToCmdLine("SCARD", "broken")).ToBytes())
+	if !strings.HasPrefix(r, "-ERR internal error executing 'scard' (id ") || !strings.HasSuffix(r, "), see the server log\r\n") {
+		t.Errorf("expected an internal error with its ID, got %q", r)
+	}
+	if got := stats.Server.CommandPanics() - panics; got != 1 {
+		t.Errorf("expected the panic to be counted once, got %d", got)
+	}
+	if info := string(d.Exec(client, utils.ToCmdLine("INFO", "stats")).ToBytes()); !strings.Contains(info, "command_panics:") {
+		t.Errorf("expected INFO stats to report the panics, got %q", info)
+	}
+	assertReply(t, d.Exec(client, utils.ToCmdLine("SET", "key", "value")), "+OK\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("GET", "key")), "$5\r\nvalue\r\n")
+
+	if got := redactedCommand(utils.ToCmdLine("AUTH", "secret")); got != "auth <6 bytes>" {
+		t.Errorf("expected the arguments to be redacted, got %q", got)
+	}
+}
+
+// TestDBIsolation tests that the commands of a client only see and change its selected DB, and
+// that the subsystems fed by the write commands record the DB of each of them
+func TestDBIsolation(t *testing.T) {
+	d := NewStandaloneDatabase()
+	sink := cdc.NewChannelSink(64)
+	d.SetChangeSink(sink)
+	db0, db1 := &connection.Connection{}, &connection.Connection{}
+	db1.SelectDB(1)
+
+	d.Exec(db0, utils.ToCmdLine("SET", "key", "zero"))
+	d.Exec(db1, utils.ToCmdLine("SET", "key", "one"))
+	d.Exec(db1, utils.ToCmdLine("SET", "only1", "one"))
+	assertReply(t, d.Exec(db0, utils.ToCmdLine("GET", "key")), "$4\r\nzero\r\n")
+	assertReply(t, d.Exec(db1, utils.ToCmdLine("GET", "key")), "$3\r\none\r\n")
+	assertReply(t, d.Exec(db0, utils.ToCmdLine("KEYS", "*")), "*1\r\n$3\r\nkey\r\n")
+	assertReply(t, d.Exec(db0, utils.ToCmdLine("EXISTS", "only1")), ":0\r\n")
+	assertReply(t, d.Exec(db0, utils.ToCmdLine("RENAME", "only1", "renamed")), "-ERR no such key\r\n")
+
+	// a key expiring in a DB leaves the key of the same name of another DB
+	d.Exec(db1, utils.ToCmdLine("PEXPIRE", "key", "20"))
+	assertReply(t, d.Exec(db0, utils.ToCmdLine("TTL", "key")), ":-1\r\n")
+	deadline := time.Now().Add(2 * time.Second)
+	for d.getDB(1).data.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the key to be removed by the active expire cycle")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertReply(t, d.Exec(db0, utils.ToCmdLine("GET", "key")), "$4\r\nzero\r\n")
+
+	// a client blocked on a key of a DB is not woken by a push to another DB
+	blocked := make(chan resp.Reply, 1)
+	go func() {
+		blocked <- d.Exec(db1, utils.ToCmdLine("BLPOP", "list", "0"))
+	}()
+	for d.getDB(1).blocking.blocked() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	d.Exec(db0, utils.ToCmdLine("RPUSH", "list", "zero"))
+	select {
+	case r := <-blocked:
+		t.Fatalf("Expected BLPOP to stay blocked, got %q", r.ToBytes())
+	case <-time.After(20 * time.Millisecond):
+	}
+	d.Exec(db1, utils.ToCmdLine("RPUSH", "list", "one"))
+	select {
+	case r := <-blocked:
+		assertReply(t, r, "*2\r\n$4\r\nlist\r\n$3\r\none\r\n")
+	case <-time.After(time.Second):
+		t.Fatal("Expected BLPOP to pop the element pushed to its DB")
+	}
+
+	assertReply(t, d.Exec(db1, utils.ToCmdLine("FLUSHDB")), "+OK\r\n")
+	assertReply(t, d.Exec(db1, utils.ToCmdLine("KEYS", "*")), "*0\r\n")
+	assertReply(t, d.Exec(db0, utils.ToCmdLine("LLEN", "list")), ":1\r\n")
+	info := string(d.Exec(db0, utils.ToCmdLine("INFO", "keyspace")).ToBytes())
+	if !strings.Contains(info, "db0:keys=2,expires=0") || strings.Contains(info, "db1:") {
+		t.Errorf("Expected the keyspace of db0 only, got %q", info)
+	}
+	d.Close()
+
+	expected := []int{0, 1, 1, 1, 0, 1, 1, 1}
+	var dbs []int
+	for r := range sink.C {
+		dbs = append(dbs, r.DB)
+	}
+	if len(dbs) != len(expected) {
+		t.Fatalf("Expected %d changes, got the DBs %v", len(expected), dbs)
+	}
+	for i, db := range expected {
+		if dbs[i] != db {
+			t.Errorf("Expected change %d in db %d, got the DBs %v", i, db, dbs)
+			break
+		}
+	}
+}
+
+// TestLazyDBs tests that the DBs are allocated by their first use, that the DBs allocated after
+// the startup propagate their writes and that CONFIG GET reports the number of DBs
+func TestLazyDBs(t *testing.T) {
+	defer func(databases int) {
+		config.Properties.Databases = databases
+	}(config.Properties.Databases)
+	config.Properties.Databases = 1024
+	d := NewStandaloneDatabase()
+	sink := cdc.NewChannelSink(8)
+	d.SetChangeSink(sink)
+	allocated := func() int {
+		n := 0
+		d.forEachDB(func(*DB) { n++ })
+		return n
+	}
+	if n := allocated(); n != 0 {
+		t.Fatalf("Expected no DB allocated at startup, got %d", n)
+	}
+
+	client := &connection.Connection{}
+	assertReply(t, d.Exec(client, utils.ToCmdLine("SELECT", "1000")), "+OK\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("SELECT", "1024")), "-ERR DB index out of range\r\n")
+	if n := allocated(); n != 0 {
+		t.Errorf("Expected SELECT not to allocate its DB, got %d DBs", n)
+	}
+	assertReply(t, d.Exec(client, utils.ToCmdLine("SET", "key", "value")), "+OK\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("GET", "key")), "$5\r\nvalue\r\n")
+	if n := allocated(); n != 1 {
+		t.Errorf("Expected a single DB allocated, got %d", n)
+	}
+	info := string(d.Exec(client, utils.ToCmdLine("INFO", "keyspace")).ToBytes())
+	if !strings.Contains(info, "db1000:keys=1,expires=0") {
+		t.Errorf("Expected the keyspace of db1000, got %q", info)
+	}
+
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "GET", "databases")), "%1\r\n$9\r\ndatabases\r\n$4\r\n1024\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "GET", "DATA*")), "%1\r\n$9\r\ndatabases\r\n$4\r\n1024\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "GET", "missing")), "%0\r\n")
+	assertReply(t, d.Exec(client, utils.ToCmdLine("CONFIG", "GET")), "-ERR wrong number of arguments for 'config|get' command\r\n")
+	d.Close()
+
+	r, ok := <-sink.C
+	if !ok || r.DB != 1000 {
+		t.Errorf("Expected the SET of db1000 in the change feed, got %+v", r)
+	}
+}
+
+// serveDatabase serves d on a local port like the handler, returning the address
+func serveDatabase(t *testing.T, d *StandaloneDatabase) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				client := connection.NewConnection(conn)
+				for payload := range parser.ParseStream(conn) {
+					if payload.Err != nil {
+						break
+					}
+					if cmd, ok := payload.Data.(*reply.MultiBulkReply); ok {
+						_ = client.WriteReply(d.Exec(client, cmd.Args))
+					}
+				}
+				d.AfterClientClose(client)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// waitForReply executes the command until it replies expected
+func waitForReply(t *testing.T, d *StandaloneDatabase, client *connection.Connection, cmdLine CmdLine, expected string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for string(d.Exec(client, cmdLine).ToBytes()) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s to reply %q, got %q", cmdLine[0], expected, d.Exec(client, cmdLine).ToBytes())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestReplication tests that a replica loads the dataset of its master, applies its writes in
+// the right DB, rejects the writes of clients and resumes the stream after a lost link
+func TestReplication(t *testing.T) {
+	master := NewStandaloneDatabase()
+	defer master.Close()
+	client := &connection.Connection{}
+	master.Exec(client, utils.ToCmdLine("SET", "a", "1"))
+	master.Exec(client, utils.ToCmdLine("SELECT", "2"))
+	master.Exec(client, utils.ToCmdLine("RPUSH", "list", "x", "y"))
+	host, port, _ := net.SplitHostPort(serveDatabase(t, master))
+
+	replica := NewStandaloneDatabase()
+	defer replica.Close()
+	replicaClient := &connection.Connection{}
+	assertReply(t, replica.Exec(replicaClient, utils.ToCmdLine("REPLICAOF", host, port)), "+OK\r\n")
+	waitForReply(t, replica, replicaClient, utils.ToCmdLine("GET", "a"), "$1\r\n1\r\n")
+	replicaClient.SelectDB(2)
+	assertReply(t, replica.Exec(replicaClient, utils.ToCmdLine("LLEN", "list")), ":2\r\n")
+
+	master.Exec(client, utils.ToCmdLine("RPUSH", "list", "z"))
+	master.Exec(client, utils.ToCmdLine("SELECT", "3"))
+	master.Exec(client, utils.ToCmdLine("SET", "b", "2"))
+	assertReply(t, master.Exec(client, utils.ToCmdLine("WAIT", "1", "1000")), ":1\r\n")
+	assertReply(t, replica.Exec(replicaClient, utils.ToCmdLine("LLEN", "list")), ":3\r\n")
+	replicaClient.SelectDB(3)
+	assertReply(t, replica.Exec(replicaClient, utils.ToCmdLine("GET", "b")), "$1\r\n2\r\n")
+	assertReply(t, replica.Exec(replicaClient, utils.ToCmdLine("SET", "b", "3")), "-READONLY You can't write against a read only replica.\r\n")
+
+	// a key only held by the replica survives a partial resync, a full one would drop it
+	replica.getDB(3).PutEntity("local", &database.DataEntity{Data: []byte("kept")})
+	link := replica.master.Load()
+	link.mu.Lock()
+	_ = link.conn.Close()
+	link.mu.Unlock()
+	master.Exec(client, utils.ToCmdLine("SET", "c", "3"))
+	waitForReply(t, replica, replicaClient, utils.ToCmdLine("GET", "c"), "$1\r\n3\r\n")
+	assertReply(t, replica.Exec(replicaClient, utils.ToCmdLine("GET", "local")), "$4\r\nkept\r\n")
+	if offset := master.repl.offset.Load(); link.offset.Load() > offset {
+		t.Errorf("Expected the offset of the replica to follow the master, got %d past %d", link.offset.Load(), offset)
+	}
+
+	assertReply(t, replica.Exec(replicaClient, utils.ToCmdLine("REPLICAOF", "NO", "ONE")), "+OK\r\n")
+	assertReply(t, replica.Exec(replicaClient, utils.ToCmdLine("SET", "b", "3")), "+OK\r\n")
+}
+
+// TestMigrate tests that MIGRATE moves the keys to another instance with their TTL, keeps them
+// with COPY, and keeps those refused by the target
+func TestMigrate(t *testing.T) {
+	source := NewStandaloneDatabase()
+	defer source.Close()
+	target := NewStandaloneDatabase()
+	defer target.Close()
+	host, port, _ := net.SplitHostPort(serveDatabase(t, target))
+	client, targetClient := &connection.Connection{}, &connection.Connection{}
+	targetClient.SelectDB(2)
+	source.Exec(client, utils.ToCmdLine("SET", "a", "1", "EX", "100"))
+	source.Exec(client, utils.ToCmdLine("RPUSH", "b", "x", "y"))
+	source.Exec(client, utils.ToCmdLine("SET", "c", "3"))
+	target.Exec(targetClient, utils.ToCmdLine("SET", "c", "old"))
+
+	assertReply(t, source.Exec(client, utils.ToCmdLine("MIGRATE", host, port, "a", "2", "1000")), "+OK\r\n")
+	assertReply(t, source.Exec(client, utils.ToCmdLine("EXISTS", "a")), ":0\r\n")
+	assertReply(t, target.Exec(targetClient, utils.ToCmdLine("GET", "a")), "$1\r\n1\r\n")
+	if ttl := target.Exec(targetClient, utils.ToCmdLine("TTL", "a")).(*reply.IntReply).Code; ttl < 98 || ttl > 100 {
+		t.Errorf("Expected the TTL to be migrated, got %d", ttl)
+	}
+	assertReply(t, source.Exec(client, utils.ToCmdLine("MIGRATE", host, port, "a", "2", "1000")), "+NOKEY\r\n")
+
+	r := source.Exec(client, utils.ToCmdLine("MIGRATE", host, port, "", "2", "1000", "KEYS", "b", "c"))
+	if !strings.HasPrefix(string(r.ToBytes()), "-ERR Target instance replied with error: BUSYKEY") {
+		t.Errorf("Expected the target to refuse the existing key, got %q", r.ToBytes())
+	}
+	assertReply(t, source.Exec(client, utils.ToCmdLine("EXISTS", "b", "c")), ":1\r\n")
+	assertReply(t, target.Exec(targetClient, utils.ToCmdLine("LRANGE", "b", "0", "-1")), "*2\r\n$1\r\nx\r\n$1\r\ny\r\n")
+
+	assertReply(t, source.Exec(client, utils.ToCmdLine("MIGRATE", host, port, "c", "2", "1000", "COPY", "REPLACE")), "+OK\r\n")
+	assertReply(t, source.Exec(client, utils.ToCmdLine("GET", "c")), "$1\r\n3\r\n")
+	assertReply(t, target.Exec(targetClient, utils.ToCmdLine("GET", "c")), "$1\r\n3\r\n")
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	_, closedPort, _ := net.SplitHostPort(listener.Addr().String())
+	_ = listener.Close()
+	assertReply(t, source.Exec(client, utils.ToCmdLine("MIGRATE", "127.0.0.1", closedPort, "c", "0", "100")), "-IOERR error or timeout connecting to the client\r\n")
+	assertReply(t, source.Exec(client, utils.ToCmdLine("GET", "c")), "$1\r\n3\r\n")
+	assertReply(t, source.Exec(client, utils.ToCmdLine("MIGRATE", host, port, "c", "0", "100", "KEYS", "c")), "-ERR When using MIGRATE KEYS option, the key argument must be set to the empty string\r\n")
+
+	// the empty key argument is not a key to migrate once KEYS is given
+	source.Exec(client, utils.ToCmdLine("SET", "", "empty"))
+	assertReply(t, source.Exec(client, utils.ToCmdLine("MIGRATE", host, port, "", "2", "1000", "KEYS")), "+NOKEY\r\n")
+	assertReply(t, source.Exec(client, utils.ToCmdLine("GET", "")), "$5\r\nempty\r\n")
+	assertReply(t, source.Exec(client, utils.ToCmdLine("MIGRATE", host, port, "", "2", "1000")), "+OK\r\n")
+	assertReply(t, target.Exec(targetClient, utils.ToCmdLine("GET", "")), "$5\r\nempty\r\n")
+}
+
+// BenchmarkWriteCommands measures the allocations of a mix of small commands on 100k keys, and the
+// TestHello tests the negotiation of the protocol and the replies shaped for RESP2 and RESP3
+func TestHello(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	d.Exec(client, utils.ToCmdLine("ZADD", "zset", "1.5", "a"))
+	d.Exec(client, utils.ToCmdLine("SADD", "set", "a"))
+	d.Exec(client, utils.ToCmdLine("HSET", "hash", "field", "a"))
+
+	exec := func(args ...string) string {
+		return string(reply.ForProtocol(d.Exec(client, utils.ToCmdLine(args...)), client.GetProtocol()).ToBytes())
+	}
+	if r := exec("HELLO", "4"); !strings.HasPrefix(r, "-NOPROTO") {
+		t.Errorf("expected -NOPROTO for an unknown version, got %q", r)
+	}
+	if r := exec("HELLO"); !strings.HasPrefix(r, "*14\r\n") || !strings.Contains(r, "$5\r\nproto\r\n:2\r\n$2\r\nid\r\n") {
+		t.Errorf("expected the properties as an array in RESP2, got %q", r)
+	}
+	assertResult := func(expected string, args ...string) {
+		t.Helper()
+		if r := exec(args...); r != expected {
+			t.Errorf("expected %q for %v, got %q", expected, args, r)
+		}
+	}
+	assertResult("$3\r\n1.5\r\n", "ZSCORE", "zset", "a")
+	assertResult("*1\r\n$1\r\na\r\n", "SMEMBERS", "set")
+	assertResult("$-1\r\n", "GET", "missing")
+	assertResult("*2\r\n$-1\r\n$1\r\na\r\n", "HMGET", "hash", "missing", "field")
+
+	if r := exec("HELLO", "3"); !strings.HasPrefix(r, "%7\r\n") || !strings.Contains(r, "$5\r\nproto\r\n:3\r\n") {
+		t.Errorf("expected the properties as a map in RESP3, got %q", r)
+	}
+	assertResult(",1.5\r\n", "ZSCORE", "zset", "a")
+	assertResult("~1\r\n$1\r\na\r\n", "SMEMBERS", "set")
+	assertResult("~0\r\n", "SINTER", "set", "missing")
+	assertResult("_\r\n", "GET", "missing")
+	assertResult("*2\r\n_\r\n$1\r\na\r\n", "HMGET", "hash", "missing", "field")
+}
+
+// TestKeyStats tests that KEYSTATS counts the keys of every DB by slot and by node of a ring
+func TestKeyStats(t *testing.T) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	db0, db1 := &connection.Connection{}, &connection.Connection{}
+	db1.SelectDB(1)
+	d.Exec(db0, utils.ToCmdLine("SET", "{user1}.name", "alice"))
+	d.Exec(db0, utils.ToCmdLine("RPUSH", "{user1}.list", "a", "b", "c"))
+	d.Exec(db1, utils.ToCmdLine("SET", "{user1}.name", "bob"))
+	for i := 0; i < 100; i++ {
+		d.Exec(db0, utils.ToCmdLine("SET", "key"+strconv.Itoa(i), "value"))
+	}
+
+	userSlot := slot.KeySlot("user1")
+	r := d.Exec(db0, utils.ToCmdLine("KEYSTATS", "SLOTS", "SLOTSRANGE", strconv.Itoa(userSlot), strconv.Itoa(userSlot)))
+	rows := r.(*reply.MultiRawReply).Replies
+	if len(rows) != 1 {
+		t.Fatalf("expected a row for the slot of the range, got %q", r.ToBytes())
+	}
+	fields := rows[0].(*reply.MultiRawReply).Replies
+	if fields[1].(*reply.IntReply).Code != 3 || fields[2].(*reply.IntReply).Code <= 0 {
+		t.Errorf("expected the 3 keys of the hash tag in its slot, got %q", rows[0].ToBytes())
+	}
+	var keys int64
+	for _, row := range d.Exec(db0, utils.ToCmdLine("KEYSTATS", "SLOTS")).(*reply.MultiRawReply).Replies {
+		keys += row.(*reply.MultiRawReply).Replies[1].(*reply.IntReply).Code
+	}
+	if keys != 103 {
+		t.Errorf("expected 103 keys in the slots, got %d", keys)
+	}
+
+	hashFunc, _ := consistenthash.NewHashFunc(consistenthash.HashCRC16, 0)
+	ring := consistenthash.NewNodeMap(hashFunc)
+	ring.AddNodes("127.0.0.1:6379", "127.0.0.1:6380")
+	expected := make(map[string]int64)
+	for i := 0; i < 100; i++ {
+		expected[ring.PickNode("key"+strconv.Itoa(i))]++
+	}
+	expected[ring.PickNode("user1")] += 3
+	r = d.Exec(db0, utils.ToCmdLine("KEYSTATS", "RING", "crc16", "0", "127.0.0.1:6380", "127.0.0.1:6379"))
+	for _, row := range r.(*reply.MultiRawReply).Replies {
+		fields := row.(*reply.MultiRawReply).Replies
+		node := string(fields[0].(*reply.BulkReply).Arg)
+		if n := fields[1].(*reply.IntReply).Code; n != expected[node] {
+			t.Errorf("expected %d keys on %s, got %d", expected[node], node, n)
+		}
+	}
+	assertReply(t, d.Exec(db0, utils.ToCmdLine("KEYSTATS", "RING", "md5", "0", "node")), "-ERR unknown cluster hash md5, expected crc32, crc16 or xxhash\r\n")
+	assertReply(t, d.Exec(db0, utils.ToCmdLine("KEYSTATS", "SLOTS", "SLOTSRANGE", "10", "5")), "-ERR invalid slot range, expected 0 <= start <= end < 16384\r\n")
+}
+
+// heap objects per key the garbage collector tracks once they are stored
+func BenchmarkWriteCommands(b *testing.B) {
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	client := &connection.Connection{}
+	const keys = 100000
+	lines := make([]CmdLine, 0, 8*keys)
+	for i := 0; i < keys; i++ {
+		key := "key:" + strconv.Itoa(i)
+		lines = append(lines,
+			utils.ToCmdLine("SET", key, "value"),
+			utils.ToCmdLine("GET", key),
+			utils.ToCmdLine("INCR", "counter:"+strconv.Itoa(i%1000)),
+			utils.ToCmdLine("EXISTS", key),
+			utils.ToCmdLine("SADD", "set:"+strconv.Itoa(i%1000), strconv.Itoa(i)),
+			utils.ToCmdLine("HSET", "hash:"+strconv.Itoa(i%1000), "field"+strconv.Itoa(i%10), "value"),
+			utils.ToCmdLine("SCARD", "set:"+strconv.Itoa(i%1000)),
+			utils.ToCmdLine("STRLEN", key),
+		)
+	}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Exec(client, lines[i%len(lines)]).ToBytes()
+	}
+	b.StopTimer()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(lines)
+	if b.N >= len(lines) {
+		b.ReportMetric((float64(after.HeapObjects)-float64(before.HeapObjects))/keys, "objects/key")
+	}
+}
+
+// TestWatchdog tests that a command waiting for a key lock is reported once as stuck, and is no
+// longer tracked when it finishes
+func TestWatchdog(t *testing.T) {
+	defer func(period int) {
+		config.Properties.WatchdogPeriod = period
+	}(config.Properties.WatchdogPeriod)
+	config.Properties.WatchdogPeriod = 500
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	conn := &connection.Connection{}
+	d.Exec(conn, utils.ToCmdLine("SET", "key", "1"))
+	if found := d.watchdog.stuck(time.Now().Add(time.Second)); len(found) != 0 {
+		t.Fatalf("expected no running command, got %q", found)
+	}
+
+	db := d.getDB(0)
+	release := make(chan struct{})
+	locked := make(chan struct{})
+	go db.WithKeyLock("key", func() {
+		close(locked)
+		<-release
+	})
+	<-locked
+	done := make(chan resp.Reply)
+	go func() {
+		done <- d.Exec(conn, utils.ToCmdLine("INCR", "key"))
+	}()
+	var found []string
+	for deadline := time.Now().Add(time.Second); len(found) == 0 && time.Now().Before(deadline); {
+		found = d.watchdog.stuck(time.Now().Add(time.Second))
+	}
+	if len(found) != 1 || !strings.Contains(found[0], "command incr") || !strings.Contains(found[0], "db 0") {
+		t.Fatalf("expected the INCR waiting for the lock to be reported, got %q", found)
+	}
+	if found := d.watchdog.stuck(time.Now().Add(time.Second)); len(found) != 0 {
+		t.Errorf("expected the stuck command to be reported once, got %q", found)
+	}
+	close(release)
+	assertReply(t, <-done, ":2\r\n")
+	for i := range d.watchdog.shards {
+		if n := len(d.watchdog.shards[i].running); n != 0 {
+			t.Errorf("expected no running command after INCR, got %d", n)
+		}
+	}
+	if stacks := allStacks(watchdogMaxStack); !strings.Contains(stacks, "goroutine ") {
+		t.Errorf("expected the stack traces of the goroutines, got %q", stacks)
+	}
+}
+
+// TestWatchdogConfigSet tests that CONFIG SET watchdog-period starts the watchdog disabled at
+// startup, and that 0 stops it
+func TestWatchdogConfigSet(t *testing.T) {
+	defer func(period int) {
+		config.Properties.WatchdogPeriod = period
+	}(config.Properties.WatchdogPeriod)
+	config.Properties.WatchdogPeriod = 0
+	d := NewStandaloneDatabase()
+	defer d.Close()
+	conn := &connection.Connection{}
+	if d.watchdog.enabled() || d.watchdog.sampling.Load() {
+		t.Fatal("expected the watchdog to be disabled")
+	}
+
+	assertReply(t, d.Exec(conn, utils.ToCmdLine("CONFIG", "SET", "watchdog-period", "20")), "+OK\r\n")
+	if !d.watchdog.enabled() || !d.watchdog.sampling.Load() {
+		t.Fatal("expected CONFIG SET to start the watchdog")
+	}
+	db := d.getDB(0)
+	release := make(chan struct{})
+	locked := make(chan struct{})
+	go db.WithKeyLock("key", func() {
+		close(locked)
+		<-release
+	})
+	<-locked
+	done := make(chan resp.Reply)
+	go func() {
+		done <- d.Exec(conn, utils.ToCmdLine("INCR", "key"))
+	}()
+	var found []string
+	for deadline := time.Now().Add(time.Second); len(found) == 0 && time.Now().Before(deadline); {
+		found = d.watchdog.stuck(time.Now().Add(time.Second))
+	}
+	close(release)
+	<-done
+	if len(found) != 1 || !strings.Contains(found[0], "command incr") {
+		t.Errorf("expected the INCR to be tracked once the watchdog started, got %q", found)
+	}
+
+	assertReply(t, d.Exec(conn, utils.ToCmdLine("CONFIG", "SET", "watchdog-period", "0")), "+OK\r\n")
+	for deadline := time.Now().Add(time.Second); d.watchdog.sampling.Load(); {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the sampling to stop once the watchdog is disabled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	d.Exec(conn, utils.ToCmdLine("INCR", "key"))
+	if found := d.watchdog.stuck(time.Now().Add(time.Second)); len(found) != 0 {
+		t.Errorf("expected no command tracked once disabled, got %q", found)
+	}
+	assertReply(t, d.Exec(conn, utils.ToCmdLine("CONFIG", "SET", "watchdog-period", "-1")), "-ERR CONFIG SET failed (possibly related to argument 'watchdog-period') - argument must be a non-negative integer\r\n")
+}
@@ -0,0 +1,410 @@
+package database
+
+import (
+	"math"
+	"redigo/datastruct/stream"
+	"redigo/interface/database"
+	"redigo/interface/resp"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getAsStream returns the stream stored at key, nil if the key doesn't exist
+func getAsStream(db *DB, key string) (*stream.Stream, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	streamObj, ok := entity.Data.(*stream.Stream)
+	if !ok {
+		return nil, reply.MakeWrongTypeErrReply()
+	}
+	return streamObj, nil
+}
+
+// makeEntriesReply formats stream entries as [[id, [field, value, ...]], ...]
+func makeEntriesReply(entries []*stream.Entry) resp.Reply {
+	if len(entries) == 0 {
+		return reply.MakeEmptyMultiBulkReply()
+	}
+	items := make([]resp.Reply, len(entries))
+	for i, entry := range entries {
+		items[i] = reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(entry.ID.String())),
+			reply.MakeMultiBulkReply(entry.Fields()),
+		})
+	}
+	return reply.MakeMultiRawReply(items)
+}
+
+// parseMaxLen parses MAXLEN [=|~] threshold starting at args[i], returns the index of the next argument
+// Approximate trimming (~) is done exactly, which is allowed since it keeps at least threshold entries
+func parseMaxLen(args [][]byte, i int) (int, int, resp.Reply) {
+	i++
+	if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+		i++
+	}
+	if i >= len(args) {
+		return 0, 0, reply.MakeSyntaxErrReply()
+	}
+	maxLen, err := strconv.Atoi(string(args[i]))
+	if err != nil || maxLen < 0 {
+		return 0, 0, reply.MakeStandardErrorReply("ERR The MAXLEN argument must be >= 0.")
+	}
+	return maxLen, i + 1, nil
+}
+
+// execXAdd implements the XADD command
+// XADD key [NOMKSTREAM] [MAXLEN [=|~] threshold] *|id field value [field value ...]
+func execXAdd(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	noMkStream := false
+	maxLen := -1
+	i := 1
+options:
+	for i < len(args) {
+		switch strings.ToUpper(string(args[i])) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			i++
+		case "MAXLEN":
+			var errReply resp.Reply
+			maxLen, i, errReply = parseMaxLen(args, i)
+			if errReply != nil {
+				return errReply
+			}
+		default:
+			break options
+		}
+	}
+	if i >= len(args) {
+		return reply.MakeSyntaxErrReply()
+	}
+	idArg := string(args[i])
+	fields := args[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return reply.MakeArgNumErrReply("xadd")
+	}
+
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		streamObj, errReply := getAsStream(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		if streamObj == nil {
+			if noMkStream {
+				result = reply.MakeNullBulkReply()
+				return
+			}
+			streamObj = stream.MakeStream()
+		}
+
+		id, err := generateStreamID(streamObj, idArg)
+		if err != nil {
+			result = reply.MakeStandardErrorReply(err.Error())
+			return
+		}
+		if err = streamObj.Add(id, fields); err != nil {
+			result = reply.MakeStandardErrorReply(err.Error())
+			return
+		}
+		if maxLen >= 0 {
+			streamObj.Trim(maxLen)
+		}
+		db.PutEntity(key, &database.DataEntity{Data: streamObj})
+
+		// the generated ID is written to the AOF so that the stream is the same after reloading
+		cmdLine := utils.ToCmdLine("XADD", key)
+		if maxLen >= 0 {
+			cmdLine = append(cmdLine, []byte("MAXLEN"), []byte(strconv.Itoa(maxLen)))
+		}
+		cmdLine = append(cmdLine, []byte(id.String()))
+		db.addAof(append(cmdLine, fields...))
+
+		result = reply.MakeBulkReply([]byte(id.String()))
+	})
+	return result
+}
+
+// generateStreamID resolves the ID argument of XADD: *, <ms>-* or an explicit ID
+func generateStreamID(streamObj *stream.Stream, idArg string) (stream.ID, error) {
+	if idArg == "*" {
+		return streamObj.NextID(uint64(time.Now().UnixMilli()))
+	}
+	if msPart, ok := strings.CutSuffix(idArg, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return stream.ID{}, stream.ErrInvalidID
+		}
+		return streamObj.NextIDWithMs(ms)
+	}
+	id, err := stream.ParseID(idArg, 0)
+	if err != nil {
+		return stream.ID{}, err
+	}
+	if id == stream.MinID {
+		return stream.ID{}, stream.ErrZeroID
+	}
+	return id, nil
+}
+
+// execXLen implements the XLEN command
+// XLEN key
+func execXLen(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		streamObj, errReply := getAsStream(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		if streamObj == nil {
+			result = reply.MakeIntReply(0)
+			return
+		}
+		result = reply.MakeIntReply(int64(streamObj.Len()))
+	})
+	return result
+}
+
+// execXTrim implements the XTRIM command
+// XTRIM key MAXLEN [=|~] threshold
+func execXTrim(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	if strings.ToUpper(string(args[1])) != "MAXLEN" {
+		return reply.MakeSyntaxErrReply()
+	}
+	maxLen, next, errReply := parseMaxLen(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+	if next != len(args) {
+		return reply.MakeSyntaxErrReply()
+	}
+
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		streamObj, errReply := getAsStream(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		if streamObj == nil {
+			result = reply.MakeIntReply(0)
+			return
+		}
+		removed := streamObj.Trim(maxLen)
+		if removed > 0 {
+			db.addAof(utils.ToCmdLine("XTRIM", key, "MAXLEN", strconv.Itoa(maxLen)))
+		}
+		result = reply.MakeIntReply(int64(removed))
+	})
+	return result
+}
+
+// parseStreamRange parses the start end [COUNT count] arguments of XRANGE
+// ok is false if the range is empty
+func parseStreamRange(args [][]byte) (start, end stream.ID, count int, ok bool, errReply resp.Reply) {
+	var err error
+	var startOk, endOk bool
+	start, startOk, err = stream.ParseRangeStart(string(args[0]))
+	if err != nil {
+		return start, end, 0, false, reply.MakeStandardErrorReply(err.Error())
+	}
+	end, endOk, err = stream.ParseRangeEnd(string(args[1]))
+	if err != nil {
+		return start, end, 0, false, reply.MakeStandardErrorReply(err.Error())
+	}
+	count = 0
+	if len(args) > 2 {
+		if len(args) != 4 || strings.ToUpper(string(args[2])) != "COUNT" {
+			return start, end, 0, false, reply.MakeSyntaxErrReply()
+		}
+		count, err = strconv.Atoi(string(args[3]))
+		if err != nil {
+			return start, end, 0, false, reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+		}
+		if count <= 0 {
+			// COUNT 0 returns nothing
+			return start, end, 0, false, nil
+		}
+	}
+	return start, end, count, startOk && endOk && !end.Less(start), nil
+}
+
+// execXRange implements the XRANGE command
+// XRANGE key start end [COUNT count]
+func execXRange(db *DB, args [][]byte) resp.Reply {
+	return streamRange(db, args[0], args[1:], false)
+}
+
+// execXRevRange implements the XREVRANGE command
+// XREVRANGE key end start [COUNT count]
+func execXRevRange(db *DB, args [][]byte) resp.Reply {
+	rangeArgs := append([][]byte{args[2], args[1]}, args[3:]...)
+	return streamRange(db, args[0], rangeArgs, true)
+}
+
+// streamRange implements XRANGE and XREVRANGE, rangeArgs are start end [COUNT count]
+func streamRange(db *DB, keyArg []byte, rangeArgs [][]byte, reverse bool) resp.Reply {
+	key := string(keyArg)
+	start, end, count, ok, errReply := parseStreamRange(rangeArgs)
+	if errReply != nil {
+		return errReply
+	}
+
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		streamObj, errReply := getAsStream(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		if streamObj == nil || !ok {
+			result = reply.MakeEmptyMultiBulkReply()
+			return
+		}
+		if reverse {
+			result = makeEntriesReply(streamObj.RevRange(start, end, count))
+		} else {
+			result = makeEntriesReply(streamObj.Range(start, end, count))
+		}
+	})
+	return result
+}
+
+// execXRead implements the XREAD command
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func execXRead(db *DB, client resp.Connection, args [][]byte) resp.Reply {
+	count := 0
+	block := time.Duration(-1)
+	i := 0
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "STREAMS" {
+			break
+		}
+		if i+1 >= len(args) {
+			return reply.MakeSyntaxErrReply()
+		}
+		val, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		switch option {
+		case "COUNT":
+			if err != nil {
+				return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+			}
+			count = int(val)
+		case "BLOCK":
+			if err != nil {
+				return reply.MakeStandardErrorReply("ERR timeout is not an integer or out of range")
+			}
+			if val < 0 {
+				return reply.MakeStandardErrorReply("ERR timeout is negative")
+			}
+			if val > math.MaxInt64/int64(time.Millisecond) {
+				return reply.MakeStandardErrorReply("ERR timeout is out of range")
+			}
+			block = time.Duration(val) * time.Millisecond
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+		i++
+	}
+	streamArgs := args[i+1:]
+	if i >= len(args) || len(streamArgs) == 0 || len(streamArgs)%2 != 0 {
+		return reply.MakeStandardErrorReply("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+	keys := make([]string, len(streamArgs)/2)
+	for j := range keys {
+		keys[j] = string(streamArgs[j])
+	}
+
+	// resolve the IDs, $ means the last ID when the command is called
+	ids := make([]stream.ID, len(keys))
+	for j, key := range keys {
+		idArg := string(streamArgs[len(keys)+j])
+		if idArg != "$" {
+			id, err := stream.ParseID(idArg, 0)
+			if err != nil {
+				return reply.MakeStandardErrorReply(err.Error())
+			}
+			ids[j] = id
+			continue
+		}
+		var errReply resp.Reply
+		db.WithKeyRLock(key, func() {
+			streamObj, err := getAsStream(db, key)
+			if err != nil {
+				errReply = err
+			} else if streamObj != nil {
+				ids[j] = streamObj.LastID()
+			}
+		})
+		if errReply != nil {
+			return errReply
+		}
+	}
+
+	if block < 0 {
+		return readStreams(db, keys, ids, count)
+	}
+	result, ok := db.block(client, keys, block, func(func(string) bool) resp.Reply {
+		result := readStreams(db, keys, ids, count)
+		if _, ok := result.(*reply.NullMultiBulkReply); ok {
+			return nil
+		}
+		return result
+	})
+	if !ok {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return result
+}
+
+// readStreams returns the entries after the given IDs as [[key, entries], ...], null if there is none
+func readStreams(db *DB, keys []string, ids []stream.ID, count int) resp.Reply {
+	var items []resp.Reply
+	for j, key := range keys {
+		var entries []*stream.Entry
+		var errReply resp.Reply
+		db.WithKeyRLock(key, func() {
+			streamObj, err := getAsStream(db, key)
+			if err != nil {
+				errReply = err
+			} else if streamObj != nil {
+				entries = streamObj.After(ids[j], count)
+			}
+		})
+		if errReply != nil {
+			return errReply
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		items = append(items, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(key)),
+			makeEntriesReply(entries),
+		}))
+	}
+	if len(items) == 0 {
+		return reply.MakeNullMultiBulkReply()
+	}
+	return reply.MakeMultiRawReply(items)
+}
+
+// Register stream commands
+func init() {
+	RegisterCommand("XADD", execXAdd, -5)           // key [NOMKSTREAM] [MAXLEN [=|~] n] *|id field value [...]
+	RegisterCommand("XLEN", execXLen, 2)            // key
+	RegisterCommand("XTRIM", execXTrim, -4)         // key MAXLEN [=|~] threshold
+	RegisterCommand("XRANGE", execXRange, -4)       // key start end [COUNT count]
+	RegisterCommand("XREVRANGE", execXRevRange, -4) // key end start [COUNT count]
+	registerBlockingCommand("XREAD", execXRead, -4) // [COUNT count] [BLOCK ms] STREAMS key [key ...] id [id ...]
+}
@@ -0,0 +1,50 @@
+package database
+
+import "testing"
+
+// TestXAddIDs tests the IDs generated and refused by XADD
+func TestXAddIDs(t *testing.T) {
+	db := MakeDB()
+	assertReply(t, exec(db, "XADD", "s", "1-1", "a", "1"), "$3\r\n1-1\r\n")
+	assertReply(t, exec(db, "XADD", "s", "1-*", "b", "2"), "$3\r\n1-2\r\n")
+	assertReply(t, exec(db, "XADD", "s", "1-1", "c", "3"), "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n")
+	assertReply(t, exec(db, "XADD", "other", "0-0", "c", "3"), "-ERR The ID specified in XADD must be greater than 0-0\r\n")
+	assertReply(t, exec(db, "XADD", "s", "3-0", "d"), "-ERR wrong number of arguments for 'xadd' command\r\n")
+	assertReply(t, exec(db, "XLEN", "s"), ":2\r\n")
+	assertReply(t, exec(db, "XLEN", "missing"), ":0\r\n")
+	assertReply(t, exec(db, "EXISTS", "other"), ":0\r\n")
+}
+
+// TestXRangeTrim tests the ranges of XRANGE and XREVRANGE, and trimming with XADD MAXLEN and XTRIM
+func TestXRangeTrim(t *testing.T) {
+	db := MakeDB()
+	exec(db, "XADD", "s", "1-1", "a", "1")
+	exec(db, "XADD", "s", "1-2", "b", "2")
+	exec(db, "XADD", "s", "2-0", "c", "3")
+	assertReply(t, exec(db, "XADD", "s", "MAXLEN", "3", "3-0", "d", "4"), "$3\r\n3-0\r\n")
+	assertReply(t, exec(db, "XLEN", "s"), ":3\r\n")
+
+	assertReply(t, exec(db, "XRANGE", "s", "-", "+", "COUNT", "2"),
+		"*2\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n")
+	assertReply(t, exec(db, "XRANGE", "s", "(1-2", "(3-0"), "*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n")
+	assertReply(t, exec(db, "XREVRANGE", "s", "+", "-", "COUNT", "1"), "*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nd\r\n$1\r\n4\r\n")
+	assertReply(t, exec(db, "XRANGE", "s", "x", "+"), "-ERR Invalid stream ID specified as stream command argument\r\n")
+
+	assertReply(t, exec(db, "XTRIM", "s", "MAXLEN", "1"), ":2\r\n")
+	assertReply(t, exec(db, "XRANGE", "s", "-", "+"), "*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nd\r\n$1\r\n4\r\n")
+	// the last ID is kept after trimming
+	assertReply(t, exec(db, "XADD", "s", "2-5", "e", "5"), "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n")
+}
+
+// TestXRead tests XREAD without BLOCK, the streams which have no new entry are left out
+func TestXRead(t *testing.T) {
+	db := MakeDB()
+	exec(db, "XADD", "s", "1-1", "a", "1")
+	exec(db, "XADD", "s", "2-0", "c", "3")
+	assertReply(t, exec(db, "XREAD", "COUNT", "1", "STREAMS", "s", "missing", "1-1", "0"),
+		"*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n")
+	assertReply(t, exec(db, "XREAD", "STREAMS", "s", "$"), "*-1\r\n")
+	assertReply(t, exec(db, "XREAD", "STREAMS", "s", "t", "0"), "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n")
+	assertReply(t, exec(db, "XREAD", "BLOCK", "-1", "STREAMS", "s", "0"), "-ERR timeout is negative\r\n")
+	assertReply(t, exec(db, "XREAD", "BLOCK", "9223372036855", "STREAMS", "s", "0"), "-ERR timeout is out of range\r\n")
+}
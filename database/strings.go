@@ -1,37 +1,180 @@
 package database
 
 import (
+	"math"
+	"redigo/config"
 	"redigo/interface/database"
 	"redigo/interface/resp"
 	"redigo/lib/utils"
 	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // execGet retrieves the value associated with the specified key from the database.
 func execGet(db *DB, args [][]byte) resp.Reply {
-	key := string(args[0])
-	if entity, ok := db.GetEntity(key); ok {
-		// TODO: If we have multiple types, we need to check the conversion if it's not []byte
-		return reply.MakeBulkReply(entity.Data.([]byte))
+	value, errReply := getAsString(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if value == nil {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply(value)
+}
+
+// setOptions are the options of SET
+type setOptions struct {
+	nx       bool      // only set the key if it does not exist
+	xx       bool      // only set the key if it already exists
+	expireAt time.Time // zero if the value does not expire
+	keepTTL  bool      // keep the expiration time of the previous value
+	get      bool      // reply with the previous value, which must be a string
+}
+
+// parseSetOptions parses the options of SET after the key and the value
+func parseSetOptions(args [][]byte, now time.Time) (setOptions, resp.Reply) {
+	var opts setOptions
+	hasExpire := false
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch option {
+		case "NX", "XX":
+			if opts.nx || opts.xx {
+				return opts, reply.MakeSyntaxErrReply()
+			}
+			opts.nx = option == "NX"
+			opts.xx = option == "XX"
+		case "KEEPTTL":
+			if hasExpire || opts.keepTTL {
+				return opts, reply.MakeSyntaxErrReply()
+			}
+			opts.keepTTL = true
+		case "GET":
+			if opts.get {
+				return opts, reply.MakeSyntaxErrReply()
+			}
+			opts.get = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasExpire || opts.keepTTL || i+1 >= len(args) {
+				return opts, reply.MakeSyntaxErrReply()
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return opts, reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+			}
+			unit := time.Second
+			if option[0] == 'P' {
+				unit = time.Millisecond
+			}
+			expireAt, ok := toExpireAt(n, unit, strings.HasSuffix(option, "AT"), now)
+			if n <= 0 || !ok {
+				return opts, reply.MakeStandardErrorReply("ERR invalid expire time in 'set' command")
+			}
+			opts.expireAt = expireAt
+			hasExpire = true
+		default:
+			return opts, reply.MakeSyntaxErrReply()
+		}
 	}
-	return reply.MakeNullBulkReply()
+	return opts, nil
+}
+
+// execSetGeneric stores the value with the options of SET, the value and its expiration time
+// are set under the key lock so that no client sees the value without its TTL
+// The AOF receives SET with an absolute PXAT expiration, so that replaying the file does not
+// restart the TTL
+// With GET, the previous value is returned instead of OK, and nothing is written if the key
+// holds another type than a string
+func execSetGeneric(db *DB, key string, value []byte, opts setOptions) resp.Reply {
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		var old []byte
+		if opts.get {
+			var errReply reply.ErrorReply
+			if old, errReply = getAsString(db, key); errReply != nil {
+				result = errReply
+				return
+			}
+		}
+		_, exists := db.GetEntity(key)
+		if (opts.nx && exists) || (opts.xx && !exists) {
+			result = oldValueReply(old)
+			return
+		}
+		db.PutEntity(key, &database.DataEntity{
+			Data: value,
+		})
+		cmdLine := utils.ToCmdLineWithName("SET", []byte(key), value)
+		switch {
+		case !opts.expireAt.IsZero():
+			db.Expire(key, opts.expireAt)
+			cmdLine = append(cmdLine, []byte("PXAT"), []byte(strconv.FormatInt(opts.expireAt.UnixMilli(), 10)))
+		case opts.keepTTL:
+			cmdLine = append(cmdLine, []byte("KEEPTTL"))
+		default:
+			// SET discards the expiration time of the previous value
+			db.Persist(key)
+		}
+		db.addAof(cmdLine)
+		if opts.get {
+			result = oldValueReply(old)
+		} else {
+			result = reply.MakeOKReply()
+		}
+	})
+	return result
+}
+
+// oldValueReply replies with the previous value of SET GET and GETSET
+func oldValueReply(old []byte) resp.Reply {
+	if old == nil {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply(old)
 }
 
 // execSet stores the specified key-value pair in the database.
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
 func execSet(db *DB, args [][]byte) resp.Reply {
-	key := string(args[0])
-	value := args[1]
-	entity := &database.DataEntity{
-		Data: value,
+	opts, errReply := parseSetOptions(args[2:], time.Now())
+	if errReply != nil {
+		return errReply
 	}
-	db.PutEntity(key, entity)
-	db.addAof(utils.ToCmdLineWithName("SET", args...))
-	return reply.MakeOKReply()
+	return execSetGeneric(db, string(args[0]), args[1], opts)
+}
+
+// execSetExGeneric implements SETEX and PSETEX, the TTL must be a positive integer
+func execSetExGeneric(db *DB, cmdName string, args [][]byte, unit time.Duration) resp.Reply {
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	expireAt, ok := toExpireAt(ttl, unit, false, time.Now())
+	if ttl <= 0 || !ok {
+		return reply.MakeStandardErrorReply("ERR invalid expire time in '" + cmdName + "' command")
+	}
+	return execSetGeneric(db, string(args[0]), args[2], setOptions{expireAt: expireAt})
+}
+
+// execSetEx stores the value with a TTL in seconds.
+// SETEX key seconds value
+func execSetEx(db *DB, args [][]byte) resp.Reply {
+	return execSetExGeneric(db, "setex", args, time.Second)
+}
+
+// execPSetEx stores the value with a TTL in milliseconds.
+// PSETEX key milliseconds value
+func execPSetEx(db *DB, args [][]byte) resp.Reply {
+	return execSetExGeneric(db, "psetex", args, time.Millisecond)
 }
 
 // execSetNX stores the specified key-value pair in the database only if the key does not already exist.
 // If the key already exists, it does not modify the value and returns 0.
 // If the key does not exist, it sets the value and returns 1.
+// Only a value which was set is propagated
 // SETNX key value
 func execSetNX(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
@@ -39,42 +182,339 @@ func execSetNX(db *DB, args [][]byte) resp.Reply {
 	entity := &database.DataEntity{
 		Data: value,
 	}
-	result := db.PutIfAbsent(key, entity)
-	db.addAof(utils.ToCmdLineWithName("SETNX", args...))
+	var result int
+	db.WithKeyLock(key, func() {
+		result = db.PutIfAbsent(key, entity)
+		if result > 0 {
+			db.addAof(utils.ToCmdLineWithName("SETNX", args...))
+		}
+	})
 	return reply.MakeIntReply(int64(result))
 }
 
 // execGetSet stores the specified key-value pair in the database and returns the old value associated with the key.
+// It is SET key value GET
+// GETSET key value
 func execGetSet(db *DB, args [][]byte) resp.Reply {
-	key := string(args[0])
-	value := args[1]
+	return execSetGeneric(db, string(args[0]), args[1], setOptions{get: true})
+}
 
-	entity, ok := db.GetEntity(key)
-	db.PutEntity(key, &database.DataEntity{
-		Data: value,
+// execMSet stores the key-value pairs, replacing the values and the TTL of the existing keys
+// The keys are locked together, so that no client sees some of the values and not the others
+// MSET key value [key value ...]
+func execMSet(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("mset")
+	}
+	db.WithKeysLock(pairKeys(args), func() {
+		putPairs(db, args)
+		db.addAof(utils.ToCmdLineWithName("MSET", args...))
 	})
-	db.addAof(utils.ToCmdLineWithName("GETSET", args...))
-	if !ok {
-		return reply.MakeNullBulkReply()
+	return reply.MakeOKReply()
+}
+
+// execMSetNX stores the key-value pairs only if none of the keys exists, it replies 1 if they
+// were set and 0 otherwise
+// MSETNX key value [key value ...]
+func execMSetNX(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("msetnx")
+	}
+	keys := pairKeys(args)
+	set := false
+	db.WithKeysLock(keys, func() {
+		for _, key := range keys {
+			if _, exists := db.GetEntity(key); exists {
+				return
+			}
+		}
+		putPairs(db, args)
+		db.addAof(utils.ToCmdLineWithName("MSET", args...))
+		set = true
+	})
+	if !set {
+		return reply.MakeIntReply(0)
+	}
+	return reply.MakeIntReply(1)
+}
+
+// pairKeys returns the keys of the key-value pairs of MSET and MSETNX
+func pairKeys(args [][]byte) []string {
+	keys := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, string(args[i]))
+	}
+	return keys
+}
+
+// putPairs stores the key-value pairs as strings without expiration time, the keys must be locked
+func putPairs(db *DB, args [][]byte) {
+	for i := 0; i < len(args); i += 2 {
+		key := string(args[i])
+		db.PutEntity(key, &database.DataEntity{
+			Data: args[i+1],
+		})
+		db.Persist(key)
 	}
-	return reply.MakeBulkReply(entity.Data.([]byte))
+}
+
+// execMGet replies the values of the keys, nil for the missing keys and those which do not hold
+// a string
+// MGET key [key ...]
+func execMGet(db *DB, args [][]byte) resp.Reply {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	values := make([][]byte, len(keys))
+	db.WithKeysRLock(keys, func() {
+		for i, key := range keys {
+			// a key which is not a string is nil, like Redis
+			values[i], _ = getAsString(db, key)
+		}
+	})
+	return reply.MakeMultiBulkReply(values)
 }
 
 // execStrLen retrieves the length of the value associated with the specified key.
 func execStrLen(db *DB, args [][]byte) resp.Reply {
+	value, errReply := getAsString(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	// a missing key is an empty string, like in Redis
+	return reply.MakeIntReply(int64(len(value)))
+}
+
+// maxStringLength returns the max length of a string value, the max length of a bulk string sent by clients
+func maxStringLength() int64 {
+	if config.Properties == nil || config.Properties.ProtoMaxBulkLen <= 0 {
+		return config.DefaultProtoMaxBulkLen
+	}
+	return int64(config.Properties.ProtoMaxBulkLen)
+}
+
+// execSetRange overwrites the string from offset and replies with its new length, a string shorter
+// than offset is padded with zero bytes. The new length is limited to proto-max-bulk-len so that
+// a high offset can't allocate more memory than a client could send in a single value
+// SETRANGE key offset value
+func execSetRange(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	entity, ok := db.GetEntity(key)
-	if !ok {
-		return reply.MakeNullBulkReply()
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	if offset < 0 {
+		return reply.MakeStandardErrorReply("ERR offset is out of range")
+	}
+	value := args[2]
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		old, errReply := getAsString(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		// an empty value changes nothing and does not create the key
+		if len(value) == 0 {
+			result = reply.MakeIntReply(int64(len(old)))
+			return
+		}
+		if offset > maxStringLength()-int64(len(value)) {
+			result = reply.MakeStandardErrorReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+			return
+		}
+		end := int(offset) + len(value)
+		// the stored value may be read by a reply being written, it is copied and not modified in place
+		updated := make([]byte, max(len(old), end))
+		copy(updated, old)
+		copy(updated[offset:], value)
+		db.PutEntity(key, &database.DataEntity{Data: updated})
+		db.addAof(utils.ToCmdLineWithName("SETRANGE", args...))
+		result = reply.MakeIntReply(int64(len(updated)))
+	})
+	return result
+}
+
+// execGetRange returns the substring between the offsets start and end, both included
+// Negative offsets count from the end of the string, offsets out of the string are clamped to it
+// GETRANGE key start end
+func execGetRange(db *DB, args [][]byte) resp.Reply {
+	start, err1 := strconv.ParseInt(string(args[1]), 10, 64)
+	end, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	value, errReply := getAsString(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	size := int64(len(value))
+	if start < 0 && end < 0 && start > end {
+		return reply.MakeBulkReply([]byte{})
+	}
+	if start < 0 {
+		start = max(size+start, 0)
+	}
+	if end < 0 {
+		end = max(size+end, 0)
+	}
+	end = min(end, size-1)
+	if size == 0 || start > end {
+		return reply.MakeBulkReply([]byte{})
+	}
+	return reply.MakeBulkReply(value[start : end+1])
+}
+
+// errNotInteger is replied when a value or an argument is not an integer in the range of int64
+var errNotInteger = reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+
+// execIncrByGeneric adds delta to the integer stored at key and replies with the result, a missing
+// key counts as 0. The value is read and written under the key lock and keeps its TTL
+func execIncrByGeneric(db *DB, key string, delta int64, cmdLine CmdLine) resp.Reply {
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		old, errReply := getAsString(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		var value int64
+		if old != nil {
+			var err error
+			if value, err = strconv.ParseInt(string(old), 10, 64); err != nil {
+				result = errNotInteger
+				return
+			}
+		}
+		if (delta > 0 && value > math.MaxInt64-delta) || (delta < 0 && value < math.MinInt64-delta) {
+			result = reply.MakeStandardErrorReply("ERR increment or decrement would overflow")
+			return
+		}
+		value += delta
+		db.PutEntity(key, &database.DataEntity{Data: []byte(strconv.FormatInt(value, 10))})
+		db.addAof(cmdLine)
+		result = reply.MakeIntReply(value)
+	})
+	return result
+}
+
+// execIncr increments the integer stored at key by one
+// INCR key
+func execIncr(db *DB, args [][]byte) resp.Reply {
+	return execIncrByGeneric(db, string(args[0]), 1, utils.ToCmdLineWithName("INCR", args...))
+}
+
+// execDecr decrements the integer stored at key by one
+// DECR key
+func execDecr(db *DB, args [][]byte) resp.Reply {
+	return execIncrByGeneric(db, string(args[0]), -1, utils.ToCmdLineWithName("DECR", args...))
+}
+
+// execIncrBy increments the integer stored at key by increment
+// INCRBY key increment
+func execIncrBy(db *DB, args [][]byte) resp.Reply {
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	return execIncrByGeneric(db, string(args[0]), delta, utils.ToCmdLineWithName("INCRBY", args...))
+}
+
+// execDecrBy decrements the integer stored at key by decrement
+// DECRBY key decrement
+func execDecrBy(db *DB, args [][]byte) resp.Reply {
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return errNotInteger
 	}
-	return reply.MakeIntReply(int64(len(entity.Data.([]byte))))
+	if delta == math.MinInt64 {
+		return reply.MakeStandardErrorReply("ERR decrement would overflow")
+	}
+	return execIncrByGeneric(db, string(args[0]), -delta, utils.ToCmdLineWithName("DECRBY", args...))
+}
+
+// execIncrByFloat adds increment to the number stored at key and replies with the result
+// The AOF receives SET with the result and KEEPTTL like in Redis, so that a replay does not depend
+// on the rounding of the addition
+// INCRBYFLOAT key increment
+func execIncrByFloat(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	delta, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return reply.MakeStandardErrorReply("ERR value is not a valid float")
+	}
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		old, errReply := getAsString(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		var value float64
+		if old != nil {
+			if value, err = strconv.ParseFloat(string(old), 64); err != nil || math.IsNaN(value) {
+				result = reply.MakeStandardErrorReply("ERR value is not a valid float")
+				return
+			}
+		}
+		value += delta
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			result = reply.MakeStandardErrorReply("ERR increment would produce NaN or Infinity")
+			return
+		}
+		formatted := []byte(strconv.FormatFloat(value, 'f', -1, 64))
+		db.PutEntity(key, &database.DataEntity{Data: formatted})
+		db.addAof(utils.ToCmdLineWithName("SET", []byte(key), formatted, []byte("KEEPTTL")))
+		result = reply.MakeBulkReply(formatted)
+	})
+	return result
+}
+
+// execAppend appends the value to the string stored at key and replies with its new length, a
+// missing key is created. The new length is limited to proto-max-bulk-len like SETRANGE
+// APPEND key value
+func execAppend(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	value := args[1]
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		old, errReply := getAsString(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		if int64(len(old)) > maxStringLength()-int64(len(value)) {
+			result = reply.MakeStandardErrorReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+			return
+		}
+		// the stored value may be read by a reply being written, it is copied and not modified in place
+		updated := make([]byte, 0, len(old)+len(value))
+		updated = append(append(updated, old...), value...)
+		db.PutEntity(key, &database.DataEntity{Data: updated})
+		db.addAof(utils.ToCmdLineWithName("APPEND", args...))
+		result = reply.MakeIntReply(int64(len(updated)))
+	})
+	return result
 }
 
 func init() {
 	RegisterCommand("GET", execGet, 2)
-	RegisterCommand("SET", execSet, 3)
+	RegisterCommand("SET", execSet, -3)
 	RegisterCommand("SETNX", execSetNX, 3)
 	RegisterCommand("GETSET", execGetSet, 3)
-	RegisterCommand("SETEX", execSet, 4)
+	RegisterCommand("MSET", execMSet, -3)
+	RegisterCommand("MSETNX", execMSetNX, -3)
+	RegisterCommand("MGET", execMGet, -2)
+	RegisterCommand("SETEX", execSetEx, 4)
+	RegisterCommand("PSETEX", execPSetEx, 4)
 	RegisterCommand("STRLEN", execStrLen, 2)
+	RegisterCommand("SETRANGE", execSetRange, 4)
+	RegisterCommand("GETRANGE", execGetRange, 4)
+	RegisterCommand("INCR", execIncr, 2)
+	RegisterCommand("DECR", execDecr, 2)
+	RegisterCommand("INCRBY", execIncrBy, 3)
+	RegisterCommand("DECRBY", execDecrBy, 3)
+	RegisterCommand("INCRBYFLOAT", execIncrByFloat, 3)
+	RegisterCommand("APPEND", execAppend, 3)
 }
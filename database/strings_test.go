@@ -0,0 +1,303 @@
+package database
+
+import (
+	"bytes"
+	"redigo/config"
+	"redigo/interface/resp"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// exec runs a command on the DB
+func exec(db *DB, args ...string) resp.Reply {
+	return db.Exec(nil, utils.ToCmdLine(args...))
+}
+
+func assertReply(t *testing.T, r resp.Reply, expected string) {
+	t.Helper()
+	if actual := string(r.ToBytes()); actual != expected {
+		t.Errorf("Expected reply %q, got %q", expected, actual)
+	}
+}
+
+// makeKeysOfAllTypes creates a key of each non string type and returns the command reading it back
+func makeKeysOfAllTypes(t *testing.T, db *DB) map[string][]string {
+	t.Helper()
+	exec(db, "HSET", "hash", "field", "value")
+	exec(db, "RPUSH", "list", "a", "b")
+	exec(db, "SADD", "set", "a", "b")
+	exec(db, "ZADD", "zset", "1", "a")
+	exec(db, "XADD", "stream", "1-1", "field", "value")
+	return map[string][]string{
+		"hash":   {"HGET", "hash", "field"},
+		"list":   {"LLEN", "list"},
+		"set":    {"SCARD", "set"},
+		"zset":   {"ZCARD", "zset"},
+		"stream": {"XLEN", "stream"},
+	}
+}
+
+// TestGetSet tests GETSET on missing and string keys
+func TestGetSet(t *testing.T) {
+	db := MakeDB()
+
+	assertReply(t, exec(db, "GETSET", "key", "v1"), string(reply.MakeNullBulkReply().ToBytes()))
+	assertReply(t, exec(db, "GET", "key"), "$2\r\nv1\r\n")
+
+	exec(db, "EXPIRE", "key", "100")
+	assertReply(t, exec(db, "GETSET", "key", "v2"), "$2\r\nv1\r\n")
+	assertReply(t, exec(db, "GET", "key"), "$2\r\nv2\r\n")
+	// GETSET discards the TTL of the previous value like SET
+	assertReply(t, exec(db, "TTL", "key"), ":-1\r\n")
+}
+
+// TestGetSetWrongType tests that GETSET neither panics nor overwrites a key of another type
+func TestGetSetWrongType(t *testing.T) {
+	db := MakeDB()
+	readers := makeKeysOfAllTypes(t, db)
+	wrongType := string(reply.MakeWrongTypeErrReply().ToBytes())
+
+	for key, reader := range readers {
+		before := string(exec(db, reader...).ToBytes())
+		assertReply(t, exec(db, "GETSET", key, "value"), wrongType)
+		assertReply(t, exec(db, "SET", key, "value", "GET"), wrongType)
+		// the key keeps its value
+		assertReply(t, exec(db, reader...), before)
+	}
+}
+
+// TestSetGet tests the GET option of SET with the other options
+func TestSetGet(t *testing.T) {
+	db := MakeDB()
+	null := string(reply.MakeNullBulkReply().ToBytes())
+
+	assertReply(t, exec(db, "SET", "key", "v1", "GET"), null)
+	assertReply(t, exec(db, "SET", "key", "v2", "GET", "EX", "100"), "$2\r\nv1\r\n")
+	assertReply(t, exec(db, "GET", "key"), "$2\r\nv2\r\n")
+	if expireAt, ok := db.ExpireTime("key"); !ok || time.Until(expireAt) > 100*time.Second {
+		t.Errorf("Expected SET GET EX to set the TTL, got %v", expireAt)
+	}
+
+	// NX does not set the existing key but still returns its value
+	assertReply(t, exec(db, "SET", "key", "v3", "NX", "GET"), "$2\r\nv2\r\n")
+	assertReply(t, exec(db, "GET", "key"), "$2\r\nv2\r\n")
+
+	// XX does not set the missing key
+	assertReply(t, exec(db, "SET", "missing", "v", "XX", "GET"), null)
+	assertReply(t, exec(db, "GET", "missing"), null)
+
+	assertReply(t, exec(db, "SET", "key", "v", "GET", "GET"), string(reply.MakeSyntaxErrReply().ToBytes()))
+}
+
+// TestSetOverwritesOtherTypes tests that SET without GET replaces a key of any type
+func TestSetOverwritesOtherTypes(t *testing.T) {
+	db := MakeDB()
+	readers := makeKeysOfAllTypes(t, db)
+	wrongType := string(reply.MakeWrongTypeErrReply().ToBytes())
+
+	for key := range readers {
+		assertReply(t, exec(db, "GET", key), wrongType)
+		assertReply(t, exec(db, "STRLEN", key), wrongType)
+		assertReply(t, exec(db, "SET", key, "value"), "+OK\r\n")
+		assertReply(t, exec(db, "GET", key), "$5\r\nvalue\r\n")
+	}
+}
+
+// TestMSetMGet tests that MSET replaces the values and the TTL of the keys, that MSETNX sets
+// nothing if one of the keys exists, and that MGET replies nil for the keys without a string
+func TestMSetMGet(t *testing.T) {
+	db := MakeDB()
+	exec(db, "SET", "a", "old", "EX", "100")
+	exec(db, "RPUSH", "list", "x")
+
+	assertReply(t, exec(db, "MSET", "a", "1", "b", "2"), "+OK\r\n")
+	assertReply(t, exec(db, "TTL", "a"), ":-1\r\n")
+	assertReply(t, exec(db, "MGET", "a", "missing", "list", "b"), "*4\r\n$1\r\n1\r\n$-1\r\n$-1\r\n$1\r\n2\r\n")
+	assertReply(t, exec(db, "MSET", "a", "1", "b"), "-ERR wrong number of arguments for 'mset' command\r\n")
+
+	assertReply(t, exec(db, "MSETNX", "c", "3", "b", "new"), ":0\r\n")
+	assertReply(t, exec(db, "MGET", "b", "c"), "*2\r\n$1\r\n2\r\n$-1\r\n")
+	assertReply(t, exec(db, "MSETNX", "c", "3", "d", "4"), ":1\r\n")
+	assertReply(t, exec(db, "MGET", "c", "d"), "*2\r\n$1\r\n3\r\n$1\r\n4\r\n")
+	assertReply(t, exec(db, "MSETNX", "e"), "-ERR wrong number of arguments for 'msetnx' command\r\n")
+}
+
+// TestSetRange tests SETRANGE on missing, shorter and longer strings
+func TestSetRange(t *testing.T) {
+	db := MakeDB()
+
+	assertReply(t, exec(db, "SETRANGE", "key", "0", ""), ":0\r\n")
+	assertReply(t, exec(db, "EXISTS", "key"), ":0\r\n")
+
+	assertReply(t, exec(db, "SETRANGE", "key", "3", "abc"), ":6\r\n")
+	assertReply(t, exec(db, "GET", "key"), "$6\r\n\x00\x00\x00abc\r\n")
+
+	exec(db, "SET", "key", "Hello World", "EX", "100")
+	assertReply(t, exec(db, "SETRANGE", "key", "6", "Redis"), ":11\r\n")
+	assertReply(t, exec(db, "GET", "key"), "$11\r\nHello Redis\r\n")
+	assertReply(t, exec(db, "SETRANGE", "key", "11", "!"), ":12\r\n")
+	assertReply(t, exec(db, "SETRANGE", "key", "0", ""), ":12\r\n")
+	assertReply(t, exec(db, "GET", "key"), "$12\r\nHello Redis!\r\n")
+	// SETRANGE keeps the TTL
+	assertReply(t, exec(db, "TTL", "key"), ":100\r\n")
+
+	assertReply(t, exec(db, "SETRANGE", "key", "-1", "a"), "-ERR offset is out of range\r\n")
+	assertReply(t, exec(db, "SETRANGE", "key", "one", "a"), "-ERR value is not an integer or out of range\r\n")
+	exec(db, "RPUSH", "list", "a")
+	assertReply(t, exec(db, "SETRANGE", "list", "0", "a"), string(reply.MakeWrongTypeErrReply().ToBytes()))
+}
+
+// TestSetRangeMaxLength tests that SETRANGE can't grow a string over proto-max-bulk-len
+func TestSetRangeMaxLength(t *testing.T) {
+	defer func(limit int) {
+		config.Properties.ProtoMaxBulkLen = limit
+	}(config.Properties.ProtoMaxBulkLen)
+	config.Properties.ProtoMaxBulkLen = 16
+	db := MakeDB()
+	tooLong := "-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n"
+
+	assertReply(t, exec(db, "SETRANGE", "key", "12", "abcd"), ":16\r\n")
+	assertReply(t, exec(db, "SETRANGE", "key", "13", "abcd"), tooLong)
+	assertReply(t, exec(db, "SETRANGE", "big", "9223372036854775807", "a"), tooLong)
+	assertReply(t, exec(db, "EXISTS", "big"), ":0\r\n")
+	// the high offset without value is accepted like in Redis
+	assertReply(t, exec(db, "SETRANGE", "key", "9223372036854775807", ""), ":16\r\n")
+
+	config.Properties.ProtoMaxBulkLen = 0
+	assertReply(t, exec(db, "SETRANGE", "key", strconv.Itoa(config.DefaultProtoMaxBulkLen), "a"), tooLong)
+}
+
+// TestGetRange tests GETRANGE with positive, negative and out of range offsets
+func TestGetRange(t *testing.T) {
+	db := MakeDB()
+	exec(db, "SET", "key", "This is a string")
+	empty := "$0\r\n\r\n"
+
+	assertReply(t, exec(db, "GETRANGE", "key", "0", "3"), "$4\r\nThis\r\n")
+	assertReply(t, exec(db, "GETRANGE", "key", "-3", "-1"), "$3\r\ning\r\n")
+	assertReply(t, exec(db, "GETRANGE", "key", "0", "-1"), "$16\r\nThis is a string\r\n")
+	assertReply(t, exec(db, "GETRANGE", "key", "10", "100"), "$6\r\nstring\r\n")
+	assertReply(t, exec(db, "GETRANGE", "key", "-100", "3"), "$4\r\nThis\r\n")
+	assertReply(t, exec(db, "GETRANGE", "key", "5", "3"), empty)
+	assertReply(t, exec(db, "GETRANGE", "key", "100", "200"), empty)
+	assertReply(t, exec(db, "GETRANGE", "key", "-1", "-5"), empty)
+	assertReply(t, exec(db, "GETRANGE", "key", "0", "9223372036854775807"), "$16\r\nThis is a string\r\n")
+	assertReply(t, exec(db, "GETRANGE", "missing", "0", "-1"), empty)
+	assertReply(t, exec(db, "GETRANGE", "key", "a", "1"), "-ERR value is not an integer or out of range\r\n")
+	exec(db, "RPUSH", "list", "a")
+	assertReply(t, exec(db, "GETRANGE", "list", "0", "1"), string(reply.MakeWrongTypeErrReply().ToBytes()))
+}
+
+// TestIncrDecr tests the integer increments, their overflow detection and that they keep the TTL
+func TestIncrDecr(t *testing.T) {
+	db := MakeDB()
+	var propagated []string
+	db.addAof = func(line CmdLine) {
+		propagated = append(propagated, string(bytes.Join(line, []byte(" "))))
+	}
+
+	assertReply(t, exec(db, "INCR", "counter"), ":1\r\n")
+	assertReply(t, exec(db, "INCRBY", "counter", "41"), ":42\r\n")
+	assertReply(t, exec(db, "DECR", "counter"), ":41\r\n")
+	assertReply(t, exec(db, "DECRBY", "counter", "-9"), ":50\r\n")
+	assertReply(t, exec(db, "GET", "counter"), "$2\r\n50\r\n")
+	assertReply(t, exec(db, "DECRBY", "missing", "5"), ":-5\r\n")
+
+	exec(db, "SET", "ttl", "10", "EX", "100")
+	assertReply(t, exec(db, "INCR", "ttl"), ":11\r\n")
+	assertReply(t, exec(db, "TTL", "ttl"), ":100\r\n")
+
+	overflow := "-ERR increment or decrement would overflow\r\n"
+	exec(db, "SET", "max", "9223372036854775807")
+	assertReply(t, exec(db, "INCR", "max"), overflow)
+	exec(db, "SET", "min", "-9223372036854775808")
+	assertReply(t, exec(db, "DECR", "min"), overflow)
+	assertReply(t, exec(db, "INCRBY", "min", "-1"), overflow)
+	assertReply(t, exec(db, "DECRBY", "counter", "-9223372036854775808"), "-ERR decrement would overflow\r\n")
+	assertReply(t, exec(db, "GET", "max"), "$19\r\n9223372036854775807\r\n")
+
+	notInteger := "-ERR value is not an integer or out of range\r\n"
+	exec(db, "SET", "text", "abc")
+	assertReply(t, exec(db, "INCR", "text"), notInteger)
+	exec(db, "SET", "spaced", " 1")
+	assertReply(t, exec(db, "INCR", "spaced"), notInteger)
+	assertReply(t, exec(db, "INCRBY", "counter", "1.5"), notInteger)
+	exec(db, "RPUSH", "list", "a")
+	assertReply(t, exec(db, "INCR", "list"), string(reply.MakeWrongTypeErrReply().ToBytes()))
+
+	expected := []string{"INCR counter", "INCRBY counter 41", "DECR counter", "DECRBY counter -9", "DECRBY missing 5"}
+	for i, line := range expected {
+		if i >= len(propagated) || propagated[i] != line {
+			t.Fatalf("Expected the commands %v to be propagated, got %v", expected, propagated)
+		}
+	}
+}
+
+// TestIncrByFloat tests INCRBYFLOAT and that it is propagated as SET with the result
+func TestIncrByFloat(t *testing.T) {
+	db := MakeDB()
+	var propagated []string
+	db.addAof = func(line CmdLine) {
+		propagated = append(propagated, string(bytes.Join(line, []byte(" "))))
+	}
+
+	exec(db, "SET", "key", "10.50", "EX", "100")
+	assertReply(t, exec(db, "INCRBYFLOAT", "key", "0.1"), "$4\r\n10.6\r\n")
+	assertReply(t, exec(db, "INCRBYFLOAT", "key", "-5"), "$3\r\n5.6\r\n")
+	assertReply(t, exec(db, "TTL", "key"), ":100\r\n")
+	exec(db, "SET", "exp", "5.0e3")
+	assertReply(t, exec(db, "INCRBYFLOAT", "exp", "2.0e2"), "$4\r\n5200\r\n")
+	assertReply(t, exec(db, "INCRBYFLOAT", "missing", "3"), "$1\r\n3\r\n")
+
+	notFloat := "-ERR value is not a valid float\r\n"
+	assertReply(t, exec(db, "INCRBYFLOAT", "key", "abc"), notFloat)
+	assertReply(t, exec(db, "INCRBYFLOAT", "key", "inf"), notFloat)
+	exec(db, "SET", "text", "abc")
+	assertReply(t, exec(db, "INCRBYFLOAT", "text", "1"), notFloat)
+	exec(db, "SET", "huge", "1.7e308")
+	assertReply(t, exec(db, "INCRBYFLOAT", "huge", "1.7e308"), "-ERR increment would produce NaN or Infinity\r\n")
+
+	if len(propagated) < 2 || propagated[1] != "SET key 10.6 KEEPTTL" {
+		t.Errorf("Expected INCRBYFLOAT to be propagated as SET with KEEPTTL, got %v", propagated)
+	}
+}
+
+// TestAppend tests APPEND on missing and existing strings and its length limit
+func TestAppend(t *testing.T) {
+	defer func(limit int) {
+		config.Properties.ProtoMaxBulkLen = limit
+	}(config.Properties.ProtoMaxBulkLen)
+	config.Properties.ProtoMaxBulkLen = 16
+	db := MakeDB()
+
+	assertReply(t, exec(db, "APPEND", "key", "Hello"), ":5\r\n")
+	assertReply(t, exec(db, "APPEND", "key", " World"), ":11\r\n")
+	assertReply(t, exec(db, "GET", "key"), "$11\r\nHello World\r\n")
+	assertReply(t, exec(db, "APPEND", "key", "!!!!!!"), "-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n")
+	assertReply(t, exec(db, "APPEND", "key", ""), ":11\r\n")
+	exec(db, "RPUSH", "list", "a")
+	assertReply(t, exec(db, "APPEND", "list", "a"), string(reply.MakeWrongTypeErrReply().ToBytes()))
+}
+
+// TestLCS tests LCS and its options with the example of the Redis documentation
+func TestLCS(t *testing.T) {
+	db := MakeDB()
+	exec(db, "MSET", "key1", "ohmytext", "key2", "mynewtext")
+	assertReply(t, exec(db, "LCS", "key1", "key2"), "$6\r\nmytext\r\n")
+	assertReply(t, exec(db, "LCS", "key1", "key2", "LEN"), ":6\r\n")
+	assertReply(t, exec(db, "LCS", "key1", "key2", "IDX"), "*4\r\n$7\r\nmatches\r\n*2\r\n"+
+		"*2\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n"+
+		"*2\r\n*2\r\n:2\r\n:3\r\n*2\r\n:0\r\n:1\r\n"+
+		"$3\r\nlen\r\n:6\r\n")
+	assertReply(t, exec(db, "LCS", "key1", "key2", "IDX", "MINMATCHLEN", "4", "WITHMATCHLEN"), "*4\r\n$7\r\nmatches\r\n*1\r\n"+
+		"*3\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n:4\r\n"+
+		"$3\r\nlen\r\n:6\r\n")
+	assertReply(t, exec(db, "LCS", "key1", "missing"), "$0\r\n\r\n")
+	assertReply(t, exec(db, "LCS", "key1", "key2", "LEN", "IDX"), "-ERR If you want both the length and indexes, please just use IDX.\r\n")
+	assertReply(t, exec(db, "LCS", "key1", "key2", "MINMATCHLEN"), "-ERR syntax error\r\n")
+	exec(db, "RPUSH", "list", "a")
+	assertReply(t, exec(db, "LCS", "key1", "list"), string(reply.MakeWrongTypeErrReply().ToBytes()))
+}
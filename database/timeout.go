@@ -0,0 +1,95 @@
+package database
+
+import (
+	"redigo/config"
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/resp/reply"
+	"strconv"
+	"time"
+)
+
+// Commands running longer than config.Properties.CommandTimeout milliseconds:
+//   - the read-only commands walking many keys or elements, KEYS and the SCAN family, check their
+//     deadline as they go and stop with an error once it passed. They stop in the goroutine of the
+//     client, which can send nothing else meanwhile, and change nothing on the way out
+//   - the other commands can not be interrupted without leaving a half-applied change, they run to
+//     completion and the watchdog, watching the command timeout when watchdog-period is not set,
+//     logs them with the stacks of the goroutines
+//
+// The replies of LRANGE, SMEMBERS or HGETALL on big values are written out from the value after
+// the command, the time spent writing them is bounded by writeTimeout instead
+
+// cancellableExecFunc is the function of the commands which stop once their deadline passed, the
+// deadline is nil when the command is not limited
+type cancellableExecFunc func(db *DB, dl *deadline, args [][]byte) resp.Reply
+
+// deadlineCheckInterval is the number of keys or elements visited between two reads of the clock
+const deadlineCheckInterval = 256
+
+// deadline is the time a command must be done by, the nil deadline never passes
+type deadline struct {
+	at     time.Time
+	visits int
+	passed bool
+}
+
+// visit counts a key or an element visited by the command, it reports whether the deadline passed
+func (dl *deadline) visit() bool {
+	if dl == nil {
+		return false
+	}
+	if !dl.passed {
+		dl.visits++
+		if dl.visits%deadlineCheckInterval == 0 {
+			dl.passed = time.Now().After(dl.at)
+		}
+	}
+	return dl.passed
+}
+
+// exceeded reports whether the command stopped because the deadline passed
+func (dl *deadline) exceeded() bool {
+	return dl != nil && dl.passed
+}
+
+// errDeadlineReply is the reply of a command stopped by its deadline, execWithTimeout replaces it
+// with an error naming the command
+var errDeadlineReply = reply.MakeStandardErrorReply("ERR command timed out")
+
+// execWithTimeout executes a command limited to timeout, the commands which can not stop are left
+// to the watchdog
+func (db *DB) execWithTimeout(cmdName string, cmd *command, args [][]byte, timeout time.Duration) resp.Reply {
+	if cmd.cancellableExec == nil {
+		return cmd.exec(db, args)
+	}
+	start := time.Now()
+	dl := &deadline{at: start.Add(timeout)}
+	result := cmd.cancellableExec(db, dl, args)
+	if !dl.exceeded() {
+		return result
+	}
+	logger.Warn("command " + cmdName + " on db " + strconv.Itoa(db.index) + " stopped after " +
+		time.Since(start).Truncate(time.Millisecond).String() + " and " + strconv.Itoa(dl.visits) + " elements")
+	return reply.MakeStandardErrorReply("ERR command " + cmdName + " timed out after " + timeout.String())
+}
+
+// commandTimeout returns the configured execution time limit, 0 means no limit
+// Blocking commands wait on purpose and are not limited
+func commandTimeout(cmdLine CmdLine) time.Duration {
+	timeout := config.Read(func(p *config.ServerProperties) int { return p.CommandTimeout })
+	if timeout <= 0 || IsBlockingCommand(cmdLine) {
+		return 0
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+func toString(v interface{}) string {
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return "unknown panic"
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"math"
+	"redigo/datastruct/timeheap"
+	"redigo/lib/logger"
+	"redigo/lib/utils"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Expired keys are removed lazily when they are accessed, and by an active cycle so that keys
+// which are never accessed again are freed
+// The volatile keys are indexed by expiration time, so the cycle goes straight to the due keys
+// instead of sampling random keys like Redis does
+const (
+	activeExpireInterval = 100 * time.Millisecond
+	// activeExpireBatch is the number of due keys removed at each round of the cycle
+	activeExpireBatch = 64
+	// activeExpireBudget bounds the time spent by the cycle on a DB
+	activeExpireBudget = 25 * time.Millisecond
+)
+
+// expireTable holds the expiration time of the volatile keys
+type expireTable struct {
+	mu   sync.Mutex
+	heap *timeheap.Heap
+}
+
+func makeExpireTable() *expireTable {
+	return &expireTable{heap: timeheap.Make()}
+}
+
+func (t *expireTable) get(key string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.heap.Get(key)
+}
+
+func (t *expireTable) set(key string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.heap.Set(key, at)
+}
+
+// remove returns true if the key had an expiration time
+func (t *expireTable) remove(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.heap.Remove(key)
+}
+
+func (t *expireTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.heap.Len()
+}
+
+func (t *expireTable) clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.heap.Clear()
+}
+
+// earliest returns the key which expires first
+func (t *expireTable) earliest() (string, time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.heap.Min()
+}
+
+// randomKeys returns n random volatile keys, possibly repeated
+func (t *expireTable) randomKeys(n int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.heap.RandomKeys(n)
+}
+
+// due returns up to n keys whose expiration time is not after now
+func (t *expireTable) due(now time.Time, n int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.heap.Due(now, n)
+}
+
+// Expire sets the expiration time of the key
+func (db *DB) Expire(key string, expireAt time.Time) {
+	db.expires.set(key, expireAt)
+}
+
+// Persist removes the expiration time of the key, returns 1 if the key had one
+func (db *DB) Persist(key string) int {
+	if db.expires.remove(key) {
+		return 1
+	}
+	return 0
+}
+
+// ExpireTime returns the expiration time of the key, false if the key does not expire
+func (db *DB) ExpireTime(key string) (time.Time, bool) {
+	return db.expires.get(key)
+}
+
+// isExpired reports whether the key has an expiration time in the past
+// The key is not removed, callers not holding the key lock use it to skip expired keys
+func (db *DB) isExpired(key string) bool {
+	at, ok := db.expires.get(key)
+	return ok && !time.Now().Before(at)
+}
+
+// expireIfNeeded removes the key if it has expired, returns true if it has
+func (db *DB) expireIfNeeded(key string) bool {
+	if !db.isExpired(key) {
+		return false
+	}
+	db.removeKey(key, eventExpired)
+	return true
+}
+
+// activeExpire removes the due keys in batches, within activeExpireBudget
+func (db *DB) activeExpire() {
+	start := time.Now()
+	for {
+		due := db.expires.due(time.Now(), activeExpireBatch)
+		for _, key := range due {
+			db.WithKeyLock(key, func() {
+				// the expiration may have been changed since it was found due
+				db.expireIfNeeded(key)
+			})
+		}
+		if len(due) < activeExpireBatch || time.Since(start) > activeExpireBudget {
+			return
+		}
+	}
+}
+
+// startActiveExpire runs the active expire cycle on all DBs until closed is closed
+func (d *StandaloneDatabase) startActiveExpire() {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				logger.Error("active expire panic: " + toString(err))
+			}
+		}()
+		ticker := time.NewTicker(activeExpireInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.closed:
+				return
+			case <-ticker.C:
+				d.forEachDB(func(db *DB) {
+					db.activeExpire()
+				})
+			}
+		}
+	}()
+}
+
+// toExpireAt converts the time argument of EXPIRE, SET EX and their variants to an expiration
+// time, unit is the unit of n and absolute tells whether n is a unix time or a TTL
+// It returns false if the result does not fit in unix milliseconds
+func toExpireAt(n int64, unit time.Duration, absolute bool, now time.Time) (time.Time, bool) {
+	multiplier := int64(unit / time.Millisecond)
+	if n > math.MaxInt64/multiplier || n < math.MinInt64/multiplier {
+		return time.Time{}, false
+	}
+	ms := n * multiplier
+	if !absolute {
+		if ms > math.MaxInt64-now.UnixMilli() {
+			return time.Time{}, false
+		}
+		ms += now.UnixMilli()
+	}
+	return time.UnixMilli(ms), true
+}
+
+// makeExpireCmd returns the PEXPIREAT command written to the AOF, so that replaying the file
+// restores the absolute expiration time rather than restarting a relative TTL
+func makeExpireCmd(key string, expireAt time.Time) CmdLine {
+	return utils.ToCmdLine("PEXPIREAT", key, strconv.FormatInt(expireAt.UnixMilli(), 10))
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"redigo/resp/reply"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordWrites returns the write commands propagated by db, joined by spaces
+func recordWrites(db *DB) *[]string {
+	var written []string
+	db.events.subscribe(eventWritten, func(event keyEvent) {
+		args := make([]string, len(event.cmdLine))
+		for i, arg := range event.cmdLine {
+			args[i] = string(arg)
+		}
+		written = append(written, strings.Join(args, " "))
+	})
+	return &written
+}
+
+// TestSetNXExpiredKey tests that SETNX sets a key whose expiration time passed before it was
+// reclaimed, and propagates only the values it set
+func TestSetNXExpiredKey(t *testing.T) {
+	db := MakeDB()
+	written := recordWrites(db)
+	exec(db, "SET", "key", "old", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+	assertReply(t, exec(db, "SETNX", "key", "new"), ":1\r\n")
+	assertReply(t, exec(db, "GET", "key"), "$3\r\nnew\r\n")
+	assertReply(t, exec(db, "TTL", "key"), ":-1\r\n")
+	assertReply(t, exec(db, "SETNX", "key", "other"), ":0\r\n")
+	assertReply(t, exec(db, "GET", "key"), "$3\r\nnew\r\n")
+
+	if len(*written) != 2 || (*written)[1] != "SETNX key new" {
+		t.Errorf("expected only the SETNX which set the key to be propagated, got %q", *written)
+	}
+}
+
+// TestExpireConditions tests the NX, XX, GT and LT options of EXPIRE
+func TestExpireConditions(t *testing.T) {
+	db := MakeDB()
+	exec(db, "SET", "key", "value")
+	assertReply(t, exec(db, "EXPIRE", "key", "100", "XX"), ":0\r\n")
+	assertReply(t, exec(db, "EXPIRE", "key", "100", "GT"), ":0\r\n")
+	assertReply(t, exec(db, "EXPIRE", "key", "100", "NX"), ":1\r\n")
+	assertReply(t, exec(db, "EXPIRE", "key", "200", "NX"), ":0\r\n")
+	assertReply(t, exec(db, "EXPIRE", "key", "50", "GT"), ":0\r\n")
+	assertReply(t, exec(db, "EXPIRE", "key", "200", "GT"), ":1\r\n")
+	assertReply(t, exec(db, "EXPIRE", "key", "300", "LT"), ":0\r\n")
+	assertReply(t, exec(db, "EXPIRE", "key", "150", "LT"), ":1\r\n")
+	assertReply(t, exec(db, "EXPIRE", "key", "120", "XX"), ":1\r\n")
+	assertReply(t, exec(db, "TTL", "key"), ":120\r\n")
+
+	assertReply(t, exec(db, "EXPIRE", "key", "10", "NX", "XX"), "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n")
+	assertReply(t, exec(db, "EXPIRE", "key", "10", "GT", "LT"), "-ERR GT and LT options at the same time are not compatible\r\n")
+	assertReply(t, exec(db, "EXPIRE", "key", "10", "SOON"), "-ERR Unsupported option SOON\r\n")
+	assertReply(t, exec(db, "EXPIRE", "missing", "10"), ":0\r\n")
+}
+
+// TestExpirePast tests that an expiration time in the past deletes the key and propagates DEL
+func TestExpirePast(t *testing.T) {
+	db := MakeDB()
+	written := recordWrites(db)
+	exec(db, "SET", "key", "value")
+	assertReply(t, exec(db, "EXPIRE", "key", "-1"), ":1\r\n")
+	assertReply(t, exec(db, "EXISTS", "key"), ":0\r\n")
+	if (*written)[len(*written)-1] != "DEL key" {
+		t.Errorf("expected DEL to be propagated, got %q", *written)
+	}
+
+	exec(db, "SET", "key", "value")
+	assertReply(t, exec(db, "PEXPIRE", "key", "100000"), ":1\r\n")
+	if last := (*written)[len(*written)-1]; !strings.HasPrefix(last, "PEXPIREAT key ") {
+		t.Errorf("expected PEXPIREAT to be propagated, got %q", last)
+	}
+}
+
+// TestTTL tests the replies of TTL and PTTL for the missing, persistent and volatile keys
+func TestTTL(t *testing.T) {
+	db := MakeDB()
+	assertReply(t, exec(db, "TTL", "missing"), ":-2\r\n")
+	assertReply(t, exec(db, "PTTL", "missing"), ":-2\r\n")
+	exec(db, "SET", "key", "value")
+	assertReply(t, exec(db, "TTL", "key"), ":-1\r\n")
+	assertReply(t, exec(db, "PTTL", "key"), ":-1\r\n")
+
+	exec(db, "EXPIRE", "key", "100")
+	assertReply(t, exec(db, "TTL", "key"), ":100\r\n")
+	if pttl := exec(db, "PTTL", "key").(*reply.IntReply).Code; pttl < 99000 || pttl > 100000 {
+		t.Errorf("expected a PTTL close to 100000, got %d", pttl)
+	}
+
+	exec(db, "PEXPIRE", "key", "1")
+	time.Sleep(5 * time.Millisecond)
+	assertReply(t, exec(db, "TTL", "key"), ":-2\r\n")
+}
+
+// TestPersist tests that PERSIST removes the expiration time of the volatile keys only
+func TestPersist(t *testing.T) {
+	db := MakeDB()
+	written := recordWrites(db)
+	assertReply(t, exec(db, "PERSIST", "missing"), ":0\r\n")
+	exec(db, "SET", "key", "value")
+	assertReply(t, exec(db, "PERSIST", "key"), ":0\r\n")
+	exec(db, "EXPIRE", "key", "100")
+	assertReply(t, exec(db, "PERSIST", "key"), ":1\r\n")
+	assertReply(t, exec(db, "TTL", "key"), ":-1\r\n")
+	if last := (*written)[len(*written)-1]; last != "PERSIST key" {
+		t.Errorf("expected PERSIST to be propagated, got %q", last)
+	}
+	if len(*written) != 3 {
+		t.Errorf("expected the PERSIST which changed nothing not to be propagated, got %q", *written)
+	}
+}
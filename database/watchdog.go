@@ -0,0 +1,218 @@
+package database
+
+import (
+	"net"
+	"redigo/config"
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The watchdog samples the commands being executed and logs the stacks of all the goroutines when
+// one runs longer than config.Properties.WatchdogPeriod milliseconds, or the command timeout when the
+// period is not set. It costs no timer per command, and it also sees the commands stuck before
+// reaching their DB, like SAVE waiting for the writes; the stacks of the other goroutines show who
+// holds the lock.
+
+// watchdogShards spreads the running commands over several locks so that the clients do not
+// contend on a single one
+const watchdogShards = 64
+
+// watchdogMaxStack bounds the stack traces logged at once, a server with many clients has as
+// many goroutines
+const watchdogMaxStack = 4 << 20
+
+// runningCommand is a command being executed
+type runningCommand struct {
+	name     string
+	client   resp.Connection
+	dbIndex  int
+	start    time.Time
+	reported bool // set once the command has been logged as stuck
+}
+
+type watchdogShard struct {
+	mu      sync.Mutex
+	running map[uint64]runningCommand
+}
+
+// watchdog tracks the running commands and reports those running longer than period, the
+// commands are only tracked while period is positive
+type watchdog struct {
+	period atomic.Int64 // time.Duration, changed by CONFIG SET watchdog-period
+	// sampling is set while the goroutine sampling the commands runs
+	sampling atomic.Bool
+	nextID   atomic.Uint64
+	shards   [watchdogShards]watchdogShard
+}
+
+func makeWatchdog(period time.Duration) *watchdog {
+	w := &watchdog{}
+	w.period.Store(int64(period))
+	for i := range w.shards {
+		w.shards[i].running = make(map[uint64]runningCommand)
+	}
+	return w
+}
+
+// setPeriod sets the execution time reported, 0 disables the watchdog
+func (w *watchdog) setPeriod(period time.Duration) {
+	w.period.Store(int64(period))
+}
+
+// enabled reports whether the commands are tracked
+func (w *watchdog) enabled() bool {
+	return w.period.Load() > 0
+}
+
+// watchdogPeriod returns the configured execution time reported by the watchdog, the command
+// timeout when watchdog-period is not set so that the commands running past it are logged, 0
+// disables it
+func watchdogPeriod() time.Duration {
+	period := config.Read(func(p *config.ServerProperties) int {
+		if p.WatchdogPeriod > 0 {
+			return p.WatchdogPeriod
+		}
+		return p.CommandTimeout
+	})
+	return time.Duration(max(period, 0)) * time.Millisecond
+}
+
+// begin records the start of a command and returns the ID to pass to end
+func (w *watchdog) begin(c resp.Connection, cmdName string) uint64 {
+	id := w.nextID.Add(1)
+	cmd := runningCommand{
+		name:    cmdName,
+		client:  c,
+		dbIndex: c.GetDBIndex(),
+		start:   time.Now(),
+	}
+	shard := &w.shards[id%watchdogShards]
+	shard.mu.Lock()
+	shard.running[id] = cmd
+	shard.mu.Unlock()
+	return id
+}
+
+// end records the end of a command, a command reported as stuck logs how long it took
+func (w *watchdog) end(id uint64) {
+	shard := &w.shards[id%watchdogShards]
+	shard.mu.Lock()
+	cmd := shard.running[id]
+	delete(shard.running, id)
+	shard.mu.Unlock()
+	if cmd.reported {
+		logger.Warn("watchdog: command " + cmd.name + " of " + clientAddr(cmd.client) + " finished after " + time.Since(cmd.start).String())
+	}
+}
+
+// stuck returns the descriptions of the commands running for more than period at now which were
+// not reported yet, and marks them reported
+func (w *watchdog) stuck(now time.Time) []string {
+	period := time.Duration(w.period.Load())
+	if period <= 0 {
+		return nil
+	}
+	var found []string
+	for i := range w.shards {
+		shard := &w.shards[i]
+		shard.mu.Lock()
+		for id, cmd := range shard.running {
+			elapsed := now.Sub(cmd.start)
+			if cmd.reported || elapsed < period {
+				continue
+			}
+			cmd.reported = true
+			shard.running[id] = cmd
+			found = append(found, "command "+cmd.name+" of "+clientAddr(cmd.client)+" on db "+strconv.Itoa(cmd.dbIndex)+
+				" is running for "+elapsed.Truncate(time.Millisecond).String())
+		}
+		shard.mu.Unlock()
+	}
+	return found
+}
+
+// startWatchdog samples the running commands twice per period in a goroutine, unless the watchdog
+// is disabled or already sampling. The goroutine follows the changes of the period and ends once
+// the watchdog is disabled
+func (d *StandaloneDatabase) startWatchdog() {
+	w := d.watchdog
+	period := time.Duration(w.period.Load())
+	if period <= 0 || !w.sampling.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(max(period/2, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.closed:
+				w.sampling.Store(false)
+				return
+			case now := <-ticker.C:
+				current := time.Duration(w.period.Load())
+				if current <= 0 {
+					w.sampling.Store(false)
+					// enabled again meanwhile, the CONFIG SET which found it sampling left it to us
+					if current = time.Duration(w.period.Load()); current <= 0 || !w.sampling.CompareAndSwap(false, true) {
+						return
+					}
+				}
+				if current != period {
+					period = current
+					ticker.Reset(max(period/2, time.Millisecond))
+				}
+				found := w.stuck(now)
+				if len(found) == 0 {
+					continue
+				}
+				msg := "watchdog:"
+				for _, cmd := range found {
+					msg += "\n  " + cmd
+				}
+				logger.Warn(msg + "\ngoroutines:\n" + allStacks(watchdogMaxStack))
+			}
+		}
+	}()
+}
+
+// setWatchdogPeriod sets watchdog-period or the command timeout, which change the execution time
+// reported by the watchdog, the watchdog starts when it was disabled and stops with 0
+func setWatchdogPeriod(d *StandaloneDatabase, name, value string) string {
+	if msg := setNonNegative(d, name, value); msg != "" {
+		return msg
+	}
+	d.watchdog.setPeriod(watchdogPeriod())
+	d.startWatchdog()
+	return ""
+}
+
+// allStacks returns the stack traces of all the goroutines, truncated to max bytes
+func allStacks(max int) string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		if len(buf) >= max {
+			return string(buf) + "\n(truncated)"
+		}
+		buf = make([]byte, min(len(buf)*2, max))
+	}
+}
+
+// clientAddr returns the address of the client, or "client" for the connections without one
+// like the AOF replay
+func clientAddr(c resp.Connection) string {
+	if conn, ok := c.(interface{ RemoteAddr() net.Addr }); ok {
+		if addr := conn.RemoteAddr(); addr != nil {
+			return addr.String()
+		}
+	}
+	return "client"
+}
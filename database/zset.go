@@ -1,18 +1,21 @@
 package database
 
 import (
+	"math"
+	"redigo/datastruct/zset"
 	"redigo/interface/database"
 	"redigo/interface/resp"
 	"redigo/lib/utils"
 	"redigo/resp/reply"
 	"strconv"
+	"strings"
 )
 
 // parseFloat parses a string to float64, handling errors
 func parseFloat(val string) (float64, resp.Reply) {
 	score, err := strconv.ParseFloat(val, 64)
 	if err != nil {
-		return 0, reply.MakeStandardErrorReply("value is not a valid float")
+		return 0, reply.MakeStandardErrorReply("ERR value is not a valid float")
 	}
 	return score, nil
 }
@@ -91,7 +94,7 @@ func execZScore(db *DB, args [][]byte) resp.Reply {
 		return reply.MakeNullBulkReply()
 	}
 
-	return reply.MakeBulkReply([]byte(strconv.FormatFloat(score, 'f', -1, 64)))
+	return reply.MakeDoubleReply(score)
 }
 
 // execZCard implements the ZCARD command
@@ -117,13 +120,26 @@ func execZCard(db *DB, args [][]byte) resp.Reply {
 // execZRange implements the ZRANGE command
 // ZRANGE key start stop [WITHSCORES]
 func execZRange(db *DB, args [][]byte) resp.Reply {
+	return execRangeByRank(db, args, false)
+}
+
+// execZRevRange implements the ZREVRANGE command, ranks count from the highest score
+// ZREVRANGE key start stop [WITHSCORES]
+func execZRevRange(db *DB, args [][]byte) resp.Reply {
+	return execRangeByRank(db, args, true)
+}
+
+// execRangeByRank replies the members between two ranks, in descending order if desc
+func execRangeByRank(db *DB, args [][]byte, desc bool) resp.Reply {
 	if len(args) < 3 {
 		return reply.MakeStandardErrorReply("wrong number of arguments for 'zrange' command")
 	}
 
 	withScores := false
-	if len(args) > 3 && string(args[3]) == "WITHSCORES" {
+	if len(args) == 4 && strings.EqualFold(string(args[3]), "WITHSCORES") {
 		withScores = true
+	} else if len(args) > 3 {
+		return reply.MakeSyntaxErrReply()
 	}
 
 	key := string(args[0])
@@ -154,25 +170,158 @@ func execZRange(db *DB, args [][]byte) resp.Reply {
 			return
 		}
 
-		// Get range
-		members := zsetObj.RangeByRank(start, stop)
+		// Get range, the scores come with the members
+		resultBytes := [][]byte{}
+		zsetObj.ForEachByRank(start, stop, desc, func(member string, score float64) bool {
+			resultBytes = appendMember(resultBytes, member, score, withScores)
+			return true
+		})
+		result = reply.MakeMultiBulkReply(resultBytes)
+	})
+
+	return result
+}
+
+// appendMember appends the member to a reply, followed by its score if withScores
+func appendMember(result [][]byte, member string, score float64, withScores bool) [][]byte {
+	result = append(result, []byte(member))
+	if withScores {
+		result = append(result, []byte(reply.FormatDouble(score)))
+	}
+	return result
+}
+
+// scoreBorder is a bound of a score range, inclusive or exclusive when it is written (score
+type scoreBorder struct {
+	value   float64
+	exclude bool
+}
+
+// parseScoreBorder parses a bound of a score range: a float, -inf, +inf, or one of them after (
+func parseScoreBorder(arg []byte) (scoreBorder, bool) {
+	s := string(arg)
+	border := scoreBorder{}
+	if strings.HasPrefix(s, "(") {
+		border.exclude = true
+		s = s[1:]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) {
+		return border, false
+	}
+	border.value = value
+	return border, true
+}
+
+// scoreRange is the range of scores of ZRANGEBYSCORE, ZREMRANGEBYSCORE and the like
+type scoreRange struct {
+	min scoreBorder
+	max scoreBorder
+}
 
-		// Prepare result
-		if !withScores {
-			resultBytes := make([][]byte, len(members))
-			for i, member := range members {
-				resultBytes[i] = []byte(member)
+// parseScoreRange parses the min and max bounds of a score range
+func parseScoreRange(min, max []byte) (scoreRange, resp.Reply) {
+	minBorder, ok1 := parseScoreBorder(min)
+	maxBorder, ok2 := parseScoreBorder(max)
+	if !ok1 || !ok2 {
+		return scoreRange{}, reply.MakeStandardErrorReply("ERR min or max is not a float")
+	}
+	return scoreRange{min: minBorder, max: maxBorder}, nil
+}
+
+// contains reports whether the score is in the range
+func (r scoreRange) contains(score float64) bool {
+	if score < r.min.value || (r.min.exclude && score == r.min.value) {
+		return false
+	}
+	if score > r.max.value || (r.max.exclude && score == r.max.value) {
+		return false
+	}
+	return true
+}
+
+// forEachInRange visits the members with scores in the range, in descending order if desc
+func forEachInRange(zsetObj zset.ZSet, r scoreRange, desc bool, consumer zset.Consumer) {
+	zsetObj.ForEachByScore(r.min.value, r.max.value, desc, func(member string, score float64) bool {
+		if !r.contains(score) {
+			// an excluded bound
+			return true
+		}
+		return consumer(member, score)
+	})
+}
+
+// execZRangeByScore implements the ZRANGEBYSCORE command
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func execZRangeByScore(db *DB, args [][]byte) resp.Reply {
+	return execRangeByScore(db, args[0], args[1], args[2], args[3:], false)
+}
+
+// execZRevRangeByScore implements the ZREVRANGEBYSCORE command, from the highest score
+// ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func execZRevRangeByScore(db *DB, args [][]byte) resp.Reply {
+	return execRangeByScore(db, args[0], args[2], args[1], args[3:], true)
+}
+
+// execRangeByScore replies the members with scores between min and max, in descending order if
+// desc. A negative count of LIMIT replies all the members after offset
+func execRangeByScore(db *DB, keyArg, min, max []byte, options [][]byte, desc bool) resp.Reply {
+	key := string(keyArg)
+	scores, errReply := parseScoreRange(min, max)
+	if errReply != nil {
+		return errReply
+	}
+	withScores := false
+	offset, count := 0, -1
+	for i := 0; i < len(options); i++ {
+		switch strings.ToUpper(string(options[i])) {
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(options) {
+				return reply.MakeSyntaxErrReply()
 			}
-			result = reply.MakeMultiBulkReply(resultBytes)
-		} else {
-			resultBytes := make([][]byte, len(members)*2)
-			for i, member := range members {
-				resultBytes[i*2] = []byte(member)
-				score, _ := zsetObj.Score(member)
-				resultBytes[i*2+1] = []byte(strconv.FormatFloat(score, 'f', -1, 64))
+			var err1, err2 error
+			offset, err1 = strconv.Atoi(string(options[i+1]))
+			count, err2 = strconv.Atoi(string(options[i+2]))
+			if err1 != nil || err2 != nil {
+				return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
 			}
+			i += 2
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	var result resp.Reply
+
+	db.WithKeyRLock(key, func() {
+		zsetObj, exists := getAsZSet(db, key)
+		if !exists {
+			result = reply.MakeEmptyMultiBulkReply()
+			return
+		}
+		if zsetObj == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+
+		resultBytes := [][]byte{}
+		if offset < 0 || count == 0 {
 			result = reply.MakeMultiBulkReply(resultBytes)
+			return
 		}
+		skipped, taken := 0, 0
+		forEachInRange(zsetObj, scores, desc, func(member string, score float64) bool {
+			if skipped < offset {
+				skipped++
+				return true
+			}
+			resultBytes = appendMember(resultBytes, member, score, withScores)
+			taken++
+			return count < 0 || taken < count
+		})
+		result = reply.MakeMultiBulkReply(resultBytes)
 	})
 
 	return result
@@ -234,15 +383,10 @@ func execZCount(db *DB, args [][]byte) resp.Reply {
 
 	key := string(args[0])
 
-	// Parse min and max scores
-	min, err := parseFloat(string(args[1]))
-	if err != nil {
-		return err
-	}
-
-	max, err := parseFloat(string(args[2]))
-	if err != nil {
-		return err
+	// Parse min and max scores, (score excludes the bound
+	scores, errReply := parseScoreRange(args[1], args[2])
+	if errReply != nil {
+		return errReply
 	}
 
 	var result resp.Reply
@@ -261,7 +405,15 @@ func execZCount(db *DB, args [][]byte) resp.Reply {
 		}
 
 		// Count elements in range
-		count := zsetObj.Count(min, max)
+		count := 0
+		if !scores.min.exclude && !scores.max.exclude {
+			count = zsetObj.Count(scores.min.value, scores.max.value)
+		} else {
+			forEachInRange(zsetObj, scores, false, func(string, float64) bool {
+				count++
+				return true
+			})
+		}
 
 		result = reply.MakeIntReply(int64(count))
 	})
@@ -272,6 +424,17 @@ func execZCount(db *DB, args [][]byte) resp.Reply {
 // execZRank implements the ZRANK command
 // ZRANK key member
 func execZRank(db *DB, args [][]byte) resp.Reply {
+	return execRank(db, args, false)
+}
+
+// execZRevRank implements the ZREVRANK command, the rank from the highest score
+// ZREVRANK key member
+func execZRevRank(db *DB, args [][]byte) resp.Reply {
+	return execRank(db, args, true)
+}
+
+// execRank replies the rank of a member, counted from the highest score if desc
+func execRank(db *DB, args [][]byte, desc bool) resp.Reply {
 	if len(args) != 2 {
 		return reply.MakeStandardErrorReply("wrong number of arguments for 'zrank' command")
 	}
@@ -294,33 +457,189 @@ func execZRank(db *DB, args [][]byte) resp.Reply {
 			return
 		}
 
-		score, exists := zsetObj.Score(member)
+		rank, exists := zsetObj.Rank(member, desc)
 		if !exists {
 			result = reply.MakeNullBulkReply()
 			return
 		}
 
-		// Calculate rank
-		rank := -1
-		if zsetObj.Encoding() == 1 {
-			skiplist := zsetObj.GetSkiplist()
-			rank = skiplist.GetRank(member, score)
-		} else {
-			members := zsetObj.RangeByRank(0, -1)
-			for i, m := range members {
-				if m == member {
-					rank = i
-					break
-				}
+		result = reply.MakeIntReply(int64(rank))
+	})
+
+	return result
+}
+
+// execZIncrBy implements the ZINCRBY command: adds the increment to the score of the member, a
+// missing member is added with the increment as score
+// ZINCRBY key increment member
+func execZIncrBy(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	increment, errReply := parseFloat(string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	member := string(args[2])
+
+	var result resp.Reply
+
+	db.WithKeyLock(key, func() {
+		zsetObj, exists := getAsZSet(db, key)
+		if exists && zsetObj == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+
+		score, _ := zsetObj.Score(member)
+		score += increment
+		if math.IsNaN(score) {
+			// inf + -inf
+			result = reply.MakeStandardErrorReply("ERR resulting score is not a number (NaN)")
+			return
+		}
+		zsetObj.Add(member, score)
+
+		db.PutEntity(key, &database.DataEntity{Data: zsetObj})
+		db.addAof(utils.ToCmdLineWithName("ZINCRBY", args...))
+		result = reply.MakeDoubleReply(score)
+	})
+
+	return result
+}
+
+// execZRemRangeByRank implements the ZREMRANGEBYRANK command
+// ZREMRANGEBYRANK key start stop
+func execZRemRangeByRank(db *DB, args [][]byte) resp.Reply {
+	start, err1 := strconv.Atoi(string(args[1]))
+	stop, err2 := strconv.Atoi(string(args[2]))
+	if err1 != nil || err2 != nil {
+		return reply.MakeStandardErrorReply("ERR value is not an integer or out of range")
+	}
+	return removeRange(db, args, "ZREMRANGEBYRANK", func(zsetObj zset.ZSet) int {
+		return zsetObj.RemoveRangeByRank(start, stop)
+	})
+}
+
+// execZRemRangeByScore implements the ZREMRANGEBYSCORE command
+// ZREMRANGEBYSCORE key min max
+func execZRemRangeByScore(db *DB, args [][]byte) resp.Reply {
+	scores, errReply := parseScoreRange(args[1], args[2])
+	if errReply != nil {
+		return errReply
+	}
+	return removeRange(db, args, "ZREMRANGEBYSCORE", func(zsetObj zset.ZSet) int {
+		if !scores.min.exclude && !scores.max.exclude {
+			return zsetObj.RemoveRangeByScore(scores.min.value, scores.max.value)
+		}
+		var members []string
+		forEachInRange(zsetObj, scores, false, func(member string, _ float64) bool {
+			members = append(members, member)
+			return true
+		})
+		for _, member := range members {
+			zsetObj.Remove(member)
+		}
+		return len(members)
+	})
+}
+
+// removeRange removes members of the sorted set with remove and replies their number, the key
+// is removed with its last member
+func removeRange(db *DB, args [][]byte, name string, remove func(zsetObj zset.ZSet) int) resp.Reply {
+	key := string(args[0])
+
+	var result resp.Reply
+
+	db.WithKeyLock(key, func() {
+		zsetObj, exists := getAsZSet(db, key)
+		if !exists {
+			result = reply.MakeIntReply(0)
+			return
+		}
+		if zsetObj == nil {
+			result = reply.MakeWrongTypeErrReply()
+			return
+		}
+
+		removed := remove(zsetObj)
+		if removed > 0 {
+			if zsetObj.Len() == 0 {
+				db.removeEmptied(key)
+			} else {
+				db.PutEntity(key, &database.DataEntity{Data: zsetObj})
 			}
+			db.addAof(utils.ToCmdLineWithName(name, args...))
 		}
 
-		if rank == -1 {
-			result = reply.MakeNullBulkReply()
+		result = reply.MakeIntReply(int64(removed))
+	})
+
+	return result
+}
+
+// execZPopMin implements the ZPOPMIN command: removes and replies the members with the lowest
+// scores, with their scores
+// ZPOPMIN key [count]
+func execZPopMin(db *DB, args [][]byte) resp.Reply {
+	return execPop(db, args, "ZPOPMIN", false)
+}
+
+// execZPopMax implements the ZPOPMAX command: removes and replies the members with the highest
+// scores, with their scores
+// ZPOPMAX key [count]
+func execZPopMax(db *DB, args [][]byte) resp.Reply {
+	return execPop(db, args, "ZPOPMAX", true)
+}
+
+// execPop pops count members, 1 by default, from the lowest scores or the highest if desc
+func execPop(db *DB, args [][]byte, name string, desc bool) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeSyntaxErrReply()
+	}
+	key := string(args[0])
+	count := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(string(args[1]))
+		if err != nil || n < 0 {
+			return reply.MakeStandardErrorReply("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+
+	var result resp.Reply
+
+	db.WithKeyLock(key, func() {
+		zsetObj, exists := getAsZSet(db, key)
+		if !exists {
+			result = reply.MakeEmptyMultiBulkReply()
+			return
+		}
+		if zsetObj == nil {
+			result = reply.MakeWrongTypeErrReply()
 			return
 		}
 
-		result = reply.MakeIntReply(int64(rank))
+		popped := [][]byte{}
+		var members []string
+		if count > 0 {
+			zsetObj.ForEachByRank(0, count-1, desc, func(member string, score float64) bool {
+				popped = appendMember(popped, member, score, true)
+				members = append(members, member)
+				return true
+			})
+		}
+		for _, member := range members {
+			zsetObj.Remove(member)
+		}
+		if len(members) > 0 {
+			if zsetObj.Len() == 0 {
+				db.removeEmptied(key)
+			} else {
+				db.PutEntity(key, &database.DataEntity{Data: zsetObj})
+			}
+			db.addAof(utils.ToCmdLineWithName(name, args...))
+		}
+
+		result = reply.MakeMultiBulkReply(popped)
 	})
 
 	return result
@@ -349,12 +668,21 @@ func execZType(db *DB, args [][]byte) resp.Reply {
 
 // Register ZSET commands
 func init() {
-	RegisterCommand("ZADD", execZAdd, -4)     // key score member [score member ...]
-	RegisterCommand("ZSCORE", execZScore, 3)  // key member
-	RegisterCommand("ZCARD", execZCard, 2)    // key
-	RegisterCommand("ZRANGE", execZRange, -4) // key start stop [WITHSCORES]
-	RegisterCommand("ZREM", execZRem, -3)     // key member [member ...]
-	RegisterCommand("ZCOUNT", execZCount, 4)  // key min max
-	RegisterCommand("ZRANK", execZRank, 3)    // key member
-	RegisterCommand("ZTYPE", execZType, 2)    // key
+	RegisterCommand("ZADD", execZAdd, -4)                         // key score member [score member ...]
+	RegisterCommand("ZSCORE", execZScore, 3)                      // key member
+	RegisterCommand("ZCARD", execZCard, 2)                        // key
+	RegisterCommand("ZRANGE", execZRange, -4)                     // key start stop [WITHSCORES]
+	RegisterCommand("ZREM", execZRem, -3)                         // key member [member ...]
+	RegisterCommand("ZCOUNT", execZCount, 4)                      // key min max
+	RegisterCommand("ZRANK", execZRank, 3)                        // key member
+	RegisterCommand("ZTYPE", execZType, 2)                        // key
+	RegisterCommand("ZREVRANGE", execZRevRange, -4)               // key start stop [WITHSCORES]
+	RegisterCommand("ZREVRANK", execZRevRank, 3)                  // key member
+	RegisterCommand("ZRANGEBYSCORE", execZRangeByScore, -4)       // key min max [WITHSCORES] [LIMIT offset count]
+	RegisterCommand("ZREVRANGEBYSCORE", execZRevRangeByScore, -4) // key max min [WITHSCORES] [LIMIT offset count]
+	RegisterCommand("ZINCRBY", execZIncrBy, 4)                    // key increment member
+	RegisterCommand("ZREMRANGEBYRANK", execZRemRangeByRank, 4)    // key start stop
+	RegisterCommand("ZREMRANGEBYSCORE", execZRemRangeByScore, 4)  // key min max
+	RegisterCommand("ZPOPMIN", execZPopMin, -2)                   // key [count]
+	RegisterCommand("ZPOPMAX", execZPopMax, -2)                   // key [count]
 }
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"redigo/resp/reply"
+	"strconv"
+	"testing"
+)
+
+// TestZSetScoreRanges tests the exclusive and infinite bounds of the score range commands
+func TestZSetScoreRanges(t *testing.T) {
+	db := MakeDB()
+	exec(db, "ZADD", "zset", "1", "a", "2", "b", "3", "c", "4", "d", "-inf", "min", "+inf", "max")
+	assertReply(t, exec(db, "ZRANGEBYSCORE", "zset", "2", "3"), "*2\r\n$1\r\nb\r\n$1\r\nc\r\n")
+	assertReply(t, exec(db, "ZRANGEBYSCORE", "zset", "(2", "+inf"), "*3\r\n$1\r\nc\r\n$1\r\nd\r\n$3\r\nmax\r\n")
+	assertReply(t, exec(db, "ZRANGEBYSCORE", "zset", "-inf", "(1", "WITHSCORES"), "*2\r\n$3\r\nmin\r\n$4\r\n-inf\r\n")
+	assertReply(t, exec(db, "ZRANGEBYSCORE", "zset", "(1", "(2"), "*0\r\n")
+	assertReply(t, exec(db, "ZRANGEBYSCORE", "zset", "1", "4", "LIMIT", "1", "2"), "*2\r\n$1\r\nb\r\n$1\r\nc\r\n")
+	assertReply(t, exec(db, "ZREVRANGEBYSCORE", "zset", "(4", "1", "withscores", "limit", "0", "2"), "*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nb\r\n$1\r\n2\r\n")
+	assertReply(t, exec(db, "ZCOUNT", "zset", "(1", "+inf"), ":4\r\n")
+	assertReply(t, exec(db, "ZRANGEBYSCORE", "zset", "x", "1"), "-ERR min or max is not a float\r\n")
+	assertReply(t, exec(db, "ZRANGEBYSCORE", "zset", "1", "2", "LIMIT", "1"), "-ERR syntax error\r\n")
+
+	assertReply(t, exec(db, "ZREMRANGEBYSCORE", "zset", "(1", "(4"), ":2\r\n")
+	assertReply(t, exec(db, "ZRANGE", "zset", "0", "-1"), "*4\r\n$3\r\nmin\r\n$1\r\na\r\n$1\r\nd\r\n$3\r\nmax\r\n")
+	assertReply(t, exec(db, "ZREMRANGEBYSCORE", "zset", "-inf", "+inf"), ":4\r\n")
+	assertReply(t, exec(db, "EXISTS", "zset"), ":0\r\n")
+}
+
+// TestZSetRanksAndPops tests the reverse ranks, the removal by rank and ZPOPMIN and ZPOPMAX on
+// both encodings
+func TestZSetRanksAndPops(t *testing.T) {
+	for _, size := range []int{10, 300} {
+		db := MakeDB()
+		for i := 0; i < size; i++ {
+			exec(db, "ZADD", "zset", strconv.Itoa(i), "m"+strconv.Itoa(i))
+		}
+		last := "m" + strconv.Itoa(size-1)
+		assertReply(t, exec(db, "ZREVRANK", "zset", last), ":0\r\n")
+		assertReply(t, exec(db, "ZREVRANK", "zset", "m0"), ":"+strconv.Itoa(size-1)+"\r\n")
+		assertReply(t, exec(db, "ZREVRANGE", "zset", "0", "0", "WITHSCORES"), "*2\r\n$"+strconv.Itoa(len(last))+"\r\n"+last+"\r\n$"+strconv.Itoa(len(last)-1)+"\r\n"+last[1:]+"\r\n")
+
+		assertReply(t, exec(db, "ZPOPMIN", "zset"), "*2\r\n$2\r\nm0\r\n$1\r\n0\r\n")
+		assertReply(t, exec(db, "ZPOPMIN", "zset", "2"), "*4\r\n$2\r\nm1\r\n$1\r\n1\r\n$2\r\nm2\r\n$1\r\n2\r\n")
+		assertReply(t, exec(db, "ZPOPMAX", "zset", "0"), "*0\r\n")
+		assertReply(t, exec(db, "ZPOPMAX", "zset", "1"), "*2\r\n$"+strconv.Itoa(len(last))+"\r\n"+last+"\r\n$"+strconv.Itoa(len(last)-1)+"\r\n"+last[1:]+"\r\n")
+		assertReply(t, exec(db, "ZREMRANGEBYRANK", "zset", "0", "1"), ":2\r\n")
+		assertReply(t, exec(db, "ZRANGE", "zset", "0", "0"), "*1\r\n$2\r\nm5\r\n")
+		assertReply(t, exec(db, "ZCARD", "zset"), ":"+strconv.Itoa(size-6)+"\r\n")
+		exec(db, "ZPOPMIN", "zset", "1000")
+		assertReply(t, exec(db, "EXISTS", "zset"), ":0\r\n")
+	}
+}
+
+// TestZIncrBy tests that ZINCRBY creates the member and refuses a NaN score
+func TestZIncrBy(t *testing.T) {
+	db := MakeDB()
+	assertReply(t, exec(db, "ZINCRBY", "zset", "1", "foo"), ",1\r\n")
+	assertReply(t, exec(db, "ZINCRBY", "zset", "-3.5", "foo"), ",-2.5\r\n")
+	assertReply(t, exec(db, "ZSCORE", "zset", "foo"), ",-2.5\r\n")
+	assertReply(t, exec(db, "ZINCRBY", "zset", "+inf", "foo"), ",inf\r\n")
+	assertReply(t, exec(db, "ZINCRBY", "zset", "-inf", "foo"), "-ERR resulting score is not a number (NaN)\r\n")
+	assertReply(t, exec(db, "ZINCRBY", "zset", "abc", "foo"), "-ERR value is not a valid float\r\n")
+	exec(db, "SET", "str", "value")
+	assertReply(t, exec(db, "ZINCRBY", "str", "1", "foo"), string(reply.MakeWrongTypeErrReply().ToBytes()))
+}
@@ -0,0 +1,239 @@
+// Package bloom implements a scalable Bloom filter
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+const (
+	// DefaultCapacity and DefaultErrorRate are used when an item is added to a key that does not exist
+	DefaultCapacity  = 100
+	DefaultErrorRate = 0.01
+	// Expansion is the capacity ratio between a new sub filter and the previous one
+	Expansion = 2
+	// tighteningRatio is the error rate ratio between a new sub filter and the previous one,
+	// so that the compound error rate stays below the requested one
+	tighteningRatio = 0.5
+)
+
+// filter is a fixed-size Bloom filter
+type filter struct {
+	bits     []uint64
+	size     uint64 // number of bits
+	hashes   uint   // number of hash functions
+	capacity int
+	count    int
+}
+
+func makeFilter(capacity int, errorRate float64) *filter {
+	// optimal bits per item is -ln(p) / ln(2)^2, optimal hash count is bits per item * ln(2)
+	bitsPerItem := -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+	size := uint64(math.Ceil(float64(capacity) * bitsPerItem))
+	if size < 64 {
+		size = 64
+	}
+	hashes := uint(math.Ceil(bitsPerItem * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &filter{
+		bits:     make([]uint64, (size+63)/64),
+		size:     size,
+		hashes:   hashes,
+		capacity: capacity,
+	}
+}
+
+// locations returns the bit positions of the item, using double hashing h1 + i*h2
+func (f *filter) locations(h1, h2 uint64) func(i uint) uint64 {
+	return func(i uint) uint64 {
+		return (h1 + uint64(i)*h2) % f.size
+	}
+}
+
+func (f *filter) test(h1, h2 uint64) bool {
+	loc := f.locations(h1, h2)
+	for i := uint(0); i < f.hashes; i++ {
+		pos := loc(i)
+		if f.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *filter) add(h1, h2 uint64) {
+	loc := f.locations(h1, h2)
+	for i := uint(0); i < f.hashes; i++ {
+		pos := loc(i)
+		f.bits[pos/64] |= 1 << (pos % 64)
+	}
+	f.count++
+}
+
+// Filter is a Bloom filter which grows by stacking sub filters when it is full
+type Filter struct {
+	filters   []*filter
+	errorRate float64
+	count     int
+}
+
+// Make creates a filter for capacity items with the given false positive rate
+func Make(capacity int, errorRate float64) *Filter {
+	return &Filter{
+		filters:   []*filter{makeFilter(capacity, errorRate)},
+		errorRate: errorRate,
+	}
+}
+
+// hashItem returns the two hashes used to compute the bit positions
+func hashItem(item []byte) (uint64, uint64) {
+	a := fnv.New64a()
+	_, _ = a.Write(item)
+	b := fnv.New64()
+	_, _ = b.Write(item)
+	// h2 must be odd to visit different bits when the size is even
+	return a.Sum64(), b.Sum64() | 1
+}
+
+// Add adds the item, returns false if the item may already exist
+func (bf *Filter) Add(item []byte) bool {
+	h1, h2 := hashItem(item)
+	for _, f := range bf.filters {
+		if f.test(h1, h2) {
+			return false
+		}
+	}
+	last := bf.filters[len(bf.filters)-1]
+	if last.count >= last.capacity {
+		rate := bf.errorRate * math.Pow(tighteningRatio, float64(len(bf.filters)))
+		last = makeFilter(last.capacity*Expansion, rate)
+		bf.filters = append(bf.filters, last)
+	}
+	last.add(h1, h2)
+	bf.count++
+	return true
+}
+
+// Exists returns false if the item has definitely not been added
+func (bf *Filter) Exists(item []byte) bool {
+	h1, h2 := hashItem(item)
+	for _, f := range bf.filters {
+		if f.test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of added items
+func (bf *Filter) Count() int {
+	return bf.count
+}
+
+// Capacity returns the total capacity of the sub filters
+func (bf *Filter) Capacity() int {
+	total := 0
+	for _, f := range bf.filters {
+		total += f.capacity
+	}
+	return total
+}
+
+// Filters returns the number of sub filters
+func (bf *Filter) Filters() int {
+	return len(bf.filters)
+}
+
+// Size returns the number of bytes used by the bit arrays
+func (bf *Filter) Size() int {
+	total := 0
+	for _, f := range bf.filters {
+		total += len(f.bits) * 8
+	}
+	return total
+}
+
+// ErrorRate returns the requested false positive rate
+func (bf *Filter) ErrorRate() float64 {
+	return bf.errorRate
+}
+
+// MarshalBinary serializes the filter
+func (bf *Filter) MarshalBinary() ([]byte, error) {
+	buf := binary.LittleEndian.AppendUint64(nil, math.Float64bits(bf.errorRate))
+	buf = binary.AppendUvarint(buf, uint64(bf.count))
+	buf = binary.AppendUvarint(buf, uint64(len(bf.filters)))
+	for _, f := range bf.filters {
+		buf = binary.AppendUvarint(buf, f.size)
+		buf = binary.AppendUvarint(buf, uint64(f.hashes))
+		buf = binary.AppendUvarint(buf, uint64(f.capacity))
+		buf = binary.AppendUvarint(buf, uint64(f.count))
+		for _, word := range f.bits {
+			buf = binary.LittleEndian.AppendUint64(buf, word)
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary restores a filter serialized by MarshalBinary
+func (bf *Filter) UnmarshalBinary(data []byte) error {
+	r := &reader{data: data}
+	bf.errorRate = math.Float64frombits(r.uint64())
+	bf.count = int(r.uvarint())
+	n := r.uvarint()
+	bf.filters = nil
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		f := &filter{
+			size:     r.uvarint(),
+			hashes:   uint(r.uvarint()),
+			capacity: int(r.uvarint()),
+			count:    int(r.uvarint()),
+		}
+		words := (f.size + 63) / 64
+		if f.size == 0 || f.hashes == 0 || words*8 > uint64(len(r.data)) {
+			return ErrCorrupted
+		}
+		f.bits = make([]uint64, words)
+		for j := range f.bits {
+			f.bits[j] = r.uint64()
+		}
+		bf.filters = append(bf.filters, f)
+	}
+	if r.err != nil || len(bf.filters) == 0 || len(r.data) != 0 {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// ErrCorrupted is returned when unmarshalling invalid data
+var ErrCorrupted = errors.New("bloom: corrupted data")
+
+// reader decodes the serialized form, the first error is kept in err
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = ErrCorrupted
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *reader) uint64() uint64 {
+	if len(r.data) < 8 {
+		r.err = ErrCorrupted
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v
+}
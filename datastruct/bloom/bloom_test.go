@@ -0,0 +1,54 @@
+package bloom
+
+import (
+	"strconv"
+	"testing"
+)
+
+// TestFalsePositives tests that the filter grows past its capacity with about its error rate, and
+// that it has no false negative
+func TestFalsePositives(t *testing.T) {
+	bf := Make(1000, 0.01)
+	for i := 0; i < 5000; i++ {
+		bf.Add([]byte("item" + strconv.Itoa(i)))
+	}
+	for i := 0; i < 5000; i++ {
+		if !bf.Exists([]byte("item" + strconv.Itoa(i))) {
+			t.Fatalf("Expected item%d to exist", i)
+		}
+	}
+	if bf.Filters() < 2 || bf.Count() > 5000 {
+		t.Errorf("Expected the filter to grow, got %d filters and %d items", bf.Filters(), bf.Count())
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if bf.Exists([]byte("other" + strconv.Itoa(i))) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 10000; rate > 0.03 {
+		t.Errorf("Expected a false positive rate close to 0.01, got %v", rate)
+	}
+}
+
+// TestMarshal tests that a filter is read back as written
+func TestMarshal(t *testing.T) {
+	bf := Make(10, 0.001)
+	for i := 0; i < 30; i++ {
+		bf.Add([]byte(strconv.Itoa(i)))
+	}
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := &Filter{}
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Count() != bf.Count() || loaded.Filters() != bf.Filters() || !loaded.Exists([]byte("29")) {
+		t.Errorf("Expected the filter of %d items, got %d items", bf.Count(), loaded.Count())
+	}
+	if err := loaded.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("Expected an error for a truncated filter")
+	}
+}
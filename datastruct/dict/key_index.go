@@ -0,0 +1,184 @@
+package dict
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// keyIndexShards is the number of shards of a keyIndex, so that concurrent writers of different
+// keys rarely wait for each other; a shard holds the keys whose position starts with its index
+const (
+	keyIndexShards = 16
+	shardBits      = 4
+)
+
+// bucketLoad is the average number of keys of a bucket over which the buckets of a shard double,
+// they halve under a load of 1/2
+const bucketLoad = 4
+
+// Position is the place of a key in the order of ScanBuckets, its 64 bits FNV-1a hash
+func Position(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// keyIndex holds the keys of a SyncDict in slices, to draw random keys in O(1), and in buckets
+// ordered by position, to scan them from a cursor
+// sync.Map iterates in a stable order, the first keys of Range are not random
+type keyIndex struct {
+	shards [keyIndexShards]keyShard
+}
+
+type keyShard struct {
+	mu   sync.Mutex
+	keys []string
+	pos  map[string]int // key -> index in keys
+	// buckets hold the keys by the bits of their position following those of the shard, so the
+	// order of the buckets is that of the positions; there are 1 << bits buckets
+	buckets [][]string
+	bits    uint
+}
+
+func (idx *keyIndex) shard(position uint64) *keyShard {
+	return &idx.shards[position>>(64-shardBits)]
+}
+
+// bucket returns the index of the bucket of a position
+func (s *keyShard) bucket(position uint64) uint64 {
+	return (position << shardBits) >> (64 - s.bits)
+}
+
+// add adds the key, it does nothing if the key is present
+func (idx *keyIndex) add(key string) {
+	position := Position(key)
+	s := idx.shard(position)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pos == nil {
+		s.pos = make(map[string]int)
+		s.buckets = make([][]string, 1)
+	}
+	if _, ok := s.pos[key]; ok {
+		return
+	}
+	s.pos[key] = len(s.keys)
+	s.keys = append(s.keys, key)
+	if len(s.keys) > bucketLoad<<s.bits {
+		s.resize(s.bits + 1)
+	} else {
+		b := s.bucket(position)
+		s.buckets[b] = append(s.buckets[b], key)
+	}
+}
+
+// remove removes the key, it does nothing if the key is absent
+func (idx *keyIndex) remove(key string) {
+	position := Position(key)
+	s := idx.shard(position)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.pos[key]
+	if !ok {
+		return
+	}
+	last := len(s.keys) - 1
+	s.keys[i] = s.keys[last]
+	s.pos[s.keys[i]] = i
+	s.keys[last] = ""
+	s.keys = s.keys[:last]
+	delete(s.pos, key)
+
+	bucket := s.buckets[s.bucket(position)]
+	for j, k := range bucket {
+		if k == key {
+			bucket[j] = bucket[len(bucket)-1]
+			bucket[len(bucket)-1] = ""
+			s.buckets[s.bucket(position)] = bucket[:len(bucket)-1]
+			break
+		}
+	}
+	if s.bits > 0 && len(s.keys) < 1<<s.bits/2 {
+		s.resize(s.bits - 1)
+	}
+}
+
+// resize distributes the keys of the shard in 1 << bits buckets
+func (s *keyShard) resize(bits uint) {
+	s.bits = bits
+	s.buckets = make([][]string, 1<<bits)
+	for _, key := range s.keys {
+		b := s.bucket(Position(key))
+		s.buckets[b] = append(s.buckets[b], key)
+	}
+}
+
+func (idx *keyIndex) len() int {
+	n := 0
+	for i := range idx.shards {
+		s := &idx.shards[i]
+		s.mu.Lock()
+		n += len(s.keys)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// random returns a random key, false if there is no key
+// A shard is drawn with a probability proportional to its number of keys
+func (idx *keyIndex) random() (string, bool) {
+	total := idx.len()
+	if total == 0 {
+		return "", false
+	}
+	n := rand.Intn(total)
+	for i := range idx.shards {
+		s := &idx.shards[i]
+		s.mu.Lock()
+		if n < len(s.keys) {
+			key := s.keys[n]
+			s.mu.Unlock()
+			return key, true
+		}
+		n -= len(s.keys)
+		s.mu.Unlock()
+	}
+	// the keys were removed in the meantime
+	return "", false
+}
+
+// scan calls fn with a copy of the keys of each bucket from the one of cursor on, and the position
+// following the bucket, 0 after the last one; it stops when fn returns false
+// The buckets may be resized between two calls of fn, the next bucket is found from the position
+func (idx *keyIndex) scan(cursor uint64, fn func(keys []string, end uint64) bool) {
+	for {
+		s := idx.shard(cursor)
+		s.mu.Lock()
+		var keys []string
+		// a shard without buckets is a single empty bucket
+		if s.buckets != nil {
+			keys = append(keys, s.buckets[s.bucket(cursor)]...)
+		}
+		width := uint64(1) << (64 - shardBits - s.bits)
+		// the end of the last bucket wraps around to 0
+		end := cursor - cursor%width + width
+		s.mu.Unlock()
+		if !fn(keys, end) || end == 0 {
+			return
+		}
+		cursor = end
+	}
+}
+
+func (idx *keyIndex) clear() {
+	for i := range idx.shards {
+		s := &idx.shards[i]
+		s.mu.Lock()
+		s.keys, s.pos = nil, nil
+		s.buckets, s.bits = nil, 0
+		s.mu.Unlock()
+	}
+}
@@ -1,11 +1,14 @@
 package dict
 
 import (
+	"redigo/lib/failpoint"
 	"sync"
 )
 
 type SyncDict struct {
 	m sync.Map
+	// keys indexes the keys of m for Len, RandomKeys and ScanBuckets
+	keys keyIndex
 }
 
 // MakeSyncDict creates a new SyncDict instance
@@ -24,35 +27,31 @@ func (dict *SyncDict) Get(key string) (val interface{}, exists bool) {
 
 // Len returns the number of key-value pairs in the dictionary
 func (dict *SyncDict) Len() int {
-	count := 0
-	// Iterate over all key-value pairs and count them
-	dict.m.Range(func(key, value interface{}) bool {
-		count++
-		return true
-	})
-	return count
+	return dict.keys.len()
 }
 
 // Put adds a key-value pair to the dictionary, if the key already exists, return 1 else 0
 func (dict *SyncDict) Put(key string, val interface{}) (result int) {
-	_, exists := dict.m.Load(key)
+	if err := failpoint.Inject(failpoint.DictPut); err != nil {
+		panic(err)
+	}
 	// Store the key-value pair
-	dict.m.Store(key, val)
+	_, exists := dict.m.Swap(key, val)
 	// Return the count of pairs
 	if exists {
 		return 0
 	}
+	dict.keys.add(key)
 	return 1
 }
 
 // PutIfAbsent adds a key-value pair to the dictionary if the key does not exist, return 1 if it exists, else 0
 func (dict *SyncDict) PutIfAbsent(key string, val interface{}) (result int) {
-	_, exists := dict.m.Load(key)
-	if exists {
+	// Store the key-value pair
+	if _, exists := dict.m.LoadOrStore(key, val); exists {
 		return 0
 	}
-	// Store the key-value pair
-	dict.m.Store(key, val)
+	dict.keys.add(key)
 	return 1
 }
 
@@ -62,35 +61,34 @@ func (dict *SyncDict) PutIfExists(key string, val interface{}) (result int) {
 	if !exists {
 		return 0
 	}
-	// Store the key-value pair
+	// Store the key-value pair, the key may have been removed in the meantime
 	dict.m.Store(key, val)
+	dict.keys.add(key)
 	return 1
 }
 
 // Remove removes a key-value pair from the dictionary, return the count of pairs were removed
 func (dict *SyncDict) Remove(key string) (result int) {
-	_, exists := dict.m.Load(key)
-	if !exists {
+	// Delete the key-value pair
+	if _, exists := dict.m.LoadAndDelete(key); !exists {
 		return 0
 	}
-	// Delete the key-value pair
-	dict.m.Delete(key)
+	dict.keys.remove(key)
 	return 1
 }
 
 // ForEach iterates over all key-value pairs in the dictionary and applies the consumer function to each pair
+// The iteration stops when the consumer returns false
 func (dict *SyncDict) ForEach(consumer Consumer) {
 	// Iterate over all key-value pairs and apply the consumer function
 	dict.m.Range(func(key, value interface{}) bool {
-		consumer(key.(string), value)
-		// Always return true to continue iteration
-		return true
+		return consumer(key.(string), value)
 	})
 }
 
 // Keys returns a slice of all keys in the dictionary
 func (dict *SyncDict) Keys() []string {
-	keys := make([]string, dict.Len())
+	keys := make([]string, 0, dict.Len())
 	// Iterate over all key-value pairs and collect the keys
 	dict.m.Range(func(key, value interface{}) bool {
 		keys = append(keys, key.(string))
@@ -100,37 +98,43 @@ func (dict *SyncDict) Keys() []string {
 }
 
 // RandomKeys returns a slice of n random keys from the dictionary
-// Due to m.Range doesn't guarantee the order of iteration, we can use this feature to get random keys
-// Note: This method may not be truly random, but it will give different keys each time
 // Duplicate keys may be returned
 func (dict *SyncDict) RandomKeys(n int) []string {
-	keys := make([]string, dict.Len())
+	keys := make([]string, 0, n)
 	for i := 0; i < n; i++ {
-		// Randomly select a key from the dictionary
-		dict.m.Range(func(key, value interface{}) bool {
-			keys = append(keys, key.(string))
-			return false
-		})
+		key, ok := dict.keys.random()
+		if !ok {
+			break
+		}
+		keys = append(keys, key)
 	}
 	return keys
 }
 
 // RandomDistinctKeys returns a slice of n distinct random keys from the dictionary
 func (dict *SyncDict) RandomDistinctKeys(n int) []string {
-	result := make([]string, dict.Len())
-
-	i := 0
+	result := make([]string, 0, n)
+	if n <= 0 {
+		return result
+	}
 
 	// Iterate over all key-value pairs and collect the keys
 	dict.m.Range(func(key, value interface{}) bool {
-		result[i] = key.(string)
-		i++
-		return i != n
+		result = append(result, key.(string))
+		return len(result) < n
 	})
 	return result
 }
 
+// ScanBuckets calls fn with the keys of the buckets holding the positions from cursor on, in the
+// order of the positions, and the position following each bucket, 0 after the last one; it stops
+// when fn returns false. A bucket may hold keys before the cursor, and keys removed since
+func (dict *SyncDict) ScanBuckets(cursor uint64, fn func(keys []string, end uint64) bool) {
+	dict.keys.scan(cursor, fn)
+}
+
 // Clear clears all key-value pairs in the dictionary
 func (dict *SyncDict) Clear() {
-	*dict = *MakeSyncDict()
+	dict.m.Clear()
+	dict.keys.clear()
 }
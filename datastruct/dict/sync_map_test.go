@@ -0,0 +1,108 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+)
+
+// TestSyncDictLen tests that the number of keys follows the puts and the removes
+func TestSyncDictLen(t *testing.T) {
+	d := MakeSyncDict()
+	for i := 0; i < 100; i++ {
+		d.Put("key"+strconv.Itoa(i), i)
+	}
+	d.Put("key0", -1)
+	d.PutIfAbsent("key1", -1)
+	d.PutIfAbsent("new", 1)
+	d.PutIfExists("missing", 1)
+	if d.Len() != 101 {
+		t.Fatalf("Expected 101 keys, got %d", d.Len())
+	}
+	for i := 0; i < 100; i += 2 {
+		if d.Remove("key"+strconv.Itoa(i)) != 1 {
+			t.Fatalf("Failed to remove key%d", i)
+		}
+	}
+	if d.Remove("key0") != 0 {
+		t.Error("Removing an absent key should return 0")
+	}
+	if d.Len() != 51 {
+		t.Errorf("Expected 51 keys, got %d", d.Len())
+	}
+	d.Clear()
+	if d.Len() != 0 || len(d.RandomKeys(3)) != 0 {
+		t.Error("Expected no key after Clear")
+	}
+}
+
+// TestSyncDictRandomKeys tests that the random keys are present and spread over the keys
+func TestSyncDictRandomKeys(t *testing.T) {
+	d := MakeSyncDict()
+	for i := 0; i < 100; i++ {
+		d.Put("key"+strconv.Itoa(i), i)
+	}
+	for i := 0; i < 100; i += 2 {
+		d.Remove("key" + strconv.Itoa(i))
+	}
+	seen := make(map[string]bool)
+	for _, key := range d.RandomKeys(1000) {
+		if _, ok := d.Get(key); !ok {
+			t.Fatalf("Random key %s is not in the dictionary", key)
+		}
+		seen[key] = true
+	}
+	// 1000 draws among 50 keys miss a given key with a probability of 1e-9
+	if len(seen) != 50 {
+		t.Errorf("Expected the random keys to cover the 50 keys, got %d", len(seen))
+	}
+}
+
+// TestSyncDictScanBuckets tests that the buckets hold every key once, in the order of the
+// positions, while they grow and shrink, and that a scan resumes from the bucket of its cursor
+func TestSyncDictScanBuckets(t *testing.T) {
+	d := MakeSyncDict()
+	for _, n := range []int{10000, 30} {
+		for i := 0; i < 10000; i++ {
+			if i < n {
+				d.Put("key"+strconv.Itoa(i), i)
+			} else {
+				d.Remove("key" + strconv.Itoa(i))
+			}
+		}
+		seen := make(map[string]bool)
+		var start, buckets uint64
+		d.ScanBuckets(0, func(keys []string, end uint64) bool {
+			for _, key := range keys {
+				if pos := Position(key); pos < start || end != 0 && pos >= end {
+					t.Fatalf("Key %s at %d is out of its bucket [%d, %d)", key, pos, start, end)
+				}
+				if seen[key] {
+					t.Fatalf("Key %s is in two buckets", key)
+				}
+				seen[key] = true
+			}
+			start = end
+			buckets++
+			return true
+		})
+		if len(seen) != n {
+			t.Errorf("Expected the buckets to hold the %d keys, got %d", n, len(seen))
+		}
+		// the buckets of a shard halve under a load of 1/2
+		if buckets > uint64(2*n+keyIndexShards) {
+			t.Errorf("Expected at most two buckets per key, got %d buckets for %d keys", buckets, n)
+		}
+	}
+
+	cursor := Position("key7")
+	d.ScanBuckets(cursor, func(keys []string, end uint64) bool {
+		found := false
+		for _, key := range keys {
+			found = found || key == "key7"
+		}
+		if !found {
+			t.Errorf("Expected the first bucket to hold the key of the cursor, got %v", keys)
+		}
+		return false
+	})
+}
@@ -0,0 +1,313 @@
+// Package document implements a parsed JSON document addressed by paths
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPath = errors.New("ERR invalid path")
+	ErrNoSuchPath  = errors.New("ERR path does not exist")
+	ErrNotNumber   = errors.New("ERR value at path is not a number")
+	ErrOverflow    = errors.New("ERR result is not a finite number")
+	ErrInvalidJSON = errors.New("ERR invalid JSON value")
+	// ErrNewAtRoot is returned when trying to create a document with a path other than the root
+	ErrNewAtRoot = errors.New("ERR new objects must be created at the root")
+)
+
+// segment is a step of a path, either an object field or an array index
+type segment struct {
+	field   string
+	index   int
+	isIndex bool
+}
+
+// Path addresses a value inside a document, an empty path is the root
+type Path []segment
+
+// ParsePath parses a path like $.a.b[0], .a["b c"][-1] or a.b
+// Both the JSONPath root "$" and the legacy root "." are accepted
+// Wildcards, recursive descent and filters are not supported
+func ParsePath(s string) (Path, error) {
+	if strings.HasPrefix(s, "$") {
+		s = s[1:]
+	}
+	var path Path
+	i := 0
+	if i < len(s) && s[i] != '.' && s[i] != '[' {
+		// legacy paths may omit the leading dot
+		s = "." + s
+	}
+	for i < len(s) {
+		switch s[i] {
+		case '.':
+			i++
+			start := i
+			for i < len(s) && s[i] != '.' && s[i] != '[' {
+				i++
+			}
+			if start == i {
+				if i == len(s) && len(path) == 0 {
+					// "." is the root
+					return path, nil
+				}
+				return nil, ErrInvalidPath
+			}
+			field := s[start:i]
+			if field == "*" || field == ".." {
+				return nil, ErrInvalidPath
+			}
+			path = append(path, segment{field: field})
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, ErrInvalidPath
+			}
+			inner := s[i+1 : i+end]
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				field := inner[1 : len(inner)-1]
+				if inner[0] == '"' {
+					unquoted, err := strconv.Unquote(inner)
+					if err != nil {
+						return nil, ErrInvalidPath
+					}
+					field = unquoted
+				}
+				path = append(path, segment{field: field})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, ErrInvalidPath
+				}
+				path = append(path, segment{index: index, isIndex: true})
+			}
+			i += end + 1
+		default:
+			return nil, ErrInvalidPath
+		}
+	}
+	return path, nil
+}
+
+// Parse decodes a JSON value, numbers are kept as json.Number so that integers stay exact
+func Parse(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, ErrInvalidJSON
+	}
+	// reject trailing data like `1 2`
+	if decoder.More() {
+		return nil, ErrInvalidJSON
+	}
+	if _, err := decoder.Token(); err == nil {
+		return nil, ErrInvalidJSON
+	}
+	return value, nil
+}
+
+// Marshal encodes a value, object fields are sorted by name
+func Marshal(value any) []byte {
+	data, _ := json.Marshal(value)
+	return data
+}
+
+// Document is a JSON value stored in the database
+type Document struct {
+	root any
+}
+
+// MakeDocument creates a document from a parsed value
+func MakeDocument(root any) *Document {
+	return &Document{root: root}
+}
+
+// resolveIndex converts a negative index counted from the end of the array
+func resolveIndex(index int, length int) (int, bool) {
+	if index < 0 {
+		index += length
+	}
+	return index, index >= 0 && index < length
+}
+
+// child returns the value addressed by seg inside container
+func child(container any, seg segment) (any, bool) {
+	if seg.isIndex {
+		array, ok := container.([]any)
+		if !ok {
+			return nil, false
+		}
+		index, ok := resolveIndex(seg.index, len(array))
+		if !ok {
+			return nil, false
+		}
+		return array[index], true
+	}
+	object, ok := container.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	value, ok := object[seg.field]
+	return value, ok
+}
+
+// Get returns the value at path
+func (d *Document) Get(path Path) (any, bool) {
+	value := d.root
+	for _, seg := range path {
+		var ok bool
+		value, ok = child(value, seg)
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Set sets the value at path, the parent must exist
+// A missing object field is created, array elements must exist
+// nx only sets missing values and xx only replaces existing values, returns false if nothing was set
+func (d *Document) Set(path Path, value any, nx bool, xx bool) (bool, error) {
+	if len(path) == 0 {
+		if nx {
+			return false, nil
+		}
+		d.root = value
+		return true, nil
+	}
+	parent, ok := d.Get(path[:len(path)-1])
+	if !ok {
+		return false, ErrNoSuchPath
+	}
+	last := path[len(path)-1]
+	_, exists := child(parent, last)
+	if (nx && exists) || (xx && !exists) {
+		return false, nil
+	}
+	if last.isIndex {
+		array, ok := parent.([]any)
+		if !ok {
+			return false, ErrNoSuchPath
+		}
+		index, ok := resolveIndex(last.index, len(array))
+		if !ok {
+			return false, errors.New("ERR array index out of range")
+		}
+		array[index] = value
+		return true, nil
+	}
+	object, ok := parent.(map[string]any)
+	if !ok {
+		return false, ErrNoSuchPath
+	}
+	object[last.field] = value
+	return true, nil
+}
+
+// Delete removes the value at path, returns false if it does not exist
+// The root cannot be deleted this way, the caller removes the whole key instead
+func (d *Document) Delete(path Path) bool {
+	if len(path) == 0 {
+		return false
+	}
+	parentPath := path[:len(path)-1]
+	parent, ok := d.Get(parentPath)
+	if !ok {
+		return false
+	}
+	last := path[len(path)-1]
+	if last.isIndex {
+		array, ok := parent.([]any)
+		if !ok {
+			return false
+		}
+		index, ok := resolveIndex(last.index, len(array))
+		if !ok {
+			return false
+		}
+		array = append(array[:index], array[index+1:]...)
+		// the slice header changed, store it back in the parent
+		_, _ = d.Set(parentPath, array, false, false)
+		return true
+	}
+	object, ok := parent.(map[string]any)
+	if !ok {
+		return false
+	}
+	if _, ok := object[last.field]; !ok {
+		return false
+	}
+	delete(object, last.field)
+	return true
+}
+
+// isInteger reports whether the number has no fraction or exponent
+func isInteger(n json.Number) bool {
+	return !strings.ContainsAny(string(n), ".eE")
+}
+
+// IncrBy adds increment to the number at path and returns the new value
+// The result is an integer if both operands are integers and the sum does not overflow
+func (d *Document) IncrBy(path Path, increment json.Number) (json.Number, error) {
+	value, ok := d.Get(path)
+	if !ok {
+		return "", ErrNoSuchPath
+	}
+	current, ok := value.(json.Number)
+	if !ok {
+		return "", ErrNotNumber
+	}
+	var result json.Number
+	a, errA := current.Int64()
+	b, errB := increment.Int64()
+	sum := a + b
+	if isInteger(current) && isInteger(increment) && errA == nil && errB == nil && (a >= 0) == (sum >= b) {
+		result = json.Number(strconv.FormatInt(sum, 10))
+	} else {
+		x, err := current.Float64()
+		if err != nil {
+			return "", ErrNotNumber
+		}
+		y, err := increment.Float64()
+		if err != nil {
+			return "", ErrNotNumber
+		}
+		f := x + y
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return "", ErrOverflow
+		}
+		result = json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	if _, err := d.Set(path, result, false, false); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// TypeOf returns the JSON type name of a value
+func TypeOf(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if isInteger(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
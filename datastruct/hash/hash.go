@@ -1,5 +1,11 @@
 package hash
 
+import (
+	"errors"
+	"fmt"
+	"redigo/datastruct/listpack"
+)
+
 const (
 	// If the number of entries in the hash exceeds this value, it will be converted to a hash table
 	hashMaxListpackEntries = 512
@@ -14,8 +20,8 @@ const (
 )
 
 type Hash struct {
-	encoding int         // The encoding type of the hash
-	listpack [][2]string // Using Go slice to simulate the listpack
+	encoding int                // The encoding type of the hash
+	listpack *listpack.Listpack // Fields and values stored alternately
 	dict     map[string]string
 }
 
@@ -23,7 +29,7 @@ type Hash struct {
 func MakeHash() *Hash {
 	return &Hash{
 		encoding: encodingListpack, // Use listpack encoding by default
-		listpack: make([][2]string, 0),
+		listpack: listpack.New(),
 		dict:     make(map[string]string),
 	}
 }
@@ -32,12 +38,12 @@ func MakeHash() *Hash {
 func (h *Hash) Get(field string) (val string, exists bool) {
 	// If using listpack encoding, search in the listpack
 	if h.encoding == encodingListpack {
-		for _, entry := range h.listpack {
-			if entry[0] == field {
-				return entry[1], true
-			}
+		index := h.listpack.Find([]byte(field), 2)
+		if index < 0 {
+			return "", false
 		}
-		return "", false
+		value, _ := h.listpack.Get(index + 1)
+		return string(value), true
 	}
 
 	val, exists = h.dict[field]
@@ -49,22 +55,20 @@ func (h *Hash) Get(field string) (val string, exists bool) {
 func (h *Hash) Set(field, value string) int {
 	if h.encoding == encodingListpack {
 		// If the size of the listpack exceeds the maximum entries or the length of the field or value exceeds the maximum value, convert to hash table
-		if len(h.listpack) >= hashMaxListpackEntries || len(field) > hashMaxListpackValue || len(value) > hashMaxListpackValue {
+		if h.Len() >= hashMaxListpackEntries || len(field) > hashMaxListpackValue || len(value) > hashMaxListpackValue {
 			h.convertToHashTable()
 		}
 	}
 
 	if h.encoding == encodingListpack {
 		// Check if the field already exists in the listpack
-		for i, entry := range h.listpack {
-			if entry[0] == field {
-				h.listpack[i][1] = value
-				return 0 // Updated existing entry
-			}
+		if index := h.listpack.Find([]byte(field), 2); index >= 0 {
+			h.listpack.Replace(index+1, []byte(value))
+			return 0 // Updated existing entry
 		}
 
 		// Add new entry
-		h.listpack = append(h.listpack, [2]string{field, value})
+		h.listpack.Append([]byte(field), []byte(value))
 		return 1
 	}
 
@@ -81,16 +85,10 @@ func (h *Hash) Delete(field string) int {
 	count := 0
 
 	if h.encoding == encodingListpack {
-		for i, entry := range h.listpack {
-			if entry[0] == field {
-				// Delete the entry and move the last entry to the current position to reduce the size
-				// Because hash doesn't need to maintain order, we can just swap the last entry with the current one
-				lastIndex := len(h.listpack) - 1
-				h.listpack[i] = h.listpack[lastIndex]
-				h.listpack = h.listpack[:lastIndex]
-				count++
-				break
-			}
+		// Delete the field and its value
+		if index := h.listpack.Find([]byte(field), 2); index >= 0 {
+			h.listpack.Delete(index, 2)
+			count++
 		}
 	} else {
 		// Delete the field from the hash table
@@ -106,7 +104,7 @@ func (h *Hash) Delete(field string) int {
 // Len returns the number of entries in the hash
 func (h *Hash) Len() int {
 	if h.encoding == encodingListpack {
-		return len(h.listpack)
+		return h.listpack.Len() / 2
 	}
 	return len(h.dict)
 }
@@ -116,9 +114,9 @@ func (h *Hash) GetAll() map[string]string {
 	result := make(map[string]string)
 
 	if h.encoding == encodingListpack {
-		for _, entry := range h.listpack {
-			result[entry[0]] = entry[1]
-		}
+		h.forEachPair(func(field, value string) {
+			result[field] = value
+		})
 	} else {
 		for field, value := range h.dict {
 			result[field] = value
@@ -127,13 +125,33 @@ func (h *Hash) GetAll() map[string]string {
 	return result
 }
 
+// ForEach calls consumer for every field value pair until it returns false
+func (h *Hash) ForEach(consumer func(field, value string) bool) {
+	if h.encoding == encodingListpack {
+		var field string
+		h.listpack.ForEach(func(i int, val []byte) bool {
+			if i%2 == 0 {
+				field = string(val)
+				return true
+			}
+			return consumer(field, string(val))
+		})
+		return
+	}
+	for field, value := range h.dict {
+		if !consumer(field, value) {
+			return
+		}
+	}
+}
+
 // Fields returns all the fields in the hash
 func (h *Hash) Fields() []string {
 	if h.encoding == encodingListpack {
-		fields := make([]string, len(h.listpack))
-		for i, entry := range h.listpack {
-			fields[i] = entry[0]
-		}
+		fields := make([]string, 0, h.Len())
+		h.forEachPair(func(field, _ string) {
+			fields = append(fields, field)
+		})
 		return fields
 	}
 
@@ -147,10 +165,10 @@ func (h *Hash) Fields() []string {
 // Values returns all the values in the hash
 func (h *Hash) Values() []string {
 	if h.encoding == encodingListpack {
-		values := make([]string, len(h.listpack))
-		for i, entry := range h.listpack {
-			values[i] = entry[1]
-		}
+		values := make([]string, 0, h.Len())
+		h.forEachPair(func(_, value string) {
+			values = append(values, value)
+		})
 		return values
 	}
 
@@ -173,25 +191,85 @@ func (h *Hash) convertToHashTable() {
 		return
 	}
 
-	h.dict = make(map[string]string, len(h.listpack))
+	h.dict = make(map[string]string, h.Len())
 
-	for _, entry := range h.listpack {
-		h.dict[entry[0]] = entry[1]
-	}
+	h.forEachPair(func(field, value string) {
+		h.dict[field] = value
+	})
 
 	h.encoding = encodingHashTable
 
 	h.listpack = nil // Clear the listpack to free up memory
 }
 
+// forEachPair iterates over the field value pairs of the listpack
+func (h *Hash) forEachPair(consumer func(field, value string)) {
+	var field string
+	h.listpack.ForEach(func(i int, val []byte) bool {
+		if i%2 == 0 {
+			field = string(val)
+		} else {
+			consumer(field, string(val))
+		}
+		return true
+	})
+}
+
 // Encoding returns the encoding type of the hash
 func (h *Hash) Encoding() int {
 	return h.encoding
 }
 
+// ObjectEncoding returns the name of the encoding of the hash, as OBJECT ENCODING replies it
+func (h *Hash) ObjectEncoding() string {
+	if h.encoding == encodingListpack {
+		return "listpack"
+	}
+	return "hashtable"
+}
+
 // Clear clears all entries in the hash
 func (h *Hash) Clear() {
-	h.listpack = nil
+	h.listpack = listpack.New()
 	h.dict = nil
 	h.encoding = encodingListpack
 }
+
+// Verify checks the invariants of the hash: in listpack encoding well-formed pairs of distinct
+// fields within the limits of the encoding, which would have converted the hash otherwise, in
+// hashtable encoding a dict holding the fields
+func (h *Hash) Verify() error {
+	switch h.encoding {
+	case encodingHashTable:
+		if h.dict == nil {
+			return errors.New("hash: hashtable encoding without a dict")
+		}
+		return nil
+	case encodingListpack:
+	default:
+		return fmt.Errorf("hash: invalid encoding %d", h.encoding)
+	}
+	if err := h.listpack.Verify(); err != nil {
+		return err
+	}
+	if h.listpack.Len()%2 != 0 {
+		return fmt.Errorf("hash: odd number of listpack entries %d", h.listpack.Len())
+	}
+	if h.listpack.Len()/2 > hashMaxListpackEntries {
+		return fmt.Errorf("hash: %d fields in listpack encoding, the limit is %d", h.listpack.Len()/2, hashMaxListpackEntries)
+	}
+	fields := make(map[string]struct{}, h.listpack.Len()/2)
+	var err error
+	h.listpack.ForEach(func(i int, val []byte) bool {
+		if len(val) > hashMaxListpackValue {
+			err = fmt.Errorf("hash: entry %d of %d bytes in listpack encoding, the limit is %d", i, len(val), hashMaxListpackValue)
+		} else if i%2 == 0 {
+			if _, ok := fields[string(val)]; ok {
+				err = fmt.Errorf("hash: duplicate field %q", val)
+			}
+			fields[string(val)] = struct{}{}
+		}
+		return err == nil
+	})
+	return err
+}
@@ -17,8 +17,8 @@ func TestMakeHash(t *testing.T) {
 		t.Errorf("New hash should use listpack encoding by default, got %d", h.encoding)
 	}
 
-	if len(h.listpack) != 0 {
-		t.Errorf("New hash should have empty listpack, got %d items", len(h.listpack))
+	if h.listpack.Len() != 0 {
+		t.Errorf("New hash should have empty listpack, got %d items", h.listpack.Len())
 	}
 }
 
@@ -202,3 +202,40 @@ func TestOtherOperations(t *testing.T) {
 		t.Error("Values returned incorrect data")
 	}
 }
+
+// TestVerify tests that Verify accepts the hashes of both encodings and reports a listpack over
+// the limits of the encoding
+func TestVerify(t *testing.T) {
+	h := MakeHash()
+	for i := 0; i < hashMaxListpackEntries; i++ {
+		h.Set("key"+strconv.Itoa(i), "value")
+	}
+	if h.Encoding() != encodingListpack {
+		t.Fatalf("Expected listpack encoding at the limit, got %d", h.Encoding())
+	}
+	if err := h.Verify(); err != nil {
+		t.Errorf("Verify of a full listpack failed: %v", err)
+	}
+	h.Set("more", "value")
+	if err := h.Verify(); err != nil {
+		t.Errorf("Verify of a hashtable failed: %v", err)
+	}
+
+	large := MakeHash()
+	large.Set("key", "value")
+	large.listpack.Append([]byte("large"), make([]byte, hashMaxListpackValue+1))
+	if err := large.Verify(); err == nil {
+		t.Error("Verify should report a value over the limit of the listpack encoding")
+	}
+	many := MakeHash()
+	for i := 0; i <= hashMaxListpackEntries; i++ {
+		many.listpack.Append([]byte("key"+strconv.Itoa(i)), []byte("value"))
+	}
+	if err := many.Verify(); err == nil {
+		t.Error("Verify should report too many fields in listpack encoding")
+	}
+	many.dict, many.encoding = nil, encodingHashTable
+	if err := many.Verify(); err == nil {
+		t.Error("Verify should report a hashtable without a dict")
+	}
+}
@@ -0,0 +1,424 @@
+// Package list implements the lists of the database as a quicklist: a doubly linked list of
+// listpack nodes, so that the elements pay the overhead of a node only once per node
+package list
+
+import (
+	"errors"
+	"fmt"
+	"redigo/datastruct/listpack"
+)
+
+const (
+	// If the number of entries of a node exceeds this value, the node is split
+	listMaxNodeEntries = 128
+	// If the bytes of a node exceed this value, the node is split, an element larger than it
+	// gets a node of its own
+	listMaxNodeBytes = 8 * 1024
+)
+
+// The encoding types for the list
+const (
+	encodingListpack = iota // at most one node
+	encodingQuicklist
+)
+
+// node is a listpack linked to its neighbours
+type node struct {
+	prev    *node
+	next    *node
+	entries *listpack.Listpack
+}
+
+// List is a list of byte strings
+// It keeps the listpack encoding while its elements fit in a single node, it is converted to a
+// quicklist when a second node is needed and back once it shrinks to half a node
+type List struct {
+	encoding int
+	head     *node
+	tail     *node
+	nodes    int
+	count    int
+}
+
+// New creates an empty list
+func New() *List {
+	return &List{encoding: encodingListpack}
+}
+
+// Len returns the number of elements
+func (l *List) Len() int {
+	return l.count
+}
+
+// Encoding returns the encoding type of the list
+func (l *List) Encoding() int {
+	return l.encoding
+}
+
+// ObjectEncoding returns the name of the encoding of the list, as OBJECT ENCODING replies it
+func (l *List) ObjectEncoding() string {
+	if l.encoding == encodingListpack {
+		return "listpack"
+	}
+	return "quicklist"
+}
+
+// oversized reports whether the node must be split
+func oversized(n *node) bool {
+	return n.entries.Len() > listMaxNodeEntries || (n.entries.Len() > 1 && n.entries.Bytes() > listMaxNodeBytes)
+}
+
+// fits reports whether val can be added to the node without splitting it
+func fits(n *node, val []byte) bool {
+	return n.entries.Len() < listMaxNodeEntries && n.entries.Bytes()+len(val) <= listMaxNodeBytes
+}
+
+// insertNode links a new empty node after prev, or at the head if prev is nil
+func (l *List) insertNode(prev *node) *node {
+	n := &node{prev: prev, entries: listpack.New()}
+	if prev == nil {
+		n.next = l.head
+		l.head = n
+	} else {
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next == nil {
+		l.tail = n
+	} else {
+		n.next.prev = n
+	}
+	l.nodes++
+	return n
+}
+
+// removeNode unlinks the node
+func (l *List) removeNode(n *node) {
+	if n.prev == nil {
+		l.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		l.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	l.nodes--
+}
+
+// updateEncoding converts the list after its nodes changed
+func (l *List) updateEncoding() {
+	switch {
+	case l.nodes > 1:
+		l.encoding = encodingQuicklist
+	case l.head == nil:
+		l.encoding = encodingListpack
+	case l.head.entries.Len() <= listMaxNodeEntries/2 && l.head.entries.Bytes() <= listMaxNodeBytes/2:
+		l.encoding = encodingListpack
+	}
+}
+
+// PushFront adds the value at the head
+func (l *List) PushFront(val []byte) {
+	if l.head == nil || !fits(l.head, val) {
+		l.insertNode(nil)
+	}
+	l.head.entries.Insert(0, val)
+	l.count++
+	l.updateEncoding()
+}
+
+// PushBack adds the value at the tail
+func (l *List) PushBack(val []byte) {
+	if l.tail == nil || !fits(l.tail, val) {
+		l.insertNode(l.tail)
+	}
+	l.tail.entries.Append(val)
+	l.count++
+	l.updateEncoding()
+}
+
+// PopFront removes and returns the element at the head
+func (l *List) PopFront() ([]byte, bool) {
+	if l.head == nil {
+		return nil, false
+	}
+	val, _ := l.head.entries.Get(0)
+	val = append([]byte{}, val...)
+	l.deleteAt(l.head, 0)
+	return val, true
+}
+
+// PopBack removes and returns the element at the tail
+func (l *List) PopBack() ([]byte, bool) {
+	if l.tail == nil {
+		return nil, false
+	}
+	last := l.tail.entries.Len() - 1
+	val, _ := l.tail.entries.Get(last)
+	val = append([]byte{}, val...)
+	l.deleteAt(l.tail, last)
+	return val, true
+}
+
+// locate returns the node holding the element at index and its index in the node, walking from
+// the nearest end. index must be in range
+func (l *List) locate(index int) (*node, int) {
+	if index < l.count/2 {
+		n := l.head
+		for index >= n.entries.Len() {
+			index -= n.entries.Len()
+			n = n.next
+		}
+		return n, index
+	}
+	n := l.tail
+	back := l.count - 1 - index
+	for back >= n.entries.Len() {
+		back -= n.entries.Len()
+		n = n.prev
+	}
+	return n, n.entries.Len() - 1 - back
+}
+
+// Get returns a copy of the element at index
+func (l *List) Get(index int) ([]byte, bool) {
+	if index < 0 || index >= l.count {
+		return nil, false
+	}
+	n, i := l.locate(index)
+	val, _ := n.entries.Get(i)
+	return append([]byte{}, val...), true
+}
+
+// Set replaces the element at index
+func (l *List) Set(index int, val []byte) bool {
+	if index < 0 || index >= l.count {
+		return false
+	}
+	n, i := l.locate(index)
+	n.entries.Replace(i, val)
+	l.rebalance(n)
+	l.updateEncoding()
+	return true
+}
+
+// Insert inserts the value before the element at index, index == Len() appends it
+func (l *List) Insert(index int, val []byte) bool {
+	if index < 0 || index > l.count {
+		return false
+	}
+	if index == l.count {
+		l.PushBack(val)
+		return true
+	}
+	n, i := l.locate(index)
+	n.entries.Insert(i, val)
+	l.count++
+	l.rebalance(n)
+	l.updateEncoding()
+	return true
+}
+
+// rebalance splits the node in halves until no part is oversized
+func (l *List) rebalance(n *node) {
+	if !oversized(n) {
+		return
+	}
+	half := n.entries.Len() / 2
+	right := l.insertNode(n)
+	for i := half; i < n.entries.Len(); i++ {
+		val, _ := n.entries.Get(i)
+		right.entries.Append(val)
+	}
+	n.entries.Delete(half, n.entries.Len()-half)
+	l.rebalance(n)
+	l.rebalance(right)
+}
+
+// Delete removes count elements starting from index, returns the number of removed elements
+func (l *List) Delete(index int, count int) int {
+	if index < 0 || index >= l.count || count <= 0 {
+		return 0
+	}
+	if index+count > l.count {
+		count = l.count - index
+	}
+	n, i := l.locate(index)
+	removed := 0
+	var before *node
+	for removed < count {
+		next := n.next
+		var deleted int
+		deleted, before = l.deleteIn(n, i, count-removed)
+		removed += deleted
+		n, i = next, 0
+	}
+	l.mergeAt(before)
+	l.updateEncoding()
+	return removed
+}
+
+// Remove removes the elements equal to val, count > 0 removes the first count of them from the
+// head, count < 0 the last -count from the tail and 0 all of them. It returns the number of
+// removed elements
+func (l *List) Remove(val []byte, count int) int {
+	var matches []int
+	l.ForEach(func(index int, elem []byte) bool {
+		if string(elem) == string(val) {
+			matches = append(matches, index)
+		}
+		return count <= 0 || len(matches) < count
+	})
+	if count < 0 && len(matches) > -count {
+		matches = matches[len(matches)+count:]
+	}
+	// from the tail so that the indexes of the next matches do not move
+	for i := len(matches) - 1; i >= 0; i-- {
+		l.Delete(matches[i], 1)
+	}
+	return len(matches)
+}
+
+// Trim keeps the elements from start to stop included, the indexes must be in range. An empty
+// range empties the list
+func (l *List) Trim(start int, stop int) {
+	if start > stop || start >= l.count || stop < 0 {
+		l.Delete(0, l.count)
+		return
+	}
+	l.Delete(stop+1, l.count-stop-1)
+	l.Delete(0, start)
+}
+
+// deleteAt removes the element of the node at index
+func (l *List) deleteAt(n *node, index int) {
+	_, before := l.deleteIn(n, index, 1)
+	l.mergeAt(before)
+	l.updateEncoding()
+}
+
+// deleteIn removes up to count elements of the node from index, it returns the number of removed
+// elements and the node before the gap they leave, nil if it is at the head
+func (l *List) deleteIn(n *node, index int, count int) (int, *node) {
+	count = n.entries.Delete(index, count)
+	l.count -= count
+	if n.entries.Len() > 0 {
+		return count, n
+	}
+	l.removeNode(n)
+	return count, n.prev
+}
+
+// mergeAt merges the nodes around the gap following before, or at the head if before is nil
+func (l *List) mergeAt(before *node) {
+	if before == nil {
+		before = l.head
+	}
+	if before == nil {
+		return
+	}
+	if before.next != nil {
+		l.merge(before, before.next)
+	}
+	if before.prev != nil {
+		l.merge(before.prev, before)
+	}
+}
+
+// merge moves the elements of right at the end of left if they fit in one node
+func (l *List) merge(left *node, right *node) {
+	if left.entries.Len()+right.entries.Len() > listMaxNodeEntries/2 ||
+		left.entries.Bytes()+right.entries.Bytes() > listMaxNodeBytes/2 {
+		return
+	}
+	right.entries.ForEach(func(_ int, val []byte) bool {
+		left.entries.Append(val)
+		return true
+	})
+	l.removeNode(right)
+}
+
+// ForEach calls consumer for every element from head to tail until it returns false
+// The value is only valid until the list is modified
+func (l *List) ForEach(consumer func(index int, val []byte) bool) {
+	index := 0
+	for n := l.head; n != nil; n = n.next {
+		stop := false
+		n.entries.ForEach(func(_ int, val []byte) bool {
+			stop = !consumer(index, val)
+			index++
+			return !stop
+		})
+		if stop {
+			return
+		}
+	}
+}
+
+// Range returns a copy of the elements from start to stop included, the indexes must be in range
+func (l *List) Range(start int, stop int) [][]byte {
+	if start < 0 || stop >= l.count || start > stop {
+		return [][]byte{}
+	}
+	result := make([][]byte, 0, stop-start+1)
+	l.ForEachInRange(start, stop, func(val []byte) bool {
+		result = append(result, append([]byte{}, val...))
+		return true
+	})
+	return result
+}
+
+// ForEachInRange calls consumer for the elements from start to stop included until it returns
+// false, the indexes must be in range. The value is only valid until the list is modified
+func (l *List) ForEachInRange(start int, stop int, consumer func(val []byte) bool) {
+	if start < 0 || stop >= l.count || start > stop {
+		return
+	}
+	remaining := stop - start + 1
+	n, i := l.locate(start)
+	for ; n != nil && remaining > 0; n, i = n.next, 0 {
+		for ; i < n.entries.Len() && remaining > 0; i++ {
+			val, _ := n.entries.Get(i)
+			remaining--
+			if !consumer(val) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns a copy of all elements
+func (l *List) Values() [][]byte {
+	return l.Range(0, l.count-1)
+}
+
+// Verify checks the links of the nodes, their listpacks and the counters of the list
+func (l *List) Verify() error {
+	nodes, count := 0, 0
+	var prev *node
+	for n := l.head; n != nil; prev, n = n, n.next {
+		if n.prev != prev {
+			return fmt.Errorf("list: broken link before node %d", nodes)
+		}
+		if n.entries.Len() == 0 {
+			return fmt.Errorf("list: empty node %d", nodes)
+		}
+		if err := n.entries.Verify(); err != nil {
+			return err
+		}
+		nodes++
+		count += n.entries.Len()
+	}
+	if l.tail != prev {
+		return errors.New("list: tail is not the last node")
+	}
+	if nodes != l.nodes || count != l.count {
+		return fmt.Errorf("list: %d nodes of %d elements, counters are %d and %d", nodes, count, l.nodes, l.count)
+	}
+	if l.encoding == encodingListpack && nodes > 1 {
+		return fmt.Errorf("list: listpack encoding with %d nodes", nodes)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package list
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// assertValues checks the elements and the invariants of the list
+func assertValues(t *testing.T, l *List, expected []string) {
+	t.Helper()
+	if err := l.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != len(expected) {
+		t.Fatalf("Expected %d elements, got %d", len(expected), l.Len())
+	}
+	for i, val := range l.Values() {
+		if string(val) != expected[i] {
+			t.Fatalf("Element %d is %q, expected %q", i, val, expected[i])
+		}
+	}
+}
+
+// TestPushAndPop tests both ends of the list
+func TestPushAndPop(t *testing.T) {
+	l := New()
+	if _, ok := l.PopFront(); ok {
+		t.Error("PopFront of an empty list should fail")
+	}
+	l.PushBack([]byte("b"))
+	l.PushFront([]byte("a"))
+	l.PushBack([]byte("42"))
+	assertValues(t, l, []string{"a", "b", "42"})
+
+	if val, ok := l.PopBack(); !ok || string(val) != "42" {
+		t.Errorf("PopBack = %q, expected 42", val)
+	}
+	if val, ok := l.PopFront(); !ok || string(val) != "a" {
+		t.Errorf("PopFront = %q, expected a", val)
+	}
+	l.PopFront()
+	assertValues(t, l, nil)
+	if l.head != nil || l.tail != nil {
+		t.Error("Empty list should have no node")
+	}
+}
+
+// TestEncodingConversion tests the conversion to a quicklist and back
+func TestEncodingConversion(t *testing.T) {
+	l := New()
+	for i := 0; i < listMaxNodeEntries; i++ {
+		l.PushBack([]byte(strconv.Itoa(i)))
+	}
+	if l.Encoding() != encodingListpack || l.nodes != 1 {
+		t.Fatalf("A full node should keep the listpack encoding, got %d with %d nodes", l.Encoding(), l.nodes)
+	}
+	l.PushBack([]byte("more"))
+	if l.Encoding() != encodingQuicklist || l.nodes != 2 {
+		t.Fatalf("Expected quicklist encoding with 2 nodes, got %d with %d nodes", l.Encoding(), l.nodes)
+	}
+	for l.Len() > listMaxNodeEntries/2 {
+		l.PopFront()
+	}
+	if l.Encoding() != encodingListpack || l.nodes != 1 {
+		t.Errorf("Expected listpack encoding after shrinking, got %d with %d nodes", l.Encoding(), l.nodes)
+	}
+
+	big := New()
+	big.PushBack([]byte("small"))
+	big.PushBack([]byte(strings.Repeat("x", listMaxNodeBytes)))
+	if big.Encoding() != encodingQuicklist {
+		t.Error("An element larger than a node should convert to quicklist")
+	}
+	assertValues(t, big, []string{"small", strings.Repeat("x", listMaxNodeBytes)})
+}
+
+// TestGetSetInsertDelete tests the access by index across nodes
+func TestGetSetInsertDelete(t *testing.T) {
+	l := New()
+	var expected []string
+	for i := 0; i < 1000; i++ {
+		l.PushBack([]byte(strconv.Itoa(i)))
+		expected = append(expected, strconv.Itoa(i))
+	}
+	for _, index := range []int{0, 127, 128, 500, 999} {
+		if val, ok := l.Get(index); !ok || string(val) != expected[index] {
+			t.Errorf("Get(%d) = %q, expected %q", index, val, expected[index])
+		}
+	}
+	if _, ok := l.Get(1000); ok {
+		t.Error("Get out of range should fail")
+	}
+
+	l.Set(500, []byte("five hundred"))
+	expected[500] = "five hundred"
+	l.Insert(128, []byte("inserted"))
+	expected = append(expected[:128], append([]string{"inserted"}, expected[128:]...)...)
+	assertValues(t, l, expected)
+
+	if n := l.Delete(100, 300); n != 300 {
+		t.Errorf("Delete removed %d elements, expected 300", n)
+	}
+	expected = append(expected[:100], expected[400:]...)
+	assertValues(t, l, expected)
+	if n := l.Delete(l.Len()-1, 10); n != 1 {
+		t.Errorf("Delete past the tail removed %d elements, expected 1", n)
+	}
+	expected = expected[:len(expected)-1]
+
+	if got := l.Range(10, 12); len(got) != 3 || string(got[0]) != expected[10] || string(got[2]) != expected[12] {
+		t.Errorf("Range(10, 12) = %q", got)
+	}
+	assertValues(t, l, expected)
+}
+
+// TestRemoveAndTrim tests the count of Remove and the bounds of Trim on a quicklist
+func TestRemoveAndTrim(t *testing.T) {
+	l := New()
+	var expected []string
+	for i := 0; i < 600; i++ {
+		val := "x"
+		if i%3 != 0 {
+			val = strconv.Itoa(i)
+		}
+		l.PushBack([]byte(val))
+		expected = append(expected, val)
+	}
+	if n := l.Remove([]byte("x"), 2); n != 2 {
+		t.Errorf("Remove from the head removed %d elements, expected 2", n)
+	}
+	expected = append(expected[1:3], expected[4:]...)
+	if n := l.Remove([]byte("x"), -1); n != 1 {
+		t.Errorf("Remove from the tail removed %d elements, expected 1", n)
+	}
+	expected = expected[:len(expected)-3]
+	expected = append(expected, "598", "599")
+	assertValues(t, l, expected)
+	if n := l.Remove([]byte("x"), 0); n != 197 {
+		t.Errorf("Remove of all removed %d elements, expected 197", n)
+	}
+	if n := l.Remove([]byte("missing"), 0); n != 0 {
+		t.Errorf("Remove of a missing value removed %d elements", n)
+	}
+	if l.Len() != 400 {
+		t.Fatalf("Expected 400 elements, got %d", l.Len())
+	}
+
+	kept := l.Values()[100:151]
+	l.Trim(100, 150)
+	expected = expected[:0]
+	for _, val := range kept {
+		expected = append(expected, string(val))
+	}
+	assertValues(t, l, expected)
+	l.Trim(10, 5)
+	assertValues(t, l, nil)
+}
+
+// TestCopies tests that the replied elements are not modified by later changes
+func TestCopies(t *testing.T) {
+	l := New()
+	l.PushBack([]byte("first"))
+	l.PushBack([]byte("second"))
+	got, _ := l.Get(1)
+	values := l.Range(0, 1)
+	l.Set(1, []byte("SECOND"))
+	l.PushFront([]byte("zero"))
+	if string(got) != "second" || string(values[0]) != "first" || string(values[1]) != "second" {
+		t.Errorf("Replied elements changed to %q and %q", got, values)
+	}
+}
+
+// TestRandomOperations compares the list with a slice under random operations
+func TestRandomOperations(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	l := New()
+	var expected []string
+	for op := 0; op < 20000; op++ {
+		val := strconv.Itoa(r.Intn(1000))
+		if r.Intn(50) == 0 {
+			val = strings.Repeat("v", r.Intn(2*listMaxNodeBytes))
+		}
+		switch r.Intn(7) {
+		case 0:
+			l.PushFront([]byte(val))
+			expected = append([]string{val}, expected...)
+		case 1, 2:
+			l.PushBack([]byte(val))
+			expected = append(expected, val)
+		case 3:
+			if got, ok := l.PopFront(); ok != (len(expected) > 0) || (ok && string(got) != expected[0]) {
+				t.Fatalf("PopFront = %q, %v", got, ok)
+			}
+			if len(expected) > 0 {
+				expected = expected[1:]
+			}
+		case 4:
+			if got, ok := l.PopBack(); ok != (len(expected) > 0) || (ok && string(got) != expected[len(expected)-1]) {
+				t.Fatalf("PopBack = %q, %v", got, ok)
+			}
+			if len(expected) > 0 {
+				expected = expected[:len(expected)-1]
+			}
+		case 5:
+			index := r.Intn(len(expected) + 1)
+			l.Insert(index, []byte(val))
+			expected = append(expected[:index], append([]string{val}, expected[index:]...)...)
+		case 6:
+			if len(expected) == 0 {
+				continue
+			}
+			index := r.Intn(len(expected))
+			count := r.Intn(20)
+			l.Delete(index, count)
+			end := index + count
+			if end > len(expected) {
+				end = len(expected)
+			}
+			expected = append(expected[:index], expected[end:]...)
+		}
+		if err := l.Verify(); err != nil {
+			t.Fatalf("After operation %d: %v", op, err)
+		}
+	}
+	assertValues(t, l, expected)
+}
@@ -0,0 +1,372 @@
+// Package listpack implements a compact list of strings serialized in a single byte slice,
+// used to store small hashes, sorted sets and stream entries with little memory overhead
+package listpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// Layout of an entry:
+//
+//	<tag> <payload> <backlen>
+//
+// tag is tagString or tagInt. The payload of a string is its length as uvarint followed by
+// the bytes, the payload of an integer is the zigzag varint of the value.
+// backlen is the length of tag + payload, encoded so that it can be read backwards,
+// which allows iterating from the tail.
+const (
+	tagString byte = iota
+	tagInt
+)
+
+// Listpack is a list of byte strings stored contiguously
+// Strings holding a canonical decimal integer are stored as varint
+type Listpack struct {
+	buf   []byte
+	count int
+}
+
+// New creates an empty listpack
+func New() *Listpack {
+	return &Listpack{}
+}
+
+// Len returns the number of elements
+func (lp *Listpack) Len() int {
+	return lp.count
+}
+
+// Bytes returns the number of bytes used by the elements
+func (lp *Listpack) Bytes() int {
+	return len(lp.buf)
+}
+
+// encode serializes an element
+func encode(val []byte) []byte {
+	var entry []byte
+	if n, ok := parseInt(val); ok {
+		entry = make([]byte, 1, 1+binary.MaxVarintLen64+2)
+		entry[0] = tagInt
+		entry = binary.AppendVarint(entry, n)
+	} else {
+		entry = make([]byte, 1, 1+binary.MaxVarintLen64+len(val)+4)
+		entry[0] = tagString
+		entry = binary.AppendUvarint(entry, uint64(len(val)))
+		entry = append(entry, val...)
+	}
+	return appendBacklen(entry, len(entry))
+}
+
+// parseInt returns the value of val if it is a canonical decimal integer, e.g. not "007" or "+1"
+func parseInt(val []byte) (int64, bool) {
+	if len(val) == 0 || len(val) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(string(val), 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != string(val) {
+		return 0, false
+	}
+	return n, true
+}
+
+// appendBacklen appends n in 7-bit groups, most significant first, all but the first byte
+// having the high bit set, so that reading from the end the high bit means "more bytes"
+func appendBacklen(buf []byte, n int) []byte {
+	var groups [5]byte
+	size := 0
+	for {
+		groups[size] = byte(n & 0x7f)
+		size++
+		n >>= 7
+		if n == 0 {
+			break
+		}
+	}
+	for i := size - 1; i >= 0; i-- {
+		b := groups[i]
+		if i != size-1 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+	}
+	return buf
+}
+
+// readBacklen reads the backlen ending just before end, returns the length and the size of backlen
+func readBacklen(buf []byte, end int) (int, int) {
+	n, shift, size := 0, 0, 0
+	for {
+		b := buf[end-1-size]
+		n |= int(b&0x7f) << shift
+		size++
+		if b&0x80 == 0 {
+			return n, size
+		}
+		shift += 7
+	}
+}
+
+// entryAt decodes the entry starting at pos, returns the payload and the total size of the entry
+// For strings the payload is a slice of the buffer, integers are formatted in decimal
+func (lp *Listpack) entryAt(pos int) ([]byte, int) {
+	tag := lp.buf[pos]
+	var val []byte
+	var encLen int
+	if tag == tagInt {
+		n, size := binary.Varint(lp.buf[pos+1:])
+		val = strconv.AppendInt(nil, n, 10)
+		encLen = 1 + size
+	} else {
+		length, size := binary.Uvarint(lp.buf[pos+1:])
+		start := pos + 1 + size
+		val = lp.buf[start : start+int(length) : start+int(length)]
+		encLen = 1 + size + int(length)
+	}
+	return val, encLen + backlenSize(encLen)
+}
+
+// Verify checks that the buffer holds exactly Len well-formed entries whose backlens match
+func (lp *Listpack) Verify() error {
+	count := 0
+	for pos := 0; pos < len(lp.buf); count++ {
+		tag := lp.buf[pos]
+		if tag != tagString && tag != tagInt {
+			return fmt.Errorf("listpack: invalid tag %d at byte %d", tag, pos)
+		}
+		var size int
+		var length uint64
+		if tag == tagInt {
+			_, size = binary.Varint(lp.buf[pos+1:])
+		} else {
+			length, size = binary.Uvarint(lp.buf[pos+1:])
+		}
+		if size <= 0 || length > uint64(len(lp.buf)) {
+			return fmt.Errorf("listpack: truncated entry at byte %d", pos)
+		}
+		encLen := 1 + size + int(length)
+		end := pos + encLen + backlenSize(encLen)
+		if end > len(lp.buf) {
+			return fmt.Errorf("listpack: truncated entry at byte %d", pos)
+		}
+		if n, _ := readBacklen(lp.buf, end); n != encLen {
+			return fmt.Errorf("listpack: backlen %d of the entry at byte %d, expected %d", n, pos, encLen)
+		}
+		pos = end
+	}
+	if count != lp.count {
+		return fmt.Errorf("listpack: %d entries, length is %d", count, lp.count)
+	}
+	return nil
+}
+
+// backlenSize returns the number of bytes used by the backlen of n
+func backlenSize(n int) int {
+	size := 1
+	for n >= 0x80 {
+		n >>= 7
+		size++
+	}
+	return size
+}
+
+// seek returns the byte offset of the element at index, or len(buf) if index == Len()
+func (lp *Listpack) seek(index int) int {
+	if index >= lp.count {
+		return len(lp.buf)
+	}
+	// walk from the nearest end
+	if index <= lp.count/2 {
+		pos := 0
+		for i := 0; i < index; i++ {
+			_, size := lp.entryAt(pos)
+			pos += size
+		}
+		return pos
+	}
+	pos := len(lp.buf)
+	for i := lp.count; i > index; i-- {
+		encLen, size := readBacklen(lp.buf, pos)
+		pos -= encLen + size
+	}
+	return pos
+}
+
+// Append adds the values at the tail
+func (lp *Listpack) Append(values ...[]byte) {
+	for _, val := range values {
+		lp.buf = append(lp.buf, encode(val)...)
+		lp.count++
+	}
+}
+
+// Insert inserts the value before the element at index, index == Len() appends it
+func (lp *Listpack) Insert(index int, val []byte) bool {
+	if index < 0 || index > lp.count {
+		return false
+	}
+	pos := lp.seek(index)
+	entry := encode(val)
+	lp.buf = append(lp.buf, entry...) // grow the buffer
+	copy(lp.buf[pos+len(entry):], lp.buf[pos:len(lp.buf)-len(entry)])
+	copy(lp.buf[pos:], entry)
+	lp.count++
+	return true
+}
+
+// Get returns the element at index
+func (lp *Listpack) Get(index int) ([]byte, bool) {
+	if index < 0 || index >= lp.count {
+		return nil, false
+	}
+	val, _ := lp.entryAt(lp.seek(index))
+	return val, true
+}
+
+// Replace sets the element at index
+func (lp *Listpack) Replace(index int, val []byte) bool {
+	if index < 0 || index >= lp.count {
+		return false
+	}
+	pos := lp.seek(index)
+	_, oldSize := lp.entryAt(pos)
+	lp.splice(pos, oldSize, encode(val))
+	return true
+}
+
+// Delete removes count elements starting from index, returns the number of removed elements
+func (lp *Listpack) Delete(index int, count int) int {
+	if index < 0 || index >= lp.count || count <= 0 {
+		return 0
+	}
+	if index+count > lp.count {
+		count = lp.count - index
+	}
+	start := lp.seek(index)
+	end := start
+	for i := 0; i < count; i++ {
+		_, size := lp.entryAt(end)
+		end += size
+	}
+	lp.splice(start, end-start, nil)
+	lp.count -= count
+	return count
+}
+
+// splice replaces size bytes at pos with data
+func (lp *Listpack) splice(pos int, size int, data []byte) {
+	tail := len(lp.buf) - pos - size
+	newLen := pos + len(data) + tail
+	if newLen > len(lp.buf) {
+		lp.buf = append(lp.buf, make([]byte, newLen-len(lp.buf))...)
+		copy(lp.buf[pos+len(data):], lp.buf[pos+size:pos+size+tail])
+	} else {
+		copy(lp.buf[pos+len(data):], lp.buf[pos+size:])
+		lp.buf = lp.buf[:newLen]
+	}
+	copy(lp.buf[pos:], data)
+}
+
+// Find returns the index of the first element equal to val among the elements
+// at index 0, step, 2*step... or -1 if not found
+// For example Find(field, 2) looks up a field in a listpack of field value pairs
+func (lp *Listpack) Find(val []byte, step int) int {
+	if step <= 0 {
+		step = 1
+	}
+	pos := 0
+	for i := 0; i < lp.count; i++ {
+		elem, size := lp.entryAt(pos)
+		if i%step == 0 && bytes.Equal(elem, val) {
+			return i
+		}
+		pos += size
+	}
+	return -1
+}
+
+// ForEach calls consumer for every element from head to tail until it returns false
+// The value is only valid until the listpack is modified
+func (lp *Listpack) ForEach(consumer func(index int, val []byte) bool) {
+	it := lp.Iterator()
+	for it.Next() {
+		if !consumer(it.Index(), it.Value()) {
+			return
+		}
+	}
+}
+
+// Values returns a copy of all elements
+func (lp *Listpack) Values() [][]byte {
+	result := make([][]byte, 0, lp.count)
+	lp.ForEach(func(_ int, val []byte) bool {
+		result = append(result, append([]byte{}, val...))
+		return true
+	})
+	return result
+}
+
+// Iterator walks the listpack in both directions
+type Iterator struct {
+	lp    *Listpack
+	pos   int // offset of the current element
+	next  int // offset of the element after the current one
+	index int
+	value []byte
+}
+
+// Iterator returns an iterator positioned before the head, call Next to move to the first element
+func (lp *Listpack) Iterator() *Iterator {
+	return &Iterator{lp: lp, index: -1}
+}
+
+// ReverseIterator returns an iterator positioned after the tail, call Prev to move to the last element
+func (lp *Listpack) ReverseIterator() *Iterator {
+	return &Iterator{lp: lp, pos: len(lp.buf), next: len(lp.buf), index: lp.count}
+}
+
+// Next moves to the next element, returns false at the end
+func (it *Iterator) Next() bool {
+	if it.index+1 >= it.lp.count {
+		// move after the tail so that Prev returns the last element
+		it.index = it.lp.count
+		it.pos = len(it.lp.buf)
+		it.next = it.pos
+		return false
+	}
+	it.pos = it.next
+	val, size := it.lp.entryAt(it.pos)
+	it.value = val
+	it.next = it.pos + size
+	it.index++
+	return true
+}
+
+// Prev moves to the previous element, returns false at the beginning
+func (it *Iterator) Prev() bool {
+	if it.index <= 0 {
+		// move before the head so that Next returns the first element
+		it.index = -1
+		it.pos = 0
+		it.next = 0
+		return false
+	}
+	encLen, size := readBacklen(it.lp.buf, it.pos)
+	it.next = it.pos
+	it.pos -= encLen + size
+	it.value, _ = it.lp.entryAt(it.pos)
+	it.index--
+	return true
+}
+
+// Index returns the index of the current element
+func (it *Iterator) Index() int {
+	return it.index
+}
+
+// Value returns the current element, which is only valid until the listpack is modified
+func (it *Iterator) Value() []byte {
+	return it.value
+}
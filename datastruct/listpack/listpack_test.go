@@ -0,0 +1,135 @@
+package listpack
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// values returns the elements as strings
+func values(lp *Listpack) []string {
+	result := make([]string, 0, lp.Len())
+	lp.ForEach(func(_ int, val []byte) bool {
+		result = append(result, string(val))
+		return true
+	})
+	return result
+}
+
+// TestAppendAndGet tests appending strings and integers
+func TestAppendAndGet(t *testing.T) {
+	lp := New()
+	inputs := []string{"hello", "", "123", "-45", "007", "+1", "9223372036854775807", strings.Repeat("x", 300)}
+	for _, in := range inputs {
+		lp.Append([]byte(in))
+	}
+	if lp.Len() != len(inputs) {
+		t.Fatalf("Expected %d elements, got %d", len(inputs), lp.Len())
+	}
+	for i, in := range inputs {
+		val, ok := lp.Get(i)
+		if !ok || string(val) != in {
+			t.Errorf("Get(%d) = %q, expected %q", i, val, in)
+		}
+	}
+	if _, ok := lp.Get(len(inputs)); ok {
+		t.Error("Get out of range should fail")
+	}
+}
+
+// TestInsertReplaceDelete tests modifications in the middle of the listpack
+func TestInsertReplaceDelete(t *testing.T) {
+	lp := New()
+	lp.Append([]byte("a"), []byte("c"))
+	lp.Insert(1, []byte("b"))
+	lp.Insert(0, []byte("start"))
+	lp.Insert(lp.Len(), []byte("end"))
+	if got := strings.Join(values(lp), ","); got != "start,a,b,c,end" {
+		t.Fatalf("Unexpected elements after insert: %s", got)
+	}
+
+	lp.Replace(2, []byte(strings.Repeat("long", 50)))
+	lp.Replace(3, []byte("42"))
+	val, _ := lp.Get(2)
+	if string(val) != strings.Repeat("long", 50) {
+		t.Errorf("Replace with a longer value failed, got %q", val)
+	}
+	lp.Replace(2, []byte("B"))
+	if got := strings.Join(values(lp), ","); got != "start,a,B,42,end" {
+		t.Fatalf("Unexpected elements after replace: %s", got)
+	}
+
+	if n := lp.Delete(1, 2); n != 2 {
+		t.Errorf("Expected 2 deleted elements, got %d", n)
+	}
+	if n := lp.Delete(2, 10); n != 1 {
+		t.Errorf("Expected 1 deleted element, got %d", n)
+	}
+	if got := strings.Join(values(lp), ","); got != "start,42" {
+		t.Fatalf("Unexpected elements after delete: %s", got)
+	}
+}
+
+// TestFind tests looking up fields in a listpack of pairs
+func TestFind(t *testing.T) {
+	lp := New()
+	lp.Append([]byte("f1"), []byte("f2"), []byte("f2"), []byte("v2"))
+	if i := lp.Find([]byte("f2"), 2); i != 2 {
+		t.Errorf("Expected f2 at index 2 with step 2, got %d", i)
+	}
+	if i := lp.Find([]byte("f2"), 1); i != 1 {
+		t.Errorf("Expected f2 at index 1 with step 1, got %d", i)
+	}
+	if i := lp.Find([]byte("v2"), 2); i != -1 {
+		t.Errorf("Values should not be found with step 2, got %d", i)
+	}
+}
+
+// TestIterator tests iterating in both directions
+func TestIterator(t *testing.T) {
+	lp := New()
+	for i := 0; i < 200; i++ {
+		lp.Append([]byte(strconv.Itoa(i) + strings.Repeat("-", i)))
+	}
+
+	it := lp.Iterator()
+	count := 0
+	for it.Next() {
+		expected := strconv.Itoa(count) + strings.Repeat("-", count)
+		if string(it.Value()) != expected || it.Index() != count {
+			t.Fatalf("Next: got %q at %d, expected %q at %d", it.Value(), it.Index(), expected, count)
+		}
+		count++
+	}
+	if count != 200 {
+		t.Fatalf("Expected 200 elements, got %d", count)
+	}
+
+	it = lp.ReverseIterator()
+	for i := 199; i >= 0; i-- {
+		if !it.Prev() {
+			t.Fatalf("Prev stopped early at %d", i)
+		}
+		expected := strconv.Itoa(i) + strings.Repeat("-", i)
+		if string(it.Value()) != expected {
+			t.Fatalf("Prev: got %q, expected %q", it.Value(), expected)
+		}
+	}
+	if it.Prev() {
+		t.Error("Prev should stop before the head")
+	}
+	// change direction
+	if !it.Next() || !bytes.Equal(it.Value(), []byte("0")) {
+		t.Errorf("Next after the head should return the first element, got %q", it.Value())
+	}
+}
+
+// TestCompactIntegers tests that integers take less space than their decimal form
+func TestCompactIntegers(t *testing.T) {
+	lp := New()
+	lp.Append([]byte("1234567890123"))
+	if lp.Bytes() >= len("1234567890123") {
+		t.Errorf("Integer should be encoded compactly, used %d bytes", lp.Bytes())
+	}
+}
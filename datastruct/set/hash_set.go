@@ -1,20 +1,37 @@
 package set
 
 import (
+	"fmt"
+	"math"
+	"math/rand"
+	"redigo/datastruct/listpack"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
-
-	"math/rand"
 )
 
 const (
 	SET_MAX_INTSET_ENTRIES = 512
+	// SET_MAX_LISTPACK_ENTRIES and SET_MAX_LISTPACK_VALUE bound a set in listpack encoding, those
+	// of Redis: a set with more members or a longer member is converted to a hash table
+	SET_MAX_LISTPACK_ENTRIES = 128
+	SET_MAX_LISTPACK_VALUE   = 64
+)
+
+// The encoding types for the set: an intset while all members are integers, then a listpack
+// while the set is small, then a hash table
+const (
+	encodingIntSet = iota
+	encodingListpack
+	encodingHashTable
 )
 
 type HashSet struct {
+	encoding int
 	dict     map[string]struct{}
 	intset   *IntSet
-	isIntset bool
+	listpack *listpack.Listpack
 }
 
 // NewHashSet creates a new HashSet
@@ -22,13 +39,27 @@ func NewHashSet() *HashSet {
 	return &HashSet{
 		dict:     make(map[string]struct{}),
 		intset:   NewIntSet(),
-		isIntset: true, // Default to intset
+		encoding: encodingIntSet, // Default to intset
+	}
+}
+
+// NewHashSetFromIntSet creates a set holding the values of the intset, which must not be
+// modified afterwards, the set is converted to a hash table if it has too many members
+func NewHashSetFromIntSet(is *IntSet) *HashSet {
+	set := &HashSet{
+		dict:     make(map[string]struct{}),
+		intset:   is,
+		encoding: encodingIntSet,
 	}
+	if is.Len() > SET_MAX_INTSET_ENTRIES {
+		set.convertToHashTable()
+	}
+	return set
 }
 
-// Add adds an integer to the set
+// Add adds a member to the set
 func (set *HashSet) Add(member string) int {
-	if set.isIntset {
+	if set.encoding == encodingIntSet {
 		if val, err := strconv.ParseInt(member, 10, 64); err == nil {
 			if ok := set.intset.Add(val); ok {
 				if set.intset.Len() > SET_MAX_INTSET_ENTRIES {
@@ -37,12 +68,24 @@ func (set *HashSet) Add(member string) int {
 				return 1
 			}
 			return 0
+		} else if set.intset.Len() < SET_MAX_LISTPACK_ENTRIES && len(member) <= SET_MAX_LISTPACK_VALUE {
+			set.convertToListpack()
 		} else {
 			// The input is not a valid integer, so we need to convert to hash table
 			// to store non-integer values
 			set.convertToHashTable()
 		}
 	}
+	if set.encoding == encodingListpack {
+		if set.listpack.Find([]byte(member), 1) >= 0 {
+			return 0
+		}
+		if set.listpack.Len() < SET_MAX_LISTPACK_ENTRIES && len(member) <= SET_MAX_LISTPACK_VALUE {
+			set.listpack.Append([]byte(member))
+			return 1
+		}
+		set.convertToHashTable()
+	}
 
 	if _, exists := set.dict[member]; exists {
 		return 0 // Already exists
@@ -51,9 +94,10 @@ func (set *HashSet) Add(member string) int {
 	return 1 // Added successfully
 }
 
-// Remove removes an integer from the set
+// Remove removes a member from the set
 func (set *HashSet) Remove(member string) int {
-	if set.isIntset {
+	switch set.encoding {
+	case encodingIntSet:
 		// If the input is an integer, we can remove it from the intset
 		if val, err := strconv.ParseInt(member, 10, 64); err == nil {
 			if ok := set.intset.Remove(val); ok {
@@ -62,6 +106,12 @@ func (set *HashSet) Remove(member string) int {
 			return 0
 		}
 		return 0 // Not an integer, cannot remove from intset
+	case encodingListpack:
+		if index := set.listpack.Find([]byte(member), 1); index >= 0 {
+			set.listpack.Delete(index, 1)
+			return 1
+		}
+		return 0
 	}
 
 	if _, exists := set.dict[member]; !exists {
@@ -74,11 +124,14 @@ func (set *HashSet) Remove(member string) int {
 
 // Contains checks if the set contains the given value
 func (set *HashSet) Contains(member string) bool {
-	if set.isIntset {
+	switch set.encoding {
+	case encodingIntSet:
 		if val, err := strconv.ParseInt(member, 10, 64); err == nil {
 			return set.intset.Contains(val)
 		}
 		return false // Not an integer
+	case encodingListpack:
+		return set.listpack.Find([]byte(member), 1) >= 0
 	}
 	_, exists := set.dict[member]
 	return exists
@@ -86,37 +139,37 @@ func (set *HashSet) Contains(member string) bool {
 
 // Members returns all members of the set
 func (set *HashSet) Members() []string {
-	if set.isIntset {
-		members := make([]string, 0, set.intset.Len())
-		set.intset.ForEach(func(value int64) bool {
-			members = append(members, strconv.FormatInt(value, 10))
-			return true
-		})
-		return members
-	}
-
-	members := make([]string, 0, len(set.dict))
-	for member := range set.dict {
+	members := make([]string, 0, set.Len())
+	set.ForEach(func(member string) bool {
 		members = append(members, member)
-	}
+		return true
+	})
 	return members
 }
 
 // Len returns the number of members in the set
 func (set *HashSet) Len() int {
-	if set.isIntset {
+	switch set.encoding {
+	case encodingIntSet:
 		return set.intset.Len()
+	case encodingListpack:
+		return set.listpack.Len()
 	}
 	return len(set.dict)
 }
 
 // ForEach iterates over all members of the set
 func (set *HashSet) ForEach(consumer func(member string) bool) {
-	if set.isIntset {
+	switch set.encoding {
+	case encodingIntSet:
 		set.intset.ForEach(func(value int64) bool {
 			return consumer(strconv.FormatInt(value, 10))
 		})
-	} else {
+	case encodingListpack:
+		set.listpack.ForEach(func(_ int, val []byte) bool {
+			return consumer(string(val))
+		})
+	default:
 		for member := range set.dict {
 			if !consumer(member) {
 				break
@@ -125,7 +178,38 @@ func (set *HashSet) ForEach(consumer func(member string) bool) {
 	}
 }
 
+// random is the random generator shared by all sets, guarded by randomMu since sets stored
+// under different keys are used concurrently
+var (
+	randomMu sync.Mutex
+	random   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// randomIntn returns a random number in [0, n)
+func randomIntn(n int) int {
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	return random.Intn(n)
+}
+
+// randomFloat returns a random number in (0, 1]
+func randomFloat() float64 {
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	return 1 - random.Float64()
+}
+
+// randomShuffle shuffles the members in place
+func randomShuffle(members []string) {
+	randomMu.Lock()
+	defer randomMu.Unlock()
+	random.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+}
+
 // RandomMembers returns random members from the set
+// Members are drawn with replacement, so the result may contain duplicates
 func (set *HashSet) RandomMembers(count int) []string {
 	size := set.Len()
 	if count <= 0 || size == 0 {
@@ -133,16 +217,47 @@ func (set *HashSet) RandomMembers(count int) []string {
 	}
 
 	res := make([]string, count)
-	members := set.Members()
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	switch set.encoding {
+	case encodingIntSet:
+		// the intset is indexable, pick the members directly
+		for i := range res {
+			res[i] = strconv.FormatInt(set.intset.getValueAt(uint32(randomIntn(size))), 10)
+		}
+		return res
+	case encodingListpack:
+		members := set.Members()
+		for i := range res {
+			res[i] = members[randomIntn(size)]
+		}
+		return res
+	}
 
-	for i := 0; i < count; i++ {
-		res[i] = members[r.Intn(size)] // Use r.Intn(size) to get a random index
+	// draw the positions first, then collect them in a single pass over the hash table
+	positions := make([]int, count)
+	for i := range positions {
+		positions[i] = randomIntn(size)
+	}
+	sort.Ints(positions)
+	i, position := 0, 0
+	for member := range set.dict {
+		for i < count && positions[i] == position {
+			res[i] = member
+			i++
+		}
+		if i == count {
+			break
+		}
+		position++
 	}
+	// the pass returns the members in iteration order, shuffle them
+	randomShuffle(res)
 	return res
 }
 
 // RandomDistinctMembers returns distinct random members from the set
+// It uses reservoir sampling, so only count members are copied whatever the size of the set,
+// with the skips of Algorithm L so that random numbers are drawn for O(count*log(size/count))
+// members only
 func (set *HashSet) RandomDistinctMembers(count int) []string {
 	size := set.Len()
 	if count <= 0 || size == 0 {
@@ -153,31 +268,105 @@ func (set *HashSet) RandomDistinctMembers(count int) []string {
 		return set.Members() // Return all members if count is greater than or equal to size
 	}
 
-	members := set.Members()
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	r.Shuffle(len(members), func(i, j int) {
-		members[i], members[j] = members[j], members[i]
+	reservoir := make([]string, 0, count)
+	weight := math.Exp(math.Log(randomFloat()) / float64(count))
+	// next is the position of the next member entering the reservoir
+	next := count + int(math.Log(randomFloat())/math.Log(1-weight))
+	position := 0
+	set.ForEach(func(member string) bool {
+		switch {
+		case position < count:
+			reservoir = append(reservoir, member)
+		case position == next:
+			reservoir[randomIntn(count)] = member
+			weight *= math.Exp(math.Log(randomFloat()) / float64(count))
+			next += int(math.Log(randomFloat())/math.Log(1-weight)) + 1
+		}
+		position++
+		// stop once the next skip goes past the end of the set
+		return position < count || next < size
 	})
+	// the first members of the iteration fill the reservoir in order, shuffle them
+	randomShuffle(reservoir)
+	return reservoir
+}
 
-	return members[:count] // Return the first 'count' members after shuffling
+// convertToListpack converts the intset to a listpack
+func (set *HashSet) convertToListpack() {
+	set.listpack = listpack.New()
+	set.intset.ForEach(func(value int64) bool {
+		set.listpack.Append(strconv.AppendInt(nil, value, 10))
+		return true
+	})
+	set.intset = nil
+	set.encoding = encodingListpack
 }
 
-// convertToHashTable converts the intset to a hash table
+// convertToHashTable converts the intset or the listpack to a hash table
 func (set *HashSet) convertToHashTable() {
-	if !set.isIntset {
+	if set.encoding == encodingHashTable {
 		return // Already a hash table
 	}
 
-	// Copy elements from intset to hash table
-	set.intset.ForEach(func(value int64) bool {
-		set.dict[strconv.FormatInt(value, 10)] = struct{}{}
+	// Copy elements to hash table
+	set.ForEach(func(member string) bool {
+		set.dict[member] = struct{}{}
 		return true
 	})
 
-	set.isIntset = false
+	set.intset, set.listpack = nil, nil
+	set.encoding = encodingHashTable
 }
 
 // IsIntSet checks if the set is an IntSet
 func (set *HashSet) IsIntSet() bool {
-	return set.isIntset
+	return set.encoding == encodingIntSet
+}
+
+// ObjectEncoding returns the name of the encoding of the set, as OBJECT ENCODING replies it
+func (set *HashSet) ObjectEncoding() string {
+	switch set.encoding {
+	case encodingIntSet:
+		return "intset"
+	case encodingListpack:
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+// IntSet returns the intset holding the members, nil if the set is not an intset
+// The intset must not be modified
+func (set *HashSet) IntSet() *IntSet {
+	if set.encoding != encodingIntSet {
+		return nil
+	}
+	return set.intset
+}
+
+// Verify checks the invariants of the intset or the listpack holding the members, if any
+func (set *HashSet) Verify() error {
+	switch set.encoding {
+	case encodingIntSet:
+		return set.intset.Verify()
+	case encodingListpack:
+		if err := set.listpack.Verify(); err != nil {
+			return err
+		}
+		if set.listpack.Len() > SET_MAX_LISTPACK_ENTRIES {
+			return fmt.Errorf("set: %d members in listpack encoding, the limit is %d", set.listpack.Len(), SET_MAX_LISTPACK_ENTRIES)
+		}
+		var err error
+		seen := make(map[string]struct{}, set.listpack.Len())
+		set.listpack.ForEach(func(i int, val []byte) bool {
+			if len(val) > SET_MAX_LISTPACK_VALUE {
+				err = fmt.Errorf("set: member %d of %d bytes in listpack encoding, the limit is %d", i, len(val), SET_MAX_LISTPACK_VALUE)
+			} else if _, ok := seen[string(val)]; ok {
+				err = fmt.Errorf("set: member %d is a duplicate", i)
+			}
+			seen[string(val)] = struct{}{}
+			return err == nil
+		})
+		return err
+	}
+	return nil
 }
@@ -37,20 +37,8 @@ func (is *IntSet) Len() int {
 
 // Add adds an integer to the set
 func (is *IntSet) Add(value int64) bool {
-	// Check if we need to upgrade encoding
-	var requiredEncoding uint32
-	if value < math.MinInt16 || value > math.MaxInt16 {
-		if value < math.MinInt32 || value > math.MaxInt32 {
-			requiredEncoding = INTSET_ENC_INT64
-		} else {
-			requiredEncoding = INTSET_ENC_INT32
-		}
-	} else {
-		requiredEncoding = INTSET_ENC_INT16
-	}
-
 	// Upgrade encoding if necessary
-	if requiredEncoding > is.encoding {
+	if requiredEncoding := encodingFor(value); requiredEncoding > is.encoding {
 		is.upgradeEncoding(requiredEncoding)
 	}
 
@@ -83,8 +71,135 @@ func (is *IntSet) Remove(value int64) bool {
 	return true
 }
 
+// Clone returns a copy of the set
+func (is *IntSet) Clone() *IntSet {
+	contents := make([]byte, len(is.contents))
+	copy(contents, is.contents)
+	return &IntSet{encoding: is.encoding, length: is.length, contents: contents}
+}
+
+// Union returns a new set with the values of both sets, merging the sorted contents
+func (is *IntSet) Union(other *IntSet) *IntSet {
+	a, b := is.ToSlice(), other.ToSlice()
+	result := make([]int64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	result = append(result, b[j:]...)
+	return fromSorted(result)
+}
+
+// Intersect returns a new set with the values present in both sets
+// When one set is much smaller, its values are searched in the other one instead of merging
+func (is *IntSet) Intersect(other *IntSet) *IntSet {
+	small, large := is, other
+	if small.length > large.length {
+		small, large = large, small
+	}
+	var result []int64
+	if int(small.length)*bitsLen(large.length) < int(small.length+large.length) {
+		small.ForEach(func(value int64) bool {
+			if large.Contains(value) {
+				result = append(result, value)
+			}
+			return true
+		})
+		return fromSorted(result)
+	}
+	a, b := small.ToSlice(), large.ToSlice()
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return fromSorted(result)
+}
+
+// Diff returns a new set with the values of the set which are not in other
+func (is *IntSet) Diff(other *IntSet) *IntSet {
+	a, b := is.ToSlice(), other.ToSlice()
+	result := make([]int64, 0, len(a))
+	j := 0
+	for _, value := range a {
+		for j < len(b) && b[j] < value {
+			j++
+		}
+		if j < len(b) && b[j] == value {
+			continue
+		}
+		result = append(result, value)
+	}
+	return fromSorted(result)
+}
+
 // Helper Methods
 
+// encodingFor returns the smallest encoding able to store the value
+func encodingFor(value int64) uint32 {
+	if value < math.MinInt32 || value > math.MaxInt32 {
+		return INTSET_ENC_INT64
+	}
+	if value < math.MinInt16 || value > math.MaxInt16 {
+		return INTSET_ENC_INT32
+	}
+	return INTSET_ENC_INT16
+}
+
+// bitsLen returns the number of steps of a binary search among n values
+func bitsLen(n uint32) int {
+	steps := 1
+	for n > 1 {
+		n >>= 1
+		steps++
+	}
+	return steps
+}
+
+// fromSorted creates a set from sorted distinct values, using the smallest encoding for them
+func fromSorted(values []int64) *IntSet {
+	is := NewIntSet()
+	if len(values) == 0 {
+		return is
+	}
+	// the extreme values need the largest encoding
+	is.encoding = max(encodingFor(values[0]), encodingFor(values[len(values)-1]))
+	is.length = uint32(len(values))
+	is.contents = make([]byte, len(values)*int(is.encoding))
+	for i, value := range values {
+		offset := i * int(is.encoding)
+		switch is.encoding {
+		case INTSET_ENC_INT16:
+			binary.LittleEndian.PutUint16(is.contents[offset:], uint16(value))
+		case INTSET_ENC_INT32:
+			binary.LittleEndian.PutUint32(is.contents[offset:], uint32(value))
+		case INTSET_ENC_INT64:
+			binary.LittleEndian.PutUint64(is.contents[offset:], uint64(value))
+		}
+	}
+	return is
+}
+
 // upgradeEncoding upgrades the encoding of the IntSet if necessary
 func (is *IntSet) upgradeEncoding(newEncoding uint32) {
 	if newEncoding <= is.encoding {
@@ -213,3 +328,22 @@ func (is *IntSet) ForEach(consumer func(value int64) bool) {
 		}
 	}
 }
+
+// Verify checks the invariants of the intset: a valid encoding, contents holding exactly length
+// values and values in strictly increasing order
+func (is *IntSet) Verify() error {
+	switch is.encoding {
+	case INTSET_ENC_INT16, INTSET_ENC_INT32, INTSET_ENC_INT64:
+	default:
+		return fmt.Errorf("intset: invalid encoding %d", is.encoding)
+	}
+	if len(is.contents) != int(is.length*is.encoding) {
+		return fmt.Errorf("intset: %d bytes for %d values of %d bytes", len(is.contents), is.length, is.encoding)
+	}
+	for i := uint32(1); i < is.length; i++ {
+		if is.getValueAt(i-1) >= is.getValueAt(i) {
+			return fmt.Errorf("intset: values not in increasing order at index %d", i)
+		}
+	}
+	return nil
+}
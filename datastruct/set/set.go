@@ -11,4 +11,5 @@ type Set interface {
 	RandomMembers(count int) []string          // Get random members from the set
 	RandomDistinctMembers(count int) []string  // Get distinct random members
 	IsIntSet() bool                            // Check if the set is an IntSet
+	ObjectEncoding() string                    // Get the name of the encoding of the set
 }
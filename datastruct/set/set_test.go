@@ -1,7 +1,11 @@
 package set
 
 import (
+	"math"
+	"math/rand"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -13,7 +17,7 @@ func TestNewHashSet(t *testing.T) {
 		t.Fatal("Failed to create a new hash set")
 	}
 
-	if !set.isIntset {
+	if set.encoding != encodingIntSet {
 		t.Errorf("New hash set should use intset encoding by default")
 	}
 
@@ -76,21 +80,21 @@ func TestRemove(t *testing.T) {
 	}
 }
 
-// TestEncoding tests the encoding conversion from intset to hashtable
+// TestEncoding tests the encoding conversion from intset to listpack
 func TestEncoding(t *testing.T) {
 	set := NewHashSet()
 
 	// Initial encoding should be intset
-	if !set.isIntset {
+	if set.encoding != encodingIntSet {
 		t.Error("Initial encoding should be intset")
 	}
 
 	// Add string member to trigger conversion
 	set.Add("abc")
 
-	// Encoding should now be hashtable
-	if set.isIntset {
-		t.Error("Encoding should be hashtable after adding non-integer")
+	// Encoding should now be listpack
+	if set.encoding != encodingListpack {
+		t.Error("Encoding should be listpack after adding non-integer")
 	}
 
 	// Verify data integrity after conversion
@@ -117,7 +121,7 @@ func TestLargeNumberOfEntries(t *testing.T) {
 	}
 
 	// Encoding should now be hashtable
-	if set.isIntset {
+	if set.encoding != encodingHashTable {
 		t.Error("Encoding should be hashtable after exceeding entry limit")
 	}
 
@@ -191,8 +195,8 @@ func TestMixedDataTypes(t *testing.T) {
 	// Now add a string to force conversion
 	set.Add("abc")
 
-	if set.isIntset {
-		t.Error("Set should use hashtable encoding after adding non-integer")
+	if set.encoding != encodingListpack {
+		t.Error("Set should use listpack encoding after adding non-integer")
 	}
 
 	// Check all values are preserved
@@ -283,6 +287,28 @@ func TestRandomDistinctMembers(t *testing.T) {
 	}
 }
 
+// TestRandomDistribution tests that every member can be drawn, for both encodings
+func TestRandomDistribution(t *testing.T) {
+	for _, prefix := range []string{"", "m"} {
+		set := NewHashSet()
+		for i := 0; i < 20; i++ {
+			set.Add(prefix + strconv.Itoa(i))
+		}
+		drawn := make(map[string]int)
+		for i := 0; i < 200; i++ {
+			for _, m := range set.RandomMembers(5) {
+				drawn[m]++
+			}
+			for _, m := range set.RandomDistinctMembers(5) {
+				drawn[m]++
+			}
+		}
+		if len(drawn) != 20 {
+			t.Errorf("Expected every member to be drawn (intset=%v), got %d of 20", set.IsIntSet(), len(drawn))
+		}
+	}
+}
+
 // TestEmptySet tests operations on an empty set
 func TestEmptySet(t *testing.T) {
 	set := NewHashSet()
@@ -326,7 +352,7 @@ func TestIntSetSpecificBehavior(t *testing.T) {
 	set.Add("3")
 
 	// Verify it's still using intset
-	if !set.isIntset {
+	if set.encoding != encodingIntSet {
 		t.Error("Set should still be using intset encoding")
 	}
 
@@ -340,7 +366,7 @@ func TestIntSetSpecificBehavior(t *testing.T) {
 
 	// Add a very large integer that should still work with intset
 	set.Add("9223372036854775807") // Max int64
-	if !set.isIntset {
+	if set.encoding != encodingIntSet {
 		t.Error("Set should still be using intset encoding with large integers")
 	}
 
@@ -350,13 +376,244 @@ func TestIntSetSpecificBehavior(t *testing.T) {
 
 	// Now add a non-integer to force conversion
 	set.Add("abc")
-	if set.isIntset {
-		t.Error("Set should convert to hashtable after adding non-integer")
+	if set.encoding != encodingListpack {
+		t.Error("Set should convert to listpack after adding non-integer")
 	}
 
 	// Verify all values survived the conversion
 	if !set.Contains("1") || !set.Contains("3") ||
 		!set.Contains("9223372036854775807") || !set.Contains("abc") {
-		t.Error("Some values were lost during conversion from intset to hashtable")
+		t.Error("Some values were lost during conversion from intset to listpack")
+	}
+}
+
+// TestListpackEncoding tests the conversions of the listpack encoding to a hash table, past the
+// number of members or the length of a member
+func TestListpackEncoding(t *testing.T) {
+	set := NewHashSet()
+	set.Add("1")
+	set.Add("a")
+	for i := 2; i < SET_MAX_LISTPACK_ENTRIES; i++ {
+		set.Add(strconv.Itoa(i))
+	}
+	if set.ObjectEncoding() != "listpack" || set.Len() != SET_MAX_LISTPACK_ENTRIES {
+		t.Fatalf("Expected a listpack of %d members, got %s of %d", SET_MAX_LISTPACK_ENTRIES, set.ObjectEncoding(), set.Len())
+	}
+	if set.Add("a") != 0 || set.Remove("b") != 0 || set.Remove("2") != 1 || set.Contains("2") {
+		t.Error("Unexpected add or remove in listpack encoding")
+	}
+	if err := set.Verify(); err != nil {
+		t.Error(err)
+	}
+	set.Add("2")
+	set.Add("b")
+	if set.ObjectEncoding() != "hashtable" || set.Len() != SET_MAX_LISTPACK_ENTRIES+1 || !set.Contains("a") || !set.Contains("1") {
+		t.Errorf("Expected a hashtable of %d members, got %s of %d", SET_MAX_LISTPACK_ENTRIES+1, set.ObjectEncoding(), set.Len())
+	}
+
+	long := NewHashSet()
+	long.Add("a")
+	long.Add(strings.Repeat("a", SET_MAX_LISTPACK_VALUE+1))
+	if long.ObjectEncoding() != "hashtable" || long.Len() != 2 {
+		t.Errorf("Expected a hashtable after a long member, got %s", long.ObjectEncoding())
+	}
+	direct := NewHashSet()
+	direct.Add(strings.Repeat("a", SET_MAX_LISTPACK_VALUE+1))
+	if direct.ObjectEncoding() != "hashtable" {
+		t.Errorf("Expected an intset to be converted to a hashtable by a long member, got %s", direct.ObjectEncoding())
+	}
+
+	// an intset past the listpack limit goes straight to a hash table
+	big := NewHashSet()
+	for i := 0; i < SET_MAX_LISTPACK_ENTRIES; i++ {
+		big.Add(strconv.Itoa(i))
+	}
+	big.Add("a")
+	if big.ObjectEncoding() != "hashtable" {
+		t.Errorf("Expected an intset of %d members to be converted to a hashtable, got %s", SET_MAX_LISTPACK_ENTRIES, big.ObjectEncoding())
+	}
+}
+
+// makeIntSet creates an intset with the given values
+func makeIntSet(values ...int64) *IntSet {
+	is := NewIntSet()
+	for _, v := range values {
+		is.Add(v)
+	}
+	return is
+}
+
+// referenceOp computes a set operation with maps, the result sorted
+func referenceOp(a, b []int64, keep func(inA, inB bool) bool) []int64 {
+	inA := make(map[int64]bool)
+	inB := make(map[int64]bool)
+	for _, v := range a {
+		inA[v] = true
+	}
+	for _, v := range b {
+		inB[v] = true
+	}
+	var result []int64
+	for v := range inA {
+		if keep(true, inB[v]) {
+			result = append(result, v)
+		}
+	}
+	for v := range inB {
+		if !inA[v] && keep(false, true) {
+			result = append(result, v)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+func equalValues(t *testing.T, name string, got *IntSet, want []int64) {
+	t.Helper()
+	values := got.ToSlice()
+	if len(values) != len(want) {
+		t.Fatalf("%s: expected %d values, got %d", name, len(want), len(values))
+	}
+	for i := range want {
+		if values[i] != want[i] {
+			t.Fatalf("%s: expected %d at %d, got %d", name, want[i], i, values[i])
+		}
+		if !got.Contains(want[i]) {
+			t.Fatalf("%s: Contains(%d) should be true", name, want[i])
+		}
+	}
+}
+
+// TestIntSetAlgebra tests Union, Intersect and Diff against a map based implementation
+func TestIntSetAlgebra(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ranges := []int64{100, math.MaxInt16 * 4, math.MaxInt32 * 4}
+	for round := 0; round < 50; round++ {
+		span := ranges[round%len(ranges)]
+		var a, b []int64
+		for i := 0; i < r.Intn(300); i++ {
+			a = append(a, r.Int63n(2*span)-span)
+		}
+		// make the sizes very different sometimes, to use the search based intersection
+		for i := 0; i < r.Intn(300)/(1+round%4*10); i++ {
+			b = append(b, r.Int63n(2*span)-span)
+		}
+		setA, setB := makeIntSet(a...), makeIntSet(b...)
+
+		equalValues(t, "union", setA.Union(setB), referenceOp(a, b, func(inA, inB bool) bool { return inA || inB }))
+		equalValues(t, "intersect", setA.Intersect(setB), referenceOp(a, b, func(inA, inB bool) bool { return inA && inB }))
+		equalValues(t, "intersect reversed", setB.Intersect(setA), referenceOp(a, b, func(inA, inB bool) bool { return inA && inB }))
+		equalValues(t, "diff", setA.Diff(setB), referenceOp(a, b, func(inA, inB bool) bool { return inA && !inB }))
+	}
+}
+
+// TestIntSetAlgebraEncoding tests that the results use the encoding of their values
+func TestIntSetAlgebraEncoding(t *testing.T) {
+	small := makeIntSet(1, 2, 3)
+	large := makeIntSet(3, math.MaxInt64)
+
+	union := small.Union(large)
+	if union.encoding != INTSET_ENC_INT64 {
+		t.Errorf("Union with a 64 bit value should use 64 bit encoding, got %d", union.encoding)
+	}
+	inter := small.Intersect(large)
+	if inter.encoding != INTSET_ENC_INT16 {
+		t.Errorf("Intersection of small values should use 16 bit encoding, got %d", inter.encoding)
+	}
+	equalValues(t, "intersect", inter, []int64{3})
+
+	// the result can be modified without changing the operands
+	inter.Add(math.MinInt64)
+	if !inter.Contains(math.MinInt64) || small.Contains(math.MinInt64) || large.Contains(math.MinInt64) {
+		t.Error("Adding to the result should upgrade it without changing the operands")
+	}
+
+	empty := NewIntSet()
+	equalValues(t, "union with empty", small.Union(empty), []int64{1, 2, 3})
+	equalValues(t, "diff with empty", small.Diff(empty), []int64{1, 2, 3})
+	equalValues(t, "intersect with empty", small.Intersect(empty), nil)
+}
+
+// TestNewHashSetFromIntSet tests creating a set from the result of an intset operation
+func TestNewHashSetFromIntSet(t *testing.T) {
+	var a, b []int64
+	for i := int64(0); i < SET_MAX_INTSET_ENTRIES; i++ {
+		a = append(a, i)
+		b = append(b, i+SET_MAX_INTSET_ENTRIES)
+	}
+	small := NewHashSetFromIntSet(makeIntSet(a[:10]...))
+	if !small.IsIntSet() || small.Len() != 10 {
+		t.Errorf("Expected an intset of 10 members, got intset=%v len=%d", small.IsIntSet(), small.Len())
+	}
+
+	big := NewHashSetFromIntSet(makeIntSet(a...).Union(makeIntSet(b...)))
+	if big.IsIntSet() {
+		t.Error("A set with too many members should be converted to a hash table")
+	}
+	if big.Len() != 2*SET_MAX_INTSET_ENTRIES || !big.Contains("0") || !big.Contains(strconv.Itoa(2*SET_MAX_INTSET_ENTRIES-1)) {
+		t.Error("Members were lost during the conversion")
+	}
+	if big.IntSet() != nil {
+		t.Error("IntSet should return nil for a hash table")
+	}
+}
+
+// benchSetSize is the number of members of the sets of the sampling benchmarks
+const benchSetSize = 1_000_000
+
+var benchSet *HashSet
+
+// makeBenchSet creates a hash table set of a million members, shared by the benchmarks
+func makeBenchSet(b *testing.B) *HashSet {
+	b.Helper()
+	if benchSet == nil {
+		benchSet = NewHashSet()
+		for i := 0; i < benchSetSize; i++ {
+			benchSet.Add("member:" + strconv.Itoa(i))
+		}
+	}
+	return benchSet
+}
+
+// BenchmarkRandomMembers measures sampling 10 members with replacement from a million members
+func BenchmarkRandomMembers(b *testing.B) {
+	set := makeBenchSet(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.RandomMembers(10)
+	}
+}
+
+// BenchmarkRandomDistinctMembers measures sampling 10 distinct members from a million members
+func BenchmarkRandomDistinctMembers(b *testing.B) {
+	set := makeBenchSet(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.RandomDistinctMembers(10)
+	}
+}
+
+// TestIntSetVerify tests that Verify accepts a valid intset and detects unsorted values
+func TestIntSetVerify(t *testing.T) {
+	is := NewIntSet()
+	for _, v := range []int64{5, -3, 70000, 1} {
+		is.Add(v)
+	}
+	if err := is.Verify(); err != nil {
+		t.Fatalf("Expected a valid intset, got %v", err)
+	}
+	// swap the first two values
+	first, second := is.contents[:is.encoding], is.contents[is.encoding:2*is.encoding]
+	tmp := append([]byte{}, first...)
+	copy(first, second)
+	copy(second, tmp)
+	if err := is.Verify(); err == nil {
+		t.Error("Expected Verify to detect the unsorted values")
+	}
+	is.contents = is.contents[1:]
+	if err := is.Verify(); err == nil {
+		t.Error("Expected Verify to detect the truncated contents")
 	}
 }
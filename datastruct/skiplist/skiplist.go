@@ -1,17 +1,37 @@
 package skiplist
 
 import (
+	"errors"
+	"fmt"
 	"math/rand"
 	"time"
 )
 
 const maxLevel = 16 // Maximum number of levels in the skip list
 
+// Level is a forward pointer of a node, span is the number of nodes it skips over
+// Spans make ranks computable in O(log N), like the zskiplist of Redis
+type Level struct {
+	Forward *Node
+	Span    int
+}
+
 // Node represents a node in the skip list
 type Node struct {
-	Member  string
-	Score   float64
-	Forward []*Node // Forward points at different levels
+	Member   string
+	Score    float64
+	Backward *Node   // Previous node on level 0, nil for the first node
+	Level    []Level // Forward points at different levels
+}
+
+// Next returns the next node in ascending order, nil at the end
+func (n *Node) Next() *Node {
+	return n.Level[0].Forward
+}
+
+// Prev returns the previous node in ascending order, nil at the beginning
+func (n *Node) Prev() *Node {
+	return n.Backward
 }
 
 // SkipList represents a skip list
@@ -26,7 +46,7 @@ type SkipList struct {
 // New SkipList creates a new skip list
 func NewSkipList() *SkipList {
 	header := &Node{
-		Forward: make([]*Node, maxLevel),
+		Level: make([]Level, maxLevel),
 	}
 	return &SkipList{
 		header: header,
@@ -35,6 +55,21 @@ func NewSkipList() *SkipList {
 	}
 }
 
+// Len returns the number of nodes
+func (sl *SkipList) Len() int {
+	return sl.length
+}
+
+// less reports whether the node sorts before the member with the score
+func less(n *Node, member string, score float64) bool {
+	return n.Score < score || (n.Score == score && n.Member < member)
+}
+
+// lessOrEqual reports whether the node sorts before or at the member with the score
+func lessOrEqual(n *Node, member string, score float64) bool {
+	return n.Score < score || (n.Score == score && n.Member <= member)
+}
+
 // randomLevel generates a random level for the new node
 func (sl *SkipList) randomLevel() int {
 	level := 1
@@ -46,16 +81,20 @@ func (sl *SkipList) randomLevel() int {
 }
 
 // Insert inserts a new member with the given score into the skip list
-func (sl *SkipList) Insert(member string, score float64) {
+// The member must not be in the skip list already
+func (sl *SkipList) Insert(member string, score float64) *Node {
 	update := make([]*Node, maxLevel)
+	rank := make([]int, maxLevel) // rank of update[i]
 	x := sl.header
 
 	// Find position to insert
 	for i := sl.level - 1; i >= 0; i-- {
-		for x.Forward[i] != nil &&
-			(x.Forward[i].Score < score ||
-				(x.Forward[i].Score == score && x.Forward[i].Member < member)) {
-			x = x.Forward[i]
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.Level[i].Forward != nil && less(x.Level[i].Forward, member, score) {
+			rank[i] += x.Level[i].Span
+			x = x.Level[i].Forward
 		}
 		update[i] = x
 	}
@@ -66,30 +105,71 @@ func (sl *SkipList) Insert(member string, score float64) {
 	// If new level is higher than current, update header's forward pointers
 	if level > sl.level {
 		for i := sl.level; i < level; i++ {
+			rank[i] = 0
 			update[i] = sl.header
+			update[i].Level[i].Span = sl.length
 		}
 		sl.level = level
 	}
 
 	// Create new node
 	x = &Node{
-		Member:  member,
-		Score:   score,
-		Forward: make([]*Node, level),
+		Member: member,
+		Score:  score,
+		Level:  make([]Level, level),
 	}
 
 	// Insert node at all levels
 	for i := 0; i < level; i++ {
-		x.Forward[i] = update[i].Forward[i]
-		update[i].Forward[i] = x
+		x.Level[i].Forward = update[i].Level[i].Forward
+		update[i].Level[i].Forward = x
+		// the span of update[i] is split by the new node
+		x.Level[i].Span = update[i].Level[i].Span - (rank[0] - rank[i])
+		update[i].Level[i].Span = rank[0] - rank[i] + 1
+	}
+	// the levels above the node skip over it
+	for i := level; i < sl.level; i++ {
+		update[i].Level[i].Span++
 	}
 
+	if update[0] != sl.header {
+		x.Backward = update[0]
+	}
 	// Update tail if necessary
-	if x.Forward[0] == nil {
+	if x.Level[0].Forward != nil {
+		x.Level[0].Forward.Backward = x
+	} else {
 		sl.tail = x
 	}
 
 	sl.length++
+	return x
+}
+
+// deleteNode unlinks x, update[i] is the last node before x at level i
+func (sl *SkipList) deleteNode(x *Node, update []*Node) {
+	for i := 0; i < sl.level; i++ {
+		if update[i].Level[i].Forward == x {
+			update[i].Level[i].Span += x.Level[i].Span - 1
+			update[i].Level[i].Forward = x.Level[i].Forward
+		} else {
+			update[i].Level[i].Span--
+		}
+	}
+
+	// Update tail if necessary
+	if x.Level[0].Forward != nil {
+		x.Level[0].Forward.Backward = x.Backward
+	} else {
+		sl.tail = x.Backward
+	}
+
+	// Update level if necessary
+	for sl.level > 1 && sl.header.Level[sl.level-1].Forward == nil {
+		sl.level--
+	}
+
+	sl.length--
 }
 
 // Delete removes an element from the skip list
@@ -99,82 +179,125 @@ func (sl *SkipList) Delete(member string, score float64) bool {
 
 	// Find position to delete
 	for i := sl.level - 1; i >= 0; i-- {
-		for x.Forward[i] != nil &&
-			(x.Forward[i].Score < score ||
-				(x.Forward[i].Score == score && x.Forward[i].Member < member)) {
-			x = x.Forward[i]
+		for x.Level[i].Forward != nil && less(x.Level[i].Forward, member, score) {
+			x = x.Level[i].Forward
 		}
 		update[i] = x
 	}
 
 	// Move to first node on level 0
-	x = x.Forward[0]
+	x = x.Level[0].Forward
 
 	// Make sure we found the right node
 	if x != nil && x.Score == score && x.Member == member {
-		// Remove node at all levels
-		for i := 0; i < sl.level; i++ {
-			if update[i].Forward[i] != x {
-				break
-			}
-			update[i].Forward[i] = x.Forward[i]
-		}
-
-		// Update tail if necessary
-		if x == sl.tail {
-			sl.tail = update[0]
-		}
-
-		// Update level if necessary
-		for sl.level > 1 && sl.header.Forward[sl.level-1] == nil {
-			sl.level--
-		}
-
-		sl.length--
+		sl.deleteNode(x, update)
 		return true
 	}
 
 	return false
 }
 
-// CountInRange counts elements with score between min and max
-func (sl *SkipList) CountInRange(min, max float64) int {
-	count := 0
+// UpdateScore changes the score of a member, the node is updated in place when it keeps its
+// position, otherwise it is removed and inserted again
+func (sl *SkipList) UpdateScore(member string, oldScore, newScore float64) *Node {
+	update := make([]*Node, maxLevel)
 	x := sl.header
-
-	// Find first node with score >= min
 	for i := sl.level - 1; i >= 0; i-- {
-		for x.Forward[i] != nil && x.Forward[i].Score < min {
-			x = x.Forward[i]
+		for x.Level[i].Forward != nil && less(x.Level[i].Forward, member, oldScore) {
+			x = x.Level[i].Forward
 		}
+		update[i] = x
+	}
+	x = x.Level[0].Forward
+	if x == nil || x.Score != oldScore || x.Member != member {
+		return nil
 	}
 
-	// Traverse nodes with score <= max
-	x = x.Forward[0]
-	for x != nil && x.Score <= max {
-		count++
-		x = x.Forward[0]
+	prev, next := x.Backward, x.Level[0].Forward
+	if (prev == nil || less(prev, member, newScore)) && (next == nil || !less(next, member, newScore)) {
+		x.Score = newScore
+		return x
 	}
+	sl.deleteNode(x, update)
+	return sl.Insert(member, newScore)
+}
 
-	return count
+// First returns the first node, nil if the skip list is empty
+func (sl *SkipList) First() *Node {
+	return sl.header.Level[0].Forward
 }
 
-// RangeByScore returns members with scores between min and max
-func (sl *SkipList) RangeByScore(min, max float64, offset, count int) []string {
-	result := []string{}
+// Last returns the last node, nil if the skip list is empty
+func (sl *SkipList) Last() *Node {
+	return sl.tail
+}
+
+// GetByRank returns the node at the 0-based rank, nil if out of range
+func (sl *SkipList) GetByRank(rank int) *Node {
+	if rank < 0 || rank >= sl.length {
+		return nil
+	}
+	traversed := 0
 	x := sl.header
+	// spans count from 1, the rank of the header is 0
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.Level[i].Forward != nil && traversed+x.Level[i].Span <= rank+1 {
+			traversed += x.Level[i].Span
+			x = x.Level[i].Forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
 
-	// Find first node with score >= min
+// FirstInRange returns the first node with min <= score <= max, nil if there is none
+func (sl *SkipList) FirstInRange(min, max float64) *Node {
+	x := sl.header
 	for i := sl.level - 1; i >= 0; i-- {
-		for x.Forward[i] != nil && x.Forward[i].Score < min {
-			x = x.Forward[i]
+		for x.Level[i].Forward != nil && x.Level[i].Forward.Score < min {
+			x = x.Level[i].Forward
 		}
 	}
+	x = x.Level[0].Forward
+	if x == nil || x.Score > max {
+		return nil
+	}
+	return x
+}
 
-	// Traverse nodes with score <= max
-	x = x.Forward[0]
+// LastInRange returns the last node with min <= score <= max, nil if there is none
+func (sl *SkipList) LastInRange(min, max float64) *Node {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.Level[i].Forward != nil && x.Level[i].Forward.Score <= max {
+			x = x.Level[i].Forward
+		}
+	}
+	if x == sl.header || x.Score < min {
+		return nil
+	}
+	return x
+}
+
+// CountInRange counts elements with score between min and max
+func (sl *SkipList) CountInRange(min, max float64) int {
+	first := sl.FirstInRange(min, max)
+	if first == nil {
+		return 0
+	}
+	last := sl.LastInRange(min, max)
+	return sl.GetRank(last.Member, last.Score) - sl.GetRank(first.Member, first.Score) + 1
+}
+
+// RangeByScore returns members with scores between min and max
+func (sl *SkipList) RangeByScore(min, max float64, offset, count int) []string {
+	result := []string{}
+	x := sl.FirstInRange(min, max)
 	skipped := 0
 
+	// Traverse nodes with score <= max
 	for x != nil && x.Score <= max {
 		if offset < 0 || skipped >= offset {
 			result = append(result, x.Member)
@@ -185,7 +308,7 @@ func (sl *SkipList) RangeByScore(min, max float64, offset, count int) []string {
 		} else {
 			skipped++
 		}
-		x = x.Forward[0]
+		x = x.Level[0].Forward
 	}
 
 	return result
@@ -212,39 +335,85 @@ func (sl *SkipList) RangeByRank(start, stop int) []string {
 		return result
 	}
 
-	// Traverse to start position
-	x := sl.header.Forward[0]
-	for i := 0; i < start && x != nil; i++ {
-		x = x.Forward[0]
-	}
-
 	// Collect members between start and stop
+	x := sl.GetByRank(start)
 	for i := start; i <= stop && x != nil; i++ {
 		result = append(result, x.Member)
-		x = x.Forward[0]
+		x = x.Level[0].Forward
 	}
 
 	return result
 }
 
-// GetRank returns the rank of a member
+// GetRank returns the 0-based rank of a member, -1 if it is not found
 func (sl *SkipList) GetRank(member string, score float64) int {
 	rank := 0
 	x := sl.header
 
 	for i := sl.level - 1; i >= 0; i-- {
-		for x.Forward[i] != nil &&
-			(x.Forward[i].Score < score ||
-				(x.Forward[i].Score == score && x.Forward[i].Member < member)) {
-			rank += 1 // Count nodes we're skipping
-			x = x.Forward[i]
+		for x.Level[i].Forward != nil && lessOrEqual(x.Level[i].Forward, member, score) {
+			rank += x.Level[i].Span // Count nodes we're skipping
+			x = x.Level[i].Forward
+		}
+		// x sorts before or at the member
+		if x != sl.header && x.Member == member && x.Score == score {
+			return rank - 1
 		}
-	}
-
-	x = x.Forward[0]
-	if x != nil && x.Member == member {
-		return rank
 	}
 
 	return -1 // Member not found
 }
+
+// Verify checks the invariants of the skip list: the nodes are in strictly increasing order with
+// consistent backward pointers, tail and length, every level links a subset of the level below
+// and the spans match the ranks of the nodes
+func (sl *SkipList) Verify() error {
+	if sl.level < 1 || sl.level > maxLevel {
+		return fmt.Errorf("skiplist: invalid level %d", sl.level)
+	}
+	ranks := make(map[*Node]int, sl.length)
+	ranks[sl.header] = 0
+	var prev *Node
+	for x := sl.header.Level[0].Forward; x != nil; x = x.Level[0].Forward {
+		if len(x.Level) < 1 || len(x.Level) > sl.level {
+			return fmt.Errorf("skiplist: member %q has %d levels, the list has %d", x.Member, len(x.Level), sl.level)
+		}
+		if x.Backward != prev {
+			return fmt.Errorf("skiplist: wrong backward pointer of member %q", x.Member)
+		}
+		if prev != nil && !less(prev, x.Member, x.Score) {
+			return fmt.Errorf("skiplist: member %q is not after member %q", x.Member, prev.Member)
+		}
+		if _, ok := ranks[x]; ok {
+			return fmt.Errorf("skiplist: cycle at member %q", x.Member)
+		}
+		ranks[x] = len(ranks)
+		prev = x
+	}
+	if len(ranks)-1 != sl.length {
+		return fmt.Errorf("skiplist: %d nodes, length is %d", len(ranks)-1, sl.length)
+	}
+	if sl.tail != prev {
+		return errors.New("skiplist: tail is not the last node")
+	}
+	for i := 0; i < sl.level; i++ {
+		x := sl.header
+		for x.Level[i].Forward != nil {
+			next := x.Level[i].Forward
+			rank, ok := ranks[next]
+			if !ok || len(next.Level) <= i {
+				return fmt.Errorf("skiplist: level %d links a node missing from the levels below", i+1)
+			}
+			if rank <= ranks[x] || x.Level[i].Span != rank-ranks[x] {
+				return fmt.Errorf("skiplist: wrong span %d at level %d before member %q", x.Level[i].Span, i+1, next.Member)
+			}
+			x = next
+		}
+	}
+	for i := sl.level; i < maxLevel; i++ {
+		if sl.header.Level[i].Forward != nil {
+			return fmt.Errorf("skiplist: level %d is above the level of the list", i+1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package stream
+
+import (
+	"errors"
+	"math"
+	"redigo/datastruct/listpack"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ID identifies an entry of the stream, formatted as <milliseconds>-<sequence>
+type ID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	// MinID is the smallest possible ID, which is never used by entries
+	MinID = ID{}
+	// MaxID is the largest possible ID
+	MaxID = ID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+	ErrInvalidID = errors.New("ERR Invalid stream ID specified as stream command argument")
+	// ErrIDTooSmall is returned when adding an entry whose ID is not greater than the last one
+	ErrIDTooSmall = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	// ErrZeroID is returned when adding an entry with ID 0-0
+	ErrZeroID = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+)
+
+// String formats the ID as <milliseconds>-<sequence>
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less reports whether id is smaller than other
+func (id ID) Less(other ID) bool {
+	if id.Ms != other.Ms {
+		return id.Ms < other.Ms
+	}
+	return id.Seq < other.Seq
+}
+
+// next returns the smallest ID greater than id, ok is false for MaxID
+func (id ID) next() (ID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return ID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return ID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// prev returns the largest ID smaller than id, ok is false for MinID
+func (id ID) prev() (ID, bool) {
+	if id.Seq > 0 {
+		return ID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return ID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseID parses an ID, the sequence part is optional and defaults to defaultSeq
+func ParseID(s string, defaultSeq uint64) (ID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return ID{}, ErrInvalidID
+	}
+	if !hasSeq {
+		return ID{Ms: ms, Seq: defaultSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return ID{}, ErrInvalidID
+	}
+	return ID{Ms: ms, Seq: seq}, nil
+}
+
+// ParseRangeStart parses the start of a range: "-", an ID or "(" followed by an exclusive ID
+// ok is false if the range is empty (exclusive start at MaxID)
+func ParseRangeStart(s string) (id ID, ok bool, err error) {
+	if s == "-" {
+		return MinID, true, nil
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	id, err = ParseID(strings.TrimPrefix(s, "("), 0)
+	if err != nil {
+		return ID{}, false, err
+	}
+	if exclusive {
+		id, ok = id.next()
+		return id, ok, nil
+	}
+	return id, true, nil
+}
+
+// ParseRangeEnd parses the end of a range: "+", an ID or "(" followed by an exclusive ID
+// ok is false if the range is empty (exclusive end at MinID)
+func ParseRangeEnd(s string) (id ID, ok bool, err error) {
+	if s == "+" {
+		return MaxID, true, nil
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	id, err = ParseID(strings.TrimPrefix(s, "("), math.MaxUint64)
+	if err != nil {
+		return ID{}, false, err
+	}
+	if exclusive {
+		id, ok = id.prev()
+		return id, ok, nil
+	}
+	return id, true, nil
+}
+
+// Entry is an entry of the stream
+type Entry struct {
+	ID     ID
+	fields *listpack.Listpack // field value pairs, flattened
+}
+
+// Fields returns a copy of the field value pairs of the entry, flattened
+func (e *Entry) Fields() [][]byte {
+	return e.fields.Values()
+}
+
+// Stream is an append-only log of entries ordered by ID
+type Stream struct {
+	entries []*Entry
+	lastID  ID // the ID of the last added entry, kept even if the entry has been trimmed
+}
+
+// MakeStream creates an empty stream
+func MakeStream() *Stream {
+	return &Stream{}
+}
+
+// Len returns the number of entries
+func (s *Stream) Len() int {
+	return len(s.entries)
+}
+
+// LastID returns the ID of the last added entry
+func (s *Stream) LastID() ID {
+	return s.lastID
+}
+
+// SetLastID sets the last ID, used to restore a stream whose last entries have been trimmed
+// It is ignored if id is smaller than the ID of the last entry
+func (s *Stream) SetLastID(id ID) {
+	if s.lastID.Less(id) {
+		s.lastID = id
+	}
+}
+
+// NextID generates an ID greater than the last one, based on the given time in milliseconds
+func (s *Stream) NextID(nowMs uint64) (ID, error) {
+	if nowMs > s.lastID.Ms {
+		return ID{Ms: nowMs}, nil
+	}
+	return s.NextIDWithMs(s.lastID.Ms)
+}
+
+// NextIDWithMs generates an ID with the given milliseconds part, for IDs like 1526919030474-*
+func (s *Stream) NextIDWithMs(ms uint64) (ID, error) {
+	if ms < s.lastID.Ms {
+		return ID{}, ErrIDTooSmall
+	}
+	if ms > s.lastID.Ms {
+		return ID{Ms: ms}, nil
+	}
+	if s.lastID.Seq == math.MaxUint64 {
+		return ID{}, ErrIDTooSmall
+	}
+	return ID{Ms: ms, Seq: s.lastID.Seq + 1}, nil
+}
+
+// Add appends an entry, the ID must be greater than the last ID
+// The fields are copied into the entry
+func (s *Stream) Add(id ID, fields [][]byte) error {
+	if !s.lastID.Less(id) {
+		return ErrIDTooSmall
+	}
+	lp := listpack.New()
+	lp.Append(fields...)
+	s.entries = append(s.entries, &Entry{ID: id, fields: lp})
+	s.lastID = id
+	return nil
+}
+
+// search returns the index of the first entry whose ID is not smaller than id
+func (s *Stream) search(id ID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].ID.Less(id)
+	})
+}
+
+// Range returns the entries with start <= ID <= end, at most count entries if count > 0
+func (s *Stream) Range(start, end ID, count int) []*Entry {
+	var result []*Entry
+	for i := s.search(start); i < len(s.entries); i++ {
+		if end.Less(s.entries[i].ID) || (count > 0 && len(result) >= count) {
+			break
+		}
+		result = append(result, s.entries[i])
+	}
+	return result
+}
+
+// RevRange returns the entries with start <= ID <= end in reverse order, at most count entries if count > 0
+func (s *Stream) RevRange(start, end ID, count int) []*Entry {
+	var result []*Entry
+	// index of the first entry greater than end
+	i := sort.Search(len(s.entries), func(i int) bool {
+		return end.Less(s.entries[i].ID)
+	})
+	for i--; i >= 0; i-- {
+		if s.entries[i].ID.Less(start) || (count > 0 && len(result) >= count) {
+			break
+		}
+		result = append(result, s.entries[i])
+	}
+	return result
+}
+
+// After returns the entries whose ID is greater than id, at most count entries if count > 0
+func (s *Stream) After(id ID, count int) []*Entry {
+	start, ok := id.next()
+	if !ok {
+		return nil
+	}
+	return s.Range(start, MaxID, count)
+}
+
+// Trim removes the oldest entries so that at most maxLen entries are kept
+// Returns the number of removed entries
+func (s *Stream) Trim(maxLen int) int {
+	if maxLen < 0 || len(s.entries) <= maxLen {
+		return 0
+	}
+	removed := len(s.entries) - maxLen
+	// copy the remaining entries so the trimmed ones can be collected
+	remaining := make([]*Entry, maxLen)
+	copy(remaining, s.entries[removed:])
+	s.entries = remaining
+	return removed
+}
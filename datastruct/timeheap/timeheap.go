@@ -0,0 +1,131 @@
+// Package timeheap indexes keys by a point in time with a min-heap, the earliest first
+package timeheap
+
+import (
+	"container/heap"
+	"math/rand"
+	"time"
+)
+
+type item struct {
+	key   string
+	at    time.Time
+	index int // index in the heap
+}
+
+// items implements heap.Interface, ordered by time
+type items []*item
+
+func (h items) Len() int           { return len(h) }
+func (h items) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h items) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *items) Push(x any) {
+	it := x.(*item)
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+func (h *items) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return it
+}
+
+// Heap maps keys to times, and finds the keys whose time is due in O(log N) per key
+// It is not safe for concurrent use
+type Heap struct {
+	items items
+	index map[string]*item
+}
+
+// Make creates an empty heap
+func Make() *Heap {
+	return &Heap{index: make(map[string]*item)}
+}
+
+// Len returns the number of keys
+func (h *Heap) Len() int {
+	return len(h.items)
+}
+
+// Get returns the time of the key
+func (h *Heap) Get(key string) (time.Time, bool) {
+	it, ok := h.index[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return it.at, true
+}
+
+// Set sets the time of the key, adding the key if needed
+func (h *Heap) Set(key string, at time.Time) {
+	if it, ok := h.index[key]; ok {
+		it.at = at
+		heap.Fix(&h.items, it.index)
+		return
+	}
+	it := &item{key: key, at: at}
+	heap.Push(&h.items, it)
+	h.index[key] = it
+}
+
+// Remove removes the key, returns false if it was absent
+func (h *Heap) Remove(key string) bool {
+	it, ok := h.index[key]
+	if !ok {
+		return false
+	}
+	heap.Remove(&h.items, it.index)
+	delete(h.index, key)
+	return true
+}
+
+// Min returns the key with the earliest time
+func (h *Heap) Min() (string, time.Time, bool) {
+	if len(h.items) == 0 {
+		return "", time.Time{}, false
+	}
+	return h.items[0].key, h.items[0].at, true
+}
+
+// Due returns up to limit keys whose time is not after now, without removing them
+// Only the due part of the heap is visited, so the cost depends on the number of due keys
+// rather than on the size of the heap
+func (h *Heap) Due(now time.Time, limit int) []string {
+	var due []string
+	// depth first walk of the heap, a node which is not due hides its whole subtree
+	stack := []int{0}
+	for len(stack) > 0 && len(due) < limit {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(h.items) || h.items[i].at.After(now) {
+			continue
+		}
+		due = append(due, h.items[i].key)
+		stack = append(stack, 2*i+2, 2*i+1)
+	}
+	return due
+}
+
+// RandomKeys returns n random keys, a key may be returned more than once
+func (h *Heap) RandomKeys(n int) []string {
+	if len(h.items) == 0 {
+		return nil
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = h.items[rand.Intn(len(h.items))].key
+	}
+	return keys
+}
+
+// Clear removes all keys
+func (h *Heap) Clear() {
+	h.items = nil
+	h.index = make(map[string]*item)
+}
@@ -0,0 +1,174 @@
+package timeheap
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestSetAndGet tests adding, updating and reading keys
+func TestSetAndGet(t *testing.T) {
+	h := Make()
+	base := time.UnixMilli(1_000_000)
+	h.Set("a", base.Add(3*time.Second))
+	h.Set("b", base.Add(1*time.Second))
+	h.Set("c", base.Add(2*time.Second))
+
+	if h.Len() != 3 {
+		t.Fatalf("Expected 3 keys, got %d", h.Len())
+	}
+	if key, _, _ := h.Min(); key != "b" {
+		t.Errorf("Expected b to be the earliest, got %s", key)
+	}
+
+	h.Set("b", base.Add(5*time.Second))
+	if key, _, _ := h.Min(); key != "c" {
+		t.Errorf("Expected c to be the earliest after updating b, got %s", key)
+	}
+	if at, ok := h.Get("b"); !ok || !at.Equal(base.Add(5*time.Second)) {
+		t.Errorf("Expected the updated time of b, got %v %v", at, ok)
+	}
+	if h.Len() != 3 {
+		t.Errorf("Updating a key should not add it again, got %d keys", h.Len())
+	}
+}
+
+// TestRemove tests removing keys from any position of the heap
+func TestRemove(t *testing.T) {
+	h := Make()
+	base := time.UnixMilli(1_000_000)
+	for i := 0; i < 100; i++ {
+		h.Set(strconv.Itoa(i), base.Add(time.Duration(i)*time.Second))
+	}
+	for i := 0; i < 100; i += 2 {
+		if !h.Remove(strconv.Itoa(i)) {
+			t.Fatalf("Failed to remove key %d", i)
+		}
+	}
+	if h.Remove("0") {
+		t.Error("Removing an absent key should return false")
+	}
+	if h.Len() != 50 {
+		t.Fatalf("Expected 50 keys, got %d", h.Len())
+	}
+	if key, _, _ := h.Min(); key != "1" {
+		t.Errorf("Expected 1 to be the earliest, got %s", key)
+	}
+	if _, ok := h.Get("2"); ok {
+		t.Error("Removed key should not be found")
+	}
+}
+
+// TestDue tests finding the due keys without removing them
+func TestDue(t *testing.T) {
+	h := Make()
+	base := time.UnixMilli(1_000_000)
+	// insert in reverse order so that due keys are spread in the heap
+	for i := 99; i >= 0; i-- {
+		h.Set(strconv.Itoa(i), base.Add(time.Duration(i)*time.Second))
+	}
+
+	due := h.Due(base.Add(9*time.Second), 100)
+	sort.Slice(due, func(i, j int) bool {
+		a, _ := strconv.Atoi(due[i])
+		b, _ := strconv.Atoi(due[j])
+		return a < b
+	})
+	if len(due) != 10 {
+		t.Fatalf("Expected 10 due keys, got %d: %v", len(due), due)
+	}
+	for i, key := range due {
+		if key != strconv.Itoa(i) {
+			t.Errorf("Expected due key %d, got %s", i, key)
+		}
+	}
+	if got := len(h.Due(base.Add(9*time.Second), 4)); got != 4 {
+		t.Errorf("Expected the limit to be respected, got %d keys", got)
+	}
+	if got := len(h.Due(base.Add(-time.Second), 100)); got != 0 {
+		t.Errorf("Expected no due key, got %d", got)
+	}
+	if h.Len() != 100 {
+		t.Errorf("Due should not remove keys, got %d keys", h.Len())
+	}
+
+	h.Clear()
+	if h.Len() != 0 || len(h.Due(base.Add(time.Hour), 10)) != 0 {
+		t.Error("Expected an empty heap after Clear")
+	}
+}
+
+// volatileKeys is the number of keys of the benchmarks
+const volatileKeys = 1_000_000
+
+func makeBenchHeap(b *testing.B) (*Heap, time.Time) {
+	b.Helper()
+	h := Make()
+	base := time.UnixMilli(1_000_000)
+	for i := 0; i < volatileKeys; i++ {
+		// spread the times over an hour, in an order unrelated to the insertion order
+		h.Set("key:"+strconv.Itoa(i), base.Add(time.Duration(i*7919%3600_000)*time.Millisecond))
+	}
+	return h, base
+}
+
+// BenchmarkSet measures updating the time of keys in a heap of a million keys
+func BenchmarkSet(b *testing.B) {
+	h, base := makeBenchHeap(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.Set("key:"+strconv.Itoa(i%volatileKeys), base.Add(time.Duration(i%3600_000)*time.Millisecond))
+	}
+}
+
+func TestRandomKeys(t *testing.T) {
+	h := Make()
+	if keys := h.RandomKeys(5); len(keys) != 0 {
+		t.Errorf("Expected no key from an empty heap, got %v", keys)
+	}
+	base := time.UnixMilli(1_000_000)
+	for i := 0; i < 10; i++ {
+		h.Set(strconv.Itoa(i), base.Add(time.Duration(i)*time.Second))
+	}
+	keys := h.RandomKeys(5)
+	if len(keys) != 5 {
+		t.Fatalf("Expected 5 keys, got %d", len(keys))
+	}
+	for _, key := range keys {
+		if _, ok := h.Get(key); !ok {
+			t.Errorf("Random key %s is not in the heap", key)
+		}
+	}
+}
+
+// BenchmarkDueAndRemove measures an active expire pass removing 20 due keys from a heap of a
+// million keys
+func BenchmarkDueAndRemove(b *testing.B) {
+	h, base := makeBenchHeap(b)
+	now := base.Add(time.Hour)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		due := h.Due(now, 20)
+		if len(due) == 0 {
+			b.StopTimer()
+			h, _ = makeBenchHeap(b)
+			b.StartTimer()
+			continue
+		}
+		for _, key := range due {
+			h.Remove(key)
+		}
+	}
+}
+
+// BenchmarkDueNone measures an active expire pass when no key is due
+func BenchmarkDueNone(b *testing.B) {
+	h, base := makeBenchHeap(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(h.Due(base.Add(-time.Second), 20)) != 0 {
+			b.Fatal("Expected no due key")
+		}
+	}
+}
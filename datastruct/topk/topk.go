@@ -0,0 +1,286 @@
+// Package topk tracks the most frequent items of a stream with the HeavyKeeper algorithm
+package topk
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	DefaultWidth = 8
+	DefaultDepth = 7
+	DefaultDecay = 0.9
+	// seed makes the decay decisions reproducible, so that replaying the AOF rebuilds the same sketch
+	seed = 0x5eed
+)
+
+type bucket struct {
+	fingerprint uint32
+	count       uint32
+}
+
+// Item is a tracked item with its estimated count
+type Item struct {
+	Item  string
+	Count uint32
+	index int // index in the heap
+}
+
+// minHeap holds the top k items, the least frequent at the root
+type minHeap []*Item
+
+func (h minHeap) Len() int { return len(h) }
+func (h minHeap) Less(i, j int) bool {
+	if h[i].Count != h[j].Count {
+		return h[i].Count < h[j].Count
+	}
+	return h[i].Item > h[j].Item
+}
+func (h minHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *minHeap) Push(x any) {
+	item := x.(*Item)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *minHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// TopK is a HeavyKeeper sketch with a heap of the k heaviest items
+type TopK struct {
+	k       int
+	width   int
+	depth   int
+	decay   float64
+	buckets [][]bucket
+	heap    minHeap
+	items   map[string]*Item
+	rand    *rand.Rand
+}
+
+// Make creates a sketch tracking the k most frequent items
+func Make(k, width, depth int, decay float64) *TopK {
+	buckets := make([][]bucket, depth)
+	for i := range buckets {
+		buckets[i] = make([]bucket, width)
+	}
+	return &TopK{
+		k:       k,
+		width:   width,
+		depth:   depth,
+		decay:   decay,
+		buckets: buckets,
+		items:   make(map[string]*Item, k),
+		rand:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+// K returns the number of tracked items
+func (t *TopK) K() int {
+	return t.k
+}
+
+// Width returns the number of buckets of each row
+func (t *TopK) Width() int {
+	return t.width
+}
+
+// Depth returns the number of rows
+func (t *TopK) Depth() int {
+	return t.depth
+}
+
+// Decay returns the probability base of decreasing a bucket owned by another item
+func (t *TopK) Decay() float64 {
+	return t.decay
+}
+
+func hashItem(item string) (uint32, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(item))
+	sum := h.Sum64()
+	return uint32(sum >> 32), sum
+}
+
+// bucketIndex returns the bucket of the item in the row, using double hashing
+func (t *TopK) bucketIndex(sum uint64, row int) int {
+	h1 := sum & math.MaxUint32
+	h2 := sum>>32 | 1
+	return int((h1 + uint64(row)*h2) % uint64(t.width))
+}
+
+// IncrBy increases the count of the item, returns the item expelled from the top k, if any
+func (t *TopK) IncrBy(item string, increment uint32) (expelled string, ok bool) {
+	fp, sum := hashItem(item)
+	var maxCount uint32
+	for row := 0; row < t.depth; row++ {
+		b := &t.buckets[row][t.bucketIndex(sum, row)]
+		switch {
+		case b.count == 0:
+			b.fingerprint = fp
+			b.count = increment
+		case b.fingerprint == fp:
+			b.count = saturatingAdd(b.count, increment)
+		default:
+			// another item owns the bucket, decay it with probability decay^count
+			remaining := increment
+			for remaining > 0 {
+				if t.rand.Float64() < math.Pow(t.decay, float64(b.count)) {
+					b.count--
+					if b.count == 0 {
+						b.fingerprint = fp
+						b.count = remaining
+						remaining = 0
+						break
+					}
+				}
+				remaining--
+			}
+		}
+		if b.fingerprint == fp && b.count > maxCount {
+			maxCount = b.count
+		}
+	}
+	return t.update(item, maxCount)
+}
+
+func saturatingAdd(a, b uint32) uint32 {
+	if a > math.MaxUint32-b {
+		return math.MaxUint32
+	}
+	return a + b
+}
+
+// update updates the heap with the estimated count of the item
+func (t *TopK) update(item string, count uint32) (string, bool) {
+	if tracked, ok := t.items[item]; ok {
+		if count > tracked.Count {
+			tracked.Count = count
+			heap.Fix(&t.heap, tracked.index)
+		}
+		return "", false
+	}
+	if count == 0 {
+		return "", false
+	}
+	if len(t.heap) < t.k {
+		entry := &Item{Item: item, Count: count}
+		heap.Push(&t.heap, entry)
+		t.items[item] = entry
+		return "", false
+	}
+	if count <= t.heap[0].Count {
+		return "", false
+	}
+	min := t.heap[0]
+	delete(t.items, min.Item)
+	entry := &Item{Item: item, Count: count, index: 0}
+	t.heap[0] = entry
+	heap.Fix(&t.heap, 0)
+	t.items[item] = entry
+	return min.Item, true
+}
+
+// Query reports whether the item is in the top k
+func (t *TopK) Query(item string) bool {
+	_, ok := t.items[item]
+	return ok
+}
+
+// List returns the top k items, the most frequent first
+func (t *TopK) List() []Item {
+	result := make([]Item, len(t.heap))
+	for i, item := range t.heap {
+		result[i] = *item
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Item < result[j].Item
+	})
+	return result
+}
+
+// ErrCorrupted is returned when unmarshalling invalid data
+var ErrCorrupted = errors.New("topk: corrupted data")
+
+// MarshalBinary serializes the sketch
+// The state of the random generator is not saved, a restored sketch starts again from the seed
+func (t *TopK) MarshalBinary() ([]byte, error) {
+	buf := binary.AppendUvarint(nil, uint64(t.k))
+	buf = binary.AppendUvarint(buf, uint64(t.width))
+	buf = binary.AppendUvarint(buf, uint64(t.depth))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(t.decay))
+	for _, row := range t.buckets {
+		for _, b := range row {
+			buf = binary.LittleEndian.AppendUint32(buf, b.fingerprint)
+			buf = binary.LittleEndian.AppendUint32(buf, b.count)
+		}
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(t.heap)))
+	for _, item := range t.heap {
+		buf = binary.AppendUvarint(buf, uint64(len(item.Item)))
+		buf = append(buf, item.Item...)
+		buf = binary.LittleEndian.AppendUint32(buf, item.Count)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary restores a sketch serialized by MarshalBinary
+func (t *TopK) UnmarshalBinary(data []byte) error {
+	uvarint := func() int {
+		v, n := binary.Uvarint(data)
+		if n <= 0 || v > math.MaxInt32 {
+			data = nil
+			return -1
+		}
+		data = data[n:]
+		return int(v)
+	}
+	k, width, depth := uvarint(), uvarint(), uvarint()
+	if k <= 0 || width <= 0 || depth <= 0 || len(data) < 8 || uint64(width)*uint64(depth) > uint64(len(data)-8)/8 {
+		return ErrCorrupted
+	}
+	decay := math.Float64frombits(binary.LittleEndian.Uint64(data))
+	data = data[8:]
+	*t = *Make(k, width, depth, decay)
+	for _, row := range t.buckets {
+		for j := range row {
+			row[j].fingerprint = binary.LittleEndian.Uint32(data)
+			row[j].count = binary.LittleEndian.Uint32(data[4:])
+			data = data[8:]
+		}
+	}
+	n := uvarint()
+	if n < 0 || n > k {
+		return ErrCorrupted
+	}
+	for i := 0; i < n; i++ {
+		length := uvarint()
+		if length < 0 || len(data) < length+4 {
+			return ErrCorrupted
+		}
+		item := &Item{Item: string(data[:length]), Count: binary.LittleEndian.Uint32(data[length:])}
+		data = data[length+4:]
+		heap.Push(&t.heap, item)
+		t.items[item.Item] = item
+	}
+	if len(data) != 0 {
+		return ErrCorrupted
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package zset
 
 import (
 	"fmt"
+	"math"
+	"redigo/datastruct/listpack"
 	"redigo/datastruct/skiplist"
 	"sort"
 	"strconv"
@@ -25,15 +27,22 @@ type ZSet interface {
 	Len() int
 	RangeByScore(min, max float64, offset, count int) []string
 	RangeByRank(start, stop int) []string
+	Rank(member string, desc bool) (int, bool)
+	ForEachByRank(start, stop int, desc bool, consumer Consumer)
+	ForEachByScore(min, max float64, desc bool, consumer Consumer)
 	RemoveRangeByRank(start, stop int) int
 	RemoveRangeByScore(min, max float64) int
 	Encoding() int
+	ObjectEncoding() string
 	GetSkiplist() *skiplist.SkipList
 }
 
+// Consumer is called for each member visited by an iteration, it returns false to stop
+type Consumer func(member string, score float64) bool
+
 type zset struct {
 	encoding int
-	listpack [][2]string
+	listpack *listpack.Listpack // members and scores stored alternately
 	dict     map[string]float64
 	skiplist *skiplist.SkipList
 }
@@ -42,7 +51,7 @@ type zset struct {
 func NewZSet() ZSet {
 	return &zset{
 		encoding: encodingListpack,
-		listpack: make([][2]string, 0),
+		listpack: listpack.New(),
 	}
 }
 
@@ -52,19 +61,17 @@ func (z *zset) Add(member string, score float64) bool {
 	// Check if we're using listpack encoding
 	if z.encoding == encodingListpack {
 		// Check if member already exists in listpack
-		for i, pair := range z.listpack {
-			if pair[0] == member {
-				// Update score if member already exists
-				z.listpack[i][1] = formatScore(score)
-				return false
-			}
+		if index := z.listpack.Find([]byte(member), 2); index >= 0 {
+			// Update score if member already exists
+			z.listpack.Replace(index+1, []byte(formatScore(score)))
+			return false
 		}
 
 		// Add new member to listpack
-		z.listpack = append(z.listpack, [2]string{member, formatScore(score)})
+		z.listpack.Append([]byte(member), []byte(formatScore(score)))
 
 		// Convert to skiplist encoding if listpack grows too large
-		if len(z.listpack) > listpackMaxSize {
+		if z.Len() > listpackMaxSize {
 			z.convertToSkiplist()
 		}
 		return true
@@ -75,11 +82,8 @@ func (z *zset) Add(member string, score float64) bool {
 	if exists {
 		// If score changed, update both dict and skiplist
 		if existingScore != score {
-			// Remove from skiplist with old score
-			z.skiplist.Delete(member, existingScore)
-			// Insert with new score
-			z.skiplist.Insert(member, score)
-			// Update score in dict
+			// The node is moved only if the new score changes its position
+			z.skiplist.UpdateScore(member, existingScore, score)
 			z.dict[member] = score
 		}
 		return false
@@ -92,8 +96,13 @@ func (z *zset) Add(member string, score float64) bool {
 }
 
 // Helper function to format score as string
+// The shortest representation is used so that the score is not rounded,
+// integers are stored compactly by the listpack
 func formatScore(score float64) string {
-	return fmt.Sprintf("%f", score)
+	if score == math.Trunc(score) && math.Abs(score) < 1e18 {
+		return strconv.FormatInt(int64(score), 10)
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
 }
 
 // Helper function to parse score string to float64
@@ -109,14 +118,12 @@ func (z *zset) convertToSkiplist() {
 
 	// Initialize skiplist and dict
 	z.skiplist = skiplist.NewSkipList()
-	z.dict = make(map[string]float64, len(z.listpack))
+	z.dict = make(map[string]float64, z.Len())
 
 	// Transfer all elements from listpack to skiplist and dict
-	for _, pair := range z.listpack {
-		member := pair[0]
-		score, _ := parseScore(pair[1])
-		z.dict[member] = score
-		z.skiplist.Insert(member, score)
+	for _, pair := range z.listpackPairs() {
+		z.dict[pair.member] = pair.score
+		z.skiplist.Insert(pair.member, pair.score)
 	}
 
 	// Update encoding and clear listpack
@@ -127,16 +134,16 @@ func (z *zset) convertToSkiplist() {
 // Score returns the score of a member, and a boolean indicating if the member exists
 func (z *zset) Score(member string) (float64, bool) {
 	if z.encoding == encodingListpack {
-		for _, pair := range z.listpack {
-			if pair[0] == member {
-				score, err := parseScore(pair[1])
-				if err != nil {
-					return 0, false
-				}
-				return score, true
-			}
+		index := z.listpack.Find([]byte(member), 2)
+		if index < 0 {
+			return 0, false
+		}
+		scoreBytes, _ := z.listpack.Get(index + 1)
+		score, err := parseScore(string(scoreBytes))
+		if err != nil {
+			return 0, false
 		}
-		return 0, false
+		return score, true
 	}
 
 	// Using skiplist encoding
@@ -147,12 +154,7 @@ func (z *zset) Score(member string) (float64, bool) {
 // Exists checks if a member exists in the sorted set
 func (z *zset) Exists(member string) bool {
 	if z.encoding == encodingListpack {
-		for _, pair := range z.listpack {
-			if pair[0] == member {
-				return true
-			}
-		}
-		return false
+		return z.listpack.Find([]byte(member), 2) >= 0
 	}
 
 	// Using skiplist encoding
@@ -164,9 +166,8 @@ func (z *zset) Exists(member string) bool {
 func (z *zset) Count(min, max float64) int {
 	if z.encoding == encodingListpack {
 		count := 0
-		for _, pair := range z.listpack {
-			score, _ := parseScore(pair[1])
-			if score >= min && score <= max {
+		for _, pair := range z.listpackPairs() {
+			if pair.score >= min && pair.score <= max {
 				count++
 			}
 		}
@@ -180,7 +181,7 @@ func (z *zset) Count(min, max float64) int {
 // Len returns the number of elements in the sorted set
 func (z *zset) Len() int {
 	if z.encoding == encodingListpack {
-		return len(z.listpack)
+		return z.listpack.Len() / 2
 	}
 	return len(z.dict)
 }
@@ -188,104 +189,168 @@ func (z *zset) Len() int {
 // RangeByScore returns members with scores between min and max
 // Limit: if offset >=0 and count > 0, return at most count members starting from offset
 func (z *zset) RangeByScore(min, max float64, offset, count int) []string {
-	if z.encoding == encodingListpack {
-		// Get matching elements from listpack
-		var matches [][2]string
-		for _, pair := range z.listpack {
-			score, _ := parseScore(pair[1])
-			if score >= min && score <= max {
-				matches = append(matches, pair)
-			}
+	result := []string{}
+	skipped := 0
+	z.ForEachByScore(min, max, false, func(member string, score float64) bool {
+		if offset > 0 && skipped < offset {
+			skipped++
+			return true
 		}
+		result = append(result, member)
+		// Stop if we've collected enough elements
+		return count <= 0 || len(result) < count
+	})
+	return result
+}
 
-		// Sort matches by score
-		sort.Slice(matches, func(i, j int) bool {
-			scoreI, _ := parseScore(matches[i][1])
-			scoreJ, _ := parseScore(matches[j][1])
-			return scoreI < scoreJ
-		})
+// RangeByRank returns members ordered by rank (position)
+// Returns members between start and stop ranks (inclusive, 0-based)
+func (z *zset) RangeByRank(start, stop int) []string {
+	result := []string{}
+	z.ForEachByRank(start, stop, false, func(member string, score float64) bool {
+		result = append(result, member)
+		return true
+	})
+	return result
+}
 
-		// Apply offset and count if specified
-		if offset >= 0 && count > 0 {
-			end := offset + count
-			if end > len(matches) {
-				end = len(matches)
-			}
-			if offset < len(matches) {
-				matches = matches[offset:end]
-			} else {
-				matches = nil
+// Rank returns the 0-based rank of a member, ordered by ascending scores or descending if desc
+func (z *zset) Rank(member string, desc bool) (int, bool) {
+	var rank int
+	if z.encoding == encodingListpack {
+		score, ok := z.Score(member)
+		if !ok {
+			return 0, false
+		}
+		// count the members sorting before the member
+		for _, pair := range z.listpackPairs() {
+			if pair.score < score || (pair.score == score && pair.member < member) {
+				rank++
 			}
 		}
-
-		// Extract member names
-		result := make([]string, len(matches))
-		for i, pair := range matches {
-			result[i] = pair[0]
+	} else {
+		score, ok := z.dict[member]
+		if !ok {
+			return 0, false
 		}
-		return result
+		rank = z.skiplist.GetRank(member, score)
+	}
+	if desc {
+		rank = z.Len() - 1 - rank
 	}
+	return rank, true
+}
 
-	// Using skiplist encoding
-	return z.skiplist.RangeByScore(min, max, offset, count)
+// normalizeRanks converts negative ranks to positive ones and clamps them to the size, returns
+// false if the range is empty
+func normalizeRanks(start, stop, size int) (int, int, bool) {
+	if start < 0 {
+		start = size + start
+	}
+	if stop < 0 {
+		stop = size + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start > stop || start >= size {
+		return 0, 0, false
+	}
+	return start, stop, true
 }
 
-// RangeByRank returns members ordered by rank (position)
-// Returns members between start and stop ranks (inclusive, 0-based)
-func (z *zset) RangeByRank(start, stop int) []string {
-	if z.encoding == encodingListpack {
-		// Copy and sort listpack elements by score
-		pairs := make([][2]string, len(z.listpack))
-		copy(pairs, z.listpack)
-
-		sort.Slice(pairs, func(i, j int) bool {
-			scoreI, _ := parseScore(pairs[i][1])
-			scoreJ, _ := parseScore(pairs[j][1])
-			return scoreI < scoreJ
-		})
+// ForEachByRank visits the members between start and stop ranks (inclusive, 0-based, negative
+// ranks count from the end), in ascending order or descending order if desc
+// With desc, rank 0 is the member with the highest score like ZREVRANGE
+func (z *zset) ForEachByRank(start, stop int, desc bool, consumer Consumer) {
+	start, stop, ok := normalizeRanks(start, stop, z.Len())
+	if !ok {
+		return
+	}
 
-		// Handle negative indices and out of range
-		size := len(pairs)
-		if start < 0 {
-			start = size + start
-		}
-		if stop < 0 {
-			stop = size + stop
-		}
-		if start < 0 {
-			start = 0
+	if z.encoding == encodingListpack {
+		pairs := z.sortedPairs()
+		for i := start; i <= stop; i++ {
+			pair := pairs[i]
+			if desc {
+				pair = pairs[len(pairs)-1-i]
+			}
+			if !consumer(pair.member, pair.score) {
+				return
+			}
 		}
-		if stop >= size {
-			stop = size - 1
+		return
+	}
+
+	// Using skiplist encoding, the first node is found in O(log N)
+	var node *skiplist.Node
+	if desc {
+		node = z.skiplist.GetByRank(z.Len() - 1 - start)
+	} else {
+		node = z.skiplist.GetByRank(start)
+	}
+	for i := start; i <= stop && node != nil; i++ {
+		if !consumer(node.Member, node.Score) {
+			return
 		}
-		if start > stop || start >= size {
-			return []string{}
+		if desc {
+			node = node.Prev()
+		} else {
+			node = node.Next()
 		}
+	}
+}
 
-		// Extract member names
-		result := make([]string, 0, stop-start+1)
-		for i := start; i <= stop; i++ {
-			result = append(result, pairs[i][0])
+// ForEachByScore visits the members with scores between min and max (inclusive), in ascending
+// order or descending order if desc
+func (z *zset) ForEachByScore(min, max float64, desc bool, consumer Consumer) {
+	if z.encoding == encodingListpack {
+		pairs := z.sortedPairs()
+		for i := range pairs {
+			pair := pairs[i]
+			if desc {
+				pair = pairs[len(pairs)-1-i]
+			}
+			if pair.score < min || pair.score > max {
+				continue
+			}
+			if !consumer(pair.member, pair.score) {
+				return
+			}
 		}
-		return result
+		return
 	}
 
 	// Using skiplist encoding
-	return z.skiplist.RangeByRank(start, stop)
+	if desc {
+		for node := z.skiplist.LastInRange(min, max); node != nil && node.Score >= min; node = node.Prev() {
+			if !consumer(node.Member, node.Score) {
+				return
+			}
+		}
+		return
+	}
+	for node := z.skiplist.FirstInRange(min, max); node != nil && node.Score <= max; node = node.Next() {
+		if !consumer(node.Member, node.Score) {
+			return
+		}
+	}
 }
 
 // Remove removes a member from the sorted set
 // Returns true if the member was removed, false if it didn't exist
 func (z *zset) Remove(member string) bool {
 	if z.encoding == encodingListpack {
-		for i, pair := range z.listpack {
-			if pair[0] == member {
-				// Remove the member by slicing it out
-				z.listpack = append(z.listpack[:i], z.listpack[i+1:]...)
-				return true
-			}
+		index := z.listpack.Find([]byte(member), 2)
+		if index < 0 {
+			return false
 		}
-		return false
+		// Remove the member and its score
+		z.listpack.Delete(index, 2)
+		return true
 	}
 
 	// Using skiplist encoding
@@ -317,10 +382,9 @@ func (z *zset) RemoveRangeByScore(min, max float64) int {
 	if z.encoding == encodingListpack {
 		// Find members to remove
 		toRemove := make([]string, 0)
-		for _, pair := range z.listpack {
-			score, _ := parseScore(pair[1])
-			if score >= min && score <= max {
-				toRemove = append(toRemove, pair[0])
+		for _, pair := range z.listpackPairs() {
+			if pair.score >= min && pair.score <= max {
+				toRemove = append(toRemove, pair.member)
 			}
 		}
 
@@ -335,7 +399,7 @@ func (z *zset) RemoveRangeByScore(min, max float64) int {
 	}
 
 	// Using skiplist encoding
-	members := z.skiplist.RangeByScore(min, max, 0, -1)
+	members := z.RangeByScore(min, max, 0, -1)
 	count := 0
 	for _, member := range members {
 		if z.Remove(member) {
@@ -345,11 +409,98 @@ func (z *zset) RemoveRangeByScore(min, max float64) int {
 	return count
 }
 
+// listpackPair is a member and its score decoded from the listpack
+type listpackPair struct {
+	member string
+	score  float64
+}
+
+// listpackPairs decodes all the members of the listpack, in insertion order
+func (z *zset) listpackPairs() []listpackPair {
+	pairs := make([]listpackPair, 0, z.Len())
+	var member string
+	z.listpack.ForEach(func(i int, val []byte) bool {
+		if i%2 == 0 {
+			member = string(val)
+		} else {
+			score, _ := parseScore(string(val))
+			pairs = append(pairs, listpackPair{member: member, score: score})
+		}
+		return true
+	})
+	return pairs
+}
+
+// sortedPairs decodes all the members of the listpack, sorted like the skiplist
+func (z *zset) sortedPairs() []listpackPair {
+	pairs := z.listpackPairs()
+	sortPairs(pairs)
+	return pairs
+}
+
+// sortPairs sorts the pairs by score, then by member like the skiplist
+func sortPairs(pairs []listpackPair) {
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].score != pairs[j].score {
+			return pairs[i].score < pairs[j].score
+		}
+		return pairs[i].member < pairs[j].member
+	})
+}
+
+// Verify checks the invariants of the sorted set: in listpack encoding the entries are pairs of
+// distinct members and valid scores, in skiplist encoding the dict and the skiplist hold the
+// same members with the same scores
+func (z *zset) Verify() error {
+	if z.encoding == encodingListpack {
+		if err := z.listpack.Verify(); err != nil {
+			return err
+		}
+		if z.listpack.Len()%2 != 0 {
+			return fmt.Errorf("zset: odd number of listpack entries %d", z.listpack.Len())
+		}
+		members := make(map[string]struct{}, z.Len())
+		var err error
+		z.listpack.ForEach(func(i int, val []byte) bool {
+			if i%2 == 0 {
+				if _, ok := members[string(val)]; ok {
+					err = fmt.Errorf("zset: duplicate member %q", val)
+				}
+				members[string(val)] = struct{}{}
+			} else if score, e := parseScore(string(val)); e != nil || math.IsNaN(score) {
+				err = fmt.Errorf("zset: invalid score %q", val)
+			}
+			return err == nil
+		})
+		return err
+	}
+	if err := z.skiplist.Verify(); err != nil {
+		return err
+	}
+	if len(z.dict) != z.skiplist.Len() {
+		return fmt.Errorf("zset: %d members in the dict, %d in the skiplist", len(z.dict), z.skiplist.Len())
+	}
+	for x := z.skiplist.First(); x != nil; x = x.Next() {
+		if score, ok := z.dict[x.Member]; !ok || score != x.Score {
+			return fmt.Errorf("zset: member %q of the skiplist has another score in the dict", x.Member)
+		}
+	}
+	return nil
+}
+
 // Encoding returns the current encoding type of the zset (0 for listpack, 1 for skiplist)
 func (z *zset) Encoding() int {
 	return z.encoding
 }
 
+// ObjectEncoding returns the name of the encoding of the zset, as OBJECT ENCODING replies it
+func (z *zset) ObjectEncoding() string {
+	if z.encoding == encodingListpack {
+		return "listpack"
+	}
+	return "skiplist"
+}
+
 // GetSkiplist returns the skiplist used by the zset when in skiplist encoding
 // Returns nil if the zset is using listpack encoding
 func (z *zset) GetSkiplist() *skiplist.SkipList {
@@ -0,0 +1,228 @@
+package zset
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// reference keeps the expected content of a zset
+type reference map[string]float64
+
+// sorted returns the members ordered by score, then by member
+func (r reference) sorted() []listpackPair {
+	pairs := make([]listpackPair, 0, len(r))
+	for member, score := range r {
+		pairs = append(pairs, listpackPair{member: member, score: score})
+	}
+	sortPairs(pairs)
+	return pairs
+}
+
+// makeRandomZSet adds size random members with few distinct scores, so that members with equal
+// scores are ordered by name
+func makeRandomZSet(size int, rng *rand.Rand) (ZSet, reference) {
+	z := NewZSet()
+	ref := make(reference)
+	for i := 0; i < size; i++ {
+		member := "m" + strconv.Itoa(rng.Intn(size*2))
+		score := float64(rng.Intn(size / 4))
+		z.Add(member, score)
+		ref[member] = score
+	}
+	return z, ref
+}
+
+// collect returns the members visited by an iteration
+func collect(iterate func(Consumer)) []listpackPair {
+	var pairs []listpackPair
+	iterate(func(member string, score float64) bool {
+		pairs = append(pairs, listpackPair{member: member, score: score})
+		return true
+	})
+	return pairs
+}
+
+func reversed(pairs []listpackPair) []listpackPair {
+	result := make([]listpackPair, len(pairs))
+	for i, pair := range pairs {
+		result[len(pairs)-1-i] = pair
+	}
+	return result
+}
+
+func equalPairs(a, b []listpackPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestIterators checks ranks and iterations of both encodings against a sorted reference
+func TestIterators(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{40, 1000} {
+		z, ref := makeRandomZSet(size, rng)
+		// update some scores to move members around
+		for member := range ref {
+			if rng.Intn(3) == 0 {
+				score := float64(rng.Intn(size / 4))
+				z.Add(member, score)
+				ref[member] = score
+			}
+		}
+		expected := ref.sorted()
+		if z.Len() != len(expected) {
+			t.Fatalf("Expected %d members, got %d", len(expected), z.Len())
+		}
+
+		for i, pair := range expected {
+			rank, ok := z.Rank(pair.member, false)
+			if !ok || rank != i {
+				t.Fatalf("Encoding %d: expected rank %d for %s, got %d %v", z.Encoding(), i, pair.member, rank, ok)
+			}
+			rank, _ = z.Rank(pair.member, true)
+			if rank != len(expected)-1-i {
+				t.Fatalf("Encoding %d: expected reverse rank %d for %s, got %d", z.Encoding(), len(expected)-1-i, pair.member, rank)
+			}
+		}
+		if _, ok := z.Rank("absent", false); ok {
+			t.Errorf("Encoding %d: absent member should have no rank", z.Encoding())
+		}
+
+		all := collect(func(c Consumer) { z.ForEachByRank(0, -1, false, c) })
+		if !equalPairs(all, expected) {
+			t.Fatalf("Encoding %d: ascending iteration does not match the reference", z.Encoding())
+		}
+		all = collect(func(c Consumer) { z.ForEachByRank(0, -1, true, c) })
+		if !equalPairs(all, reversed(expected)) {
+			t.Fatalf("Encoding %d: descending iteration does not match the reference", z.Encoding())
+		}
+
+		start, stop := len(expected)/3, len(expected)/2
+		part := collect(func(c Consumer) { z.ForEachByRank(start, stop, false, c) })
+		if !equalPairs(part, expected[start:stop+1]) {
+			t.Errorf("Encoding %d: range by rank %d..%d does not match the reference", z.Encoding(), start, stop)
+		}
+		part = collect(func(c Consumer) { z.ForEachByRank(-stop-1, -start-1, true, c) })
+		if !equalPairs(part, reversed(expected)[len(expected)-stop-1:len(expected)-start]) {
+			t.Errorf("Encoding %d: descending range by negative ranks does not match the reference", z.Encoding())
+		}
+
+		min, max := float64(size/16), float64(size/8)
+		var inRange []listpackPair
+		for _, pair := range expected {
+			if pair.score >= min && pair.score <= max {
+				inRange = append(inRange, pair)
+			}
+		}
+		part = collect(func(c Consumer) { z.ForEachByScore(min, max, false, c) })
+		if !equalPairs(part, inRange) {
+			t.Errorf("Encoding %d: range by score does not match the reference", z.Encoding())
+		}
+		part = collect(func(c Consumer) { z.ForEachByScore(min, max, true, c) })
+		if !equalPairs(part, reversed(inRange)) {
+			t.Errorf("Encoding %d: descending range by score does not match the reference", z.Encoding())
+		}
+		if z.Count(min, max) != len(inRange) {
+			t.Errorf("Encoding %d: expected count %d, got %d", z.Encoding(), len(inRange), z.Count(min, max))
+		}
+		if n := len(collect(func(c Consumer) { z.ForEachByScore(math.Inf(1), math.Inf(1), false, c) })); n != 0 {
+			t.Errorf("Encoding %d: expected an empty range, got %d members", z.Encoding(), n)
+		}
+	}
+}
+
+// TestIterationStops checks that a consumer returning false stops the iteration
+func TestIterationStops(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for _, size := range []int{40, 1000} {
+		z, _ := makeRandomZSet(size, rng)
+		visited := 0
+		z.ForEachByRank(0, -1, true, func(member string, score float64) bool {
+			visited++
+			return visited < 5
+		})
+		if visited != 5 {
+			t.Errorf("Encoding %d: expected 5 visited members, got %d", z.Encoding(), visited)
+		}
+	}
+}
+
+// TestRankAfterRemove checks that ranks stay correct when members are removed from the skiplist
+func TestRankAfterRemove(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	z, ref := makeRandomZSet(2000, rng)
+	for member := range ref {
+		if rng.Intn(2) == 0 {
+			z.Remove(member)
+			delete(ref, member)
+		}
+	}
+	removed := z.RemoveRangeByRank(10, 19)
+	expected := ref.sorted()
+	expected = append(expected[:10:10], expected[20:]...)
+	if removed != 10 {
+		t.Fatalf("Expected 10 removed members, got %d", removed)
+	}
+
+	members := z.RangeByRank(0, -1)
+	if len(members) != len(expected) {
+		t.Fatalf("Expected %d members, got %d", len(expected), len(members))
+	}
+	for i, pair := range expected {
+		if members[i] != pair.member {
+			t.Fatalf("Expected %s at rank %d, got %s", pair.member, i, members[i])
+		}
+		if rank, _ := z.Rank(pair.member, false); rank != i {
+			t.Fatalf("Expected rank %d for %s, got %d", i, pair.member, rank)
+		}
+	}
+}
+
+// TestVerify tests that Verify accepts valid sorted sets in both encodings and detects a
+// corrupted skiplist
+func TestVerify(t *testing.T) {
+	small := NewZSet()
+	small.Add("a", 1)
+	small.Add("b", 2)
+	if err := small.(*zset).Verify(); err != nil {
+		t.Fatalf("Expected a valid listpack zset, got %v", err)
+	}
+
+	z := NewZSet()
+	for i := 0; i < listpackMaxSize*2; i++ {
+		z.Add("member"+strconv.Itoa(i), float64(i%17))
+	}
+	for i := 0; i < listpackMaxSize; i += 3 {
+		z.Remove("member" + strconv.Itoa(i))
+	}
+	if err := z.(*zset).Verify(); err != nil {
+		t.Fatalf("Expected a valid skiplist zset, got %v", err)
+	}
+
+	first := z.GetSkiplist().First()
+	first.Score = 100
+	if err := z.(*zset).Verify(); err == nil {
+		t.Error("Expected Verify to detect a node out of order")
+	}
+	first.Score = 0
+	first.Level[0].Span = 2
+	if err := z.(*zset).Verify(); err == nil {
+		t.Error("Expected Verify to detect a wrong span")
+	}
+	first.Level[0].Span = 1
+	if err := z.(*zset).Verify(); err != nil {
+		t.Fatalf("Expected the repaired zset to be valid, got %v", err)
+	}
+	z.(*zset).dict[first.Member] = 1
+	if err := z.(*zset).Verify(); err == nil {
+		t.Error("Expected Verify to detect a score differing from the dict")
+	}
+}
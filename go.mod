@@ -2,6 +2,4 @@ module redigo
 
 go 1.23.1
 
-require github.com/jolestar/go-commons-pool/v2 v2.1.2
-
-require golang.org/x/exp v0.0.0-20250408133849-7e4ce0ab07d0 // indirect
+require github.com/yuin/gopher-lua v1.1.2
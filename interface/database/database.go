@@ -1,6 +1,10 @@
 package database
 
-import "redigo/interface/resp"
+import (
+	"redigo/interface/resp"
+	"sync/atomic"
+	"time"
+)
 
 // CmdLine is a type alias for a slice of byte slices
 type CmdLine = [][]byte
@@ -15,4 +19,59 @@ type Database interface {
 // DataEntity 将数据封装为 DataEntity 类型
 type DataEntity struct {
 	Data interface{}
+	// access is the unix time in seconds of the last access, like the LRU clock of Redis
+	// It is updated atomically since concurrent readers share the entity
+	access atomic.Int64
+	// size is the estimated memory of the value accounted by its DB, -1 once it is removed
+	size atomic.Int64
+}
+
+// Touch records an access to the value
+func (e *DataEntity) Touch() {
+	e.access.Store(time.Now().Unix())
+}
+
+// IdleTime returns the time since the last access of the value, with a resolution of a second
+func (e *DataEntity) IdleTime() time.Duration {
+	return time.Duration(time.Now().Unix()-e.access.Load()) * time.Second
+}
+
+// Account sets the estimated memory of the value stored in a DB, it returns the change of the
+// memory accounted for the value
+func (e *DataEntity) Account(size int64) int64 {
+	old := e.size.Swap(size)
+	if old < 0 {
+		old = 0
+	}
+	return size - old
+}
+
+// Resize updates the estimated memory of the value after it changed, it returns the change of the
+// memory accounted for the value, 0 if the value was removed from its DB
+func (e *DataEntity) Resize(size int64) int64 {
+	for {
+		old := e.size.Load()
+		if old < 0 {
+			return 0
+		}
+		if e.size.CompareAndSwap(old, size) {
+			return size - old
+		}
+	}
+}
+
+// Release stops accounting the value removed from its DB, it returns the memory accounted for it
+func (e *DataEntity) Release() int64 {
+	if old := e.size.Swap(-1); old > 0 {
+		return old
+	}
+	return 0
+}
+
+// Size returns the estimated memory of the value
+func (e *DataEntity) Size() int64 {
+	if size := e.size.Load(); size > 0 {
+		return size
+	}
+	return 0
 }
@@ -5,4 +5,9 @@ type Connection interface {
 	Write([]byte) error // Write data to the connection
 	GetDBIndex() int    // Get database index
 	SelectDB(int)       // Select database
+	GetProtocol() int   // Get the protocol version negotiated by HELLO
+	SetProtocol(int)    // Set the protocol version
+	// GetSubscriptions returns the number of channels and patterns the connection is subscribed to
+	GetSubscriptions() int
+	SetSubscriptions(int) // Set the number of subscriptions, maintained by the pubsub hub
 }
@@ -1,6 +1,21 @@
 // Package resp reply: Redis 对客户端的回复
 package resp
 
+import "io"
+
 type Reply interface {
 	ToBytes() []byte // 将回复转换为字节数组
 }
+
+// StreamReply 可以分段写出的回复，大集合的回复不必先拼接成一个完整的字节数组
+type StreamReply interface {
+	Reply
+	io.WriterTo
+}
+
+// HeldReply 写出时需要持有数据的锁的回复，WriteTo 在 Hold 的 fn 内调用
+// 连接先调用 Hold 再获取自身的锁，与命令持有键的锁时向连接发布消息的加锁顺序一致
+type HeldReply interface {
+	StreamReply
+	Hold(fn func())
+}
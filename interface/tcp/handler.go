@@ -10,3 +10,8 @@ type Handler interface {
 	Handle(ctx context.Context, conn net.Conn)
 	Close() error
 }
+
+// StatusReporter is implemented by handlers which can describe their state in the log
+type StatusReporter interface {
+	Status() string
+}
@@ -6,13 +6,13 @@ import (
 )
 
 type NodeMap struct {
-	hashFunc    func(data []byte) uint32
+	hashFunc    HashFunc
 	nodeHashs   []int
 	nodehashMap map[int]string
 }
 
-// NewNodeMap creates a new NodeMap instance
-func NewNodeMap(hashFunc func(data []byte) uint32) *NodeMap {
+// NewNodeMap creates a new NodeMap instance, hashing with crc32 if hashFunc is nil
+func NewNodeMap(hashFunc HashFunc) *NodeMap {
 	m := &NodeMap{
 		hashFunc:    hashFunc,
 		nodehashMap: make(map[int]string),
@@ -43,13 +43,18 @@ func (m *NodeMap) AddNodes(nodes ...string) {
 
 // PickNode picks a node based on the key, returning the node that is closest to the hash of the key
 func (m *NodeMap) PickNode(key string) string {
+	return m.PickNodeOfHash(m.hashFunc([]byte(key)))
+}
+
+// PickNodeOfHash returns the node closest to the hash, the node of the keys hashed to it
+// With the crc16 hash, the hash of a key is its slot
+func (m *NodeMap) PickNodeOfHash(hash uint32) string {
 	if m.IsEmpty() {
 		return ""
 	}
 
-	hash := int(m.hashFunc([]byte(key)))
 	index := sort.Search(len(m.nodeHashs), func(i int) bool {
-		return m.nodeHashs[i] >= hash
+		return m.nodeHashs[i] >= int(hash)
 	})
 	// If the hash is greater than all node hashes, Int.Search returns len(m.nodeHashs)
 	// So we need to wrap around to the first node
@@ -0,0 +1,94 @@
+package consistenthash
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/bits"
+	"redigo/lib/crc16"
+	"redigo/lib/slot"
+	"strings"
+)
+
+// HashFunc maps a key or a node to its position on the ring
+type HashFunc func(data []byte) uint32
+
+// Names of the hash functions of the ring, for the cluster-hash option
+const (
+	// HashCRC32 is the IEEE CRC-32, the default
+	HashCRC32 = "crc32"
+	// HashCRC16 is the hash of Redis Cluster, the slot of the key computed from its hash tag, so
+	// that a key has the slot Redis Cluster clients compute for it
+	HashCRC16 = "crc16"
+	// HashXXHash is the 32 bits xxHash
+	HashXXHash = "xxhash"
+)
+
+// NewHashFunc returns the hash function with the name, crc32 if it is empty, seeded with seed
+// With a seed of 0 the functions return the standard checksums
+func NewHashFunc(name string, seed uint32) (HashFunc, error) {
+	switch strings.ToLower(name) {
+	case "", HashCRC32:
+		return func(data []byte) uint32 {
+			return crc32.Update(seed, crc32.IEEETable, data)
+		}, nil
+	case HashCRC16:
+		return func(data []byte) uint32 {
+			return uint32(crc16.Update(uint16(seed), []byte(slot.HashTag(string(data))))) % slot.SlotCount
+		}, nil
+	case HashXXHash:
+		return func(data []byte) uint32 {
+			return xxhash32(data, seed)
+		}, nil
+	}
+	return nil, errors.New("unknown cluster hash " + name + ", expected crc32, crc16 or xxhash")
+}
+
+const (
+	xxPrime1 uint32 = 2654435761
+	xxPrime2 uint32 = 2246822519
+	xxPrime3 uint32 = 3266489917
+	xxPrime4 uint32 = 668265263
+	xxPrime5 uint32 = 374761393
+)
+
+func xxRound(acc uint32, input []byte) uint32 {
+	acc += binary.LittleEndian.Uint32(input) * xxPrime2
+	return bits.RotateLeft32(acc, 13) * xxPrime1
+}
+
+// xxhash32 returns the XXH32 hash of data
+func xxhash32(data []byte, seed uint32) uint32 {
+	n := len(data)
+	var h uint32
+	if n >= 16 {
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for ; len(data) >= 16; data = data[16:] {
+			v1 = xxRound(v1, data[0:])
+			v2 = xxRound(v2, data[4:])
+			v3 = xxRound(v3, data[8:])
+			v4 = xxRound(v4, data[12:])
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = seed + xxPrime5
+	}
+	h += uint32(n)
+	for ; len(data) >= 4; data = data[4:] {
+		h += binary.LittleEndian.Uint32(data) * xxPrime3
+		h = bits.RotateLeft32(h, 17) * xxPrime4
+	}
+	for _, b := range data {
+		h += uint32(b) * xxPrime5
+		h = bits.RotateLeft32(h, 11) * xxPrime1
+	}
+	h ^= h >> 15
+	h *= xxPrime2
+	h ^= h >> 13
+	h *= xxPrime3
+	h ^= h >> 16
+	return h
+}
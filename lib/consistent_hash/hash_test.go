@@ -0,0 +1,68 @@
+package consistenthash
+
+import (
+	"redigo/lib/slot"
+	"testing"
+)
+
+// TestHashFuncs tests the hash functions against their reference values
+func TestHashFuncs(t *testing.T) {
+	tests := []struct {
+		name     string
+		seed     uint32
+		data     string
+		expected uint32
+	}{
+		{HashCRC32, 0, "123456789", 0xCBF43926},
+		{"", 0, "123456789", 0xCBF43926},
+		// slots computed by CLUSTER KEYSLOT of Redis
+		{HashCRC16, 0, "123456789", 0x31C3 % slot.SlotCount},
+		{HashCRC16, 0, "foo", 12182},
+		{HashCRC16, 0, "{user1000}.following", 3443},
+		{HashCRC16, 0, "somekey", 11058},
+		{HashXXHash, 0, "", 0x02CC5D05},
+		{HashXXHash, 0, "a", 0x550D7456},
+		{HashXXHash, 0, "abc", 0x32D153FF},
+		{HashXXHash, 0, "Nobody inspects the spammish repetition", 0xE2293B2F},
+		{HashXXHash, 1, "", 0x0B2CB792},
+	}
+	for _, test := range tests {
+		hash, err := NewHashFunc(test.name, test.seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := hash([]byte(test.data)); actual != test.expected {
+			t.Errorf("Expected %s(%q, seed %d) to be %d, got %d", test.name, test.data, test.seed, test.expected, actual)
+		}
+	}
+	if _, err := NewHashFunc("md5", 0); err == nil {
+		t.Error("Expected an unknown hash function to be rejected")
+	}
+}
+
+// TestHashTag tests that the keys with the same hash tag are on the same node with crc16
+func TestHashTag(t *testing.T) {
+	hash, _ := NewHashFunc(HashCRC16, 0)
+	m := NewNodeMap(hash)
+	m.AddNodes("127.0.0.1:6379", "127.0.0.1:6380", "127.0.0.1:6381")
+	node := m.PickNode("{user1000}.following")
+	for _, key := range []string{"{user1000}.followers", "{user1000}", "x{user1000}"} {
+		if actual := m.PickNode(key); actual != node {
+			t.Errorf("Expected %s to be on %s, got %s", key, node, actual)
+		}
+	}
+}
+
+func TestPickNodeOfHash(t *testing.T) {
+	hashFunc, err := NewHashFunc(HashCRC16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewNodeMap(hashFunc)
+	m.AddNodes("127.0.0.1:6379", "127.0.0.1:6380", "127.0.0.1:6381")
+	for _, key := range []string{"foo", "bar", "{user1}.name", "user:1000", ""} {
+		if got, want := m.PickNodeOfHash(uint32(slot.KeySlot(key))), m.PickNode(key); got != want {
+			t.Errorf("PickNodeOfHash(slot of %q) = %s, want %s", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package crc16
+
+// table is the lookup table of the CRC16-CCITT (XMODEM) polynomial 0x1021,
+// which is the checksum used by Redis Cluster to compute key slots
+var table [256]uint16
+
+func init() {
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+}
+
+// Checksum returns the CRC16 checksum of data
+func Checksum(data []byte) uint16 {
+	return Update(0, data)
+}
+
+// Update returns the checksum of data continuing from crc, the checksum of the previous data or
+// a seed
+func Update(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc = crc<<8 ^ table[byte(crc>>8)^b]
+	}
+	return crc
+}
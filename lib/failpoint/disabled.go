@@ -0,0 +1,27 @@
+//go:build !failpoint
+
+package failpoint
+
+// Enable fails, failpoints are not compiled in
+func Enable(name, spec string) error {
+	if _, err := parseAction(spec); err != nil {
+		return err
+	}
+	return ErrDisabled
+}
+
+// Disable does nothing, failpoints are not compiled in
+func Disable(name string) {}
+
+// DisableAll does nothing, failpoints are not compiled in
+func DisableAll() {}
+
+// List returns no failpoint, failpoints are not compiled in
+func List() []string {
+	return nil
+}
+
+// Inject does nothing, failpoints are not compiled in
+func Inject(name string) error {
+	return nil
+}
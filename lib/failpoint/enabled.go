@@ -0,0 +1,79 @@
+//go:build failpoint
+
+package failpoint
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	mu      sync.RWMutex
+	actions = make(map[string]*action)
+)
+
+// Enable sets the action of the failpoint, replacing its previous action
+func Enable(name, spec string) error {
+	a, err := parseAction(spec)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	actions[name] = a
+	mu.Unlock()
+	return nil
+}
+
+// Disable removes the action of the failpoint
+func Disable(name string) {
+	mu.Lock()
+	delete(actions, name)
+	mu.Unlock()
+}
+
+// DisableAll removes the actions of all failpoints
+func DisableAll() {
+	mu.Lock()
+	actions = make(map[string]*action)
+	mu.Unlock()
+}
+
+// List returns the enabled failpoints
+func List() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Inject runs the action of the failpoint, it returns the error of an error action and nil
+// otherwise
+func Inject(name string) error {
+	mu.RLock()
+	empty := len(actions) == 0
+	mu.RUnlock()
+	if empty {
+		return nil
+	}
+	mu.Lock()
+	a, ok := actions[name]
+	if ok && a.count > 0 {
+		a.count--
+		if a.count == 0 {
+			delete(actions, name)
+		}
+	}
+	mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if a.delay > 0 {
+		time.Sleep(a.delay)
+	}
+	return a.err
+}
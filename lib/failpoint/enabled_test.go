@@ -0,0 +1,51 @@
+//go:build failpoint
+
+package failpoint
+
+import (
+	"testing"
+	"time"
+)
+
+// TestInject tests that an error action fails the point the given number of times only
+func TestInject(t *testing.T) {
+	defer DisableAll()
+	if err := Enable(AofWrite, "2*error(disk full)"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := Inject(AofWrite); err == nil || err.Error() != "disk full" {
+			t.Errorf("Expected injection %d to fail with disk full, got %v", i, err)
+		}
+	}
+	if err := Inject(AofWrite); err != nil {
+		t.Errorf("Expected the failpoint to be disabled after 2 injections, got %v", err)
+	}
+	if len(List()) != 0 {
+		t.Errorf("Expected no enabled failpoint, got %v", List())
+	}
+}
+
+// TestInjectDelay tests that a delay action sleeps until the failpoint is disabled
+func TestInjectDelay(t *testing.T) {
+	defer DisableAll()
+	if err := Enable(DictPut, "delay(20ms)"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := Inject(DictPut); err != nil {
+		t.Errorf("Expected a delay without error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected a delay of 20ms, got %v", elapsed)
+	}
+	if err := Inject(ClusterRelay); err != nil {
+		t.Errorf("Expected another failpoint not to be affected, got %v", err)
+	}
+	Disable(DictPut)
+	start = time.Now()
+	Inject(DictPut)
+	if elapsed := time.Since(start); elapsed >= 20*time.Millisecond {
+		t.Errorf("Expected no delay after Disable, got %v", elapsed)
+	}
+}
@@ -0,0 +1,82 @@
+// Package failpoint injects delays and errors at named points of the server, to test the
+// timeout, retry and durability paths deterministically.
+//
+// Failpoints are compiled in with the failpoint build tag only:
+//
+//	go build -tags failpoint
+//	go test -tags failpoint ./...
+//
+// Without the tag Inject does nothing and is inlined away, the points cost nothing in production.
+// A failpoint is enabled with an action:
+//   - delay(<duration>) sleeps, e.g. delay(100ms)
+//   - error(<message>) makes the point fail with the message
+//   - <n>*<action> runs the action the n next times only, e.g. 2*error(disk full)
+package failpoint
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The points of the server
+const (
+	AofWrite      = "aof-write"      // before a command is written to the AOF file
+	ClusterRelay  = "cluster-relay"  // before a command is relayed to a peer
+	DictPut       = "dict-put"       // before a key is stored in a dict, an error panics
+	ClusterRename = "cluster-rename" // before a key renamed across nodes is deleted from its node
+)
+
+// points lists the failpoints which may be enabled
+var points = map[string]struct{}{AofWrite: {}, ClusterRelay: {}, DictPut: {}, ClusterRename: {}}
+
+// Exists reports whether the server has a failpoint of that name
+func Exists(name string) bool {
+	_, ok := points[name]
+	return ok
+}
+
+// ErrDisabled is returned when enabling a failpoint in a build without the failpoint tag
+var ErrDisabled = errors.New("failpoints are not compiled in, build with -tags failpoint")
+
+// action is what an enabled failpoint does
+type action struct {
+	delay time.Duration
+	err   error
+	count int // number of times left, -1 for ever
+}
+
+// parseAction parses the action of a failpoint
+func parseAction(spec string) (*action, error) {
+	a := &action{count: -1}
+	if n, rest, ok := strings.Cut(spec, "*"); ok {
+		count, err := strconv.Atoi(n)
+		if err != nil || count <= 0 {
+			return nil, errors.New("invalid failpoint count " + n)
+		}
+		a.count = count
+		spec = rest
+	}
+	name, arg, ok := strings.Cut(spec, "(")
+	if !ok || !strings.HasSuffix(arg, ")") {
+		return nil, errors.New("invalid failpoint action " + spec + ", expected delay(<duration>) or error(<message>)")
+	}
+	arg = strings.TrimSuffix(arg, ")")
+	switch strings.ToLower(name) {
+	case "delay":
+		delay, err := time.ParseDuration(arg)
+		if err != nil || delay < 0 {
+			return nil, errors.New("invalid failpoint delay " + arg)
+		}
+		a.delay = delay
+	case "error":
+		if arg == "" {
+			arg = "failpoint error"
+		}
+		a.err = errors.New(arg)
+	default:
+		return nil, errors.New("unknown failpoint action " + name + ", expected delay or error")
+	}
+	return a, nil
+}
@@ -0,0 +1,23 @@
+package failpoint
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseAction tests the actions of the failpoints and their counts
+func TestParseAction(t *testing.T) {
+	a, err := parseAction("delay(150ms)")
+	if err != nil || a.delay != 150*time.Millisecond || a.err != nil || a.count != -1 {
+		t.Errorf("Expected a delay of 150ms for ever, got %+v, %v", a, err)
+	}
+	a, err = parseAction("2*error(disk full)")
+	if err != nil || a.err == nil || a.err.Error() != "disk full" || a.count != 2 {
+		t.Errorf("Expected the error disk full twice, got %+v, %v", a, err)
+	}
+	for _, spec := range []string{"", "delay", "delay(soon)", "delay(-1s)", "0*error(x)", "x*error(x)", "crash(now)"} {
+		if _, err := parseAction(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package geohash encodes coordinates into 52-bit geohash integers, the same way Redis
+// stores GEO members as sorted set scores
+package geohash
+
+import "math"
+
+const (
+	// MaxStep is the number of bits used for each coordinate
+	MaxStep = 26
+
+	LngMin = -180.0
+	LngMax = 180.0
+	// LatMin and LatMax are the limits of the EPSG:3857 projection, as in Redis
+	LatMin = -85.05112878
+	LatMax = 85.05112878
+
+	// EarthRadius is the radius used by Redis to compute distances, in meters
+	EarthRadius = 6372797.560856
+	// mercatorMax is the half-length of the projected world, in meters
+	mercatorMax = 20037726.37
+)
+
+// Valid reports whether the coordinates can be encoded
+func Valid(lng, lat float64) bool {
+	return lng >= LngMin && lng <= LngMax && lat >= LatMin && lat <= LatMax
+}
+
+// Encode converts the coordinates into a geohash with MaxStep bits per coordinate
+func Encode(lng, lat float64) uint64 {
+	latOffset := (lat - LatMin) / (LatMax - LatMin)
+	lngOffset := (lng - LngMin) / (LngMax - LngMin)
+	latBits := uint64(latOffset * (1 << MaxStep))
+	lngBits := uint64(lngOffset * (1 << MaxStep))
+	// the max value falls just outside the range
+	if latBits == 1<<MaxStep {
+		latBits--
+	}
+	if lngBits == 1<<MaxStep {
+		lngBits--
+	}
+	return interleave(latBits, lngBits)
+}
+
+// Decode returns the center of the cell of a geohash with MaxStep bits per coordinate
+func Decode(hash uint64) (lng, lat float64) {
+	lngMin, lngMax, latMin, latMax := cellBounds(hash, MaxStep)
+	lng = math.Max(LngMin, math.Min(LngMax, (lngMin+lngMax)/2))
+	lat = math.Max(LatMin, math.Min(LatMax, (latMin+latMax)/2))
+	return lng, lat
+}
+
+// cellBounds returns the area covered by a geohash of the given step
+func cellBounds(hash uint64, step uint) (lngMin, lngMax, latMin, latMax float64) {
+	latBits, lngBits := deinterleave(hash)
+	cells := float64(uint64(1) << step)
+	latMin = LatMin + float64(latBits)/cells*(LatMax-LatMin)
+	latMax = LatMin + float64(latBits+1)/cells*(LatMax-LatMin)
+	lngMin = LngMin + float64(lngBits)/cells*(LngMax-LngMin)
+	lngMax = LngMin + float64(lngBits+1)/cells*(LngMax-LngMin)
+	return
+}
+
+// interleave puts the bits of lat at the even positions and the bits of lng at the odd positions
+func interleave(latBits, lngBits uint64) uint64 {
+	return spread(latBits) | spread(lngBits)<<1
+}
+
+// deinterleave is the reverse of interleave
+func deinterleave(hash uint64) (latBits, lngBits uint64) {
+	return squash(hash), squash(hash >> 1)
+}
+
+// spread inserts a zero bit before every bit of the lower 32 bits
+func spread(v uint64) uint64 {
+	v &= 0xFFFFFFFF
+	v = (v | v<<16) & 0x0000FFFF0000FFFF
+	v = (v | v<<8) & 0x00FF00FF00FF00FF
+	v = (v | v<<4) & 0x0F0F0F0F0F0F0F0F
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+// squash keeps the bits at even positions, collapsing them into the lower 32 bits
+func squash(v uint64) uint64 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0F0F0F0F0F0F0F0F
+	v = (v | v>>4) & 0x00FF00FF00FF00FF
+	v = (v | v>>8) & 0x0000FFFF0000FFFF
+	v = (v | v>>16) & 0x00000000FFFFFFFF
+	return v
+}
+
+// Distance returns the great-circle distance between two points in meters (haversine formula)
+func Distance(lng1, lat1, lng2, lat2 float64) float64 {
+	lat1r := degToRad(lat1)
+	lat2r := degToRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(degToRad(lng2-lng1) / 2)
+	return 2 * EarthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+func degToRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// estimateStep returns the largest step whose cells are not smaller than the radius
+func estimateStep(radius, lat float64) uint {
+	if radius == 0 {
+		return MaxStep
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2 // make sure the range is included in most of the base cases
+	// cells near the poles are narrower
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > MaxStep {
+		step = MaxStep
+	}
+	return uint(step)
+}
+
+// Range is a half-open range [Min, Max) of geohashes with MaxStep bits per coordinate
+type Range struct {
+	Min uint64
+	Max uint64
+}
+
+// SearchRanges returns the ranges of geohashes to scan for all points within the box of
+// the given half width and half height (in meters) centered on the point.
+// The cell containing the center and its 8 neighbours are returned, callers must
+// still filter the points by distance.
+func SearchRanges(lng, lat, halfWidth, halfHeight float64) []Range {
+	step := estimateStep(math.Max(halfWidth, halfHeight), lat)
+	// make sure the 3x3 block around the center cell covers the whole box
+	deltaLat := halfHeight / EarthRadius * 180 / math.Pi
+	deltaLng := halfWidth / (EarthRadius * math.Cos(degToRad(lat))) * 180 / math.Pi
+	boxLatMin := math.Max(LatMin, lat-deltaLat)
+	boxLatMax := math.Min(LatMax, lat+deltaLat)
+	for step > 1 {
+		cellLngMin, cellLngMax, cellLatMin, cellLatMax := cellBounds(Encode(lng, lat)>>(2*(MaxStep-step)), step)
+		cellWidth := cellLngMax - cellLngMin
+		cellHeight := cellLatMax - cellLatMin
+		if boxLatMin >= cellLatMin-cellHeight && boxLatMax <= cellLatMax+cellHeight &&
+			lng-deltaLng >= cellLngMin-cellWidth && lng+deltaLng <= cellLngMax+cellWidth {
+			break
+		}
+		step--
+	}
+	shift := 2 * (MaxStep - step)
+	center := Encode(lng, lat) >> shift
+	latBits, lngBits := deinterleave(center)
+	cells := int64(1) << step
+
+	ranges := make([]Range, 0, 9)
+	seen := make(map[uint64]struct{}, 9)
+	for dLat := int64(-1); dLat <= 1; dLat++ {
+		neighbourLat := int64(latBits) + dLat
+		if neighbourLat < 0 || neighbourLat >= cells {
+			continue
+		}
+		for dLng := int64(-1); dLng <= 1; dLng++ {
+			// longitude wraps around the antimeridian
+			neighbourLng := (int64(lngBits) + dLng + cells) % cells
+			cell := interleave(uint64(neighbourLat), uint64(neighbourLng))
+			if _, ok := seen[cell]; ok {
+				continue
+			}
+			seen[cell] = struct{}{}
+			ranges = append(ranges, Range{Min: cell << shift, Max: (cell + 1) << shift})
+		}
+	}
+	return ranges
+}
+
+// DistanceInBox returns the distance between the point and the center if the point
+// lies in the box of the given width and height (in meters) centered on (lng, lat)
+func DistanceInBox(lng, lat, width, height, pointLng, pointLat float64) (float64, bool) {
+	// the distance along a meridian and along the parallel of the point
+	latDistance := Distance(pointLng, pointLat, pointLng, lat)
+	if latDistance > height/2 {
+		return 0, false
+	}
+	lngDistance := Distance(pointLng, pointLat, lng, pointLat)
+	if lngDistance > width/2 {
+		return 0, false
+	}
+	return Distance(lng, lat, pointLng, pointLat), true
+}
@@ -27,6 +27,7 @@ var (
 	mu                 sync.Mutex
 	logPrefix          = ""
 	levelFlags         = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+	settings           *Settings // settings of Setup, used by Reopen
 )
 
 type logLevel int
@@ -47,21 +48,44 @@ func init() {
 }
 
 // Setup initializes logger
-func Setup(settings *Settings) {
-	var err error
-	dir := settings.Path
-	fileName := fmt.Sprintf("%s-%s.%s",
-		settings.Name,
-		time.Now().Format(settings.TimeFormat),
-		settings.Ext)
-
-	logFile, err := mustOpen(fileName, dir)
+func Setup(s *Settings) {
+	file, err := openLogFile(s)
 	if err != nil {
 		log.Fatalf("logging.Setup err: %s", err)
 	}
+	mu.Lock()
+	defer mu.Unlock()
+	settings = s
+	logFile = file
+	logger = log.New(io.MultiWriter(os.Stdout, logFile), defaultPrefix, flags)
+}
+
+// Reopen closes the log file and opens it again by its name, so that logs go to a new file after
+// the old one was moved by an external rotation. It does nothing if Setup was not called
+func Reopen() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if settings == nil {
+		return nil
+	}
+	file, err := openLogFile(settings)
+	if err != nil {
+		return err
+	}
+	if logFile != nil {
+		_ = logFile.Close()
+	}
+	logFile = file
+	logger.SetOutput(io.MultiWriter(os.Stdout, logFile))
+	return nil
+}
 
-	mw := io.MultiWriter(os.Stdout, logFile)
-	logger = log.New(mw, defaultPrefix, flags)
+func openLogFile(s *Settings) (*os.File, error) {
+	fileName := fmt.Sprintf("%s-%s.%s",
+		s.Name,
+		time.Now().Format(s.TimeFormat),
+		s.Ext)
+	return mustOpen(fileName, s.Path)
 }
 
 func setPrefix(level logLevel) {
@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readLog returns the content of a log file
+func readLog(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestReopen tests that Reopen opens the log file again by its name once it was moved, and that
+// it does nothing before Setup
+func TestReopen(t *testing.T) {
+	if err := Reopen(); err != nil {
+		t.Fatalf("Expected Reopen to do nothing before Setup, got %v", err)
+	}
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = logFile.Close()
+		settings, logFile = nil, nil
+		logger = log.New(os.Stdout, defaultPrefix, flags)
+	})
+	dir := t.TempDir()
+	// the time format has no layout element, the name does not change with the time
+	Setup(&Settings{Path: dir, Name: "redigo", Ext: "log", TimeFormat: "test"})
+	name := filepath.Join(dir, "redigo-test.log")
+	Info("before the rotation")
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	Info("after the move")
+	if err := Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	Info("after the reopen")
+
+	rotated := readLog(t, name+".1")
+	if !strings.Contains(rotated, "before the rotation") || !strings.Contains(rotated, "after the move") {
+		t.Errorf("Expected the logs before Reopen in the moved file, got %q", rotated)
+	}
+	current := readLog(t, name)
+	if strings.Contains(current, "before the rotation") || !strings.Contains(current, "[INFO][logger_test.go:") ||
+		!strings.Contains(current, "after the reopen") {
+		t.Errorf("Expected only the logs after Reopen in the new file, got %q", current)
+	}
+}
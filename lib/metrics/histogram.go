@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// LatencyBuckets are the upper bounds in seconds of the buckets of latency histograms, from
+// 100µs to 10s
+var LatencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts observations in buckets, it is not safe for concurrent use
+type Histogram struct {
+	bounds []float64 // upper bounds of the buckets, in increasing order
+	counts []uint64  // observations of each bucket, the last one counts those above all bounds
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram with the upper bounds of its buckets in increasing order
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe adds an observation
+func (h *Histogram) Observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Merge adds the observations of another histogram with the same buckets
+func (h *Histogram) Merge(other *Histogram) {
+	for i, n := range other.counts {
+		h.counts[i] += n
+	}
+	h.sum += other.sum
+	h.count += other.count
+}
+
+// Clone returns a copy of the histogram
+func (h *Histogram) Clone() *Histogram {
+	clone := NewHistogram(h.bounds)
+	clone.Merge(h)
+	return clone
+}
+
+// Quantile returns the upper bound of the bucket holding the q-quantile, +Inf if it is above all
+// bounds and 0 without observations
+func (h *Histogram) Quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank && n > 0 {
+			if i == len(h.bounds) {
+				return math.Inf(1)
+			}
+			return h.bounds[i]
+		}
+	}
+	return math.Inf(1)
+}
+
+// HistogramSample is a histogram with its labels
+type HistogramSample struct {
+	Labels    []Label
+	Histogram *Histogram
+}
+
+// Histogram writes histograms as the cumulative counts of their buckets with the sum and the
+// count of the observations
+func (w *Writer) Histogram(name, help string, samples ...HistogramSample) {
+	w.sb.WriteString("# HELP " + name + " " + help + "\n")
+	w.sb.WriteString("# TYPE " + name + " histogram\n")
+	for _, sample := range samples {
+		h := sample.Histogram
+		var cumulative uint64
+		for i, n := range h.counts {
+			cumulative += n
+			le := "+Inf"
+			if i < len(h.bounds) {
+				le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+			}
+			labels := append(append([]Label{}, sample.Labels...), Label{Name: "le", Value: le})
+			w.sample(name+"_bucket", labels, float64(cumulative))
+		}
+		w.sample(name+"_sum", sample.Labels, h.sum)
+		w.sample(name+"_count", sample.Labels, float64(h.count))
+	}
+}
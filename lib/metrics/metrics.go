@@ -0,0 +1,113 @@
+// Package metrics serves the metrics of the server in the Prometheus text exposition format
+package metrics
+
+import (
+	"net/http"
+	"redigo/lib/logger"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Label is a label of a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a value of a metric with its labels
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Writer writes metric families in the text exposition format
+type Writer struct {
+	sb strings.Builder
+}
+
+// Gauge writes a metric whose value can go up and down
+func (w *Writer) Gauge(name, help string, samples ...Sample) {
+	w.family(name, "gauge", help, samples)
+}
+
+// Counter writes a metric whose value only goes up
+func (w *Writer) Counter(name, help string, samples ...Sample) {
+	w.family(name, "counter", help, samples)
+}
+
+func (w *Writer) family(name, kind, help string, samples []Sample) {
+	w.sb.WriteString("# HELP " + name + " " + help + "\n")
+	w.sb.WriteString("# TYPE " + name + " " + kind + "\n")
+	for _, sample := range samples {
+		w.sample(name, sample.Labels, sample.Value)
+	}
+}
+
+// sample writes a line of a metric family
+func (w *Writer) sample(name string, labels []Label, value float64) {
+	w.sb.WriteString(name)
+	if len(labels) > 0 {
+		w.sb.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.sb.WriteByte(',')
+			}
+			w.sb.WriteString(label.Name + "=" + strconv.Quote(label.Value))
+		}
+		w.sb.WriteByte('}')
+	}
+	w.sb.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+// Collector writes a group of metrics when the endpoint is scraped
+type Collector func(w *Writer)
+
+var (
+	mu         sync.Mutex
+	collectors = make(map[string]Collector)
+)
+
+// Register adds a collector, replacing the collector registered with the same name
+func Register(name string, collector Collector) {
+	mu.Lock()
+	defer mu.Unlock()
+	collectors[name] = collector
+}
+
+// Collect runs all collectors in the order of their names
+func Collect() string {
+	mu.Lock()
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]Collector, len(names))
+	for i, name := range names {
+		list[i] = collectors[name]
+	}
+	mu.Unlock()
+
+	w := &Writer{}
+	for _, collector := range list {
+		collector(w)
+	}
+	return w.sb.String()
+}
+
+// ListenAndServe serves the metrics at /metrics on the address in the background
+func ListenAndServe(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(Collect()))
+	})
+	go func() {
+		logger.Info("metrics endpoint listening on " + address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			logger.Error("metrics endpoint: " + err.Error())
+		}
+	}()
+}
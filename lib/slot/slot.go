@@ -0,0 +1,30 @@
+package slot
+
+import (
+	"redigo/lib/crc16"
+	"strings"
+)
+
+// SlotCount is the number of hash slots in a Redis Cluster
+const SlotCount = 16384
+
+// HashTag returns the part of the key used to compute its slot
+// If the key contains a non-empty {...} section, only the content between the first braces is hashed,
+// so that keys like {user1}.name and {user1}.age are stored in the same slot
+func HashTag(key string) string {
+	begin := strings.IndexByte(key, '{')
+	if begin < 0 {
+		return key
+	}
+	end := strings.IndexByte(key[begin+1:], '}')
+	if end <= 0 {
+		// no closing brace or empty tag
+		return key
+	}
+	return key[begin+1 : begin+1+end]
+}
+
+// KeySlot returns the hash slot of the key
+func KeySlot(key string) int {
+	return int(crc16.Checksum([]byte(HashTag(key)))) % SlotCount
+}
@@ -0,0 +1,141 @@
+// Package stats collects the counters of the server reported by INFO: the connections counted by
+// the handler, the commands it processed and the rate of the commands
+package stats
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// opsSamples is the number of samples the instantaneous rate is averaged on, like Redis
+const opsSamples = 16
+
+// SampleInterval is the interval the rate of the commands should be sampled at
+const SampleInterval = 100 * time.Millisecond
+
+// Stats counts the connections and the commands of a server
+type Stats struct {
+	start time.Time
+
+	connectedClients    atomic.Int64
+	totalConnections    atomic.Int64
+	rejectedConnections atomic.Int64
+	totalCommands       atomic.Int64
+	keysTruncated       atomic.Int64
+	commandPanics       atomic.Int64
+
+	// the commands per second measured by the last samples, in a ring
+	mu          sync.Mutex
+	lastSample  time.Time
+	lastTotal   int64
+	rates       [opsSamples]float64
+	rateIndex   int
+	rateSamples int
+}
+
+// Server is the stats of the server, updated by the handler
+var Server = New()
+
+// New creates stats starting now
+func New() *Stats {
+	return &Stats{start: time.Now()}
+}
+
+// Uptime returns the time since the stats started
+func (s *Stats) Uptime() time.Duration {
+	return time.Since(s.start)
+}
+
+// ClientConnected counts an accepted connection
+func (s *Stats) ClientConnected() {
+	s.connectedClients.Add(1)
+	s.totalConnections.Add(1)
+}
+
+// ClientDisconnected counts a closed connection
+func (s *Stats) ClientDisconnected() {
+	s.connectedClients.Add(-1)
+}
+
+// ConnectionRejected counts a connection closed on accept, e.g. over a limit of its user
+func (s *Stats) ConnectionRejected() {
+	s.rejectedConnections.Add(1)
+}
+
+// CommandProcessed counts a command received from a client
+func (s *Stats) CommandProcessed() {
+	s.totalCommands.Add(1)
+}
+
+// KeysTruncated counts a KEYS stopped by keys-max-scan
+func (s *Stats) KeysTruncated() {
+	s.keysTruncated.Add(1)
+}
+
+// CommandPanicked counts a command whose execution panicked
+func (s *Stats) CommandPanicked() {
+	s.commandPanics.Add(1)
+}
+
+// ConnectedClients returns the number of connections
+func (s *Stats) ConnectedClients() int64 {
+	return s.connectedClients.Load()
+}
+
+// TotalConnections returns the number of connections accepted since the start
+func (s *Stats) TotalConnections() int64 {
+	return s.totalConnections.Load()
+}
+
+// RejectedConnections returns the number of connections rejected since the start
+func (s *Stats) RejectedConnections() int64 {
+	return s.rejectedConnections.Load()
+}
+
+// TruncatedKeys returns the number of KEYS stopped by keys-max-scan
+func (s *Stats) TruncatedKeys() int64 {
+	return s.keysTruncated.Load()
+}
+
+// CommandPanics returns the number of commands which panicked since the start
+func (s *Stats) CommandPanics() int64 {
+	return s.commandPanics.Load()
+}
+
+// TotalCommands returns the number of commands processed since the start
+func (s *Stats) TotalCommands() int64 {
+	return s.totalCommands.Load()
+}
+
+// Sample measures the rate of the commands since the previous sample, it should be called every
+// SampleInterval
+func (s *Stats) Sample(now time.Time) {
+	total := s.totalCommands.Load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.lastSample.IsZero() {
+		if elapsed := now.Sub(s.lastSample); elapsed > 0 {
+			s.rates[s.rateIndex] = float64(total-s.lastTotal) / elapsed.Seconds()
+			s.rateIndex = (s.rateIndex + 1) % opsSamples
+			if s.rateSamples < opsSamples {
+				s.rateSamples++
+			}
+		}
+	}
+	s.lastSample, s.lastTotal = now, total
+}
+
+// OpsPerSecond returns the commands per second averaged on the last samples
+func (s *Stats) OpsPerSecond() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rateSamples == 0 {
+		return 0
+	}
+	sum := 0.0
+	for i := 0; i < s.rateSamples; i++ {
+		sum += s.rates[i]
+	}
+	return int64(sum/float64(s.rateSamples) + 0.5)
+}
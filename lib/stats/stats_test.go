@@ -0,0 +1,46 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCounters(t *testing.T) {
+	s := New()
+	s.ClientConnected()
+	s.ClientConnected()
+	s.ClientDisconnected()
+	s.ConnectionRejected()
+	if s.ConnectedClients() != 1 || s.TotalConnections() != 2 || s.RejectedConnections() != 1 {
+		t.Errorf("unexpected connections: %d connected, %d total, %d rejected",
+			s.ConnectedClients(), s.TotalConnections(), s.RejectedConnections())
+	}
+}
+
+func TestOpsPerSecond(t *testing.T) {
+	s := New()
+	now := time.Now()
+	s.Sample(now)
+	if ops := s.OpsPerSecond(); ops != 0 {
+		t.Errorf("expected no rate before the second sample, got %d", ops)
+	}
+	for i := 1; i <= 2*opsSamples; i++ {
+		for j := 0; j < 50; j++ {
+			s.CommandProcessed()
+		}
+		s.Sample(now.Add(time.Duration(i) * SampleInterval))
+	}
+	// 50 commands every 100ms
+	if ops := s.OpsPerSecond(); ops != 500 {
+		t.Errorf("expected 500 ops/s, got %d", ops)
+	}
+	for i := 1; i <= opsSamples; i++ {
+		s.Sample(now.Add(time.Duration(2*opsSamples+i) * SampleInterval))
+	}
+	if ops := s.OpsPerSecond(); ops != 0 {
+		t.Errorf("expected 0 ops/s once the commands stopped, got %d", ops)
+	}
+	if s.TotalCommands() != 100*opsSamples {
+		t.Errorf("expected %d commands, got %d", 100*opsSamples, s.TotalCommands())
+	}
+}
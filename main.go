@@ -7,8 +7,12 @@ import (
 	"path/filepath" // Add import
 	"redigo/config"
 	"redigo/lib/logger"
+	"redigo/lib/metrics"
+	_ "redigo/module/probabilistic" // BF.* and TOPK.* commands
+	_ "redigo/module/rejson"        // JSON.* commands
 	"redigo/resp/handler"
 	"redigo/tcp"
+	"time"
 )
 
 // Default configuration file name
@@ -41,7 +45,7 @@ func findConfigFile() string {
 		if fileExists(configPath) {
 			return configPath
 		} else {
-			fmt.Printf("Warning: Config file specified by command line does not exist: %s\n", configPath)
+			logger.Warn("config file specified by command line does not exist: " + configPath)
 		}
 	}
 
@@ -76,18 +80,28 @@ func main() {
 	configFileToLoad := findConfigFile()
 
 	if configFileToLoad != "" { // If config file is found
-		fmt.Printf("Loading config file: %s\n", configFileToLoad)
+		logger.Info("loading config file " + configFileToLoad)
 		config.SetupConfig(configFileToLoad) // Load config using found path
 	} else {
-		fmt.Printf("Config file not found in standard locations, using default config\n")
+		logger.Warn("config file not found in standard locations, using the default config")
 		config.Properties = defaultProperties // Use default configuration
 	}
 
+	if config.Properties.MetricsPort > 0 {
+		metrics.ListenAndServe(fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.MetricsPort))
+	}
+
+	if config.Properties.ReusePort {
+		logger.Warn("reuse-port is set: the processes listening on the same port have their own datasets, " +
+			"shut the old process down as soon as the new one listens")
+	}
 	err := tcp.ListenAndServeWithSignal(
 		&tcp.Config{
 			Address: fmt.Sprintf("%s:%d",
 				config.Properties.Bind,
 				config.Properties.Port),
+			ReusePort:    config.Properties.ReusePort,
+			DrainTimeout: time.Duration(config.Properties.ShutdownDrainTimeout) * time.Millisecond,
 		},
 		handler.MakeHandler())
 	if err != nil {
@@ -0,0 +1,212 @@
+package probabilistic
+
+import (
+	"redigo/database"
+	"redigo/datastruct/bloom"
+	idatabase "redigo/interface/database"
+	"redigo/interface/resp"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strconv"
+)
+
+// bloomFilter wraps the filter so that TYPE reports the module type
+type bloomFilter struct {
+	*bloom.Filter
+}
+
+func (bf *bloomFilter) ModuleTypeName() string {
+	return "MBbloom--"
+}
+
+func (bf *bloomFilter) ModuleSize() (int, int) {
+	return bf.Count(), bf.Size()
+}
+
+func (bf *bloomFilter) ModuleDump() ([]byte, error) {
+	return bf.MarshalBinary()
+}
+
+func loadBloomFilter(payload []byte) (interface{}, error) {
+	bf := &bloomFilter{&bloom.Filter{}}
+	if err := bf.UnmarshalBinary(payload); err != nil {
+		return nil, err
+	}
+	return bf, nil
+}
+
+// getAsBloom returns the filter stored at key, nil if the key does not exist
+func getAsBloom(db *database.DB, key string) (*bloomFilter, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	bf, ok := entity.Data.(*bloomFilter)
+	if !ok {
+		return nil, reply.MakeWrongTypeErrReply()
+	}
+	return bf, nil
+}
+
+// getOrInitBloom returns the filter stored at key, creating one with the default parameters if needed
+func getOrInitBloom(db *database.DB, key string) (*bloomFilter, reply.ErrorReply) {
+	bf, errReply := getAsBloom(db, key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if bf == nil {
+		bf = &bloomFilter{bloom.Make(bloom.DefaultCapacity, bloom.DefaultErrorRate)}
+		db.PutEntity(key, &idatabase.DataEntity{Data: bf})
+	}
+	return bf, nil
+}
+
+// execBFReserve implements the BF.RESERVE command
+// BF.RESERVE key error_rate capacity
+func execBFReserve(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	errorRate, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil {
+		return reply.MakeStandardErrorReply("ERR bad error rate")
+	}
+	if errorRate <= 0 || errorRate >= 1 {
+		return reply.MakeStandardErrorReply("ERR (0 < error rate range < 1)")
+	}
+	capacity, err := strconv.Atoi(string(args[2]))
+	if err != nil || capacity <= 0 {
+		return reply.MakeStandardErrorReply("ERR (capacity should be larger than 0)")
+	}
+
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		if _, exists := db.GetEntity(key); exists {
+			result = reply.MakeStandardErrorReply("ERR item exists")
+			return
+		}
+		db.PutEntity(key, &idatabase.DataEntity{Data: &bloomFilter{bloom.Make(capacity, errorRate)}})
+		db.AddAof(utils.ToCmdLineWithName("BF.RESERVE", args...))
+		result = reply.MakeOKReply()
+	})
+	return result
+}
+
+// bloomAdd adds the items, returns 1 for each added item and 0 for each item that may already exist
+func bloomAdd(db *database.DB, name string, args [][]byte) ([]int64, reply.ErrorReply) {
+	key := string(args[0])
+	var added []int64
+	var errReply reply.ErrorReply
+	db.WithKeyLock(key, func() {
+		var bf *bloomFilter
+		bf, errReply = getOrInitBloom(db, key)
+		if errReply != nil {
+			return
+		}
+		added = make([]int64, len(args)-1)
+		for i, item := range args[1:] {
+			if bf.Add(item) {
+				added[i] = 1
+			}
+		}
+		db.AddAof(utils.ToCmdLineWithName(name, args...))
+	})
+	return added, errReply
+}
+
+// execBFAdd implements the BF.ADD command
+// BF.ADD key item
+func execBFAdd(db *database.DB, args [][]byte) resp.Reply {
+	added, errReply := bloomAdd(db, "BF.ADD", args)
+	if errReply != nil {
+		return errReply
+	}
+	return reply.MakeIntReply(added[0])
+}
+
+// execBFMAdd implements the BF.MADD command
+// BF.MADD key item [item ...]
+func execBFMAdd(db *database.DB, args [][]byte) resp.Reply {
+	added, errReply := bloomAdd(db, "BF.MADD", args)
+	if errReply != nil {
+		return errReply
+	}
+	return makeIntsReply(added)
+}
+
+// bloomExists checks the items, returns 1 for each item which may exist
+func bloomExists(db *database.DB, args [][]byte) ([]int64, reply.ErrorReply) {
+	key := string(args[0])
+	exists := make([]int64, len(args)-1)
+	var errReply reply.ErrorReply
+	db.WithKeyRLock(key, func() {
+		var bf *bloomFilter
+		bf, errReply = getAsBloom(db, key)
+		if errReply != nil || bf == nil {
+			return
+		}
+		for i, item := range args[1:] {
+			if bf.Exists(item) {
+				exists[i] = 1
+			}
+		}
+	})
+	return exists, errReply
+}
+
+// execBFExists implements the BF.EXISTS command
+// BF.EXISTS key item
+func execBFExists(db *database.DB, args [][]byte) resp.Reply {
+	exists, errReply := bloomExists(db, args)
+	if errReply != nil {
+		return errReply
+	}
+	return reply.MakeIntReply(exists[0])
+}
+
+// execBFMExists implements the BF.MEXISTS command
+// BF.MEXISTS key item [item ...]
+func execBFMExists(db *database.DB, args [][]byte) resp.Reply {
+	exists, errReply := bloomExists(db, args)
+	if errReply != nil {
+		return errReply
+	}
+	return makeIntsReply(exists)
+}
+
+// execBFInfo implements the BF.INFO command
+// BF.INFO key
+func execBFInfo(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		bf, errReply := getAsBloom(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		if bf == nil {
+			result = reply.MakeStandardErrorReply("ERR not found")
+			return
+		}
+		result = reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("Capacity")),
+			reply.MakeIntReply(int64(bf.Capacity())),
+			reply.MakeBulkReply([]byte("Size")),
+			reply.MakeIntReply(int64(bf.Size())),
+			reply.MakeBulkReply([]byte("Number of filters")),
+			reply.MakeIntReply(int64(bf.Filters())),
+			reply.MakeBulkReply([]byte("Number of items inserted")),
+			reply.MakeIntReply(int64(bf.Count())),
+			reply.MakeBulkReply([]byte("Expansion rate")),
+			reply.MakeIntReply(bloom.Expansion),
+		})
+	})
+	return result
+}
+
+func makeIntsReply(values []int64) resp.Reply {
+	replies := make([]resp.Reply, len(values))
+	for i, v := range values {
+		replies[i] = reply.MakeIntReply(v)
+	}
+	return reply.MakeMultiRawReply(replies)
+}
@@ -0,0 +1,38 @@
+// Package probabilistic provides the Bloom filter (BF.*) and top-k (TOPK.*) commands as a module
+// It is enabled by importing the package:
+//
+//	import _ "redigo/module/probabilistic"
+package probabilistic
+
+import (
+	"redigo/database"
+	"redigo/lib/logger"
+)
+
+func init() {
+	err := database.RegisterModule(&database.Module{
+		Name:    "bf",
+		Version: 1,
+		Commands: []database.ModuleCommand{
+			{Name: "BF.RESERVE", Exec: execBFReserve, Arity: 4},               // BF.RESERVE key error_rate capacity
+			{Name: "BF.ADD", Exec: execBFAdd, Arity: 3},                       // BF.ADD key item
+			{Name: "BF.MADD", Exec: execBFMAdd, Arity: -3},                    // BF.MADD key item [item ...]
+			{Name: "BF.EXISTS", Exec: execBFExists, Arity: 3, ReadOnly: true}, // BF.EXISTS key item
+			{Name: "BF.MEXISTS", Exec: execBFMExists, Arity: -3, ReadOnly: true},
+			{Name: "BF.INFO", Exec: execBFInfo, Arity: 2, ReadOnly: true},
+			{Name: "TOPK.RESERVE", Exec: execTopKReserve, Arity: -3}, // TOPK.RESERVE key topk [width depth decay]
+			{Name: "TOPK.ADD", Exec: execTopKAdd, Arity: -3},         // TOPK.ADD key item [item ...]
+			{Name: "TOPK.INCRBY", Exec: execTopKIncrBy, Arity: -4},   // TOPK.INCRBY key item increment [item increment ...]
+			{Name: "TOPK.QUERY", Exec: execTopKQuery, Arity: -3, ReadOnly: true},
+			{Name: "TOPK.LIST", Exec: execTopKList, Arity: -2, ReadOnly: true}, // TOPK.LIST key [WITHCOUNT]
+			{Name: "TOPK.INFO", Exec: execTopKInfo, Arity: 2, ReadOnly: true},
+		},
+		Types: map[string]database.ModuleTypeLoader{
+			"MBbloom--": loadBloomFilter,
+			"TopK-TYPE": loadTopKSketch,
+		},
+	})
+	if err != nil {
+		logger.Error("failed to register module bf: " + err.Error())
+	}
+}
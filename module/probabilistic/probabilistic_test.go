@@ -0,0 +1,55 @@
+package probabilistic
+
+import (
+	"redigo/database"
+	"redigo/lib/utils"
+	"testing"
+)
+
+// assertCommands runs the commands on the DB and checks their replies
+func assertCommands(t *testing.T, db *database.DB, cases [][2]interface{}) {
+	t.Helper()
+	for _, c := range cases {
+		args, expected := c[0].([]string), c[1].(string)
+		if got := string(db.Exec(nil, utils.ToCmdLine(args...)).ToBytes()); got != expected {
+			t.Errorf("Expected %v to reply %q, got %q", args, expected, got)
+		}
+	}
+}
+
+// TestBloomCommands tests the BF.* commands and the errors of their arguments
+func TestBloomCommands(t *testing.T) {
+	db := database.MakeDB()
+	assertCommands(t, db, [][2]interface{}{
+		{[]string{"BF.RESERVE", "bf", "0.01", "100"}, "+OK\r\n"},
+		{[]string{"BF.RESERVE", "bf", "0.01", "100"}, "-ERR item exists\r\n"},
+		{[]string{"BF.RESERVE", "other", "2", "100"}, "-ERR (0 < error rate range < 1)\r\n"},
+		{[]string{"BF.ADD", "bf", "a"}, ":1\r\n"},
+		{[]string{"BF.ADD", "bf", "a"}, ":0\r\n"},
+		{[]string{"BF.MADD", "bf", "b", "c"}, "*2\r\n:1\r\n:1\r\n"},
+		{[]string{"BF.EXISTS", "bf", "a"}, ":1\r\n"},
+		{[]string{"BF.MEXISTS", "bf", "a", "missing"}, "*2\r\n:1\r\n:0\r\n"},
+		{[]string{"BF.INFO", "bf"}, "*10\r\n$8\r\nCapacity\r\n:100\r\n$4\r\nSize\r\n:120\r\n$17\r\nNumber of filters\r\n:1\r\n" +
+			"$24\r\nNumber of items inserted\r\n:3\r\n$14\r\nExpansion rate\r\n:2\r\n"},
+		{[]string{"TYPE", "bf"}, "$9\r\nMBbloom--\r\n"},
+		// BF.ADD creates the filter with the default parameters
+		{[]string{"BF.ADD", "created", "x"}, ":1\r\n"},
+		{[]string{"SET", "str", "v"}, "+OK\r\n"},
+		{[]string{"BF.ADD", "str", "a"}, "-WRONG TYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+}
+
+// TestTopKCommands tests the TOPK.* commands, an item with a higher count expelling the least
+// frequent one
+func TestTopKCommands(t *testing.T) {
+	db := database.MakeDB()
+	assertCommands(t, db, [][2]interface{}{
+		{[]string{"TOPK.ADD", "tk", "a"}, "-ERR TopK: key does not exist\r\n"},
+		{[]string{"TOPK.RESERVE", "tk", "2"}, "+OK\r\n"},
+		{[]string{"TOPK.ADD", "tk", "a", "b", "a", "c"}, "*4\r\n$-1\r\n$-1\r\n$-1\r\n$-1\r\n"},
+		{[]string{"TOPK.INCRBY", "tk", "c", "10"}, "*1\r\n$1\r\nb\r\n"},
+		{[]string{"TOPK.QUERY", "tk", "c", "b"}, "*2\r\n:1\r\n:0\r\n"},
+		{[]string{"TOPK.LIST", "tk", "WITHCOUNT"}, "*4\r\n$1\r\nc\r\n:11\r\n$1\r\na\r\n:2\r\n"},
+		{[]string{"TOPK.INFO", "tk"}, "*8\r\n$1\r\nk\r\n:2\r\n$5\r\nwidth\r\n:8\r\n$5\r\ndepth\r\n:7\r\n$5\r\ndecay\r\n$3\r\n0.9\r\n"},
+	})
+}
@@ -0,0 +1,229 @@
+package probabilistic
+
+import (
+	"math"
+	"redigo/database"
+	"redigo/datastruct/topk"
+	idatabase "redigo/interface/database"
+	"redigo/interface/resp"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// topKSketch wraps the sketch so that TYPE reports the module type
+type topKSketch struct {
+	*topk.TopK
+}
+
+func (t *topKSketch) ModuleTypeName() string {
+	return "TopK-TYPE"
+}
+
+func (t *topKSketch) ModuleSize() (int, int) {
+	items := t.List()
+	bytes := t.Width() * t.Depth() * 8 // fingerprint and counter of each bucket
+	for _, item := range items {
+		bytes += len(item.Item) + 8
+	}
+	return len(items), bytes
+}
+
+func (t *topKSketch) ModuleDump() ([]byte, error) {
+	return t.MarshalBinary()
+}
+
+func loadTopKSketch(payload []byte) (interface{}, error) {
+	t := &topKSketch{&topk.TopK{}}
+	if err := t.UnmarshalBinary(payload); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// getAsTopK returns the sketch stored at key
+// Unlike Bloom filters, a sketch must be created with TOPK.RESERVE before use
+func getAsTopK(db *database.DB, key string) (*topKSketch, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, reply.MakeStandardErrorReply("ERR TopK: key does not exist")
+	}
+	sketch, ok := entity.Data.(*topKSketch)
+	if !ok {
+		return nil, reply.MakeWrongTypeErrReply()
+	}
+	return sketch, nil
+}
+
+// execTopKReserve implements the TOPK.RESERVE command
+// TOPK.RESERVE key topk [width depth decay]
+func execTopKReserve(db *database.DB, args [][]byte) resp.Reply {
+	if len(args) != 2 && len(args) != 5 {
+		return reply.MakeArgNumErrReply("topk.reserve")
+	}
+	key := string(args[0])
+	k, err := strconv.Atoi(string(args[1]))
+	if err != nil || k <= 0 {
+		return reply.MakeStandardErrorReply("ERR TopK: invalid k")
+	}
+	width, depth, decay := topk.DefaultWidth, topk.DefaultDepth, topk.DefaultDecay
+	if len(args) == 5 {
+		width, err = strconv.Atoi(string(args[2]))
+		if err != nil || width <= 0 {
+			return reply.MakeStandardErrorReply("ERR TopK: invalid width")
+		}
+		depth, err = strconv.Atoi(string(args[3]))
+		if err != nil || depth <= 0 {
+			return reply.MakeStandardErrorReply("ERR TopK: invalid depth")
+		}
+		decay, err = strconv.ParseFloat(string(args[4]), 64)
+		if err != nil || decay <= 0 || decay > 1 {
+			return reply.MakeStandardErrorReply("ERR TopK: invalid decay value. must be '<= 1' & '> 0'")
+		}
+	}
+
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		if _, exists := db.GetEntity(key); exists {
+			result = reply.MakeStandardErrorReply("ERR TopK: key already exists")
+			return
+		}
+		db.PutEntity(key, &idatabase.DataEntity{Data: &topKSketch{topk.Make(k, width, depth, decay)}})
+		db.AddAof(utils.ToCmdLineWithName("TOPK.RESERVE", args...))
+		result = reply.MakeOKReply()
+	})
+	return result
+}
+
+// topKIncrBy increases the items and replies with the expelled items
+// The sketch is randomized with a fixed seed, so the AOF must record every call in order to be replayed identically
+func topKIncrBy(db *database.DB, name string, key string, items [][]byte, increments []uint32, args [][]byte) resp.Reply {
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		sketch, errReply := getAsTopK(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		replies := make([]resp.Reply, len(items))
+		for i, item := range items {
+			if expelled, ok := sketch.IncrBy(string(item), increments[i]); ok {
+				replies[i] = reply.MakeBulkReply([]byte(expelled))
+			} else {
+				replies[i] = reply.MakeNullBulkReply()
+			}
+		}
+		db.AddAof(utils.ToCmdLineWithName(name, args...))
+		result = reply.MakeMultiRawReply(replies)
+	})
+	return result
+}
+
+// execTopKAdd implements the TOPK.ADD command
+// TOPK.ADD key item [item ...]
+func execTopKAdd(db *database.DB, args [][]byte) resp.Reply {
+	items := args[1:]
+	increments := make([]uint32, len(items))
+	for i := range increments {
+		increments[i] = 1
+	}
+	return topKIncrBy(db, "TOPK.ADD", string(args[0]), items, increments, args)
+}
+
+// execTopKIncrBy implements the TOPK.INCRBY command
+// TOPK.INCRBY key item increment [item increment ...]
+func execTopKIncrBy(db *database.DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("topk.incrby")
+	}
+	items := make([][]byte, 0, len(args)/2)
+	increments := make([]uint32, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		increment, err := strconv.ParseUint(string(args[i+1]), 10, 32)
+		if err != nil || increment == 0 || increment > math.MaxUint16 {
+			return reply.MakeStandardErrorReply("ERR TopK: increment must be an integer between 1 and 65535")
+		}
+		items = append(items, args[i])
+		increments = append(increments, uint32(increment))
+	}
+	return topKIncrBy(db, "TOPK.INCRBY", string(args[0]), items, increments, args)
+}
+
+// execTopKQuery implements the TOPK.QUERY command
+// TOPK.QUERY key item [item ...]
+func execTopKQuery(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		sketch, errReply := getAsTopK(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		found := make([]int64, len(args)-1)
+		for i, item := range args[1:] {
+			if sketch.Query(string(item)) {
+				found[i] = 1
+			}
+		}
+		result = makeIntsReply(found)
+	})
+	return result
+}
+
+// execTopKList implements the TOPK.LIST command
+// TOPK.LIST key [WITHCOUNT]
+func execTopKList(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	withCount := false
+	if len(args) == 2 && strings.ToUpper(string(args[1])) == "WITHCOUNT" {
+		withCount = true
+	} else if len(args) != 1 {
+		return reply.MakeSyntaxErrReply()
+	}
+
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		sketch, errReply := getAsTopK(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		items := sketch.List()
+		replies := make([]resp.Reply, 0, len(items)*2)
+		for _, item := range items {
+			replies = append(replies, reply.MakeBulkReply([]byte(item.Item)))
+			if withCount {
+				replies = append(replies, reply.MakeIntReply(int64(item.Count)))
+			}
+		}
+		result = reply.MakeMultiRawReply(replies)
+	})
+	return result
+}
+
+// execTopKInfo implements the TOPK.INFO command
+// TOPK.INFO key
+func execTopKInfo(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		sketch, errReply := getAsTopK(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		result = reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("k")),
+			reply.MakeIntReply(int64(sketch.K())),
+			reply.MakeBulkReply([]byte("width")),
+			reply.MakeIntReply(int64(sketch.Width())),
+			reply.MakeBulkReply([]byte("depth")),
+			reply.MakeIntReply(int64(sketch.Depth())),
+			reply.MakeBulkReply([]byte("decay")),
+			reply.MakeBulkReply([]byte(strconv.FormatFloat(sketch.Decay(), 'f', -1, 64))),
+		})
+	})
+	return result
+}
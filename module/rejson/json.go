@@ -0,0 +1,289 @@
+package rejson
+
+import (
+	"encoding/json"
+	"redigo/database"
+	"redigo/datastruct/document"
+	idatabase "redigo/interface/database"
+	"redigo/interface/resp"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strings"
+)
+
+// jsonDocument wraps the document so that TYPE reports the module type
+type jsonDocument struct {
+	*document.Document
+}
+
+func (d *jsonDocument) ModuleTypeName() string {
+	return "ReJSON-RL"
+}
+
+// ModuleSize reports the number of elements of the root and the length of the serialized document
+func (d *jsonDocument) ModuleSize() (int, int) {
+	root, _ := d.Get(nil)
+	elements := 1
+	switch v := root.(type) {
+	case map[string]any:
+		elements = len(v)
+	case []any:
+		elements = len(v)
+	}
+	return elements, len(document.Marshal(root))
+}
+
+func (d *jsonDocument) ModuleDump() ([]byte, error) {
+	root, _ := d.Get(nil)
+	return document.Marshal(root), nil
+}
+
+func loadJSONDocument(payload []byte) (interface{}, error) {
+	root, err := document.Parse(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonDocument{document.MakeDocument(root)}, nil
+}
+
+// getAsDocument returns the document stored at key, nil if the key does not exist
+func getAsDocument(db *database.DB, key string) (*jsonDocument, reply.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	doc, ok := entity.Data.(*jsonDocument)
+	if !ok {
+		return nil, reply.MakeWrongTypeErrReply()
+	}
+	return doc, nil
+}
+
+// parsePathArg parses a path argument, JSON.GET and JSON.DEL default to the root
+func parsePathArg(args [][]byte, i int) (document.Path, reply.ErrorReply) {
+	if i >= len(args) {
+		return nil, nil
+	}
+	path, err := document.ParsePath(string(args[i]))
+	if err != nil {
+		return nil, reply.MakeStandardErrorReply(err.Error())
+	}
+	return path, nil
+}
+
+// execJSONSet implements the JSON.SET command
+// JSON.SET key path value [NX|XX]
+func execJSONSet(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	path, errReply := parsePathArg(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+	value, err := document.Parse(args[2])
+	if err != nil {
+		return reply.MakeStandardErrorReply(err.Error())
+	}
+	nx, xx := false, false
+	if len(args) == 4 {
+		switch strings.ToUpper(string(args[3])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	} else if len(args) > 4 {
+		return reply.MakeSyntaxErrReply()
+	}
+
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		doc, errReply := getAsDocument(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		if doc == nil {
+			if len(path) != 0 {
+				result = reply.MakeStandardErrorReply(document.ErrNewAtRoot.Error())
+				return
+			}
+			if xx {
+				result = reply.MakeNullBulkReply()
+				return
+			}
+			db.PutEntity(key, &idatabase.DataEntity{Data: &jsonDocument{document.MakeDocument(value)}})
+		} else {
+			set, err := doc.Set(path, value, nx, xx)
+			if err != nil {
+				result = reply.MakeStandardErrorReply(err.Error())
+				return
+			}
+			if !set {
+				result = reply.MakeNullBulkReply()
+				return
+			}
+		}
+		db.AddAof(utils.ToCmdLineWithName("JSON.SET", args...))
+		result = reply.MakeOKReply()
+	})
+	return result
+}
+
+// execJSONGet implements the JSON.GET command
+// JSON.GET key [path ...]
+// With several paths the reply is an object mapping each path to its value
+func execJSONGet(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	paths := make([]document.Path, 0, len(args)-1)
+	for i := 1; i < len(args); i++ {
+		path, errReply := parsePathArg(args, i)
+		if errReply != nil {
+			return errReply
+		}
+		paths = append(paths, path)
+	}
+
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		doc, errReply := getAsDocument(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		if doc == nil {
+			result = reply.MakeNullBulkReply()
+			return
+		}
+		if len(paths) <= 1 {
+			var path document.Path
+			if len(paths) == 1 {
+				path = paths[0]
+			}
+			value, ok := doc.Get(path)
+			if !ok {
+				result = reply.MakeStandardErrorReply(document.ErrNoSuchPath.Error())
+				return
+			}
+			result = reply.MakeBulkReply(document.Marshal(value))
+			return
+		}
+		values := make(map[string]any, len(paths))
+		for i, path := range paths {
+			value, ok := doc.Get(path)
+			if !ok {
+				result = reply.MakeStandardErrorReply(document.ErrNoSuchPath.Error())
+				return
+			}
+			values[string(args[i+1])] = value
+		}
+		result = reply.MakeBulkReply(document.Marshal(values))
+	})
+	return result
+}
+
+// execJSONDel implements the JSON.DEL command
+// JSON.DEL key [path]
+// Deleting the root removes the key
+func execJSONDel(db *database.DB, args [][]byte) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeArgNumErrReply("json.del")
+	}
+	key := string(args[0])
+	path, errReply := parsePathArg(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		doc, errReply := getAsDocument(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		if doc == nil {
+			result = reply.MakeIntReply(0)
+			return
+		}
+		if len(path) == 0 {
+			db.Remove(key)
+		} else if !doc.Delete(path) {
+			result = reply.MakeIntReply(0)
+			return
+		}
+		db.AddAof(utils.ToCmdLineWithName("JSON.DEL", args...))
+		result = reply.MakeIntReply(1)
+	})
+	return result
+}
+
+// execJSONNumIncrBy implements the JSON.NUMINCRBY command
+// JSON.NUMINCRBY key path number
+func execJSONNumIncrBy(db *database.DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	path, errReply := parsePathArg(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+	value, err := document.Parse(args[2])
+	increment, ok := value.(json.Number)
+	if err != nil || !ok {
+		return reply.MakeStandardErrorReply("ERR increment is not a number")
+	}
+
+	var result resp.Reply
+	db.WithKeyLock(key, func() {
+		doc, errReply := getAsDocument(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		if doc == nil {
+			result = reply.MakeStandardErrorReply("ERR could not perform this operation on a key that doesn't exist")
+			return
+		}
+		number, err := doc.IncrBy(path, increment)
+		if err != nil {
+			result = reply.MakeStandardErrorReply(err.Error())
+			return
+		}
+		db.AddAof(utils.ToCmdLineWithName("JSON.NUMINCRBY", args...))
+		result = reply.MakeBulkReply([]byte(number))
+	})
+	return result
+}
+
+// execJSONType implements the JSON.TYPE command
+// JSON.TYPE key [path]
+func execJSONType(db *database.DB, args [][]byte) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeArgNumErrReply("json.type")
+	}
+	key := string(args[0])
+	path, errReply := parsePathArg(args, 1)
+	if errReply != nil {
+		return errReply
+	}
+
+	var result resp.Reply
+	db.WithKeyRLock(key, func() {
+		doc, errReply := getAsDocument(db, key)
+		if errReply != nil {
+			result = errReply
+			return
+		}
+		if doc == nil {
+			result = reply.MakeNullBulkReply()
+			return
+		}
+		value, ok := doc.Get(path)
+		if !ok {
+			result = reply.MakeNullBulkReply()
+			return
+		}
+		result = reply.MakeStatusReply(document.TypeOf(value))
+	})
+	return result
+}
@@ -0,0 +1,30 @@
+// Package rejson provides the JSON.* commands as a module, storing parsed JSON documents
+// It is enabled by importing the package:
+//
+//	import _ "redigo/module/rejson"
+package rejson
+
+import (
+	"redigo/database"
+	"redigo/lib/logger"
+)
+
+func init() {
+	err := database.RegisterModule(&database.Module{
+		Name:    "ReJSON",
+		Version: 1,
+		Commands: []database.ModuleCommand{
+			{Name: "JSON.SET", Exec: execJSONSet, Arity: -4},                   // JSON.SET key path value [NX|XX]
+			{Name: "JSON.GET", Exec: execJSONGet, Arity: -2, ReadOnly: true},   // JSON.GET key [path ...]
+			{Name: "JSON.DEL", Exec: execJSONDel, Arity: -2},                   // JSON.DEL key [path]
+			{Name: "JSON.NUMINCRBY", Exec: execJSONNumIncrBy, Arity: 4},        // JSON.NUMINCRBY key path number
+			{Name: "JSON.TYPE", Exec: execJSONType, Arity: -2, ReadOnly: true}, // JSON.TYPE key [path]
+		},
+		Types: map[string]database.ModuleTypeLoader{
+			"ReJSON-RL": loadJSONDocument,
+		},
+	})
+	if err != nil {
+		logger.Error("failed to register module ReJSON: " + err.Error())
+	}
+}
@@ -0,0 +1,235 @@
+package pubsub
+
+import (
+	"errors"
+	"redigo/interface/resp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutputLimit is the client-output-buffer-limit of the subscribers: the bytes waiting to be
+// written to a subscriber, over which it is disconnected like in Redis
+type OutputLimit struct {
+	// Hard disconnects the subscriber as soon as it is exceeded, 0 means no limit
+	Hard int64
+	// Soft disconnects the subscriber when it stays exceeded for SoftSeconds, 0 means no limit
+	Soft        int64
+	SoftSeconds int
+}
+
+// DefaultOutputLimit is the limit of the pubsub class in Redis: 32mb 8mb 60
+var DefaultOutputLimit = OutputLimit{Hard: 32 << 20, Soft: 8 << 20, SoftSeconds: 60}
+
+// ParseOutputLimit returns the limit of the pubsub class of a client-output-buffer-limit value,
+// made of groups "<class> <hard> <soft> <soft seconds>" like in Redis, the classes normal and
+// replica are accepted but not enforced. Without a pubsub group the default limit is returned
+func ParseOutputLimit(value string) (OutputLimit, error) {
+	limit := DefaultOutputLimit
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return limit, errors.New("wrong number of arguments")
+	}
+	for i := 0; i < len(fields); i += 4 {
+		class := strings.ToLower(fields[i])
+		if class != "normal" && class != "replica" && class != "slave" && class != "pubsub" {
+			return limit, errors.New("invalid client class " + fields[i])
+		}
+		hard, err := parseMemory(fields[i+1])
+		if err != nil {
+			return limit, err
+		}
+		soft, err := parseMemory(fields[i+2])
+		if err != nil {
+			return limit, err
+		}
+		seconds, err := strconv.Atoi(fields[i+3])
+		if err != nil || seconds < 0 {
+			return limit, errors.New("invalid soft limit seconds " + fields[i+3])
+		}
+		if class == "pubsub" {
+			limit = OutputLimit{Hard: hard, Soft: soft, SoftSeconds: seconds}
+		}
+	}
+	return limit, nil
+}
+
+// memoryUnits are the units of the memory sizes of Redis, k is 1000 and kb is 1024
+var memoryUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+	{"g", 1000 * 1000 * 1000}, {"m", 1000 * 1000}, {"k", 1000}, {"b", 1},
+}
+
+func parseMemory(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	factor := int64(1)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower, factor = strings.TrimSuffix(lower, unit.suffix), unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/factor {
+		return 0, errors.New("invalid memory size " + value)
+	}
+	return n * factor, nil
+}
+
+// queued is a message waiting in an outbox, done is closed once it is written if not nil
+type queued struct {
+	data []byte
+	done chan struct{}
+}
+
+// outbox writes the messages of a subscriber from its own goroutine, so that a slow subscriber
+// never delays the publishers; its queue is bounded by the OutputLimit of the hub
+type outbox struct {
+	conn  resp.Connection
+	mu    sync.Mutex
+	wake  chan struct{}
+	queue []queued
+	// size is the number of bytes queued, overSoft the time it went over the soft limit
+	size     int64
+	overSoft time.Time
+	// closing accepts no more messages and stops the writer once the queue is written, dropped
+	// also discards the queue; exited is set by the writer when it returns
+	closing bool
+	dropped bool
+	exited  bool
+}
+
+func newOutbox(c resp.Connection) *outbox {
+	o := &outbox{conn: c, wake: make(chan struct{}, 1)}
+	go o.write()
+	return o
+}
+
+func (o *outbox) write() {
+	for {
+		o.mu.Lock()
+		if len(o.queue) == 0 {
+			if o.closing {
+				o.exited = true
+				o.mu.Unlock()
+				return
+			}
+			o.mu.Unlock()
+			<-o.wake
+			continue
+		}
+		item := o.queue[0]
+		o.queue[0] = queued{}
+		o.queue = o.queue[1:]
+		o.mu.Unlock()
+
+		var err error
+		if item.data != nil {
+			err = o.conn.Write(item.data)
+		}
+		if item.done != nil {
+			close(item.done)
+		}
+		o.mu.Lock()
+		if !o.dropped {
+			o.size -= int64(len(item.data))
+		}
+		if len(o.queue) == 0 {
+			// the written messages are released with the array instead of growing it forever
+			o.queue = nil
+		}
+		o.mu.Unlock()
+		if err != nil {
+			o.drop()
+		}
+	}
+}
+
+func (o *outbox) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// push queues a message, it returns false if the subscriber is over the limit
+func (o *outbox) push(data []byte, limit *OutputLimit) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closing {
+		return true
+	}
+	o.queue = append(o.queue, queued{data: data})
+	o.size += int64(len(data))
+	o.signal()
+	if limit.Hard > 0 && o.size > limit.Hard {
+		return false
+	}
+	if limit.Soft > 0 && o.size > limit.Soft {
+		now := time.Now()
+		if o.overSoft.IsZero() {
+			o.overSoft = now
+		} else if now.Sub(o.overSoft) >= time.Duration(limit.SoftSeconds)*time.Second {
+			return false
+		}
+	} else {
+		o.overSoft = time.Time{}
+	}
+	return true
+}
+
+// confirm queues a reply of the subscribe commands, which is not limited, it returns a channel
+// closed once the reply is written or dropped
+func (o *outbox) confirm(data []byte) <-chan struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	done := make(chan struct{})
+	if o.closing {
+		close(done)
+		return done
+	}
+	o.queue = append(o.queue, queued{data: data, done: done})
+	o.size += int64(len(data))
+	o.signal()
+	return done
+}
+
+// flush waits for the messages queued so far to be written
+func (o *outbox) flush() {
+	o.mu.Lock()
+	if o.exited || o.dropped {
+		o.mu.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	o.queue = append(o.queue, queued{done: done})
+	o.signal()
+	o.mu.Unlock()
+	<-done
+}
+
+// close stops the writer once the queued messages are written
+func (o *outbox) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closing = true
+	o.signal()
+}
+
+// drop stops the writer and discards the queued messages
+func (o *outbox) drop() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, item := range o.queue {
+		if item.done != nil {
+			close(item.done)
+		}
+	}
+	o.queue, o.size = nil, 0
+	o.closing, o.dropped = true, true
+	o.signal()
+}
@@ -0,0 +1,409 @@
+// Package pubsub implements publish/subscribe messaging: clients subscribe to channels or to
+// glob-style patterns of channels and receive the messages published to them
+//
+// The messages and the replies of the subscribe commands are pushed to the connections as
+// arrays in RESP2 and as push replies in RESP3. In RESP2 a subscribed connection is reserved to
+// the messages, the handler only accepts the subscribe family of commands on it
+//
+// Each subscriber is written to by its own goroutine, the publishers only queue the messages. A
+// subscriber whose queue exceeds the OutputLimit of the hub is disconnected
+package pubsub
+
+import (
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/lib/wildcard"
+	"redigo/resp/reply"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// subscriptions are the channels and the patterns a connection is subscribed to
+type subscriptions struct {
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	out      *outbox
+}
+
+func (s *subscriptions) count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// pattern is a subscribed pattern with its subscribers
+type pattern struct {
+	matcher     *wildcard.Pattern
+	subscribers map[resp.Connection]struct{}
+}
+
+// Hub holds the subscriptions of the connections and delivers the published messages
+type Hub struct {
+	mu       sync.RWMutex
+	channels map[string]map[resp.Connection]struct{}
+	patterns map[string]*pattern
+	clients  map[resp.Connection]*subscriptions
+	limit    atomic.Pointer[OutputLimit]
+}
+
+// MakeHub creates a hub without subscriptions, with the DefaultOutputLimit
+func MakeHub() *Hub {
+	h := &Hub{
+		channels: make(map[string]map[resp.Connection]struct{}),
+		patterns: make(map[string]*pattern),
+		clients:  make(map[resp.Connection]*subscriptions),
+	}
+	h.SetOutputLimit(DefaultOutputLimit)
+	return h
+}
+
+// SetOutputLimit sets the limit of the messages queued for each subscriber
+func (h *Hub) SetOutputLimit(limit OutputLimit) {
+	h.limit.Store(&limit)
+}
+
+// format returns a message in the shape of the protocol of the connection
+func format(c resp.Connection, args ...resp.Reply) []byte {
+	return reply.ForProtocol(reply.MakePushReply(args), c.GetProtocol()).ToBytes()
+}
+
+// confirm queues a reply of the subscribe commands, it returns a channel closed once written
+func confirm(c resp.Connection, s *subscriptions, args ...resp.Reply) <-chan struct{} {
+	return s.out.confirm(format(c, args...))
+}
+
+func bulk(s string) resp.Reply {
+	return reply.MakeBulkReply([]byte(s))
+}
+
+// clientOf returns the subscriptions of the connection, creating them if needed, h.mu must be held
+func (h *Hub) clientOf(c resp.Connection) *subscriptions {
+	s, ok := h.clients[c]
+	if !ok {
+		s = &subscriptions{
+			channels: make(map[string]struct{}),
+			patterns: make(map[string]struct{}),
+			out:      newOutbox(c),
+		}
+		h.clients[c] = s
+	}
+	return s
+}
+
+// release forgets the connection once it has no subscription left, h.mu must be held
+func (h *Hub) release(c resp.Connection, s *subscriptions) {
+	c.SetSubscriptions(s.count())
+	if s.count() == 0 {
+		delete(h.clients, c)
+		s.out.close()
+	}
+}
+
+// Subscribe subscribes the connection to the channels, confirming each of them with the number
+// of subscriptions of the connection
+// SUBSCRIBE channel [channel ...]
+func (h *Hub) Subscribe(c resp.Connection, args [][]byte) resp.Reply {
+	h.mu.Lock()
+	s := h.clientOf(c)
+	var written <-chan struct{}
+	for _, arg := range args {
+		channel := string(arg)
+		if _, ok := s.channels[channel]; !ok {
+			s.channels[channel] = struct{}{}
+			if h.channels[channel] == nil {
+				h.channels[channel] = make(map[resp.Connection]struct{})
+			}
+			h.channels[channel][c] = struct{}{}
+		}
+		c.SetSubscriptions(s.count())
+		written = confirm(c, s, bulk("subscribe"), bulk(channel), reply.MakeIntReply(int64(s.count())))
+	}
+	h.mu.Unlock()
+	if written != nil {
+		<-written
+	}
+	return reply.MakeNoReply()
+}
+
+// Unsubscribe unsubscribes the connection from the channels, or from all its channels without
+// argument
+// UNSUBSCRIBE [channel ...]
+func (h *Hub) Unsubscribe(c resp.Connection, args [][]byte) resp.Reply {
+	h.mu.Lock()
+	s := h.clientOf(c)
+	var written <-chan struct{}
+	channels := toStrings(args)
+	if len(channels) == 0 {
+		channels = sortedKeys(s.channels)
+	}
+	if len(channels) == 0 {
+		written = confirm(c, s, bulk("unsubscribe"), reply.MakeNullBulkReply(), reply.MakeIntReply(int64(s.count())))
+	}
+	for _, channel := range channels {
+		if _, ok := s.channels[channel]; ok {
+			delete(s.channels, channel)
+			delete(h.channels[channel], c)
+			if len(h.channels[channel]) == 0 {
+				delete(h.channels, channel)
+			}
+		}
+		written = confirm(c, s, bulk("unsubscribe"), bulk(channel), reply.MakeIntReply(int64(s.count())))
+	}
+	h.release(c, s)
+	h.mu.Unlock()
+	// the confirmations are written without the lock, after the messages queued before them
+	<-written
+	return reply.MakeNoReply()
+}
+
+// PSubscribe subscribes the connection to the patterns
+// PSUBSCRIBE pattern [pattern ...]
+func (h *Hub) PSubscribe(c resp.Connection, args [][]byte) resp.Reply {
+	h.mu.Lock()
+	s := h.clientOf(c)
+	var written <-chan struct{}
+	for _, arg := range args {
+		src := string(arg)
+		if _, ok := s.patterns[src]; !ok {
+			s.patterns[src] = struct{}{}
+			p, ok := h.patterns[src]
+			if !ok {
+				p = &pattern{
+					matcher:     wildcard.CompilePattern(src),
+					subscribers: make(map[resp.Connection]struct{}),
+				}
+				h.patterns[src] = p
+			}
+			p.subscribers[c] = struct{}{}
+		}
+		c.SetSubscriptions(s.count())
+		written = confirm(c, s, bulk("psubscribe"), bulk(src), reply.MakeIntReply(int64(s.count())))
+	}
+	h.mu.Unlock()
+	if written != nil {
+		<-written
+	}
+	return reply.MakeNoReply()
+}
+
+// PUnsubscribe unsubscribes the connection from the patterns, or from all its patterns without
+// argument
+// PUNSUBSCRIBE [pattern ...]
+func (h *Hub) PUnsubscribe(c resp.Connection, args [][]byte) resp.Reply {
+	h.mu.Lock()
+	s := h.clientOf(c)
+	var written <-chan struct{}
+	patterns := toStrings(args)
+	if len(patterns) == 0 {
+		patterns = sortedKeys(s.patterns)
+	}
+	if len(patterns) == 0 {
+		written = confirm(c, s, bulk("punsubscribe"), reply.MakeNullBulkReply(), reply.MakeIntReply(int64(s.count())))
+	}
+	for _, src := range patterns {
+		if _, ok := s.patterns[src]; ok {
+			delete(s.patterns, src)
+			if p, ok := h.patterns[src]; ok {
+				delete(p.subscribers, c)
+				if len(p.subscribers) == 0 {
+					delete(h.patterns, src)
+				}
+			}
+		}
+		written = confirm(c, s, bulk("punsubscribe"), bulk(src), reply.MakeIntReply(int64(s.count())))
+	}
+	h.release(c, s)
+	h.mu.Unlock()
+	// the confirmations are written without the lock, after the messages queued before them
+	<-written
+	return reply.MakeNoReply()
+}
+
+// Publish sends the message to the subscribers of the channel and of the patterns matching it,
+// it returns the number of messages sent
+func (h *Hub) Publish(channel string, message []byte) int {
+	type delivery struct {
+		conn    resp.Connection
+		pattern string // empty for a subscription to the channel
+	}
+	h.mu.RLock()
+	deliveries := make([]delivery, 0, len(h.channels[channel]))
+	for c := range h.channels[channel] {
+		deliveries = append(deliveries, delivery{conn: c})
+	}
+	for src, p := range h.patterns {
+		if p.matcher.IsMatch(channel) {
+			for c := range p.subscribers {
+				deliveries = append(deliveries, delivery{conn: c, pattern: src})
+			}
+		}
+	}
+	// queued under the lock so that a message published before an UNSUBSCRIBE is written before
+	// its confirmation, the subscribers write them from their own goroutines
+	limit := h.limit.Load()
+	var slow []resp.Connection
+	for _, d := range deliveries {
+		var msg []byte
+		if d.pattern == "" {
+			msg = format(d.conn, bulk("message"), bulk(channel), reply.MakeBulkReply(message))
+		} else {
+			msg = format(d.conn, bulk("pmessage"), bulk(d.pattern), bulk(channel), reply.MakeBulkReply(message))
+		}
+		if !h.clients[d.conn].out.push(msg, limit) {
+			slow = append(slow, d.conn)
+		}
+	}
+	h.mu.RUnlock()
+	for _, c := range slow {
+		h.evict(c)
+	}
+	return len(deliveries)
+}
+
+// evict disconnects a subscriber over the output limit, its queued messages are discarded
+func (h *Hub) evict(c resp.Connection) {
+	if !h.remove(c) {
+		// evicted by another publisher
+		return
+	}
+	c.SetSubscriptions(0)
+	logger.Warn("pubsub subscriber over client-output-buffer-limit, disconnected")
+	if closer, ok := c.(interface{ Close() error }); ok {
+		// closing waits for the reply being written, the publisher is not delayed
+		go func() {
+			_ = closer.Close()
+		}()
+	}
+}
+
+// Disconnect removes the subscriptions of a closed connection
+func (h *Hub) Disconnect(c resp.Connection) {
+	h.remove(c)
+}
+
+// remove removes the subscriptions of the connection, it returns false if it had none
+func (h *Hub) remove(c resp.Connection) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.clients[c]
+	if !ok {
+		return false
+	}
+	for channel := range s.channels {
+		delete(h.channels[channel], c)
+		if len(h.channels[channel]) == 0 {
+			delete(h.channels, channel)
+		}
+	}
+	for src := range s.patterns {
+		if p, ok := h.patterns[src]; ok {
+			delete(p.subscribers, c)
+			if len(p.subscribers) == 0 {
+				delete(h.patterns, src)
+			}
+		}
+	}
+	delete(h.clients, c)
+	s.out.drop()
+	return true
+}
+
+// Channels returns the channels with subscribers, matching the pattern if it is not empty
+func (h *Hub) Channels(match string) []string {
+	var matcher *wildcard.Pattern
+	if match != "" {
+		matcher = wildcard.CompilePattern(match)
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	channels := make([]string, 0, len(h.channels))
+	for channel := range h.channels {
+		if matcher == nil || matcher.IsMatch(channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// NumSub returns the number of subscribers of the channel, not counting the patterns
+func (h *Hub) NumSub(channel string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.channels[channel])
+}
+
+// NumPat returns the number of subscriptions to patterns of all connections
+func (h *Hub) NumPat() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n := 0
+	for _, p := range h.patterns {
+		n += len(p.subscribers)
+	}
+	return n
+}
+
+// ExecPubSub implements the PUBSUB command
+// PUBSUB CHANNELS [pattern]
+// PUBSUB NUMSUB [channel ...]
+// PUBSUB NUMPAT
+func (h *Hub) ExecPubSub(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("pubsub")
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "CHANNELS":
+		if len(args) > 2 {
+			return reply.MakeArgNumErrReply("pubsub|channels")
+		}
+		match := ""
+		if len(args) == 2 {
+			match = string(args[1])
+		}
+		channels := h.Channels(match)
+		lines := make([][]byte, len(channels))
+		for i, channel := range channels {
+			lines[i] = []byte(channel)
+		}
+		return reply.MakeMultiBulkReply(lines)
+	case "NUMSUB":
+		replies := make([]resp.Reply, 0, 2*(len(args)-1))
+		for _, arg := range args[1:] {
+			replies = append(replies, reply.MakeBulkReply(arg), reply.MakeIntReply(int64(h.NumSub(string(arg)))))
+		}
+		return reply.MakeMultiRawReply(replies)
+	case "NUMPAT":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("pubsub|numpat")
+		}
+		return reply.MakeIntReply(int64(h.NumPat()))
+	}
+	return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[0]) + "'. Try PUBSUB CHANNELS, PUBSUB NUMSUB or PUBSUB NUMPAT.")
+}
+
+// ExecPublish implements the PUBLISH command, it replies the number of messages sent
+// PUBLISH channel message
+func (h *Hub) ExecPublish(args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("publish")
+	}
+	return reply.MakeIntReply(int64(h.Publish(string(args[0]), args[1])))
+}
+
+func toStrings(args [][]byte) []string {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		values[i] = string(arg)
+	}
+	return values
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
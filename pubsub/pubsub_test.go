@@ -0,0 +1,246 @@
+package pubsub
+
+import (
+	"bytes"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a connection recording what is written to it
+type recorder struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	protocol int
+	subs     int
+	// stuck blocks the writes until it is closed, like a client which stops reading
+	stuck  chan struct{}
+	closed bool
+}
+
+func (r *recorder) Write(b []byte) error {
+	if r.stuck != nil {
+		<-r.stuck
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf.Write(b)
+	return nil
+}
+
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func (r *recorder) GetDBIndex() int { return 0 }
+func (r *recorder) SelectDB(int)    {}
+func (r *recorder) GetProtocol() int {
+	if r.protocol == 0 {
+		return reply.RESP2
+	}
+	return r.protocol
+}
+func (r *recorder) SetProtocol(protocol int) { r.protocol = protocol }
+func (r *recorder) GetSubscriptions() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.subs
+}
+func (r *recorder) SetSubscriptions(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subs = n
+}
+
+// flush waits for the messages queued for the subscriber to be written
+func flush(h *Hub, c *recorder) {
+	h.mu.RLock()
+	s := h.clients[c]
+	h.mu.RUnlock()
+	if s != nil {
+		s.out.flush()
+	}
+}
+
+// take returns what was written since the last call
+func (r *recorder) take() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.buf.String()
+	r.buf.Reset()
+	return s
+}
+
+func assertWritten(t *testing.T, r *recorder, expected string) {
+	t.Helper()
+	if got := r.take(); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+// TestSubscribePublish tests the replies of SUBSCRIBE and the delivery of the messages to the
+// subscribers of the channel and of the matching patterns
+func TestSubscribePublish(t *testing.T) {
+	hub := MakeHub()
+	a, b := &recorder{}, &recorder{protocol: reply.RESP3}
+
+	hub.Subscribe(a, utils.ToCmdLine("news", "sport"))
+	assertWritten(t, a, "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$5\r\nsport\r\n:2\r\n")
+	hub.PSubscribe(b, utils.ToCmdLine("n*"))
+	assertWritten(t, b, ">3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:1\r\n")
+	if a.GetSubscriptions() != 2 || b.GetSubscriptions() != 1 {
+		t.Errorf("Expected 2 and 1 subscriptions, got %d and %d", a.subs, b.subs)
+	}
+
+	if n := hub.Publish("news", []byte("hello")); n != 2 {
+		t.Errorf("Expected 2 receivers, got %d", n)
+	}
+	flush(hub, a)
+	flush(hub, b)
+	assertWritten(t, a, "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+	assertWritten(t, b, ">4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+	if n := hub.Publish("weather", []byte("rain")); n != 0 {
+		t.Errorf("Expected no receiver, got %d", n)
+	}
+	assertReplyBytes(t, hub.ExecPubSub(utils.ToCmdLine("CHANNELS")), "*2\r\n$4\r\nnews\r\n$5\r\nsport\r\n")
+	assertReplyBytes(t, hub.ExecPubSub(utils.ToCmdLine("NUMSUB", "news", "none")), "*4\r\n$4\r\nnews\r\n:1\r\n$4\r\nnone\r\n:0\r\n")
+	assertReplyBytes(t, hub.ExecPubSub(utils.ToCmdLine("NUMPAT")), ":1\r\n")
+}
+
+// TestUnsubscribe tests UNSUBSCRIBE of some, all and no channels, and that a closed connection
+// receives no message
+func TestUnsubscribe(t *testing.T) {
+	hub := MakeHub()
+	c := &recorder{}
+	hub.Subscribe(c, utils.ToCmdLine("a", "b", "c"))
+	c.take()
+
+	hub.Unsubscribe(c, utils.ToCmdLine("b"))
+	assertWritten(t, c, "*3\r\n$11\r\nunsubscribe\r\n$1\r\nb\r\n:2\r\n")
+	hub.Unsubscribe(c, nil)
+	assertWritten(t, c, "*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:1\r\n*3\r\n$11\r\nunsubscribe\r\n$1\r\nc\r\n:0\r\n")
+	hub.Unsubscribe(c, nil)
+	assertWritten(t, c, "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n")
+	if c.GetSubscriptions() != 0 || len(hub.clients) != 0 || len(hub.channels) != 0 {
+		t.Errorf("Expected no subscription left, got %d", c.GetSubscriptions())
+	}
+
+	hub.PSubscribe(c, utils.ToCmdLine("*"))
+	hub.Disconnect(c)
+	c.take()
+	if n := hub.Publish("a", []byte("x")); n != 0 {
+		t.Errorf("Expected the closed connection not to receive the message, got %d receivers", n)
+	}
+	assertWritten(t, c, "")
+}
+
+func assertReplyBytes(t *testing.T, r interface{ ToBytes() []byte }, expected string) {
+	t.Helper()
+	if got := string(r.ToBytes()); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+// TestMessagesBeforeUnsubscribe tests that the messages queued before UNSUBSCRIBE are written
+// before its confirmation
+func TestMessagesBeforeUnsubscribe(t *testing.T) {
+	hub := MakeHub()
+	c := &recorder{}
+	hub.Subscribe(c, utils.ToCmdLine("a"))
+	c.take()
+	for i := 0; i < 100; i++ {
+		hub.Publish("a", []byte("x"))
+	}
+	hub.Unsubscribe(c, nil)
+	got := c.take()
+	if !strings.HasSuffix(got, "*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:0\r\n") ||
+		strings.Count(got, "message") != 100 {
+		t.Errorf("Expected 100 messages then the confirmation, got %q", got)
+	}
+}
+
+// TestSlowSubscriber tests that a subscriber which stops reading does not delay the publishers,
+// and is disconnected once its queue exceeds the output limit
+func TestSlowSubscriber(t *testing.T) {
+	hub := MakeHub()
+	hub.SetOutputLimit(OutputLimit{Hard: 1 << 20})
+	stuck, fast := &recorder{}, &recorder{}
+	hub.Subscribe(stuck, utils.ToCmdLine("news"))
+	hub.Subscribe(fast, utils.ToCmdLine("news"))
+	stuck.stuck = make(chan struct{})
+	defer close(stuck.stuck)
+
+	message := []byte(strings.Repeat("x", 1024))
+	start := time.Now()
+	for i := 0; i < 2000; i++ {
+		hub.Publish("news", message)
+		if i%100 == 0 {
+			// the other subscriber keeps up
+			flush(hub, fast)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the publishers not to wait for the stuck subscriber, took %v", elapsed)
+	}
+	if n := hub.NumSub("news"); n != 1 {
+		t.Errorf("Expected the stuck subscriber to be unsubscribed, got %d subscribers", n)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !stuck.isClosed() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !stuck.isClosed() || stuck.GetSubscriptions() != 0 {
+		t.Error("Expected the stuck subscriber to be closed")
+	}
+	flush(hub, fast)
+	if got := fast.take(); strings.Count(got, "message") != 2000 {
+		t.Errorf("Expected the other subscriber to receive the 2000 messages, got %d", strings.Count(got, "message"))
+	}
+}
+
+// TestSoftOutputLimit tests that a subscriber is disconnected when it stays over the soft limit
+func TestSoftOutputLimit(t *testing.T) {
+	hub := MakeHub()
+	hub.SetOutputLimit(OutputLimit{Soft: 100, SoftSeconds: 0})
+	c := &recorder{}
+	hub.Subscribe(c, utils.ToCmdLine("news"))
+	c.stuck = make(chan struct{})
+	defer close(c.stuck)
+	message := []byte(strings.Repeat("x", 200))
+	hub.Publish("news", message)
+	if n := hub.NumSub("news"); n != 1 {
+		t.Errorf("Expected the subscriber to stay while it just went over the soft limit, got %d", n)
+	}
+	hub.Publish("news", message)
+	if n := hub.NumSub("news"); n != 0 {
+		t.Errorf("Expected the subscriber over the soft limit to be unsubscribed, got %d", n)
+	}
+}
+
+func (r *recorder) isClosed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+// TestParseOutputLimit tests the client-output-buffer-limit values
+func TestParseOutputLimit(t *testing.T) {
+	limit, err := ParseOutputLimit("normal 0 0 0 replica 256mb 64mb 60 pubsub 1gb 2k 30")
+	if err != nil || limit != (OutputLimit{Hard: 1 << 30, Soft: 2000, SoftSeconds: 30}) {
+		t.Errorf("Expected the limit of pubsub, got %v %v", limit, err)
+	}
+	if limit, err := ParseOutputLimit(""); err != nil || limit != DefaultOutputLimit {
+		t.Errorf("Expected the default limit, got %v %v", limit, err)
+	}
+	for _, value := range []string{"pubsub 1mb 1mb", "other 0 0 0", "pubsub 1xb 0 0", "pubsub -1 0 0", "pubsub 0 0 -1"} {
+		if _, err := ParseOutputLimit(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
@@ -0,0 +1,515 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Object is a key read from a snapshot, the field matching Type holds the value
+type Object struct {
+	DB       int
+	Key      string
+	Type     byte
+	ExpireAt int64 // unix time in milliseconds, 0 if the key does not expire
+
+	String  []byte
+	List    [][]byte
+	Set     []string
+	Hash    map[string]string
+	ZSet    []ZSetMember
+	Stream  *StreamValue
+	Module  *ModuleValue
+	Aux     map[string]string // aux fields read so far
+	Version int
+}
+
+// TypeName returns the name of the type of the key as reported by TYPE, module values are named
+// after their module type
+func (obj *Object) TypeName() string {
+	switch obj.Type {
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeHash:
+		return "hash"
+	case TypeZSet2:
+		return "zset"
+	case TypeStream:
+		return "stream"
+	case TypeModule:
+		return "module:" + obj.Module.TypeName
+	}
+	return "type " + strconv.Itoa(int(obj.Type))
+}
+
+// StreamValue is the value of a stream key
+type StreamValue struct {
+	LastMs  uint64
+	LastSeq uint64
+	Entries []StreamEntry
+}
+
+// ModuleValue is the value of a module key
+type ModuleValue struct {
+	TypeName string
+	Payload  []byte
+}
+
+// Decoder reads a snapshot
+type Decoder struct {
+	r       *bufio.Reader
+	crc     uint64
+	offset  int64
+	buf     [8]byte
+	version int
+	aux     map[string]string
+	// dump reads values without key, from the payload of DUMP
+	dump bool
+}
+
+// NewDecoder creates a decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), aux: make(map[string]string)}
+}
+
+// Offset returns the number of bytes read so far, to locate errors
+func (d *Decoder) Offset() int64 {
+	return d.offset
+}
+
+func (d *Decoder) readFull(p []byte) error {
+	n, err := io.ReadFull(d.r, p)
+	d.offset += int64(n)
+	d.crc = CRC64(d.crc, p[:n])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	err := d.readFull(d.buf[:1])
+	return d.buf[0], err
+}
+
+// readLength reads a length, encoded is true if the length is the type of a specially encoded string
+func (d *Decoder) readLength() (n uint64, encoded bool, err error) {
+	first, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case len6Bit:
+		return uint64(first & 0x3f), false, nil
+	case len14Bit:
+		next, err := d.readByte()
+		return uint64(first&0x3f)<<8 | uint64(next), false, err
+	case lenEncVal:
+		return uint64(first & 0x3f), true, nil
+	}
+	switch first {
+	case len32Bit:
+		err = d.readFull(d.buf[:4])
+		return uint64(binary.BigEndian.Uint32(d.buf[:4])), false, err
+	case len64Bit:
+		err = d.readFull(d.buf[:8])
+		return binary.BigEndian.Uint64(d.buf[:8]), false, err
+	}
+	return 0, false, fmt.Errorf("%w: bad length encoding 0x%x at offset %d", ErrBadFormat, first, d.offset-1)
+}
+
+// readPlainLength reads a length which may not be a special encoding
+func (d *Decoder) readPlainLength() (uint64, error) {
+	n, encoded, err := d.readLength()
+	if err == nil && encoded {
+		err = fmt.Errorf("%w: unexpected encoded value at offset %d", ErrBadFormat, d.offset)
+	}
+	return n, err
+}
+
+// readCount reads the number of elements of a collection, bounded to reject absurd values
+func (d *Decoder) readCount() (int, error) {
+	n, err := d.readPlainLength()
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt32 {
+		return 0, fmt.Errorf("%w: collection too large at offset %d", ErrBadFormat, d.offset)
+	}
+	return int(n), nil
+}
+
+func (d *Decoder) readString() ([]byte, error) {
+	n, encoded, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		if n > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: string too large at offset %d", ErrBadFormat, d.offset)
+		}
+		s := make([]byte, n)
+		err = d.readFull(s)
+		return s, err
+	}
+	switch n {
+	case encInt8:
+		b, err := d.readByte()
+		return []byte(strconv.Itoa(int(int8(b)))), err
+	case encInt16:
+		err = d.readFull(d.buf[:2])
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(d.buf[:2]))))), err
+	case encInt32:
+		err = d.readFull(d.buf[:4])
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(d.buf[:4]))))), err
+	case encLZF:
+		compressedLen, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, err := d.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		if compressedLen > math.MaxInt32 || rawLen > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: string too large at offset %d", ErrBadFormat, d.offset)
+		}
+		compressed := make([]byte, compressedLen)
+		if err := d.readFull(compressed); err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(rawLen))
+	}
+	return nil, fmt.Errorf("%w: unknown string encoding %d at offset %d", ErrBadFormat, n, d.offset)
+}
+
+func (d *Decoder) readBinaryDouble() (float64, error) {
+	err := d.readFull(d.buf[:8])
+	return math.Float64frombits(binary.LittleEndian.Uint64(d.buf[:8])), err
+}
+
+// readHeader checks the magic string and reads the version
+func (d *Decoder) readHeader() error {
+	header := make([]byte, 9)
+	if err := d.readFull(header); err != nil {
+		return ErrBadMagic
+	}
+	if string(header[:5]) != magic {
+		return ErrBadMagic
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 {
+		return ErrBadMagic
+	}
+	d.version = version
+	return nil
+}
+
+// Decode reads the snapshot and calls handler for each key
+// The checksum is verified at the end if the file has one
+func (d *Decoder) Decode(handler func(obj *Object) error) error {
+	if err := d.readHeader(); err != nil {
+		return err
+	}
+	db := 0
+	var expireAt int64
+	for {
+		opcode, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case opcodeEOF:
+			return d.readChecksum()
+		case opcodeAux:
+			key, err := d.readString()
+			if err != nil {
+				return err
+			}
+			value, err := d.readString()
+			if err != nil {
+				return err
+			}
+			d.aux[string(key)] = string(value)
+			continue
+		case opcodeSelectDB:
+			n, err := d.readPlainLength()
+			if err != nil {
+				return err
+			}
+			db = int(n)
+			continue
+		case opcodeResizeDB:
+			if _, err := d.readPlainLength(); err != nil {
+				return err
+			}
+			if _, err := d.readPlainLength(); err != nil {
+				return err
+			}
+			continue
+		case opcodeExpireTimeMs:
+			if err := d.readFull(d.buf[:8]); err != nil {
+				return err
+			}
+			expireAt = int64(binary.LittleEndian.Uint64(d.buf[:8]))
+			continue
+		case opcodeExpireTime:
+			if err := d.readFull(d.buf[:4]); err != nil {
+				return err
+			}
+			expireAt = int64(binary.LittleEndian.Uint32(d.buf[:4])) * 1000
+			continue
+		}
+		obj, err := d.readObject(opcode)
+		if err != nil {
+			return err
+		}
+		obj.DB = db
+		obj.ExpireAt = expireAt
+		obj.Aux = d.aux
+		obj.Version = d.version
+		expireAt = 0
+		if err := handler(obj); err != nil {
+			return err
+		}
+	}
+}
+
+// DecodeDump reads the value of the payload of DUMP, the returned object has no key
+// ErrBadDump is returned if the checksum is wrong or the version is newer than Version, like
+// Redis refuses the payloads of newer versions
+func DecodeDump(payload []byte) (*Object, error) {
+	if len(payload) < 11 {
+		return nil, ErrBadDump
+	}
+	body := payload[:len(payload)-10]
+	version := binary.LittleEndian.Uint16(payload[len(payload)-10:])
+	checksum := binary.LittleEndian.Uint64(payload[len(payload)-8:])
+	if version > Version || checksum != CRC64(0, payload[:len(payload)-8]) {
+		return nil, ErrBadDump
+	}
+	d := NewDecoder(bytes.NewReader(body))
+	d.dump = true
+	d.version = int(version)
+	valueType, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	obj, err := d.readObject(valueType)
+	if err != nil {
+		return nil, err
+	}
+	if d.offset != int64(len(body)) {
+		return nil, fmt.Errorf("%w: %d bytes after the value", ErrBadFormat, int64(len(body))-d.offset)
+	}
+	obj.Version = d.version
+	return obj, nil
+}
+
+func (d *Decoder) readChecksum() error {
+	// versions before 5 have no checksum
+	if d.version < 5 {
+		return nil
+	}
+	expected := d.crc
+	if _, err := io.ReadFull(d.r, d.buf[:8]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	d.offset += 8
+	actual := binary.LittleEndian.Uint64(d.buf[:8])
+	// a zero checksum means checksums were disabled when saving
+	if actual != 0 && actual != expected {
+		return ErrBadChecksum
+	}
+	return nil
+}
+
+func (d *Decoder) readObject(valueType byte) (*Object, error) {
+	obj := &Object{Type: valueType}
+	var err error
+	if !d.dump {
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		obj.Key = string(key)
+	}
+	switch valueType {
+	case TypeString:
+		obj.String, err = d.readString()
+	case TypeList:
+		obj.List, err = d.readStrings()
+	case TypeSet:
+		var members [][]byte
+		members, err = d.readStrings()
+		obj.Set = make([]string, len(members))
+		for i, m := range members {
+			obj.Set[i] = string(m)
+		}
+	case TypeHash:
+		obj.Hash, err = d.readHash()
+	case TypeZSet2:
+		obj.ZSet, err = d.readZSet()
+	case TypeStream:
+		obj.Stream, err = d.readStream()
+	case TypeModule:
+		obj.Module, err = d.readModule()
+	default:
+		return nil, fmt.Errorf("%w: unsupported value type %d at offset %d", ErrBadFormat, valueType, d.offset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (d *Decoder) readStrings() ([][]byte, error) {
+	n, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		v, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (d *Decoder) readHash() (map[string]string, error) {
+	n, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, min(n, 1024))
+	for i := 0; i < n; i++ {
+		f, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		fields[string(f)] = string(v)
+	}
+	return fields, nil
+}
+
+func (d *Decoder) readZSet() ([]ZSetMember, error) {
+	n, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]ZSetMember, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		m, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		score, err := d.readBinaryDouble()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, ZSetMember{Member: string(m), Score: score})
+	}
+	return members, nil
+}
+
+func (d *Decoder) readStream() (*StreamValue, error) {
+	var s StreamValue
+	var err error
+	if s.LastMs, err = d.readPlainLength(); err != nil {
+		return nil, err
+	}
+	if s.LastSeq, err = d.readPlainLength(); err != nil {
+		return nil, err
+	}
+	n, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	s.Entries = make([]StreamEntry, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		var entry StreamEntry
+		if entry.Ms, err = d.readPlainLength(); err != nil {
+			return nil, err
+		}
+		if entry.Seq, err = d.readPlainLength(); err != nil {
+			return nil, err
+		}
+		if entry.Fields, err = d.readStrings(); err != nil {
+			return nil, err
+		}
+		s.Entries = append(s.Entries, entry)
+	}
+	return &s, nil
+}
+
+func (d *Decoder) readModule() (*ModuleValue, error) {
+	typeName, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+	return &ModuleValue{TypeName: string(typeName), Payload: payload}, nil
+}
+
+// lzfDecompress decompresses data compressed with LZF, as used by Redis for long strings
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	errCorrupted := errors.New("rdb: corrupted LZF string")
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// literal run of ctrl+1 bytes
+			ctrl++
+			if i+ctrl > len(in) {
+				return nil, errCorrupted
+			}
+			out = append(out, in[i:i+ctrl]...)
+			i += ctrl
+			continue
+		}
+		// back reference
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errCorrupted
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errCorrupted
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errCorrupted
+		}
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errCorrupted
+	}
+	return out, nil
+}
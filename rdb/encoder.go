@@ -0,0 +1,239 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+)
+
+// ZSetMember is a member of a sorted set with its score
+type ZSetMember struct {
+	Member string
+	Score  float64
+}
+
+// StreamEntry is an entry of a stream, Fields holds the field value pairs flattened
+type StreamEntry struct {
+	Ms     uint64
+	Seq    uint64
+	Fields [][]byte
+}
+
+// Encoder writes a snapshot
+// Call WriteHeader first, then WriteDBHeader for each DB followed by its keys, then WriteEnd
+type Encoder struct {
+	w   *bufio.Writer
+	crc uint64
+	err error
+	buf [9]byte
+	// dump omits the keys, for the payload of DUMP
+	dump bool
+}
+
+// NewEncoder creates an encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w)}
+}
+
+// NewDumpEncoder creates an encoder writing a single value to w in the format of the payload of
+// DUMP: the key given to the Write method is not written, call WriteDumpEnd after the value
+func NewDumpEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w), dump: true}
+}
+
+func (e *Encoder) write(data []byte) {
+	if e.err != nil {
+		return
+	}
+	e.crc = CRC64(e.crc, data)
+	_, e.err = e.w.Write(data)
+}
+
+func (e *Encoder) writeByte(b byte) {
+	e.buf[0] = b
+	e.write(e.buf[:1])
+}
+
+func (e *Encoder) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.writeByte(byte(n))
+	case n < 1<<14:
+		e.buf[0] = byte(n>>8) | len14Bit<<6
+		e.buf[1] = byte(n)
+		e.write(e.buf[:2])
+	case n <= math.MaxUint32:
+		e.buf[0] = len32Bit
+		binary.BigEndian.PutUint32(e.buf[1:], uint32(n))
+		e.write(e.buf[:5])
+	default:
+		e.buf[0] = len64Bit
+		binary.BigEndian.PutUint64(e.buf[1:], n)
+		e.write(e.buf[:9])
+	}
+}
+
+func (e *Encoder) writeString(s []byte) {
+	e.writeLength(uint64(len(s)))
+	e.write(s)
+}
+
+func (e *Encoder) writeBinaryDouble(f float64) {
+	binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(f))
+	e.write(e.buf[:8])
+}
+
+func (e *Encoder) writeKey(valueType byte, key string) {
+	e.writeByte(valueType)
+	if !e.dump {
+		e.writeString([]byte(key))
+	}
+}
+
+// WriteHeader writes the magic string, the version and the aux fields
+func (e *Encoder) WriteHeader(aux map[string]string) error {
+	e.write([]byte(magic + strconv.Itoa(Version + 10000)[1:]))
+	for k, v := range aux {
+		e.writeByte(opcodeAux)
+		e.writeString([]byte(k))
+		e.writeString([]byte(v))
+	}
+	return e.err
+}
+
+// WriteDBHeader starts the keys of a DB, keys is the number of keys used as a hint when loading
+func (e *Encoder) WriteDBHeader(index int, keys int, expires int) error {
+	e.writeByte(opcodeSelectDB)
+	e.writeLength(uint64(index))
+	e.writeByte(opcodeResizeDB)
+	e.writeLength(uint64(keys))
+	e.writeLength(uint64(expires))
+	return e.err
+}
+
+// WriteExpire sets the expiration time of the next key, as unix time in milliseconds
+func (e *Encoder) WriteExpire(unixMs int64) error {
+	e.writeByte(opcodeExpireTimeMs)
+	binary.LittleEndian.PutUint64(e.buf[:8], uint64(unixMs))
+	e.write(e.buf[:8])
+	return e.err
+}
+
+// WriteString writes a string value
+func (e *Encoder) WriteString(key string, value []byte) error {
+	e.writeKey(TypeString, key)
+	e.writeString(value)
+	return e.err
+}
+
+// WriteList writes a list value
+func (e *Encoder) WriteList(key string, values [][]byte) error {
+	e.writeKey(TypeList, key)
+	e.writeLength(uint64(len(values)))
+	for _, v := range values {
+		e.writeString(v)
+	}
+	return e.err
+}
+
+// WriteSet writes a set value
+func (e *Encoder) WriteSet(key string, members []string) error {
+	e.writeKey(TypeSet, key)
+	e.writeLength(uint64(len(members)))
+	for _, m := range members {
+		e.writeString([]byte(m))
+	}
+	return e.err
+}
+
+// WriteHash writes a hash value
+func (e *Encoder) WriteHash(key string, fields map[string]string) error {
+	e.writeKey(TypeHash, key)
+	e.writeLength(uint64(len(fields)))
+	for f, v := range fields {
+		e.writeString([]byte(f))
+		e.writeString([]byte(v))
+	}
+	return e.err
+}
+
+// WriteZSet writes a sorted set value
+func (e *Encoder) WriteZSet(key string, members []ZSetMember) error {
+	e.writeKey(TypeZSet2, key)
+	e.writeLength(uint64(len(members)))
+	for _, m := range members {
+		e.writeString([]byte(m.Member))
+		e.writeBinaryDouble(m.Score)
+	}
+	return e.err
+}
+
+// WriteStream writes a stream value, lastMs and lastSeq are the ID of the last added entry
+func (e *Encoder) WriteStream(key string, lastMs, lastSeq uint64, entries []StreamEntry) error {
+	e.writeKey(TypeStream, key)
+	e.writeLength(lastMs)
+	e.writeLength(lastSeq)
+	e.writeLength(uint64(len(entries)))
+	for _, entry := range entries {
+		e.writeLength(entry.Ms)
+		e.writeLength(entry.Seq)
+		e.writeLength(uint64(len(entry.Fields)))
+		for _, f := range entry.Fields {
+			e.writeString(f)
+		}
+	}
+	return e.err
+}
+
+// WriteModule writes a value of a module type
+func (e *Encoder) WriteModule(key string, typeName string, payload []byte) error {
+	e.writeKey(TypeModule, key)
+	e.writeString([]byte(typeName))
+	e.writeString(payload)
+	return e.err
+}
+
+// WriteEncoded writes keys encoded by another encoder, flushed without WriteEnd, so that they
+// count in the checksum of this one
+func (e *Encoder) WriteEncoded(data []byte) error {
+	e.write(data)
+	return e.err
+}
+
+// Flush writes the buffered data to the writer, for an encoder of keys given to WriteEncoded
+func (e *Encoder) Flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// WriteEnd writes the end of file marker and the checksum, then flushes the writer
+func (e *Encoder) WriteEnd() error {
+	e.writeByte(opcodeEOF)
+	if e.err != nil {
+		return e.err
+	}
+	binary.LittleEndian.PutUint64(e.buf[:8], e.crc)
+	if _, err := e.w.Write(e.buf[:8]); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// WriteDumpEnd ends the payload of DUMP with the RDB version on 2 bytes and the checksum of the
+// whole payload on 8 bytes, like Redis, then flushes the writer
+func (e *Encoder) WriteDumpEnd() error {
+	binary.LittleEndian.PutUint16(e.buf[:2], Version)
+	e.write(e.buf[:2])
+	if e.err != nil {
+		return e.err
+	}
+	binary.LittleEndian.PutUint64(e.buf[:8], e.crc)
+	if _, err := e.w.Write(e.buf[:8]); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
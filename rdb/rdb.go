@@ -0,0 +1,88 @@
+// Package rdb reads and writes snapshots of the dataset in the RDB format of Redis
+//
+// Strings, lists, sets, hashes and sorted sets use the plain encodings of Redis, so a snapshot
+// without streams or module values can be loaded by Redis as well. Streams and module values
+// use redigo specific types (TypeStream and TypeModule).
+// The decoder also understands the integer and LZF compressed string encodings written by Redis.
+package rdb
+
+import "errors"
+
+// Version is the RDB version written in the header
+const Version = 9
+
+const magic = "REDIS"
+
+// Value types
+const (
+	TypeString = 0
+	TypeList   = 1
+	TypeSet    = 2
+	TypeHash   = 4
+	TypeZSet2  = 5 // sorted set with binary double scores
+	// TypeStream is a redigo extension: last ID, then entries of ID and field value pairs
+	TypeStream = 0xE0
+	// TypeModule is a redigo extension: module type name, then an opaque payload
+	TypeModule = 0xE1
+)
+
+// Opcodes
+const (
+	opcodeAux          = 0xFA
+	opcodeResizeDB     = 0xFB
+	opcodeExpireTimeMs = 0xFC
+	opcodeExpireTime   = 0xFD
+	opcodeSelectDB     = 0xFE
+	opcodeEOF          = 0xFF
+)
+
+// Length encodings, the type of length is given by the 2 high bits of the first byte
+const (
+	len6Bit   = 0
+	len14Bit  = 1
+	len32Bit  = 0x80
+	len64Bit  = 0x81
+	lenEncVal = 3 // the length is followed by a specially encoded value
+	encInt8   = 0
+	encInt16  = 1
+	encInt32  = 2
+	encLZF    = 3
+)
+
+var (
+	ErrBadMagic    = errors.New("rdb: not a RDB file")
+	ErrBadChecksum = errors.New("rdb: wrong checksum")
+	ErrBadFormat   = errors.New("rdb: corrupted file")
+	ErrBadDump     = errors.New("rdb: DUMP payload version or checksum are wrong")
+)
+
+// crcTable is the table of CRC-64/Jones, the checksum used by Redis
+var crcTable = makeCRCTable()
+
+// jonesPoly is the reflected polynomial of CRC-64/Jones
+const jonesPoly = 0x95ac9329ac4bc9b5
+
+func makeCRCTable() *[256]uint64 {
+	table := new([256]uint64)
+	for i := 0; i < 256; i++ {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ jonesPoly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// CRC64 updates the checksum with data
+// Unlike hash/crc64 the value is neither inverted before nor after, as in Redis
+func CRC64(crc uint64, data []byte) uint64 {
+	for _, b := range data {
+		crc = crcTable[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
@@ -0,0 +1,171 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestCRC64 tests the checksum against the check value of CRC-64/Jones used by Redis
+func TestCRC64(t *testing.T) {
+	if crc := CRC64(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Errorf("Unexpected checksum %x", crc)
+	}
+}
+
+// TestRoundTrip tests that every value type is read back as written
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.WriteHeader(map[string]string{"redis-ver": "7.0.0"})
+	enc.WriteDBHeader(0, 3, 1)
+	enc.WriteExpire(1700000000000)
+	enc.WriteString("str", []byte(strings.Repeat("v", 20000)))
+	enc.WriteList("list", [][]byte{[]byte("a"), []byte("")})
+	enc.WriteHash("hash", map[string]string{"f": "v"})
+	enc.WriteDBHeader(3, 3, 0)
+	enc.WriteSet("set", []string{"1", "x"})
+	enc.WriteZSet("zset", []ZSetMember{{Member: "m", Score: -1.5}})
+	enc.WriteStream("stream", 5, 1, []StreamEntry{{Ms: 5, Seq: 0, Fields: [][]byte{[]byte("f"), []byte("v")}}})
+	enc.WriteModule("mod", "type", []byte{0, 1, 2})
+	if err := enc.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+
+	var objects []*Object
+	err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(func(obj *Object) error {
+		objects = append(objects, obj)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 7 {
+		t.Fatalf("Expected 7 objects, got %d", len(objects))
+	}
+	if objects[0].ExpireAt != 1700000000000 || len(objects[0].String) != 20000 || objects[0].Aux["redis-ver"] != "7.0.0" {
+		t.Errorf("Unexpected string object %+v", objects[0].Key)
+	}
+	if objects[1].ExpireAt != 0 || len(objects[1].List) != 2 || string(objects[1].List[0]) != "a" {
+		t.Errorf("Unexpected list object %+v", objects[1])
+	}
+	if objects[2].Hash["f"] != "v" {
+		t.Errorf("Unexpected hash object %+v", objects[2])
+	}
+	if objects[3].DB != 3 || len(objects[3].Set) != 2 {
+		t.Errorf("Unexpected set object %+v", objects[3])
+	}
+	if objects[4].ZSet[0].Score != -1.5 {
+		t.Errorf("Unexpected zset object %+v", objects[4])
+	}
+	s := objects[5].Stream
+	if s.LastMs != 5 || s.LastSeq != 1 || len(s.Entries) != 1 || string(s.Entries[0].Fields[1]) != "v" {
+		t.Errorf("Unexpected stream object %+v", s)
+	}
+	if objects[6].Module.TypeName != "type" || !bytes.Equal(objects[6].Module.Payload, []byte{0, 1, 2}) {
+		t.Errorf("Unexpected module object %+v", objects[6].Module)
+	}
+
+	// flip a byte of the payload
+	corrupted := append([]byte{}, buf.Bytes()...)
+	corrupted[len(corrupted)-20] ^= 0xff
+	err = NewDecoder(bytes.NewReader(corrupted)).Decode(func(obj *Object) error { return nil })
+	if err == nil {
+		t.Error("Expected an error for a corrupted file")
+	}
+}
+
+// TestWriteEncoded tests that the keys encoded apart are read back and count in the checksum
+func TestWriteEncoded(t *testing.T) {
+	var key bytes.Buffer
+	keyEnc := NewEncoder(&key)
+	keyEnc.WriteExpire(1700000000000)
+	keyEnc.WriteString("str", []byte("v"))
+	if err := keyEnc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.WriteHeader(nil)
+	enc.WriteDBHeader(0, 2, 1)
+	enc.WriteEncoded(key.Bytes())
+	enc.WriteList("list", [][]byte{[]byte("a")})
+	if err := enc.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(func(obj *Object) error {
+		keys = append(keys, obj.Key)
+		if obj.Key == "str" && (obj.ExpireAt != 1700000000000 || string(obj.String) != "v") {
+			t.Errorf("Unexpected string object %+v", obj)
+		}
+		return nil
+	})
+	if err != nil || len(keys) != 2 {
+		t.Errorf("Expected the 2 keys, got %v (%v)", keys, err)
+	}
+}
+
+// TestEncodedStrings tests the integer and LZF encodings written by Redis
+func TestEncodedStrings(t *testing.T) {
+	data := []byte{
+		0xC0, 0xF6, // int8 -10
+		0xC1, 0x39, 0x30, // int16 12345
+		0xC2, 0x15, 0xCD, 0x5B, 0x07, // int32 123456789
+		// LZF "aaaaaaaaaa": literal "a", then a back reference of 9 bytes at distance 1
+		0xC3, 5, 10, 0x00, 'a', 0xE0, 0x00, 0x00,
+	}
+	d := NewDecoder(bytes.NewReader(data))
+	for _, expected := range []string{"-10", "12345", "123456789", "aaaaaaaaaa"} {
+		s, err := d.readString()
+		if err != nil || string(s) != expected {
+			t.Errorf("Expected %q, got %q (%v)", expected, s, err)
+		}
+	}
+}
+
+// TestDumpPayload tests that a value is read back from the payload of DUMP, which Redis payloads
+// of the same version can be read too, and that corrupted payloads are refused
+func TestDumpPayload(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewDumpEncoder(&buf)
+	enc.WriteZSet("ignored", []ZSetMember{{Member: "a", Score: 1}, {Member: "b", Score: 2.5}})
+	if err := enc.WriteDumpEnd(); err != nil {
+		t.Fatal(err)
+	}
+	payload := buf.Bytes()
+	obj, err := DecodeDump(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Key != "" || obj.Type != TypeZSet2 || len(obj.ZSet) != 2 || obj.ZSet[1].Score != 2.5 {
+		t.Errorf("Unexpected zset object %+v", obj)
+	}
+
+	// DUMP mykey of the Redis documentation, the integer 10 with RDB version 9
+	obj, err = DecodeDump([]byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"))
+	if err != nil || obj.Type != TypeString || string(obj.String) != "10" {
+		t.Errorf("Unexpected object %+v of the Redis payload, error %v", obj, err)
+	}
+
+	tampered := append([]byte{}, payload...)
+	tampered[3] ^= 1
+	if _, err := DecodeDump(tampered); err != ErrBadDump {
+		t.Errorf("Expected ErrBadDump for a wrong checksum, got %v", err)
+	}
+	newer := append([]byte{}, payload[:len(payload)-10]...)
+	newer = append(newer, 12, 0)
+	newer = binary.LittleEndian.AppendUint64(newer, CRC64(0, newer))
+	if _, err := DecodeDump(newer); err != ErrBadDump {
+		t.Errorf("Expected ErrBadDump for a newer version, got %v", err)
+	}
+	trailing := append([]byte{}, payload[:len(payload)-10]...)
+	trailing = append(trailing, 'x', Version, 0)
+	trailing = binary.LittleEndian.AppendUint64(trailing, CRC64(0, trailing))
+	if _, err := DecodeDump(trailing); !errors.Is(err, ErrBadFormat) {
+		t.Errorf("Expected ErrBadFormat for trailing bytes, got %v", err)
+	}
+}
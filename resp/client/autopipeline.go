@@ -0,0 +1,127 @@
+package client
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// AutoPipelineConfig stores the options of the automatic pipelining of a client
+// Above Threshold requests per second, the write goroutine waits up to Linger for more requests
+// and writes up to MaxBatch of them in one flush, as a Pipeline would. Below it, every request
+// is written on its own so that a quiet caller gets no added latency
+type AutoPipelineConfig struct {
+	Threshold int           // requests per second above which requests are batched, 0 disables batching
+	MaxBatch  int           // max number of requests written in one flush, 0 means defaultMaxBatch
+	Linger    time.Duration // max wait for more requests once a batch is started, 0 means defaultLinger
+}
+
+const (
+	defaultMaxBatch = 64
+	defaultLinger   = 100 * time.Microsecond
+	// rateWindow is the period over which the request rate is measured
+	rateWindow = 100 * time.Millisecond
+)
+
+// AutoPipelineStats counts the flushes of the write goroutine
+type AutoPipelineStats struct {
+	Requests uint64  // requests written
+	Flushes  uint64  // writes to the connection, Requests/Flushes is the mean batch size
+	Rate     float64 // request rate measured over the last window, per second
+}
+
+// SetAutoPipeline enables automatic pipelining, it must be called before Start
+func (client *Client) SetAutoPipeline(config AutoPipelineConfig) {
+	if config.MaxBatch <= 0 {
+		config.MaxBatch = defaultMaxBatch
+	}
+	if config.Linger <= 0 {
+		config.Linger = defaultLinger
+	}
+	client.autoPipeline = config
+}
+
+// AutoPipelineStats returns the counters of the write goroutine
+func (client *Client) AutoPipelineStats() AutoPipelineStats {
+	return AutoPipelineStats{
+		Requests: client.meter.written.Load(),
+		Flushes:  client.meter.flushes.Load(),
+		Rate:     client.meter.lastRate(),
+	}
+}
+
+// rateMeter measures the rate of the requests received by the write goroutine and counts its
+// flushes. It is only updated by the write goroutine, the atomic fields are read by AutoPipelineStats
+type rateMeter struct {
+	start   time.Time
+	count   int
+	rate    atomic.Uint64 // bits of the float64 rate
+	written atomic.Uint64
+	flushes atomic.Uint64
+}
+
+// add counts n requests received at now and returns the rate of the last complete window
+func (m *rateMeter) add(n int, now time.Time) float64 {
+	m.count += n
+	elapsed := now.Sub(m.start)
+	if elapsed >= rateWindow {
+		m.rate.Store(math.Float64bits(float64(m.count) / elapsed.Seconds()))
+		m.start = now
+		m.count = 0
+	}
+	return m.lastRate()
+}
+
+func (m *rateMeter) lastRate() float64 {
+	return math.Float64frombits(m.rate.Load())
+}
+
+// flushed counts a write of n requests to the connection
+func (m *rateMeter) flushed(n int) {
+	m.written.Add(uint64(n))
+	m.flushes.Add(1)
+}
+
+// batching reports whether the request rate is above the threshold of the auto pipelining
+func (client *Client) batching() bool {
+	threshold := client.autoPipeline.Threshold
+	return threshold > 0 && client.meter.add(1, time.Now()) >= float64(threshold)
+}
+
+// collectBatch returns first followed by the requests received within the linger time, up to
+// MaxBatch requests. It returns early when the pending requests are closed
+func (client *Client) collectBatch(first *request) []*request {
+	batch := []*request{first}
+	timer := time.NewTimer(client.autoPipeline.Linger)
+	defer timer.Stop()
+	for len(batch) < client.autoPipeline.MaxBatch {
+		select {
+		case req, ok := <-client.pendingReqs:
+			if !ok {
+				return batch
+			}
+			client.meter.add(1, time.Now())
+			batch = append(batch, req)
+		case <-timer.C:
+			return batch
+		}
+	}
+	return batch
+}
+
+// doRequests writes the requests of a batch in one flush, expanding the pipelines
+func (client *Client) doRequests(batch []*request) {
+	reqs := make([]*request, 0, len(batch))
+	for _, req := range batch {
+		switch {
+		case req == nil:
+		case len(req.batch) > 0:
+			reqs = append(reqs, req.batch...)
+		case len(req.args) > 0:
+			reqs = append(reqs, req)
+		}
+	}
+	if len(reqs) > 0 {
+		client.writeRequests(reqs)
+	}
+}
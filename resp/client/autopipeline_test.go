@@ -0,0 +1,56 @@
+package client
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRateMeter tests that the rate is measured over complete windows
+func TestRateMeter(t *testing.T) {
+	c := &Client{}
+	m := &c.meter
+	now := time.Now()
+	m.start = now
+	if rate := m.add(10, now.Add(rateWindow/2)); rate != 0 {
+		t.Errorf("Expected no rate before the end of the first window, got %v", rate)
+	}
+	if rate := m.add(10, now.Add(rateWindow)); rate != 200 {
+		t.Errorf("Expected 200 requests per second, got %v", rate)
+	}
+	m.flushed(3)
+	if stats := c.AutoPipelineStats(); stats.Requests != 3 || stats.Flushes != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+// TestAutoPipeline tests that the concurrent requests above the threshold are written in batches,
+// and that each caller gets its own reply
+func TestAutoPipeline(t *testing.T) {
+	c, err := MakeClient(startTestServer(t).addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetAutoPipeline(AutoPipelineConfig{Threshold: 1, Linger: time.Millisecond})
+	c.Start()
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	stop := time.Now().Add(3 * rateWindow)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(stop) {
+				if r := c.Send([][]byte{[]byte("PING")}); string(r.ToBytes()) != "+PONG\r\n" {
+					t.Errorf("Unexpected reply %q", r.ToBytes())
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := c.AutoPipelineStats(); stats.Flushes >= stats.Requests || stats.Rate < 1 {
+		t.Errorf("Expected the requests to be batched, got %+v", stats)
+	}
+}
@@ -1,13 +1,20 @@
 package client
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"net"
 	"redigo/interface/resp"
 	"redigo/lib/logger"
+	"redigo/lib/sync/atomic"
 	"redigo/lib/sync/wait"
+	"redigo/lib/utils"
 	"redigo/resp/parser"
 	"redigo/resp/reply"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,12 +22,35 @@ import (
 // Client is a pipeline mode redis client
 type Client struct {
 	conn        net.Conn
+	connMu      sync.Mutex    // protects swapping conn during reconnection, and reconnected
 	pendingReqs chan *request // wait to send
 	waitingReqs chan *request // waiting response
 	ticker      *time.Ticker
 	addr        string
 
 	working *sync.WaitGroup // its counter presents unfinished requests(pending and waiting)
+	closing atomic.Boolean  // client is closing, stop reconnecting
+	closeMu sync.RWMutex    // held for writing while pendingReqs is closed, the senders hold it for reading
+	broken  atomic.Boolean  // connection is lost, the requests fail until it is re-established
+	// reconnected is closed once the goroutine re-establishing the connection ends, nil if none runs
+	reconnected chan struct{}
+	stop        chan struct{} // closed by Close, stops the reconnection
+
+	// connection state replayed after reconnection
+	stateMu     sync.Mutex
+	authArgs    [][]byte // last successful AUTH command
+	dbIndex     int      // last successful SELECT index
+	onReconnect func(client *Client)
+
+	// pub/sub state, updated by the read goroutine on subscription confirmations
+	subMu    sync.Mutex
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	messages chan *Message
+
+	// automatic pipelining of the requests, disabled by a zero threshold
+	autoPipeline AutoPipelineConfig
+	meter        rateMeter
 }
 
 // request is a message sends to redis server
@@ -31,16 +61,30 @@ type request struct {
 	heartbeat bool
 	waiting   *wait.Wait
 	err       error
+	batch     []*request   // sub requests of a pipeline, written in one flush
+	expect    int          // number of replies the request waits for, 0 means 1
+	replies   []resp.Reply // all replies when expect > 1
 }
 
 const (
 	chanSize = 256
 	maxWait  = 3 * time.Second
+
+	// reconnection backoff, doubled after each failed attempt
+	minBackoff           = 50 * time.Millisecond
+	maxBackoff           = 2 * time.Second
+	maxReconnectAttempts = 8
+	dialTimeout          = time.Second
+)
+
+var (
+	errClientClosed   = errors.New("client closed")
+	errConnectionLost = errors.New("connection lost, reconnecting")
 )
 
 // MakeClient creates a new client
 func MakeClient(addr string) (*Client, error) {
-	conn, err := net.Dial("tcp", addr)
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -50,6 +94,10 @@ func MakeClient(addr string) (*Client, error) {
 		pendingReqs: make(chan *request, chanSize),
 		waitingReqs: make(chan *request, chanSize),
 		working:     &sync.WaitGroup{},
+		channels:    make(map[string]struct{}),
+		patterns:    make(map[string]struct{}),
+		messages:    make(chan *Message, chanSize),
+		stop:        make(chan struct{}),
 	}, nil
 }
 
@@ -58,7 +106,7 @@ func (client *Client) Start() {
 	client.ticker = time.NewTicker(10 * time.Second)
 	go client.handleWrite()
 	go func() {
-		err := client.handleRead()
+		err := client.handleRead(client.conn)
 		if err != nil {
 			logger.Error(err)
 		}
@@ -68,41 +116,237 @@ func (client *Client) Start() {
 
 // Close stops asynchronous goroutines and close connection
 func (client *Client) Close() {
+	client.closeMu.Lock()
+	if client.closing.Get() {
+		client.closeMu.Unlock()
+		return
+	}
+	client.closing.Set(true)
 	client.ticker.Stop()
+	close(client.stop)
 	// stop new request
 	close(client.pendingReqs)
+	client.closeMu.Unlock()
 
 	// wait stop process
 	client.working.Wait()
 
 	// clean
+	client.connMu.Lock()
 	_ = client.conn.Close()
+	reconnected := client.reconnected
+	client.connMu.Unlock()
+	// the reconnection queues the replayed state for its replies
+	if reconnected != nil {
+		<-reconnected
+	}
 	close(client.waitingReqs)
+	close(client.messages)
+}
+
+// OnReconnect registers a hook called after the connection has been re-established
+// and the AUTH/SELECT state has been replayed.
+// The hook runs in its own goroutine so it is free to send commands.
+func (client *Client) OnReconnect(hook func(client *Client)) {
+	client.stateMu.Lock()
+	client.onReconnect = hook
+	client.stateMu.Unlock()
 }
 
-func (client *Client) handleConnectionError(err error) error {
-	err1 := client.conn.Close()
+// dropConnection closes the given connection if it is still the current one, fails all requests
+// waiting for its replies, since they will never arrive, and starts re-establishing it
+func (client *Client) dropConnection(failed net.Conn, err error) {
+	client.connMu.Lock()
+	defer client.connMu.Unlock()
+	if client.conn != failed {
+		// already replaced by a new connection
+		return
+	}
+	err1 := failed.Close()
 	if err1 != nil {
-		if opErr, ok := err1.(*net.OpError); ok {
-			if opErr.Err.Error() != "use of closed network connection" {
-				return err1
+		if opErr, ok := err1.(*net.OpError); !ok || opErr.Err.Error() != "use of closed network connection" {
+			logger.Warn(err1)
+		}
+	}
+	if !client.broken.Get() {
+		logger.Warn("connection to " + client.addr + " lost: " + err.Error())
+	}
+	client.broken.Set(true)
+	client.failWaitingRequests(err)
+	client.startReconnect()
+}
+
+// failWaitingRequests finishes all requests waiting for a reply with the given error
+func (client *Client) failWaitingRequests(err error) {
+	for {
+		select {
+		case req := <-client.waitingReqs:
+			if req == nil {
+				return
 			}
-		} else {
-			return err1
+			req.err = err
+			if req.waiting != nil {
+				req.waiting.Done()
+			}
+		default:
+			return
 		}
 	}
-	conn, err1 := net.Dial("tcp", client.addr)
-	if err1 != nil {
-		logger.Error(err1)
-		return err1
+}
+
+// startReconnect starts re-establishing the lost connection in a goroutine, unless one runs
+// already or the client is closing. connMu must be held
+func (client *Client) startReconnect() {
+	if client.reconnected != nil || client.closing.Get() {
+		return
+	}
+	reconnected := make(chan struct{})
+	client.reconnected = reconnected
+	go client.reconnect(reconnected)
+}
+
+// reconnect dials the server with exponential backoff, then replays the connection state
+// It runs apart from the write goroutine, which fails the requests meanwhile, and gives up after
+// maxReconnectAttempts; the next request starts it again
+func (client *Client) reconnect(reconnected chan struct{}) {
+	defer close(reconnected)
+	backoff := minBackoff
+	for i := 0; i < maxReconnectAttempts; i++ {
+		conn, err := net.DialTimeout("tcp", client.addr, dialTimeout)
+		if err == nil {
+			if err = client.restore(conn); err == nil {
+				logger.Info("reconnected to " + client.addr)
+				client.stateMu.Lock()
+				hook := client.onReconnect
+				client.stateMu.Unlock()
+				if hook != nil {
+					go hook(client)
+				}
+				return
+			}
+		}
+		if err == errClientClosed {
+			return
+		}
+		logger.Warn("reconnect to " + client.addr + " failed: " + err.Error())
+		select {
+		case <-client.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
 	}
+	client.connMu.Lock()
+	client.reconnected = nil
+	client.connMu.Unlock()
+}
+
+// restore makes conn the connection of the client once the connection state is replayed on it,
+// the requests are written again from then on
+func (client *Client) restore(conn net.Conn) error {
+	client.connMu.Lock()
+	if client.closing.Get() {
+		client.connMu.Unlock()
+		_ = conn.Close()
+		return errClientClosed
+	}
+	// still broken, so that the write goroutine writes nothing before the replayed state
 	client.conn = conn
+	client.connMu.Unlock()
 	go func() {
-		_ = client.handleRead()
+		_ = client.handleRead(conn)
 	}()
+	if err := client.replayState(conn); err != nil {
+		client.dropConnection(conn, err)
+		return err
+	}
+	client.connMu.Lock()
+	defer client.connMu.Unlock()
+	if client.conn != conn || client.closing.Get() {
+		// lost again while the state was replayed
+		return errConnectionLost
+	}
+	client.broken.Set(false)
+	client.reconnected = nil
+	return nil
+}
+
+// replayState restores AUTH, SELECT and subscriptions on a fresh connection
+// It is called before the write goroutine writes to the connection, so the commands are written
+// directly and queued before any other request, their replies are ignored
+func (client *Client) replayState(conn net.Conn) error {
+	client.stateMu.Lock()
+	cmds := make([][][]byte, 0, 2)
+	if client.authArgs != nil {
+		cmds = append(cmds, client.authArgs)
+	}
+	if client.dbIndex != 0 {
+		cmds = append(cmds, utils.ToCmdLine("SELECT", strconv.Itoa(client.dbIndex)))
+	}
+	client.stateMu.Unlock()
+
+	requests := make([]*request, 0, len(cmds)+2)
+	for _, args := range cmds {
+		requests = append(requests, &request{args: args, heartbeat: true})
+	}
+	client.subMu.Lock()
+	if len(client.channels) > 0 {
+		requests = append(requests, makeReplayRequest("SUBSCRIBE", client.channels))
+	}
+	if len(client.patterns) > 0 {
+		requests = append(requests, makeReplayRequest("PSUBSCRIBE", client.patterns))
+	}
+	client.subMu.Unlock()
+	if len(requests) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, req := range requests {
+		buf.Write(reply.MakeMultiBulkReply(req.args).ToBytes())
+	}
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for _, req := range requests {
+		for i := 0; i < req.expectedReplies(); i++ {
+			client.waitingReqs <- req
+		}
+	}
 	return nil
 }
 
+// makeReplayRequest builds a subscribe family request for all targets
+func makeReplayRequest(cmd string, targets map[string]struct{}) *request {
+	args := make([][]byte, 0, len(targets)+1)
+	args = append(args, []byte(cmd))
+	for target := range targets {
+		args = append(args, []byte(target))
+	}
+	return &request{args: args, heartbeat: true, expect: len(targets)}
+}
+
+// trackState remembers AUTH and SELECT so they can be replayed after reconnection
+func (client *Client) trackState(args [][]byte, r resp.Reply) {
+	if len(args) < 2 || r == nil || reply.IsErrReply(r) {
+		return
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "auth":
+		client.stateMu.Lock()
+		client.authArgs = args
+		client.stateMu.Unlock()
+	case "select":
+		index, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return
+		}
+		client.stateMu.Lock()
+		client.dbIndex = index
+		client.stateMu.Unlock()
+	}
+}
+
 func (client *Client) heartbeat() {
 	for range client.ticker.C {
 		client.doHeartbeat()
@@ -111,10 +355,26 @@ func (client *Client) heartbeat() {
 
 func (client *Client) handleWrite() {
 	for req := range client.pendingReqs {
-		client.doRequest(req)
+		if client.batching() {
+			client.doRequests(client.collectBatch(req))
+		} else {
+			client.doRequest(req)
+		}
 	}
 }
 
+// Errors returned by the client when the server did not reply
+const (
+	timeoutMsg       = "server time out"
+	requestFailedMsg = "request failed"
+)
+
+// IsTimeout reports whether the reply is the error returned when the server did not reply in time
+func IsTimeout(result resp.Reply) bool {
+	errReply, ok := result.(reply.ErrorReply)
+	return ok && errReply.Error() == timeoutMsg
+}
+
 // Send sends a request to redis server
 func (client *Client) Send(args [][]byte) resp.Reply {
 	request := &request{
@@ -125,14 +385,17 @@ func (client *Client) Send(args [][]byte) resp.Reply {
 	request.waiting.Add(1)
 	client.working.Add(1)
 	defer client.working.Done()
-	client.pendingReqs <- request
+	if !client.enqueue(request) {
+		return reply.MakeStandardErrorReply(requestFailedMsg)
+	}
 	timeout := request.waiting.WaitWithTimeout(maxWait)
 	if timeout {
-		return reply.MakeStandardErrorReply("server time out")
+		return reply.MakeStandardErrorReply(timeoutMsg)
 	}
 	if request.err != nil {
-		return reply.MakeStandardErrorReply("request failed")
+		return reply.MakeStandardErrorReply(requestFailedMsg)
 	}
+	client.trackState(args, request.reply)
 	return request.reply
 }
 
@@ -145,31 +408,81 @@ func (client *Client) doHeartbeat() {
 	request.waiting.Add(1)
 	client.working.Add(1)
 	defer client.working.Done()
-	client.pendingReqs <- request
-	request.waiting.WaitWithTimeout(maxWait)
+	if client.enqueue(request) {
+		request.waiting.WaitWithTimeout(maxWait)
+	}
+}
+
+// enqueue queues the request for the write goroutine, it returns false once the client is closed
+func (client *Client) enqueue(req *request) bool {
+	client.closeMu.RLock()
+	defer client.closeMu.RUnlock()
+	if client.closing.Get() {
+		return false
+	}
+	client.pendingReqs <- req
+	return true
+}
+
+// dead reports whether the client is closed, or lost its connection and could not re-establish it
+func (client *Client) dead() bool {
+	return client.closing.Get() || client.broken.Get()
 }
 
 func (client *Client) doRequest(req *request) {
-	if req == nil || len(req.args) == 0 {
+	if req == nil {
 		return
 	}
-	re := reply.MakeMultiBulkReply(req.args)
-	bytes := re.ToBytes()
-	_, err := client.conn.Write(bytes)
-	i := 0
-	for err != nil && i < 3 {
-		err = client.handleConnectionError(err)
-		if err == nil {
-			_, err = client.conn.Write(bytes)
+	if len(req.batch) > 0 {
+		client.writeRequests(req.batch)
+		return
+	}
+	if len(req.args) == 0 {
+		return
+	}
+	client.writeRequests([]*request{req})
+}
+
+// writeRequests writes the requests in one flush and queues them for their replies in the same
+// order, a request expecting multiple replies is queued once per reply
+func (client *Client) writeRequests(reqs []*request) {
+	var buf bytes.Buffer
+	for _, req := range reqs {
+		buf.Write(reply.MakeMultiBulkReply(req.args).ToBytes())
+	}
+	err := client.write(buf.Bytes())
+	client.meter.flushed(len(reqs))
+	for _, req := range reqs {
+		if err != nil {
+			req.err = err
+			req.waiting.Done()
+			continue
+		}
+		for i := 0; i < req.expectedReplies(); i++ {
+			client.waitingReqs <- req
 		}
-		i++
 	}
-	if err == nil {
-		client.waitingReqs <- req
-	} else {
-		req.err = err
-		req.waiting.Done()
+}
+
+// write sends bytes to the server
+// It fails at once while the connection is lost, so that the queued requests do not wait for the
+// reconnection, and a failed write loses the connection
+func (client *Client) write(data []byte) error {
+	client.connMu.Lock()
+	conn, broken := client.conn, client.broken.Get()
+	if broken {
+		// the reconnection may have given up
+		client.startReconnect()
+	}
+	client.connMu.Unlock()
+	if broken {
+		return errConnectionLost
+	}
+	if _, err := conn.Write(data); err != nil {
+		client.dropConnection(conn, err)
+		return err
 	}
+	return nil
 }
 
 func (client *Client) finishRequest(reply resp.Reply) {
@@ -179,24 +492,63 @@ func (client *Client) finishRequest(reply resp.Reply) {
 			logger.Error(err)
 		}
 	}()
+	if client.handlePushMessage(reply) {
+		return
+	}
 	request := <-client.waitingReqs
 	if request == nil {
 		return
 	}
 	request.reply = reply
+	if request.expect > 1 {
+		request.replies = append(request.replies, reply)
+	}
 	if request.waiting != nil {
 		request.waiting.Done()
 	}
 }
 
-func (client *Client) handleRead() error {
-	ch := parser.ParseStream(client.conn)
+// expectedReplies returns the number of replies the request waits for
+func (req *request) expectedReplies() int {
+	if req.expect > 1 {
+		return req.expect
+	}
+	return 1
+}
+
+// handleRead reads replies from the given connection until it is closed
+// If the connection is lost unexpectedly, waiting requests are failed and
+// the next write will reconnect
+func (client *Client) handleRead(conn net.Conn) error {
+	ch := parser.ParseStream(conn)
 	for payload := range ch {
 		if payload.Err != nil {
+			if isConnectionError(payload.Err) {
+				if !client.closing.Get() {
+					client.dropConnection(conn, payload.Err)
+				}
+				return nil
+			}
 			client.finishRequest(reply.MakeStandardErrorReply(payload.Err.Error()))
+			if protocolErr, ok := payload.Err.(*parser.ProtocolError); ok && protocolErr.Fatal {
+				// the following replies can't be matched to requests any more
+				if !client.closing.Get() {
+					client.dropConnection(conn, payload.Err)
+				}
+				return nil
+			}
 			continue
 		}
 		client.finishRequest(payload.Data)
 	}
 	return nil
 }
+
+// isConnectionError reports whether the error comes from the connection rather than the protocol
+func isConnectionError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok || strings.Contains(err.Error(), "use of closed network connection")
+}
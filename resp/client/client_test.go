@@ -0,0 +1,303 @@
+package client
+
+import (
+	"bytes"
+	"net"
+	"redigo/interface/resp"
+	"redigo/lib/utils"
+	"redigo/lib/wildcard"
+	"redigo/resp/parser"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer is a server answering the commands used by the tests of the client: AUTH, SELECT,
+// PING, SET, GET, INCR, PUBLISH and the subscribe family, FAIL replies an error
+type testServer struct {
+	addr     string
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	data  map[int]map[string]string // the keys of each DB
+	conns []*testConn
+}
+
+// testConn is a connection accepted by the server, with the commands it received
+type testConn struct {
+	conn     net.Conn
+	mu       sync.Mutex // held while writing, PUBLISH writes to the other connections
+	db       int
+	lines    []string
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// startTestServer starts a test server, stopped at the end of the test
+func startTestServer(t *testing.T) *testServer {
+	srv := &testServer{addr: "127.0.0.1:0", data: make(map[int]map[string]string)}
+	srv.start(t)
+	srv.addr = srv.listener.Addr().String()
+	t.Cleanup(srv.stop)
+	return srv
+}
+
+// start listens on the address of the server, again after stop
+func (srv *testServer) start(t *testing.T) {
+	listener, err := net.Listen("tcp", srv.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.listener = listener
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			c := &testConn{conn: conn, channels: make(map[string]struct{}), patterns: make(map[string]struct{})}
+			srv.mu.Lock()
+			srv.conns = append(srv.conns, c)
+			srv.mu.Unlock()
+			srv.wg.Add(1)
+			go func() {
+				defer srv.wg.Done()
+				defer conn.Close()
+				srv.serve(c)
+			}()
+		}
+	}()
+}
+
+// stop closes the listener and the connections, the clients can not connect until start
+func (srv *testServer) stop() {
+	_ = srv.listener.Close()
+	srv.kill()
+	srv.wg.Wait()
+}
+
+// kill closes the server side of the connections
+func (srv *testServer) kill() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, c := range srv.conns {
+		_ = c.conn.Close()
+	}
+}
+
+// lines returns the commands received by each connection, in the order of the connections
+func (srv *testServer) lines() [][]string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	result := make([][]string, len(srv.conns))
+	for i, c := range srv.conns {
+		c.mu.Lock()
+		result[i] = append([]string(nil), c.lines...)
+		c.mu.Unlock()
+	}
+	return result
+}
+
+// serve executes the commands of the connection, its subscriptions end with it
+func (srv *testServer) serve(c *testConn) {
+	defer func() {
+		srv.mu.Lock()
+		c.channels = make(map[string]struct{})
+		c.patterns = make(map[string]struct{})
+		srv.mu.Unlock()
+	}()
+	for payload := range parser.ParseStream(c.conn) {
+		if payload.Err != nil {
+			return
+		}
+		args := payload.Data.(*reply.MultiBulkReply).Args
+		c.mu.Lock()
+		c.lines = append(c.lines, string(bytes.Join(args, []byte(" "))))
+		c.mu.Unlock()
+		out := srv.exec(c, strings.ToUpper(string(args[0])), args[1:])
+		c.mu.Lock()
+		_, err := c.conn.Write(out)
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// exec returns the replies of a command, the subscribe family replies one confirmation per target
+func (srv *testServer) exec(c *testConn, name string, args [][]byte) []byte {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	switch {
+	case name == "AUTH" || name == "PING" && len(args) == 0:
+		if name == "PING" {
+			return []byte("+PONG\r\n")
+		}
+		return reply.MakeOKReply().ToBytes()
+	case name == "SELECT" && len(args) == 1:
+		c.db, _ = strconv.Atoi(string(args[0]))
+		return reply.MakeOKReply().ToBytes()
+	case name == "SET" && len(args) == 2:
+		if srv.data[c.db] == nil {
+			srv.data[c.db] = make(map[string]string)
+		}
+		srv.data[c.db][string(args[0])] = string(args[1])
+		return reply.MakeOKReply().ToBytes()
+	case name == "GET" && len(args) == 1:
+		if value, ok := srv.data[c.db][string(args[0])]; ok {
+			return reply.MakeBulkReply([]byte(value)).ToBytes()
+		}
+		return reply.MakeNullBulkReply().ToBytes()
+	case name == "INCR" && len(args) == 1:
+		if srv.data[c.db] == nil {
+			srv.data[c.db] = make(map[string]string)
+		}
+		n, _ := strconv.ParseInt(srv.data[c.db][string(args[0])], 10, 64)
+		srv.data[c.db][string(args[0])] = strconv.FormatInt(n+1, 10)
+		return reply.MakeIntReply(n + 1).ToBytes()
+	case name == "PUBLISH" && len(args) == 2:
+		return reply.MakeIntReply(srv.publish(string(args[0]), args[1])).ToBytes()
+	case name == "SUBSCRIBE" || name == "PSUBSCRIBE" || name == "UNSUBSCRIBE" || name == "PUNSUBSCRIBE":
+		return c.subscription(strings.ToLower(name), args)
+	case name == "FAIL":
+		return reply.MakeStandardErrorReply("ERR failed").ToBytes()
+	}
+	return reply.MakeStandardErrorReply("ERR unknown command '" + strings.ToLower(name) + "'").ToBytes()
+}
+
+// publish writes the message to the connections subscribed to the channel or to a matching
+// pattern, srv.mu must be held
+func (srv *testServer) publish(channel string, payload []byte) int64 {
+	var receivers int64
+	for _, c := range srv.conns {
+		var messages []byte
+		if _, ok := c.channels[channel]; ok {
+			messages = append(messages, reply.MakeMultiBulkReply([][]byte{[]byte("message"), []byte(channel), payload}).ToBytes()...)
+		}
+		for pattern := range c.patterns {
+			if wildcard.CompilePattern(pattern).IsMatch(channel) {
+				messages = append(messages, reply.MakeMultiBulkReply([][]byte{[]byte("pmessage"), []byte(pattern), []byte(channel), payload}).ToBytes()...)
+			}
+		}
+		if len(messages) == 0 {
+			continue
+		}
+		receivers++
+		c.mu.Lock()
+		_, _ = c.conn.Write(messages)
+		c.mu.Unlock()
+	}
+	return receivers
+}
+
+// subscription applies a command of the subscribe family, the confirmations hold the number of
+// subscriptions left; UNSUBSCRIBE without argument confirms each channel, or nil if there is none
+func (c *testConn) subscription(kind string, args [][]byte) []byte {
+	targets := c.channels
+	if strings.HasPrefix(kind, "p") {
+		targets = c.patterns
+	}
+	names := make([]string, len(args))
+	for i, arg := range args {
+		names[i] = string(arg)
+	}
+	if len(names) == 0 {
+		for name := range targets {
+			names = append(names, name)
+		}
+	}
+	var out []byte
+	confirm := func(name []byte) {
+		out = append(out, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(kind)),
+			bulkOrNull(name),
+			reply.MakeIntReply(int64(len(c.channels) + len(c.patterns))),
+		}).ToBytes()...)
+	}
+	if len(names) == 0 {
+		confirm(nil)
+		return out
+	}
+	for _, name := range names {
+		if strings.HasSuffix(kind, "unsubscribe") {
+			delete(targets, name)
+		} else {
+			targets[name] = struct{}{}
+		}
+		confirm([]byte(name))
+	}
+	return out
+}
+
+// bulkOrNull returns the bulk reply of s, the null reply for nil
+func bulkOrNull(s []byte) resp.Reply {
+	if s == nil {
+		return reply.MakeNullBulkReply()
+	}
+	return reply.MakeBulkReply(s)
+}
+
+// TestClientReconnect tests that the requests fail at once while the connection is lost, and
+// that the connection is re-established with the AUTH and SELECT of the client replayed before
+// its requests, calling the hook of OnReconnect
+func TestClientReconnect(t *testing.T) {
+	srv := startTestServer(t)
+	c, err := MakeClient(srv.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	defer c.Close()
+	reconnected := make(chan struct{}, 16)
+	c.OnReconnect(func(c *Client) {
+		reconnected <- struct{}{}
+	})
+	for _, args := range [][]string{{"AUTH", "secret"}, {"SELECT", "2"}, {"SET", "k", "v"}} {
+		if r := c.Send(utils.ToCmdLine(args...)); string(r.ToBytes()) != "+OK\r\n" {
+			t.Fatalf("Expected %s to reply OK, got %q", args[0], r.ToBytes())
+		}
+	}
+
+	srv.stop()
+	// a request queued while the server is unreachable fails instead of waiting for the reconnection
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if r := c.Send(utils.ToCmdLine("GET", "k")); !reply.IsErrReply(r) || IsTimeout(r) {
+			t.Errorf("Expected the request to fail while the connection is lost, got %q", r.ToBytes())
+		}
+	}
+	if elapsed := time.Since(start); elapsed > maxWait/2 {
+		t.Errorf("Expected the requests to fail at once, took %v", elapsed)
+	}
+	if !c.dead() {
+		t.Error("Expected the client to be dead while the connection is lost")
+	}
+
+	srv.start(t)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if r := c.Send(utils.ToCmdLine("GET", "k")); string(r.ToBytes()) == "$1\r\nv\r\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the client to reconnect on DB 2")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Error("Expected the hook of OnReconnect to be called")
+	}
+	conns := srv.lines()
+	last := conns[len(conns)-1]
+	if len(conns) < 2 || len(last) < 3 || last[0] != "AUTH secret" || last[1] != "SELECT 2" {
+		t.Errorf("Expected the new connection to start with AUTH and SELECT, got %q", last)
+	}
+}
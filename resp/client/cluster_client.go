@@ -0,0 +1,278 @@
+package client
+
+import (
+	"errors"
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/lib/slot"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxRedirects is the max number of MOVED/ASK redirections followed for one command
+const maxRedirects = 5
+
+// ClusterClient is a cluster-aware client
+// It keeps a slot -> node map fetched by CLUSTER SLOTS, routes commands to the node
+// owning the key and follows MOVED/ASK redirections
+type ClusterClient struct {
+	seeds      []string
+	poolConfig PoolConfig
+
+	mu    sync.RWMutex
+	slots []string         // slot -> node address, empty if unknown
+	pools map[string]*Pool // node address -> pool
+}
+
+// MakeClusterClient creates a cluster client and loads the topology from the seed nodes
+func MakeClusterClient(seeds []string, config PoolConfig) (*ClusterClient, error) {
+	if len(seeds) == 0 {
+		return nil, errors.New("no seed node")
+	}
+	cc := &ClusterClient{
+		seeds:      seeds,
+		poolConfig: config,
+		slots:      make([]string, slot.SlotCount),
+		pools:      make(map[string]*Pool),
+	}
+	if err := cc.RefreshTopology(); err != nil {
+		cc.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// Send routes the command to the node owning its key and returns the reply
+// The key is assumed to be the first argument after the command name
+func (cc *ClusterClient) Send(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeStandardErrorReply("ERR empty command")
+	}
+	addr := cc.pickNode(args)
+	asking := false
+	for i := 0; i <= maxRedirects; i++ {
+		if addr == "" {
+			return reply.MakeStandardErrorReply("ERR no node available")
+		}
+		result, err := cc.sendTo(addr, args, asking)
+		if err != nil {
+			// the node may be down, refresh topology and try the new owner
+			logger.Warn("cluster client: send to " + addr + " failed: " + err.Error())
+			if refreshErr := cc.RefreshTopology(); refreshErr != nil {
+				return reply.MakeStandardErrorReply("ERR " + err.Error())
+			}
+			addr = cc.pickNode(args)
+			asking = false
+			continue
+		}
+		redirect, ok := ParseRedirect(result)
+		if !ok {
+			return result
+		}
+		if redirect.Kind == reply.RedirectMoved {
+			// the slot has been moved permanently, remember the new owner and reload the map
+			cc.mu.Lock()
+			cc.slots[redirect.Slot] = redirect.Addr
+			cc.mu.Unlock()
+			go func() {
+				_ = cc.RefreshTopology()
+			}()
+			asking = false
+		} else {
+			// the slot is being migrated, only this command goes to the target
+			asking = true
+		}
+		addr = redirect.Addr
+	}
+	return reply.MakeStandardErrorReply("ERR too many cluster redirections")
+}
+
+// sendTo sends the command to the given node, prefixed with ASKING if required
+func (cc *ClusterClient) sendTo(addr string, args [][]byte, asking bool) (resp.Reply, error) {
+	pool := cc.getPool(addr)
+	c, err := pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Put(c)
+	if !asking {
+		result := c.Send(args)
+		return result, checkClientError(result)
+	}
+	// ASKING and the command must be sent on the same connection
+	replies := c.Pipeline().
+		Queue(utils.ToCmdLine("ASKING")).
+		Queue(args).
+		Exec()
+	return replies[1], checkClientError(replies[1])
+}
+
+// checkClientError converts failures of the client itself (not errors from the server) into error
+func checkClientError(result resp.Reply) error {
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		msg := errReply.Error()
+		if msg == timeoutMsg || msg == requestFailedMsg {
+			return errors.New(msg)
+		}
+	}
+	return nil
+}
+
+// pickNode returns the node owning the key of the command, or any known node for keyless commands
+func (cc *ClusterClient) pickNode(args [][]byte) string {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	if len(args) > 1 {
+		if addr := cc.slots[slot.KeySlot(string(args[1]))]; addr != "" {
+			return addr
+		}
+	}
+	for addr := range cc.pools {
+		return addr
+	}
+	if len(cc.seeds) > 0 {
+		return cc.seeds[0]
+	}
+	return ""
+}
+
+// getPool returns the pool of the node, creating it on first use
+func (cc *ClusterClient) getPool(addr string) *Pool {
+	cc.mu.RLock()
+	pool, ok := cc.pools[addr]
+	cc.mu.RUnlock()
+	if ok {
+		return pool
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if pool, ok = cc.pools[addr]; ok {
+		return pool
+	}
+	pool = MakePool(addr, cc.poolConfig)
+	cc.pools[addr] = pool
+	return pool
+}
+
+// RefreshTopology reloads the slot map with CLUSTER SLOTS from any reachable node
+func (cc *ClusterClient) RefreshTopology() error {
+	cc.mu.RLock()
+	candidates := make([]string, 0, len(cc.pools)+len(cc.seeds))
+	for addr := range cc.pools {
+		candidates = append(candidates, addr)
+	}
+	cc.mu.RUnlock()
+	candidates = append(candidates, cc.seeds...)
+
+	var lastErr error
+	for _, addr := range candidates {
+		slots, err := cc.fetchSlots(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		cc.mu.Lock()
+		cc.slots = slots
+		cc.mu.Unlock()
+		return nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no node available")
+	}
+	return lastErr
+}
+
+// fetchSlots asks the node for the slot map
+func (cc *ClusterClient) fetchSlots(addr string) ([]string, error) {
+	pool := cc.getPool(addr)
+	c, err := pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Put(c)
+	result := c.Send(utils.ToCmdLine("CLUSTER", "SLOTS"))
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		return nil, errors.New(errReply.Error())
+	}
+	return parseClusterSlots(result)
+}
+
+// Close closes the connection pools of all nodes
+func (cc *ClusterClient) Close() {
+	cc.mu.Lock()
+	pools := cc.pools
+	cc.pools = make(map[string]*Pool)
+	cc.mu.Unlock()
+	for _, pool := range pools {
+		pool.Close()
+	}
+}
+
+// parseClusterSlots converts the reply of CLUSTER SLOTS into a slot -> master address map
+// Each entry is [start, end, [ip, port, id...], replicas...]
+func parseClusterSlots(result resp.Reply) ([]string, error) {
+	slots := make([]string, slot.SlotCount)
+	if _, ok := result.(*reply.EmptyMultiBulkReply); ok {
+		return slots, nil
+	}
+	entries, ok := result.(*reply.MultiRawReply)
+	if !ok {
+		return nil, errors.New("unexpected reply of CLUSTER SLOTS")
+	}
+	for _, e := range entries.Replies {
+		entry, ok := e.(*reply.MultiRawReply)
+		if !ok || len(entry.Replies) < 3 {
+			return nil, errors.New("malformed CLUSTER SLOTS entry")
+		}
+		start, ok1 := entry.Replies[0].(*reply.IntReply)
+		end, ok2 := entry.Replies[1].(*reply.IntReply)
+		node, ok3 := flattenArray(entry.Replies[2])
+		if !ok1 || !ok2 || !ok3 || len(node) < 2 {
+			return nil, errors.New("malformed CLUSTER SLOTS entry")
+		}
+		if start.Code < 0 || end.Code >= slot.SlotCount || start.Code > end.Code {
+			return nil, errors.New("slot out of range in CLUSTER SLOTS")
+		}
+		addr := string(node[0]) + ":" + string(node[1])
+		for i := start.Code; i <= end.Code; i++ {
+			slots[i] = addr
+		}
+	}
+	return slots, nil
+}
+
+// ParseRedirect returns the redirection of a -MOVED or -ASK error reply such as
+// "MOVED 3999 127.0.0.1:6381", false if the reply is not a redirection
+func ParseRedirect(result resp.Reply) (*reply.RedirectErrReply, bool) {
+	if redirect, ok := result.(*reply.RedirectErrReply); ok {
+		return redirect, true
+	}
+	errReply, isErr := result.(reply.ErrorReply)
+	if !isErr {
+		return nil, false
+	}
+	fields := strings.Fields(errReply.Error())
+	if len(fields) != 3 || (fields[0] != reply.RedirectMoved && fields[0] != reply.RedirectAsk) {
+		return nil, false
+	}
+	slotIndex, err := strconv.Atoi(fields[1])
+	if err != nil || slotIndex < 0 || slotIndex >= slot.SlotCount {
+		return nil, false
+	}
+	return &reply.RedirectErrReply{Kind: fields[0], Slot: slotIndex, Addr: fields[2]}, true
+}
+
+// IsMoved reports whether the reply is a -MOVED redirection
+func IsMoved(result resp.Reply) bool {
+	redirect, ok := ParseRedirect(result)
+	return ok && redirect.Kind == reply.RedirectMoved
+}
+
+// IsAsk reports whether the reply is an -ASK redirection
+func IsAsk(result resp.Reply) bool {
+	redirect, ok := ParseRedirect(result)
+	return ok && redirect.Kind == reply.RedirectAsk
+}
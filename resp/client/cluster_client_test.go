@@ -0,0 +1,202 @@
+package client
+
+import (
+	"net"
+	"redigo/lib/slot"
+	"redigo/resp/parser"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestParseRedirect tests the redirections parsed from the error replies, as read from a server
+// or made by the constructors
+func TestParseRedirect(t *testing.T) {
+	payload := <-parser.ParseStream(strings.NewReader("-MOVED 3999 127.0.0.1:6381\r\n"))
+	if payload.Err != nil {
+		t.Fatal(payload.Err)
+	}
+	redirect, ok := ParseRedirect(payload.Data)
+	if !ok || *redirect != (reply.RedirectErrReply{Kind: reply.RedirectMoved, Slot: 3999, Addr: "127.0.0.1:6381"}) {
+		t.Errorf("Unexpected redirection %+v of the MOVED reply", redirect)
+	}
+	if !IsMoved(payload.Data) || IsAsk(payload.Data) {
+		t.Error("Expected the reply to be a MOVED redirection")
+	}
+	ask := reply.MakeAskErrReply(0, "127.0.0.1:6382")
+	if string(ask.ToBytes()) != "-ASK 0 127.0.0.1:6382\r\n" || !IsAsk(ask) || IsMoved(ask) {
+		t.Errorf("Unexpected ASK reply %q", ask.ToBytes())
+	}
+	if r := reply.MakeMovedErrReply(16383, "h:1"); string(r.ToBytes()) != "-MOVED 16383 h:1\r\n" || !reply.IsErrReply(r) {
+		t.Errorf("Unexpected MOVED reply %q", r.ToBytes())
+	}
+
+	for _, msg := range []string{"MOVED", "MOVED 1", "MOVED x h:1", "MOVED 16384 h:1", "MOVED -1 h:1",
+		"ASK 1 h:1 extra", "moved 1 h:1", "ERR unknown command"} {
+		if redirect, ok := ParseRedirect(reply.MakeStandardErrorReply(msg)); ok {
+			t.Errorf("Expected %q not to be a redirection, got %+v", msg, redirect)
+		}
+	}
+	if _, ok := ParseRedirect(reply.MakeStatusReply("MOVED 1 h:1")); ok {
+		t.Error("Expected a status reply not to be a redirection")
+	}
+}
+
+// fakeNode is a node of a cluster replying to the commands with respond, it records the commands
+type fakeNode struct {
+	addr     string
+	mu       sync.Mutex
+	commands []string
+}
+
+func startNode(t *testing.T, respond func(args []string) string) *fakeNode {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &fakeNode{addr: listener.Addr().String()}
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		_ = listener.Close()
+		wg.Wait()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for payload := range parser.ParseStream(conn) {
+					if payload.Err != nil {
+						return
+					}
+					var args []string
+					for _, arg := range payload.Data.(*reply.MultiBulkReply).Args {
+						args = append(args, string(arg))
+					}
+					node.mu.Lock()
+					node.commands = append(node.commands, strings.Join(args, " "))
+					node.mu.Unlock()
+					if _, err := conn.Write([]byte(respond(args))); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return node
+}
+
+// received returns the commands of the node other than CLUSTER SLOTS
+func (node *fakeNode) received() []string {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	var commands []string
+	for _, command := range node.commands {
+		if command != "CLUSTER SLOTS" {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// slotEntry is an entry of the reply of CLUSTER SLOTS
+func slotEntry(start, end int, addr string) string {
+	host, port, _ := net.SplitHostPort(addr)
+	return "*3\r\n:" + strconv.Itoa(start) + "\r\n:" + strconv.Itoa(end) + "\r\n*2\r\n$" + strconv.Itoa(len(host)) + "\r\n" +
+		host + "\r\n:" + port + "\r\n"
+}
+
+// slotsReply is the reply of CLUSTER SLOTS giving all slots to the node of addr, but the slot
+// moved to the node of movedTo if set
+func slotsReply(addr string, moved int, movedTo string) string {
+	if movedTo == "" {
+		return "*1\r\n" + slotEntry(0, slot.SlotCount-1, addr)
+	}
+	entries := []string{slotEntry(moved, moved, movedTo)}
+	if moved > 0 {
+		entries = append(entries, slotEntry(0, moved-1, addr))
+	}
+	if moved < slot.SlotCount-1 {
+		entries = append(entries, slotEntry(moved+1, slot.SlotCount-1, addr))
+	}
+	return "*" + strconv.Itoa(len(entries)) + "\r\n" + strings.Join(entries, "")
+}
+
+// TestClusterClientRedirect tests that the client follows MOVED and updates its slot map, that it
+// sends ASKING before the command redirected by ASK only, and that it stops after maxRedirects
+func TestClusterClientRedirect(t *testing.T) {
+	var a, b *fakeNode
+	// the slot of the key moved is moved to b once a replied MOVED
+	var moved atomic.Bool
+	topology := func() string {
+		if moved.Load() {
+			return slotsReply(a.addr, slot.KeySlot("moved"), b.addr)
+		}
+		return slotsReply(a.addr, 0, "")
+	}
+	movedSlot := strconv.Itoa(slot.KeySlot("moved"))
+	askSlot := strconv.Itoa(slot.KeySlot("ask"))
+	a = startNode(t, func(args []string) string {
+		switch strings.Join(args, " ") {
+		case "CLUSTER SLOTS":
+			return topology()
+		case "GET moved":
+			moved.Store(true)
+			return "-MOVED " + movedSlot + " " + b.addr + "\r\n"
+		case "GET ask":
+			return "-ASK " + askSlot + " " + b.addr + "\r\n"
+		case "GET loop":
+			return "-MOVED " + strconv.Itoa(slot.KeySlot("loop")) + " " + a.addr + "\r\n"
+		}
+		return "+OK\r\n"
+	})
+	b = startNode(t, func(args []string) string {
+		switch args[0] {
+		case "CLUSTER":
+			return topology()
+		case "GET":
+			return "$1\r\nb\r\n"
+		}
+		return "+OK\r\n"
+	})
+	cc, err := MakeClusterClient([]string{a.addr}, PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	for i := 0; i < 2; i++ {
+		if r := cc.Send([][]byte{[]byte("GET"), []byte("moved")}); string(r.ToBytes()) != "$1\r\nb\r\n" {
+			t.Errorf("Expected the reply of the node of MOVED, got %q", r.ToBytes())
+		}
+	}
+	if got := strings.Join(a.received(), ","); got != "GET moved" {
+		t.Errorf("Expected the second GET to go to the node of MOVED, the first node received %q", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		if r := cc.Send([][]byte{[]byte("GET"), []byte("ask")}); string(r.ToBytes()) != "$1\r\nb\r\n" {
+			t.Errorf("Expected the reply of the node of ASK, got %q", r.ToBytes())
+		}
+	}
+	if got := strings.Join(a.received(), ","); got != "GET moved,GET ask,GET ask" {
+		t.Errorf("Expected each GET to go to the owner after ASK, the first node received %q", got)
+	}
+	if got := strings.Join(b.received(), ","); got != "GET moved,GET moved,ASKING,GET ask,ASKING,GET ask" {
+		t.Errorf("Expected ASKING before each command redirected by ASK, the second node received %q", got)
+	}
+
+	if r := cc.Send([][]byte{[]byte("GET"), []byte("loop")}); string(r.ToBytes()) != "-ERR too many cluster redirections\r\n" {
+		t.Errorf("Expected the redirections to stop, got %q", r.ToBytes())
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"redigo/interface/resp"
+	"redigo/lib/sync/wait"
+	"redigo/resp/reply"
+)
+
+// Pipeline queues commands locally and sends them to the server in one flush
+type Pipeline struct {
+	client *Client
+	cmds   [][][]byte
+}
+
+// Pipeline creates a new pipeline bound to the client
+func (client *Client) Pipeline() *Pipeline {
+	return &Pipeline{
+		client: client,
+	}
+}
+
+// Queue appends a command to the pipeline, it will not be sent until Exec is called
+func (p *Pipeline) Queue(args [][]byte) *Pipeline {
+	p.cmds = append(p.cmds, args)
+	return p
+}
+
+// Len returns the number of queued commands
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Exec writes all queued commands in one flush and returns the replies in order
+// The pipeline is reset after Exec so it can be reused
+func (p *Pipeline) Exec() []resp.Reply {
+	cmds := p.cmds
+	p.cmds = nil
+	if len(cmds) == 0 {
+		return []resp.Reply{}
+	}
+
+	waiting := &wait.Wait{}
+	waiting.Add(len(cmds))
+	batch := make([]*request, len(cmds))
+	for i, args := range cmds {
+		batch[i] = &request{
+			args:    args,
+			waiting: waiting,
+		}
+	}
+
+	client := p.client
+	client.working.Add(1)
+	defer client.working.Done()
+	timeout := false
+	if client.enqueue(&request{batch: batch, waiting: waiting}) {
+		timeout = waiting.WaitWithTimeout(maxWait)
+	} else {
+		for _, req := range batch {
+			req.err = errClientClosed
+		}
+	}
+
+	replies := make([]resp.Reply, len(batch))
+	for i, req := range batch {
+		if req.err != nil {
+			replies[i] = reply.MakeStandardErrorReply(requestFailedMsg)
+		} else if req.reply == nil {
+			if timeout {
+				replies[i] = reply.MakeStandardErrorReply(timeoutMsg)
+			} else {
+				replies[i] = reply.MakeNullBulkReply()
+			}
+		} else {
+			replies[i] = req.reply
+			client.trackState(req.args, req.reply)
+		}
+	}
+	return replies
+}
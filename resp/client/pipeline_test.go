@@ -0,0 +1,99 @@
+package client
+
+import (
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"testing"
+)
+
+// TestPipeline tests that the replies of the queued commands come in order, that a failed
+// command only fails its own reply, and that the pipeline is reset after Exec
+func TestPipeline(t *testing.T) {
+	srv := startTestServer(t)
+	c, err := MakeClient(srv.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	defer c.Close()
+
+	p := c.Pipeline().
+		Queue(utils.ToCmdLine("SET", "k", "v")).
+		Queue(utils.ToCmdLine("INCR", "n")).
+		Queue(utils.ToCmdLine("FAIL")).
+		Queue(utils.ToCmdLine("INCR", "n")).
+		Queue(utils.ToCmdLine("GET", "k")).
+		Queue(utils.ToCmdLine("GET", "missing"))
+	if p.Len() != 6 {
+		t.Fatalf("Expected 6 queued commands, got %d", p.Len())
+	}
+	expected := []string{"+OK\r\n", ":1\r\n", "-ERR failed\r\n", ":2\r\n", "$1\r\nv\r\n", "$-1\r\n"}
+	replies := p.Exec()
+	if len(replies) != len(expected) {
+		t.Fatalf("Expected %d replies, got %d", len(expected), len(replies))
+	}
+	for i, r := range replies {
+		if got := string(r.ToBytes()); got != expected[i] {
+			t.Errorf("Expected reply #%d to be %q, got %q", i, expected[i], got)
+		}
+	}
+	lines := srv.lines()[0]
+	if len(lines) != 6 || lines[2] != "FAIL" || lines[3] != "INCR n" {
+		t.Errorf("Expected the commands to be sent in order, got %q", lines)
+	}
+
+	if p.Len() != 0 {
+		t.Errorf("Expected the pipeline to be reset after Exec, got %d commands", p.Len())
+	}
+	// the reset pipeline is reusable and tracks SELECT like Send
+	replies = p.Queue(utils.ToCmdLine("SELECT", "1")).Queue(utils.ToCmdLine("GET", "k")).Exec()
+	if len(replies) != 2 || string(replies[1].ToBytes()) != "$-1\r\n" {
+		t.Errorf("Expected k to be missing on DB 1, got %v", replies)
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.dbIndex != 1 {
+		t.Errorf("Expected the client to track DB 1, got %d", c.dbIndex)
+	}
+}
+
+// TestEmptyPipeline tests that an empty pipeline returns no reply without sending anything
+func TestEmptyPipeline(t *testing.T) {
+	srv := startTestServer(t)
+	c, err := MakeClient(srv.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	defer c.Close()
+
+	if replies := c.Pipeline().Exec(); replies == nil || len(replies) != 0 {
+		t.Errorf("Expected an empty slice of replies, got %v", replies)
+	}
+	if r := c.Send(utils.ToCmdLine("PING")); string(r.ToBytes()) != "+PONG\r\n" {
+		t.Errorf("Expected the client to keep working, got %q", r.ToBytes())
+	}
+	if lines := srv.lines()[0]; len(lines) != 1 {
+		t.Errorf("Expected the server to only receive PING, got %q", lines)
+	}
+}
+
+// TestPipelineClosed tests that every command of a pipeline fails on a closed client
+func TestPipelineClosed(t *testing.T) {
+	c, err := MakeClient(startTestServer(t).addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	c.Close()
+
+	replies := c.Pipeline().Queue(utils.ToCmdLine("PING")).Queue(utils.ToCmdLine("PING")).Exec()
+	if len(replies) != 2 {
+		t.Fatalf("Expected 2 replies, got %d", len(replies))
+	}
+	for i, r := range replies {
+		if !reply.IsErrReply(r) {
+			t.Errorf("Expected reply #%d to be an error, got %q", i, r.ToBytes())
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package client
+
+import (
+	"errors"
+	"redigo/lib/logger"
+	"redigo/lib/utils"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPoolClosed is returned by Get after the pool has been closed
+	ErrPoolClosed = errors.New("client pool closed")
+	// ErrPoolExhausted is returned by Get when MaxActive is reached and Wait is false
+	ErrPoolExhausted = errors.New("client pool exhausted")
+	// ErrPoolTimeout is returned by Get when no client was returned within WaitTimeout
+	ErrPoolTimeout = errors.New("client pool timeout")
+)
+
+// PoolConfig stores the options of a client pool
+type PoolConfig struct {
+	MaxActive int  // max number of clients allocated by the pool at a time, 0 means no limit
+	MaxIdle   int  // max number of idle clients kept in the pool
+	Wait      bool // if true, Get blocks until a client is returned when MaxActive is reached
+	// WaitTimeout is the max time Get blocks when Wait is set, 0 means no limit
+	WaitTimeout time.Duration
+	// Password is sent with AUTH by the clients created by the pool, empty sends no AUTH
+	Password string
+	// PasswordFunc returns the password instead of Password, called for each client created and
+	// again after it reconnected, for a password which may change like requirepass
+	PasswordFunc func() string
+	// DB is selected by the clients created by the pool, after AUTH
+	DB int
+	// Handshake is called on each client created by the pool after AUTH and SELECT, and again
+	// after the client reconnected, an error closes the new client
+	Handshake func(c *Client) error
+	// AutoPipeline is applied to the clients created by the pool, those borrowed by Get are used
+	// by one caller at a time, so it mostly batches the requests of the shared client
+	AutoPipeline AutoPipelineConfig
+}
+
+// PoolStats is a snapshot of the pool utilization
+type PoolStats struct {
+	Active  int // clients allocated by the pool, including idle ones
+	Idle    int // clients waiting in the pool
+	Waiting int // callers blocked in Get
+}
+
+// Pool keeps a set of started clients connected to the same address
+type Pool struct {
+	addr   string
+	config PoolConfig
+	mu     sync.Mutex
+	idles  []*Client
+	// waiters are the callers blocked in Get, served in FIFO order with a client, or with nil
+	// when an allocation slot is freed, the caller then creates the client
+	waiters []chan *Client
+	active  int
+	closed  bool
+
+	sharedMu sync.Mutex
+	shared   *Client // client of Shared, not counted in the stats
+}
+
+// MakePool creates a new client pool, clients are created lazily on Get
+func MakePool(addr string, config PoolConfig) *Pool {
+	return &Pool{
+		addr:   addr,
+		config: config,
+	}
+}
+
+// Get borrows a client from the pool, creating a new one if there is no idle client, the idle
+// clients which lost their connection are closed
+func (pool *Pool) Get() (*Client, error) {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	// reuse the most recently returned client
+	for n := len(pool.idles); n > 0; n-- {
+		c := pool.idles[n-1]
+		pool.idles = pool.idles[:n-1]
+		if !c.dead() {
+			pool.mu.Unlock()
+			return c, nil
+		}
+		// its connection was lost while idle
+		pool.active--
+		go c.Close()
+	}
+	if pool.config.MaxActive > 0 && pool.active >= pool.config.MaxActive {
+		if !pool.config.Wait {
+			pool.mu.Unlock()
+			return nil, ErrPoolExhausted
+		}
+		waiter := make(chan *Client, 1)
+		pool.waiters = append(pool.waiters, waiter)
+		pool.mu.Unlock()
+		return pool.wait(waiter)
+	}
+	pool.active++
+	pool.mu.Unlock()
+	return pool.dial()
+}
+
+// wait waits for a client or a slot handed to the waiter by Put or release
+func (pool *Pool) wait(waiter chan *Client) (*Client, error) {
+	var timeout <-chan time.Time
+	if pool.config.WaitTimeout > 0 {
+		timer := time.NewTimer(pool.config.WaitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case c, ok := <-waiter:
+		return pool.served(c, ok)
+	case <-timeout:
+	}
+	pool.mu.Lock()
+	for i, w := range pool.waiters {
+		if w == waiter {
+			pool.waiters = append(pool.waiters[:i], pool.waiters[i+1:]...)
+			pool.mu.Unlock()
+			return nil, ErrPoolTimeout
+		}
+	}
+	pool.mu.Unlock()
+	// served meanwhile
+	c, ok := <-waiter
+	return pool.served(c, ok)
+}
+
+// served returns what a waiter received: a client, a slot to create one, or the closed pool
+func (pool *Pool) served(c *Client, ok bool) (*Client, error) {
+	if !ok {
+		return nil, ErrPoolClosed
+	}
+	if c == nil {
+		return pool.dial()
+	}
+	return c, nil
+}
+
+// dial creates a client on an allocation slot, the slot is released if it fails
+func (pool *Pool) dial() (*Client, error) {
+	c, err := pool.makeClient()
+	if err != nil {
+		pool.release()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Put returns a client to the pool
+// It is handed to a blocked caller first, kept as idle if there is room, otherwise closed. A
+// client which lost its connection is closed, its slot goes to a blocked caller
+func (pool *Pool) Put(c *Client) {
+	if c == nil {
+		return
+	}
+	pool.mu.Lock()
+	if pool.closed {
+		pool.active--
+		pool.mu.Unlock()
+		c.Close()
+		return
+	}
+	if c.dead() {
+		pool.mu.Unlock()
+		c.Close()
+		pool.release()
+		return
+	}
+	if len(pool.waiters) > 0 {
+		waiter := pool.waiters[0]
+		pool.waiters = pool.waiters[1:]
+		pool.mu.Unlock()
+		waiter <- c
+		return
+	}
+	if len(pool.idles) < pool.config.MaxIdle {
+		pool.idles = append(pool.idles, c)
+		pool.mu.Unlock()
+		return
+	}
+	pool.active--
+	pool.mu.Unlock()
+	c.Close()
+}
+
+// Shared returns the client shared by all the callers of Shared, created on the first call and
+// replaced once closed or once its connection is lost
+// Concurrent requests to a client are serialized on its connection, so the commands which may
+// block the connection, like BLPOP, must borrow a client with Get instead. It must not be put back
+func (pool *Pool) Shared() (*Client, error) {
+	pool.sharedMu.Lock()
+	defer pool.sharedMu.Unlock()
+	pool.mu.Lock()
+	closed := pool.closed
+	pool.mu.Unlock()
+	if closed {
+		return nil, ErrPoolClosed
+	}
+	if pool.shared != nil {
+		if !pool.shared.dead() {
+			return pool.shared, nil
+		}
+		// it fails the requests until its reconnection succeeds, a new client serves them at once
+		// if the server is reachable. The closing waits for the requests of the callers still
+		// holding it
+		go pool.shared.Close()
+		pool.shared = nil
+	}
+	c, err := pool.makeClient()
+	if err != nil {
+		return nil, err
+	}
+	pool.shared = c
+	return c, nil
+}
+
+// makeClient creates and starts a client, authenticated with the password of the pool, on the DB
+// of the pool and after the handshake of the pool
+func (pool *Pool) makeClient() (*Client, error) {
+	c, err := MakeClient(pool.addr)
+	if err != nil {
+		return nil, err
+	}
+	c.SetAutoPipeline(pool.config.AutoPipeline)
+	c.Start()
+	if err := pool.auth(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if pool.config.DB != 0 {
+		if r := c.Send(utils.ToCmdLine("SELECT", strconv.Itoa(pool.config.DB))); reply.IsErrReply(r) {
+			c.Close()
+			return nil, errors.New("SELECT on " + pool.addr + " failed: " + strings.TrimSpace(string(r.ToBytes()[1:])))
+		}
+	}
+	handshake := pool.config.Handshake
+	if handshake != nil {
+		if err := handshake(c); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if handshake != nil || pool.config.PasswordFunc != nil {
+		c.OnReconnect(func(c *Client) {
+			// the AUTH replayed by the client has the password of the previous connection
+			if pool.config.PasswordFunc != nil {
+				if err := pool.auth(c); err != nil {
+					logger.Warn(err.Error())
+				}
+			}
+			// the server may have been restarted with another version
+			if handshake != nil {
+				if err := handshake(c); err != nil {
+					logger.Warn(err.Error())
+				}
+			}
+		})
+	}
+	return c, nil
+}
+
+// auth authenticates the client with the password of the pool, if any
+func (pool *Pool) auth(c *Client) error {
+	password := pool.config.Password
+	if pool.config.PasswordFunc != nil {
+		password = pool.config.PasswordFunc()
+	}
+	if password == "" {
+		return nil
+	}
+	if r := c.Send(utils.ToCmdLine("AUTH", password)); reply.IsErrReply(r) {
+		return errors.New("AUTH to " + pool.addr + " failed: " + strings.TrimSpace(string(r.ToBytes()[1:])))
+	}
+	return nil
+}
+
+// release gives back an allocation slot which did not produce a usable client, it is handed to
+// the first blocked caller if any
+func (pool *Pool) release() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if len(pool.waiters) > 0 && !pool.closed {
+		waiter := pool.waiters[0]
+		pool.waiters = pool.waiters[1:]
+		waiter <- nil
+		return
+	}
+	pool.active--
+}
+
+// Stats returns the current utilization of the pool
+func (pool *Pool) Stats() PoolStats {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return PoolStats{
+		Active:  pool.active,
+		Idle:    len(pool.idles),
+		Waiting: len(pool.waiters),
+	}
+}
+
+// Close closes all idle clients and the shared one, and wakes up blocked callers
+// Borrowed clients are closed when they are put back
+func (pool *Pool) Close() {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return
+	}
+	pool.closed = true
+	idles := pool.idles
+	pool.idles = nil
+	pool.active -= len(idles)
+	for _, waiter := range pool.waiters {
+		close(waiter)
+	}
+	pool.waiters = nil
+	pool.mu.Unlock()
+
+	for _, c := range idles {
+		c.Close()
+	}
+	pool.sharedMu.Lock()
+	if pool.shared != nil {
+		pool.shared.Close()
+		pool.shared = nil
+	}
+	pool.sharedMu.Unlock()
+}
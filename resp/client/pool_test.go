@@ -0,0 +1,324 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"redigo/resp/parser"
+	"redigo/resp/reply"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listen accepts connections and never answers, enough for the clients which send no command
+func listen(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// getAsync calls Get in background, the result is sent to the channel
+func getAsync(pool *Pool) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		c, err := pool.Get()
+		if c != nil {
+			pool.Put(c)
+		}
+		done <- err
+	}()
+	return done
+}
+
+// waitForWaiters waits until n callers are blocked in Get
+func waitForWaiters(t *testing.T, pool *Pool, n int) {
+	deadline := time.Now().Add(time.Second)
+	for pool.Stats().Waiting != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d callers blocked in Get, got %d", n, pool.Stats().Waiting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitDead waits until the client sees its connection lost
+func waitDead(t *testing.T, c *Client) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !c.dead() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the client to see its connection lost")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestPoolDialFailure tests that a failed dial hands its slot to a blocked caller, which would
+// otherwise wait forever once MaxActive is reached
+func TestPoolDialFailure(t *testing.T) {
+	failure := errors.New("handshake failed")
+	proceed := make(chan struct{})
+	pool := MakePool(listen(t), PoolConfig{
+		MaxActive: 1,
+		Wait:      true,
+		Handshake: func(c *Client) error {
+			<-proceed
+			return failure
+		},
+	})
+	defer pool.Close()
+
+	first := getAsync(pool)
+	deadline := time.Now().Add(time.Second)
+	for pool.Stats().Active != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the first Get to take the slot")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	second := getAsync(pool)
+	waitForWaiters(t, pool, 1)
+
+	close(proceed)
+	for _, done := range []<-chan error{first, second} {
+		select {
+		case err := <-done:
+			if err != failure {
+				t.Errorf("Expected the handshake error, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected Get to return after the failed dial")
+		}
+	}
+	if stats := pool.Stats(); stats.Active != 0 || stats.Waiting != 0 {
+		t.Errorf("Expected the slots to be released, got %+v", stats)
+	}
+}
+
+// TestPoolWaitTimeout tests that Get gives up after WaitTimeout, and that the pool keeps serving
+func TestPoolWaitTimeout(t *testing.T) {
+	pool := MakePool(listen(t), PoolConfig{MaxActive: 1, MaxIdle: 1, Wait: true, WaitTimeout: 20 * time.Millisecond})
+	defer pool.Close()
+	c, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := pool.Get(); err != ErrPoolTimeout {
+		t.Errorf("Expected ErrPoolTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected Get to wait WaitTimeout, returned after %v", elapsed)
+	}
+	if waiting := pool.Stats().Waiting; waiting != 0 {
+		t.Errorf("Expected the timed out caller to be removed, %d waiting", waiting)
+	}
+
+	pool.Put(c)
+	again, err := pool.Get()
+	if err != nil || again != c {
+		t.Errorf("Expected the idle client, got %v", err)
+	}
+	pool.Put(again)
+}
+
+// TestPoolExhaustedAndClosed tests the errors of Get without Wait and after Close
+func TestPoolExhaustedAndClosed(t *testing.T) {
+	pool := MakePool(listen(t), PoolConfig{MaxActive: 1})
+	c, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Get(); err != ErrPoolExhausted {
+		t.Errorf("Expected ErrPoolExhausted, got %v", err)
+	}
+	pool.Close()
+	if _, err := pool.Get(); err != ErrPoolClosed {
+		t.Errorf("Expected ErrPoolClosed, got %v", err)
+	}
+	pool.Put(c)
+	if active := pool.Stats().Active; active != 0 {
+		t.Errorf("Expected the client put back after Close to be closed, %d active", active)
+	}
+
+	waiting := MakePool(listen(t), PoolConfig{MaxActive: 1, Wait: true})
+	c, err = waiting.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := getAsync(waiting)
+	waitForWaiters(t, waiting, 1)
+	waiting.Close()
+	if err := <-done; err != ErrPoolClosed {
+		t.Errorf("Expected the blocked caller to get ErrPoolClosed, got %v", err)
+	}
+	waiting.Put(c)
+}
+
+// listenAuth accepts connections which reply +OK to the commands, the passwords of AUTH are sent
+// to the channel
+func listenAuth(t *testing.T, passwords chan<- string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		_ = listener.Close()
+		wg.Wait()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				for payload := range parser.ParseStream(conn) {
+					if payload.Err != nil {
+						return
+					}
+					if args := payload.Data.(*reply.MultiBulkReply).Args; string(args[0]) == "AUTH" {
+						passwords <- string(args[1])
+					}
+					if _, err := conn.Write(reply.MakeOKReply().ToBytes()); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// TestPoolPasswordFunc tests that the password of PasswordFunc is read for each client created
+func TestPoolPasswordFunc(t *testing.T) {
+	passwords := make(chan string, 2)
+	var mu sync.Mutex
+	password := "first"
+	pool := MakePool(listenAuth(t, passwords), PoolConfig{PasswordFunc: func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return password
+	}})
+	defer pool.Close()
+
+	first, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	password = "second"
+	mu.Unlock()
+	second, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"first", "second"} {
+		if got := <-passwords; got != expected {
+			t.Errorf("Expected AUTH %s, got %s", expected, got)
+		}
+	}
+	pool.Put(first)
+	pool.Put(second)
+}
+
+// TestPoolSharedRedial tests that the shared client is replaced while its connection is lost,
+// and that the replaced client fails the requests of the callers still holding it
+func TestPoolSharedRedial(t *testing.T) {
+	srv := startTestServer(t)
+	pool := MakePool(srv.addr, PoolConfig{})
+	defer pool.Close()
+	first, err := pool.Shared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := pool.Shared(); again != first {
+		t.Fatal("Expected the shared client to be kept while it is connected")
+	}
+
+	srv.stop()
+	waitDead(t, first)
+	if _, err := pool.Shared(); err == nil {
+		t.Error("Expected the dial of the new shared client to fail")
+	}
+	srv.start(t)
+	second, err := pool.Shared()
+	if err != nil || second == first {
+		t.Fatalf("Expected a new shared client, got %v", err)
+	}
+	if r := second.Send([][]byte{[]byte("PING")}); string(r.ToBytes()) != "+PONG\r\n" {
+		t.Errorf("Expected the new client to be connected, got %q", r.ToBytes())
+	}
+	first.Close()
+	if r := first.Send([][]byte{[]byte("PING")}); !reply.IsErrReply(r) {
+		t.Errorf("Expected an error from the closed client, got %q", r.ToBytes())
+	}
+}
+
+// TestPoolDeadClients tests that Get and Put close the clients which lost their connection
+// instead of handing them out, and that their slots go to new clients
+func TestPoolDeadClients(t *testing.T) {
+	srv := startTestServer(t)
+	pool := MakePool(srv.addr, PoolConfig{MaxActive: 1, MaxIdle: 1, Wait: true})
+	defer pool.Close()
+	idle, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(idle)
+
+	srv.stop()
+	waitDead(t, idle)
+	if _, err := pool.Get(); err == nil {
+		t.Fatal("Expected the dial of a new client to fail instead of getting the dead idle one")
+	}
+	if stats := pool.Stats(); stats.Active != 0 || stats.Idle != 0 {
+		t.Errorf("Expected the dead idle client to be dropped, got %+v", stats)
+	}
+
+	srv.start(t)
+	borrowed, err := pool.Get()
+	if err != nil || borrowed == idle {
+		t.Fatalf("Expected a new client, got %v", err)
+	}
+	// the caller blocked on MaxActive gets the slot of the dead client put back
+	waiter := getAsync(pool)
+	waitForWaiters(t, pool, 1)
+	srv.stop()
+	waitDead(t, borrowed)
+	pool.Put(borrowed)
+	select {
+	case err := <-waiter:
+		if err == nil {
+			t.Error("Expected the blocked caller to dial a new client, got the dead one")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the blocked caller to get the slot of the dead client")
+	}
+	if !borrowed.closing.Get() {
+		t.Error("Expected the dead client to be closed")
+	}
+	if stats := pool.Stats(); stats.Active != 0 || stats.Idle != 0 {
+		t.Errorf("Expected no client left, got %+v", stats)
+	}
+}
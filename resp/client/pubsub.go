@@ -0,0 +1,223 @@
+package client
+
+import (
+	"errors"
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/lib/sync/wait"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// Message is a message pushed by the server to a subscribed client
+type Message struct {
+	Channel string // channel the message was published to
+	Pattern string // matched pattern, only set for pattern subscriptions
+	Payload string
+}
+
+// Subscribe subscribes the client to the given channels
+// Messages of all subscriptions are delivered on the returned channel,
+// which is closed when the client is closed
+func (client *Client) Subscribe(channels ...string) (<-chan *Message, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("no channel to subscribe")
+	}
+	if err := client.sendSubscription("SUBSCRIBE", channels, len(channels)); err != nil {
+		return nil, err
+	}
+	return client.messages, nil
+}
+
+// PSubscribe subscribes the client to the given patterns
+func (client *Client) PSubscribe(patterns ...string) (<-chan *Message, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("no pattern to subscribe")
+	}
+	if err := client.sendSubscription("PSUBSCRIBE", patterns, len(patterns)); err != nil {
+		return nil, err
+	}
+	return client.messages, nil
+}
+
+// Unsubscribe unsubscribes the client from the given channels, or from all channels if none is given
+func (client *Client) Unsubscribe(channels ...string) error {
+	expect := len(channels)
+	if expect == 0 {
+		client.subMu.Lock()
+		expect = len(client.channels)
+		client.subMu.Unlock()
+	}
+	return client.sendSubscription("UNSUBSCRIBE", channels, expect)
+}
+
+// PUnsubscribe unsubscribes the client from the given patterns, or from all patterns if none is given
+func (client *Client) PUnsubscribe(patterns ...string) error {
+	expect := len(patterns)
+	if expect == 0 {
+		client.subMu.Lock()
+		expect = len(client.patterns)
+		client.subMu.Unlock()
+	}
+	return client.sendSubscription("PUNSUBSCRIBE", patterns, expect)
+}
+
+// SubscriptionCount returns the number of channels and patterns the client is subscribed to
+func (client *Client) SubscriptionCount() int {
+	client.subMu.Lock()
+	defer client.subMu.Unlock()
+	return len(client.channels) + len(client.patterns)
+}
+
+// sendSubscription sends a subscribe family command and waits for all of its confirmations
+// The server sends one confirmation per channel, at least one even if nothing was subscribed
+func (client *Client) sendSubscription(cmd string, targets []string, expect int) error {
+	if expect < 1 {
+		expect = 1
+	}
+	args := make([][]byte, len(targets)+1)
+	args[0] = []byte(cmd)
+	for i, target := range targets {
+		args[i+1] = []byte(target)
+	}
+	request := &request{
+		args:    args,
+		waiting: &wait.Wait{},
+		expect:  expect,
+	}
+	request.waiting.Add(expect)
+	client.working.Add(1)
+	defer client.working.Done()
+	if !client.enqueue(request) {
+		return errClientClosed
+	}
+	timeout := request.waiting.WaitWithTimeout(maxWait)
+	if timeout {
+		return errors.New("server time out")
+	}
+	if request.err != nil {
+		return request.err
+	}
+	for _, r := range request.replies {
+		if errReply, ok := r.(reply.ErrorReply); ok {
+			return errors.New(errReply.Error())
+		}
+	}
+	if errReply, ok := request.reply.(reply.ErrorReply); ok {
+		return errors.New(errReply.Error())
+	}
+	return nil
+}
+
+// handlePushMessage consumes replies which don't belong to any request:
+// messages pushed to subscribed channels. Subscription confirmations
+// update the local state but are still handed to the waiting request.
+// Returns true if the reply was consumed.
+func (client *Client) handlePushMessage(r resp.Reply) bool {
+	args, ok := flattenArray(r)
+	if !ok || len(args) < 3 {
+		return false
+	}
+	kind := strings.ToLower(string(args[0]))
+	switch kind {
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe":
+		client.updateSubscription(kind, string(args[1]), parseSubscriptionCount(args[2]))
+		return false
+	case "message", "pmessage":
+		// Only treat them as push messages in subscribed mode,
+		// otherwise they may be a normal array reply
+		if client.SubscriptionCount() == 0 {
+			return false
+		}
+		msg := &Message{}
+		if kind == "message" {
+			msg.Channel = string(args[1])
+			msg.Payload = string(args[2])
+		} else {
+			if len(args) < 4 {
+				return false
+			}
+			msg.Pattern = string(args[1])
+			msg.Channel = string(args[2])
+			msg.Payload = string(args[3])
+		}
+		client.deliverMessage(msg)
+		return true
+	}
+	return false
+}
+
+// updateSubscription applies a subscription confirmation to the local state
+// count is the number of subscriptions left on the server side
+func (client *Client) updateSubscription(kind string, target string, count int) {
+	client.subMu.Lock()
+	defer client.subMu.Unlock()
+	if count == 0 && (kind == "unsubscribe" || kind == "punsubscribe") {
+		client.channels = make(map[string]struct{})
+		client.patterns = make(map[string]struct{})
+		return
+	}
+	switch kind {
+	case "subscribe":
+		client.channels[target] = struct{}{}
+	case "psubscribe":
+		client.patterns[target] = struct{}{}
+	case "unsubscribe":
+		delete(client.channels, target)
+	case "punsubscribe":
+		delete(client.patterns, target)
+	}
+}
+
+// deliverMessage sends the message to the subscriber
+// The channel may be closed concurrently by Close, so recover from sending on a closed channel
+func (client *Client) deliverMessage(msg *Message) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Warn("drop message of channel " + msg.Channel + ": client closed")
+		}
+	}()
+	client.messages <- msg
+}
+
+// flattenArray converts an array of bulk strings and integers into plain arguments
+// Confirmations mix bulk strings with an integer count, so they are parsed as MultiRawReply
+// (or PushReply when the server speaks RESP3)
+func flattenArray(r resp.Reply) ([][]byte, bool) {
+	var elements []resp.Reply
+	switch re := r.(type) {
+	case *reply.MultiBulkReply:
+		return re.Args, true
+	case *reply.MultiRawReply:
+		elements = re.Replies
+	case *reply.PushReply:
+		elements = re.Replies
+	default:
+		return nil, false
+	}
+	args := make([][]byte, len(elements))
+	for i, element := range elements {
+		switch e := element.(type) {
+		case *reply.BulkReply:
+			args[i] = e.Arg
+		case *reply.IntReply:
+			args[i] = []byte(strconv.FormatInt(e.Code, 10))
+		case *reply.NullBulkReply, *reply.NullReply:
+			args[i] = nil
+		default:
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+// parseSubscriptionCount parses the count element of a confirmation,
+// which is an integer reply inside the array
+func parseSubscriptionCount(arg []byte) int {
+	count, err := strconv.Atoi(strings.TrimPrefix(string(arg), ":"))
+	if err != nil {
+		return 0
+	}
+	return count
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"redigo/lib/utils"
+	"testing"
+	"time"
+)
+
+// startSubscriber returns a started client of the server, closed at the end of the test
+func startSubscriber(t *testing.T, srv *testServer) *Client {
+	t.Helper()
+	c, err := MakeClient(srv.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	t.Cleanup(c.Close)
+	return c
+}
+
+// receive returns the next message of the subscriptions
+func receive(t *testing.T, messages <-chan *Message) *Message {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a message")
+		return nil
+	}
+}
+
+// TestSubscribe tests that the confirmations of SUBSCRIBE, PSUBSCRIBE and UNSUBSCRIBE update the
+// subscriptions of the client, and that the published messages are delivered
+func TestSubscribe(t *testing.T) {
+	srv := startTestServer(t)
+	c := startSubscriber(t, srv)
+	publisher := startSubscriber(t, srv)
+
+	if _, err := c.Subscribe(); err == nil {
+		t.Error("Expected SUBSCRIBE without channel to fail")
+	}
+	messages, err := c.Subscribe("a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := c.SubscriptionCount(); count != 2 {
+		t.Errorf("Expected 2 subscriptions, got %d", count)
+	}
+	if _, err := c.PSubscribe("news.*"); err != nil {
+		t.Fatal(err)
+	}
+	if count := c.SubscriptionCount(); count != 3 {
+		t.Errorf("Expected 3 subscriptions, got %d", count)
+	}
+
+	if r := publisher.Send(utils.ToCmdLine("PUBLISH", "a", "hello")); string(r.ToBytes()) != ":1\r\n" {
+		t.Errorf("Expected 1 receiver, got %q", r.ToBytes())
+	}
+	if msg := receive(t, messages); msg.Channel != "a" || msg.Pattern != "" || msg.Payload != "hello" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+	publisher.Send(utils.ToCmdLine("PUBLISH", "news.tech", "go"))
+	if msg := receive(t, messages); msg.Channel != "news.tech" || msg.Pattern != "news.*" || msg.Payload != "go" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	if err := c.Unsubscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+	if count := c.SubscriptionCount(); count != 2 {
+		t.Errorf("Expected 2 subscriptions after UNSUBSCRIBE a, got %d", count)
+	}
+	if r := publisher.Send(utils.ToCmdLine("PUBLISH", "a", "lost")); string(r.ToBytes()) != ":0\r\n" {
+		t.Errorf("Expected no receiver of a, got %q", r.ToBytes())
+	}
+	// without argument, UNSUBSCRIBE waits for the confirmation of each channel
+	if err := c.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	if count := c.SubscriptionCount(); count != 1 {
+		t.Errorf("Expected the pattern to be left, got %d subscriptions", count)
+	}
+	if err := c.PUnsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	if count := c.SubscriptionCount(); count != 0 {
+		t.Errorf("Expected no subscription, got %d", count)
+	}
+	// nothing subscribed, the server still confirms once
+	if err := c.Unsubscribe(); err != nil {
+		t.Error(err)
+	}
+	select {
+	case msg := <-messages:
+		t.Errorf("Expected no other message, got %+v", msg)
+	default:
+	}
+}
+
+// TestSubscribeReconnect tests that the channels and the patterns are subscribed again on the new
+// connection after the server went away, and that their messages are delivered
+func TestSubscribeReconnect(t *testing.T) {
+	srv := startTestServer(t)
+	c := startSubscriber(t, srv)
+	messages, err := c.Subscribe("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PSubscribe("news.*"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.stop()
+	srv.start(t)
+	publisher := startSubscriber(t, srv)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// the pattern is subscribed again after the channel
+		if r := publisher.Send(utils.ToCmdLine("PUBLISH", "news.tech", "again")); string(r.ToBytes()) == ":1\r\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the client to subscribe again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if msg := receive(t, messages); msg.Pattern != "news.*" || msg.Payload != "again" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+	publisher.Send(utils.ToCmdLine("PUBLISH", "a", "again"))
+	if msg := receive(t, messages); msg.Channel != "a" || msg.Payload != "again" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+	if count := c.SubscriptionCount(); count != 2 {
+		t.Errorf("Expected 2 subscriptions after the reconnection, got %d", count)
+	}
+}
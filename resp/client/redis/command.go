@@ -0,0 +1,504 @@
+package redis
+
+import (
+	"redigo/interface/resp"
+	"redigo/resp/client"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// baseCmd holds the arguments of a command and the error of its reply
+type baseCmd struct {
+	args []interface{}
+	err  error
+}
+
+// Args returns the arguments of the command, the name first
+func (cmd *baseCmd) Args() []interface{} {
+	return cmd.args
+}
+
+// Name returns the name of the command in lower case
+func (cmd *baseCmd) Name() string {
+	if len(cmd.args) == 0 {
+		return ""
+	}
+	return strings.ToLower(string(toArg(cmd.args[0])))
+}
+
+// Err returns the error of the command, Nil for a null reply
+func (cmd *baseCmd) Err() error {
+	return cmd.err
+}
+
+// SetErr replaces the error of the command
+func (cmd *baseCmd) SetErr(err error) {
+	cmd.err = err
+}
+
+// describe formats the command and its result like go-redis does, e.g. get key: value
+func (cmd *baseCmd) describe(val interface{}) string {
+	var sb strings.Builder
+	for i, arg := range cmd.args {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.Write(toArg(arg))
+	}
+	sb.WriteString(": ")
+	if cmd.err != nil {
+		sb.WriteString(cmd.err.Error())
+	} else {
+		sb.WriteString(toString(val))
+	}
+	return sb.String()
+}
+
+func toString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case []string:
+		return "[" + strings.Join(v, " ") + "]"
+	}
+	return string(toArg(val))
+}
+
+// Cmd is a command of any reply, the result of Do
+type Cmd struct {
+	baseCmd
+	val interface{}
+}
+
+func newCmd(args []interface{}, r resp.Reply, err error) *Cmd {
+	cmd := &Cmd{baseCmd: baseCmd{args: args, err: err}}
+	if err == nil {
+		cmd.val, cmd.err = toValue(r)
+	}
+	return cmd
+}
+
+// toValue converts a reply to string, int64, float64, bool or a slice of values, a null reply
+// is nil with the error Nil
+func toValue(r resp.Reply) (interface{}, error) {
+	switch re := r.(type) {
+	case *reply.IntReply:
+		return re.Code, nil
+	case *reply.BooleanReply:
+		return re.Value, nil
+	case *reply.DoubleReply:
+		return re.Value, nil
+	case *reply.MultiBulkReply:
+		values := make([]interface{}, len(re.Args))
+		for i, arg := range re.Args {
+			if arg != nil { // a nil element is a null bulk string
+				values[i] = string(arg)
+			}
+		}
+		return values, nil
+	case *reply.EmptyMultiBulkReply:
+		return []interface{}{}, nil
+	case *reply.MultiRawReply:
+		return toValues(re.Replies)
+	case *reply.SetReply:
+		return toValues(re.Replies)
+	}
+	str, err := client.GetString(r)
+	if err != nil {
+		return nil, err
+	}
+	return str, nil
+}
+
+func toValues(replies []resp.Reply) (interface{}, error) {
+	values := make([]interface{}, len(replies))
+	for i, element := range replies {
+		val, err := toValue(element)
+		if err != nil && err != Nil {
+			return nil, err
+		}
+		values[i] = val
+	}
+	return values, nil
+}
+
+// Val returns the value of the reply
+func (cmd *Cmd) Val() interface{} {
+	return cmd.val
+}
+
+// Result returns the value of the reply and the error
+func (cmd *Cmd) Result() (interface{}, error) {
+	return cmd.val, cmd.err
+}
+
+// Text returns the value as a string
+func (cmd *Cmd) Text() (string, error) {
+	if cmd.err != nil {
+		return "", cmd.err
+	}
+	return toString(cmd.val), nil
+}
+
+// Int64 returns the value as an integer
+func (cmd *Cmd) Int64() (int64, error) {
+	if cmd.err != nil {
+		return 0, cmd.err
+	}
+	switch v := cmd.val.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, unexpectedValue(cmd.val, "integer")
+}
+
+// Bool returns the value as a boolean, an integer is true unless it is 0
+func (cmd *Cmd) Bool() (bool, error) {
+	if cmd.err != nil {
+		return false, cmd.err
+	}
+	switch v := cmd.val.(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case string:
+		return strconv.ParseBool(v)
+	}
+	return false, unexpectedValue(cmd.val, "boolean")
+}
+
+func (cmd *Cmd) String() string {
+	return cmd.describe(cmd.val)
+}
+
+// StatusCmd is a command replying a status, like SET
+type StatusCmd struct {
+	baseCmd
+	val string
+}
+
+func newStatusCmd(args []interface{}, r resp.Reply, err error) *StatusCmd {
+	cmd := &StatusCmd{baseCmd: baseCmd{args: args, err: err}}
+	if err == nil {
+		cmd.val, cmd.err = client.GetString(r)
+	}
+	return cmd
+}
+
+// Val returns the status
+func (cmd *StatusCmd) Val() string {
+	return cmd.val
+}
+
+// Result returns the status and the error
+func (cmd *StatusCmd) Result() (string, error) {
+	return cmd.val, cmd.err
+}
+
+func (cmd *StatusCmd) String() string {
+	return cmd.describe(cmd.val)
+}
+
+// StringCmd is a command replying a bulk string, like GET
+type StringCmd struct {
+	baseCmd
+	val string
+}
+
+func newStringCmd(args []interface{}, r resp.Reply, err error) *StringCmd {
+	cmd := &StringCmd{baseCmd: baseCmd{args: args, err: err}}
+	if err == nil {
+		cmd.val, cmd.err = client.GetString(r)
+	}
+	return cmd
+}
+
+// Val returns the string
+func (cmd *StringCmd) Val() string {
+	return cmd.val
+}
+
+// Result returns the string and the error, Nil if the key does not exist
+func (cmd *StringCmd) Result() (string, error) {
+	return cmd.val, cmd.err
+}
+
+// Bytes returns the string as bytes
+func (cmd *StringCmd) Bytes() ([]byte, error) {
+	return []byte(cmd.val), cmd.err
+}
+
+// Int64 parses the string as an integer
+func (cmd *StringCmd) Int64() (int64, error) {
+	if cmd.err != nil {
+		return 0, cmd.err
+	}
+	return strconv.ParseInt(cmd.val, 10, 64)
+}
+
+// Int parses the string as an integer
+func (cmd *StringCmd) Int() (int, error) {
+	if cmd.err != nil {
+		return 0, cmd.err
+	}
+	return strconv.Atoi(cmd.val)
+}
+
+// Float64 parses the string as a float
+func (cmd *StringCmd) Float64() (float64, error) {
+	if cmd.err != nil {
+		return 0, cmd.err
+	}
+	return strconv.ParseFloat(cmd.val, 64)
+}
+
+func (cmd *StringCmd) String() string {
+	return cmd.describe(cmd.val)
+}
+
+// IntCmd is a command replying an integer, like INCR
+type IntCmd struct {
+	baseCmd
+	val int64
+}
+
+func newIntCmd(args []interface{}, r resp.Reply, err error) *IntCmd {
+	cmd := &IntCmd{baseCmd: baseCmd{args: args, err: err}}
+	if err == nil {
+		cmd.val, cmd.err = client.GetInt(r)
+	}
+	return cmd
+}
+
+// Val returns the integer
+func (cmd *IntCmd) Val() int64 {
+	return cmd.val
+}
+
+// Result returns the integer and the error
+func (cmd *IntCmd) Result() (int64, error) {
+	return cmd.val, cmd.err
+}
+
+func (cmd *IntCmd) String() string {
+	return cmd.describe(cmd.val)
+}
+
+// BoolCmd is a command replying 1 or 0, like EXPIRE, or a status meaning true, like SET NX
+type BoolCmd struct {
+	baseCmd
+	val bool
+}
+
+func newBoolCmd(args []interface{}, r resp.Reply, err error) *BoolCmd {
+	cmd := &BoolCmd{baseCmd: baseCmd{args: args, err: err}}
+	if err != nil {
+		return cmd
+	}
+	switch r.(type) {
+	case *reply.OKReply, *reply.StatusReply:
+		cmd.val = true
+		return cmd
+	}
+	n, err := client.GetInt(r)
+	switch {
+	case err == Nil:
+		// SET NX replies null when the key exists
+	case err != nil:
+		cmd.err = err
+	default:
+		cmd.val = n == 1
+	}
+	return cmd
+}
+
+// Val returns the boolean
+func (cmd *BoolCmd) Val() bool {
+	return cmd.val
+}
+
+// Result returns the boolean and the error
+func (cmd *BoolCmd) Result() (bool, error) {
+	return cmd.val, cmd.err
+}
+
+func (cmd *BoolCmd) String() string {
+	return cmd.describe(cmd.val)
+}
+
+// FloatCmd is a command replying a float, like ZSCORE
+type FloatCmd struct {
+	baseCmd
+	val float64
+}
+
+func newFloatCmd(args []interface{}, r resp.Reply, err error) *FloatCmd {
+	cmd := &FloatCmd{baseCmd: baseCmd{args: args, err: err}}
+	if err != nil {
+		return cmd
+	}
+	if double, ok := r.(*reply.DoubleReply); ok {
+		cmd.val = double.Value
+		return cmd
+	}
+	str, err := client.GetString(r)
+	if err != nil {
+		cmd.err = err
+		return cmd
+	}
+	cmd.val, cmd.err = strconv.ParseFloat(str, 64)
+	return cmd
+}
+
+// Val returns the float
+func (cmd *FloatCmd) Val() float64 {
+	return cmd.val
+}
+
+// Result returns the float and the error
+func (cmd *FloatCmd) Result() (float64, error) {
+	return cmd.val, cmd.err
+}
+
+func (cmd *FloatCmd) String() string {
+	return cmd.describe(cmd.val)
+}
+
+// DurationCmd is a command replying a time to live, like TTL, the negative replies -1 (no
+// expiration) and -2 (no key) are kept as is
+type DurationCmd struct {
+	baseCmd
+	val time.Duration
+}
+
+func newDurationCmd(args []interface{}, r resp.Reply, err error, precision time.Duration) *DurationCmd {
+	cmd := &DurationCmd{baseCmd: baseCmd{args: args, err: err}}
+	if err != nil {
+		return cmd
+	}
+	n, err := client.GetInt(r)
+	switch {
+	case err != nil:
+		cmd.err = err
+	case n < 0:
+		cmd.val = time.Duration(n)
+	default:
+		cmd.val = time.Duration(n) * precision
+	}
+	return cmd
+}
+
+// Val returns the duration
+func (cmd *DurationCmd) Val() time.Duration {
+	return cmd.val
+}
+
+// Result returns the duration and the error
+func (cmd *DurationCmd) Result() (time.Duration, error) {
+	return cmd.val, cmd.err
+}
+
+func (cmd *DurationCmd) String() string {
+	return cmd.describe(cmd.val.String())
+}
+
+// StringSliceCmd is a command replying an array of strings, like LRANGE
+type StringSliceCmd struct {
+	baseCmd
+	val []string
+}
+
+func newStringSliceCmd(args []interface{}, r resp.Reply, err error) *StringSliceCmd {
+	cmd := &StringSliceCmd{baseCmd: baseCmd{args: args, err: err}}
+	if err == nil {
+		cmd.val, cmd.err = client.GetStringSlice(r)
+	}
+	return cmd
+}
+
+// Val returns the strings
+func (cmd *StringSliceCmd) Val() []string {
+	return cmd.val
+}
+
+// Result returns the strings and the error
+func (cmd *StringSliceCmd) Result() ([]string, error) {
+	return cmd.val, cmd.err
+}
+
+func (cmd *StringSliceCmd) String() string {
+	return cmd.describe(cmd.val)
+}
+
+// SliceCmd is a command replying an array of values which may be null, like MGET or HMGET, a
+// null element is nil
+type SliceCmd struct {
+	baseCmd
+	val []interface{}
+}
+
+func newSliceCmd(args []interface{}, r resp.Reply, err error) *SliceCmd {
+	cmd := &SliceCmd{baseCmd: baseCmd{args: args, err: err}}
+	if err != nil {
+		return cmd
+	}
+	val, err := toValue(r)
+	if err != nil {
+		cmd.err = err
+		return cmd
+	}
+	values, ok := val.([]interface{})
+	if !ok {
+		cmd.err = unexpectedValue(val, "array")
+		return cmd
+	}
+	cmd.val = values
+	return cmd
+}
+
+// Val returns the values
+func (cmd *SliceCmd) Val() []interface{} {
+	return cmd.val
+}
+
+// Result returns the values and the error
+func (cmd *SliceCmd) Result() ([]interface{}, error) {
+	return cmd.val, cmd.err
+}
+
+func (cmd *SliceCmd) String() string {
+	return cmd.describe(cmd.val)
+}
+
+// MapStringStringCmd is a command replying field-value pairs, like HGETALL
+type MapStringStringCmd struct {
+	baseCmd
+	val map[string]string
+}
+
+func newMapStringStringCmd(args []interface{}, r resp.Reply, err error) *MapStringStringCmd {
+	cmd := &MapStringStringCmd{baseCmd: baseCmd{args: args, err: err}}
+	if err == nil {
+		cmd.val, cmd.err = client.GetMap(r)
+	}
+	return cmd
+}
+
+// Val returns the map
+func (cmd *MapStringStringCmd) Val() map[string]string {
+	return cmd.val
+}
+
+// Result returns the map and the error
+func (cmd *MapStringStringCmd) Result() (map[string]string, error) {
+	return cmd.val, cmd.err
+}
+
+func (cmd *MapStringStringCmd) String() string {
+	return cmd.describe(cmd.val)
+}
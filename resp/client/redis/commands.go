@@ -0,0 +1,429 @@
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// Z is a member of a sorted set with its score
+type Z struct {
+	Score  float64
+	Member interface{}
+}
+
+// appendArgs appends the values to args, a single slice or map is flattened like go-redis does,
+// a map into field-value pairs
+func appendArgs(args []interface{}, values []interface{}) []interface{} {
+	if len(values) == 1 {
+		switch v := values[0].(type) {
+		case []string:
+			for _, s := range v {
+				args = append(args, s)
+			}
+			return args
+		case []interface{}:
+			return append(args, v...)
+		case map[string]interface{}:
+			for field, value := range v {
+				args = append(args, field, value)
+			}
+			return args
+		case map[string]string:
+			for field, value := range v {
+				args = append(args, field, value)
+			}
+			return args
+		}
+	}
+	return append(args, values...)
+}
+
+// keysArgs returns the arguments of a command taking a list of keys
+func keysArgs(name string, keys []string) []interface{} {
+	args := make([]interface{}, 1, 1+len(keys))
+	args[0] = name
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	return args
+}
+
+// usePrecise reports whether the expiration must be sent in milliseconds
+func usePrecise(expiration time.Duration) bool {
+	return expiration < time.Second || expiration%time.Second != 0
+}
+
+// appendExpiration appends EX or PX to the arguments of SET, no expiration appends nothing
+func appendExpiration(args []interface{}, expiration time.Duration) []interface{} {
+	switch {
+	case expiration <= 0:
+		return args
+	case usePrecise(expiration):
+		return append(args, "px", expiration.Milliseconds())
+	}
+	return append(args, "ex", int64(expiration/time.Second))
+}
+
+func (c *Client) statusCmd(ctx context.Context, args ...interface{}) *StatusCmd {
+	r, err := c.process(ctx, args)
+	return newStatusCmd(args, r, err)
+}
+
+func (c *Client) stringCmd(ctx context.Context, args ...interface{}) *StringCmd {
+	r, err := c.process(ctx, args)
+	return newStringCmd(args, r, err)
+}
+
+func (c *Client) intCmd(ctx context.Context, args ...interface{}) *IntCmd {
+	r, err := c.process(ctx, args)
+	return newIntCmd(args, r, err)
+}
+
+func (c *Client) boolCmd(ctx context.Context, args ...interface{}) *BoolCmd {
+	r, err := c.process(ctx, args)
+	return newBoolCmd(args, r, err)
+}
+
+func (c *Client) floatCmd(ctx context.Context, args ...interface{}) *FloatCmd {
+	r, err := c.process(ctx, args)
+	return newFloatCmd(args, r, err)
+}
+
+func (c *Client) stringSliceCmd(ctx context.Context, args ...interface{}) *StringSliceCmd {
+	r, err := c.process(ctx, args)
+	return newStringSliceCmd(args, r, err)
+}
+
+func (c *Client) sliceCmd(ctx context.Context, args ...interface{}) *SliceCmd {
+	r, err := c.process(ctx, args)
+	return newSliceCmd(args, r, err)
+}
+
+func (c *Client) mapStringStringCmd(ctx context.Context, args ...interface{}) *MapStringStringCmd {
+	r, err := c.process(ctx, args)
+	return newMapStringStringCmd(args, r, err)
+}
+
+func (c *Client) durationCmd(ctx context.Context, precision time.Duration, args ...interface{}) *DurationCmd {
+	r, err := c.process(ctx, args)
+	return newDurationCmd(args, r, err, precision)
+}
+
+// Ping sends PING
+func (c *Client) Ping(ctx context.Context) *StatusCmd {
+	return c.statusCmd(ctx, "ping")
+}
+
+// Echo sends ECHO
+func (c *Client) Echo(ctx context.Context, message interface{}) *StringCmd {
+	return c.stringCmd(ctx, "echo", message)
+}
+
+// Del deletes the keys and returns the number of deleted keys
+func (c *Client) Del(ctx context.Context, keys ...string) *IntCmd {
+	return c.intCmd(ctx, keysArgs("del", keys)...)
+}
+
+// Exists returns the number of existing keys
+func (c *Client) Exists(ctx context.Context, keys ...string) *IntCmd {
+	return c.intCmd(ctx, keysArgs("exists", keys)...)
+}
+
+// Expire sets the time to live of the key in seconds
+func (c *Client) Expire(ctx context.Context, key string, expiration time.Duration) *BoolCmd {
+	return c.boolCmd(ctx, "expire", key, int64(expiration/time.Second))
+}
+
+// PExpire sets the time to live of the key in milliseconds
+func (c *Client) PExpire(ctx context.Context, key string, expiration time.Duration) *BoolCmd {
+	return c.boolCmd(ctx, "pexpire", key, expiration.Milliseconds())
+}
+
+// TTL returns the time to live of the key, -1 if it has none and -2 if the key does not exist
+func (c *Client) TTL(ctx context.Context, key string) *DurationCmd {
+	return c.durationCmd(ctx, time.Second, "ttl", key)
+}
+
+// PTTL returns the time to live of the key in milliseconds
+func (c *Client) PTTL(ctx context.Context, key string) *DurationCmd {
+	return c.durationCmd(ctx, time.Millisecond, "pttl", key)
+}
+
+// Persist removes the time to live of the key
+func (c *Client) Persist(ctx context.Context, key string) *BoolCmd {
+	return c.boolCmd(ctx, "persist", key)
+}
+
+// Type returns the type of the value of the key
+func (c *Client) Type(ctx context.Context, key string) *StatusCmd {
+	return c.statusCmd(ctx, "type", key)
+}
+
+// Rename renames the key
+func (c *Client) Rename(ctx context.Context, key string, newKey string) *StatusCmd {
+	return c.statusCmd(ctx, "rename", key, newKey)
+}
+
+// RenameNX renames the key if the new key does not exist
+func (c *Client) RenameNX(ctx context.Context, key string, newKey string) *BoolCmd {
+	return c.boolCmd(ctx, "renamenx", key, newKey)
+}
+
+// Keys returns the keys matching the pattern
+func (c *Client) Keys(ctx context.Context, pattern string) *StringSliceCmd {
+	return c.stringSliceCmd(ctx, "keys", pattern)
+}
+
+// FlushDB deletes all the keys of the DB
+func (c *Client) FlushDB(ctx context.Context) *StatusCmd {
+	return c.statusCmd(ctx, "flushdb")
+}
+
+// Get returns the value of the key, Nil if it does not exist
+func (c *Client) Get(ctx context.Context, key string) *StringCmd {
+	return c.stringCmd(ctx, "get", key)
+}
+
+// Set sets the value of the key, a zero expiration means no time to live
+func (c *Client) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *StatusCmd {
+	args := appendExpiration([]interface{}{"set", key, value}, expiration)
+	return c.statusCmd(ctx, args...)
+}
+
+// SetNX sets the value of the key if it does not exist
+func (c *Client) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *BoolCmd {
+	if expiration <= 0 {
+		return c.boolCmd(ctx, "setnx", key, value)
+	}
+	args := appendExpiration([]interface{}{"set", key, value}, expiration)
+	return c.boolCmd(ctx, append(args, "nx")...)
+}
+
+// SetEx sets the value and the time to live of the key
+func (c *Client) SetEx(ctx context.Context, key string, value interface{}, expiration time.Duration) *StatusCmd {
+	return c.statusCmd(ctx, "setex", key, int64(expiration/time.Second), value)
+}
+
+// GetSet sets the value of the key and returns the old one
+func (c *Client) GetSet(ctx context.Context, key string, value interface{}) *StringCmd {
+	return c.stringCmd(ctx, "getset", key, value)
+}
+
+// GetRange returns the substring of the value from start to end included
+func (c *Client) GetRange(ctx context.Context, key string, start int64, end int64) *StringCmd {
+	return c.stringCmd(ctx, "getrange", key, start, end)
+}
+
+// SetRange overwrites the value from offset and returns its new length
+func (c *Client) SetRange(ctx context.Context, key string, offset int64, value string) *IntCmd {
+	return c.intCmd(ctx, "setrange", key, offset, value)
+}
+
+// StrLen returns the length of the value
+func (c *Client) StrLen(ctx context.Context, key string) *IntCmd {
+	return c.intCmd(ctx, "strlen", key)
+}
+
+// Append appends to the value and returns its new length
+func (c *Client) Append(ctx context.Context, key string, value string) *IntCmd {
+	return c.intCmd(ctx, "append", key, value)
+}
+
+// Incr increments the integer value by one
+func (c *Client) Incr(ctx context.Context, key string) *IntCmd {
+	return c.intCmd(ctx, "incr", key)
+}
+
+// IncrBy increments the integer value
+func (c *Client) IncrBy(ctx context.Context, key string, value int64) *IntCmd {
+	return c.intCmd(ctx, "incrby", key, value)
+}
+
+// IncrByFloat increments the float value
+func (c *Client) IncrByFloat(ctx context.Context, key string, value float64) *FloatCmd {
+	return c.floatCmd(ctx, "incrbyfloat", key, value)
+}
+
+// Decr decrements the integer value by one
+func (c *Client) Decr(ctx context.Context, key string) *IntCmd {
+	return c.intCmd(ctx, "decr", key)
+}
+
+// DecrBy decrements the integer value
+func (c *Client) DecrBy(ctx context.Context, key string, value int64) *IntCmd {
+	return c.intCmd(ctx, "decrby", key, value)
+}
+
+// HGet returns the value of the field, Nil if it does not exist
+func (c *Client) HGet(ctx context.Context, key string, field string) *StringCmd {
+	return c.stringCmd(ctx, "hget", key, field)
+}
+
+// HSet sets the fields, given as field-value pairs, a map or a slice of pairs, and returns the
+// number of added fields
+func (c *Client) HSet(ctx context.Context, key string, values ...interface{}) *IntCmd {
+	return c.intCmd(ctx, appendArgs([]interface{}{"hset", key}, values)...)
+}
+
+// HSetNX sets the field if it does not exist
+func (c *Client) HSetNX(ctx context.Context, key string, field string, value interface{}) *BoolCmd {
+	return c.boolCmd(ctx, "hsetnx", key, field, value)
+}
+
+// HMSet sets the fields, given like those of HSet
+func (c *Client) HMSet(ctx context.Context, key string, values ...interface{}) *BoolCmd {
+	return c.boolCmd(ctx, appendArgs([]interface{}{"hmset", key}, values)...)
+}
+
+// HMGet returns the values of the fields, nil for a missing field
+func (c *Client) HMGet(ctx context.Context, key string, fields ...string) *SliceCmd {
+	args := []interface{}{"hmget", key}
+	for _, field := range fields {
+		args = append(args, field)
+	}
+	return c.sliceCmd(ctx, args...)
+}
+
+// HGetAll returns all the fields and values of the hash, empty if the key does not exist
+func (c *Client) HGetAll(ctx context.Context, key string) *MapStringStringCmd {
+	return c.mapStringStringCmd(ctx, "hgetall", key)
+}
+
+// HDel deletes the fields and returns the number of deleted fields
+func (c *Client) HDel(ctx context.Context, key string, fields ...string) *IntCmd {
+	args := []interface{}{"hdel", key}
+	for _, field := range fields {
+		args = append(args, field)
+	}
+	return c.intCmd(ctx, args...)
+}
+
+// HExists reports whether the field exists
+func (c *Client) HExists(ctx context.Context, key string, field string) *BoolCmd {
+	return c.boolCmd(ctx, "hexists", key, field)
+}
+
+// HLen returns the number of fields
+func (c *Client) HLen(ctx context.Context, key string) *IntCmd {
+	return c.intCmd(ctx, "hlen", key)
+}
+
+// HKeys returns the fields
+func (c *Client) HKeys(ctx context.Context, key string) *StringSliceCmd {
+	return c.stringSliceCmd(ctx, "hkeys", key)
+}
+
+// HVals returns the values
+func (c *Client) HVals(ctx context.Context, key string) *StringSliceCmd {
+	return c.stringSliceCmd(ctx, "hvals", key)
+}
+
+// LPush adds the values at the head and returns the length of the list
+func (c *Client) LPush(ctx context.Context, key string, values ...interface{}) *IntCmd {
+	return c.intCmd(ctx, appendArgs([]interface{}{"lpush", key}, values)...)
+}
+
+// RPush adds the values at the tail and returns the length of the list
+func (c *Client) RPush(ctx context.Context, key string, values ...interface{}) *IntCmd {
+	return c.intCmd(ctx, appendArgs([]interface{}{"rpush", key}, values)...)
+}
+
+// LPop removes and returns the element at the head, Nil if the list does not exist
+func (c *Client) LPop(ctx context.Context, key string) *StringCmd {
+	return c.stringCmd(ctx, "lpop", key)
+}
+
+// RPop removes and returns the element at the tail, Nil if the list does not exist
+func (c *Client) RPop(ctx context.Context, key string) *StringCmd {
+	return c.stringCmd(ctx, "rpop", key)
+}
+
+// LLen returns the length of the list
+func (c *Client) LLen(ctx context.Context, key string) *IntCmd {
+	return c.intCmd(ctx, "llen", key)
+}
+
+// LRange returns the elements from start to stop included
+func (c *Client) LRange(ctx context.Context, key string, start int64, stop int64) *StringSliceCmd {
+	return c.stringSliceCmd(ctx, "lrange", key, start, stop)
+}
+
+// LIndex returns the element at index, Nil if it is out of range
+func (c *Client) LIndex(ctx context.Context, key string, index int64) *StringCmd {
+	return c.stringCmd(ctx, "lindex", key, index)
+}
+
+// LSet replaces the element at index
+func (c *Client) LSet(ctx context.Context, key string, index int64, value interface{}) *StatusCmd {
+	return c.statusCmd(ctx, "lset", key, index, value)
+}
+
+// SAdd adds the members and returns the number of added members
+func (c *Client) SAdd(ctx context.Context, key string, members ...interface{}) *IntCmd {
+	return c.intCmd(ctx, appendArgs([]interface{}{"sadd", key}, members)...)
+}
+
+// SRem removes the members and returns the number of removed members
+func (c *Client) SRem(ctx context.Context, key string, members ...interface{}) *IntCmd {
+	return c.intCmd(ctx, appendArgs([]interface{}{"srem", key}, members)...)
+}
+
+// SMembers returns the members of the set
+func (c *Client) SMembers(ctx context.Context, key string) *StringSliceCmd {
+	return c.stringSliceCmd(ctx, "smembers", key)
+}
+
+// SIsMember reports whether the member is in the set
+func (c *Client) SIsMember(ctx context.Context, key string, member interface{}) *BoolCmd {
+	return c.boolCmd(ctx, "sismember", key, member)
+}
+
+// SCard returns the number of members
+func (c *Client) SCard(ctx context.Context, key string) *IntCmd {
+	return c.intCmd(ctx, "scard", key)
+}
+
+// SPop removes and returns a random member, Nil if the set does not exist
+func (c *Client) SPop(ctx context.Context, key string) *StringCmd {
+	return c.stringCmd(ctx, "spop", key)
+}
+
+// ZAdd adds the members or updates their scores and returns the number of added members
+func (c *Client) ZAdd(ctx context.Context, key string, members ...Z) *IntCmd {
+	args := make([]interface{}, 2, 2+2*len(members))
+	args[0], args[1] = "zadd", key
+	for _, m := range members {
+		args = append(args, m.Score, m.Member)
+	}
+	return c.intCmd(ctx, args...)
+}
+
+// ZScore returns the score of the member, Nil if it is not in the sorted set
+func (c *Client) ZScore(ctx context.Context, key string, member string) *FloatCmd {
+	return c.floatCmd(ctx, "zscore", key, member)
+}
+
+// ZRange returns the members from start to stop included, by ascending score
+func (c *Client) ZRange(ctx context.Context, key string, start int64, stop int64) *StringSliceCmd {
+	return c.stringSliceCmd(ctx, "zrange", key, start, stop)
+}
+
+// ZRem removes the members and returns the number of removed members
+func (c *Client) ZRem(ctx context.Context, key string, members ...interface{}) *IntCmd {
+	return c.intCmd(ctx, appendArgs([]interface{}{"zrem", key}, members)...)
+}
+
+// ZCard returns the number of members
+func (c *Client) ZCard(ctx context.Context, key string) *IntCmd {
+	return c.intCmd(ctx, "zcard", key)
+}
+
+// ZRank returns the rank of the member, Nil if it is not in the sorted set
+func (c *Client) ZRank(ctx context.Context, key string, member string) *IntCmd {
+	return c.intCmd(ctx, "zrank", key, member)
+}
+
+// Publish sends the message to the channel and returns the number of receivers
+func (c *Client) Publish(ctx context.Context, channel string, message interface{}) *IntCmd {
+	return c.intCmd(ctx, "publish", channel, message)
+}
@@ -0,0 +1,170 @@
+// Package redis is a small adapter exposing the API of go-redis backed by resp/client, so that
+// an application written for go-redis switches to redigo by changing its import path
+//
+// The commands return the same typed results as go-redis, e.g. Get returns a *StringCmd whose
+// Result is the value and the error, Nil when the key does not exist. Only a subset of the
+// commands has a typed method, the others can be sent with Do.
+//
+//	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+//	val, err := rdb.Get(ctx, "key").Result()
+//	if err == redis.Nil {
+//		// key does not exist
+//	}
+package redis
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"redigo/interface/resp"
+	"redigo/resp/client"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Nil is the error of a command which replied a null value, e.g. GET of a missing key
+var Nil = client.ErrNil
+
+// Options are the options of NewClient, a subset of those of go-redis
+type Options struct {
+	Addr     string // host:port of the server, 127.0.0.1:6379 if empty
+	Password string // sent with AUTH by every connection, empty sends no AUTH
+	DB       int    // selected by every connection
+	// PoolSize is the max number of connections, 0 means 10 per CPU like go-redis
+	PoolSize int
+	// PoolTimeout is the max time a command waits for a connection when all are busy, 0 means
+	// no limit
+	PoolTimeout time.Duration
+}
+
+// Client is a client of a server, safe for concurrent use
+// Each command borrows a connection of the pool so that a blocking command does not hold the
+// others back
+type Client struct {
+	opt  Options
+	pool *client.Pool
+}
+
+// NewClient creates a client, the connections are opened on the first commands
+func NewClient(opt *Options) *Client {
+	o := *opt
+	if o.Addr == "" {
+		o.Addr = "127.0.0.1:6379"
+	}
+	if o.PoolSize <= 0 {
+		o.PoolSize = 10 * runtime.GOMAXPROCS(0)
+	}
+	return &Client{
+		opt: o,
+		pool: client.MakePool(o.Addr, client.PoolConfig{
+			MaxActive:   o.PoolSize,
+			MaxIdle:     o.PoolSize,
+			Wait:        true,
+			WaitTimeout: o.PoolTimeout,
+			Password:    o.Password,
+			DB:          o.DB,
+		}),
+	}
+}
+
+// Options returns the options of the client
+func (c *Client) Options() *Options {
+	return &c.opt
+}
+
+// Close closes the connections of the client
+func (c *Client) Close() error {
+	c.pool.Close()
+	return nil
+}
+
+// String describes the client like go-redis does
+func (c *Client) String() string {
+	return "Redis<" + c.opt.Addr + " db:" + strconv.Itoa(c.opt.DB) + ">"
+}
+
+// process sends the command on a connection of the pool, the context is checked before sending
+// since resp/client applies its own timeout to the replies
+func (c *Client) process(ctx context.Context, args []interface{}) (resp.Reply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cli, err := c.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer c.pool.Put(cli)
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = toArg(arg)
+	}
+	r := cli.Send(cmdLine)
+	if client.IsTimeout(r) {
+		return nil, fmt.Errorf("redis: %s timed out", cmdLine[0])
+	}
+	return r, nil
+}
+
+// Do sends any command, the value of the result is a string, an int64, a float64, a bool or a
+// []interface{} of those, depending on the reply
+func (c *Client) Do(ctx context.Context, args ...interface{}) *Cmd {
+	r, err := c.process(ctx, args)
+	return newCmd(args, r, err)
+}
+
+// toArg converts an argument of a command to bytes like go-redis does: strings and integers as
+// is, floats in the shortest form, booleans as 1 or 0, durations in nanoseconds
+func toArg(arg interface{}) []byte {
+	switch v := arg.(type) {
+	case nil:
+		return []byte{}
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	case int:
+		return []byte(strconv.Itoa(v))
+	case int8:
+		return []byte(strconv.FormatInt(int64(v), 10))
+	case int16:
+		return []byte(strconv.FormatInt(int64(v), 10))
+	case int32:
+		return []byte(strconv.FormatInt(int64(v), 10))
+	case int64:
+		return []byte(strconv.FormatInt(v, 10))
+	case uint:
+		return []byte(strconv.FormatUint(uint64(v), 10))
+	case uint8:
+		return []byte(strconv.FormatUint(uint64(v), 10))
+	case uint16:
+		return []byte(strconv.FormatUint(uint64(v), 10))
+	case uint32:
+		return []byte(strconv.FormatUint(uint64(v), 10))
+	case uint64:
+		return []byte(strconv.FormatUint(v, 10))
+	case float32:
+		return []byte(strconv.FormatFloat(float64(v), 'f', -1, 32))
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		if v {
+			return []byte("1")
+		}
+		return []byte("0")
+	case time.Duration:
+		return []byte(strconv.FormatInt(v.Nanoseconds(), 10))
+	case time.Time:
+		return []byte(v.Format(time.RFC3339Nano))
+	case encoding.BinaryMarshaler:
+		if b, err := v.MarshalBinary(); err == nil {
+			return b
+		}
+	}
+	return []byte(fmt.Sprint(arg))
+}
+
+// unexpectedValue is the error of a value which cannot be converted to the expected type
+func unexpectedValue(val interface{}, expected string) error {
+	return fmt.Errorf("redis: unexpected value %v of type %T, expected %s", val, val, expected)
+}
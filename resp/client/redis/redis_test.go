@@ -0,0 +1,208 @@
+package redis
+
+import (
+	"context"
+	"net"
+	"redigo/config"
+	"redigo/resp/handler"
+	"redigo/tcp"
+	"testing"
+	"time"
+)
+
+// startServer starts a standalone server on a free port and returns its address
+func startServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Properties.Self = ""
+	config.Properties.Peers = nil
+	config.Properties.AppendOnly = false
+	config.Properties.Dir = t.TempDir()
+	h := handler.MakeHandler()
+	closeCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		tcp.ListenAndServe(listener, h, closeCh)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(closeCh)
+		<-done
+	})
+	return listener.Addr().String()
+}
+
+// newTestClient creates a client of the server on the DB, closed at the end of the test
+func newTestClient(t *testing.T, addr string, db int) *Client {
+	rdb := NewClient(&Options{Addr: addr, DB: db, PoolSize: 2})
+	t.Cleanup(func() {
+		_ = rdb.Close()
+	})
+	return rdb
+}
+
+// TestCommands tests the typed results of the commands of each type against the server
+func TestCommands(t *testing.T) {
+	ctx := context.Background()
+	rdb := newTestClient(t, startServer(t), 0)
+
+	if val, err := rdb.Ping(ctx).Result(); err != nil || val != "PONG" {
+		t.Errorf("Expected PONG, got %q (%v)", val, err)
+	}
+	if err := rdb.Set(ctx, "str", 1.5, 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := rdb.Get(ctx, "str").Float64(); err != nil || val != 1.5 {
+		t.Errorf("Expected 1.5, got %v (%v)", val, err)
+	}
+	if _, err := rdb.Get(ctx, "missing").Result(); err != Nil {
+		t.Errorf("Expected Nil for a missing key, got %v", err)
+	}
+	if ok, err := rdb.SetNX(ctx, "str", "v", 0).Result(); err != nil || ok {
+		t.Errorf("Expected SETNX of an existing key to fail, got %v (%v)", ok, err)
+	}
+	if ok, err := rdb.SetNX(ctx, "str", "v", time.Minute).Result(); err != nil || ok {
+		t.Errorf("Expected SET NX of an existing key to fail, got %v (%v)", ok, err)
+	}
+	if ok, err := rdb.SetNX(ctx, "nx", "v", time.Minute).Result(); err != nil || !ok {
+		t.Errorf("Expected SET NX of a new key to succeed, got %v (%v)", ok, err)
+	}
+	if ttl, err := rdb.TTL(ctx, "nx").Result(); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expected a TTL of at most a minute, got %v (%v)", ttl, err)
+	}
+	if ttl := rdb.TTL(ctx, "str").Val(); ttl != -1 {
+		t.Errorf("Expected -1 for a key without TTL, got %v", ttl)
+	}
+	if n, err := rdb.IncrBy(ctx, "counter", 5).Result(); err != nil || n != 5 {
+		t.Errorf("Expected 5, got %d (%v)", n, err)
+	}
+	if _, err := rdb.Incr(ctx, "str").Result(); err == nil {
+		t.Error("Expected an error incrementing a float")
+	}
+
+	if n, err := rdb.HSet(ctx, "hash", "a", 1, "b", true).Result(); err != nil || n != 2 {
+		t.Errorf("Expected 2 fields, got %d (%v)", n, err)
+	}
+	if m, err := rdb.HGetAll(ctx, "hash").Result(); err != nil || len(m) != 2 || m["a"] != "1" || m["b"] != "1" {
+		t.Errorf("Unexpected hash %v (%v)", m, err)
+	}
+	if vals, err := rdb.HMGet(ctx, "hash", "a", "missing").Result(); err != nil || len(vals) != 2 || vals[0] != "1" || vals[1] != nil {
+		t.Errorf("Unexpected fields %v (%v)", vals, err)
+	}
+
+	rdb.RPush(ctx, "list", "a", "b", "c")
+	if vals, err := rdb.LRange(ctx, "list", 0, -1).Result(); err != nil || len(vals) != 3 || vals[2] != "c" {
+		t.Errorf("Unexpected list %v (%v)", vals, err)
+	}
+	if val, err := rdb.LPop(ctx, "list").Result(); err != nil || val != "a" {
+		t.Errorf("Expected a, got %q (%v)", val, err)
+	}
+
+	rdb.SAdd(ctx, "set", "x", "y")
+	if ok := rdb.SIsMember(ctx, "set", "x").Val(); !ok {
+		t.Error("Expected x to be a member")
+	}
+	if members := rdb.SMembers(ctx, "set").Val(); len(members) != 2 {
+		t.Errorf("Expected 2 members, got %v", members)
+	}
+
+	if n, err := rdb.ZAdd(ctx, "zset", Z{Score: 2, Member: "b"}, Z{Score: 1, Member: "a"}).Result(); err != nil || n != 2 {
+		t.Errorf("Expected 2 members, got %d (%v)", n, err)
+	}
+	if score, err := rdb.ZScore(ctx, "zset", "b").Result(); err != nil || score != 2 {
+		t.Errorf("Expected 2, got %v (%v)", score, err)
+	}
+	if members := rdb.ZRange(ctx, "zset", 0, -1).Val(); len(members) != 2 || members[0] != "a" {
+		t.Errorf("Unexpected members %v", members)
+	}
+
+	if typ := rdb.Type(ctx, "zset").Val(); typ != "zset" {
+		t.Errorf("Expected zset, got %s", typ)
+	}
+	if n := rdb.Del(ctx, "str", "hash", "missing").Val(); n != 2 {
+		t.Errorf("Expected 2 keys deleted, got %d", n)
+	}
+	if _, err := rdb.HGet(ctx, "list", "f").Result(); err == nil || err == Nil {
+		t.Errorf("Expected the WRONGTYPE error, got %v", err)
+	}
+	if s := rdb.Get(ctx, "counter").String(); s != "get counter: 5" {
+		t.Errorf("Unexpected description %q", s)
+	}
+}
+
+// TestDo tests the values of Do for each kind of reply
+func TestDo(t *testing.T) {
+	ctx := context.Background()
+	rdb := newTestClient(t, startServer(t), 0)
+
+	rdb.RPush(ctx, "list", "a", "b")
+	if val, err := rdb.Do(ctx, "lrange", "list", 0, -1).Result(); err != nil || len(val.([]interface{})) != 2 {
+		t.Errorf("Unexpected array %v (%v)", val, err)
+	}
+	if n, err := rdb.Do(ctx, "llen", "list").Int64(); err != nil || n != 2 {
+		t.Errorf("Expected 2, got %d (%v)", n, err)
+	}
+	if ok, err := rdb.Do(ctx, "exists", "list").Bool(); err != nil || !ok {
+		t.Errorf("Expected true, got %v (%v)", ok, err)
+	}
+	if text, err := rdb.Do(ctx, "lindex", "list", 1).Text(); err != nil || text != "b" {
+		t.Errorf("Expected b, got %q (%v)", text, err)
+	}
+	if err := rdb.Do(ctx, "get", "missing").Err(); err != Nil {
+		t.Errorf("Expected Nil, got %v", err)
+	}
+	if err := rdb.Do(ctx, "nosuchcommand").Err(); err == nil {
+		t.Error("Expected an error for an unknown command")
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := rdb.Do(canceled, "ping").Err(); err != context.Canceled {
+		t.Errorf("Expected the error of the context, got %v", err)
+	}
+}
+
+// TestOptionsDB tests that the connections select the DB of the options
+func TestOptionsDB(t *testing.T) {
+	ctx := context.Background()
+	addr := startServer(t)
+	db0, db1 := newTestClient(t, addr, 0), newTestClient(t, addr, 1)
+	db1.Set(ctx, "key", "v", 0)
+	if n := db0.Exists(ctx, "key").Val(); n != 0 {
+		t.Error("Expected the key to be set in DB 1 only")
+	}
+	if val := db1.Get(ctx, "key").Val(); val != "v" {
+		t.Errorf("Expected v, got %q", val)
+	}
+	if s := db1.String(); s != "Redis<"+addr+" db:1>" {
+		t.Errorf("Unexpected description %s", s)
+	}
+}
+
+// TestToArg tests the conversion of the arguments like go-redis does
+func TestToArg(t *testing.T) {
+	cases := []struct {
+		arg      interface{}
+		expected string
+	}{
+		{nil, ""},
+		{"s", "s"},
+		{[]byte("b"), "b"},
+		{-3, "-3"},
+		{uint8(7), "7"},
+		{1.25, "1.25"},
+		{float32(0.5), "0.5"},
+		{true, "1"},
+		{false, "0"},
+		{2 * time.Millisecond, "2000000"},
+		{struct{ A int }{1}, "{1}"},
+	}
+	for _, c := range cases {
+		if got := string(toArg(c.arg)); got != c.expected {
+			t.Errorf("Expected %q for %v, got %q", c.expected, c.arg, got)
+		}
+	}
+}
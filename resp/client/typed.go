@@ -0,0 +1,138 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"redigo/interface/resp"
+	"redigo/resp/reply"
+	"strconv"
+)
+
+// ErrNil is returned by the typed helpers when the server replies a null value
+var ErrNil = errors.New("redigo: nil reply")
+
+// GetString converts a bulk string or status reply to string
+func GetString(r resp.Reply) (string, error) {
+	switch re := r.(type) {
+	case *reply.BulkReply:
+		return string(re.Arg), nil
+	case *reply.StatusReply:
+		return re.Status, nil
+	case *reply.OKReply:
+		return "OK", nil
+	case *reply.PongReply:
+		return "PONG", nil
+	case *reply.EmptyBulkReply:
+		return "", nil
+	case *reply.VerbatimStringReply:
+		return string(re.Text), nil
+	case *reply.BigNumberReply:
+		return re.Number, nil
+	case *reply.DoubleReply:
+		return reply.FormatDouble(re.Value), nil
+	}
+	return "", convertError(r, "string")
+}
+
+// GetInt converts an integer reply, or a bulk string holding an integer, to int64
+func GetInt(r resp.Reply) (int64, error) {
+	switch re := r.(type) {
+	case *reply.IntReply:
+		return re.Code, nil
+	case *reply.BulkReply:
+		val, err := strconv.ParseInt(string(re.Arg), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("redigo: cannot convert %q to integer", re.Arg)
+		}
+		return val, nil
+	case *reply.BooleanReply:
+		if re.Value {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, convertError(r, "integer")
+}
+
+// GetStringSlice converts an array reply to a slice of strings
+// Null elements are converted to empty strings
+func GetStringSlice(r resp.Reply) ([]string, error) {
+	var elements []resp.Reply
+	switch re := r.(type) {
+	case *reply.MultiBulkReply:
+		result := make([]string, len(re.Args))
+		for i, arg := range re.Args {
+			result[i] = string(arg)
+		}
+		return result, nil
+	case *reply.EmptyMultiBulkReply:
+		return []string{}, nil
+	case *reply.MultiRawReply:
+		elements = re.Replies
+	case *reply.SetReply:
+		elements = re.Replies
+	default:
+		return nil, convertError(r, "array")
+	}
+	result := make([]string, len(elements))
+	for i, element := range elements {
+		switch e := element.(type) {
+		case *reply.NullBulkReply, *reply.NullReply:
+			continue
+		case *reply.IntReply:
+			result[i] = strconv.FormatInt(e.Code, 10)
+			continue
+		}
+		str, err := GetString(element)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = str
+	}
+	return result, nil
+}
+
+// GetMap converts an array of field-value pairs (e.g. the reply of HGETALL) or a RESP3 map to a map
+func GetMap(r resp.Reply) (map[string]string, error) {
+	if mapReply, ok := r.(*reply.MapReply); ok {
+		result := make(map[string]string, len(mapReply.Keys))
+		for i := range mapReply.Keys {
+			key, err := GetString(mapReply.Keys[i])
+			if err != nil {
+				return nil, err
+			}
+			value, err := GetString(mapReply.Values[i])
+			if err != nil && err != ErrNil {
+				return nil, err
+			}
+			result[key] = value
+		}
+		return result, nil
+	}
+	values, err := GetStringSlice(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(values)%2 != 0 {
+		return nil, errors.New("redigo: array reply has odd number of elements, cannot convert to map")
+	}
+	result := make(map[string]string, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		result[values[i]] = values[i+1]
+	}
+	return result, nil
+}
+
+// convertError returns the error for a reply which cannot be converted to the expected type
+// Error replies are returned as is, null replies as ErrNil
+func convertError(r resp.Reply, expected string) error {
+	switch re := r.(type) {
+	case nil:
+		return ErrNil
+	case *reply.NullBulkReply, *reply.NullMultiBulkReply, *reply.NullReply:
+		return ErrNil
+	case reply.ErrorReply:
+		return errors.New(re.Error())
+	}
+	return fmt.Errorf("redigo: unexpected reply %T, expected %s", r, expected)
+}
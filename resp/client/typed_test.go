@@ -0,0 +1,127 @@
+package client
+
+import (
+	"redigo/interface/resp"
+	"redigo/resp/reply"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// typedCase is a reply converted by a typed helper, err is a part of the expected error message,
+// empty if the conversion succeeds
+type typedCase struct {
+	name  string
+	reply resp.Reply
+	want  interface{}
+	err   string
+}
+
+// checkTyped checks the result of a typed helper against a case
+func checkTyped(t *testing.T, c typedCase, got interface{}, err error) {
+	t.Helper()
+	if c.err != "" {
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected an error containing %q, got %v (%v)", c.name, c.err, err, got)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("%s: unexpected error %v", c.name, err)
+	} else if !reflect.DeepEqual(got, c.want) {
+		t.Errorf("%s: expected %#v, got %#v", c.name, c.want, got)
+	}
+}
+
+// failingCases are the replies no helper converts: the null replies, the errors and a reply of
+// another type
+func failingCases(wrongType resp.Reply, expected string) []typedCase {
+	return []typedCase{
+		{name: "nil", reply: nil, err: ErrNil.Error()},
+		{name: "null bulk", reply: reply.MakeNullBulkReply(), err: ErrNil.Error()},
+		{name: "null array", reply: reply.MakeNullMultiBulkReply(), err: ErrNil.Error()},
+		{name: "RESP3 null", reply: reply.MakeNullReply(), err: ErrNil.Error()},
+		{name: "error", reply: reply.MakeStandardErrorReply("ERR boom"), err: "ERR boom"},
+		{name: "wrong type", reply: reply.MakeWrongTypeErrReply(), err: "WRONG TYPE"},
+		{name: "other type", reply: wrongType, err: "expected " + expected},
+	}
+}
+
+// TestGetString tests the replies converted to a string and the failures
+func TestGetString(t *testing.T) {
+	cases := []typedCase{
+		{name: "bulk", reply: reply.MakeBulkReply([]byte("v")), want: "v"},
+		{name: "empty bulk", reply: reply.MakeEmptyBulkReply(), want: ""},
+		{name: "status", reply: reply.MakeStatusReply("QUEUED"), want: "QUEUED"},
+		{name: "OK", reply: reply.MakeOKReply(), want: "OK"},
+		{name: "PONG", reply: reply.MakePongReply(), want: "PONG"},
+		{name: "verbatim", reply: reply.MakeVerbatimStringReply("txt", []byte("text")), want: "text"},
+		{name: "big number", reply: reply.MakeBigNumberReply("12345678901234567890"), want: "12345678901234567890"},
+		{name: "double", reply: reply.MakeDoubleReply(1.5), want: "1.5"},
+	}
+	for _, c := range append(cases, failingCases(reply.MakeIntReply(1), "string")...) {
+		got, err := GetString(c.reply)
+		checkTyped(t, c, got, err)
+	}
+}
+
+// TestGetInt tests the replies converted to an integer and the failures
+func TestGetInt(t *testing.T) {
+	cases := []typedCase{
+		{name: "integer", reply: reply.MakeIntReply(-3), want: int64(-3)},
+		{name: "bulk", reply: reply.MakeBulkReply([]byte("42")), want: int64(42)},
+		{name: "bulk not a number", reply: reply.MakeBulkReply([]byte("4x")), err: `cannot convert "4x" to integer`},
+		{name: "true", reply: reply.MakeBooleanReply(true), want: int64(1)},
+		{name: "false", reply: reply.MakeBooleanReply(false), want: int64(0)},
+	}
+	for _, c := range append(cases, failingCases(reply.MakeStatusReply("OK"), "integer")...) {
+		got, err := GetInt(c.reply)
+		checkTyped(t, c, got, err)
+	}
+}
+
+// TestGetStringSlice tests the arrays converted to a slice of strings and the failures
+func TestGetStringSlice(t *testing.T) {
+	cases := []typedCase{
+		{name: "bulk array", reply: reply.MakeMultiBulkReply([][]byte{[]byte("a"), nil}), want: []string{"a", ""}},
+		{name: "empty array", reply: reply.MakeEmptyMultiBulkReply(), want: []string{}},
+		{name: "mixed array", reply: reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("a")), reply.MakeIntReply(2), reply.MakeNullBulkReply(), reply.MakeStatusReply("s"),
+		}), want: []string{"a", "2", "", "s"}},
+		{name: "set", reply: reply.MakeBulkSetReply([][]byte{[]byte("m")}), want: []string{"m"}},
+		{name: "error element", reply: reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("a")), reply.MakeStandardErrorReply("ERR element"),
+		}), err: "ERR element"},
+		{name: "nested array", reply: reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeMultiBulkReply([][]byte{[]byte("a")}),
+		}), err: "expected string"},
+	}
+	for _, c := range append(cases, failingCases(reply.MakeBulkReply([]byte("a")), "array")...) {
+		got, err := GetStringSlice(c.reply)
+		checkTyped(t, c, got, err)
+	}
+}
+
+// TestGetMap tests the field-value arrays and the RESP3 maps converted to a map and the failures
+func TestGetMap(t *testing.T) {
+	cases := []typedCase{
+		{name: "pairs", reply: reply.MakeMultiBulkReply([][]byte{[]byte("f"), []byte("v"), []byte("g"), []byte("w")}),
+			want: map[string]string{"f": "v", "g": "w"}},
+		{name: "empty array", reply: reply.MakeEmptyMultiBulkReply(), want: map[string]string{}},
+		{name: "odd array", reply: reply.MakeMultiBulkReply([][]byte{[]byte("f")}), err: "odd number of elements"},
+		{name: "map", reply: reply.MakeMapReply(
+			[]resp.Reply{reply.MakeBulkReply([]byte("f")), reply.MakeBulkReply([]byte("n"))},
+			[]resp.Reply{reply.MakeBulkReply([]byte("v")), reply.MakeNullReply()},
+		), want: map[string]string{"f": "v", "n": ""}},
+		{name: "map with a null key", reply: reply.MakeMapReply(
+			[]resp.Reply{reply.MakeNullReply()}, []resp.Reply{reply.MakeBulkReply([]byte("v"))},
+		), err: ErrNil.Error()},
+		{name: "map with an error value", reply: reply.MakeMapReply(
+			[]resp.Reply{reply.MakeBulkReply([]byte("f"))}, []resp.Reply{reply.MakeStandardErrorReply("ERR value")},
+		), err: "ERR value"},
+	}
+	for _, c := range append(cases, failingCases(reply.MakeIntReply(1), "array")...) {
+		got, err := GetMap(c.reply)
+		checkTyped(t, c, got, err)
+	}
+}
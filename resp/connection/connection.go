@@ -1,29 +1,81 @@
 package connection
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"redigo/acl"
+	"redigo/config"
+	"redigo/interface/resp"
+	"redigo/lib/logger"
 	"redigo/lib/sync/wait"
+	"redigo/resp/reply"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultWriteTimeout 是未配置 writeTimeout 时写入一个回复的最长时间
+const DefaultWriteTimeout = 10 * time.Second
+
+// Write 返回的错误类型，可以用 errors.Is 判断
+var (
+	// ErrWriteTimeout 表示客户端在期限内没有读取回复，连接已被关闭
+	ErrWriteTimeout = errors.New("write timeout")
+	// ErrConnClosed 表示连接已经关闭，或之前的写入失败导致连接不可用
+	ErrConnClosed = errors.New("connection closed")
+)
+
 // Connection 表示客户端和服务端的连接
 type Connection struct {
 	conn         net.Conn   // 底层的网络连接
 	waitingReply wait.Wait  // 等待完成响应的同步器
 	mu           sync.Mutex // 发送响应时的互斥锁
-	selectedDB   int        // 选择的数据库的编号
+	authed       bool       // 是否已通过 AUTH 认证，只在设置了 requirepass 时检查
+	writeErr     error      // 第一次写入失败的错误，之后的写入直接返回该错误
+	asking       bool       // 收到 ASKING 后为 true，只对下一条命令有效
+	readOnly     bool       // 集群模式下收到 READONLY 后为 true，直到 READWRITE
+	// 以下字段由 CLIENT LIST 在其他连接的协程中读取，因此是原子变量
+	selectedDB atomic.Int32             // 选择的数据库的编号
+	protocol   atomic.Int32             // HELLO 协商的协议版本，0 表示默认的 RESP2
+	user       atomic.Pointer[acl.User] // 连接认证的用户，nil 表示默认用户
+	subs       atomic.Int32             // 订阅的频道和模式的数量
+
+	id        uint64    // CLIENT ID 返回的编号，按连接建立的顺序递增
+	createdAt time.Time // 连接建立的时间
+	// 以下字段由 CLIENT LIST 在其他连接的协程中读取，修改和读取时持有 infoMu
+	infoMu          sync.Mutex
+	name            string    // CLIENT SETNAME 设置的名称
+	lastCmd         string    // 最近一次执行的命令的名称
+	lastInteraction time.Time // 最近一次收到命令的时间
 }
 
+// nextID 是下一个连接的编号
+var nextID atomic.Uint64
+
 // NewConnection 创建一个新的连接
 func NewConnection(conn net.Conn) *Connection {
+	now := time.Now()
 	return &Connection{
-		conn: conn,
+		conn:            conn,
+		id:              nextID.Add(1),
+		createdAt:       now,
+		lastInteraction: now,
 	}
 }
 
-// RemoteAddr 返回远程客户端的地址
+// LocalAddr 返回连接的本地地址
+func (c *Connection) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr 返回远程客户端的地址，没有底层连接时返回 nil
 func (c *Connection) RemoteAddr() net.Addr {
+	if c.conn == nil {
+		return nil
+	}
 	return c.conn.RemoteAddr()
 }
 
@@ -34,7 +86,8 @@ func (c *Connection) Close() error {
 	return nil
 }
 
-// Write 向客户端发送数据
+// Write 向客户端发送数据，直到全部写完、超过写入期限或出错
+// 写入失败后回复流可能只写了一部分，无法再继续使用，因此关闭底层连接，读循环随之结束并清理客户端
 func (c *Connection) Write(b []byte) error {
 	if len(b) == 0 {
 		return nil
@@ -45,17 +98,228 @@ func (c *Connection) Write(b []byte) error {
 		c.waitingReply.Done()
 		c.mu.Unlock()
 	}()
+	return c.write(b)
+}
+
+// streamBufferSize 是分段写出回复时每次发送的字节数
+const streamBufferSize = 64 * 1024
 
-	_, err := c.conn.Write(b)
+// streamWriters 复用分段写出回复的缓冲区
+var streamWriters = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewWriterSize(nil, streamBufferSize)
+	},
+}
+
+// WriteReply 向客户端发送回复，resp.StreamReply 逐块写出，不在内存中拼接完整的回复
+// 整个回复在锁内写完，推送的消息不会插入回复的中间；每一块重新计算写入期限，
+// 客户端只要在持续读取，大回复就不会因为总耗时超过 writeTimeout 而断开
+// resp.HeldReply 在持有数据的锁时写出，元素直接从数据结构写到连接
+func (c *Connection) WriteReply(r resp.Reply) error {
+	if hr, ok := r.(resp.HeldReply); ok {
+		var err error
+		hr.Hold(func() {
+			err = c.writeStream(hr)
+		})
+		return err
+	}
+	sr, ok := r.(resp.StreamReply)
+	if !ok {
+		return c.Write(r.ToBytes())
+	}
+	return c.writeStream(sr)
+}
+
+// writeStream 在连接的锁内分块写出回复
+func (c *Connection) writeStream(sr resp.StreamReply) error {
+	c.mu.Lock()
+	c.waitingReply.Add(1)
+	defer func() {
+		c.waitingReply.Done()
+		c.mu.Unlock()
+	}()
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	w := streamWriters.Get().(*bufio.Writer)
+	w.Reset(lockedWriter{c})
+	defer func() {
+		w.Reset(nil)
+		streamWriters.Put(w)
+	}()
+	if _, err := sr.WriteTo(w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// lockedWriter 在已持有 c.mu 时写入连接
+type lockedWriter struct {
+	c *Connection
+}
+
+func (w lockedWriter) Write(b []byte) (int, error) {
+	if err := w.c.write(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// write 在写入期限内写完 b，调用方必须持有 c.mu
+func (c *Connection) write(b []byte) error {
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout()))
+	for len(b) > 0 {
+		n, err := c.conn.Write(b)
+		b = b[n:]
+		if err == nil && n == 0 {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			c.writeErr = classifyWriteError(err)
+			logger.Warn("write to " + c.conn.RemoteAddr().String() + " failed, closing the connection: " + err.Error())
+			_ = c.conn.Close()
+			return c.writeErr
+		}
+	}
+	return nil
+}
+
+// writeTimeout 返回配置的写入期限
+func writeTimeout() time.Duration {
+	if config.Properties == nil || config.Properties.WriteTimeout <= 0 {
+		return DefaultWriteTimeout
+	}
+	return time.Duration(config.Properties.WriteTimeout) * time.Millisecond
+}
+
+// classifyWriteError 将写入错误归类为 ErrWriteTimeout、ErrConnClosed 或原始错误
+func classifyWriteError(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Errorf("%w: %v", ErrWriteTimeout, err)
+	case errors.Is(err, net.ErrClosed), errors.Is(err, io.ErrClosedPipe):
+		return fmt.Errorf("%w: %v", ErrConnClosed, err)
+	}
 	return err
 }
 
 // GetDBIndex returns selected db
 func (c *Connection) GetDBIndex() int {
-	return c.selectedDB
+	return int(c.selectedDB.Load())
 }
 
 // SelectDB selects a database
 func (c *Connection) SelectDB(dbNum int) {
-	c.selectedDB = dbNum
+	c.selectedDB.Store(int32(dbNum))
+}
+
+// GetProtocol returns the protocol version, RESP2 unless RESP3 was negotiated
+func (c *Connection) GetProtocol() int {
+	if protocol := c.protocol.Load(); protocol != 0 {
+		return int(protocol)
+	}
+	return reply.RESP2
+}
+
+// SetProtocol sets the protocol version
+func (c *Connection) SetProtocol(protocol int) {
+	c.protocol.Store(int32(protocol))
+}
+
+// GetSubscriptions returns the number of channels and patterns the connection is subscribed to
+func (c *Connection) GetSubscriptions() int {
+	return int(c.subs.Load())
+}
+
+// SetSubscriptions sets the number of subscriptions of the connection
+func (c *Connection) SetSubscriptions(n int) {
+	c.subs.Store(int32(n))
+}
+
+// GetUser returns the user of the connection, the default user unless another one authenticated
+func (c *Connection) GetUser() *acl.User {
+	if user := c.user.Load(); user != nil {
+		return user
+	}
+	return acl.DefaultUser()
+}
+
+// IsAuthenticated reports whether the connection authenticated with AUTH
+func (c *Connection) IsAuthenticated() bool {
+	return c.authed
+}
+
+// SetAuthenticated sets the authentication state of the connection
+func (c *Connection) SetAuthenticated(authed bool) {
+	c.authed = authed
+}
+
+// SetUser sets the user of the connection
+func (c *Connection) SetUser(user *acl.User) {
+	c.user.Store(user)
+}
+
+// SetAsking 记录连接发送了 ASKING，下一条命令可以访问正在导入的槽
+func (c *Connection) SetAsking(asking bool) {
+	c.asking = asking
+}
+
+// TakeAsking 返回连接是否发送了 ASKING 并清除该标记
+func (c *Connection) TakeAsking() bool {
+	asking := c.asking
+	c.asking = false
+	return asking
+}
+
+// SetReadOnly 记录连接发送了 READONLY 或 READWRITE
+func (c *Connection) SetReadOnly(readOnly bool) {
+	c.readOnly = readOnly
+}
+
+// IsReadOnly 返回连接是否发送了 READONLY，副本节点在本地执行它的只读命令
+func (c *Connection) IsReadOnly() bool {
+	return c.readOnly
+}
+
+// GetID 返回连接的编号
+func (c *Connection) GetID() uint64 {
+	return c.id
+}
+
+// CreatedAt 返回连接建立的时间
+func (c *Connection) CreatedAt() time.Time {
+	return c.createdAt
+}
+
+// GetName 返回 CLIENT SETNAME 设置的名称，未设置时为空
+func (c *Connection) GetName() string {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	return c.name
+}
+
+// SetName 设置连接的名称，空字符串清除名称
+func (c *Connection) SetName(name string) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	c.name = name
+}
+
+// SetLastCommand 记录收到的命令的名称和时间
+func (c *Connection) SetLastCommand(cmd string) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	c.lastCmd = cmd
+	c.lastInteraction = time.Now()
+}
+
+// GetLastCommand 返回最近一次执行的命令的名称和收到它的时间
+func (c *Connection) GetLastCommand() (string, time.Time) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	return c.lastCmd, c.lastInteraction
 }
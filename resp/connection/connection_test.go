@@ -0,0 +1,191 @@
+package connection
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"redigo/config"
+	"redigo/resp/reply"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// makeElements returns the elements of a reply larger than several chunks of streamBufferSize
+func makeElements() [][]byte {
+	var elements [][]byte
+	for i := 0; i < 4*streamBufferSize/16; i++ {
+		elements = append(elements, []byte(strconv.Itoa(1000000+i)))
+	}
+	return elements
+}
+
+// TestWriteHeldReply tests that a held reply is written out in chunks while its elements are
+// produced, in Hold and before the lock of the connection is taken
+func TestWriteHeldReply(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewConnection(server)
+	elements := makeElements()
+	expected := reply.MakeMultiBulkReply(elements).ToBytes()
+
+	var received atomic.Int64
+	done := make(chan []byte)
+	go func() {
+		buf := make([]byte, len(expected))
+		for n := 0; n < len(buf); {
+			read, err := client.Read(buf[n:])
+			if err != nil {
+				break
+			}
+			n += read
+			received.Store(int64(n))
+		}
+		done <- buf
+	}()
+
+	held := false
+	receivedBeforeLast := int64(0)
+	r := reply.MakeElementsReply('*', func(fn func()) {
+		if !c.mu.TryLock() {
+			t.Error("Expected Hold to be called before the lock of the connection is taken")
+		} else {
+			c.mu.Unlock()
+		}
+		held = true
+		fn()
+		held = false
+	}, func(header func(n int), element func(elem []byte) bool) reply.ErrorReply {
+		if !held {
+			t.Error("Expected the elements to be produced in Hold")
+		}
+		header(len(elements))
+		for i, elem := range elements {
+			if i == len(elements)-1 {
+				receivedBeforeLast = received.Load()
+			}
+			element(elem)
+		}
+		return nil
+	})
+	if err := c.WriteReply(r); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-done; !bytes.Equal(got, expected) {
+		t.Errorf("Expected the %d elements, got %d bytes", len(elements), len(got))
+	}
+	if receivedBeforeLast < streamBufferSize {
+		t.Errorf("Expected the first chunks to be sent before the last element is produced, %d bytes were", receivedBeforeLast)
+	}
+}
+
+// TestWriteReplyClosedPeer tests that a reply to a closed peer fails with ErrConnClosed, and that the
+// following writes return the same error
+func TestWriteReplyClosedPeer(t *testing.T) {
+	server, client := net.Pipe()
+	c := NewConnection(server)
+	go func() {
+		// read a part of the reply then go away
+		_, _ = io.ReadFull(client, make([]byte, streamBufferSize))
+		_ = client.Close()
+	}()
+	err := c.WriteReply(reply.MakeMultiBulkReply(makeElements()))
+	if !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Expected ErrConnClosed, got %v", err)
+	}
+	if again := c.Write([]byte("+OK\r\n")); again != err {
+		t.Errorf("Expected the first error to be returned again, got %v", again)
+	}
+}
+
+// setWriteTimeout sets the writeTimeout option in milliseconds until the end of the test
+func setWriteTimeout(t *testing.T, ms int) {
+	saved := config.Properties.WriteTimeout
+	t.Cleanup(func() {
+		config.Properties.WriteTimeout = saved
+	})
+	config.Properties.WriteTimeout = ms
+}
+
+// TestWriteTimeout tests that a write to a peer which stops reading fails after writeTimeout and
+// closes the connection, while a peer reading slowly gets a reply longer to send than writeTimeout
+func TestWriteTimeout(t *testing.T) {
+	setWriteTimeout(t, 100)
+	server, client := net.Pipe()
+	c := NewConnection(server)
+	start := time.Now()
+	err := c.Write(bytes.Repeat([]byte("a"), 1024))
+	if !errors.Is(err, ErrWriteTimeout) {
+		t.Errorf("Expected ErrWriteTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the write to wait writeTimeout, failed after %v", elapsed)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the connection to be closed, got %v", err)
+	}
+	if again := c.WriteReply(reply.MakeMultiBulkReply(makeElements())); again != err {
+		t.Errorf("Expected the first error to be returned again, got %v", again)
+	}
+
+	server, client = net.Pipe()
+	defer client.Close()
+	c = NewConnection(server)
+	elements := makeElements()
+	expected := reply.MakeMultiBulkReply(elements).ToBytes()
+	done := make(chan int)
+	go func() {
+		buf := make([]byte, streamBufferSize)
+		n := 0
+		for n < len(expected) {
+			read, err := client.Read(buf)
+			if err != nil {
+				break
+			}
+			n += read
+			time.Sleep(40 * time.Millisecond)
+		}
+		done <- n
+	}()
+	start = time.Now()
+	if err := c.WriteReply(reply.MakeMultiBulkReply(elements)); err != nil {
+		t.Errorf("Expected the reply read slowly to be written, got %v", err)
+	}
+	if n := <-done; n != len(expected) {
+		t.Errorf("Expected %d bytes, got %d", len(expected), n)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the reply to take longer than writeTimeout to be read, took %v", elapsed)
+	}
+}
+
+// shortConn is a connection accepting at most max bytes per write, or none with a zero max
+type shortConn struct {
+	net.Conn
+	max     int
+	written bytes.Buffer
+}
+
+func (c *shortConn) Write(b []byte) (int, error) {
+	n := min(len(b), c.max)
+	c.written.Write(b[:n])
+	return n, nil
+}
+
+// TestPartialWrites tests that the partial writes are resumed until the data is written, and that
+// a write making no progress fails
+func TestPartialWrites(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &shortConn{Conn: server, max: 3}
+	c := NewConnection(conn)
+	if err := c.Write([]byte("+OK\r\n$5\r\nhello\r\n")); err != nil || conn.written.String() != "+OK\r\n$5\r\nhello\r\n" {
+		t.Errorf("Expected the data to be written in full, got %q (%v)", conn.written.String(), err)
+	}
+	conn.max = 0
+	if err := c.Write([]byte("+OK\r\n")); !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("Expected io.ErrShortWrite, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"redigo/capture"
+	"redigo/config"
+	"redigo/lib/logger"
+	"redigo/resp/connection"
+	"strings"
+)
+
+// unrecordedCommands are neither recorded in the traffic capture nor shown to MONITOR: AUTH holds
+// the password and the commands of the replication stream are not client traffic
+var unrecordedCommands = map[string]struct{}{
+	"auth":     {},
+	"psync":    {},
+	"sync":     {},
+	"replconf": {},
+}
+
+// openCapture opens the traffic capture of traffic-capture, nil when it is not set
+func openCapture() *capture.Recorder {
+	if config.Properties.TrafficCapture == "" {
+		return nil
+	}
+	recorder, err := capture.Open(config.Properties.TrafficCapture)
+	if err != nil {
+		logger.Error("traffic capture disabled: " + err.Error())
+		return nil
+	}
+	logger.Info("capturing the traffic to " + config.Properties.TrafficCapture)
+	return recorder
+}
+
+// captureCommand records a command of the client in the traffic capture, without the AUTH
+// option of HELLO
+func (h *RespHandler) captureCommand(client *connection.Connection, args [][]byte) {
+	if h.capture == nil {
+		return
+	}
+	name := strings.ToLower(string(args[0]))
+	if _, ok := unrecordedCommands[name]; ok {
+		return
+	}
+	if name == "hello" {
+		args = withoutHelloAuth(args)
+	}
+	h.capture.Record(client.GetID(), args)
+}
+
+// withoutHelloAuth removes the AUTH option and its username and password from HELLO
+func withoutHelloAuth(args [][]byte) [][]byte {
+	for i := 2; i < len(args); i++ {
+		if strings.EqualFold(string(args[i]), "auth") && i+2 < len(args) {
+			return append(append([][]byte{}, args[:i]...), args[i+3:]...)
+		}
+	}
+	return args
+}
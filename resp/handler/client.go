@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"redigo/interface/resp"
+	"redigo/resp/connection"
+	"redigo/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commandName returns the name of the command recorded as the last command of the client, with
+// the subcommand for CLIENT like Redis does, e.g. client|list
+func commandName(args [][]byte) string {
+	name := strings.ToLower(string(args[0]))
+	if name == "client" && len(args) > 1 {
+		name += "|" + strings.ToLower(string(args[1]))
+	}
+	return name
+}
+
+// execClient implements the CLIENT command, it reports whether the client itself was killed and
+// must be closed once the reply is sent
+// CLIENT ID | GETNAME | SETNAME name | INFO | LIST [ID id ...] | KILL addr | KILL filter value ...
+func (h *RespHandler) execClient(client *connection.Connection, args [][]byte) (resp.Reply, bool) {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("client"), false
+	}
+	sub := strings.ToLower(string(args[1]))
+	switch sub {
+	case "id":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|id"), false
+		}
+		return reply.MakeIntReply(int64(client.GetID())), false
+	case "getname":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|getname"), false
+		}
+		name := client.GetName()
+		if name == "" {
+			return reply.MakeNullBulkReply(), false
+		}
+		return reply.MakeBulkReply([]byte(name)), false
+	case "setname":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("client|setname"), false
+		}
+		return execClientSetName(client, string(args[2])), false
+	case "info":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|info"), false
+		}
+		return reply.MakeBulkReply([]byte(clientInfo(client, time.Now()) + "\n")), false
+	case "list":
+		return h.execClientList(args[2:]), false
+	case "kill":
+		return h.execClientKill(client, args[2:])
+	}
+	return reply.MakeStandardErrorReply("ERR unknown subcommand '" + string(args[1]) +
+		"'. Try CLIENT ID, CLIENT GETNAME, CLIENT SETNAME, CLIENT INFO, CLIENT LIST or CLIENT KILL."), false
+}
+
+// execClientSetName sets the name of the client, an empty name removes it
+func execClientSetName(client *connection.Connection, name string) resp.Reply {
+	if errReply := checkClientName(name); errReply != nil {
+		return errReply
+	}
+	client.SetName(name)
+	return reply.MakeOKReply()
+}
+
+// checkClientName rejects the names holding spaces, newlines or special characters
+func checkClientName(name string) reply.ErrorReply {
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' {
+			return reply.MakeStandardErrorReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+	}
+	return nil
+}
+
+// clients returns the active connections in the order of their ids
+func (h *RespHandler) clients() []*connection.Connection {
+	var clients []*connection.Connection
+	h.activeConn.Range(func(key interface{}, val interface{}) bool {
+		clients = append(clients, key.(*connection.Connection))
+		return true
+	})
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].GetID() < clients[j].GetID()
+	})
+	return clients
+}
+
+// execClientList returns a line per client, or per client of the given ids
+// CLIENT LIST [ID id ...]
+func (h *RespHandler) execClientList(args [][]byte) resp.Reply {
+	var ids map[uint64]struct{}
+	if len(args) > 0 {
+		if !strings.EqualFold(string(args[0]), "id") || len(args) < 2 {
+			return reply.MakeSyntaxErrReply()
+		}
+		ids = make(map[uint64]struct{})
+		for _, arg := range args[1:] {
+			id, err := strconv.ParseUint(string(arg), 10, 64)
+			if err != nil || id == 0 {
+				return reply.MakeStandardErrorReply("ERR Invalid client ID")
+			}
+			ids[id] = struct{}{}
+		}
+	}
+	var sb strings.Builder
+	now := time.Now()
+	for _, c := range h.clients() {
+		if ids != nil {
+			if _, ok := ids[c.GetID()]; !ok {
+				continue
+			}
+		}
+		sb.WriteString(clientInfo(c, now))
+		sb.WriteByte('\n')
+	}
+	return reply.MakeBulkReply([]byte(sb.String()))
+}
+
+// clientInfo describes the client in the format of CLIENT LIST, sub counts the channels and the
+// patterns
+func clientInfo(c *connection.Connection, now time.Time) string {
+	lastCmd, lastInteraction := c.GetLastCommand()
+	if lastCmd == "" {
+		lastCmd = "NULL"
+	}
+	return "id=" + strconv.FormatUint(c.GetID(), 10) +
+		" addr=" + c.RemoteAddr().String() +
+		" laddr=" + c.LocalAddr().String() +
+		" name=" + c.GetName() +
+		" age=" + strconv.FormatInt(int64(now.Sub(c.CreatedAt())/time.Second), 10) +
+		" idle=" + strconv.FormatInt(int64(now.Sub(lastInteraction)/time.Second), 10) +
+		" db=" + strconv.Itoa(c.GetDBIndex()) +
+		" sub=" + strconv.Itoa(c.GetSubscriptions()) +
+		" resp=" + strconv.Itoa(c.GetProtocol()) +
+		" user=" + c.GetUser().Name +
+		" cmd=" + lastCmd
+}
+
+// clientFilter selects the clients to kill, an empty field matches any client
+type clientFilter struct {
+	id     uint64
+	addr   string
+	laddr  string
+	skipMe bool
+}
+
+func (f *clientFilter) match(c *connection.Connection, self *connection.Connection) bool {
+	return (f.id == 0 || c.GetID() == f.id) &&
+		(f.addr == "" || c.RemoteAddr().String() == f.addr) &&
+		(f.laddr == "" || c.LocalAddr().String() == f.laddr) &&
+		(!f.skipMe || c != self)
+}
+
+// execClientKill closes the clients, the old form kills the client of the address and replies
+// OK, the form of filters replies the number of killed clients and skips the caller by default
+// CLIENT KILL addr | CLIENT KILL [ID id] [ADDR addr] [LADDR addr] [SKIPME yes|no]
+func (h *RespHandler) execClientKill(self *connection.Connection, args [][]byte) (resp.Reply, bool) {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("client|kill"), false
+	}
+	filter := &clientFilter{skipMe: true}
+	oldForm := len(args) == 1
+	if oldForm {
+		filter.addr = string(args[0])
+		filter.skipMe = false
+	} else {
+		if len(args)%2 != 0 {
+			return reply.MakeSyntaxErrReply(), false
+		}
+		for i := 0; i < len(args); i += 2 {
+			value := string(args[i+1])
+			switch strings.ToLower(string(args[i])) {
+			case "id":
+				id, err := strconv.ParseUint(value, 10, 64)
+				if err != nil || id == 0 {
+					return reply.MakeStandardErrorReply("ERR client-id should be greater than 0"), false
+				}
+				filter.id = id
+			case "addr":
+				filter.addr = value
+			case "laddr":
+				filter.laddr = value
+			case "skipme":
+				switch strings.ToLower(value) {
+				case "yes":
+					filter.skipMe = true
+				case "no":
+					filter.skipMe = false
+				default:
+					return reply.MakeSyntaxErrReply(), false
+				}
+			default:
+				return reply.MakeSyntaxErrReply(), false
+			}
+		}
+	}
+	killed, killedSelf := 0, false
+	for _, c := range h.clients() {
+		if !filter.match(c, self) {
+			continue
+		}
+		killed++
+		if c == self {
+			killedSelf = true
+			continue
+		}
+		// the read loop of the client sees the closed connection and releases the client, Close
+		// waits for the reply being written so it must not block the caller
+		go func(c *connection.Connection) {
+			_ = c.Close()
+		}(c)
+	}
+	if oldForm {
+		if killed == 0 {
+			return reply.MakeStandardErrorReply("ERR No such client"), false
+		}
+		return reply.MakeOKReply(), killedSelf
+	}
+	return reply.MakeIntReply(int64(killed)), killedSelf
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fields returns the fields of a line of CLIENT LIST by name
+func fields(line string) map[string]string {
+	m := make(map[string]string)
+	for _, field := range strings.Fields(line) {
+		if name, value, ok := strings.Cut(field, "="); ok {
+			m[name] = value
+		}
+	}
+	return m
+}
+
+// TestClientSetName tests CLIENT SETNAME and GETNAME, and the names rejected
+func TestClientSetName(t *testing.T) {
+	_, addr := serve(t)
+	c := dial(t, addr)
+	c.assert("$-1\r\n", "CLIENT", "GETNAME")
+	c.assert("+OK\r\n", "CLIENT", "SETNAME", "worker-1")
+	c.assert("$8\r\nworker-1\r\n", "CLIENT", "GETNAME")
+	c.assert("-ERR Client names cannot contain spaces, newlines or special characters.\r\n", "CLIENT", "SETNAME", "a b")
+	c.assert("$8\r\nworker-1\r\n", "CLIENT", "GETNAME")
+	c.assert("+OK\r\n", "CLIENT", "SETNAME", "")
+	c.assert("$-1\r\n", "CLIENT", "GETNAME")
+	c.assert("-ERR wrong number of arguments for 'client|setname' command\r\n", "CLIENT", "SETNAME")
+}
+
+// TestClientListInfo tests the lines of CLIENT LIST and CLIENT INFO
+func TestClientListInfo(t *testing.T) {
+	_, addr := serve(t)
+	a, b := dial(t, addr), dial(t, addr)
+	a.assert("+OK\r\n", "CLIENT", "SETNAME", "a")
+	a.assert("+OK\r\n", "SELECT", "3")
+	b.assert("+OK\r\n", "CLIENT", "SETNAME", "b")
+	idA := strings.Trim(a.do("CLIENT", "ID"), ":\r\n")
+
+	lines := strings.Split(strings.TrimSuffix(bulk(b.do("CLIENT", "LIST")), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a line per client, got %q", lines)
+	}
+	first := fields(lines[0])
+	if first["id"] != idA || first["name"] != "a" || first["db"] != "3" || first["cmd"] != "client|id" ||
+		first["addr"] != a.conn.LocalAddr().String() || first["resp"] != "2" || first["sub"] != "0" {
+		t.Errorf("Expected the line of a, got %q", lines[0])
+	}
+	if second := fields(lines[1]); second["name"] != "b" || second["cmd"] != "client|list" {
+		t.Errorf("Expected the line of b, got %q", lines[1])
+	}
+
+	only := bulk(b.do("CLIENT", "LIST", "ID", idA))
+	if fields(only)["name"] != "a" || strings.Count(only, "\n") != 1 {
+		t.Errorf("Expected the line of a only, got %q", only)
+	}
+	b.assert("-ERR Invalid client ID\r\n", "CLIENT", "LIST", "ID", "x")
+
+	info := fields(bulk(a.do("CLIENT", "INFO")))
+	if info["id"] != idA || info["name"] != "a" || info["cmd"] != "client|info" {
+		t.Errorf("Expected CLIENT INFO to describe the client, got %v", info)
+	}
+}
+
+// TestClientKill tests the old form and the filters of CLIENT KILL
+func TestClientKill(t *testing.T) {
+	_, addr := serve(t)
+	a, b, c := dial(t, addr), dial(t, addr), dial(t, addr)
+	idB := strings.Trim(b.do("CLIENT", "ID"), ":\r\n")
+	c.do("PING")
+
+	a.assert(":1\r\n", "CLIENT", "KILL", "ID", idB)
+	if got := b.read(); !strings.HasPrefix(got, "error: ") {
+		t.Errorf("Expected the killed client to be disconnected, got %q", got)
+	}
+	a.assert("-ERR No such client\r\n", "CLIENT", "KILL", "127.0.0.1:1")
+	a.assert("+OK\r\n", "CLIENT", "KILL", c.conn.LocalAddr().String())
+	if got := c.read(); !strings.HasPrefix(got, "error: ") {
+		t.Errorf("Expected the killed client to be disconnected, got %q", got)
+	}
+	// the filters skip the caller unless SKIPME no
+	a.assert(":0\r\n", "CLIENT", "KILL", "ADDR", a.conn.LocalAddr().String())
+	a.assert("-ERR client-id should be greater than 0\r\n", "CLIENT", "KILL", "ID", "0")
+	a.assert("-ERR syntax error\r\n", "CLIENT", "KILL", "ID", "1", "SKIPME")
+	a.assert(":1\r\n", "CLIENT", "KILL", "ADDR", a.conn.LocalAddr().String(), "SKIPME", "no")
+	if got := a.read(); !strings.HasPrefix(got, "error: ") {
+		t.Errorf("Expected the client killing itself to be disconnected, got %q", got)
+	}
+}
+
+// TestClientListConcurrent tests CLIENT LIST while the other clients change their state, it is
+// meant to run with -race
+func TestClientListConcurrent(t *testing.T) {
+	_, addr := serve(t)
+	observer := dial(t, addr)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		c := dial(t, addr)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c.do("SELECT", strconv.Itoa(j%4))
+				c.do("HELLO", strconv.Itoa(2+j%2))
+				c.do("CLIENT", "SETNAME", "c"+strconv.Itoa(j))
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		if lines := bulk(observer.do("CLIENT", "LIST")); strings.Count(lines, "\n") != 5 {
+			t.Fatalf("Expected 5 clients, got %q", lines)
+		}
+	}
+	wg.Wait()
+}
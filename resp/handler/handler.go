@@ -2,18 +2,23 @@ package handler
 
 import (
 	"context"
-	"fmt"
+	"crypto/subtle"
 	"io"
 	"net"
+	"redigo/acl"
+	"redigo/capture"
 	"redigo/cluster"
 	"redigo/config"
 	"redigo/database"
 	databaseface "redigo/interface/database"
+	"redigo/interface/resp"
 	"redigo/lib/logger"
+	"redigo/lib/stats"
 	"redigo/lib/sync/atomic"
 	"redigo/resp/connection"
 	"redigo/resp/parser"
 	"redigo/resp/reply"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -22,11 +27,226 @@ var (
 	unknownErrReplyBytes = []byte("-ERR unknown\r\n")
 )
 
+// Default limits of the requests sent by clients, those of Redis
+const (
+	defaultProtoMaxBulkLen = config.DefaultProtoMaxBulkLen
+	defaultMaxRequestSize  = 1024 * 1024 * 1024
+)
+
+// requestLimits returns the limits of the requests sent by clients
+func requestLimits() parser.Limits {
+	limits := parser.Limits{
+		MaxBulkLen:     int64(config.Properties.ProtoMaxBulkLen),
+		MaxRequestSize: int64(config.Properties.MaxRequestSize),
+	}
+	if limits.MaxBulkLen <= 0 {
+		limits.MaxBulkLen = defaultProtoMaxBulkLen
+	}
+	if limits.MaxRequestSize <= 0 {
+		limits.MaxRequestSize = defaultMaxRequestSize
+	}
+	return limits
+}
+
+// checkWriteElements rejects a write command with more elements than max-write-elements, the
+// command name and the key are not counted
+func checkWriteElements(args [][]byte) reply.ErrorReply {
+	max := config.Read(func(p *config.ServerProperties) int { return p.MaxWriteElements })
+	if max <= 0 || len(args)-2 <= max || database.IsReadOnlyCommand(string(args[0])) {
+		return nil
+	}
+	return reply.MakeStandardErrorReply("ERR too many elements in a single write, the limit is " +
+		strconv.Itoa(max) + " (max-write-elements)")
+}
+
+// checkUserLimits consumes a command of the user of the client, rejecting it with -LIMIT when the
+// user exceeds its ops or writes per second
+func checkUserLimits(client *connection.Connection, args [][]byte) reply.ErrorReply {
+	if err := client.GetUser().Allow(database.IsWriteCommand(string(args[0]))); err != nil {
+		return reply.MakeStandardErrorReply(err.Error())
+	}
+	return nil
+}
+
+// unauthenticatedCommands are the commands accepted from a connection which did not authenticate
+// when requirepass is set
+var unauthenticatedCommands = map[string]struct{}{
+	"auth":  {},
+	"hello": {}, // authenticates with its AUTH option
+	"ping":  {},
+}
+
+// requirePass returns the password of the default user, CONFIG SET requirepass changes it
+func requirePass() string {
+	return config.Read(func(p *config.ServerProperties) string { return p.RequirePass })
+}
+
+// checkAuth rejects the commands other than AUTH and PING with -NOAUTH when requirepass is set
+// and the client did not authenticate
+func checkAuth(client *connection.Connection, args [][]byte) reply.ErrorReply {
+	if requirePass() == "" || client.IsAuthenticated() {
+		return nil
+	}
+	if _, ok := unauthenticatedCommands[strings.ToLower(string(args[0]))]; ok {
+		return nil
+	}
+	return reply.MakeNoAuthErrReply()
+}
+
+// execAuth implements the AUTH command, the password of the default user is requirepass, those of
+// the other users are set with the users option
+// AUTH [username] password
+func execAuth(client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 || len(args) > 3 {
+		return reply.MakeArgNumErrReply("auth")
+	}
+	if len(args) == 3 && string(args[1]) != acl.DefaultUserName {
+		user, ok := acl.GetUser(string(args[1]))
+		if !ok || !user.CheckPassword(args[2]) {
+			return reply.MakeStandardErrorReply("ERR invalid password")
+		}
+		return authenticate(client, user)
+	}
+	password := requirePass()
+	if password == "" {
+		return reply.MakeStandardErrorReply("ERR AUTH <password> called without any password configured " +
+			"for the default user. Are you sure your configuration is correct?")
+	}
+	if subtle.ConstantTimeCompare(args[len(args)-1], []byte(password)) != 1 {
+		return reply.MakeStandardErrorReply("ERR invalid password")
+	}
+	return authenticate(client, acl.DefaultUser())
+}
+
+// authenticate makes the user that of the client, the connection now counts against the limits
+// of the user instead of those of the former one
+func authenticate(client *connection.Connection, user *acl.User) resp.Reply {
+	if current := client.GetUser(); current != user {
+		if err := user.Connect(); err != nil {
+			return reply.MakeStandardErrorReply(err.Error())
+		}
+		current.Disconnect()
+		client.SetUser(user)
+	}
+	client.SetAuthenticated(true)
+	return reply.MakeOKReply()
+}
+
+// execHello handles the AUTH and SETNAME options of HELLO, then the database negotiates the
+// protocol version and returns the server properties. Without AUTH, a client must authenticate
+// first when requirepass is set
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func (h *RespHandler) execHello(client *connection.Connection, args [][]byte) resp.Reply {
+	var auth [][]byte
+	var name []byte
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToLower(string(args[i])); {
+		case option == "auth" && i+2 < len(args):
+			auth = [][]byte{[]byte("auth"), args[i+1], args[i+2]}
+			i += 2
+		case option == "setname" && i+1 < len(args):
+			name = args[i+1]
+			i++
+		default:
+			return reply.MakeStandardErrorReply("ERR Syntax error in HELLO option '" + string(args[i]) + "'")
+		}
+	}
+	if auth != nil {
+		if r, ok := execAuth(client, auth).(reply.ErrorReply); ok {
+			return r
+		}
+	}
+	if requirePass() != "" && !client.IsAuthenticated() {
+		return reply.MakeStandardErrorReply("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time")
+	}
+	if name != nil {
+		if errReply := checkClientName(string(name)); errReply != nil {
+			return errReply
+		}
+	}
+	r := h.db.Exec(client, args[:min(len(args), 2)])
+	if _, failed := r.(reply.ErrorReply); name != nil && !failed {
+		client.SetName(string(name))
+	}
+	return r
+}
+
+// subscribedCommands are the commands accepted from a RESP2 connection subscribed to channels or
+// patterns, the connection only receives the messages and the replies of these commands
+var subscribedCommands = map[string]struct{}{
+	"subscribe":    {},
+	"unsubscribe":  {},
+	"psubscribe":   {},
+	"punsubscribe": {},
+	"ping":         {},
+}
+
+// checkSubscribedContext rejects the commands other than the subscribe family from a RESP2
+// connection in subscribed mode. RESP3 connections receive the messages as push replies, they
+// may send any command
+func checkSubscribedContext(client *connection.Connection, args [][]byte) reply.ErrorReply {
+	if client.GetSubscriptions() == 0 || client.GetProtocol() >= reply.RESP3 {
+		return nil
+	}
+	cmdName := strings.ToLower(string(args[0]))
+	if _, ok := subscribedCommands[cmdName]; ok {
+		return nil
+	}
+	return reply.MakeStandardErrorReply("ERR Can't execute '" + cmdName +
+		"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")
+}
+
+// subscribedPong is the reply of PING in subscribed mode, an array holding pong and the message
+func subscribedPong(args [][]byte) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeArgNumErrReply("ping")
+	}
+	message := []byte{}
+	if len(args) == 2 {
+		message = args[1]
+	}
+	return reply.MakeMultiBulkReply([][]byte{[]byte("pong"), message})
+}
+
+// ProtocolErrorAction tells the handler what to do after a client sent malformed data
+type ProtocolErrorAction int
+
+const (
+	// ReplyAndContinue sends an error reply and keeps reading from the connection
+	ReplyAndContinue ProtocolErrorAction = iota
+	// ReplyAndClose sends an error reply and closes the connection
+	ReplyAndClose
+	// CloseSilently closes the connection without reply
+	CloseSilently
+)
+
+// ProtocolErrorPolicy decides the action for a protocol error
+type ProtocolErrorPolicy func(client *connection.Connection, err *parser.ProtocolError) ProtocolErrorAction
+
+// DefaultProtocolErrorPolicy keeps the connection after recoverable errors and closes it after fatal ones,
+// since the rest of the stream can't be framed correctly
+func DefaultProtocolErrorPolicy(client *connection.Connection, err *parser.ProtocolError) ProtocolErrorAction {
+	if err.Fatal {
+		return ReplyAndClose
+	}
+	return ReplyAndContinue
+}
+
 // RespHandler implements tcp.Handler and serves as a redis handler
 type RespHandler struct {
 	activeConn sync.Map // *client -> placeholder
 	db         databaseface.Database
 	closing    atomic.Boolean // refusing new client and new request
+	// protocolErrorPolicy is called on every protocol error sent by clients
+	protocolErrorPolicy ProtocolErrorPolicy
+	// capture records the commands of the clients when traffic-capture is set, nil otherwise
+	capture *capture.Recorder
+	// monitors receive the commands of the clients after MONITOR
+	monitors *monitorFeed
+	// clientLimit counts the connections against maxclients
+	clientLimit clientLimit
 }
 
 // MakeHandler creates a RespHandler instance
@@ -34,22 +254,50 @@ func MakeHandler() *RespHandler {
 	var db databaseface.Database
 	// If self is not empty, it means this is a cluster node
 	// and we need to create a cluster database
-	if config.Properties.Self != "" && len(config.Properties.Peers) > 0 {
-		fmt.Println("You are running in cluster mode")
+	clusterMode := config.Properties.Self != "" && len(config.Properties.Peers) > 0
+	if clusterMode {
 		db = cluster.MakeClusterDatabase()
 	} else {
-		fmt.Println("You are running in standalone mode")
 		db = database.NewStandaloneDatabase()
 	}
+	// logged once the database resolved the defaults of the configuration
+	for _, line := range database.StartupSummary(clusterMode) {
+		logger.Info(line)
+	}
+	acl.SetUser(acl.NewUser(acl.DefaultUserName, acl.Limits{
+		MaxOpsPerSecond:    config.Properties.UserMaxOpsPerSecond,
+		MaxConnections:     config.Properties.UserMaxConnections,
+		MaxWritesPerSecond: config.Properties.UserMaxWritesPerSecond,
+	}))
+	if err := acl.LoadUsers(config.Properties.Users); err != nil {
+		panic(err)
+	}
 	return &RespHandler{
-		db: db,
+		db:                  db,
+		protocolErrorPolicy: DefaultProtocolErrorPolicy,
+		capture:             openCapture(),
+		monitors:            makeMonitorFeed(),
 	}
 }
 
+// SetProtocolErrorPolicy replaces the policy applied to protocol errors, nil restores the default one
+// It must be called before the handler starts serving
+func (h *RespHandler) SetProtocolErrorPolicy(policy ProtocolErrorPolicy) {
+	if policy == nil {
+		policy = DefaultProtocolErrorPolicy
+	}
+	h.protocolErrorPolicy = policy
+}
+
 func (h *RespHandler) closeClient(client *connection.Connection) {
 	_ = client.Close()
+	client.GetUser().Disconnect()
 	h.db.AfterClientClose(client)
+	h.monitors.remove(client)
 	h.activeConn.Delete(client)
+	if h.capture != nil {
+		h.capture.Disconnected(client.GetID())
+	}
 }
 
 // Handle receives and executes redis commands
@@ -59,25 +307,47 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 		_ = conn.Close()
 	}
 
+	if !h.clientLimit.admit() {
+		_, _ = conn.Write(maxClientsErrReplyBytes)
+		_ = conn.Close()
+		stats.Server.ConnectionRejected()
+		return
+	}
+	defer h.clientLimit.release()
+
 	client := connection.NewConnection(conn)
+	if err := client.GetUser().Connect(); err != nil {
+		_ = client.Write(reply.MakeStandardErrorReply(err.Error()).ToBytes())
+		_ = client.Close()
+		stats.Server.ConnectionRejected()
+		return
+	}
 	h.activeConn.Store(client, 1)
+	stats.Server.ClientConnected()
+	defer stats.Server.ClientDisconnected()
 
-	ch := parser.ParseStream(conn)
-	for payload := range ch {
+	ch := parser.ParseRequests(conn, requestLimits())
+	// next is a payload read while a blocking command was executed
+	var next *parser.Payload
+	for {
+		payload := next
+		next = nil
+		if payload == nil {
+			var ok bool
+			if payload, ok = <-ch; !ok {
+				return
+			}
+		}
 		// fmt.Println("payload:", payload)
 		if payload.Err != nil {
-			if payload.Err == io.EOF ||
-				payload.Err == io.ErrUnexpectedEOF ||
-				strings.Contains(payload.Err.Error(), "use of closed network connection") {
+			if isConnectionClosed(payload.Err) {
 				// connection closed
 				h.closeClient(client)
 				logger.Info("connection closed: " + client.RemoteAddr().String())
 				return
 			}
 			// protocol err
-			errReply := reply.MakeStandardErrorReply(payload.Err.Error())
-			err := client.Write(errReply.ToBytes())
-			if err != nil {
+			if !h.handleProtocolError(client, payload.Err) {
 				h.closeClient(client)
 				logger.Info("connection closed: " + client.RemoteAddr().String())
 				return
@@ -90,18 +360,120 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 		}
 		r, ok := payload.Data.(*reply.MultiBulkReply)
 		if !ok {
+			// the parser of the requests only emits arrays of bulk strings
 			logger.Error("require multi bulk reply")
+			_ = client.Write(unknownErrReplyBytes)
+			continue
+		}
+		client.SetLastCommand(commandName(r.Args))
+		if errReply := checkAuth(client, r.Args); errReply != nil {
+			_ = client.Write(errReply.ToBytes())
 			continue
 		}
-		result := h.db.Exec(client, r.Args)
+		h.captureCommand(client, r.Args)
+		h.monitors.feed(client, r.Args)
+		if errReply := checkWriteElements(r.Args); errReply != nil {
+			_ = client.Write(errReply.ToBytes())
+			continue
+		}
+		if errReply := checkUserLimits(client, r.Args); errReply != nil {
+			_ = client.Write(errReply.ToBytes())
+			continue
+		}
+		if errReply := checkSubscribedContext(client, r.Args); errReply != nil {
+			_ = client.Write(errReply.ToBytes())
+			continue
+		}
+		stats.Server.CommandProcessed()
+		var result resp.Reply
+		killedSelf := false
+		if client.GetSubscriptions() > 0 && client.GetProtocol() < reply.RESP3 && strings.EqualFold(string(r.Args[0]), "ping") {
+			result = subscribedPong(r.Args)
+		} else if strings.EqualFold(string(r.Args[0]), "auth") {
+			result = execAuth(client, r.Args)
+		} else if strings.EqualFold(string(r.Args[0]), "hello") {
+			result = h.execHello(client, r.Args)
+		} else if strings.EqualFold(string(r.Args[0]), "client") {
+			result, killedSelf = h.execClient(client, r.Args)
+		} else if strings.EqualFold(string(r.Args[0]), "monitor") {
+			result = h.execMonitor(client, r.Args)
+		} else if database.IsBlockingCommand(r.Args) {
+			result, next = h.execBlocking(client, r.Args, ch)
+		} else {
+			result = h.db.Exec(client, r.Args)
+		}
 		if result != nil {
-			_ = client.Write(result.ToBytes())
+			_ = client.WriteReply(reply.ForProtocol(result, client.GetProtocol()))
 		} else {
 			_ = client.Write(unknownErrReplyBytes)
 		}
+		if killedSelf {
+			// the read loop ends on the closed connection and releases the client
+			_ = client.Close()
+		}
+	}
+}
+
+// isConnectionClosed reports whether the error read from the connection means it is closed
+func isConnectionClosed(err error) bool {
+	return err == io.EOF ||
+		err == io.ErrUnexpectedEOF ||
+		strings.Contains(err.Error(), "use of closed network connection")
+}
+
+// execBlocking executes a blocking command while reading the next payload of the client, so that
+// the command is released when the client disconnects instead of waiting for its timeout.
+// The payload read meanwhile is returned to be handled after the command
+func (h *RespHandler) execBlocking(client *connection.Connection, args [][]byte, ch <-chan *parser.Payload) (resp.Reply, *parser.Payload) {
+	done := make(chan resp.Reply, 1)
+	go func() {
+		done <- h.db.Exec(client, args)
+	}()
+	select {
+	case result := <-done:
+		return result, nil
+	case next, ok := <-ch:
+		if !ok {
+			next = &parser.Payload{Err: io.EOF}
+		}
+		if next.Err != nil && isConnectionClosed(next.Err) {
+			h.db.AfterClientClose(client)
+		}
+		return <-done, next
 	}
 }
 
+// handleProtocolError applies the protocol error policy and reports whether the connection should be kept
+func (h *RespHandler) handleProtocolError(client *connection.Connection, err error) bool {
+	protocolErr, ok := err.(*parser.ProtocolError)
+	if !ok {
+		// unknown error, reply and keep going as before
+		errReply := reply.MakeStandardErrorReply(err.Error())
+		return client.Write(errReply.ToBytes()) == nil
+	}
+	logger.Warn("client " + client.RemoteAddr().String() + " sent malformed data: " + protocolErr.Error())
+	action := h.protocolErrorPolicy(client, protocolErr)
+	if action == CloseSilently {
+		return false
+	}
+	errReply := reply.MakeProtocolErrReply(protocolErr.Msg)
+	if writeErr := client.Write(errReply.ToBytes()); writeErr != nil {
+		return false
+	}
+	return action == ReplyAndContinue
+}
+
+// Status describes the clients of the handler for the status report of SIGUSR1
+func (h *RespHandler) Status() string {
+	clients := 0
+	h.activeConn.Range(func(key interface{}, val interface{}) bool {
+		clients++
+		return true
+	})
+	return "clients=" + strconv.Itoa(clients) +
+		" default_user_connections=" + strconv.Itoa(acl.DefaultUser().Connections())
+}
+
 // Close stops handler
 func (h *RespHandler) Close() error {
 	logger.Info("handler shutting down...")
@@ -113,5 +485,10 @@ func (h *RespHandler) Close() error {
 		return true
 	})
 	h.db.Close()
+	if h.capture != nil {
+		if err := h.capture.Close(); err != nil {
+			logger.Error("traffic capture: " + err.Error())
+		}
+	}
 	return nil
 }
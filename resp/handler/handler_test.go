@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"redigo/acl"
+	"redigo/config"
+	"redigo/lib/utils"
+	"redigo/resp/parser"
+	"redigo/resp/reply"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve serves a new handler on a local port, returning the handler and its address
+func serve(t *testing.T) (*RespHandler, string) {
+	t.Helper()
+	h := MakeHandler()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+		_ = h.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go h.Handle(context.Background(), conn)
+		}
+	}()
+	return h, listener.Addr().String()
+}
+
+// testClient sends commands to the handler and reads the replies
+type testClient struct {
+	t       *testing.T
+	conn    net.Conn
+	replies <-chan *parser.Payload
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return &testClient{t: t, conn: conn, replies: parser.ParseStream(conn)}
+}
+
+// send writes a command without waiting for its reply
+func (c *testClient) send(args ...string) {
+	c.t.Helper()
+	if _, err := c.conn.Write(reply.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes()); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read returns the next reply, or the error ending the stream
+func (c *testClient) read() string {
+	c.t.Helper()
+	select {
+	case payload := <-c.replies:
+		if payload.Err != nil {
+			return "error: " + payload.Err.Error()
+		}
+		return string(payload.Data.ToBytes())
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timeout waiting for a reply")
+		return ""
+	}
+}
+
+// do sends a command and returns its reply
+func (c *testClient) do(args ...string) string {
+	c.t.Helper()
+	c.send(args...)
+	return c.read()
+}
+
+// assert sends a command and checks its reply
+func (c *testClient) assert(expected string, args ...string) {
+	c.t.Helper()
+	if got := c.do(args...); got != expected {
+		c.t.Errorf("Expected %s to reply %q, got %q", args[0], expected, got)
+	}
+}
+
+// bulk returns the body of a bulk reply
+func bulk(r string) string {
+	if _, body, ok := strings.Cut(r, "\r\n"); ok {
+		return strings.TrimSuffix(body, "\r\n")
+	}
+	return r
+}
+
+// TestUserAuth tests that AUTH username password authenticates as a user of the users option,
+// whose connections and writes count against its own limits
+func TestUserAuth(t *testing.T) {
+	defer func(users []string) {
+		config.Properties.Users = users
+		_ = acl.LoadUsers(users)
+	}(config.Properties.Users)
+	config.Properties.Users = []string{"alice secret maxconnections=1 maxwritespersecond=1"}
+	_, addr := serve(t)
+
+	alice := dial(t, addr)
+	alice.assert("-ERR invalid password\r\n", "AUTH", "alice", "wrong")
+	alice.assert("-ERR invalid password\r\n", "AUTH", "bob", "secret")
+	alice.assert("+OK\r\n", "AUTH", "alice", "secret")
+	alice.assert("+OK\r\n", "SET", "a", "1")
+	alice.assert("-LIMIT user 'alice' exceeded user-max-writes-per-second 1\r\n", "SET", "a", "2")
+	alice.assert("$1\r\n1\r\n", "GET", "a")
+
+	other := dial(t, addr)
+	other.assert("-LIMIT user 'alice' exceeded user-max-connections 1\r\n", "AUTH", "alice", "secret")
+	// still the default user, which has no limit
+	other.assert("+OK\r\n", "SET", "b", "1")
+	other.assert("+OK\r\n", "SET", "b", "2")
+
+	_ = alice.conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for other.do("AUTH", "alice", "secret") != "+OK\r\n" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the connection of alice to be released once closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestRequirePass tests that with requirepass the connections are rejected with -NOAUTH until
+// they authenticate with AUTH or with the AUTH option of HELLO
+func TestRequirePass(t *testing.T) {
+	_, addr := serve(t)
+	c := dial(t, addr)
+	c.assert("-ERR AUTH <password> called without any password configured for the default user. "+
+		"Are you sure your configuration is correct?\r\n", "AUTH", "secret")
+
+	defer func(password string) {
+		config.Properties.RequirePass = password
+	}(config.Properties.RequirePass)
+	config.Properties.RequirePass = "secret"
+
+	c.assert("+PONG\r\n", "PING")
+	c.assert("-NOAUTH Authentication required.\r\n", "GET", "a")
+	if r := c.do("HELLO", "3"); !strings.HasPrefix(r, "-NOAUTH HELLO must be called with the client already authenticated") {
+		t.Errorf("Expected HELLO without AUTH to be rejected, got %q", r)
+	}
+	c.assert("-ERR invalid password\r\n", "AUTH", "wrong")
+	c.assert("-ERR invalid password\r\n", "AUTH", "default", "wrong")
+	c.assert("-NOAUTH Authentication required.\r\n", "SET", "a", "1")
+	c.assert("+OK\r\n", "AUTH", "secret")
+	c.assert("+OK\r\n", "SET", "a", "1")
+
+	hello := dial(t, addr)
+	hello.assert("-ERR invalid password\r\n", "HELLO", "3", "AUTH", "default", "wrong")
+	hello.assert("-NOAUTH Authentication required.\r\n", "GET", "a")
+	if r := hello.do("HELLO", "3", "AUTH", "default", "secret", "SETNAME", "app"); !strings.HasPrefix(r, "%") {
+		t.Errorf("Expected HELLO AUTH to authenticate and switch to RESP3, got %q", r)
+	}
+	hello.assert("$1\r\n1\r\n", "GET", "a")
+	hello.assert("$3\r\napp\r\n", "CLIENT", "GETNAME")
+}
+
+// TestStatus tests that the status report of SIGUSR1 counts the clients connected
+func TestStatus(t *testing.T) {
+	h, addr := serve(t)
+	first := dial(t, addr)
+	second := dial(t, addr)
+	first.assert("+PONG\r\n", "PING")
+	second.assert("+PONG\r\n", "PING")
+	if status := h.Status(); !strings.HasPrefix(status, "clients=2 default_user_connections=") {
+		t.Errorf("Expected 2 clients in the status, got %q", status)
+	}
+
+	_ = first.conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for !strings.HasPrefix(h.Status(), "clients=1 ") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the closed client to leave the status, got %q", h.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestRequestElements tests that a request array holding something else than bulk strings is
+// answered with a protocol error, and that the connection keeps serving the next requests
+func TestRequestElements(t *testing.T) {
+	_, addr := serve(t)
+	c := dial(t, addr)
+	if _, err := c.conn.Write([]byte("*2\r\n$3\r\nGET\r\n:12\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.read(); got != "-PROTOCOL ERROR: expected '$', got ':'\r\n" {
+		t.Errorf("Expected a protocol error, got %q", got)
+	}
+	c.assert("+PONG\r\n", "PING")
+}
+
+// TestRequestTypes tests that a request which is not an array, such as a RESP3 map, is answered
+// with a protocol error, and that the connection keeps serving the next requests
+func TestRequestTypes(t *testing.T) {
+	_, addr := serve(t)
+	c := dial(t, addr)
+	if _, err := c.conn.Write([]byte("%1\r\n$3\r\nGET\r\n$1\r\na\r\n*0\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.read(); got != "-PROTOCOL ERROR: expected '*', got '%'\r\n" {
+		t.Errorf("Expected a protocol error, got %q", got)
+	}
+	// the empty array is skipped without reply
+	c.assert("+PONG\r\n", "PING")
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"redigo/config"
+	"sync/atomic"
+)
+
+// maxClientsErrReplyBytes is the reply of a connection over maxclients before it is closed, that of Redis
+var maxClientsErrReplyBytes = []byte("-ERR max number of clients reached\r\n")
+
+// clientLimit counts the connections of a handler against maxclients
+type clientLimit struct {
+	count atomic.Int64
+}
+
+// admit counts a new connection, it returns false when the handler already serves maxclients
+// connections, 0 means no limit
+// The limit is read for every connection, CONFIG SET maxclients applies to the next ones and
+// keeps the connections over a lowered limit, like Redis
+func (l *clientLimit) admit() bool {
+	n := l.count.Add(1)
+	if max := config.Read(func(p *config.ServerProperties) int { return p.MaxClients }); max > 0 && n > int64(max) {
+		l.count.Add(-1)
+		return false
+	}
+	return true
+}
+
+// release uncounts a connection which was admitted
+func (l *clientLimit) release() {
+	l.count.Add(-1)
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"redigo/interface/resp"
+	"redigo/lib/logger"
+	"redigo/resp/connection"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// monitorBuffer is the number of lines waiting to be sent to a monitor, a monitor which falls
+// further behind is disconnected like a Redis client over its output buffer limit
+const monitorBuffer = 4096
+
+// monitorClient is a connection which sent MONITOR, its lines are written by its own goroutine so
+// that a slow monitor never delays the commands
+type monitorClient struct {
+	lines chan []byte
+	stop  chan struct{}
+}
+
+// monitorFeed streams the commands processed by the server to the monitors
+type monitorFeed struct {
+	mu       sync.RWMutex
+	monitors map[*connection.Connection]*monitorClient
+	// count is the number of monitors, the commands are not formatted while there is none
+	count atomic.Int32
+}
+
+func makeMonitorFeed() *monitorFeed {
+	return &monitorFeed{monitors: make(map[*connection.Connection]*monitorClient)}
+}
+
+// add makes the connection receive the commands from now on
+func (f *monitorFeed) add(client *connection.Connection) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.monitors[client]; ok {
+		return
+	}
+	m := &monitorClient{
+		lines: make(chan []byte, monitorBuffer),
+		stop:  make(chan struct{}),
+	}
+	f.monitors[client] = m
+	f.count.Add(1)
+	go func() {
+		for {
+			select {
+			case line := <-m.lines:
+				if client.Write(line) != nil {
+					return
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// remove stops the feed of the connection
+func (f *monitorFeed) remove(client *connection.Connection) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m, ok := f.monitors[client]; ok {
+		delete(f.monitors, client)
+		f.count.Add(-1)
+		close(m.stop)
+	}
+}
+
+// feed sends the command of the client to the monitors, in the format of Redis:
+// +1700000000.123456 [0 127.0.0.1:50000] "set" "key" "value"
+// AUTH, the AUTH option of HELLO and the replication commands are not shown, like in the capture
+func (f *monitorFeed) feed(client *connection.Connection, args [][]byte) {
+	if f.count.Load() == 0 {
+		return
+	}
+	name := strings.ToLower(string(args[0]))
+	if _, ok := unrecordedCommands[name]; ok {
+		return
+	}
+	if name == "hello" {
+		args = withoutHelloAuth(args)
+	}
+	line := monitorLine(time.Now(), client, args)
+
+	var slow []*connection.Connection
+	f.mu.RLock()
+	for c, m := range f.monitors {
+		select {
+		case m.lines <- line:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	f.mu.RUnlock()
+	for _, c := range slow {
+		f.remove(c)
+		logger.Warn("monitor " + c.RemoteAddr().String() + " is too slow, disconnected")
+		// closing waits for the reply being written, the command is not delayed
+		go func() {
+			_ = c.Close()
+		}()
+	}
+}
+
+// monitorLine formats a command as a status reply
+func monitorLine(now time.Time, client *connection.Connection, args [][]byte) []byte {
+	var sb strings.Builder
+	sb.WriteByte('+')
+	sb.WriteString(strconv.FormatInt(now.Unix(), 10))
+	sb.WriteByte('.')
+	micros := strconv.Itoa(now.Nanosecond() / 1000)
+	sb.WriteString(strings.Repeat("0", 6-len(micros)) + micros)
+	sb.WriteString(" [" + strconv.Itoa(client.GetDBIndex()) + " ")
+	if addr := client.RemoteAddr(); addr != nil {
+		sb.WriteString(addr.String())
+	} else {
+		sb.WriteString("unknown")
+	}
+	sb.WriteByte(']')
+	for _, arg := range args {
+		sb.WriteByte(' ')
+		writeQuoted(&sb, arg)
+	}
+	sb.WriteString(reply.CRLF)
+	return []byte(sb.String())
+}
+
+// writeQuoted writes the argument between double quotes, escaping the quotes, the backslashes and
+// the non printable bytes like Redis
+func writeQuoted(sb *strings.Builder, arg []byte) {
+	const hex = "0123456789abcdef"
+	sb.WriteByte('"')
+	for _, b := range arg {
+		switch b {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(b)
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		case '\a':
+			sb.WriteString("\\a")
+		case '\b':
+			sb.WriteString("\\b")
+		default:
+			if b < 0x20 || b >= 0x7f {
+				sb.WriteString("\\x")
+				sb.WriteByte(hex[b>>4])
+				sb.WriteByte(hex[b&0xf])
+			} else {
+				sb.WriteByte(b)
+			}
+		}
+	}
+	sb.WriteByte('"')
+}
+
+// execMonitor implements MONITOR, the connection receives the commands processed from now on
+// The reply is written before the feed starts, so that it comes before the first line
+func (h *RespHandler) execMonitor(client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("monitor")
+	}
+	_ = client.Write(reply.MakeOKReply().ToBytes())
+	h.monitors.add(client)
+	return reply.MakeNoReply()
+}
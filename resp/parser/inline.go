@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"redigo/resp/reply"
+	"strconv"
+)
+
+// inlineMaxSize is the max length of an inline command, and of a line sent by a client, that of
+// Redis
+const inlineMaxSize = 64 * 1024
+
+// isInline reports whether a top level line is an inline command, e.g. PING typed over telnet,
+// instead of a RESP payload. A RESP payload starts with its type byte
+func isInline(line []byte) bool {
+	switch line[0] {
+	case '*', '$', '%', '~', '>', '=', '!', '+', '-', ':', '_', '#', ',', '(':
+		return false
+	}
+	return true
+}
+
+// parseInline splits an inline command into its arguments, nil for a blank line
+// The arguments are separated by spaces and the line may end with \n only, like in Redis
+func parseInline(line []byte, start int64, stream *streamReader) (*reply.MultiBulkReply, error) {
+	args, ok := splitArgs(line)
+	if !ok {
+		return nil, &ProtocolError{Msg: "unbalanced quotes in request", Offset: start, Index: stream.index, Fatal: true}
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return reply.MakeMultiBulkReply(args), nil
+}
+
+// splitArgs splits a line into arguments with the quoting rules of redis-cli:
+// "double quotes" understand the escapes \n \r \t \b \a \\ \" and \xHH, 'single quotes' only \',
+// and a closing quote must be followed by a space or the end of the line
+// It returns false for unbalanced quotes
+func splitArgs(line []byte) ([][]byte, bool) {
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, true
+		}
+		var arg []byte
+		inDouble, inSingle, done := false, false, false
+		for !done {
+			if inDouble {
+				if i == len(line) {
+					return nil, false
+				}
+				switch {
+				case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					b, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+					arg = append(arg, byte(b))
+					i += 3
+				case line[i] == '\\' && i+1 < len(line):
+					i++
+					arg = append(arg, unescape(line[i]))
+				case line[i] == '"':
+					// the closing quote must be followed by a space
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, false
+					}
+					done = true
+				default:
+					arg = append(arg, line[i])
+				}
+			} else if inSingle {
+				if i == len(line) {
+					return nil, false
+				}
+				switch {
+				case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					arg = append(arg, '\'')
+				case line[i] == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, false
+					}
+					done = true
+				default:
+					arg = append(arg, line[i])
+				}
+			} else {
+				if i == len(line) {
+					break
+				}
+				switch line[i] {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					arg = append(arg, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		if arg == nil {
+			arg = []byte{}
+		}
+		args = append(args, arg)
+	}
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t' || b == '\v' || b == '\f'
+}
+
+func isHex(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'f' || b >= 'A' && b <= 'F'
+}
+
+// unescape returns the byte of an escape sequence in double quotes, the byte itself if unknown
+func unescape(b byte) byte {
+	switch b {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+	return b
+}
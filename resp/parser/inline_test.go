@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"redigo/resp/reply"
+	"strings"
+	"testing"
+)
+
+// TestInlineCommands tests the splitting of the inline commands with the quoting rules of
+// redis-cli, and that the blank lines are skipped
+func TestInlineCommands(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected []string
+	}{
+		{"PING\r\n", []string{"PING"}},
+		{"SET  foo \tbar\n", []string{"SET", "foo", "bar"}},
+		{`SET "a b" 'c d'` + "\r\n", []string{"SET", "a b", "c d"}},
+		{`SET k "\x41\n\t\"\\"` + "\r\n", []string{"SET", "k", "A\n\t\"\\"}},
+		{`SET k 'it\'s \n'` + "\r\n", []string{"SET", "k", `it's \n`}},
+		{`SET k ""` + "\r\n", []string{"SET", "k", ""}},
+	}
+	for _, c := range cases {
+		payloads := parseAll(ParseRequests(strings.NewReader(c.line), Limits{}))
+		if len(payloads) != 2 || payloads[0].Err != nil {
+			t.Errorf("Expected a command and the end of the stream for %q, got %v", c.line, payloads)
+			continue
+		}
+		if got := payloads[0].Data.(*reply.MultiBulkReply).Args; len(got) != len(c.expected) {
+			t.Errorf("Expected %q for %q, got %q", c.expected, c.line, got)
+		} else {
+			for i := range got {
+				if string(got[i]) != c.expected[i] {
+					t.Errorf("Expected %q for %q, got %q", c.expected, c.line, got)
+					break
+				}
+			}
+		}
+	}
+
+	payloads := parseAll(ParseRequests(strings.NewReader("\r\n  \n\nPING\r\n"), Limits{}))
+	if len(payloads) != 2 || payloads[0].Err != nil || string(payloads[0].Data.ToBytes()) != "*1\r\n$4\r\nPING\r\n" {
+		t.Errorf("Expected the blank lines to be skipped, got %v", payloads)
+	}
+}
+
+// TestInlineErrors tests that the unbalanced quotes are fatal errors
+func TestInlineErrors(t *testing.T) {
+	for _, line := range []string{`SET "foo` + "\r\n", `SET 'foo` + "\r\n", `SET "foo"bar` + "\r\n"} {
+		payloads := parseAll(ParseRequests(strings.NewReader(line), Limits{}))
+		err := protocolError(payloads[0])
+		if err == nil || !err.Fatal || err.Msg != "unbalanced quotes in request" {
+			t.Errorf("Expected unbalanced quotes for %q, got %v", line, payloads[0].Err)
+		}
+	}
+}
+
+// endlessReader returns the same byte forever, a client never sending a newline
+type endlessReader byte
+
+func (r endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+// TestInlineMaxSize tests that a line of a client longer than inlineMaxSize fails before it is
+// read in full, and that the longest line is accepted
+func TestInlineMaxSize(t *testing.T) {
+	longest := "ECHO " + strings.Repeat("a", inlineMaxSize-7) + "\r\n"
+	payloads := parseAll(ParseRequests(strings.NewReader(longest), Limits{}))
+	if len(payloads) != 2 || payloads[0].Err != nil {
+		t.Errorf("Expected the inline command of %d bytes to be parsed, got %v", len(longest), payloads[0].Err)
+	}
+
+	cases := []struct {
+		reader io.Reader
+		msg    string
+	}{
+		{endlessReader('a'), "too big inline request"},
+		{io.MultiReader(strings.NewReader("*"), endlessReader('1')), "too big mbulk count string"},
+	}
+	for _, c := range cases {
+		payloads := parseAll(ParseRequests(c.reader, Limits{}))
+		err := protocolError(payloads[0])
+		if len(payloads) != 1 || err == nil || !err.Fatal || err.Msg != c.msg || err.Offset != 0 {
+			t.Errorf("Expected the fatal error %q ending the stream, got %v", c.msg, payloads[0].Err)
+		}
+	}
+
+	// the streams which are not requests keep their long lines
+	status := "+" + string(bytes.Repeat([]byte("a"), 2*inlineMaxSize)) + "\r\n"
+	payloads = parseAll(ParseStream(strings.NewReader(status)))
+	if payloads[0].Err != nil || len(payloads[0].Data.ToBytes()) != len(status) {
+		t.Errorf("Expected the long status reply to be parsed, got %v", payloads[0].Err)
+	}
+}
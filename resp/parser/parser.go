@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"errors"
 	"io"
+	"math"
 	"redigo/interface/resp"
 	"redigo/lib/logger"
 	"redigo/resp/reply"
@@ -18,30 +19,171 @@ type Payload struct {
 	Err  error
 }
 
-// readState represents the state of the parser
-type readState struct {
-	readingMultiLine  bool     // Whether it is reading multi-line data
-	expectedArgsCount int      // Expected number of arguments
-	msgType           byte     // Message type
-	args              [][]byte // Arguments
-	bulkLen           int64    // Length of Bulk reply
+// ProtocolError describes malformed input and where it was found in the stream
+type ProtocolError struct {
+	Msg    string // what is wrong
+	Offset int64  // byte offset of the beginning of the offending data in the stream
+	Index  int    // index of the payload in the stream, starting from 0
+	// Fatal means the parser lost track of the framing (e.g. inside a bulk string or an array),
+	// so the following data may be misinterpreted. Recoverable errors only skip one line.
+	Fatal bool
 }
 
-// isDone checks if parsing is complete
-func (r *readState) isDone() bool {
-	return r.expectedArgsCount > 0 && len(r.args) == r.expectedArgsCount
+func (e *ProtocolError) Error() string {
+	return "protocol error: " + e.Msg + " (offset " + strconv.FormatInt(e.Offset, 10) +
+		", command #" + strconv.Itoa(e.Index) + ")"
+}
+
+// Limits bounds the size of the payloads read from a stream, 0 means no limit
+// Lengths announced by headers are checked before the memory is allocated
+type Limits struct {
+	MaxBulkLen     int64 // max length of a bulk string, like proto-max-bulk-len of Redis
+	MaxRequestSize int64 // max bytes of a top level payload, including the framing
+}
+
+// streamReader wraps the buffered reader to keep track of the consumed bytes
+type streamReader struct {
+	reader       *bufio.Reader
+	offset       int64 // number of bytes consumed so far
+	index        int   // number of payloads emitted so far
+	limits       Limits
+	payloadStart int64 // offset of the top level payload being read
+	inline       bool  // accept inline commands, only sent by clients
+}
+
+// readLine reads until \n, including it
+// The lines of a client are limited to inlineMaxSize, the longer ones fail with a fatal
+// ProtocolError before they are read in full
+func (r *streamReader) readLine() ([]byte, error) {
+	if !r.inline {
+		line, err := r.reader.ReadBytes('\n')
+		r.offset += int64(len(line))
+		return line, err
+	}
+	start := r.offset
+	var line []byte
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		r.offset += int64(len(chunk))
+		if len(line)+len(chunk) > inlineMaxSize {
+			head := line
+			if len(head) == 0 {
+				head = chunk
+			}
+			msg := "too big mbulk count string"
+			if isInline(head) {
+				msg = "too big inline request"
+			}
+			return nil, &ProtocolError{Msg: msg, Offset: start, Index: r.index, Fatal: true}
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// readFull reads exactly len(buf) bytes
+func (r *streamReader) readFull(buf []byte) error {
+	n, err := io.ReadFull(r.reader, buf)
+	r.offset += int64(n)
+	return err
+}
+
+// protocolError makes a ProtocolError for data starting at the given offset
+func (r *streamReader) protocolError(data []byte, start int64, fatal bool) *ProtocolError {
+	return &ProtocolError{
+		Msg:    "invalid data " + strconv.Quote(strings.TrimSuffix(string(data), "\r\n")),
+		Offset: start,
+		Index:  r.index,
+		Fatal:  fatal,
+	}
+}
+
+// limitError makes a fatal ProtocolError for a header announcing more data than allowed
+func (r *streamReader) limitError(msg string, start int64) *ProtocolError {
+	return &ProtocolError{Msg: msg, Offset: start, Index: r.index, Fatal: true}
+}
+
+// checkBulkLen rejects a bulk string longer than the limits before its body is read
+func (r *streamReader) checkBulkLen(length int64, start int64) error {
+	if r.limits.MaxBulkLen > 0 && length > r.limits.MaxBulkLen {
+		return r.limitError("invalid bulk length "+strconv.FormatInt(length, 10)+
+			", the limit is "+strconv.FormatInt(r.limits.MaxBulkLen, 10)+" bytes", start)
+	}
+	return r.checkRequestSize(length+2, start)
+}
+
+// checkRequestSize rejects a payload which would exceed the limit after reading n more bytes
+func (r *streamReader) checkRequestSize(n int64, start int64) error {
+	max := r.limits.MaxRequestSize
+	if max > 0 && (n > max || r.offset-r.payloadStart+n > max) {
+		return r.limitError("request too large, the limit is "+strconv.FormatInt(max, 10)+" bytes", start)
+	}
+	return nil
+}
+
+// checkRequest rejects a request which is not an array of bulk strings, e.g. a RESP3 map or an
+// array holding an integer. The payload was read whole, the error is recoverable
+func (r *streamReader) checkRequest(request resp.Reply, start int64) error {
+	switch request := request.(type) {
+	case *reply.MultiBulkReply:
+		return nil
+	case *reply.MultiRawReply:
+		for _, element := range request.Replies {
+			switch element.(type) {
+			case *reply.BulkReply, *reply.NullBulkReply:
+			default:
+				return r.requestError('$', element, start)
+			}
+		}
+		return nil
+	}
+	return r.requestError('*', request, start)
+}
+
+// requestError makes the recoverable ProtocolError of a request holding got where the type
+// expected was wanted
+func (r *streamReader) requestError(expected byte, got resp.Reply, start int64) *ProtocolError {
+	msg := "expected '" + string(expected) + "', got '" + string(got.ToBytes()[:1]) + "'"
+	return &ProtocolError{Msg: msg, Offset: start, Index: r.index}
+}
+
+// isEmptyRequest reports whether a request is an empty or a null array, skipped without reply
+// like in Redis
+func isEmptyRequest(request resp.Reply) bool {
+	switch request.(type) {
+	case *reply.EmptyMultiBulkReply, *reply.NullMultiBulkReply:
+		return true
+	}
+	return false
 }
 
 // ParseStream parses the stream into individual Payloads
 // Implements concurrency
 func ParseStream(reader io.Reader) <-chan *Payload {
+	return ParseStreamWithLimits(reader, Limits{})
+}
+
+// ParseStreamWithLimits parses the stream like ParseStream, payloads over the limits are
+// rejected with a fatal ProtocolError
+func ParseStreamWithLimits(reader io.Reader, limits Limits) <-chan *Payload {
+	ch := make(chan *Payload)
+	go parseIt(reader, limits, false, ch)
+	return ch
+}
+
+// ParseRequests parses the requests of a client like ParseStreamWithLimits, the requests may
+// also be inline commands such as SET foo bar typed over telnet. The other streams, like the AOF
+// and the replies of a server, are parsed by ParseStream, where such a line is a protocol error
+func ParseRequests(reader io.Reader, limits Limits) <-chan *Payload {
 	ch := make(chan *Payload)
-	go parseIt(reader, ch)
+	go parseIt(reader, limits, true, ch)
 	return ch
 }
 
 // parseIt parses the input stream and sends Payloads to the channel
-func parseIt(reader io.Reader, ch chan<- *Payload) {
+func parseIt(reader io.Reader, limits Limits, inline bool, ch chan<- *Payload) {
 	defer func() {
 		if err := recover(); err != nil {
 			// Print stack trace information
@@ -49,163 +191,261 @@ func parseIt(reader io.Reader, ch chan<- *Payload) {
 		}
 	}()
 
-	bufReader := bufio.NewReader(reader) // Buffered reader
-	var state readState                  // Parser state
-	var err error
-	var msg []byte
-
-	// Read data
+	stream := &streamReader{reader: bufio.NewReader(reader), limits: limits, inline: inline} // Buffered reader
 	for {
-		var ioErr bool // Whether it is an IO error
-		msg, ioErr, err = readLine(bufReader, &state)
+		start := stream.offset
+		stream.payloadStart = start
+		line, err := stream.readLine()
 		if err != nil {
-			// If it is an IO error, close the channel and exit the loop
-			if ioErr {
+			// IO error, the stream is over
+			ch <- &Payload{Err: err}
+			close(ch)
+			return
+		}
+		if stream.inline && isInline(line) {
+			args, err := parseInline(line, start, stream)
+			if err != nil {
 				ch <- &Payload{Err: err}
-				close(ch)
-				return
+				stream.index++
+			} else if args != nil {
+				ch <- &Payload{Data: args}
+				stream.index++
 			}
+			// a blank line is skipped without reply
+			continue
+		}
+		if !isValidLine(line) {
+			// Does not conform to RESP protocol format, skip the line
+			ch <- &Payload{Err: stream.protocolError(line, start, false)}
+			stream.index++
+			continue
+		}
+		result, ioErr, err := parseReply(line, start, stream, true)
+		if err != nil {
 			ch <- &Payload{Err: err}
-			state = readState{} // Reset state
-			continue            // Continue the loop to read the next line
-		}
-
-		// Non-multi-line reading state
-		if !state.readingMultiLine {
-			// Multi-bulk reply
-			if msg[0] == '*' {
-				// Parse the header to get the expected number of arguments
-				err = parseMultiBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{Err: errors.New("Protocol error" + string(msg))}
-					state = readState{} // Reset state
-					continue            // Continue the loop to read the next line
-				}
-				// If the expected number of arguments is 0, return directly
-				if state.expectedArgsCount == 0 {
-					ch <- &Payload{Data: &reply.EmptyMultiBulkReply{}}
-					state = readState{} // Reset state
-					continue            // Continue the loop to read the next line
-				}
-			} else if msg[0] == '$' {
-				// Bulk reply
-				err = parseBulkHeader(msg, &state) // Parse the Bulk reply header to get the length
-				if err != nil {
-					ch <- &Payload{Err: errors.New("Protocol error" + string(msg))}
-					state = readState{} // Reset state
-					continue            // Continue the loop to read the next line
-				}
-				if state.bulkLen == -1 {
-					// If the length of the Bulk reply is 0, return directly
-					ch <- &Payload{Data: &reply.NullBulkReply{}}
-					state = readState{} // Reset state
-					continue            // Continue the loop to read the next line
-				}
-			} else {
-				// Single-line reply
-				result, err := parseSingleLineReply(msg)
-				ch <- &Payload{Data: result, Err: err}
-				state = readState{} // This message is complete, reset state
-				continue            // Continue the loop to read the next line
+			if ioErr {
+				close(ch)
+				return
 			}
-		} else {
-			err = readBody(msg, &state)
-			if err != nil {
-				ch <- &Payload{
-					Err: errors.New("protocol error: " + string(msg)),
-				}
-				state = readState{} // Reset state
+			stream.index++
+			continue // Continue the loop to read the next line
+		}
+		if stream.inline {
+			if isEmptyRequest(result) {
 				continue
 			}
-			// If parsing is complete, return the result
-			if state.isDone() {
-				var result resp.Reply
-				if state.msgType == '*' {
-					result = reply.MakeMultiBulkReply(state.args)
-				} else if state.msgType == '$' {
-					result = reply.MakeBulkReply(state.args[0])
-				}
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
-				}
-				state = readState{}
+			if err := stream.checkRequest(result, start); err != nil {
+				ch <- &Payload{Err: err}
+				stream.index++
+				continue
 			}
 		}
+		ch <- &Payload{Data: result}
+		stream.index++
 	}
 }
 
-// readLine reads a line of data
-func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
-	var line []byte
-	var err error
-	// Read a normal line
-	if state.bulkLen == 0 {
-		line, err = bufReader.ReadBytes('\n')
+// isValidLine checks that the line ends with \r\n and carries at least a type byte
+func isValidLine(line []byte) bool {
+	return len(line) > 2 && line[len(line)-2] == '\r'
+}
+
+// parseReply parses a complete reply starting with the given header line read at offset start
+// Both RESP2 and RESP3 types are supported
+// topLevel is false for elements of an aggregate, whose errors are always fatal
+// The returned bool reports whether the error comes from the underlying reader
+func parseReply(line []byte, start int64, stream *streamReader, topLevel bool) (resp.Reply, bool, error) {
+	switch line[0] {
+	case '*': // Multi-bulk reply
+		return parseArray(line, start, stream)
+	case '$': // Bulk reply
+		return parseBulkString(line, start, stream)
+	case '%', '~', '>': // RESP3 map, set and push
+		return parseAggregate(line, start, stream)
+	case '=', '!': // RESP3 verbatim string and blob error
+		return parseBlobReply(line, start, stream)
+	default: // Single-line reply
+		result, err := parseSingleLineReply(line)
 		if err != nil {
-			// An error occurred
-			return nil, true, err
+			return nil, false, stream.protocolError(line, start, !topLevel)
 		}
-		if len(line) == 0 || line[len(line)-2] != '\r' {
-			// Does not conform to RESP protocol format
-			return nil, false, errors.New("Protocol error: " + string(line))
+		return result, false, nil
+	}
+}
+
+// parseLength parses the length in the header of a bulk string or an aggregate, -1 means null
+func parseLength(header []byte, start int64, stream *streamReader) (int64, error) {
+	length, err := strconv.ParseInt(string(header[1:len(header)-2]), 10, 64)
+	if err != nil || length < -1 {
+		return 0, stream.protocolError(header, start, true)
+	}
+	return length, nil
+}
+
+// readBlob reads a body of the given length followed by \r\n
+func readBlob(length int64, stream *streamReader) ([]byte, bool, error) {
+	bodyStart := stream.offset
+	body := make([]byte, length+2) // 2 is the length of \r\n
+	err := stream.readFull(body)
+	if err != nil {
+		return nil, true, err
+	}
+	if body[length] != '\r' || body[length+1] != '\n' {
+		return nil, false, stream.protocolError(body, bodyStart, true)
+	}
+	return body[:length], false, nil
+}
+
+// maxPreallocElements bounds the elements allocated from the count of an aggregate header before
+// they are read, so that a header such as *100000000 does not allocate gigabytes
+const maxPreallocElements = 1024
+
+// readElements reads count replies, used by arrays and RESP3 aggregates
+func readElements(count int64, start int64, stream *streamReader) ([]resp.Reply, bool, error) {
+	// every element takes at least 3 bytes, like :0\r\n, check before allocating the slice
+	if max := stream.limits.MaxRequestSize; max > 0 && count > 0 {
+		if err := stream.checkRequestSize(min(count, max)*3, start); err != nil {
+			return nil, false, err
 		}
-	} else {
-		// Read Bulk reply
-		line = make([]byte, state.bulkLen+2) // 2 is the length of \r\n
-		_, err = io.ReadFull(bufReader, line)
+	}
+	// the count comes from the header, the slice grows with the elements actually received
+	elements := make([]resp.Reply, 0, min(count, maxPreallocElements))
+	for i := int64(0); i < count; i++ {
+		lineStart := stream.offset
+		line, err := stream.readLine()
 		if err != nil {
-			// An error occurred
 			return nil, true, err
 		}
-		if len(line) == 0 || line[len(line)-2] != '\r' || line[len(line)-1] != '\n' {
-			// Does not conform to RESP protocol format
-			return nil, false, errors.New("Protocol error: " + string(line))
+		if !isValidLine(line) {
+			return nil, false, stream.protocolError(line, lineStart, true)
 		}
-		state.bulkLen = 0
+		element, ioErr, err := parseReply(line, lineStart, stream, false)
+		if err != nil {
+			return nil, ioErr, err
+		}
+		elements = append(elements, element)
 	}
-	return line, false, nil
+	return elements, false, nil
 }
 
-func parseMultiBulkHeader(msg []byte, state *readState) error {
-	var err error
-	var expectedLine uint64
-	expectedLine, err = strconv.ParseUint(string(msg[1:len(msg)-2]), 10, 32)
+// parseBulkString reads the body of a bulk string whose header is given
+func parseBulkString(header []byte, start int64, stream *streamReader) (resp.Reply, bool, error) {
+	bulkLen, err := parseLength(header, start, stream)
 	if err != nil {
-		return errors.New("protocol error: " + string(msg))
+		return nil, false, err
 	}
-	if expectedLine == 0 {
-		state.expectedArgsCount = 0
-		return nil
-	} else if expectedLine > 0 {
-		// Multi-line reading
-		state.msgType = msg[0]
-		state.readingMultiLine = true
-		state.expectedArgsCount = int(expectedLine)
-		state.args = make([][]byte, 0, expectedLine)
-		return nil
-	} else {
-		return errors.New("protocol error: " + string(msg))
+	if bulkLen == -1 { // Null bulk
+		return reply.MakeNullBulkReply(), false, nil
 	}
+	if err := stream.checkBulkLen(bulkLen, start); err != nil {
+		return nil, false, err
+	}
+	body, ioErr, err := readBlob(bulkLen, stream)
+	if err != nil {
+		return nil, ioErr, err
+	}
+	return reply.MakeBulkReply(body), false, nil
 }
 
-func parseBulkHeader(msg []byte, state *readState) error {
-	var err error
-	state.bulkLen, err = strconv.ParseInt(string(msg[1:len(msg)-2]), 10, 64)
+// parseBlobReply reads a RESP3 verbatim string (=) or blob error (!)
+func parseBlobReply(header []byte, start int64, stream *streamReader) (resp.Reply, bool, error) {
+	length, err := parseLength(header, start, stream)
 	if err != nil {
-		return errors.New("protocol error: " + string(msg))
+		return nil, false, err
 	}
-	if state.bulkLen == -1 { // Null bulk
-		return nil
-	} else if state.bulkLen > 0 {
-		state.msgType = msg[0]
-		state.readingMultiLine = true
-		state.expectedArgsCount = 1
-		state.args = make([][]byte, 0, 1)
-		return nil
-	} else {
-		return errors.New("protocol error: " + string(msg))
+	if length == -1 {
+		return nil, false, stream.protocolError(header, start, true)
+	}
+	if err := stream.checkBulkLen(length, start); err != nil {
+		return nil, false, err
+	}
+	bodyStart := stream.offset
+	body, ioErr, err := readBlob(length, stream)
+	if err != nil {
+		return nil, ioErr, err
 	}
+	if header[0] == '!' {
+		return reply.MakeBlobErrorReply(string(body)), false, nil
+	}
+	// the text is prefixed with a 3 bytes format and a colon, e.g. txt:
+	if len(body) < 4 || body[3] != ':' {
+		return nil, false, stream.protocolError(body, bodyStart, true)
+	}
+	return reply.MakeVerbatimStringReply(string(body[:3]), body[4:]), false, nil
+}
+
+// parseAggregate reads a RESP3 map (%), set (~) or push (>)
+func parseAggregate(header []byte, start int64, stream *streamReader) (resp.Reply, bool, error) {
+	count, err := parseLength(header, start, stream)
+	if err != nil {
+		return nil, false, err
+	}
+	if count == -1 {
+		return nil, false, stream.protocolError(header, start, true)
+	}
+	if header[0] != '%' {
+		elements, ioErr, err := readElements(count, start, stream)
+		if err != nil {
+			return nil, ioErr, err
+		}
+		if header[0] == '~' {
+			return reply.MakeSetReply(elements), false, nil
+		}
+		return reply.MakePushReply(elements), false, nil
+	}
+	// a map of n entries is followed by n keys and n values, interleaved
+	if count > math.MaxInt64/2 {
+		return nil, false, stream.protocolError(header, start, true)
+	}
+	elements, ioErr, err := readElements(count*2, start, stream)
+	if err != nil {
+		return nil, ioErr, err
+	}
+	keys := make([]resp.Reply, count)
+	values := make([]resp.Reply, count)
+	for i := int64(0); i < count; i++ {
+		keys[i] = elements[2*i]
+		values[i] = elements[2*i+1]
+	}
+	return reply.MakeMapReply(keys, values), false, nil
+}
+
+// parseArray reads all elements of an array whose header is given
+// Arrays made only of bulk strings are returned as MultiBulkReply (which is how commands are sent),
+// other arrays (nested arrays, integers, status...) as MultiRawReply
+func parseArray(header []byte, start int64, stream *streamReader) (resp.Reply, bool, error) {
+	count, err := parseLength(header, start, stream)
+	if err != nil {
+		return nil, false, err
+	}
+	if count == -1 {
+		return reply.MakeNullMultiBulkReply(), false, nil
+	}
+	if count == 0 {
+		return reply.MakeEmptyMultiBulkReply(), false, nil
+	}
+	elements, ioErr, err := readElements(count, start, stream)
+	if err != nil {
+		return nil, ioErr, err
+	}
+	onlyBulk := true
+	for _, element := range elements {
+		switch element.(type) {
+		case *reply.BulkReply, *reply.NullBulkReply:
+		default:
+			onlyBulk = false
+		}
+	}
+	if !onlyBulk {
+		return reply.MakeMultiRawReply(elements), false, nil
+	}
+	args := make([][]byte, len(elements))
+	for i, element := range elements {
+		if bulk, ok := element.(*reply.BulkReply); ok {
+			args[i] = bulk.Arg
+		}
+	}
+	return reply.MakeMultiBulkReply(args), false, nil
 }
 
 // parseSingleLineReply parses a single-line reply
@@ -220,32 +460,50 @@ func parseSingleLineReply(msg []byte) (resp.Reply, error) {
 	case ':': // Integer reply
 		val, err := strconv.ParseInt(str[1:], 10, 64)
 		if err != nil {
-			return nil, errors.New("protocol error: " + string(msg))
+			return nil, errors.New("protocol error: " + str)
 		}
 		result = reply.MakeIntReply(val)
+	case '_': // RESP3 null
+		if len(str) != 1 {
+			return nil, errors.New("protocol error: " + str)
+		}
+		result = reply.MakeNullReply()
+	case '#': // RESP3 boolean
+		switch str[1:] {
+		case "t":
+			result = reply.MakeBooleanReply(true)
+		case "f":
+			result = reply.MakeBooleanReply(false)
+		default:
+			return nil, errors.New("protocol error: " + str)
+		}
+	case ',': // RESP3 double, inf and -inf are accepted by ParseFloat
+		val, err := strconv.ParseFloat(str[1:], 64)
+		if err != nil && !errors.Is(err, strconv.ErrRange) {
+			return nil, errors.New("protocol error: " + str)
+		}
+		result = reply.MakeDoubleReply(val)
+	case '(': // RESP3 big number
+		if !isBigNumber(str[1:]) {
+			return nil, errors.New("protocol error: " + str)
+		}
+		result = reply.MakeBigNumberReply(str[1:])
+	default:
+		return nil, errors.New("protocol error: " + str)
 	}
 	return result, nil
 }
 
-// readBody reads the message body
-func readBody(msg []byte, state *readState) error {
-	if len(msg) < 2 {
-		return errors.New("protocol error: message too short")
+// isBigNumber checks that the string is a decimal integer with optional sign
+func isBigNumber(str string) bool {
+	str = strings.TrimPrefix(strings.TrimPrefix(str, "-"), "+")
+	if str == "" {
+		return false
 	}
-	line := msg[0 : len(msg)-2]
-	var err error
-	if line[0] == '$' {
-		// Bulk reply
-		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
-		if err != nil {
-			return errors.New("protocol error: " + string(msg))
-		}
-		if state.bulkLen <= 0 { // Null bulk in multi-bulks
-			state.args = append(state.args, []byte{})
-			state.bulkLen = 0
+	for i := 0; i < len(str); i++ {
+		if str[i] < '0' || str[i] > '9' {
+			return false
 		}
-	} else {
-		state.args = append(state.args, line)
 	}
-	return nil
+	return true
 }
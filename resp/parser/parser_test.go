@@ -0,0 +1,201 @@
+package parser
+
+import (
+	"errors"
+	"io"
+	"redigo/interface/resp"
+	"redigo/resp/reply"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// parseAll returns the payloads of the stream until it is over
+func parseAll(ch <-chan *Payload) []*Payload {
+	var payloads []*Payload
+	for payload := range ch {
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+// protocolError returns the ProtocolError of the payload, nil if it is not one
+func protocolError(payload *Payload) *ProtocolError {
+	var err *ProtocolError
+	if errors.As(payload.Err, &err) {
+		return err
+	}
+	return nil
+}
+
+// TestParseStream tests the payloads of RESP2 and RESP3 and the end of the stream
+func TestParseStream(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$0\r\n\r\n" +
+		"+OK\r\n-ERR bad\r\n:42\r\n$-1\r\n*-1\r\n*0\r\n" +
+		"*2\r\n:1\r\n$1\r\nx\r\n%1\r\n+key\r\n:1\r\n~1\r\n#t\r\n"
+	payloads := parseAll(ParseStream(strings.NewReader(input)))
+	expected := []resp.Reply{
+		reply.MakeMultiBulkReply([][]byte{[]byte("SET"), []byte("a"), {}}),
+		reply.MakeStatusReply("OK"),
+		reply.MakeStandardErrorReply("ERR bad"),
+		reply.MakeIntReply(42),
+		reply.MakeNullBulkReply(),
+		reply.MakeNullMultiBulkReply(),
+		reply.MakeEmptyMultiBulkReply(),
+		reply.MakeMultiRawReply([]resp.Reply{reply.MakeIntReply(1), reply.MakeBulkReply([]byte("x"))}),
+		reply.MakeMapReply([]resp.Reply{reply.MakeStatusReply("key")}, []resp.Reply{reply.MakeIntReply(1)}),
+		reply.MakeSetReply([]resp.Reply{reply.MakeBooleanReply(true)}),
+	}
+	if len(payloads) != len(expected)+1 {
+		t.Fatalf("Expected %d payloads and the end of the stream, got %d", len(expected), len(payloads))
+	}
+	for i, r := range expected {
+		if payloads[i].Err != nil {
+			t.Errorf("Expected payload %d to be %q, got the error %v", i, r.ToBytes(), payloads[i].Err)
+		} else if got := string(payloads[i].Data.ToBytes()); got != string(r.ToBytes()) {
+			t.Errorf("Expected payload %d to be %q, got %q", i, r.ToBytes(), got)
+		}
+	}
+	if err := payloads[len(expected)].Err; err != io.EOF {
+		t.Errorf("Expected the stream to end with EOF, got %v", err)
+	}
+}
+
+// TestMalformedHeaders tests the errors of the invalid headers and bodies, the fatal errors are
+// those after which the framing is lost
+func TestMalformedHeaders(t *testing.T) {
+	cases := []struct {
+		input string
+		fatal bool
+	}{
+		{"*abc\r\n", true},
+		{"*-2\r\n", true},
+		{"*\r\n", true},
+		{"$abc\r\n", true},
+		{"$-3\r\n", true},
+		{"$3\r\nabcd\r\n", true},
+		{"*1\r\n:x\r\n", true},
+		{"*2\r\n$3\r\nGET\r\nfoo\r\n", true},
+		{"%-1\r\n", true},
+		{"=5\r\nabcde\r\n", true},
+		{":x\r\n", false},
+		{"#x\r\n", false},
+		{"+OK\n", false},
+		{"PING\r\n", false},
+	}
+	for _, c := range cases {
+		payloads := parseAll(ParseStream(strings.NewReader(c.input)))
+		err := protocolError(payloads[0])
+		if err == nil {
+			t.Errorf("Expected a protocol error for %q, got %v", c.input, payloads[0])
+			continue
+		}
+		if err.Fatal != c.fatal {
+			t.Errorf("Expected the error of %q to be fatal=%v, got %v", c.input, c.fatal, err)
+		}
+		if err.Offset != 0 && !c.fatal {
+			t.Errorf("Expected the error of %q at offset 0, got %d", c.input, err.Offset)
+		}
+	}
+}
+
+// TestRecoverableError tests that a line which is not RESP is skipped and the next payloads are
+// parsed, with the offset and the index of the error
+func TestRecoverableError(t *testing.T) {
+	payloads := parseAll(ParseStream(strings.NewReader("+OK\r\nnot resp\r\n:1\r\n")))
+	if len(payloads) != 4 {
+		t.Fatalf("Expected 3 payloads and the end of the stream, got %d", len(payloads))
+	}
+	err := protocolError(payloads[1])
+	if err == nil || err.Fatal || err.Offset != 5 || err.Index != 1 {
+		t.Errorf("Expected a recoverable error at offset 5 of command #1, got %v", payloads[1].Err)
+	}
+	if got := string(payloads[2].Data.ToBytes()); got != ":1\r\n" {
+		t.Errorf("Expected the next payload to be parsed, got %q", got)
+	}
+}
+
+// TestRequestElements tests that a request array with an element other than a bulk string is a
+// recoverable error naming the type of the element, the next request is parsed
+func TestRequestElements(t *testing.T) {
+	input := "*2\r\n$3\r\nGET\r\n:12\r\n*2\r\n$3\r\nGET\r\n$-1\r\n"
+	payloads := parseAll(ParseRequests(strings.NewReader(input), Limits{}))
+	if len(payloads) != 3 {
+		t.Fatalf("Expected 2 payloads and the end of the stream, got %d", len(payloads))
+	}
+	err := protocolError(payloads[0])
+	if err == nil || err.Fatal || err.Msg != "expected '$', got ':'" || err.Offset != 0 {
+		t.Errorf("Expected a recoverable error on the integer, got %v", payloads[0].Err)
+	}
+	if got := string(payloads[1].Data.ToBytes()); got != "*2\r\n$3\r\nGET\r\n$-1\r\n" {
+		t.Errorf("Expected the next request to be parsed, got %q", got)
+	}
+
+	// the replies of a server may mix the types
+	payloads = parseAll(ParseStream(strings.NewReader(input)))
+	if payloads[0].Err != nil {
+		t.Errorf("Expected the array to be parsed outside of the requests, got %v", payloads[0].Err)
+	}
+}
+
+// TestRequestTypes tests that a request which is not an array is a recoverable error naming its
+// type, and that the empty and null arrays are skipped
+func TestRequestTypes(t *testing.T) {
+	for _, input := range []string{"%1\r\n$3\r\nGET\r\n$1\r\na\r\n", "$3\r\nGET\r\n", ":1\r\n", "+PING\r\n", "~1\r\n$4\r\nPING\r\n"} {
+		payloads := parseAll(ParseRequests(strings.NewReader(input+"*1\r\n$4\r\nPING\r\n"), Limits{}))
+		if len(payloads) != 3 {
+			t.Fatalf("Expected 2 payloads and the end of the stream for %q, got %d", input, len(payloads))
+		}
+		err := protocolError(payloads[0])
+		if expected := "expected '*', got '" + input[:1] + "'"; err == nil || err.Fatal || err.Msg != expected {
+			t.Errorf("Expected a recoverable error for %q, got %v", input, payloads[0].Err)
+		}
+		if payloads[1].Err != nil {
+			t.Errorf("Expected the next request of %q to be parsed, got %v", input, payloads[1].Err)
+		}
+	}
+
+	payloads := parseAll(ParseRequests(strings.NewReader("*0\r\n*-1\r\n*1\r\n$4\r\nPING\r\n"), Limits{}))
+	if len(payloads) != 2 || payloads[0].Err != nil || string(payloads[0].Data.ToBytes()) != "*1\r\n$4\r\nPING\r\n" {
+		t.Errorf("Expected the empty arrays to be skipped, got %v", payloads)
+	}
+}
+
+// TestLimits tests that the headers announcing more than the limits are rejected before the
+// data is read
+func TestLimits(t *testing.T) {
+	limits := Limits{MaxBulkLen: 5, MaxRequestSize: 64}
+	cases := []struct {
+		input string
+		msg   string
+	}{
+		{"$6\r\nabcdef\r\n", "invalid bulk length 6, the limit is 5 bytes"},
+		{"*100\r\n", "request too large, the limit is 64 bytes"},
+		{"*6\r\n" + strings.Repeat("$5\r\nabcde\r\n", 6), "request too large, the limit is 64 bytes"},
+	}
+	for _, c := range cases {
+		payloads := parseAll(ParseStreamWithLimits(strings.NewReader(c.input), limits))
+		err := protocolError(payloads[0])
+		if err == nil || !err.Fatal || err.Msg != c.msg {
+			t.Errorf("Expected the fatal error %q for %q, got %v", c.msg, c.input, payloads[0].Err)
+		}
+	}
+}
+
+// TestHugeArrayHeader tests that the count of an array header does not allocate the elements
+// before they are received
+func TestHugeArrayHeader(t *testing.T) {
+	for _, header := range []string{"*100000000\r\n", "%100000000\r\n", "~100000000\r\n"} {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		payloads := parseAll(ParseRequests(strings.NewReader(header+"$4\r\nPING\r\n"), Limits{}))
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("Expected %q to allocate less than 1MB, got %d bytes", header, allocated)
+		}
+		if len(payloads) != 1 || payloads[0].Err != io.EOF {
+			t.Errorf("Expected the truncated array of %q to end the stream, got %v", header, payloads)
+		}
+	}
+}
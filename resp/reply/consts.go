@@ -1,12 +1,28 @@
 // Package reply consts： 对客户端的一些固定的回复
 package reply
 
+// 固定回复的字节数组是共享的，不必每次回复都重新分配
+var (
+	pongBytes           = []byte("+PONG\r\n")
+	okBytes             = []byte("+OK\r\n")
+	nullBulkBytes       = []byte("$-1\r\n")
+	emptyBulkBytes      = []byte("$0\r\n\r\n")
+	emptyMultiBulkBytes = []byte("*0\r\n")
+	nullMultiBulkBytes  = []byte("*-1\r\n")
+)
+
+// shared 返回共享的字节数组，容量等于长度，调用者 append 时会复制而不会修改共享的数组
+// 调用者不能修改 ToBytes 返回的字节
+func shared(b []byte) []byte {
+	return b[:len(b):len(b)]
+}
+
 // PongReply 在客户端发送 PING 命令时的回复是固定的 PONG
 type PongReply struct{}
 
 // ToBytes 将回复转换为字节数组
 func (r *PongReply) ToBytes() []byte {
-	return []byte("+PONG\r\n")
+	return shared(pongBytes)
 }
 
 // MakePongReply 创建一个 PONG 回复
@@ -19,7 +35,7 @@ func MakePongReply() *PongReply {
 type OKReply struct{}
 
 func (r *OKReply) ToBytes() []byte {
-	return []byte("+OK\r\n")
+	return shared(okBytes)
 }
 
 func MakeOKReply() *OKReply {
@@ -30,7 +46,7 @@ func MakeOKReply() *OKReply {
 type NullBulkReply struct{}
 
 func (r *NullBulkReply) ToBytes() []byte {
-	return []byte("$-1\r\n") // -1，表示 nil 值
+	return shared(nullBulkBytes) // -1，表示 nil 值
 }
 
 func MakeNullBulkReply() *NullBulkReply {
@@ -41,7 +57,7 @@ func MakeNullBulkReply() *NullBulkReply {
 type EmptyBulkReply struct{}
 
 func (r *EmptyBulkReply) ToBytes() []byte {
-	return []byte("$0\r\n\r\n") // 0，表示空字符串
+	return shared(emptyBulkBytes) // 0，表示空字符串
 }
 
 func MakeEmptyBulkReply() *EmptyBulkReply {
@@ -52,13 +68,24 @@ func MakeEmptyBulkReply() *EmptyBulkReply {
 type EmptyMultiBulkReply struct{}
 
 func (r *EmptyMultiBulkReply) ToBytes() []byte {
-	return []byte("*0\r\n")
+	return shared(emptyMultiBulkBytes)
 }
 
 func MakeEmptyMultiBulkReply() *EmptyMultiBulkReply {
 	return &EmptyMultiBulkReply{}
 }
 
+// NullMultiBulkReply 空的 MultiBulk 回复(数组 nil)，例如阻塞命令超时
+type NullMultiBulkReply struct{}
+
+func (r *NullMultiBulkReply) ToBytes() []byte {
+	return shared(nullMultiBulkBytes)
+}
+
+func MakeNullMultiBulkReply() *NullMultiBulkReply {
+	return &NullMultiBulkReply{}
+}
+
 // NoReply 无回复
 type NoReply struct{}
 
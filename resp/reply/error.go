@@ -1,5 +1,7 @@
 package reply
 
+import "strconv"
+
 // UnknownReply 未知错误回复
 type UnknownReply struct{}
 
@@ -78,3 +80,64 @@ func (r *ProtocolErrReply) ToBytes() []byte {
 func MakeProtocolErrReply(msg string) *ProtocolErrReply {
 	return &ProtocolErrReply{Msg: msg}
 }
+
+// NoAuthErrReply 设置了 requirepass 时，未认证的客户端发送了 AUTH 和 PING 以外的命令
+type NoAuthErrReply struct{}
+
+func (r *NoAuthErrReply) Error() string {
+	return "NOAUTH Authentication required."
+}
+
+func (r *NoAuthErrReply) ToBytes() []byte {
+	return []byte("-NOAUTH Authentication required.\r\n")
+}
+
+func MakeNoAuthErrReply() *NoAuthErrReply {
+	return &NoAuthErrReply{}
+}
+
+// CrossSlotErrReply 集群模式下多键命令的键不在同一个节点
+type CrossSlotErrReply struct{}
+
+func (r *CrossSlotErrReply) Error() string {
+	return "CROSSSLOT Keys in request don't hash to the same slot"
+}
+
+func (r *CrossSlotErrReply) ToBytes() []byte {
+	return []byte("-CROSSSLOT Keys in request don't hash to the same slot\r\n")
+}
+
+func MakeCrossSlotErrReply() *CrossSlotErrReply {
+	return &CrossSlotErrReply{}
+}
+
+// Redirect kinds of RedirectErrReply
+const (
+	RedirectMoved = "MOVED" // 槽已经永久迁移到另一个节点，客户端应更新槽映射
+	RedirectAsk   = "ASK"   // 槽正在迁移，只有这一条命令需要带上 ASKING 发送到目标节点
+)
+
+// RedirectErrReply 集群模式下告诉客户端槽所在的节点，如 -MOVED 3999 127.0.0.1:6381
+type RedirectErrReply struct {
+	Kind string // RedirectMoved 或 RedirectAsk
+	Slot int    // 键所在的槽
+	Addr string // 目标节点的地址 host:port
+}
+
+func (r *RedirectErrReply) Error() string {
+	return r.Kind + " " + strconv.Itoa(r.Slot) + " " + r.Addr
+}
+
+func (r *RedirectErrReply) ToBytes() []byte {
+	return []byte("-" + r.Error() + "\r\n")
+}
+
+// MakeMovedErrReply 创建 -MOVED 回复，槽 slot 由 addr 节点负责
+func MakeMovedErrReply(slot int, addr string) *RedirectErrReply {
+	return &RedirectErrReply{Kind: RedirectMoved, Slot: slot, Addr: addr}
+}
+
+// MakeAskErrReply 创建 -ASK 回复，槽 slot 正在迁移到 addr 节点
+func MakeAskErrReply(slot int, addr string) *RedirectErrReply {
+	return &RedirectErrReply{Kind: RedirectAsk, Slot: slot, Addr: addr}
+}
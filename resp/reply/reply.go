@@ -1,7 +1,7 @@
 package reply
 
 import (
-	"bytes"
+	"io"
 	"redigo/interface/resp"
 	"strconv"
 )
@@ -25,9 +25,9 @@ type BulkReply struct {
 
 // ToBytes 将不符合 RESP 协议的字符串转换为符合 RESP 协议的字符串
 func (r *BulkReply) ToBytes() []byte {
-	// 如果字符串为空，返回空字符串
-	if len(r.Arg) == 0 {
-		return nullBUlkReplyBytes
+	// nil 表示空值，长度为 0 的切片表示空字符串
+	if r.Arg == nil {
+		return []byte(string(nullBUlkReplyBytes) + CRLF)
 	}
 	// 将 BulkReply 转换为符合 RESP 协议的字节数组
 	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
@@ -43,25 +43,40 @@ type MultiBulkReply struct {
 }
 
 func (r *MultiBulkReply) ToBytes() []byte {
-	argLen := len(r.Args)
-	var buf bytes.Buffer
-	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
+	return toBytes(r)
+}
+
+// WriteTo 逐个写出元素，*3\r\n$3\r\nfoo\r\n$3\r\nbar\r\n$5\r\nhello\r\n
+func (r *MultiBulkReply) WriteTo(w io.Writer) (int64, error) {
+	sw := &streamWriter{w: w}
+	sw.writeString("*" + strconv.Itoa(len(r.Args)) + CRLF)
 	for _, arg := range r.Args {
-		if arg == nil {
-			// *-1\r\n\r\n 表示空数组
-			buf.WriteString(string(nullBUlkReplyBytes) + CRLF)
-		} else {
-			// *3\r\n$3\r\nfoo\r\n$3\r\nbar\r\n$5\r\nhello\r\n
-			buf.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
-		}
+		sw.writeBulk(arg)
 	}
-	return buf.Bytes()
+	return sw.n, sw.err
 }
 
 func MakeMultiBulkReply(args [][]byte) *MultiBulkReply {
 	return &MultiBulkReply{Args: args}
 }
 
+// MultiRawReply 由任意类型回复组成的数组，可以嵌套，例如 CLUSTER SLOTS 的回复
+type MultiRawReply struct {
+	Replies []resp.Reply
+}
+
+func (r *MultiRawReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+func (r *MultiRawReply) WriteTo(w io.Writer) (int64, error) {
+	return writeAggregate(w, '*', r.Replies)
+}
+
+func MakeMultiRawReply(replies []resp.Reply) *MultiRawReply {
+	return &MultiRawReply{Replies: replies}
+}
+
 // StatusReply 状态回复
 type StatusReply struct {
 	Status string
@@ -104,11 +119,34 @@ type IntReply struct {
 
 // ToBytes marshal redis.Reply
 func (r *IntReply) ToBytes() []byte {
+	if r.Code >= 0 && r.Code < sharedIntegers {
+		return shared(sharedIntegerBytes[r.Code])
+	}
 	return []byte(":" + strconv.FormatInt(r.Code, 10) + CRLF)
 }
 
+// sharedIntegers 像 Redis 的共享整数一样，0 到 9999 的整数回复预先创建并共享，
+// 计数、长度等命令的回复大多在这个范围内，不必每次分配
+const sharedIntegers = 10000
+
+var (
+	sharedIntReplies   [sharedIntegers]IntReply
+	sharedIntegerBytes [sharedIntegers][]byte
+)
+
+func init() {
+	for i := range sharedIntReplies {
+		sharedIntReplies[i].Code = int64(i)
+		sharedIntegerBytes[i] = []byte(":" + strconv.Itoa(i) + CRLF)
+	}
+}
+
 // MakeIntReply creates int reply
+// 共享的整数回复不能修改
 func MakeIntReply(code int64) *IntReply {
+	if code >= 0 && code < sharedIntegers {
+		return &sharedIntReplies[code]
+	}
 	return &IntReply{
 		Code: code,
 	}
@@ -0,0 +1,176 @@
+package reply
+
+import (
+	"io"
+	"math"
+	"redigo/interface/resp"
+	"strconv"
+)
+
+// RESP3 新增的回复类型，ToBytes 输出的是 RESP3 格式
+
+// NullReply RESP3 的空值 `_`
+type NullReply struct{}
+
+func (r *NullReply) ToBytes() []byte {
+	return []byte("_\r\n")
+}
+
+func MakeNullReply() *NullReply {
+	return &NullReply{}
+}
+
+// BooleanReply 布尔值回复，#t 或 #f
+type BooleanReply struct {
+	Value bool
+}
+
+func (r *BooleanReply) ToBytes() []byte {
+	if r.Value {
+		return []byte("#t\r\n")
+	}
+	return []byte("#f\r\n")
+}
+
+func MakeBooleanReply(value bool) *BooleanReply {
+	return &BooleanReply{Value: value}
+}
+
+// DoubleReply 浮点数回复，例如 ,3.14
+type DoubleReply struct {
+	Value float64
+}
+
+func (r *DoubleReply) ToBytes() []byte {
+	return []byte("," + FormatDouble(r.Value) + CRLF)
+}
+
+func MakeDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{Value: value}
+}
+
+// FormatDouble 按 RESP3 的写法格式化浮点数，无穷大写作 inf 和 -inf
+func FormatDouble(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "inf"
+	case math.IsInf(value, -1):
+		return "-inf"
+	case math.IsNaN(value):
+		return "nan"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// BigNumberReply 大整数回复，例如 (3492890328409238509324850943850943825024385
+// 这里保留原始的十进制字符串
+type BigNumberReply struct {
+	Number string
+}
+
+func (r *BigNumberReply) ToBytes() []byte {
+	return []byte("(" + r.Number + CRLF)
+}
+
+func MakeBigNumberReply(number string) *BigNumberReply {
+	return &BigNumberReply{Number: number}
+}
+
+// VerbatimStringReply 带格式的字符串回复，例如 =15\r\ntxt:Some string\r\n
+type VerbatimStringReply struct {
+	Format string // 3 个字符的格式，例如 txt、mkd
+	Text   []byte
+}
+
+func (r *VerbatimStringReply) ToBytes() []byte {
+	length := len(r.Format) + 1 + len(r.Text)
+	return []byte("=" + strconv.Itoa(length) + CRLF + r.Format + ":" + string(r.Text) + CRLF)
+}
+
+func MakeVerbatimStringReply(format string, text []byte) *VerbatimStringReply {
+	return &VerbatimStringReply{Format: format, Text: text}
+}
+
+// BlobErrorReply 二进制安全的错误回复，例如 !21\r\nSYNTAX invalid syntax\r\n
+type BlobErrorReply struct {
+	Status string
+}
+
+func (r *BlobErrorReply) ToBytes() []byte {
+	return []byte("!" + strconv.Itoa(len(r.Status)) + CRLF + r.Status + CRLF)
+}
+
+func (r *BlobErrorReply) Error() string {
+	return r.Status
+}
+
+func MakeBlobErrorReply(status string) *BlobErrorReply {
+	return &BlobErrorReply{Status: status}
+}
+
+// MapReply 字典回复，Keys 和 Values 一一对应，保持原来的顺序
+type MapReply struct {
+	Keys   []resp.Reply
+	Values []resp.Reply
+}
+
+func (r *MapReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+func (r *MapReply) WriteTo(w io.Writer) (int64, error) {
+	sw := &streamWriter{w: w}
+	sw.writeString("%" + strconv.Itoa(len(r.Keys)) + CRLF)
+	for i := range r.Keys {
+		sw.writeReply(r.Keys[i])
+		sw.writeReply(r.Values[i])
+	}
+	return sw.n, sw.err
+}
+
+func MakeMapReply(keys []resp.Reply, values []resp.Reply) *MapReply {
+	return &MapReply{Keys: keys, Values: values}
+}
+
+// SetReply 集合回复，格式与数组相同，只是类型符号为 ~
+type SetReply struct {
+	Replies []resp.Reply
+}
+
+func (r *SetReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+func (r *SetReply) WriteTo(w io.Writer) (int64, error) {
+	return writeAggregate(w, '~', r.Replies)
+}
+
+func MakeSetReply(replies []resp.Reply) *SetReply {
+	return &SetReply{Replies: replies}
+}
+
+// MakeBulkSetReply 由成员的字节数组创建集合回复，例如 SMEMBERS 的回复
+func MakeBulkSetReply(members [][]byte) *SetReply {
+	replies := make([]resp.Reply, len(members))
+	for i, member := range members {
+		replies[i] = MakeBulkReply(member)
+	}
+	return &SetReply{Replies: replies}
+}
+
+// PushReply 服务端主动推送的消息，例如 pub/sub 消息，类型符号为 >
+type PushReply struct {
+	Replies []resp.Reply
+}
+
+func (r *PushReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+func (r *PushReply) WriteTo(w io.Writer) (int64, error) {
+	return writeAggregate(w, '>', r.Replies)
+}
+
+func MakePushReply(replies []resp.Reply) *PushReply {
+	return &PushReply{Replies: replies}
+}
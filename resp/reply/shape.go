@@ -0,0 +1,133 @@
+package reply
+
+import "redigo/interface/resp"
+
+// 协议版本，由 HELLO 协商，默认为 RESP2
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// ForProtocol 按连接协商的协议版本调整回复的表示方式
+// 命令统一返回 RESP3 的类型，例如 HGETALL 返回 MapReply，RESP2 的连接会收到对应的 RESP2 表示：
+// 字典展开为键值交替的数组，集合和推送变为数组，浮点数和大整数变为字符串，布尔值变为整数
+// RESP3 的连接收到的空值统一为 `_`，包括数组中的空值
+func ForProtocol(r resp.Reply, protocol int) resp.Reply {
+	if protocol >= RESP3 {
+		converted, _ := toRESP3(r)
+		return converted
+	}
+	return toRESP2(r)
+}
+
+// toRESP3 将 RESP2 的空字符串 $-1 和空数组 *-1 转换为 `_`，不含空值的回复原样返回，
+// 第二个返回值表示回复是否被转换
+func toRESP3(r resp.Reply) (resp.Reply, bool) {
+	switch re := r.(type) {
+	case *NullBulkReply, *NullMultiBulkReply:
+		return MakeNullReply(), true
+	case *MultiBulkReply:
+		for _, arg := range re.Args {
+			if arg == nil {
+				return toRESP3Elements(re), true
+			}
+		}
+	case *MultiRawReply:
+		if replies, changed := toRESP3All(re.Replies); changed {
+			return MakeMultiRawReply(replies), true
+		}
+	case *MapReply:
+		keys, keysChanged := toRESP3All(re.Keys)
+		values, valuesChanged := toRESP3All(re.Values)
+		if keysChanged || valuesChanged {
+			return MakeMapReply(keys, values), true
+		}
+	case *SetReply:
+		if replies, changed := toRESP3All(re.Replies); changed {
+			return MakeSetReply(replies), true
+		}
+	case *PushReply:
+		if replies, changed := toRESP3All(re.Replies); changed {
+			return MakePushReply(replies), true
+		}
+	}
+	return r, false
+}
+
+// toRESP3Elements 将含有空值的字符串数组（例如 MGET 的回复）转换为回复的数组
+func toRESP3Elements(r *MultiBulkReply) resp.Reply {
+	replies := make([]resp.Reply, len(r.Args))
+	for i, arg := range r.Args {
+		if arg == nil {
+			replies[i] = MakeNullReply()
+		} else {
+			replies[i] = MakeBulkReply(arg)
+		}
+	}
+	return MakeMultiRawReply(replies)
+}
+
+// toRESP3All 转换每个元素，没有元素被转换时返回原来的切片
+func toRESP3All(replies []resp.Reply) ([]resp.Reply, bool) {
+	var result []resp.Reply
+	for i, re := range replies {
+		converted, changed := toRESP3(re)
+		if !changed && result == nil {
+			continue
+		}
+		if result == nil {
+			result = make([]resp.Reply, len(replies))
+			copy(result, replies[:i])
+		}
+		result[i] = converted
+	}
+	if result == nil {
+		return replies, false
+	}
+	return result, true
+}
+
+// toRESP2 递归地将 RESP3 类型转换为 RESP2 类型，RESP2 类型原样返回
+func toRESP2(r resp.Reply) resp.Reply {
+	switch re := r.(type) {
+	case *MapReply:
+		replies := make([]resp.Reply, 0, len(re.Keys)*2)
+		for i := range re.Keys {
+			replies = append(replies, toRESP2(re.Keys[i]), toRESP2(re.Values[i]))
+		}
+		return MakeMultiRawReply(replies)
+	case *SetReply:
+		return MakeMultiRawReply(toRESP2All(re.Replies))
+	case *PushReply:
+		return MakeMultiRawReply(toRESP2All(re.Replies))
+	case *MultiRawReply:
+		return MakeMultiRawReply(toRESP2All(re.Replies))
+	case *ElementsReply:
+		// the elements of a map are already the field value pairs of RESP2
+		return re.WithPrefix('*')
+	case *NullReply:
+		return MakeNullBulkReply()
+	case *BooleanReply:
+		if re.Value {
+			return MakeIntReply(1)
+		}
+		return MakeIntReply(0)
+	case *DoubleReply:
+		return MakeBulkReply([]byte(FormatDouble(re.Value)))
+	case *BigNumberReply:
+		return MakeBulkReply([]byte(re.Number))
+	case *VerbatimStringReply:
+		return MakeBulkReply(re.Text)
+	case *BlobErrorReply:
+		return MakeStandardErrorReply(re.Status)
+	}
+	return r
+}
+
+func toRESP2All(replies []resp.Reply) []resp.Reply {
+	result := make([]resp.Reply, len(replies))
+	for i, re := range replies {
+		result[i] = toRESP2(re)
+	}
+	return result
+}
@@ -0,0 +1,170 @@
+package reply
+
+import (
+	"bytes"
+	"io"
+	"redigo/interface/resp"
+	"strconv"
+)
+
+// 聚合类型的回复（MultiBulkReply、MultiRawReply、MapReply、SetReply、PushReply）实现了
+// resp.StreamReply，连接将元素逐个写入缓冲区再分块发送，例如 SMEMBERS、HGETALL、LRANGE
+// 返回千万个元素时，不必先把整个回复拼接成一个字节数组
+
+// toBytes 将分段写出的回复拼接为完整的字节数组
+func toBytes(r io.WriterTo) []byte {
+	var buf bytes.Buffer
+	_, _ = r.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// writeAggregate 写出数组形式的聚合类型，prefix 为类型符号
+func writeAggregate(w io.Writer, prefix byte, replies []resp.Reply) (int64, error) {
+	sw := &streamWriter{w: w}
+	sw.writeString(string(prefix) + strconv.Itoa(len(replies)) + CRLF)
+	for _, re := range replies {
+		sw.writeReply(re)
+	}
+	return sw.n, sw.err
+}
+
+// streamWriter 统计写出的字节数，出错后不再写入，由调用方最后检查 err
+type streamWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (sw *streamWriter) write(b []byte) {
+	if sw.err != nil {
+		return
+	}
+	n, err := sw.w.Write(b)
+	sw.n += int64(n)
+	sw.err = err
+}
+
+func (sw *streamWriter) writeString(s string) {
+	if sw.err != nil {
+		return
+	}
+	n, err := io.WriteString(sw.w, s)
+	sw.n += int64(n)
+	sw.err = err
+}
+
+// writeBulk 写出一个字符串元素，nil 写出为空值
+func (sw *streamWriter) writeBulk(arg []byte) {
+	if arg == nil {
+		sw.writeString(string(nullBUlkReplyBytes) + CRLF)
+		return
+	}
+	sw.writeString("$" + strconv.Itoa(len(arg)) + CRLF)
+	sw.write(arg)
+	sw.writeString(CRLF)
+}
+
+// writeReply 写出一个元素，嵌套的聚合类型同样分段写出
+func (sw *streamWriter) writeReply(r resp.Reply) {
+	if sw.err != nil {
+		return
+	}
+	if sr, ok := r.(resp.StreamReply); ok {
+		n, err := sr.WriteTo(sw.w)
+		sw.n += n
+		sw.err = err
+		return
+	}
+	sw.write(r.ToBytes())
+}
+
+// ElementsReply 字符串元素的聚合回复，元素在写出时才由 produce 逐个产生，直接从数据结构写到连接，
+// 例如大集合的 SMEMBERS、HGETALL、LRANGE 不必先把元素复制到切片中
+// produce 读取的数据由 hold 加锁（例如键的读锁），WriteTo 必须在 Hold 内调用，写出期间数据不会被修改
+type ElementsReply struct {
+	prefix byte // *、~ 或 %，字典的元素为键值交替，个数为元素数的一半
+	hold   func(fn func())
+	// produce 先调用 header 给出元素个数，再为每个元素调用 element，直到它返回 false；
+	// 数据已经不是可以写出的类型时不调用 header，返回错误回复代替
+	produce func(header func(n int), element func(elem []byte) bool) ErrorReply
+}
+
+// MakeElementsReply 创建在 hold 内由 produce 产生元素的聚合回复
+func MakeElementsReply(prefix byte, hold func(fn func()), produce func(header func(n int), element func(elem []byte) bool) ErrorReply) *ElementsReply {
+	return &ElementsReply{prefix: prefix, hold: hold, produce: produce}
+}
+
+// Hold 在持有数据的锁时调用 fn
+func (r *ElementsReply) Hold(fn func()) {
+	r.hold(fn)
+}
+
+func (r *ElementsReply) ToBytes() []byte {
+	var b []byte
+	r.Hold(func() {
+		b = toBytes(r)
+	})
+	return b
+}
+
+// WriteTo 逐个写出产生的元素，必须在 Hold 内调用
+func (r *ElementsReply) WriteTo(w io.Writer) (int64, error) {
+	sw := &streamWriter{w: w}
+	written := false
+	errReply := r.produce(func(n int) {
+		written = true
+		if r.prefix == '%' {
+			n /= 2
+		}
+		sw.writeString(string(r.prefix) + strconv.Itoa(n) + CRLF)
+	}, func(elem []byte) bool {
+		sw.writeBulk(elem)
+		return sw.err == nil
+	})
+	if !written && errReply != nil {
+		sw.write(errReply.ToBytes())
+	}
+	return sw.n, sw.err
+}
+
+// WithPrefix 返回以 prefix 为类型符号的同一回复，例如把数组作为集合写出
+func (r *ElementsReply) WithPrefix(prefix byte) *ElementsReply {
+	return &ElementsReply{prefix: prefix, hold: r.hold, produce: r.produce}
+}
+
+// Resolve 把 ElementsReply 立即转换为元素复制到内存中的回复，供需要读取回复内容的调用方使用，
+// 例如脚本和集群的聚合命令；其他回复原样返回
+func Resolve(r resp.Reply) resp.Reply {
+	er, ok := r.(*ElementsReply)
+	if !ok {
+		return r
+	}
+	var elements [][]byte
+	var errReply ErrorReply
+	written := false
+	er.Hold(func() {
+		errReply = er.produce(func(n int) {
+			written = true
+			elements = make([][]byte, 0, n)
+		}, func(elem []byte) bool {
+			elements = append(elements, bytes.Clone(elem))
+			return true
+		})
+	})
+	if !written && errReply != nil {
+		return errReply
+	}
+	switch er.prefix {
+	case '~':
+		return MakeBulkSetReply(elements)
+	case '%':
+		keys := make([]resp.Reply, 0, len(elements)/2)
+		values := make([]resp.Reply, 0, len(elements)/2)
+		for i := 0; i+1 < len(elements); i += 2 {
+			keys = append(keys, MakeBulkReply(elements[i]))
+			values = append(values, MakeBulkReply(elements[i+1]))
+		}
+		return MakeMapReply(keys, values)
+	}
+	return MakeMultiBulkReply(elements)
+}
@@ -0,0 +1,157 @@
+package reply
+
+import (
+	"bytes"
+	"errors"
+	"redigo/interface/resp"
+	"strconv"
+	"testing"
+)
+
+// recordingWriter keeps what is written, and fails once limit bytes are written if limit is set
+type recordingWriter struct {
+	buf    bytes.Buffer
+	writes int
+	limit  int
+}
+
+var errWriterFull = errors.New("writer full")
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.writes++
+	if w.limit > 0 && w.buf.Len()+len(b) > w.limit {
+		n := w.limit - w.buf.Len()
+		w.buf.Write(b[:n])
+		return n, errWriterFull
+	}
+	return w.buf.Write(b)
+}
+
+// TestAggregateWriteTo tests that the aggregates written out element by element match their
+// encoding, nested ones included, and that the writes stop at the first error
+func TestAggregateWriteTo(t *testing.T) {
+	cases := []struct {
+		reply    resp.StreamReply
+		expected string
+	}{
+		{MakeMultiBulkReply([][]byte{[]byte("foo"), nil, {}}), "*3\r\n$3\r\nfoo\r\n$-1\r\n$0\r\n\r\n"},
+		{MakeMultiRawReply([]resp.Reply{MakeIntReply(1), MakeMultiBulkReply([][]byte{[]byte("a")})}), "*2\r\n:1\r\n*1\r\n$1\r\na\r\n"},
+		{MakeMapReply([]resp.Reply{MakeBulkReply([]byte("f"))}, []resp.Reply{MakeIntReply(2)}), "%1\r\n$1\r\nf\r\n:2\r\n"},
+		{MakeBulkSetReply([][]byte{[]byte("m")}), "~1\r\n$1\r\nm\r\n"},
+		{MakePushReply([]resp.Reply{MakeBulkReply([]byte("message"))}), ">1\r\n$7\r\nmessage\r\n"},
+	}
+	for _, c := range cases {
+		w := &recordingWriter{}
+		n, err := c.reply.WriteTo(w)
+		if err != nil || w.buf.String() != c.expected || n != int64(len(c.expected)) || string(c.reply.ToBytes()) != c.expected {
+			t.Errorf("Expected %q, got %q (%d bytes, %v)", c.expected, w.buf.String(), n, err)
+		}
+	}
+
+	w := &recordingWriter{limit: 10}
+	n, err := MakeMultiBulkReply([][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}).WriteTo(w)
+	if err != errWriterFull || n != 10 || w.writes != 3 {
+		t.Errorf("Expected the writes to stop at the error after 10 bytes, got %d bytes in %d writes (%v)", n, w.writes, err)
+	}
+}
+
+// makeElements returns an ElementsReply of the elements, held records whether produce runs in Hold
+func makeElements(t *testing.T, prefix byte, elements [][]byte, held *bool) *ElementsReply {
+	return MakeElementsReply(prefix, func(fn func()) {
+		*held = true
+		defer func() {
+			*held = false
+		}()
+		fn()
+	}, func(header func(n int), element func(elem []byte) bool) ErrorReply {
+		if !*held {
+			t.Error("Expected the elements to be produced in Hold")
+		}
+		header(len(elements))
+		for _, elem := range elements {
+			if !element(elem) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// TestElementsReply tests that the elements are written out as they are produced, with the type of
+// the aggregate, and their copy by Resolve
+func TestElementsReply(t *testing.T) {
+	var elements [][]byte
+	for i := 0; i < 100; i++ {
+		elements = append(elements, []byte(strconv.Itoa(i)))
+	}
+	held := false
+	array := makeElements(t, '*', elements, &held)
+	expected := string(MakeMultiBulkReply(elements).ToBytes())
+	if got := string(array.ToBytes()); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	// each element is written before the next one is produced
+	w := &recordingWriter{}
+	produced := 0
+	incremental := MakeElementsReply('*', func(fn func()) { fn() }, func(header func(n int), element func(elem []byte) bool) ErrorReply {
+		header(len(elements))
+		for i, elem := range elements {
+			if i > 0 && !bytes.HasSuffix(w.buf.Bytes(), MakeBulkReply(elements[i-1]).ToBytes()) {
+				t.Fatalf("Expected element %d to be written before element %d is produced", i-1, i)
+			}
+			produced++
+			element(elem)
+		}
+		return nil
+	})
+	incremental.Hold(func() {
+		if _, err := incremental.WriteTo(w); err != nil {
+			t.Error(err)
+		}
+	})
+	if produced != len(elements) || w.buf.String() != expected {
+		t.Errorf("Expected the %d elements to be written, got %q", len(elements), w.buf.String())
+	}
+
+	// the production stops at the first error of the writer
+	w = &recordingWriter{limit: 20}
+	array.Hold(func() {
+		if _, err := array.WriteTo(w); err != errWriterFull {
+			t.Errorf("Expected the error of the writer, got %v", err)
+		}
+	})
+
+	pairs := [][]byte{[]byte("f1"), []byte("v1"), []byte("f2"), []byte("v2")}
+	m := makeElements(t, '%', pairs, &held)
+	if got := string(m.ToBytes()); got != "%2\r\n$2\r\nf1\r\n$2\r\nv1\r\n$2\r\nf2\r\n$2\r\nv2\r\n" {
+		t.Errorf("Unexpected map %q", got)
+	}
+	if got := string(ForProtocol(m, RESP2).ToBytes()); got != string(MakeMultiBulkReply(pairs).ToBytes()) {
+		t.Errorf("Expected the map to be flattened for RESP2, got %q", got)
+	}
+	if got := string(array.WithPrefix('~').ToBytes()); got != "~100"+expected[4:] {
+		t.Errorf("Expected a set, got %q", got[:10])
+	}
+
+	for _, r := range []*ElementsReply{array, array.WithPrefix('~'), m} {
+		resolved := Resolve(r)
+		if _, ok := resolved.(*ElementsReply); ok || string(resolved.ToBytes()) != string(r.ToBytes()) {
+			t.Errorf("Expected %q resolved in memory, got %q", r.ToBytes()[:4], resolved.ToBytes())
+		}
+	}
+	if other := MakeIntReply(1); Resolve(other) != other {
+		t.Error("Expected the other replies to be kept")
+	}
+
+	// a value of another type by the time the reply is written
+	wrongType := MakeElementsReply('*', func(fn func()) { fn() }, func(func(n int), func(elem []byte) bool) ErrorReply {
+		return MakeWrongTypeErrReply()
+	})
+	if got := string(wrongType.ToBytes()); got != string(MakeWrongTypeErrReply().ToBytes()) {
+		t.Errorf("Expected WRONGTYPE, got %q", got)
+	}
+	if _, ok := Resolve(wrongType).(*WrongTypeErrReply); !ok {
+		t.Errorf("Expected Resolve to return the error, got %q", Resolve(wrongType).ToBytes())
+	}
+}
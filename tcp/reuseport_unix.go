@@ -0,0 +1,11 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package tcp
+
+import "syscall"
+
+// setReusePort sets SO_REUSEPORT on the socket, so that several processes can listen on the same
+// address and the kernel spreads the connections among them
+func setReusePort(fd uintptr) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+}
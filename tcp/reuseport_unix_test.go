@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package tcp
+
+import "testing"
+
+// TestListenReusePort tests that a second listener binds the address with ReusePort only
+func TestListenReusePort(t *testing.T) {
+	first, err := listen(&Config{Address: "127.0.0.1:0", ReusePort: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	addr := first.Addr().String()
+
+	if other, err := listen(&Config{Address: addr}); err == nil {
+		other.Close()
+		t.Error("Expected the address to be in use without ReusePort")
+	}
+	second, err := listen(&Config{Address: addr, ReusePort: true})
+	if err != nil {
+		t.Fatalf("Expected a second listener with ReusePort, got %v", err)
+	}
+	second.Close()
+}
@@ -12,13 +12,41 @@ import (
 	"os/signal"
 	"redigo/interface/tcp"
 	"redigo/lib/logger"
+	"runtime"
 	"sync"
 	"syscall"
+	"time"
 )
 
 // Config stores tcp server properties
 type Config struct {
 	Address string
+	// ReusePort listens with SO_REUSEPORT, so that a new process can bind the address alongside
+	// this one before it shuts down, the connections are then spread among the processes
+	ReusePort bool
+	// DrainTimeout is how long the server waits for the clients to disconnect after it stopped
+	// accepting connections, before closing them, 0 closes them immediately
+	DrainTimeout time.Duration
+}
+
+// listen binds the address of the config
+func listen(cfg *Config) (net.Listener, error) {
+	if !cfg.ReusePort {
+		return net.Listen("tcp", cfg.Address)
+	}
+	lc := net.ListenConfig{
+		Control: func(network, address string, conn syscall.RawConn) error {
+			var sockErr error
+			err := conn.Control(func(fd uintptr) {
+				sockErr = setReusePort(fd)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	return lc.Listen(context.Background(), "tcp", cfg.Address)
 }
 
 // ListenAndServeWithSignal 绑定端口，启动服务，直到收到退出信号
@@ -33,23 +61,77 @@ func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 			closeChan <- struct{}{}
 		}
 	}()
-	listener, err := net.Listen("tcp", cfg.Address)
+	go handleOperationalSignals(handler)
+	listener, err := listen(cfg)
 	if err != nil {
 		return err
 	}
 	logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
-	ListenAndServe(listener, handler, closeChan)
+	serve(listener, handler, closeChan, cfg.DrainTimeout)
 	return nil
 }
 
+// handleOperationalSignals logs a status report on SIGUSR1 and reopens the log file on SIGUSR2
+func handleOperationalSignals(handler tcp.Handler) {
+	if statusSignal == nil {
+		return
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, statusSignal, reopenLogSignal)
+	for sig := range sigCh {
+		switch sig {
+		case statusSignal:
+			reportStatus(handler)
+		case reopenLogSignal:
+			if err := logger.Reopen(); err != nil {
+				logger.Error("reopen log file: " + err.Error())
+				continue
+			}
+			logger.Info("log file reopened")
+		}
+	}
+}
+
+// reportStatus logs the state of the handler and the memory of the process
+func reportStatus(handler tcp.Handler) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	status := fmt.Sprintf("status: heap_alloc=%d heap_sys=%d sys=%d num_gc=%d goroutines=%d",
+		stats.HeapAlloc, stats.HeapSys, stats.Sys, stats.NumGC, runtime.NumGoroutine())
+	if reporter, ok := handler.(tcp.StatusReporter); ok {
+		status += " " + reporter.Status()
+	}
+	logger.Info(status)
+}
+
 // ListenAndServe binds port and handle requests, blocking until close
 func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan struct{}) {
+	serve(listener, handler, closeChan, 0)
+}
+
+// serve handles requests until close, then waits up to drainTimeout for the clients to disconnect
+func serve(listener net.Listener, handler tcp.Handler, closeChan <-chan struct{}, drainTimeout time.Duration) {
+	// closed when all connections are done after the listener was closed
+	drained := make(chan struct{})
+	// stopping is closed on close, shutdown is closed once the connections were closed
+	stopping := make(chan struct{})
+	shutdown := make(chan struct{})
 	// listen signal
 	go func() {
 		<-closeChan
+		close(stopping)
 		logger.Info("shutting down...")
 		_ = listener.Close() // listener.Accept() will return err immediately
-		_ = handler.Close()  // close connections
+		if drainTimeout > 0 {
+			logger.Info(fmt.Sprintf("draining connections for up to %s", drainTimeout))
+			select {
+			case <-drained:
+			case <-time.After(drainTimeout):
+				logger.Info("drain timeout, closing the remaining connections")
+			}
+		}
+		_ = handler.Close() // close connections
+		close(shutdown)
 	}()
 
 	// listen port
@@ -76,4 +158,10 @@ func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan
 		}()
 	}
 	waitDone.Wait()
+	close(drained)
+	select {
+	case <-stopping:
+		<-shutdown
+	default:
+	}
 }
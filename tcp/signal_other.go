@@ -0,0 +1,11 @@
+//go:build !unix
+
+package tcp
+
+import "os"
+
+// SIGUSR1 and SIGUSR2 don't exist on this platform
+var (
+	statusSignal    os.Signal
+	reopenLogSignal os.Signal
+)
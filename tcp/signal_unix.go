@@ -0,0 +1,14 @@
+//go:build unix
+
+package tcp
+
+import (
+	"os"
+	"syscall"
+)
+
+// statusSignal asks the server to log a status report
+var statusSignal os.Signal = syscall.SIGUSR1
+
+// reopenLogSignal asks the server to reopen its log file after an external rotation
+var reopenLogSignal os.Signal = syscall.SIGUSR2
@@ -0,0 +1,68 @@
+//go:build unix
+
+package tcp
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"redigo/lib/logger"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// statusHandler is a handler reporting a fixed status
+type statusHandler struct{}
+
+func (statusHandler) Handle(ctx context.Context, conn net.Conn) {}
+
+func (statusHandler) Close() error { return nil }
+
+func (statusHandler) Status() string { return "clients=3" }
+
+// waitForLog sends sig to the process until the log file contains expected
+func waitForLog(t *testing.T, name string, sig syscall.Signal, expected string) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		// the handler may not be notified of the signal yet, it is sent again until logged
+		if err := syscall.Kill(os.Getpid(), sig); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		data, _ := os.ReadFile(name)
+		if strings.Contains(string(data), expected) {
+			return string(data)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %q in the log after %v, got %q", expected, sig, data)
+		}
+	}
+}
+
+// TestOperationalSignals tests that SIGUSR1 logs the status of the handler and that SIGUSR2
+// reopens the log file moved by a rotation
+func TestOperationalSignals(t *testing.T) {
+	// while the test is notified, the signals do not end the process before the handler is
+	ignored := make(chan os.Signal, 16)
+	signal.Notify(ignored, statusSignal, reopenLogSignal)
+	defer signal.Stop(ignored)
+	dir := t.TempDir()
+	logger.Setup(&logger.Settings{Path: dir, Name: "redigo", Ext: "log", TimeFormat: "test"})
+	name := filepath.Join(dir, "redigo-test.log")
+	go handleOperationalSignals(statusHandler{})
+
+	status := waitForLog(t, name, syscall.SIGUSR1, " clients=3\n")
+	if !strings.Contains(status, "status: heap_alloc=") || !strings.Contains(status, " goroutines=") {
+		t.Errorf("Expected the memory and the goroutines in the status report, got %q", status)
+	}
+
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	waitForLog(t, name, syscall.SIGUSR2, "log file reopened")
+}
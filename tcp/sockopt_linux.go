@@ -0,0 +1,6 @@
+//go:build 386 || amd64 || arm
+
+package tcp
+
+// soReusePort is SO_REUSEPORT, which package syscall doesn't define for these architectures
+const soReusePort = 0xf
@@ -0,0 +1,293 @@
+// Package chaos runs a cluster in-process and injects faults between its nodes, to check that the
+// cluster keeps its guarantees when nodes die and the network misbehaves
+//
+// Every node is reached by its peers through a proxy, the address of the proxy is the address of
+// the node in the consistent hash ring. The proxies inject the faults: latency on the relays,
+// dropped connections, and a dead backend while the node is killed. Tests talk to the nodes
+// directly, so that only the traffic between nodes is affected.
+//
+// The nodes run with appendonly, a killed node is restarted from its AOF file.
+package chaos
+
+import (
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"redigo/config"
+	"redigo/resp/handler"
+	"redigo/tcp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// configMu serializes the creation of nodes, since the cluster reads the global config
+var configMu sync.Mutex
+
+// Cluster is a set of nodes running in the current process
+type Cluster struct {
+	dir   string
+	nodes []*Node
+}
+
+// Node is a node of a Cluster
+type Node struct {
+	index int
+	id    string // address of the proxy, the identity of the node in the ring
+	proxy *proxy
+
+	mu      sync.Mutex
+	addr    string // address of the server, empty while the node is killed
+	closeCh chan struct{}
+	done    chan struct{}
+}
+
+// Start starts a cluster of n nodes keeping their AOF files in dir
+func Start(n int, dir string) (*Cluster, error) {
+	c := &Cluster{dir: dir}
+	for i := 0; i < n; i++ {
+		p, err := listenProxy()
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.nodes = append(c.nodes, &Node{index: i, id: p.addr(), proxy: p})
+	}
+	for _, node := range c.nodes {
+		if err := c.startNode(node); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Nodes returns the nodes of the cluster
+func (c *Cluster) Nodes() []*Node {
+	return c.nodes
+}
+
+// startNode starts the server of the node, loading its AOF file
+func (c *Cluster) startNode(node *Node) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	peers := make([]string, 0, len(c.nodes)-1)
+	for _, other := range c.nodes {
+		if other != node {
+			peers = append(peers, other.id)
+		}
+	}
+
+	configMu.Lock()
+	config.Properties.Self = node.id
+	config.Properties.Peers = peers
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = filepath.Join(c.dir, "node"+strconv.Itoa(node.index)+".aof")
+	h := handler.MakeHandler()
+	configMu.Unlock()
+
+	node.mu.Lock()
+	node.addr = listener.Addr().String()
+	node.closeCh = make(chan struct{})
+	node.done = make(chan struct{})
+	closeCh, done := node.closeCh, node.done
+	node.mu.Unlock()
+	node.proxy.setBackend(listener.Addr().String())
+	go func() {
+		tcp.ListenAndServe(listener, h, closeCh)
+		close(done)
+	}()
+	return nil
+}
+
+// Kill stops the node, closing its connections and the connections of its peers to it
+// Commands relayed to the node fail until it is restarted
+func (c *Cluster) Kill(node *Node) {
+	node.mu.Lock()
+	closeCh, done := node.closeCh, node.done
+	node.addr = ""
+	node.closeCh = nil
+	node.mu.Unlock()
+	if closeCh == nil {
+		return
+	}
+	node.proxy.setBackend("")
+	node.proxy.dropConnections()
+	close(closeCh)
+	<-done
+}
+
+// Restart starts a killed node again from its AOF file
+func (c *Cluster) Restart(node *Node) error {
+	node.mu.Lock()
+	running := node.closeCh != nil
+	node.mu.Unlock()
+	if running {
+		return errors.New("node " + node.id + " is running")
+	}
+	return c.startNode(node)
+}
+
+// Close kills all nodes and stops the proxies
+func (c *Cluster) Close() {
+	for _, node := range c.nodes {
+		c.Kill(node)
+		node.proxy.close()
+	}
+}
+
+// Addr returns the address clients use to reach the node, empty while it is killed
+func (n *Node) Addr() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.addr
+}
+
+// ID returns the address of the node in the ring
+func (n *Node) ID() string {
+	return n.id
+}
+
+// SetLatency delays the data sent by peers to the node by latency, 0 removes the delay
+func (n *Node) SetLatency(latency time.Duration) {
+	n.proxy.setLatency(latency)
+}
+
+// DropConnections closes the connections of the peers to the node, they have to reconnect
+func (n *Node) DropConnections() {
+	n.proxy.dropConnections()
+}
+
+// Heal removes the faults injected on the node
+func (n *Node) Heal() {
+	n.proxy.setLatency(0)
+}
+
+// proxy forwards the connections of the peers of a node to the node
+type proxy struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	backend string // address of the node, empty while it is killed
+	latency time.Duration
+	conns   map[net.Conn]struct{}
+}
+
+func listenProxy() (*proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &proxy{listener: listener, conns: make(map[net.Conn]struct{})}
+	go p.serve()
+	return p, nil
+}
+
+func (p *proxy) addr() string {
+	return p.listener.Addr().String()
+}
+
+func (p *proxy) setBackend(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.backend = addr
+}
+
+func (p *proxy) setLatency(latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.latency = latency
+}
+
+func (p *proxy) getLatency() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.latency
+}
+
+// track adds a connection to close on dropConnections, it returns false if the connection must
+// be refused because the node is killed
+func (p *proxy) track(conn net.Conn) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.backend == "" {
+		return "", false
+	}
+	p.conns[conn] = struct{}{}
+	return p.backend, true
+}
+
+func (p *proxy) untrack(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, conn)
+}
+
+func (p *proxy) dropConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for conn := range p.conns {
+		_ = conn.Close()
+		delete(p.conns, conn)
+	}
+}
+
+func (p *proxy) close() {
+	_ = p.listener.Close()
+	p.dropConnections()
+}
+
+func (p *proxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.forward(conn)
+	}
+}
+
+// forward copies the data between a peer and the node, delaying the data of the peer
+func (p *proxy) forward(conn net.Conn) {
+	backend, ok := p.track(conn)
+	if !ok {
+		_ = conn.Close()
+		return
+	}
+	defer p.untrack(conn)
+	server, err := net.Dial("tcp", backend)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	if _, ok := p.track(server); !ok {
+		_ = conn.Close()
+		_ = server.Close()
+		return
+	}
+	defer p.untrack(server)
+
+	go func() {
+		_, _ = io.Copy(conn, server)
+		_ = conn.Close()
+	}()
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if latency := p.getLatency(); latency > 0 {
+				time.Sleep(latency)
+			}
+			if _, err := server.Write(buf[:n]); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	_ = server.Close()
+}
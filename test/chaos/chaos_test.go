@@ -0,0 +1,629 @@
+package chaos
+
+import (
+	"bufio"
+	"net"
+	"redigo/config"
+	"redigo/interface/resp"
+	"redigo/lib/slot"
+	"redigo/lib/utils"
+	"redigo/resp/client"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// convergeTimeout is how long the cluster may take to serve every key again after the faults
+const convergeTimeout = 15 * time.Second
+
+func startCluster(t *testing.T, n int) *Cluster {
+	t.Helper()
+	c, err := Start(n, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func connect(t *testing.T, node *Node) *client.Client {
+	t.Helper()
+	cli, err := client.MakeClient(node.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.Start()
+	t.Cleanup(cli.Close)
+	return cli
+}
+
+// writer writes unique keys through a set of clients and remembers the acknowledged ones
+type writer struct {
+	prefix string
+	mu     sync.Mutex
+	next   int
+	acked  map[string]string
+}
+
+func newWriter(prefix string) *writer {
+	return &writer{prefix: prefix, acked: make(map[string]string)}
+}
+
+// write sets n new keys concurrently, spread over the clients, and returns the number of
+// acknowledged writes
+func (w *writer) write(clients []*client.Client, n int) int {
+	var wg sync.WaitGroup
+	acked := 0
+	for i := 0; i < n; i++ {
+		w.mu.Lock()
+		key := w.prefix + ":" + strconv.Itoa(w.next)
+		w.next++
+		w.mu.Unlock()
+		value := "v" + key
+		cli := clients[i%len(clients)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := cli.Send(utils.ToCmdLine("SET", key, value))
+			if isOK(result) {
+				w.mu.Lock()
+				w.acked[key] = value
+				acked++
+				w.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return acked
+}
+
+// checkAcked fails if a node does not return the acknowledged value of a key
+func (w *writer) checkAcked(t *testing.T, clients []*client.Client) {
+	t.Helper()
+	for key, value := range w.acked {
+		for i, cli := range clients {
+			result := cli.Send(utils.ToCmdLine("GET", key))
+			if got := bulkString(result); got != value {
+				t.Errorf("node %d: lost acknowledged write %s: got %q, want %q", i, key, got, value)
+			}
+		}
+	}
+}
+
+// isOK tells whether the reply is +OK, the client parses it as a status reply
+func isOK(r resp.Reply) bool {
+	return string(r.ToBytes()) == string(reply.MakeOKReply().ToBytes())
+}
+
+func bulkString(r resp.Reply) string {
+	if bulk, ok := r.(*reply.BulkReply); ok && bulk.Arg != nil {
+		return string(bulk.Arg)
+	}
+	return string(r.ToBytes())
+}
+
+// waitConverged waits until every node serves writes of keys owned by every node
+func waitConverged(t *testing.T, w *writer, clients []*client.Client) {
+	t.Helper()
+	deadline := time.Now().Add(convergeTimeout)
+	for {
+		const probes = 30
+		if w.write(clients, probes) == probes {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cluster did not converge within %s", convergeTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestNodeKill(t *testing.T) {
+	c := startCluster(t, 3)
+	nodes := c.Nodes()
+	w := newWriter("kill")
+	clients := []*client.Client{connect(t, nodes[0]), connect(t, nodes[1]), connect(t, nodes[2])}
+	if acked := w.write(clients, 60); acked != 60 {
+		t.Fatalf("healthy cluster acknowledged %d writes of 60", acked)
+	}
+
+	c.Kill(nodes[2])
+	survivors := clients[:2]
+	// the ring depends on the random ports, the killed node may own any share of the keys
+	if acked := w.write(survivors, 60); acked == 0 {
+		t.Errorf("live nodes acknowledged no write with a node killed")
+	}
+
+	if err := c.Restart(nodes[2]); err != nil {
+		t.Fatal(err)
+	}
+	clients[2] = connect(t, nodes[2])
+	waitConverged(t, w, clients)
+	w.checkAcked(t, clients)
+}
+
+func TestRelayLatency(t *testing.T) {
+	c := startCluster(t, 3)
+	nodes := c.Nodes()
+	w := newWriter("latency")
+	clients := []*client.Client{connect(t, nodes[0]), connect(t, nodes[1]), connect(t, nodes[2])}
+
+	for _, node := range nodes {
+		node.SetLatency(50 * time.Millisecond)
+	}
+	if acked := w.write(clients, 60); acked != 60 {
+		t.Errorf("acknowledged %d writes of 60 with slow relays", acked)
+	}
+	for _, node := range nodes {
+		node.Heal()
+	}
+	waitConverged(t, w, clients)
+	w.checkAcked(t, clients)
+}
+
+func TestDroppedPeerConnections(t *testing.T) {
+	c := startCluster(t, 3)
+	nodes := c.Nodes()
+	w := newWriter("drop")
+	clients := []*client.Client{connect(t, nodes[0]), connect(t, nodes[1]), connect(t, nodes[2])}
+	w.write(clients, 30)
+
+	stop := make(chan struct{})
+	dropped := make(chan struct{})
+	go func() {
+		defer close(dropped)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+				nodes[1].DropConnections()
+			}
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		w.write(clients, 30)
+	}
+	close(stop)
+	<-dropped
+
+	waitConverged(t, w, clients)
+	w.checkAcked(t, clients)
+}
+
+// peerInfo returns the fields of the INFO cluster line of the peer
+func peerInfo(t *testing.T, cli *client.Client, peer string) map[string]string {
+	t.Helper()
+	info := bulkString(cli.Send(utils.ToCmdLine("INFO", "cluster")))
+	for _, line := range strings.Split(info, "\r\n") {
+		_, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.HasPrefix(value, "addr="+peer+",") {
+			continue
+		}
+		fields := make(map[string]string)
+		for _, field := range strings.Split(value, ",") {
+			name, v, _ := strings.Cut(field, "=")
+			fields[name] = v
+		}
+		return fields
+	}
+	t.Fatalf("no INFO cluster line of peer %s in %q", peer, info)
+	return nil
+}
+
+func TestRelayMetricsFindSlowPeer(t *testing.T) {
+	c := startCluster(t, 3)
+	nodes := c.Nodes()
+	w := newWriter("metrics")
+	clients := []*client.Client{connect(t, nodes[0])}
+	w.write(clients, 100)
+
+	// the ring depends on the random ports, slow down the peer receiving most relays
+	slow := nodes[1]
+	if relays(peerInfo(t, clients[0], nodes[2].ID())) > relays(peerInfo(t, clients[0], slow.ID())) {
+		slow = nodes[2]
+	}
+	before := peerInfo(t, clients[0], slow.ID())
+	if relays(before) == 0 {
+		t.Skip("the first node owns every key")
+	}
+	slow.SetLatency(50 * time.Millisecond)
+	w.write(clients, 30)
+	slow.Heal()
+
+	after := peerInfo(t, clients[0], slow.ID())
+	// the histogram holds the relays before the latency as well, they are less than 99% of them
+	if p99 := atoi(after["p99_latency_us"]); p99 != -1 && p99 < 50000 {
+		t.Errorf("expected the p99 latency of the slow peer to be over 50ms, got %s", after["p99_latency_us"])
+	}
+	if after["errors"] != "0" || after["timeouts"] != "0" {
+		t.Errorf("expected only successful relays, got %d errors and %d timeouts", atoi(after["errors"]), atoi(after["timeouts"]))
+	}
+	w.checkAcked(t, clients)
+}
+
+func relays(fields map[string]string) int {
+	return atoi(fields["relays"])
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// TestCrossNodeRename tests that RENAME and RENAMENX move the values between the nodes of the
+// keys, with their TTL
+func TestCrossNodeRename(t *testing.T) {
+	c := startCluster(t, 3)
+	nodes := c.Nodes()
+	cli, other := connect(t, nodes[0]), connect(t, nodes[1])
+	// most of the pairs are owned by different nodes
+	for i := 0; i < 20; i++ {
+		src, dest, taken := "src:"+strconv.Itoa(i), "dest:"+strconv.Itoa(i), "taken:"+strconv.Itoa(i)
+		cli.Send(utils.ToCmdLine("RPUSH", src, "a", "b"))
+		cli.Send(utils.ToCmdLine("EXPIRE", src, "100"))
+		cli.Send(utils.ToCmdLine("SET", dest, "old"))
+		cli.Send(utils.ToCmdLine("SET", taken, "kept"))
+
+		if result := cli.Send(utils.ToCmdLine("RENAME", src, dest)); !isOK(result) {
+			t.Fatalf("RENAME %s %s: %q", src, dest, result.ToBytes())
+		}
+		if got := string(other.Send(utils.ToCmdLine("LRANGE", dest, "0", "-1")).ToBytes()); got != "*2\r\n$1\r\na\r\n$1\r\nb\r\n" {
+			t.Errorf("expected %s to hold the list, got %q", dest, got)
+		}
+		if ttl := other.Send(utils.ToCmdLine("TTL", dest)).(*reply.IntReply).Code; ttl < 98 || ttl > 100 {
+			t.Errorf("expected the TTL to move with %s, got %d", dest, ttl)
+		}
+		if exists := other.Send(utils.ToCmdLine("EXISTS", src)).(*reply.IntReply).Code; exists != 0 {
+			t.Errorf("expected %s to be removed", src)
+		}
+
+		if result := cli.Send(utils.ToCmdLine("RENAMENX", dest, taken)); string(result.ToBytes()) != ":0\r\n" {
+			t.Errorf("expected RENAMENX onto an existing key to reply 0, got %q", result.ToBytes())
+		}
+		if got := bulkString(other.Send(utils.ToCmdLine("GET", taken))); got != "kept" {
+			t.Errorf("expected RENAMENX to keep %s, got %q", taken, got)
+		}
+		if result := cli.Send(utils.ToCmdLine("RENAMENX", dest, src)); string(result.ToBytes()) != ":1\r\n" {
+			t.Errorf("expected RENAMENX back to %s to reply 1, got %q", src, result.ToBytes())
+		}
+		if result := cli.Send(utils.ToCmdLine("RENAME", dest, src)); !strings.Contains(string(result.ToBytes()), "no such key") {
+			t.Errorf("expected RENAME of a missing key to fail, got %q", result.ToBytes())
+		}
+	}
+}
+
+// TestCrossNodeMSet tests that MSET, MGET and MSETNX on keys of several nodes write every key to
+// its node and read the values back in the order of the keys
+func TestCrossNodeMSet(t *testing.T) {
+	c := startCluster(t, 3)
+	nodes := c.Nodes()
+	cli, other := connect(t, nodes[0]), connect(t, nodes[1])
+	var pairs, keys []string
+	for i := 0; i < 20; i++ {
+		key := "mset:" + strconv.Itoa(i)
+		pairs = append(pairs, key, "v"+strconv.Itoa(i))
+		keys = append(keys, key)
+	}
+	if result := cli.Send(utils.ToCmdLine(append([]string{"MSET"}, pairs...)...)); !isOK(result) {
+		t.Fatalf("MSET: %q", result.ToBytes())
+	}
+	for i, key := range keys {
+		if got := bulkString(other.Send(utils.ToCmdLine("GET", key))); got != "v"+strconv.Itoa(i) {
+			t.Errorf("expected %s to be set, got %q", key, got)
+		}
+	}
+
+	result := other.Send(utils.ToCmdLine(append([]string{"MGET", "missing"}, keys...)...))
+	values, ok := result.(*reply.MultiBulkReply)
+	if !ok || len(values.Args) != len(keys)+1 {
+		t.Fatalf("MGET: %q", result.ToBytes())
+	}
+	if values.Args[0] != nil {
+		t.Errorf("expected nil for the missing key, got %q", values.Args[0])
+	}
+	for i := range keys {
+		if got := string(values.Args[i+1]); got != "v"+strconv.Itoa(i) {
+			t.Errorf("expected MGET to reply the value of %s, got %q", keys[i], got)
+		}
+	}
+
+	if result := cli.Send(utils.ToCmdLine("MSETNX", "fresh:a", "1", "fresh:b", "2", keys[5], "new")); string(result.ToBytes()) != ":0\r\n" {
+		t.Errorf("expected MSETNX with an existing key to reply 0, got %q", result.ToBytes())
+	}
+	if got := string(other.Send(utils.ToCmdLine("MGET", "fresh:a", "fresh:b")).ToBytes()); got != "*2\r\n$-1\r\n$-1\r\n" {
+		t.Errorf("expected MSETNX to set nothing, got %q", got)
+	}
+	if result := cli.Send(utils.ToCmdLine("MSETNX", "fresh:a", "1", "fresh:b", "2", "fresh:c", "3")); string(result.ToBytes()) != ":1\r\n" {
+		t.Errorf("expected MSETNX with new keys to reply 1, got %q", result.ToBytes())
+	}
+	if got := string(other.Send(utils.ToCmdLine("MGET", "fresh:a", "fresh:b", "fresh:c")).ToBytes()); got != "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n" {
+		t.Errorf("expected MSETNX to set the 3 keys, got %q", got)
+	}
+}
+
+func TestClusterEval(t *testing.T) {
+	c := startCluster(t, 3)
+	nodes := c.Nodes()
+	cli, other := connect(t, nodes[0]), connect(t, nodes[1])
+	result := cli.Send(utils.ToCmdLine("SCRIPT", "LOAD", "return redis.call('INCRBY', KEYS[1], ARGV[1])"))
+	sha := bulkString(result)
+	if len(sha) != 40 {
+		t.Fatalf("SCRIPT LOAD: %q", result.ToBytes())
+	}
+	// the scripts run on the node of their keys, wherever the client is connected
+	for i := 0; i < 20; i++ {
+		key := "eval:" + strconv.Itoa(i)
+		if result := cli.Send(utils.ToCmdLine("EVAL", "return redis.call('SET', KEYS[1], ARGV[1])", "1", key, "10")); !isOK(result) {
+			t.Fatalf("EVAL %s: %q", key, result.ToBytes())
+		}
+		if result := other.Send(utils.ToCmdLine("EVALSHA", sha, "1", key, "5")); string(result.ToBytes()) != ":15\r\n" {
+			t.Errorf("expected EVALSHA on %s to reply 15, got %q", key, result.ToBytes())
+		}
+		if got := bulkString(other.Send(utils.ToCmdLine("GET", key))); got != "15" {
+			t.Errorf("expected %s to be 15, got %q", key, got)
+		}
+	}
+}
+
+// startRedirectCluster starts a cluster of n nodes answering with the redirections of Redis Cluster
+func startRedirectCluster(t *testing.T, n int) *Cluster {
+	t.Helper()
+	configMu.Lock()
+	redirect, hash := config.Properties.ClusterRedirect, config.Properties.ClusterHash
+	config.Properties.ClusterRedirect, config.Properties.ClusterHash = true, "crc16"
+	configMu.Unlock()
+	// MIGRATE reads the mode while the test runs
+	t.Cleanup(func() {
+		configMu.Lock()
+		config.Properties.ClusterRedirect, config.Properties.ClusterHash = redirect, hash
+		configMu.Unlock()
+	})
+	return startCluster(t, n)
+}
+
+// ownerOf returns the node owning the slot of the key, learnt from the redirection of node
+func ownerOf(t *testing.T, c *Cluster, node *Node, key string) *Node {
+	t.Helper()
+	result := connect(t, node).Send(utils.ToCmdLine("TYPE", key))
+	redirect, ok := client.ParseRedirect(result)
+	if !ok {
+		return node
+	}
+	for _, other := range c.Nodes() {
+		if other.ID() == redirect.Addr {
+			return other
+		}
+	}
+	t.Fatalf("redirected to the unknown node %s", redirect.Addr)
+	return nil
+}
+
+// TestClusterRedirect tests that the nodes answer MOVED for the keys of the other nodes and that a
+// cluster client learning the slots with CLUSTER SLOTS reaches every key
+func TestClusterRedirect(t *testing.T) {
+	c := startRedirectCluster(t, 3)
+	nodes := c.Nodes()
+	cli := connect(t, nodes[0])
+
+	// the ranges cover every slot once
+	ranges, ok := cli.Send(utils.ToCmdLine("CLUSTER", "SLOTS")).(*reply.MultiRawReply)
+	if !ok {
+		t.Fatal("expected the slot ranges")
+	}
+	next := int64(0)
+	for _, r := range ranges.Replies {
+		fields := r.(*reply.MultiRawReply).Replies
+		if start := fields[0].(*reply.IntReply).Code; start != next {
+			t.Fatalf("expected a range starting at %d, got %d", next, start)
+		}
+		next = fields[1].(*reply.IntReply).Code + 1
+	}
+	if next != slot.SlotCount {
+		t.Fatalf("expected the ranges to end at the last slot, got %d", next-1)
+	}
+	if shards, ok := cli.Send(utils.ToCmdLine("CLUSTER", "SHARDS")).(*reply.MultiRawReply); !ok || len(shards.Replies) != 3 {
+		t.Errorf("expected a shard per node")
+	}
+	if result := cli.Send(utils.ToCmdLine("CLUSTER", "KEYSLOT", "{user1}.name")); string(result.ToBytes()) != ":"+strconv.Itoa(slot.KeySlot("user1"))+"\r\n" {
+		t.Errorf("expected the slot of the hash tag, got %q", result.ToBytes())
+	}
+
+	cc, err := client.MakeClusterClient([]string{nodes[0].ID()}, client.PoolConfig{MaxActive: 4, MaxIdle: 4, Wait: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	moved := 0
+	for i := 0; i < 50; i++ {
+		key := "key" + strconv.Itoa(i)
+		if result := cc.Send(utils.ToCmdLine("SET", key, strconv.Itoa(i))); !isOK(result) {
+			t.Fatalf("SET %s: %q", key, result.ToBytes())
+		}
+		if got := bulkString(cc.Send(utils.ToCmdLine("GET", key))); got != strconv.Itoa(i) {
+			t.Errorf("expected %s to be %d, got %q", key, i, got)
+		}
+		result := cli.Send(utils.ToCmdLine("GET", key))
+		if redirect, ok := client.ParseRedirect(result); ok {
+			moved++
+			if redirect.Kind != reply.RedirectMoved || redirect.Slot != slot.KeySlot(key) {
+				t.Errorf("expected MOVED to the slot of %s, got %q", key, result.ToBytes())
+			}
+		}
+	}
+	if moved == 0 || moved == 50 {
+		t.Errorf("expected the keys to be spread over the nodes, %d of 50 are moved", moved)
+	}
+	if result := cli.Send(utils.ToCmdLine("EXISTS", "a", "b")); string(result.ToBytes()) != string(reply.MakeCrossSlotErrReply().ToBytes()) {
+		t.Errorf("expected CROSSSLOT, got %q", result.ToBytes())
+	}
+}
+
+// TestClusterRedirectMigration tests moving a slot with CLUSTER SETSLOT and MIGRATE: the keys
+// already moved are answered with ASK by the source node and served by the target after ASKING
+func TestClusterRedirectMigration(t *testing.T) {
+	c := startRedirectCluster(t, 3)
+	k1, k2 := "{mig}1", "{mig}2"
+	s := strconv.Itoa(slot.KeySlot(k1))
+	source := ownerOf(t, c, c.Nodes()[0], k1)
+	var target *Node
+	for _, node := range c.Nodes() {
+		if node != source {
+			target = node
+			break
+		}
+	}
+	src, dst := connect(t, source), connect(t, target)
+	src.Send(utils.ToCmdLine("SET", k1, "v1"))
+	src.Send(utils.ToCmdLine("SET", k2, "v2"))
+
+	sourceID := bulkString(src.Send(utils.ToCmdLine("CLUSTER", "MYID")))
+	if result := dst.Send(utils.ToCmdLine("CLUSTER", "SETSLOT", s, "IMPORTING", sourceID)); !isOK(result) {
+		t.Fatalf("SETSLOT IMPORTING: %q", result.ToBytes())
+	}
+	if result := src.Send(utils.ToCmdLine("CLUSTER", "SETSLOT", s, "MIGRATING", target.ID())); !isOK(result) {
+		t.Fatalf("SETSLOT MIGRATING: %q", result.ToBytes())
+	}
+	host, port, _ := strings.Cut(target.Addr(), ":")
+	if result := src.Send(utils.ToCmdLine("MIGRATE", host, port, k1, "0", "1000")); !isOK(result) {
+		t.Fatalf("MIGRATE: %q", result.ToBytes())
+	}
+
+	if result := src.Send(utils.ToCmdLine("GET", k1)); string(result.ToBytes()) != "-ASK "+s+" "+target.ID()+"\r\n" {
+		t.Errorf("expected ASK for the moved key, got %q", result.ToBytes())
+	}
+	if got := bulkString(src.Send(utils.ToCmdLine("GET", k2))); got != "v2" {
+		t.Errorf("expected the source to serve the key not moved yet, got %q", got)
+	}
+	if result := src.Send(utils.ToCmdLine("EXISTS", k1, k2)); !strings.HasPrefix(string(result.ToBytes()), "-TRYAGAIN") {
+		t.Errorf("expected TRYAGAIN for the keys on both nodes, got %q", result.ToBytes())
+	}
+	if result := dst.Send(utils.ToCmdLine("GET", k1)); !client.IsMoved(result) {
+		t.Errorf("expected MOVED without ASKING, got %q", result.ToBytes())
+	}
+	replies := dst.Pipeline().Queue(utils.ToCmdLine("ASKING")).Queue(utils.ToCmdLine("GET", k1)).Queue(utils.ToCmdLine("GET", k1)).Exec()
+	if got := bulkString(replies[1]); got != "v1" {
+		t.Errorf("expected the target to serve the key after ASKING, got %q", replies[1].ToBytes())
+	}
+	if !client.IsMoved(replies[2]) {
+		t.Errorf("expected ASKING to apply to one command, got %q", replies[2].ToBytes())
+	}
+
+	if result := src.Send(utils.ToCmdLine("CLUSTER", "SETSLOT", s, "NODE", target.ID())); !strings.Contains(string(result.ToBytes()), "still hold keys") {
+		t.Errorf("expected the source to keep the slot of its keys, got %q", result.ToBytes())
+	}
+	src.Send(utils.ToCmdLine("MIGRATE", host, port, "", "0", "1000", "KEYS", k2))
+	if result := src.Send(utils.ToCmdLine("CLUSTER", "COUNTKEYSINSLOT", s)); string(result.ToBytes()) != ":0\r\n" {
+		t.Errorf("expected the keys to be moved, got %q", result.ToBytes())
+	}
+	for _, node := range c.Nodes() {
+		if result := connect(t, node).Send(utils.ToCmdLine("CLUSTER", "SETSLOT", s, "NODE", target.ID())); !isOK(result) {
+			t.Fatalf("SETSLOT NODE on %s: %q", node.ID(), result.ToBytes())
+		}
+	}
+	if result := src.Send(utils.ToCmdLine("GET", k2)); string(result.ToBytes()) != "-MOVED "+s+" "+target.ID()+"\r\n" {
+		t.Errorf("expected MOVED to the new owner, got %q", result.ToBytes())
+	}
+	if got := bulkString(dst.Send(utils.ToCmdLine("GET", k2))); got != "v2" {
+		t.Errorf("expected the new owner to serve the key, got %q", got)
+	}
+}
+
+// peerHealth returns the health of the peer in INFO cluster of the client
+func peerHealth(cli *client.Client, peer *Node) string {
+	for _, line := range strings.Split(bulkString(cli.Send(utils.ToCmdLine("INFO", "cluster"))), "\r\n") {
+		if strings.Contains(line, "addr="+peer.ID()+",") {
+			if _, health, ok := strings.Cut(line, ",health="); ok {
+				health, _, _ = strings.Cut(health, ",")
+				return health
+			}
+		}
+	}
+	return ""
+}
+
+// TestPeerFailover tests that a killed node is removed from the ring once its probes fail, so
+// that every key is served by the other nodes, and added back once it is restarted
+func TestPeerFailover(t *testing.T) {
+	configMu.Lock()
+	interval, failures := config.Properties.ClusterProbeInterval, config.Properties.ClusterProbeFailures
+	config.Properties.ClusterProbeInterval, config.Properties.ClusterProbeFailures = 50, 2
+	configMu.Unlock()
+	defer func() {
+		configMu.Lock()
+		config.Properties.ClusterProbeInterval, config.Properties.ClusterProbeFailures = interval, failures
+		configMu.Unlock()
+	}()
+	c := startCluster(t, 3)
+	nodes := c.Nodes()
+	cli, killed := connect(t, nodes[0]), nodes[2]
+
+	waitForHealth := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(convergeTimeout)
+		for peerHealth(cli, killed) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %s to be %s, got %q", killed.ID(), want, peerHealth(cli, killed))
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	waitForHealth("ok")
+	c.Kill(killed)
+	waitForHealth("failed")
+	for i := 0; i < 30; i++ {
+		key := "key" + strconv.Itoa(i)
+		if result := cli.Send(utils.ToCmdLine("SET", key, "value")); !isOK(result) {
+			t.Errorf("expected SET %s to be served without the failed node, got %q", key, result.ToBytes())
+		}
+	}
+
+	if err := c.Restart(killed); err != nil {
+		t.Fatal(err)
+	}
+	waitForHealth("ok")
+	for i := 0; i < 30; i++ {
+		key := "key" + strconv.Itoa(i)
+		if result := cli.Send(utils.ToCmdLine("SET", key, "again")); !isOK(result) {
+			t.Errorf("expected SET %s to succeed after the recovery, got %q", key, result.ToBytes())
+		}
+		if got := bulkString(cli.Send(utils.ToCmdLine("GET", key))); got != "again" {
+			t.Errorf("expected %s to be read back, got %q", key, got)
+		}
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	c := startCluster(t, 3)
+	nodes := c.Nodes()
+	monitor, err := net.Dial("tcp", nodes[0].Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer monitor.Close()
+	if _, err := monitor.Write([]byte("MONITOR\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	_ = monitor.SetReadDeadline(time.Now().Add(5 * time.Second))
+	lines := bufio.NewReader(monitor)
+	if line, err := lines.ReadString('\n'); err != nil || line != "+OK\r\n" {
+		t.Fatalf("MONITOR: %q %v", line, err)
+	}
+
+	// the commands received by the node are shown, wherever their keys are
+	cli := connect(t, nodes[0])
+	cli.Send(utils.ToCmdLine("SET", "monitor:key", "a \"quoted\"\nvalue"))
+	cli.Send(utils.ToCmdLine("GET", "monitor:key"))
+	for _, expected := range []string{`"SET" "monitor:key" "a \"quoted\"\nvalue"`, `"GET" "monitor:key"`} {
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(line, "+") || !strings.Contains(line, " [0 127.0.0.1:") || !strings.HasSuffix(line, "] "+expected+"\r\n") {
+			t.Errorf("expected a line with %s, got %q", expected, line)
+		}
+	}
+}
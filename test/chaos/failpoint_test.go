@@ -0,0 +1,162 @@
+//go:build failpoint
+
+package chaos
+
+import (
+	"redigo/config"
+	"redigo/lib/failpoint"
+	"redigo/lib/utils"
+	"redigo/resp/client"
+	"redigo/resp/reply"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestRelayFailpoint tests that the relays failed by a failpoint are reported to the clients and
+// counted as errors, and that the writes succeed again once the failpoint is exhausted
+func TestRelayFailpoint(t *testing.T) {
+	c := startCluster(t, 3)
+	nodes := c.Nodes()
+	clients := []*client.Client{connect(t, nodes[0])}
+	t.Cleanup(failpoint.DisableAll)
+
+	// the failpoints are shared by the nodes of the process, only the first node relays
+	if result := clients[0].Send(utils.ToCmdLine("DEBUG", "FAILPOINT", "cluster-relay", "3*error(injected)")); !isOK(result) {
+		t.Fatalf("expected DEBUG FAILPOINT to succeed, got %q", result.ToBytes())
+	}
+	w := newWriter("failpoint")
+	acked := w.write(clients, 100)
+	errors := 0
+	for _, node := range nodes[1:] {
+		errors += atoi(peerInfo(t, clients[0], node.ID())["errors"])
+	}
+	if errors < 3 {
+		t.Skip("the first node owns nearly every key")
+	}
+	if acked != 97 || errors != 3 {
+		t.Errorf("expected 3 failed relays, got %d acknowledged writes and %d errors", acked, errors)
+	}
+	waitConverged(t, w, clients)
+	w.checkAcked(t, clients)
+}
+
+// TestCrossNodeRenameRollback tests that a RENAME across nodes failing to delete the source key
+// puts back the previous value of the new key
+func TestCrossNodeRenameRollback(t *testing.T) {
+	c := startCluster(t, 3)
+	cli := connect(t, c.Nodes()[0])
+	t.Cleanup(failpoint.DisableAll)
+
+	if result := cli.Send(utils.ToCmdLine("DEBUG", "FAILPOINT", "cluster-rename", "1*error(injected)")); !isOK(result) {
+		t.Fatalf("expected DEBUG FAILPOINT to succeed, got %q", result.ToBytes())
+	}
+	failed := 0
+	for i := 0; i < 20; i++ {
+		src, dest := "src:"+strconv.Itoa(i), "dest:"+strconv.Itoa(i)
+		cli.Send(utils.ToCmdLine("SET", src, "new"))
+		cli.Send(utils.ToCmdLine("SET", dest, "old", "EX", "100"))
+		result := cli.Send(utils.ToCmdLine("RENAME", src, dest))
+		if isOK(result) {
+			continue
+		}
+		failed++
+		if !strings.Contains(string(result.ToBytes()), "injected") {
+			t.Errorf("expected the injected error, got %q", result.ToBytes())
+		}
+		if got := bulkString(cli.Send(utils.ToCmdLine("GET", src))); got != "new" {
+			t.Errorf("expected %s to be kept, got %q", src, got)
+		}
+		if got := bulkString(cli.Send(utils.ToCmdLine("GET", dest))); got != "old" {
+			t.Errorf("expected %s to be rolled back, got %q", dest, got)
+		}
+		if ttl := cli.Send(utils.ToCmdLine("TTL", dest)).(*reply.IntReply).Code; ttl < 98 {
+			t.Errorf("expected the TTL of %s to be rolled back, got %d", dest, ttl)
+		}
+	}
+	if failed != 1 {
+		t.Errorf("expected a single failed rename, got %d", failed)
+	}
+}
+
+// persistenceInfo returns the fields of INFO persistence
+func persistenceInfo(t *testing.T, cli *client.Client) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for _, line := range strings.Split(bulkString(cli.Send(utils.ToCmdLine("INFO", "persistence"))), "\r\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// TestAofBackpressure tests the aof-backpressure policies with an AOF writer slowed down by a
+// failpoint: the accepted writes are all written to the AOF in order, and counted by the policy
+func TestAofBackpressure(t *testing.T) {
+	for _, test := range []struct {
+		policy  string
+		counter string
+	}{
+		{"block", "aof_blocked_writes"},
+		{"spill", "aof_spilled_commands"},
+		{"reject", "aof_rejected_writes"},
+	} {
+		t.Run(test.policy, func(t *testing.T) {
+			configMu.Lock()
+			size, policy := config.Properties.AofBufferSize, config.Properties.AofBackpressure
+			config.Properties.AofBufferSize, config.Properties.AofBackpressure = 4, test.policy
+			configMu.Unlock()
+			// read again when the node restarts
+			t.Cleanup(func() {
+				configMu.Lock()
+				config.Properties.AofBufferSize, config.Properties.AofBackpressure = size, policy
+				configMu.Unlock()
+			})
+			c := startCluster(t, 1)
+			node := c.Nodes()[0]
+			cli := connect(t, node)
+			t.Cleanup(failpoint.DisableAll)
+
+			if result := cli.Send(utils.ToCmdLine("DEBUG", "FAILPOINT", "aof-write", "delay(2ms)")); !isOK(result) {
+				t.Fatalf("expected DEBUG FAILPOINT to succeed, got %q", result.ToBytes())
+			}
+			var accepted []string
+			for i := 0; i < 100; i++ {
+				result := cli.Send(utils.ToCmdLine("RPUSH", "list", strconv.Itoa(i)))
+				if reply.IsErrReply(result) {
+					if !strings.HasPrefix(string(result.ToBytes()), "-OOM") {
+						t.Fatalf("expected an OOM error, got %q", result.ToBytes())
+					}
+					continue
+				}
+				accepted = append(accepted, strconv.Itoa(i))
+			}
+			info := persistenceInfo(t, cli)
+			if info["aof_backpressure"] != test.policy || atoi(info[test.counter]) == 0 {
+				t.Errorf("expected %s to count the writes, got %v", test.counter, info)
+			}
+			if test.policy == "reject" && len(accepted) == 100 {
+				t.Errorf("expected writes to be rejected")
+			}
+			failpoint.DisableAll()
+
+			c.Kill(node)
+			if err := c.Restart(node); err != nil {
+				t.Fatal(err)
+			}
+			cli = connect(t, node)
+			result, ok := cli.Send(utils.ToCmdLine("LRANGE", "list", "0", "-1")).(*reply.MultiBulkReply)
+			if !ok {
+				t.Fatalf("expected the list to be reloaded")
+			}
+			var got []string
+			for _, arg := range result.Args {
+				got = append(got, string(arg))
+			}
+			if strings.Join(got, ",") != strings.Join(accepted, ",") {
+				t.Errorf("expected the AOF to hold the %d accepted writes in order, got %v", len(accepted), got)
+			}
+		})
+	}
+}
@@ -0,0 +1,791 @@
+package compat
+
+// Cases are the tests ported from the Redis test suite, in the order of their files
+var Cases = concat(stringCases, incrCases, keyspaceCases, expireCases, listCases, hashCases, setCases,
+	zsetCases, streamCases, introspectionCases, otherCases)
+
+func concat(groups ...[]Case) []Case {
+	var all []Case
+	for _, group := range groups {
+		all = append(all, group...)
+	}
+	return all
+}
+
+var stringCases = []Case{
+	{Suite: "unit/type/string", Name: "SET and GET an item", Steps: []Step{
+		want("SET x foobar", "OK"),
+		want("GET x", "foobar"),
+	}},
+	{Suite: "unit/type/string", Name: "SET and GET an empty item", Steps: []Step{
+		want("SET x {}", "OK"),
+		want("GET x", ""),
+	}},
+	{Suite: "unit/type/string", Name: "SETNX target key missing", Steps: []Step{
+		want("SETNX novar foobared", "1"),
+		want("GET novar", "foobared"),
+	}},
+	{Suite: "unit/type/string", Name: "SETNX target key exists", Steps: []Step{
+		want("SET novar foobared", "OK"),
+		want("SETNX novar blabla", "0"),
+		want("GET novar", "foobared"),
+	}},
+	{Suite: "unit/type/string", Name: "GETSET (set new value)", Steps: []Step{
+		want("GETSET foo xyz", ""),
+		want("GET foo", "xyz"),
+	}},
+	{Suite: "unit/type/string", Name: "GETSET (replace old value)", Steps: []Step{
+		want("SET foo bar", "OK"),
+		want("GETSET foo xyz", "bar"),
+		want("GET foo", "xyz"),
+	}},
+	{Suite: "unit/type/string", Name: "MSET base case", Steps: []Step{
+		want("MSET x 10 y {foo bar} z {x x x}", "OK"),
+		want("MGET x y z", "10 {foo bar} {x x x}"),
+	}},
+	{Suite: "unit/type/string", Name: "MSETNX with not existing keys", Steps: []Step{
+		want("MSETNX x1 xxx y2 yyy", "1"),
+		want("MGET x1 y2", "xxx yyy"),
+	}},
+	{Suite: "unit/type/string", Name: "STRLEN against non-existing key", Steps: []Step{
+		want("STRLEN notakey", "0"),
+	}},
+	{Suite: "unit/type/string", Name: "STRLEN against plain string", Steps: []Step{
+		want("SET mystring myvalue", "OK"),
+		want("STRLEN mystring", "7"),
+	}},
+	{Suite: "unit/type/string", Name: "SETRANGE against non-existing key", Steps: []Step{
+		want("SETRANGE mykey 0 foo", "3"),
+		want("GET mykey", "foo"),
+		want("SETRANGE mykey 1 bar", "4"),
+		want("GET mykey", "fbar"),
+	}},
+	{Suite: "unit/type/string", Name: "SETRANGE against key with wrong type", Pending: "the error says WRONG TYPE instead of WRONGTYPE", Steps: []Step{
+		want("LPUSH mykey foo", "1"),
+		wantErr("SETRANGE mykey 0 bar", "*WRONGTYPE*"),
+	}},
+	{Suite: "unit/type/string", Name: "GETRANGE against string value", Steps: []Step{
+		want("SET mykey {Hello World}", "OK"),
+		want("GETRANGE mykey 0 3", "Hell"),
+		want("GETRANGE mykey 0 -1", "Hello World"),
+		want("GETRANGE mykey -4 -1", "orld"),
+		want("GETRANGE mykey 5 3", ""),
+		want("GETRANGE mykey 5 5000", " World"),
+		want("GETRANGE mykey -5000 10000", "Hello World"),
+	}},
+	{Suite: "unit/type/string", Name: "GETEX EX option", Pending: "GETEX is missing", Steps: []Step{
+		want("SET foo bar", "OK"),
+		want("GETEX foo EX 10", "bar"),
+		wantRange("TTL foo", 5, 10),
+	}},
+	{Suite: "unit/type/string", Name: "GETDEL command", Pending: "GETDEL is missing", Steps: []Step{
+		want("SET foo bar", "OK"),
+		want("GETDEL foo", "bar"),
+		want("GETDEL foo", ""),
+	}},
+	{Suite: "unit/type/string", Name: "SETEX - Set + Expire combo operation. Check for TTL", Steps: []Step{
+		want("SETEX x 12 test", "OK"),
+		wantRange("TTL x", 10, 12),
+	}},
+	{Suite: "unit/type/string", Name: "SETEX - Wrong time parameter", Steps: []Step{
+		wantErr("SETEX z -10 foo", "*invalid expire*"),
+	}},
+	{Suite: "unit/type/string", Name: "APPEND basics", Steps: []Step{
+		want("APPEND foo bar", "3"),
+		want("GET foo", "bar"),
+		want("APPEND foo 100", "6"),
+		want("GET foo", "bar100"),
+	}},
+	{Suite: "unit/type/string", Name: "Extended SET NX option", Steps: []Step{
+		want("SET foo 1 NX", "OK"),
+		want("SET foo 2 NX", ""),
+		want("GET foo", "1"),
+	}},
+	{Suite: "unit/type/string", Name: "Extended SET XX option", Steps: []Step{
+		want("SET foo 1 XX", ""),
+		want("SET foo bar", "OK"),
+		want("SET foo 2 XX", "OK"),
+		want("GET foo", "2"),
+	}},
+	{Suite: "unit/type/string", Name: "Extended SET GET option", Steps: []Step{
+		want("SET foo bar", "OK"),
+		want("SET foo bar2 GET", "bar"),
+		want("GET foo", "bar2"),
+	}},
+	{Suite: "unit/type/string", Name: "Extended SET EX option", Steps: []Step{
+		want("SET foo bar EX 10", "OK"),
+		wantRange("TTL foo", 5, 10),
+	}},
+	{Suite: "unit/type/string", Name: "Extended SET using multiple options at once", Steps: []Step{
+		want("SET foo val", "OK"),
+		want("SET foo bar XX PX 10000", "OK"),
+		wantRange("TTL foo", 5, 10),
+	}},
+	{Suite: "unit/type/string", Name: "SET with EX with big integer should report an error", Steps: []Step{
+		wantErr("SET foo bar EX 10000000000000000", "*invalid expire time*"),
+	}},
+	{Suite: "unit/type/string", Name: "LCS basic", Steps: []Step{
+		want("SET virus1 ohmytext", "OK"),
+		want("SET virus2 mynewtext", "OK"),
+		want("LCS virus1 virus2", "mytext"),
+		want("LCS virus1 virus2 LEN", "6"),
+	}},
+}
+
+var incrCases = []Case{
+	{Suite: "unit/type/incr", Name: "INCR against non existing key", Steps: []Step{
+		want("INCR novar", "1"),
+		want("GET novar", "1"),
+	}},
+	{Suite: "unit/type/incr", Name: "INCR against key created by incr itself", Steps: []Step{
+		want("INCR novar", "1"),
+		want("INCR novar", "2"),
+	}},
+	{Suite: "unit/type/incr", Name: "DECR against key created by incr", Steps: []Step{
+		want("INCR novar", "1"),
+		want("DECR novar", "0"),
+	}},
+	{Suite: "unit/type/incr", Name: "INCR against key originally set with SET", Steps: []Step{
+		want("SET novar 100", "OK"),
+		want("INCR novar", "101"),
+	}},
+	{Suite: "unit/type/incr", Name: "INCR over 32bit value", Steps: []Step{
+		want("SET novar 17179869184", "OK"),
+		want("INCR novar", "17179869185"),
+	}},
+	{Suite: "unit/type/incr", Name: "INCRBY over 32bit value with over 32bit increment", Steps: []Step{
+		want("SET novar 17179869184", "OK"),
+		want("INCRBY novar 17179869184", "34359738368"),
+	}},
+	{Suite: "unit/type/incr", Name: "INCR fails against key with spaces (left)", Steps: []Step{
+		want("SET novar { 11}", "OK"),
+		wantErr("INCR novar", "ERR*"),
+	}},
+	{Suite: "unit/type/incr", Name: "INCR fails against a key holding a list", Pending: "the error says WRONG TYPE instead of WRONGTYPE", Steps: []Step{
+		want("RPUSH mylist 1", "1"),
+		wantErr("INCR mylist", "*WRONGTYPE*"),
+	}},
+	{Suite: "unit/type/incr", Name: "DECRBY over 32bit value with over 32bit increment, negative res", Steps: []Step{
+		want("SET novar 17179869184", "OK"),
+		want("DECRBY novar 17179869185", "-1"),
+	}},
+	{Suite: "unit/type/incr", Name: "DECRBY against key is not exist", Steps: []Step{
+		want("DECRBY key_not_exist 1", "-1"),
+	}},
+	{Suite: "unit/type/incr", Name: "INCR uses shared objects in the 0-9999 range", Steps: []Step{
+		want("SET foo -1", "OK"),
+		want("INCR foo", "0"),
+	}},
+	{Suite: "unit/type/incr", Name: "INCRBYFLOAT against non existing key", Steps: []Step{
+		want("INCRBYFLOAT novar 1", "1"),
+		want("GET novar", "1"),
+		want("INCRBYFLOAT novar 0.25", "1.25"),
+		want("GET novar", "1.25"),
+	}},
+	{Suite: "unit/type/incr", Name: "INCRBYFLOAT fails against key with spaces (left)", Steps: []Step{
+		want("SET novar { 11}", "OK"),
+		wantErr("INCRBYFLOAT novar 1.0", "ERR *valid*"),
+	}},
+	{Suite: "unit/type/incr", Name: "INCRBYFLOAT does not allow NaN or Infinity", Pending: "+inf is refused as an invalid float", Steps: []Step{
+		want("SET foo 0", "OK"),
+		wantErr("INCRBYFLOAT foo +inf", "ERR *would produce*"),
+	}},
+	{Suite: "unit/type/incr", Name: "INCRBYFLOAT decrement", Pending: "the increment is computed in float64, Redis uses a long double", Steps: []Step{
+		want("SET foo 1", "OK"),
+		want("INCRBYFLOAT foo -1.1", "-0.1"),
+	}},
+}
+
+var keyspaceCases = []Case{
+	{Suite: "unit/keyspace", Name: "DEL against a single item", Steps: []Step{
+		want("SET x foo", "OK"),
+		want("DEL x", "1"),
+		want("GET x", ""),
+	}},
+	{Suite: "unit/keyspace", Name: "Vararg DEL", Steps: []Step{
+		want("SET foo1 a", "OK"),
+		want("SET foo2 b", "OK"),
+		want("SET foo3 c", "OK"),
+		want("DEL foo1 foo2 foo3 foo4", "3"),
+		want("MGET foo1 foo2 foo3", "{} {} {}"),
+	}},
+	{Suite: "unit/keyspace", Name: "KEYS with pattern", Steps: []Step{
+		want("SET key_x hello", "OK"),
+		want("SET key_y hello", "OK"),
+		want("SET foo_a hello", "OK"),
+		want("SET foo_b hello", "OK"),
+		wantSorted("KEYS foo*", "foo_a foo_b"),
+	}},
+	{Suite: "unit/keyspace", Name: "KEYS to get all keys", Steps: []Step{
+		want("SET key_x hello", "OK"),
+		want("SET foo_a hello", "OK"),
+		wantSorted("KEYS *", "foo_a key_x"),
+	}},
+	{Suite: "unit/keyspace", Name: "DBSIZE", Pending: "DBSIZE is missing", Steps: []Step{
+		want("SET a 1", "OK"),
+		want("SET b 2", "OK"),
+		want("DBSIZE", "2"),
+	}},
+	{Suite: "unit/keyspace", Name: "EXISTS", Steps: []Step{
+		want("SET newkey test", "OK"),
+		want("EXISTS newkey", "1"),
+		want("DEL newkey", "1"),
+		want("EXISTS newkey", "0"),
+	}},
+	{Suite: "unit/keyspace", Name: "Zero length value in key. SET/GET/EXISTS", Steps: []Step{
+		want("SET emptykey {}", "OK"),
+		want("GET emptykey", ""),
+		want("EXISTS emptykey", "1"),
+	}},
+	{Suite: "unit/keyspace", Name: "RENAME basic usage", Steps: []Step{
+		want("SET mykey hello", "OK"),
+		want("RENAME mykey mykey1", "OK"),
+		want("RENAME mykey1 mykey2", "OK"),
+		want("GET mykey2", "hello"),
+		want("EXISTS mykey", "0"),
+	}},
+	{Suite: "unit/keyspace", Name: "RENAME against non existing source key", Steps: []Step{
+		wantErr("RENAME nokey foobar", "ERR*"),
+	}},
+	{Suite: "unit/keyspace", Name: "RENAMENX basic usage", Steps: []Step{
+		want("SET mykey foobar", "OK"),
+		want("RENAMENX mykey mykey2", "1"),
+		want("GET mykey2", "foobar"),
+		want("EXISTS mykey", "0"),
+	}},
+	{Suite: "unit/keyspace", Name: "RENAMENX against already existing key", Steps: []Step{
+		want("SET mykey foo", "OK"),
+		want("SET mykey2 bar", "OK"),
+		want("RENAMENX mykey mykey2", "0"),
+	}},
+	{Suite: "unit/keyspace", Name: "TYPE of the values", Steps: []Step{
+		want("SET s x", "OK"),
+		want("RPUSH l x", "1"),
+		want("HSET h f v", "1"),
+		want("TYPE s", "string"),
+		want("TYPE l", "list"),
+		want("TYPE h", "hash"),
+		want("TYPE nokey", "none"),
+	}},
+	{Suite: "unit/keyspace", Name: "COPY basic usage for string", Pending: "COPY is missing", Steps: []Step{
+		want("SET mykey foobar", "OK"),
+		want("COPY mykey mynewkey", "1"),
+		want("GET mynewkey", "foobar"),
+	}},
+}
+
+var expireCases = []Case{
+	{Suite: "unit/expire", Name: "EXPIRE - set timeouts multiple times", Steps: []Step{
+		want("SET x foobar", "OK"),
+		want("EXPIRE x 5", "1"),
+		wantRange("TTL x", 4, 5),
+		want("EXPIRE x 10", "1"),
+		wantRange("TTL x", 9, 10),
+		want("GET x", "foobar"),
+	}},
+	{Suite: "unit/expire", Name: "EXPIRE with negative expiry", Steps: []Step{
+		want("SET foo bar", "OK"),
+		want("EXPIRE foo -1", "1"),
+		want("GET foo", ""),
+	}},
+	{Suite: "unit/expire", Name: "PERSIST can undo an EXPIRE", Steps: []Step{
+		want("SET x foo", "OK"),
+		want("EXPIRE x 50", "1"),
+		wantRange("TTL x", 49, 50),
+		want("PERSIST x", "1"),
+		want("TTL x", "-1"),
+		want("GET x", "foo"),
+	}},
+	{Suite: "unit/expire", Name: "PERSIST returns 0 against non existing or non volatile keys", Steps: []Step{
+		want("SET x foo", "OK"),
+		want("PERSIST foo", "0"),
+		want("PERSIST nokeyatall", "0"),
+	}},
+	{Suite: "unit/expire", Name: "PTTL returns time to live in milliseconds", Steps: []Step{
+		want("SETEX x 1 somevalue", "OK"),
+		wantRange("PTTL x", 900, 1000),
+	}},
+	{Suite: "unit/expire", Name: "TTL / PTTL / EXPIRETIME / PEXPIRETIME return -1 if key has no expire", Steps: []Step{
+		want("SET x hello", "OK"),
+		want("TTL x", "-1"),
+		want("PTTL x", "-1"),
+		want("EXPIRETIME x", "-1"),
+		want("PEXPIRETIME x", "-1"),
+	}},
+	{Suite: "unit/expire", Name: "TTL / PTTL / EXPIRETIME / PEXPIRETIME return -2 if key does not exit", Steps: []Step{
+		want("TTL x", "-2"),
+		want("PTTL x", "-2"),
+		want("EXPIRETIME x", "-2"),
+		want("PEXPIRETIME x", "-2"),
+	}},
+	{Suite: "unit/expire", Name: "EXPIRE with NX option on a key with ttl", Steps: []Step{
+		want("SET foo bar EX 100", "OK"),
+		want("EXPIRE foo 200 NX", "0"),
+		wantRange("TTL foo", 50, 100),
+	}},
+	{Suite: "unit/expire", Name: "EXPIRE with XX option on a key without ttl", Steps: []Step{
+		want("SET foo bar", "OK"),
+		want("EXPIRE foo 200 XX", "0"),
+		want("TTL foo", "-1"),
+	}},
+	{Suite: "unit/expire", Name: "EXPIRE with GT option on a key with lower ttl", Steps: []Step{
+		want("SET foo bar EX 100", "OK"),
+		want("EXPIRE foo 200 GT", "1"),
+		wantRange("TTL foo", 101, 200),
+	}},
+	{Suite: "unit/expire", Name: "EXPIRE with big integer overflow when basetime is added", Steps: []Step{
+		want("SET foo bar", "OK"),
+		wantErr("EXPIRE foo 9223370399119966", "ERR invalid expire time in 'expire' command"),
+	}},
+	{Suite: "unit/expire", Name: "EXPIREAT with a past timestamp deletes the key", Steps: []Step{
+		want("SET foo bar", "OK"),
+		want("EXPIREAT foo 1", "1"),
+		want("EXISTS foo", "0"),
+	}},
+}
+
+var listCases = []Case{
+	{Suite: "unit/type/list", Name: "LPUSH, RPUSH, LLENGTH, LINDEX, LPOP - quicklist", Steps: []Step{
+		want("LPUSH myxlist a", "1"),
+		want("RPUSH myxlist b", "2"),
+		want("RPUSH myxlist c", "3"),
+		want("LLEN myxlist", "3"),
+		want("LINDEX myxlist 0", "a"),
+		want("LINDEX myxlist 1", "b"),
+		want("LINDEX myxlist 2", "c"),
+		want("LINDEX myxlist 3", ""),
+		want("LINDEX myxlist -1", "c"),
+		want("LPOP myxlist", "a"),
+		want("RPOP myxlist", "c"),
+		want("LLEN myxlist", "1"),
+	}},
+	{Suite: "unit/type/list", Name: "LPUSH, RPUSH variadic", Steps: []Step{
+		want("LPUSH mylist a b c", "3"),
+		want("RPUSH mylist 0 1", "5"),
+		want("LRANGE mylist 0 -1", "c b a 0 1"),
+	}},
+	{Suite: "unit/type/list", Name: "LPOP/RPOP with the count", Steps: []Step{
+		want("RPUSH listcount aa bb cc dd", "4"),
+		want("LPOP listcount 2", "aa bb"),
+		want("RPOP listcount 1", "dd"),
+	}},
+	{Suite: "unit/type/list", Name: "LPOP/RPOP against empty list", Steps: []Step{
+		want("LPOP non-existing-list", ""),
+		want("RPOP non-existing-list", ""),
+	}},
+	{Suite: "unit/type/list", Name: "LPUSHX, RPUSHX - generic", Steps: []Step{
+		want("LPUSHX xlist a", "0"),
+		want("LLEN xlist", "0"),
+		want("RPUSHX xlist a", "0"),
+	}},
+	{Suite: "unit/type/list", Name: "LINDEX against non-list value error", Pending: "the error says WRONG TYPE instead of WRONGTYPE", Steps: []Step{
+		want("SET mylist foobar", "OK"),
+		wantErr("LINDEX mylist 0", "*WRONGTYPE*"),
+	}},
+	{Suite: "unit/type/list", Name: "LPUSH against non-list value error", Pending: "the error says WRONG TYPE instead of WRONGTYPE", Steps: []Step{
+		want("SET mylist foobar", "OK"),
+		wantErr("LPUSH mylist 0", "*WRONGTYPE*"),
+	}},
+	{Suite: "unit/type/list", Name: "LRANGE basics", Steps: []Step{
+		want("RPUSH mylist 0 1 2 3 4 5 6 7 8 9", "10"),
+		want("LRANGE mylist 1 -2", "1 2 3 4 5 6 7 8"),
+		want("LRANGE mylist -3 -1", "7 8 9"),
+		want("LRANGE mylist 4 4", "4"),
+	}},
+	{Suite: "unit/type/list", Name: "LRANGE out of range indexes including the full list", Steps: []Step{
+		want("RPUSH mylist 1 2 3", "3"),
+		want("LRANGE mylist -1000 1000", "1 2 3"),
+	}},
+	{Suite: "unit/type/list", Name: "LRANGE out of range negative end index", Steps: []Step{
+		want("RPUSH mylist 1 2 3", "3"),
+		want("LRANGE mylist 0 -4", ""),
+		want("LRANGE mylist 0 -3", "1"),
+	}},
+	{Suite: "unit/type/list", Name: "LRANGE against non existing key", Steps: []Step{
+		want("LRANGE nosuchkey 0 1", ""),
+	}},
+	{Suite: "unit/type/list", Name: "LSET", Steps: []Step{
+		want("RPUSH mylist 99 98 97 96 95", "5"),
+		want("LSET mylist 1 foo", "OK"),
+		want("LSET mylist -1 bar", "OK"),
+		want("LRANGE mylist 0 -1", "99 foo 97 96 bar"),
+	}},
+	{Suite: "unit/type/list", Name: "LSET out of range index", Pending: "the error has no ERR prefix", Steps: []Step{
+		want("RPUSH mylist 99 98", "2"),
+		wantErr("LSET mylist 10 foo", "ERR*range*"),
+	}},
+	{Suite: "unit/type/list", Name: "LSET against non existing key", Pending: "the error has no ERR prefix", Steps: []Step{
+		wantErr("LSET nosuchkey 10 foo", "ERR*key*"),
+	}},
+	{Suite: "unit/type/list", Name: "LINSERT", Steps: []Step{
+		want("RPUSH xlist a b c d", "4"),
+		want("LINSERT xlist BEFORE c zz", "5"),
+		want("LINSERT xlist AFTER c yy", "6"),
+		want("LRANGE xlist 0 -1", "a b zz c yy d"),
+		want("LINSERT xlist BEFORE nothing x", "-1"),
+	}},
+	{Suite: "unit/type/list", Name: "LREM remove all the occurrences", Steps: []Step{
+		want("RPUSH mylist foo bar foobar foobared zap bar test foo", "8"),
+		want("LREM mylist 0 bar", "2"),
+		want("LRANGE mylist 0 -1", "foo foobar foobared zap test foo"),
+	}},
+	{Suite: "unit/type/list", Name: "LTRIM basics", Steps: []Step{
+		want("RPUSH mylist 1 2 3 4 5", "5"),
+		want("LTRIM mylist 1 -2", "OK"),
+		want("LRANGE mylist 0 -1", "2 3 4"),
+	}},
+	{Suite: "unit/type/list", Name: "RPOPLPUSH base case", Steps: []Step{
+		want("RPUSH mylist a b c d", "4"),
+		want("RPOPLPUSH mylist newlist", "d"),
+		want("RPOPLPUSH mylist newlist", "c"),
+		want("LRANGE mylist 0 -1", "a b"),
+		want("LRANGE newlist 0 -1", "c d"),
+	}},
+	{Suite: "unit/type/list", Name: "LPOS basic usage", Steps: []Step{
+		want("RPUSH mylist a b c 1 2 3 c c", "8"),
+		want("LPOS mylist a", "0"),
+		want("LPOS mylist c", "2"),
+	}},
+	{Suite: "unit/type/list", Name: "BLPOP: single existing list", Steps: []Step{
+		want("RPUSH blist a b c", "3"),
+		want("BLPOP blist 1", "blist a"),
+		want("BRPOP blist 1", "blist c"),
+	}},
+	{Suite: "unit/type/list", Name: "BLPOP with a timeout on an empty list", Steps: []Step{
+		want("BLPOP blist1 0.1", ""),
+	}},
+}
+
+var hashCases = []Case{
+	{Suite: "unit/type/hash", Name: "HSET/HLEN - Small hash creation", Steps: []Step{
+		want("HSET smallhash a 1 b 2 c 3", "3"),
+		want("HLEN smallhash", "3"),
+	}},
+	{Suite: "unit/type/hash", Name: "HSET in update and insert mode", Steps: []Step{
+		want("HSET smallhash a 1", "1"),
+		want("HSET smallhash a 2", "0"),
+		want("HGET smallhash a", "2"),
+	}},
+	{Suite: "unit/type/hash", Name: "HSETNX target key missing - small hash", Steps: []Step{
+		want("HSETNX smallhash a foo", "1"),
+		want("HGET smallhash a", "foo"),
+	}},
+	{Suite: "unit/type/hash", Name: "HSETNX target key exists - small hash", Steps: []Step{
+		want("HSET smallhash a foo", "1"),
+		want("HSETNX smallhash a bar", "0"),
+		want("HGET smallhash a", "foo"),
+	}},
+	{Suite: "unit/type/hash", Name: "HMSET - small hash", Steps: []Step{
+		want("HMSET smallhash a 1 b 2", "OK"),
+		want("HMGET smallhash a b c", "1 2 {}"),
+	}},
+	{Suite: "unit/type/hash", Name: "HKEYS - small hash", Steps: []Step{
+		want("HSET smallhash a 1 b 2", "2"),
+		wantSorted("HKEYS smallhash", "a b"),
+		wantSorted("HVALS smallhash", "1 2"),
+		wantSorted("HGETALL smallhash", "1 2 a b"),
+	}},
+	{Suite: "unit/type/hash", Name: "HGETALL against non-existing key", Steps: []Step{
+		want("HGETALL htest", ""),
+	}},
+	{Suite: "unit/type/hash", Name: "HDEL and return value", Steps: []Step{
+		want("HSET smallhash a 1", "1"),
+		want("HDEL smallhash nokey", "0"),
+		want("HDEL smallhash a", "1"),
+		want("HGET smallhash a", ""),
+	}},
+	{Suite: "unit/type/hash", Name: "HDEL - more than a single value", Steps: []Step{
+		want("HSET myhash a 1 b 2 c 3", "3"),
+		want("HDEL myhash x y", "0"),
+		want("HDEL myhash a c f", "2"),
+		want("HGETALL myhash", "b 2"),
+	}},
+	{Suite: "unit/type/hash", Name: "HEXISTS", Steps: []Step{
+		want("HSET smallhash a 1", "1"),
+		want("HEXISTS smallhash a", "1"),
+		want("HEXISTS smallhash nokey", "0"),
+	}},
+	{Suite: "unit/type/hash", Name: "HINCRBY against non existing hash key", Pending: "HINCRBY is missing", Steps: []Step{
+		want("HINCRBY smallhash tmp 2", "2"),
+		want("HGET smallhash tmp", "2"),
+	}},
+	{Suite: "unit/type/hash", Name: "HINCRBYFLOAT against non existing hash key", Pending: "HINCRBYFLOAT is missing", Steps: []Step{
+		want("HINCRBYFLOAT smallhash tmp 2.5", "2.5"),
+	}},
+	{Suite: "unit/type/hash", Name: "HSTRLEN against the small hash", Pending: "HSTRLEN is missing", Steps: []Step{
+		want("HSET smallhash a hello", "1"),
+		want("HSTRLEN smallhash a", "5"),
+	}},
+	{Suite: "unit/type/hash", Name: "HSET against a key of another type", Pending: "the error says WRONG TYPE instead of WRONGTYPE", Steps: []Step{
+		want("SET k v", "OK"),
+		wantErr("HSET k f v", "*WRONGTYPE*"),
+	}},
+}
+
+var setCases = []Case{
+	{Suite: "unit/type/set", Name: "SADD, SCARD, SISMEMBER, SMEMBERS basics - intset", Steps: []Step{
+		want("SADD myset 16", "1"),
+		want("SADD myset 17", "1"),
+		want("SADD myset 16", "0"),
+		want("SCARD myset", "2"),
+		want("SISMEMBER myset 16", "1"),
+		want("SISMEMBER myset 18", "0"),
+		wantSorted("SMEMBERS myset", "16 17"),
+	}},
+	{Suite: "unit/type/set", Name: "SADD against non set", Pending: "the error says WRONG TYPE instead of WRONGTYPE", Steps: []Step{
+		want("LPUSH mylist foo", "1"),
+		wantErr("SADD mylist bar", "*WRONGTYPE*"),
+	}},
+	{Suite: "unit/type/set", Name: "SADD a non-integer against an intset", Steps: []Step{
+		want("SADD myset 1 2 3", "3"),
+		want("SADD myset a", "1"),
+		wantSorted("SMEMBERS myset", "1 2 3 a"),
+	}},
+	{Suite: "unit/type/set", Name: "SREM basics - intset", Steps: []Step{
+		want("SADD myset 3 4 5", "3"),
+		want("SREM myset 6", "0"),
+		want("SREM myset 4", "1"),
+		wantSorted("SMEMBERS myset", "3 5"),
+	}},
+	{Suite: "unit/type/set", Name: "SREM with multiple arguments", Steps: []Step{
+		want("SADD myset a b c d", "4"),
+		want("SREM myset k k k", "0"),
+		want("SREM myset b d x y", "2"),
+		wantSorted("SMEMBERS myset", "a c"),
+	}},
+	{Suite: "unit/type/set", Name: "SINTER, SUNION and SDIFF against two sets", Steps: []Step{
+		want("SADD set1 1 2 3 4", "4"),
+		want("SADD set2 3 4 5 6", "4"),
+		wantSorted("SINTER set1 set2", "3 4"),
+		wantSorted("SUNION set1 set2", "1 2 3 4 5 6"),
+		wantSorted("SDIFF set1 set2", "1 2"),
+	}},
+	{Suite: "unit/type/set", Name: "SINTERSTORE with two sets", Steps: []Step{
+		want("SADD set1 1 2 3 4", "4"),
+		want("SADD set2 3 4 5 6", "4"),
+		want("SINTERSTORE setres set1 set2", "2"),
+		wantSorted("SMEMBERS setres", "3 4"),
+	}},
+	{Suite: "unit/type/set", Name: "SUNIONSTORE against non existing keys should delete dstkey", Steps: []Step{
+		want("SET setres xxx", "OK"),
+		want("SUNIONSTORE setres foo111 bar222", "0"),
+		want("EXISTS setres", "0"),
+	}},
+	{Suite: "unit/type/set", Name: "SDIFFSTORE against non existing keys should delete dstkey", Steps: []Step{
+		want("SET setres xxx", "OK"),
+		want("SDIFFSTORE setres foo111 bar222", "0"),
+		want("EXISTS setres", "0"),
+	}},
+	{Suite: "unit/type/set", Name: "SPOP basics - hashtable", Steps: []Step{
+		want("SADD myset a", "1"),
+		want("SPOP myset", "a"),
+		want("SCARD myset", "0"),
+	}},
+	{Suite: "unit/type/set", Name: "SPOP with count", Steps: []Step{
+		want("SADD myset a b c", "3"),
+		wantSorted("SPOP myset 3", "a b c"),
+		want("EXISTS myset", "0"),
+	}},
+	{Suite: "unit/type/set", Name: "SRANDMEMBER count of 0 is handled correctly", Steps: []Step{
+		want("SADD myset a", "1"),
+		want("SRANDMEMBER myset 0", ""),
+	}},
+	{Suite: "unit/type/set", Name: "SMOVE basics - from regular set to intset", Pending: "SMOVE is missing", Steps: []Step{
+		want("SADD myset1 a b", "2"),
+		want("SADD myset2 1 2", "2"),
+		want("SMOVE myset1 myset2 a", "1"),
+		wantSorted("SMEMBERS myset2", "1 2 a"),
+	}},
+	{Suite: "unit/type/set", Name: "SMISMEMBER against non set", Pending: "SMISMEMBER is missing", Steps: []Step{
+		want("SADD myset a", "1"),
+		want("SMISMEMBER myset a b", "1 0"),
+	}},
+	{Suite: "unit/type/set", Name: "SINTERCARD with two sets", Pending: "SINTERCARD is missing", Steps: []Step{
+		want("SADD set1 1 2 3", "3"),
+		want("SADD set2 2 3 4", "3"),
+		want("SINTERCARD 2 set1 set2", "2"),
+	}},
+}
+
+var zsetCases = []Case{
+	{Suite: "unit/type/zset", Name: "ZSET basic ZADD and score update", Steps: []Step{
+		want("ZADD ztmp 10 x", "1"),
+		want("ZADD ztmp 20 y", "1"),
+		want("ZADD ztmp 30 z", "1"),
+		want("ZRANGE ztmp 0 -1", "x y z"),
+		want("ZADD ztmp 1 y", "0"),
+		want("ZRANGE ztmp 0 -1", "y x z"),
+	}},
+	{Suite: "unit/type/zset", Name: "ZSET element can't be set to NaN with ZADD", Pending: "ZADD accepts a NaN score", Steps: []Step{
+		wantErr("ZADD myzset nan abc", "*not*float*"),
+	}},
+	{Suite: "unit/type/zset", Name: "ZADD XX option without key", Pending: "ZADD takes no NX, XX or INCR option", Steps: []Step{
+		want("ZADD ztmp XX 10 x", "0"),
+		want("TYPE ztmp", "none"),
+	}},
+	{Suite: "unit/type/zset", Name: "ZADD NX only add new elements without updating old ones", Pending: "ZADD takes no NX, XX or INCR option", Steps: []Step{
+		want("ZADD ztmp 10 x 20 y 30 z", "3"),
+		want("ZADD ztmp NX 11 x 21 y 100 a 200 b", "2"),
+		want("ZSCORE ztmp x", "10"),
+		want("ZSCORE ztmp a", "100"),
+	}},
+	{Suite: "unit/type/zset", Name: "ZADD INCR works like ZINCRBY", Pending: "ZADD takes no NX, XX or INCR option", Steps: []Step{
+		want("ZADD ztmp 10 x 20 y 30 z", "3"),
+		want("ZADD ztmp INCR 15 x", "25"),
+	}},
+	{Suite: "unit/type/zset", Name: "ZCARD basics", Steps: []Step{
+		want("ZADD ztmp 10 a 20 b 30 c", "3"),
+		want("ZCARD ztmp", "3"),
+		want("ZCARD zdoesntexist", "0"),
+	}},
+	{Suite: "unit/type/zset", Name: "ZREM removes key after last element is removed", Pending: "ZREM keeps the empty sorted set", Steps: []Step{
+		want("ZADD ztmp 10 x 20 y", "2"),
+		want("EXISTS ztmp", "1"),
+		want("ZREM ztmp z", "0"),
+		want("ZREM ztmp y", "1"),
+		want("ZREM ztmp x", "1"),
+		want("EXISTS ztmp", "0"),
+	}},
+	{Suite: "unit/type/zset", Name: "ZRANGE basics", Steps: []Step{
+		want("ZADD ztmp 1 a 2 b 3 c 4 d", "4"),
+		want("ZRANGE ztmp 0 -1", "a b c d"),
+		want("ZRANGE ztmp 0 -2", "a b c"),
+		want("ZRANGE ztmp 1 -1", "b c d"),
+		want("ZRANGE ztmp -1 -1", "d"),
+		want("ZRANGE ztmp 0 -1 WITHSCORES", "a 1 b 2 c 3 d 4"),
+	}},
+	{Suite: "unit/type/zset", Name: "ZRANK basics", Steps: []Step{
+		want("ZADD zranktmp 10 x 20 y 30 z", "3"),
+		want("ZRANK zranktmp x", "0"),
+		want("ZRANK zranktmp z", "2"),
+		want("ZRANK zranktmp foo", ""),
+	}},
+	{Suite: "unit/type/zset", Name: "ZSCORE", Steps: []Step{
+		want("ZADD zscoretest 1.5 a", "1"),
+		want("ZSCORE zscoretest a", "1.5"),
+		want("ZSCORE zscoretest b", ""),
+	}},
+	{Suite: "unit/type/zset", Name: "ZCOUNT basics", Steps: []Step{
+		want("ZADD zset 1 a 2 b 3 c", "3"),
+		want("ZCOUNT zset 2 3", "2"),
+		want("ZCOUNT zset (1 3", "2"),
+		want("ZCOUNT zset -inf +inf", "3"),
+	}},
+	{Suite: "unit/type/zset", Name: "ZREVRANGE basics", Steps: []Step{
+		want("ZADD ztmp 1 a 2 b 3 c", "3"),
+		want("ZREVRANGE ztmp 0 -1", "c b a"),
+	}},
+	{Suite: "unit/type/zset", Name: "ZINCRBY - can create a new sorted set", Steps: []Step{
+		want("ZINCRBY zset 1 foo", "1"),
+		want("ZRANGE zset 0 -1", "foo"),
+	}},
+	{Suite: "unit/type/zset", Name: "ZRANGEBYSCORE basics", Steps: []Step{
+		want("ZADD zset 1 a 2 b 3 c 4 d", "4"),
+		want("ZRANGEBYSCORE zset 2 3", "b c"),
+		want("ZRANGEBYSCORE zset (2 +inf", "c d"),
+	}},
+	{Suite: "unit/type/zset", Name: "ZPOPMIN/ZPOPMAX basics", Steps: []Step{
+		want("ZADD zset 1 a 2 b 3 c", "3"),
+		want("ZPOPMIN zset", "a 1"),
+		want("ZPOPMAX zset", "c 3"),
+	}},
+}
+
+var streamCases = []Case{
+	{Suite: "unit/type/stream", Name: "XADD with explicit IDs and XRANGE", Steps: []Step{
+		want("XADD mystream 1-1 a 1", "1-1"),
+		want("XADD mystream 1-2 b 2", "1-2"),
+		want("XLEN mystream", "2"),
+		want("XRANGE mystream - +", "{1-1 {a 1}} {1-2 {b 2}}"),
+	}},
+	{Suite: "unit/type/stream", Name: "XADD IDs are incremental", Steps: []Step{
+		want("XADD mystream 5-1 a 1", "5-1"),
+		wantErr("XADD mystream 5-1 b 2", "ERR*equal or smaller*"),
+	}},
+	{Suite: "unit/type/stream", Name: "XRANGE COUNT works as expected", Steps: []Step{
+		want("XADD mystream 1-1 a 1", "1-1"),
+		want("XADD mystream 1-2 b 2", "1-2"),
+		want("XRANGE mystream - + COUNT 1", "{1-1 {a 1}}"),
+		want("XREVRANGE mystream + - COUNT 1", "{1-2 {b 2}}"),
+	}},
+}
+
+var introspectionCases = []Case{
+	{Suite: "unit/introspection", Name: "CLIENT KILL with illegal arguments", Steps: []Step{
+		wantErr("CLIENT KILL", "ERR wrong number of arguments for 'client|kill' command"),
+		wantErr("CLIENT KILL id 10 wrong_arg", "ERR*syntax*"),
+		wantErr("CLIENT KILL id str", "ERR*greater than 0*"),
+		wantErr("CLIENT KILL id -1", "ERR*greater than 0*"),
+		wantErr("CLIENT KILL skipme yes_or_no", "ERR*syntax*"),
+	}},
+	{Suite: "unit/introspection", Name: "CLIENT GETNAME should return NIL if name is not assigned", Steps: []Step{
+		want("CLIENT GETNAME", ""),
+	}},
+	{Suite: "unit/introspection", Name: "CLIENT LIST shows empty fields for unassigned names", Steps: []Step{
+		wantMatch("CLIENT LIST", "*name= *"),
+	}},
+	{Suite: "unit/introspection", Name: "CLIENT SETNAME does not accept spaces", Steps: []Step{
+		wantErr("CLIENT SETNAME {foo bar}", "ERR*"),
+	}},
+	{Suite: "unit/introspection", Name: "CLIENT SETNAME can assign a name to this connection", Steps: []Step{
+		want("CLIENT SETNAME myname", "OK"),
+		wantMatch("CLIENT LIST", "*name=myname*"),
+	}},
+	{Suite: "unit/introspection", Name: "CLIENT SETNAME can change the name of an existing connection", Steps: []Step{
+		want("CLIENT SETNAME someothername", "OK"),
+		wantMatch("CLIENT LIST", "*name=someothername*"),
+		want("CLIENT SETNAME {}", "OK"),
+	}},
+	{Suite: "unit/introspection-2", Name: "COMMAND COUNT get total number of Redis commands", Steps: []Step{
+		wantRange("COMMAND COUNT", 1, 1000),
+	}},
+	{Suite: "unit/introspection-2", Name: "COMMAND GETKEYS GET", Steps: []Step{
+		want("COMMAND GETKEYS GET key", "key"),
+	}},
+	{Suite: "unit/introspection-2", Name: "COMMAND GETKEYS LCS", Steps: []Step{
+		want("COMMAND GETKEYS LCS key1 key2", "key1 key2"),
+	}},
+	{Suite: "unit/introspection-2", Name: "COMMAND GETKEYS EVAL with keys", Steps: []Step{
+		want("COMMAND GETKEYS EVAL {return 1} 1 key", "key"),
+	}},
+	{Suite: "unit/introspection-2", Name: "COMMAND GETKEYS EVAL without keys", Steps: []Step{
+		want("COMMAND GETKEYS EVAL {return 1} 0", ""),
+	}},
+	{Suite: "unit/introspection-2", Name: "COMMAND GETKEYS with the wrong number of arguments", Steps: []Step{
+		wantErr("COMMAND GETKEYS GET", "*Invalid number of arguments*"),
+		wantErr("COMMAND GETKEYS NOSUCH key", "*Invalid command*"),
+		wantErr("COMMAND GETKEYS PING", "*no key arguments*"),
+	}},
+	{Suite: "unit/introspection-2", Name: "COMMAND INFO of invalid subcommands", Steps: []Step{
+		want("COMMAND INFO get|key", "{}"),
+	}},
+	// the arity and the positions of the keys, the flags and the Redis 7 fields differ
+	{Suite: "unit/introspection-2", Name: "COMMAND INFO arity and key positions", Steps: []Step{
+		wantMatch("COMMAND INFO get", "{get 2 * 1 1 1 *}"),
+		wantMatch("COMMAND INFO hset", "{hset -4 * 1 1 1 *}"),
+		wantMatch("COMMAND INFO lpop", "{lpop -2 * 1 1 1 *}"),
+		wantMatch("COMMAND INFO mset", "{mset -3 * 1 -1 2 *}"),
+		wantMatch("COMMAND INFO blpop", "{blpop -3 * 1 -2 1 *}"),
+		wantMatch("COMMAND INFO rpoplpush", "{rpoplpush 3 * 1 2 1 *}"),
+		wantMatch("COMMAND INFO ping", "{ping -1 * 0 0 0 *}"),
+	}},
+}
+
+var otherCases = []Case{
+	{Suite: "unit/other", Name: "PING", Steps: []Step{
+		want("PING", "PONG"),
+		want("PING hello", "hello"),
+	}},
+	{Suite: "unit/other", Name: "ECHO", Steps: []Step{
+		want("ECHO hello", "hello"),
+	}},
+	{Suite: "unit/other", Name: "SELECT an out of range DB", Pending: "the error says index out of range", Steps: []Step{
+		wantErr("SELECT 1000000", "*index is out of range*"),
+		want("SELECT "+testDB, "OK"),
+	}},
+	{Suite: "unit/other", Name: "FLUSHDB", Steps: []Step{
+		want("SET x 1", "OK"),
+		want("FLUSHDB", "OK"),
+		want("EXISTS x", "0"),
+	}},
+}
@@ -0,0 +1,325 @@
+// Package compat runs a curated subset of the behavioral tests of Redis against a server and
+// reports a compatibility matrix, to find the missing semantics and prioritize them
+//
+// The cases are a Go port of tests of the Redis test suite (tests/unit/*.tcl), a case keeps the
+// name of the test it comes from and its suite is the path of the file. Like the Tcl suite, a
+// reply is compared in the form of a Tcl value: a status, an integer or a bulk string is its
+// text, a null reply is empty, and an array is a Tcl list of its elements.
+//
+// A case which redigo is known to fail is pending, with the reason, so that the matrix tells the
+// cases which fail because of a missing feature from the regressions. Run against a real Redis,
+// every case passes: that checks the cases themselves.
+package compat
+
+import (
+	"errors"
+	"fmt"
+	"redigo/interface/resp"
+	"redigo/lib/utils"
+	"redigo/lib/wildcard"
+	"redigo/resp/client"
+	"redigo/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// testDB is the DB the cases run in, flushed before each case, that of the Redis test suite
+const testDB = "9"
+
+// Case is a test of the Redis test suite, its steps are run in order on the same connection
+type Case struct {
+	Suite   string // file of the test in the Redis source tree, e.g. unit/type/list
+	Name    string // name of the test
+	Steps   []Step
+	Pending string // reason why redigo fails the case, empty if it is expected to pass
+}
+
+// Step is a command and the expected reply
+type Step struct {
+	Cmd    string // words separated by spaces, {} quotes a word holding spaces or an empty word
+	Want   string // reply in the form of a Tcl value
+	Err    string // glob-style pattern of the expected error, like assert_error
+	Match  string // glob-style pattern of the reply, like assert_match
+	Sorted bool   // the elements of the reply are sorted before the comparison, like lsort
+	Range  *[2]int64
+}
+
+// want expects the reply of the command in the form of a Tcl value
+func want(cmd string, value string) Step {
+	return Step{Cmd: cmd, Want: value}
+}
+
+// wantSorted expects the sorted elements of the reply of the command
+func wantSorted(cmd string, value string) Step {
+	return Step{Cmd: cmd, Want: value, Sorted: true}
+}
+
+// wantErr expects an error matching the glob-style pattern
+func wantErr(cmd string, pattern string) Step {
+	return Step{Cmd: cmd, Err: pattern}
+}
+
+// wantMatch expects a reply matching the glob-style pattern
+func wantMatch(cmd string, pattern string) Step {
+	return Step{Cmd: cmd, Match: pattern}
+}
+
+// wantRange expects an integer between low and high included, like assert_range
+func wantRange(cmd string, low int64, high int64) Step {
+	return Step{Cmd: cmd, Range: &[2]int64{low, high}}
+}
+
+// Status is the outcome of a case
+type Status int
+
+const (
+	Passed  Status = iota
+	Failed         // the case is expected to pass
+	Pending        // the case fails for the reason of Case.Pending
+	Fixed          // the case passes although it is pending, the reason may be removed
+)
+
+func (s Status) String() string {
+	return [...]string{"passed", "failed", "pending", "fixed"}[s]
+}
+
+// Result is the outcome of a case, with the step which failed
+type Result struct {
+	Case    *Case
+	Status  Status
+	Failure string // the failed step, the expected and the actual reply
+}
+
+// Report is the outcome of all cases
+type Report struct {
+	Results []Result
+}
+
+// Run runs the cases on a connection to the server at addr
+func Run(addr string, cases []Case) (*Report, error) {
+	cli, err := client.MakeClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	cli.Start()
+	defer cli.Close()
+	if r := cli.Send(utils.ToCmdLine("SELECT", testDB)); reply.IsErrReply(r) {
+		return nil, errors.New("SELECT " + testDB + ": " + format(r))
+	}
+	report := &Report{}
+	for i := range cases {
+		report.Results = append(report.Results, runCase(cli, &cases[i]))
+	}
+	return report, nil
+}
+
+// runCase flushes the DB and runs the steps of the case until one fails
+func runCase(cli *client.Client, c *Case) Result {
+	result := Result{Case: c, Status: Passed}
+	failure := ""
+	if r := cli.Send(utils.ToCmdLine("FLUSHDB")); reply.IsErrReply(r) {
+		failure = "FLUSHDB: " + format(r)
+	}
+	for _, step := range c.Steps {
+		if failure != "" {
+			break
+		}
+		failure = runStep(cli, step)
+	}
+	switch {
+	case failure != "" && c.Pending != "":
+		result.Status = Pending
+	case failure != "":
+		result.Status = Failed
+	case c.Pending != "":
+		result.Status = Fixed
+	}
+	result.Failure = failure
+	return result
+}
+
+// runStep sends the command of the step and returns the failure, empty if the reply is expected
+func runStep(cli *client.Client, step Step) string {
+	r := cli.Send(utils.ToCmdLine(splitWords(step.Cmd)...))
+	got := format(r)
+	if step.Sorted {
+		got = formatSorted(r)
+	}
+	isErr := r != nil && reply.IsErrReply(r)
+	switch {
+	case step.Err != "":
+		if !isErr || !wildcard.CompilePattern(step.Err).IsMatch(got) {
+			return fmt.Sprintf("%s: expected an error matching %q, got %q", step.Cmd, step.Err, got)
+		}
+	case step.Match != "":
+		if isErr || !wildcard.CompilePattern(step.Match).IsMatch(got) {
+			return fmt.Sprintf("%s: expected a reply matching %q, got %q", step.Cmd, step.Match, got)
+		}
+	case step.Range != nil:
+		n, err := strconv.ParseInt(got, 10, 64)
+		if isErr || err != nil || n < step.Range[0] || n > step.Range[1] {
+			return fmt.Sprintf("%s: expected an integer in [%d, %d], got %q", step.Cmd, step.Range[0], step.Range[1], got)
+		}
+	case isErr || got != step.Want:
+		return fmt.Sprintf("%s: expected %q, got %q", step.Cmd, step.Want, got)
+	}
+	return ""
+}
+
+// splitWords splits a command into its words, a word in braces may hold spaces or be empty
+func splitWords(cmd string) []string {
+	var words []string
+	for i := 0; i < len(cmd); {
+		switch {
+		case cmd[i] == ' ':
+			i++
+		case cmd[i] == '{':
+			end := strings.IndexByte(cmd[i:], '}')
+			words = append(words, cmd[i+1:i+end])
+			i += end + 1
+		default:
+			end := strings.IndexByte(cmd[i:], ' ')
+			if end < 0 {
+				end = len(cmd) - i
+			}
+			words = append(words, cmd[i:i+end])
+			i += end
+		}
+	}
+	return words
+}
+
+// format returns the reply in the form of a Tcl value, an error is its message
+func format(r resp.Reply) string {
+	switch r := r.(type) {
+	case nil:
+		return ""
+	case *reply.StatusReply:
+		return r.Status
+	case *reply.IntReply:
+		return strconv.FormatInt(r.Code, 10)
+	case *reply.BulkReply:
+		return string(r.Arg)
+	case *reply.MultiBulkReply:
+		elements := make([]string, len(r.Args))
+		for i, arg := range r.Args {
+			elements[i] = string(arg)
+		}
+		return tclList(elements)
+	case *reply.MultiRawReply:
+		return tclList(formatElements(r.Replies))
+	case reply.ErrorReply:
+		return r.Error()
+	}
+	// the constant replies of the parser: null bulk, empty array, null array
+	raw := string(r.ToBytes())
+	if strings.HasPrefix(raw, "$-1") || strings.HasPrefix(raw, "*-1") || strings.HasPrefix(raw, "*0") {
+		return ""
+	}
+	return strings.TrimSpace(raw)
+}
+
+func formatElements(replies []resp.Reply) []string {
+	elements := make([]string, len(replies))
+	for i, element := range replies {
+		elements[i] = format(element)
+	}
+	return elements
+}
+
+// formatSorted formats an array with its elements sorted
+func formatSorted(r resp.Reply) string {
+	var elements []string
+	switch r := r.(type) {
+	case *reply.MultiBulkReply:
+		for _, arg := range r.Args {
+			elements = append(elements, string(arg))
+		}
+	case *reply.MultiRawReply:
+		elements = formatElements(r.Replies)
+	default:
+		return format(r)
+	}
+	sort.Strings(elements)
+	return tclList(elements)
+}
+
+// tclList joins the elements in a Tcl list, an empty element or one holding spaces is braced
+func tclList(elements []string) string {
+	quoted := make([]string, len(elements))
+	for i, element := range elements {
+		if element == "" || strings.ContainsAny(element, " {}") {
+			element = "{" + element + "}"
+		}
+		quoted[i] = element
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Count returns the number of results with the status
+func (report *Report) Count(status Status) int {
+	n := 0
+	for _, result := range report.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Matrix returns a table of the results of each suite, followed by the failed, pending and fixed
+// cases with the failed step
+func (report *Report) Matrix() string {
+	type counts [4]int
+	var suites []string
+	bySuite := make(map[string]*counts)
+	for _, result := range report.Results {
+		c, ok := bySuite[result.Case.Suite]
+		if !ok {
+			c = &counts{}
+			bySuite[result.Case.Suite] = c
+			suites = append(suites, result.Case.Suite)
+		}
+		c[result.Status]++
+	}
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "suite\tpassed\tfailed\tpending\tfixed\tcompatibility")
+	total := counts{}
+	for _, suite := range suites {
+		c := bySuite[suite]
+		for i := range total {
+			total[i] += c[i]
+		}
+		writeRow(w, suite, *c)
+	}
+	writeRow(w, "total", total)
+	_ = w.Flush()
+	for _, status := range []Status{Failed, Pending, Fixed} {
+		for _, result := range report.Results {
+			if result.Status != status {
+				continue
+			}
+			sb.WriteString("\n" + status.String() + ": " + result.Case.Suite + ": " + result.Case.Name)
+			if result.Case.Pending != "" {
+				sb.WriteString(" (" + result.Case.Pending + ")")
+			}
+			if result.Failure != "" {
+				sb.WriteString("\n    " + result.Failure)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// writeRow writes the counts of a suite, the compatibility is the ratio of the passing cases
+func writeRow(w *tabwriter.Writer, suite string, c [4]int) {
+	all := c[Passed] + c[Failed] + c[Pending] + c[Fixed]
+	ratio := 0.0
+	if all > 0 {
+		ratio = 100 * float64(c[Passed]+c[Fixed]) / float64(all)
+	}
+	_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.0f%%\n", suite, c[Passed], c[Failed], c[Pending], c[Fixed], ratio)
+}
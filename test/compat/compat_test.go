@@ -0,0 +1,66 @@
+package compat
+
+import (
+	"net"
+	"os"
+	"redigo/config"
+	"redigo/resp/handler"
+	"redigo/tcp"
+	"testing"
+)
+
+// TestCompatibility runs the cases against a redigo server started in the process, or against
+// the server at REDIGO_COMPAT_ADDR, and logs the compatibility matrix
+// It fails if a case which is not pending fails
+func TestCompatibility(t *testing.T) {
+	addr := os.Getenv("REDIGO_COMPAT_ADDR")
+	if addr == "" {
+		addr = startServer(t)
+	}
+	report, err := Run(addr, Cases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("compatibility with the Redis test suite:\n" + report.Matrix())
+	for _, result := range report.Results {
+		if result.Status == Failed {
+			t.Errorf("%s: %s: %s", result.Case.Suite, result.Case.Name, result.Failure)
+		}
+	}
+}
+
+// TestSplitWords tests the quoting of the words of the commands
+func TestSplitWords(t *testing.T) {
+	words := splitWords("SET  {a b} {} x")
+	if len(words) != 4 || words[0] != "SET" || words[1] != "a b" || words[2] != "" || words[3] != "x" {
+		t.Errorf("unexpected words %q", words)
+	}
+	if got := tclList([]string{"a", "", "b c"}); got != "a {} {b c}" {
+		t.Errorf("unexpected list %s", got)
+	}
+}
+
+// startServer starts a standalone server on a free port and returns its address
+func startServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Properties.Self = ""
+	config.Properties.Peers = nil
+	config.Properties.AppendOnly = false
+	config.Properties.Dir = t.TempDir()
+	h := handler.MakeHandler()
+	closeCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		tcp.ListenAndServe(listener, h, closeCh)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(closeCh)
+		<-done
+	})
+	return listener.Addr().String()
+}